@@ -3,6 +3,7 @@ package server
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/samlau0508/imserver/pkg/okhttp"
@@ -69,6 +70,20 @@ func (ch *ChannelAPI) Route(r *okhttp.OKHttp) {
 	// 同步频道消息
 	r.POST("/channel/messagesync", ch.syncMessages)
 
+	//################### 消息保留策略 ###################
+	r.POST("/channel/retention", ch.retention)              // 获取频道生效的消息保留策略
+	r.POST("/channel/retention_set", ch.retentionSet)       // 设置频道的消息保留策略
+	r.POST("/channel/retention_remove", ch.retentionRemove) // 移除频道的消息保留策略（使用频道类型或默认的保留策略）
+
+	//################### 禁言 ###################
+	r.POST("/channel/admin_add", ch.adminAdd)                  // 添加管理员（不受全员禁言和慢速模式限制）
+	r.POST("/channel/admin_remove", ch.adminRemove)            // 移除管理员
+	r.POST("/channel/mute_set", ch.muteSet)                    // 设置全员禁言
+	r.POST("/channel/slowmode_set", ch.slowModeSet)            // 设置慢速模式
+	r.POST("/channel/member_mute_add", ch.memberMuteAdd)       // 禁言成员
+	r.POST("/channel/member_mute_remove", ch.memberMuteRemove) // 解除成员禁言
+	r.POST("/channel/moderation", ch.moderation)               // 获取频道的禁言设置
+
 }
 
 func (ch *ChannelAPI) channelCreateOrUpdate(c *okhttp.Context) {
@@ -77,6 +92,9 @@ func (ch *ChannelAPI) channelCreateOrUpdate(c *okhttp.Context) {
 		c.ResponseError(errors.Wrap(err, "数据格式有误！"))
 		return
 	}
+	if ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
 	if err := req.Check(); err != nil {
 		c.ResponseError(err)
 		return
@@ -88,7 +106,7 @@ func (ch *ChannelAPI) channelCreateOrUpdate(c *okhttp.Context) {
 	// channelInfo := okstore.NewChannelInfo(req.ChannelID, req.ChannelType)
 	channelInfo := req.ToChannelInfo()
 
-	err := ch.s.store.AddOrUpdateChannel(channelInfo)
+	err := ch.s.channelManager.AddOrUpdateChannelInfo(channelInfo)
 	if err != nil {
 		c.ResponseError(err)
 		ch.Error("创建频道失败！", zap.Error(err))
@@ -120,8 +138,11 @@ func (ch *ChannelAPI) updateOrAddChannelInfo(c *okhttp.Context) {
 		c.ResponseError(errors.New("数据格式有误！"))
 		return
 	}
+	if ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
 	channelInfo := req.ToChannelInfo()
-	err := ch.s.store.AddOrUpdateChannel(channelInfo)
+	err := ch.s.channelManager.AddOrUpdateChannelInfo(channelInfo)
 	if err != nil {
 		ch.Error("添加或更新频道信息失败！", zap.Error(err))
 		c.ResponseError(errors.New("添加或更新频道信息失败！"))
@@ -136,7 +157,7 @@ func (ch *ChannelAPI) updateOrAddChannelInfo(c *okhttp.Context) {
 }
 
 func (ch *ChannelAPI) addSubscriber(c *okhttp.Context) {
-	var req subscriberAddReq
+	var req SubscriberAddReq
 	if err := c.BindJSON(&req); err != nil {
 		ch.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(errors.Wrap(err, "数据格式有误！"))
@@ -153,6 +174,9 @@ func (ch *ChannelAPI) addSubscriber(c *okhttp.Context) {
 	if req.ChannelType == 0 {
 		req.ChannelType = okproto.ChannelTypeGroup //默认为群
 	}
+	if ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
 	channel, err := ch.s.channelManager.GetChannel(req.ChannelID, req.ChannelType)
 	if err != nil {
 		ch.Error("获取频道失败！", zap.String("channel", req.ChannelID), zap.Error(err))
@@ -182,7 +206,7 @@ func (ch *ChannelAPI) addSubscriber(c *okhttp.Context) {
 	c.ResponseOK()
 }
 
-func (ch *ChannelAPI) addTmpSubscriberWithReq(req subscriberAddReq, channel *Channel) error {
+func (ch *ChannelAPI) addTmpSubscriberWithReq(req SubscriberAddReq, channel *Channel) error {
 	if req.Reset == 1 {
 		channel.RemoveAllTmpSubscriber()
 	}
@@ -192,7 +216,7 @@ func (ch *ChannelAPI) addTmpSubscriberWithReq(req subscriberAddReq, channel *Cha
 	return nil
 }
 
-func (ch *ChannelAPI) addSubscriberWithReq(req subscriberAddReq, channel *Channel) error {
+func (ch *ChannelAPI) addSubscriberWithReq(req SubscriberAddReq, channel *Channel) error {
 	var err error
 	existSubscribers := make([]string, 0)
 	if req.Reset == 1 {
@@ -232,11 +256,14 @@ func (ch *ChannelAPI) addSubscriberWithReq(req subscriberAddReq, channel *Channe
 }
 
 func (ch *ChannelAPI) removeSubscriber(c *okhttp.Context) {
-	var req subscriberRemoveReq
+	var req SubscriberRemoveReq
 	if err := c.BindJSON(&req); err != nil {
 		c.ResponseError(errors.Wrap(err, "数据格式有误！"))
 		return
 	}
+	if ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
 	if err := req.Check(); err != nil {
 		c.ResponseError(err)
 		return
@@ -275,12 +302,16 @@ func (ch *ChannelAPI) removeSubscriber(c *okhttp.Context) {
 }
 
 func (ch *ChannelAPI) blacklistAdd(c *okhttp.Context) {
-	var req blacklistReq
+	var req BlacklistReq
 	if err := c.BindJSON(&req); err != nil {
 		ch.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(err)
 		return
 	}
+	// 个人频道的黑名单分散在各个用户的fake频道上，在本节点处理
+	if req.ChannelType != okproto.ChannelTypePerson && ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
 	if err := req.Check(); err != nil {
 		c.ResponseError(err)
 		return
@@ -329,12 +360,16 @@ func (ch *ChannelAPI) blacklistAdd(c *okhttp.Context) {
 }
 
 func (ch *ChannelAPI) blacklistSet(c *okhttp.Context) {
-	var req blacklistReq
+	var req BlacklistReq
 	if err := c.BindJSON(&req); err != nil {
 		ch.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(err)
 		return
 	}
+	// 个人频道的黑名单分散在各个用户的fake频道上，在本节点处理
+	if req.ChannelType != okproto.ChannelTypePerson && ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
 	if strings.TrimSpace(req.ChannelID) == "" {
 		c.ResponseError(errors.New("频道ID不能为空！"))
 		return
@@ -395,12 +430,16 @@ func (ch *ChannelAPI) blacklistSet(c *okhttp.Context) {
 }
 
 func (ch *ChannelAPI) blacklistRemove(c *okhttp.Context) {
-	var req blacklistReq
+	var req BlacklistReq
 	if err := c.BindJSON(&req); err != nil {
 		ch.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(err)
 		return
 	}
+	// 个人频道的黑名单分散在各个用户的fake频道上，在本节点处理
+	if req.ChannelType != okproto.ChannelTypePerson && ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
 	if err := req.Check(); err != nil {
 		c.ResponseError(err)
 		return
@@ -451,6 +490,9 @@ func (ch *ChannelAPI) channelDelete(c *okhttp.Context) {
 		c.ResponseError(errors.Wrap(err, "数据格式有误！"))
 		return
 	}
+	if ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
 
 	err := ch.s.store.DeleteChannelAndClearMessages(req.ChannelID, req.ChannelType)
 	if err != nil {
@@ -466,12 +508,15 @@ func (ch *ChannelAPI) channelDelete(c *okhttp.Context) {
 
 // 添加白名单
 func (ch *ChannelAPI) whitelistAdd(c *okhttp.Context) {
-	var req whitelistReq
+	var req WhitelistReq
 	if err := c.BindJSON(&req); err != nil {
 		ch.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(err)
 		return
 	}
+	if ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
 	if err := req.Check(); err != nil {
 		c.ResponseError(err)
 		return
@@ -493,12 +538,15 @@ func (ch *ChannelAPI) whitelistAdd(c *okhttp.Context) {
 	c.ResponseOK()
 }
 func (ch *ChannelAPI) whitelistSet(c *okhttp.Context) {
-	var req whitelistReq
+	var req WhitelistReq
 	if err := c.BindJSON(&req); err != nil {
 		ch.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(err)
 		return
 	}
+	if ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
 	if strings.TrimSpace(req.ChannelID) == "" {
 		c.ResponseError(errors.New("频道ID不能为空！"))
 		return
@@ -530,12 +578,15 @@ func (ch *ChannelAPI) whitelistSet(c *okhttp.Context) {
 
 // 移除白名单
 func (ch *ChannelAPI) whitelistRemove(c *okhttp.Context) {
-	var req whitelistReq
+	var req WhitelistReq
 	if err := c.BindJSON(&req); err != nil {
 		ch.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(err)
 		return
 	}
+	if ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
 	if err := req.Check(); err != nil {
 		c.ResponseError(err)
 		return
@@ -565,20 +616,15 @@ const (
 
 // 同步频道内的消息
 func (ch *ChannelAPI) syncMessages(c *okhttp.Context) {
-	var req struct {
-		LoginUID        string   `json:"login_uid"` // 当前登录用户的uid
-		ChannelID       string   `json:"channel_id"`
-		ChannelType     uint8    `json:"channel_type"`
-		StartMessageSeq uint32   `json:"start_message_seq"` //开始消息列号（结果包含start_message_seq的消息）
-		EndMessageSeq   uint32   `json:"end_message_seq"`   // 结束消息列号（结果不包含end_message_seq的消息）
-		Limit           int      `json:"limit"`             // 每次同步数量限制
-		PullMode        PullMode `json:"pull_mode"`         // 拉取模式 0:向下拉取 1:向上拉取
-	}
+	var req ChannelMessageSyncReq
 	if err := c.BindJSON(&req); err != nil {
 		ch.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(errors.New("数据格式有误！"))
 		return
 	}
+	if ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, req.LoginUID, req.ChannelID, req.ChannelType, req) {
+		return
+	}
 
 	var (
 		limit         = req.Limit
@@ -606,40 +652,253 @@ func (ch *ChannelAPI) syncMessages(c *okhttp.Context) {
 		c.ResponseError(err)
 		return
 	}
-	messageResps := make([]*MessageResp, 0, len(messages))
-	if len(messages) > 0 {
-		for _, message := range messages {
-			messageResp := &MessageResp{}
-			messageResp.from(message.(*Message), ch.s.store)
-			messageResps = append(messageResps, messageResp)
-		}
-	}
-	var more bool = true // 是否有更多数据
-	if len(messageResps) < limit {
+	var more bool = true // 是否有更多数据（按过滤删除的消息之前的数量判断）
+	if len(messages) < limit {
 		more = false
 	}
-	if len(messageResps) > 0 {
+	if len(messages) > 0 {
 
 		if req.PullMode == PullModeDown {
 			if req.EndMessageSeq != 0 {
-				messageSeq := messageResps[0].MessageSeq
+				messageSeq := messages[0].GetSeq()
 				if req.EndMessageSeq == messageSeq {
 					more = false
 				}
 			}
 		} else {
 			if req.EndMessageSeq != 0 {
-				messageSeq := messageResps[len(messageResps)-1].MessageSeq
+				messageSeq := messages[len(messages)-1].GetSeq()
 				if req.EndMessageSeq == messageSeq {
 					more = false
 				}
 			}
 		}
 	}
-	c.JSON(http.StatusOK, syncMessageResp{
+	messages = ch.s.messageManager.filterDeletedMessages(req.LoginUID, fakeChannelID, req.ChannelType, messages)
+	messageResps := make([]*MessageResp, 0, len(messages))
+	if len(messages) > 0 {
+		for _, message := range messages {
+			messageResp := &MessageResp{}
+			messageResp.from(message.(*Message), ch.s.store)
+			messageResps = append(messageResps, messageResp)
+		}
+		ch.s.messageManager.fillMessageExtras(fakeChannelID, req.ChannelType, messageResps)
+	}
+	c.JSON(http.StatusOK, SyncMessageResp{
 		StartMessageSeq: req.StartMessageSeq,
 		EndMessageSeq:   req.EndMessageSeq,
 		More:            okutil.BoolToInt(more),
 		Messages:        messageResps,
 	})
 }
+
+func (ch *ChannelAPI) retention(c *okhttp.Context) {
+	var req ChannelRetentionGetReq
+	if err := c.BindJSON(&req); err != nil {
+		ch.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	if ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	policy, err := ch.s.store.GetChannelRetention(req.ChannelID, req.ChannelType)
+	if err != nil {
+		ch.Error("获取频道的消息保留策略失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	if policy != nil {
+		c.JSON(http.StatusOK, ChannelRetentionResp{RetentionPolicy: *policy, Custom: true})
+		return
+	}
+	c.JSON(http.StatusOK, ChannelRetentionResp{RetentionPolicy: ch.s.storeCfg.GetRetention(req.ChannelType)})
+}
+
+func (ch *ChannelAPI) retentionSet(c *okhttp.Context) {
+	var req ChannelRetentionReq
+	if err := c.BindJSON(&req); err != nil {
+		ch.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	if ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	err := ch.s.store.SetChannelRetention(req.ChannelID, req.ChannelType, &req.RetentionPolicy)
+	if err != nil {
+		ch.Error("设置频道的消息保留策略失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseOK()
+}
+
+func (ch *ChannelAPI) retentionRemove(c *okhttp.Context) {
+	var req ChannelRetentionGetReq
+	if err := c.BindJSON(&req); err != nil {
+		ch.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	if ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	err := ch.s.store.SetChannelRetention(req.ChannelID, req.ChannelType, nil)
+	if err != nil {
+		ch.Error("移除频道的消息保留策略失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseOK()
+}
+
+// 禁言设置的请求在频道所在节点处理
+func (ch *ChannelAPI) bindModerationReq(c *okhttp.Context, req channelModerationBinder) bool {
+	if err := c.BindJSON(req); err != nil {
+		ch.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(err)
+		return false
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return false
+	}
+	channelID, channelType := req.channel()
+	if channelType == okproto.ChannelTypePerson {
+		c.ResponseError(errors.New("暂不支持个人频道！"))
+		return false
+	}
+	return !ch.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", channelID, channelType, req)
+}
+
+func (ch *ChannelAPI) updateModeration(c *okhttp.Context, channelID string, channelType uint8, modifyFnc func(moderation *okstore.ChannelModeration)) bool {
+	err := ch.s.channelManager.UpdateModeration(channelID, channelType, modifyFnc)
+	if err != nil {
+		ch.Error("修改频道的禁言设置失败！", zap.Error(err), zap.String("channelID", channelID), zap.Uint8("channelType", channelType))
+		c.ResponseError(err)
+		return false
+	}
+	c.ResponseOK()
+	return true
+}
+
+func (ch *ChannelAPI) adminAdd(c *okhttp.Context) {
+	var req ChannelAdminReq
+	if !ch.bindModerationReq(c, &req) {
+		return
+	}
+	ch.updateModeration(c, req.ChannelID, req.ChannelType, func(moderation *okstore.ChannelModeration) {
+		for _, uid := range req.UIDs {
+			if !moderation.IsAdmin(uid) {
+				moderation.Admins = append(moderation.Admins, uid)
+			}
+		}
+	})
+}
+
+func (ch *ChannelAPI) adminRemove(c *okhttp.Context) {
+	var req ChannelAdminReq
+	if !ch.bindModerationReq(c, &req) {
+		return
+	}
+	ch.updateModeration(c, req.ChannelID, req.ChannelType, func(moderation *okstore.ChannelModeration) {
+		admins := make([]string, 0, len(moderation.Admins))
+		for _, admin := range moderation.Admins {
+			if !okutil.ArrayContains(req.UIDs, admin) {
+				admins = append(admins, admin)
+			}
+		}
+		moderation.Admins = admins
+	})
+}
+
+func (ch *ChannelAPI) muteSet(c *okhttp.Context) {
+	var req ChannelMuteReq
+	if !ch.bindModerationReq(c, &req) {
+		return
+	}
+	expireAt := durationToExpireAt(req.Duration)
+	ok := ch.updateModeration(c, req.ChannelID, req.ChannelType, func(moderation *okstore.ChannelModeration) {
+		moderation.Mute = req.Mute == 1
+		moderation.MuteExpireAt = 0
+		if moderation.Mute {
+			moderation.MuteExpireAt = expireAt
+		}
+	})
+	if ok && req.Mute == 1 {
+		ch.s.channelManager.ScheduleModerationExpire(req.ChannelID, req.ChannelType, expireAt)
+	}
+}
+
+func (ch *ChannelAPI) slowModeSet(c *okhttp.Context) {
+	var req ChannelSlowModeReq
+	if !ch.bindModerationReq(c, &req) {
+		return
+	}
+	ch.updateModeration(c, req.ChannelID, req.ChannelType, func(moderation *okstore.ChannelModeration) {
+		moderation.SlowMode = req.Interval
+	})
+}
+
+func (ch *ChannelAPI) memberMuteAdd(c *okhttp.Context) {
+	var req ChannelMemberMuteReq
+	if !ch.bindModerationReq(c, &req) {
+		return
+	}
+	expireAt := durationToExpireAt(req.Duration)
+	ok := ch.updateModeration(c, req.ChannelID, req.ChannelType, func(moderation *okstore.ChannelModeration) {
+		if moderation.MemberMutes == nil {
+			moderation.MemberMutes = map[string]int64{}
+		}
+		for _, uid := range req.UIDs {
+			moderation.MemberMutes[uid] = expireAt
+		}
+	})
+	if ok {
+		ch.s.channelManager.ScheduleModerationExpire(req.ChannelID, req.ChannelType, expireAt)
+	}
+}
+
+func (ch *ChannelAPI) memberMuteRemove(c *okhttp.Context) {
+	var req ChannelMemberMuteReq
+	if !ch.bindModerationReq(c, &req) {
+		return
+	}
+	ch.updateModeration(c, req.ChannelID, req.ChannelType, func(moderation *okstore.ChannelModeration) {
+		for _, uid := range req.UIDs {
+			delete(moderation.MemberMutes, uid)
+		}
+	})
+}
+
+func (ch *ChannelAPI) moderation(c *okhttp.Context) {
+	var req ChannelModerationReq
+	if !ch.bindModerationReq(c, &req) {
+		return
+	}
+	channelInfo, err := ch.s.store.GetChannel(req.ChannelID, req.ChannelType)
+	if err != nil {
+		ch.Error("获取频道信息失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	var moderation okstore.ChannelModeration
+	if channelInfo != nil {
+		moderation = channelInfo.ChannelModeration
+		moderation.ClearExpired(time.Now().Unix())
+	}
+	c.JSON(http.StatusOK, newChannelModerationResp(moderation))
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/samlau0508/imserver/pkg/okhttp"
+	"github.com/samlau0508/imserver/pkg/oklog"
+	"github.com/samlau0508/imserver/pkg/okstore"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"go.uber.org/zap"
+)
+
+// ClusterAPI 集群节点之间通讯的api
+type ClusterAPI struct {
+	s *Server
+	oklog.Log
+}
+
+// NewClusterAPI NewClusterAPI
+func NewClusterAPI(s *Server) *ClusterAPI {
+	return &ClusterAPI{
+		s:   s,
+		Log: oklog.NewOKLog("ClusterAPI"),
+	}
+}
+
+// Route Route
+func (cl *ClusterAPI) Route(r *okhttp.OKHttp) {
+	r.GET("/cluster/nodes", cl.nodes)                         // 获取集群节点
+	r.POST("/cluster/heartbeat", cl.heartbeat)                // 节点心跳
+	r.POST("/cluster/message/send", cl.messageSend)           // api发送的消息交给频道所在节点发送
+	r.POST("/cluster/message/put", cl.messagePut)             // 消息交给频道所在节点处理
+	r.POST("/cluster/message/deliver", cl.messageDeliver)     // 消息交给订阅者所在节点投递
+	r.POST("/cluster/event/process", cl.eventProcess)         // 客户端发起的频道事件交给频道所在节点处理
+	r.POST("/cluster/event/deliver", cl.eventDeliver)         // 频道事件交给订阅者所在节点投递
+	r.POST("/cluster/channel/subscribe", cl.channelSubscribe) // 数据频道的订阅交给频道所在节点登记
+	r.POST("/cluster/apikey/put", cl.apiKeyPut)               // 同步其他节点创建的api密钥
+	r.POST("/cluster/apikey/remove", cl.apiKeyRemove)         // 同步其他节点移除的api密钥
+	r.POST("/cluster/presence/watch", cl.presenceWatch)       // 其他节点登记订阅本节点用户的在线状态
+	r.POST("/cluster/presence/notify", cl.presenceNotify)     // 用户所在节点推送在线状态变化
+}
+
+func (cl *ClusterAPI) nodes(c *okhttp.Context) {
+	nodes := cl.s.clusterManager.Nodes()
+	resps := make([]*ClusterNodeResp, 0, len(nodes))
+	for _, node := range nodes {
+		resps = append(resps, &ClusterNodeResp{
+			ClusterNode: ClusterNode{
+				NodeID:  node.NodeID,
+				APIURL:  node.APIURL,
+				TCPAddr: node.TCPAddr,
+				WSAddr:  node.WSAddr,
+				WSSAddr: node.WSSAddr,
+			},
+			Online: cl.s.clusterManager.NodeOnline(node.NodeID),
+		})
+	}
+	c.JSON(http.StatusOK, resps)
+}
+
+func (cl *ClusterAPI) heartbeat(c *okhttp.Context) {
+	if !cl.s.clusterManager.On() {
+		c.ResponseError(errors.New("没有开启分布式！"))
+		return
+	}
+	var req ClusterNode
+	if err := c.BindJSON(&req); err != nil {
+		cl.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	cl.s.clusterManager.updateNode(req.NodeID, &req)
+	c.JSON(http.StatusOK, cl.s.clusterManager.LocalNode())
+}
+
+func (cl *ClusterAPI) messageSend(c *okhttp.Context) {
+	var req clusterMessageSendReq
+	if err := c.BindJSON(&req); err != nil {
+		cl.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	messageID, messageSeq, err := cl.s.messageManager.SendMessageToChannel(req.Req, req.ChannelID, req.ChannelType, req.ClientMsgNo, okproto.StreamFlag(req.StreamFlag))
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	c.JSON(http.StatusOK, &clusterMessageSendResp{
+		MessageID:  messageID,
+		MessageSeq: messageSeq,
+	})
+}
+
+func (cl *ClusterAPI) messagePut(c *okhttp.Context) {
+	var req clusterMessagePutReq
+	if err := c.BindJSON(&req); err != nil {
+		cl.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	messages, err := decodeClusterMessages(req.Messages)
+	if err != nil {
+		cl.Error("解码消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("解码消息失败！"))
+		return
+	}
+	reasonCode, err := cl.s.dispatch.processor.putChannelMessages(req.ChannelID, req.ChannelType, req.FromUID, okproto.DeviceFlag(req.FromDeviceFlag), req.FromDeviceID, messages)
+	if err != nil {
+		cl.Error("处理转发的消息失败！", zap.Error(err))
+	}
+	messageSeqs := make([]uint32, 0, len(messages))
+	reasonCodes := make([]okproto.ReasonCode, 0, len(messages))
+	for _, m := range messages {
+		messageSeqs = append(messageSeqs, m.MessageSeq)
+		reasonCodes = append(reasonCodes, m.reasonCode)
+	}
+	c.JSON(http.StatusOK, &clusterMessagePutResp{
+		ReasonCode:  reasonCode,
+		MessageSeqs: messageSeqs,
+		ReasonCodes: reasonCodes,
+	})
+}
+
+func (cl *ClusterAPI) messageDeliver(c *okhttp.Context) {
+	var req clusterMessageDeliverReq
+	if err := c.BindJSON(&req); err != nil {
+		cl.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	messages, err := decodeClusterMessages(req.Messages)
+	if err != nil {
+		cl.Error("解码消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("解码消息失败！"))
+		return
+	}
+	for _, m := range messages {
+		m.fromDeviceFlag = okproto.DeviceFlag(req.FromDeviceFlag)
+		m.fromDeviceID = req.FromDeviceID
+		m.large = req.Large
+	}
+	// 订阅者所在节点没有频道数据，这里只用频道的基础信息来投递
+	channel := NewChannel(&okstore.ChannelInfo{
+		ChannelID:   req.ChannelID,
+		ChannelType: req.ChannelType,
+		Large:       req.Large,
+	}, cl.s)
+	err = channel.putToSubscribers(messages, req.Subscribers, req.FromUID, okproto.DeviceFlag(req.FromDeviceFlag), req.FromDeviceID)
+	if err != nil {
+		cl.Error("投递转发的消息失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseOK()
+}
+
+func (cl *ClusterAPI) eventProcess(c *okhttp.Context) {
+	var req clusterEventReq
+	if err := c.BindJSON(&req); err != nil {
+		cl.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	if req.UID == "" || req.Type == "" {
+		c.ResponseError(errors.New("uid和type不能为空！"))
+		return
+	}
+	c.JSON(http.StatusOK, &clusterEventResp{
+		ReasonCode: cl.s.dispatch.processor.processClusterEvent(&req),
+	})
+}
+
+func (cl *ClusterAPI) eventDeliver(c *okhttp.Context) {
+	var req clusterEventDeliverReq
+	if err := c.BindJSON(&req); err != nil {
+		cl.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	event, err := decodeChannelEvent(req.Type, req.Data)
+	if err != nil {
+		cl.Error("解码事件失败！", zap.Error(err), zap.String("type", req.Type))
+		c.ResponseError(errors.New("解码事件失败！"))
+		return
+	}
+	cl.s.deliveryManager.startDeliveryLocalChannelEvent(req.Subscribers, req.ChannelID, req.ChannelType, req.Type, event)
+	c.ResponseOK()
+}
+
+func (cl *ClusterAPI) channelSubscribe(c *okhttp.Context) {
+	var req clusterChannelSubscribeReq
+	if err := c.BindJSON(&req); err != nil {
+		cl.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	if req.UID == "" || req.ChannelID == "" {
+		c.ResponseError(errors.New("uid和channel_id不能为空！"))
+		return
+	}
+	if req.ChannelType != okproto.ChannelTypeData {
+		c.ResponseError(errors.New("只支持订阅数据频道！"))
+		return
+	}
+	c.JSON(http.StatusOK, &clusterChannelSubscribeResp{
+		ReasonCode: cl.s.dispatch.processor.updateLocalDataChannelSubscriber(req.UID, req.ChannelID, req.ChannelType, okproto.Action(req.Action), req.RemoveSubscriber),
+	})
+}
+
+func (cl *ClusterAPI) apiKeyPut(c *okhttp.Context) {
+	var req okstore.APIKey
+	if err := c.BindJSON(&req); err != nil {
+		cl.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	if req.ID == "" || req.KeyHash == "" {
+		c.ResponseError(errors.New("id和key_hash不能为空！"))
+		return
+	}
+	if err := cl.s.apiKeyManager.Put(&req); err != nil {
+		cl.Error("保存api密钥失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseOK()
+}
+
+func (cl *ClusterAPI) apiKeyRemove(c *okhttp.Context) {
+	var req APIKeyRemoveReq
+	if err := c.BindJSON(&req); err != nil {
+		cl.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := cl.s.apiKeyManager.Remove(req.ID, false); err != nil {
+		cl.Error("移除api密钥失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseOK()
+}
+
+func (cl *ClusterAPI) presenceWatch(c *okhttp.Context) {
+	var req clusterPresenceWatchReq
+	if err := c.BindJSON(&req); err != nil {
+		cl.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	if req.NodeID == 0 {
+		c.ResponseError(errors.New("node_id不能为空！"))
+		return
+	}
+	cl.s.presenceManager.Watch(req.NodeID, req.UIDs)
+	c.ResponseOK()
+}
+
+func (cl *ClusterAPI) presenceNotify(c *okhttp.Context) {
+	var presences []*PresenceResp
+	if err := c.BindJSON(&presences); err != nil {
+		cl.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	cl.s.presenceManager.NotifyLocal(presences)
+	c.ResponseOK()
+}
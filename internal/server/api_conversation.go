@@ -46,8 +46,11 @@ func (s *ConversationAPI) conversationsList(c *okhttp.Context) {
 		c.ResponseError(errors.New("uid cannot be empty"))
 		return
 	}
+	if s.s.clusterManager.ForwardToUserNodeIfNeed(c, uid, nil) {
+		return
+	}
 	conversations := s.s.conversationManager.GetConversations(uid, 0, nil)
-	conversationResps := make([]conversationResp, 0)
+	conversationResps := make([]ConversationResp, 0)
 	if len(conversations) > 0 {
 		for _, conversation := range conversations {
 			fakeChannelID := conversation.ChannelID
@@ -55,7 +58,7 @@ func (s *ConversationAPI) conversationsList(c *okhttp.Context) {
 				fakeChannelID = GetFakeChannelIDWith(uid, conversation.ChannelID)
 			}
 			// 获取到偏移位内的指定最大条数的最新消息
-			message, err := s.s.store.LoadMsg(fakeChannelID, conversation.ChannelType, conversation.LastMsgSeq)
+			message, err := s.s.messageManager.loadLastVisibleMessage(uid, fakeChannelID, conversation.ChannelType, conversation.LastMsgSeq)
 			if err != nil {
 				s.Error("Failed to query recent news", zap.Error(err))
 				c.ResponseError(err)
@@ -64,8 +67,9 @@ func (s *ConversationAPI) conversationsList(c *okhttp.Context) {
 			messageResp := &MessageResp{}
 			if message != nil {
 				messageResp.from(message.(*Message), s.s.store)
+				s.s.messageManager.fillMessageExtras(fakeChannelID, conversation.ChannelType, []*MessageResp{messageResp})
 			}
-			conversationResps = append(conversationResps, conversationResp{
+			conversationResps = append(conversationResps, ConversationResp{
 				ChannelID:   conversation.ChannelID,
 				ChannelType: conversation.ChannelType,
 				Unread:      conversation.UnreadCount,
@@ -79,7 +83,7 @@ func (s *ConversationAPI) conversationsList(c *okhttp.Context) {
 
 // 清楚会话未读数量
 func (s *ConversationAPI) clearConversationUnread(c *okhttp.Context) {
-	var req clearConversationUnreadReq
+	var req ClearConversationUnreadReq
 	if err := c.BindJSON(&req); err != nil {
 		c.ResponseError(err)
 		return
@@ -88,6 +92,9 @@ func (s *ConversationAPI) clearConversationUnread(c *okhttp.Context) {
 		c.ResponseError(err)
 		return
 	}
+	if s.s.clusterManager.ForwardToUserNodeIfNeed(c, req.UID, req) {
+		return
+	}
 	conversation := s.s.conversationManager.GetConversation(req.UID, req.ChannelID, req.ChannelType)
 	if conversation == nil && req.MessageSeq > 0 {
 		conversation = &okstore.Conversation{
@@ -108,13 +115,7 @@ func (s *ConversationAPI) clearConversationUnread(c *okhttp.Context) {
 }
 
 func (s *ConversationAPI) setConversationUnread(c *okhttp.Context) {
-	var req struct {
-		UID         string `json:"uid"`
-		ChannelID   string `json:"channel_id"`
-		ChannelType uint8  `json:"channel_type"`
-		Unread      int    `json:"unread"`
-		MessageSeq  uint32 `json:"message_seq"` // messageSeq 只有超大群才会传 因为超大群最近会话服务器不会维护，需要客户端传递messageSeq进行主动维护
-	}
+	var req SetConversationUnreadReq
 	if err := c.BindJSON(&req); err != nil {
 		c.ResponseError(err)
 		return
@@ -123,6 +124,9 @@ func (s *ConversationAPI) setConversationUnread(c *okhttp.Context) {
 		c.ResponseError(errors.New("UID cannot be empty"))
 		return
 	}
+	if s.s.clusterManager.ForwardToUserNodeIfNeed(c, req.UID, req) {
+		return
+	}
 	if req.ChannelID == "" || req.ChannelType == 0 {
 		c.ResponseError(errors.New("channel_id or channel_type cannot be empty"))
 		return
@@ -149,7 +153,7 @@ func (s *ConversationAPI) setConversationUnread(c *okhttp.Context) {
 }
 
 func (s *ConversationAPI) deleteConversation(c *okhttp.Context) {
-	var req deleteChannelReq
+	var req ConversationDeleteReq
 	if err := c.BindJSON(&req); err != nil {
 		s.Error("Data Format", zap.Error(err))
 		c.ResponseError(err)
@@ -159,6 +163,9 @@ func (s *ConversationAPI) deleteConversation(c *okhttp.Context) {
 		c.ResponseError(err)
 		return
 	}
+	if s.s.clusterManager.ForwardToUserNodeIfNeed(c, req.UID, req) {
+		return
+	}
 	// 删除最近会话
 	err := s.s.conversationManager.DeleteConversation([]string{req.UID}, req.ChannelID, req.ChannelType)
 	if err != nil {
@@ -170,25 +177,22 @@ func (s *ConversationAPI) deleteConversation(c *okhttp.Context) {
 }
 
 func (s *ConversationAPI) syncUserConversation(c *okhttp.Context) {
-	var req struct {
-		UID         string             `json:"uid"`
-		Version     int64              `json:"version"`       // 当前客户端的会话最大版本号(客户端最新会话的时间戳)
-		LastMsgSeqs string             `json:"last_msg_seqs"` // 客户端所有会话的最后一条消息序列号 格式： channelID:channelType:last_msg_seq|channelID:channelType:last_msg_seq
-		MsgCount    int64              `json:"msg_count"`     // 每个会话消息数量
-		Larges      []*okproto.Channel `json:"larges"`        // 超大频道集合
-	}
+	var req SyncUserConversationReq
 	if err := c.BindJSON(&req); err != nil {
 		s.Error("格式有误！", zap.Error(err))
 		c.ResponseError(err)
 		return
 	}
+	if s.s.clusterManager.ForwardToUserNodeIfNeed(c, req.UID, req) {
+		return
+	}
 	// msgCount := req.MsgCount
 	// if msgCount == 0 {
 	// 	msgCount = 100
 	// }
 
 	channelLastMsgSeqStrList := strings.Split(req.LastMsgSeqs, "|")
-	channelRecentMessageReqs := make([]*channelRecentMessageReq, 0, len(channelLastMsgSeqStrList))
+	channelRecentMessageReqs := make([]*ChannelRecentMessageReq, 0, len(channelLastMsgSeqStrList))
 	channelLastMsgMap := map[string]uint32{} // 频道对应的messageSeq
 	for _, channelLastMsgSeqStr := range channelLastMsgSeqStrList {
 		channelLastMsgSeqs := strings.Split(channelLastMsgSeqStr, ":")
@@ -254,14 +258,14 @@ func (s *ConversationAPI) syncUserConversation(c *okhttp.Context) {
 		}
 	}
 
-	resps := make([]*syncUserConversationResp, 0, len(newConversations))
+	resps := make([]*SyncUserConversationResp, 0, len(newConversations))
 	if len(newConversations) > 0 {
 		for _, conversation := range newConversations {
 			syncUserConversationR := newSyncUserConversationResp(conversation)
 			resps = append(resps, syncUserConversationR)
 
 			msgSeq := channelLastMsgMap[fmt.Sprintf("%s-%d", conversation.ChannelID, conversation.ChannelType)]
-			channelRecentMessageReqs = append(channelRecentMessageReqs, &channelRecentMessageReq{
+			channelRecentMessageReqs = append(channelRecentMessageReqs, &ChannelRecentMessageReq{
 				ChannelID:   conversation.ChannelID,
 				ChannelType: conversation.ChannelType,
 				LastMsgSeq:  msgSeq,
@@ -278,9 +282,9 @@ func (s *ConversationAPI) syncUserConversation(c *okhttp.Context) {
 		if len(channelRecentMessages) > 0 {
 			for i := 0; i < len(resps); i++ {
 				resp := resps[i]
-				for _, channelRecentMessage := range channelRecentMessages {
-					if resp.ChannelID == channelRecentMessage.ChannelID && resp.ChannelType == channelRecentMessage.ChannelType {
-						resp.Recents = channelRecentMessage.Messages
+				for _, recentMessage := range channelRecentMessages {
+					if resp.ChannelID == recentMessage.ChannelID && resp.ChannelType == recentMessage.ChannelType {
+						resp.Recents = recentMessage.Messages
 					}
 				}
 			}
@@ -290,11 +294,7 @@ func (s *ConversationAPI) syncUserConversation(c *okhttp.Context) {
 }
 
 func (s *ConversationAPI) syncRecentMessages(c *okhttp.Context) {
-	var req struct {
-		UID      string                     `json:"uid"`
-		Channels []*channelRecentMessageReq `json:"channels"`
-		MsgCount int                        `json:"msg_count"`
-	}
+	var req SyncRecentMessagesReq
 	if err := c.BindJSON(&req); err != nil {
 		s.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(errors.New("数据格式有误！"))
@@ -304,7 +304,15 @@ func (s *ConversationAPI) syncRecentMessages(c *okhttp.Context) {
 	if msgCount <= 0 {
 		msgCount = 15
 	}
-	channelRecentMessages, err := s.getRecentMessages(req.UID, req.MsgCount, req.Channels)
+	var (
+		channelRecentMessages []*ChannelRecentMessage
+		err                   error
+	)
+	if c.GetHeader(clusterForwardHeader) != "" { // 其他节点来获取的，只查本节点的频道
+		channelRecentMessages, err = s.getLocalRecentMessages(req.UID, req.MsgCount, req.Channels)
+	} else {
+		channelRecentMessages, err = s.getRecentMessages(req.UID, req.MsgCount, req.Channels)
+	}
 	if err != nil {
 		s.Error("获取最近消息失败！", zap.Error(err))
 		c.ResponseError(errors.New("获取最近消息失败！"))
@@ -313,9 +321,52 @@ func (s *ConversationAPI) syncRecentMessages(c *okhttp.Context) {
 	c.JSON(http.StatusOK, channelRecentMessages)
 }
 
-func (s *ConversationAPI) getRecentMessages(uid string, msgCount int, channels []*channelRecentMessageReq) ([]*channelRecentMessage, error) {
+func (s *ConversationAPI) getRecentMessages(uid string, msgCount int, channels []*ChannelRecentMessageReq) ([]*ChannelRecentMessage, error) {
 	fmt.Println("getRecentMessages-->", uid, msgCount, channels)
-	channelRecentMessages := make([]*channelRecentMessage, 0)
+	if !s.s.clusterManager.On() {
+		return s.getLocalRecentMessages(uid, msgCount, channels)
+	}
+	// 频道消息存储在频道所在节点，按节点分组获取
+	localChannels := make([]*ChannelRecentMessageReq, 0, len(channels))
+	remoteChannelMap := map[int64][]*ChannelRecentMessageReq{}
+	for _, channel := range channels {
+		fakeChannelID := channel.ChannelID
+		if channel.ChannelType == okproto.ChannelTypePerson {
+			fakeChannelID = GetFakeChannelIDWith(uid, channel.ChannelID)
+		}
+		nodeID, err := s.s.clusterManager.NodeIDOfChannel(fakeChannelID, channel.ChannelType)
+		if err != nil {
+			return nil, err
+		}
+		if s.s.clusterManager.IsLocal(nodeID) {
+			localChannels = append(localChannels, channel)
+			continue
+		}
+		remoteChannelMap[nodeID] = append(remoteChannelMap[nodeID], channel)
+	}
+	channelRecentMessages, err := s.getLocalRecentMessages(uid, msgCount, localChannels)
+	if err != nil {
+		return nil, err
+	}
+	for nodeID, remoteChannels := range remoteChannelMap {
+		var remoteRecentMessages []*ChannelRecentMessage
+		err = s.s.clusterManager.requestNode(nodeID, "/conversation/syncMessages", map[string]interface{}{
+			"uid":       uid,
+			"channels":  remoteChannels,
+			"msg_count": msgCount,
+		}, &remoteRecentMessages)
+		if err != nil {
+			s.Error("获取其他节点的最近消息失败！", zap.Error(err), zap.Int64("nodeID", nodeID))
+			return nil, err
+		}
+		channelRecentMessages = append(channelRecentMessages, remoteRecentMessages...)
+	}
+	return channelRecentMessages, nil
+}
+
+// 获取本节点频道的最近消息
+func (s *ConversationAPI) getLocalRecentMessages(uid string, msgCount int, channels []*ChannelRecentMessageReq) ([]*ChannelRecentMessage, error) {
+	channelRecentMessages := make([]*ChannelRecentMessage, 0)
 	if len(channels) > 0 {
 		var (
 			recentMessages []okstore.Message
@@ -331,6 +382,7 @@ func (s *ConversationAPI) getRecentMessages(uid string, msgCount int, channels [
 				s.Error("查询最近消息失败！", zap.Error(err), zap.String("uid", uid), zap.String("fakeChannelID", fakeChannelID), zap.Uint8("channelType", channel.ChannelType), zap.Uint32("LastMsgSeq", channel.LastMsgSeq))
 				return nil, err
 			}
+			recentMessages = s.s.messageManager.filterDeletedMessages(uid, fakeChannelID, channel.ChannelType, recentMessages)
 			messageResps := MessageRespSlice{}
 			if len(recentMessages) > 0 {
 				for _, recentMessage := range recentMessages {
@@ -338,9 +390,10 @@ func (s *ConversationAPI) getRecentMessages(uid string, msgCount int, channels [
 					messageResp.from(recentMessage.(*Message), s.s.store)
 					messageResps = append(messageResps, messageResp)
 				}
+				s.s.messageManager.fillMessageExtras(fakeChannelID, channel.ChannelType, messageResps)
 			}
 			sort.Sort(sort.Reverse(messageResps))
-			channelRecentMessages = append(channelRecentMessages, &channelRecentMessage{
+			channelRecentMessages = append(channelRecentMessages, &ChannelRecentMessage{
 				ChannelID:   channel.ChannelID,
 				ChannelType: channel.ChannelType,
 				Messages:    messageResps,
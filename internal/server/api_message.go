@@ -5,9 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-	"time"
 
-	"github.com/gin-gonic/gin"
 	"github.com/samlau0508/imserver/pkg/okhttp"
 	"github.com/samlau0508/imserver/pkg/oklog"
 	"github.com/samlau0508/imserver/pkg/okstore"
@@ -32,19 +30,314 @@ func NewMessageAPI(s *Server) *MessageAPI {
 
 // Route route
 func (m *MessageAPI) Route(r *okhttp.OKHttp) {
-	r.POST("/message/send", m.send)           // 发送消息
-	r.POST("/message/sendbatch", m.sendBatch) // 批量发送消息
-	r.POST("/message/sync", m.sync)           // 消息同步(写模式)
-	r.POST("/message/syncack", m.syncack)     // 消息同步回执(写模式)
+	r.POST("/message/send", m.send)                    // 发送消息
+	r.POST("/message/sendbatch", m.sendBatch)          // 批量发送消息
+	r.POST("/message/sync", m.sync)                    // 消息同步(写模式)
+	r.POST("/message/syncack", m.syncack)              // 消息同步回执(写模式)
+	r.POST("/message/revoke", m.revoke)                // 撤回消息
+	r.POST("/message/edit", m.edit)                    // 编辑消息
+	r.POST("/message/edithistory", m.editHistory)      // 消息编辑记录
+	r.POST("/message/search", m.search)                // 搜索消息
+	r.POST("/message/delete", m.delete)                // 删除消息（对所有人或只对自己）
+	r.POST("/message/delete/markers", m.deleteMarkers) // 消息删除标记
+
+	r.POST("/message/receipt", m.receipt)                // 消息回执（已读未读数量）
+	r.POST("/message/receipt/readers", m.receiptReaders) // 消息已读用户列表
+	r.POST("/message/receipt/read", m.receiptRead)       // 上报消息已读
 
 	r.POST("/streammessage/start", m.streamMessageStart) // 流消息开始
 	r.POST("/streammessage/end", m.streamMessageEnd)     // 流消息结束
 
 }
 
+// 撤回消息（api撤回不校验发送者和撤回时间）
+func (m *MessageAPI) revoke(c *okhttp.Context) {
+	var req MessageRevokeReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if m.s.clusterManager.ForwardToChannelNodeIfNeed(c, req.UID, req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	reasonCode, err := m.s.messageManager.Revoke(req, false)
+	if err != nil {
+		m.Error("撤回消息失败！", zap.Error(err), zap.String("channelID", req.ChannelID), zap.Uint8("channelType", req.ChannelType), zap.Uint32("messageSeq", req.MessageSeq))
+		c.ResponseError(err)
+		return
+	}
+	if reasonCode != okproto.ReasonSuccess {
+		c.ResponseError(errors.New(reasonCode.String()))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 编辑消息（api编辑不校验发送者）
+func (m *MessageAPI) edit(c *okhttp.Context) {
+	var req MessageEditReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if m.s.clusterManager.ForwardToChannelNodeIfNeed(c, req.UID, req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	reasonCode, err := m.s.messageManager.Edit(req, false)
+	if err != nil {
+		m.Error("编辑消息失败！", zap.Error(err), zap.String("channelID", req.ChannelID), zap.Uint8("channelType", req.ChannelType), zap.Uint32("messageSeq", req.MessageSeq))
+		c.ResponseError(err)
+		return
+	}
+	if reasonCode != okproto.ReasonSuccess {
+		c.ResponseError(errors.New(reasonCode.String()))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 删除消息（api删除可以对所有人删除）
+func (m *MessageAPI) delete(c *okhttp.Context) {
+	var req MessageDeleteReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if m.s.clusterManager.ForwardToChannelNodeIfNeed(c, req.UID, req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	reasonCode, err := m.s.messageManager.Delete(req, false)
+	if err != nil {
+		m.Error("删除消息失败！", zap.Error(err), zap.String("channelID", req.ChannelID), zap.Uint8("channelType", req.ChannelType))
+		c.ResponseError(err)
+		return
+	}
+	if reasonCode != okproto.ReasonSuccess {
+		c.ResponseError(errors.New(reasonCode.String()))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 消息删除标记
+func (m *MessageAPI) deleteMarkers(c *okhttp.Context) {
+	var req MessageDeleteMarkersReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if m.s.clusterManager.ForwardToChannelNodeIfNeed(c, req.UID, req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == okproto.ChannelTypePerson {
+		fakeChannelID = GetFakeChannelIDWith(req.UID, req.ChannelID)
+	}
+	markers, err := m.s.store.GetMessageDeleteMarkers(fakeChannelID, req.ChannelType, req.UID)
+	if err != nil {
+		m.Error("获取消息删除标记失败！", zap.Error(err), zap.String("channelID", req.ChannelID), zap.Uint8("channelType", req.ChannelType))
+		c.ResponseError(err)
+		return
+	}
+	c.JSON(http.StatusOK, markers)
+}
+
+// 消息编辑记录
+func (m *MessageAPI) editHistory(c *okhttp.Context) {
+	var req MessageEditHistoryReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if m.s.clusterManager.ForwardToChannelNodeIfNeed(c, req.UID, req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == okproto.ChannelTypePerson {
+		fakeChannelID = GetFakeChannelIDWith(req.UID, req.ChannelID)
+	}
+	edits, err := m.s.messageManager.EditHistory(fakeChannelID, req.ChannelType, req.MessageSeq)
+	if err != nil {
+		m.Error("获取消息编辑记录失败！", zap.Error(err), zap.String("channelID", req.ChannelID), zap.Uint8("channelType", req.ChannelType), zap.Uint32("messageSeq", req.MessageSeq))
+		c.ResponseError(err)
+		return
+	}
+	resps := make([]*MessageEditResp, 0, len(edits))
+	for _, edit := range edits {
+		resps = append(resps, newMessageEditResp(edit))
+	}
+	c.JSON(http.StatusOK, resps)
+}
+
+// 搜索消息（指定频道则按消息序号分页搜索频道内的消息，否则搜索uid所有最近会话频道的消息）
+func (m *MessageAPI) search(c *okhttp.Context) {
+	var req MessageSearchReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if !m.s.searchManager.On() {
+		c.ResponseError(errors.New("消息搜索未开启！"))
+		return
+	}
+	if req.ChannelID != "" {
+		if m.s.clusterManager.ForwardToChannelNodeIfNeed(c, req.UID, req.ChannelID, req.ChannelType, req) {
+			return
+		}
+		results, err := m.s.searchManager.SearchLocal(req.UID, []*MessageSearchChannel{{ChannelID: req.ChannelID, ChannelType: req.ChannelType}}, req.Keyword, req.StartMessageSeq, req.Limit)
+		if err != nil {
+			c.ResponseError(err)
+			return
+		}
+		if len(results) == 0 {
+			c.JSON(http.StatusOK, &MessageSearchResult{ChannelID: req.ChannelID, ChannelType: req.ChannelType, Messages: make([]*MessageResp, 0)})
+			return
+		}
+		c.JSON(http.StatusOK, results[0])
+		return
+	}
+	if c.GetHeader(clusterForwardHeader) != "" && len(req.Channels) > 0 { // 其他节点来搜索的，只搜本节点的频道
+		results, err := m.s.searchManager.SearchLocal(req.UID, req.Channels, req.Keyword, 0, req.Limit)
+		if err != nil {
+			c.ResponseError(err)
+			return
+		}
+		c.JSON(http.StatusOK, results)
+		return
+	}
+	// 最近会话在用户所在节点
+	if m.s.clusterManager.ForwardToUserNodeIfNeed(c, req.UID, req) {
+		return
+	}
+	conversations := m.s.conversationManager.GetConversations(req.UID, 0, nil)
+	channels := make([]*MessageSearchChannel, 0, len(conversations))
+	for _, conversation := range conversations {
+		channels = append(channels, &MessageSearchChannel{ChannelID: conversation.ChannelID, ChannelType: conversation.ChannelType})
+	}
+	results, err := m.s.searchManager.Search(req.UID, channels, req.Keyword, req.Limit)
+	if err != nil {
+		m.Error("搜索消息失败！", zap.Error(err), zap.String("uid", req.UID))
+		c.ResponseError(err)
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// 消息回执（已读未读数量）
+func (m *MessageAPI) receipt(c *okhttp.Context) {
+	var req MessageReceiptReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if m.s.clusterManager.ForwardToChannelNodeIfNeed(c, req.UID, req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == okproto.ChannelTypePerson {
+		fakeChannelID = GetFakeChannelIDWith(req.UID, req.ChannelID)
+	}
+	receipts, err := m.s.messageManager.Receipts(fakeChannelID, req.ChannelType, req.MessageSeqs)
+	if err != nil {
+		m.Error("获取消息回执失败！", zap.Error(err), zap.String("channelID", req.ChannelID), zap.Uint8("channelType", req.ChannelType))
+		c.ResponseError(err)
+		return
+	}
+	c.JSON(http.StatusOK, receipts)
+}
+
+// 消息已读用户列表
+func (m *MessageAPI) receiptReaders(c *okhttp.Context) {
+	var req MessageReadersReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if m.s.clusterManager.ForwardToChannelNodeIfNeed(c, req.UID, req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == okproto.ChannelTypePerson {
+		fakeChannelID = GetFakeChannelIDWith(req.UID, req.ChannelID)
+	}
+	readers, err := m.s.messageManager.Readers(fakeChannelID, req.ChannelType, req.MessageSeq)
+	if err != nil {
+		m.Error("获取消息已读用户失败！", zap.Error(err), zap.String("channelID", req.ChannelID), zap.Uint8("channelType", req.ChannelType), zap.Uint32("messageSeq", req.MessageSeq))
+		c.ResponseError(err)
+		return
+	}
+	resps := make([]*MessageReaderResp, 0, len(readers))
+	for _, reader := range readers {
+		resps = append(resps, &MessageReaderResp{
+			UID:      reader.UID,
+			ReadedAt: reader.ReadedAt,
+		})
+	}
+	c.JSON(http.StatusOK, resps)
+}
+
+// 上报消息已读
+func (m *MessageAPI) receiptRead(c *okhttp.Context) {
+	var req MessageReadReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if m.s.clusterManager.ForwardToChannelNodeIfNeed(c, req.UID, req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	reasonCode, err := m.s.messageManager.Read(req)
+	if err != nil {
+		m.Error("处理消息已读失败！", zap.Error(err), zap.String("uid", req.UID), zap.String("channelID", req.ChannelID), zap.Uint8("channelType", req.ChannelType))
+		c.ResponseError(err)
+		return
+	}
+	if reasonCode != okproto.ReasonSuccess {
+		c.ResponseError(errors.New(reasonCode.String()))
+		return
+	}
+	c.ResponseOK()
+}
+
 // 消息同步
 func (m *MessageAPI) sync(c *okhttp.Context) {
-	var req syncReq
+	var req SyncReq
 	if err := c.BindJSON(&req); err != nil {
 		c.ResponseError(err)
 		return
@@ -53,6 +346,9 @@ func (m *MessageAPI) sync(c *okhttp.Context) {
 		c.ResponseError(err)
 		return
 	}
+	if m.s.clusterManager.ForwardToUserNodeIfNeed(c, req.UID, req) {
+		return
+	}
 	readedMessageSeq, err := m.s.store.GetMessageOfUserCursor(req.UID) // 获取当前用户已读消息seq
 	if err != nil {
 		c.ResponseError(err)
@@ -68,11 +364,20 @@ func (m *MessageAPI) sync(c *okhttp.Context) {
 	}
 	sartSeq = sartSeq + 1 // 从已读消息的下一条开始同步
 
-	messages, err := m.s.store.SyncMessageOfUser(req.UID, sartSeq, req.Limit)
-	if err != nil {
-		m.Error("同步消息失败！", zap.Error(err))
-		c.ResponseError(err)
-		return
+	var messages []okstore.Message
+	for {
+		queueMessages, err := m.s.store.SyncMessageOfUser(req.UID, sartSeq, req.Limit)
+		if err != nil {
+			m.Error("同步消息失败！", zap.Error(err))
+			c.ResponseError(err)
+			return
+		}
+		messages = m.s.messageManager.filterDeletedMessagesOfUser(req.UID, queueMessages)
+		// 一批消息都被删除了则继续同步下一批，否则客户端会以为已经同步完了
+		if len(messages) > 0 || req.Limit <= 0 || len(queueMessages) < req.Limit {
+			break
+		}
+		sartSeq = queueMessages[len(queueMessages)-1].GetSeq() + 1
 	}
 	resps := make([]*MessageResp, 0, len(messages))
 	if len(messages) > 0 {
@@ -87,7 +392,7 @@ func (m *MessageAPI) sync(c *okhttp.Context) {
 
 // 同步回执
 func (m *MessageAPI) syncack(c *okhttp.Context) {
-	var req syncackReq
+	var req SyncackReq
 	if err := c.BindJSON(&req); err != nil {
 		m.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(errors.New("数据格式有误！"))
@@ -97,6 +402,9 @@ func (m *MessageAPI) syncack(c *okhttp.Context) {
 		c.ResponseError(err)
 		return
 	}
+	if m.s.clusterManager.ForwardToUserNodeIfNeed(c, req.UID, req) {
+		return
+	}
 	err := m.s.store.UpdateMessageOfUserCursorIfNeed(req.UID, req.LastMessageSeq)
 	if err != nil {
 		c.ResponseError(err)
@@ -108,12 +416,7 @@ func (m *MessageAPI) syncack(c *okhttp.Context) {
 
 // TODO: 这个批量接口比较慢 需要优化
 func (m *MessageAPI) sendBatch(c *okhttp.Context) {
-	var req struct {
-		Header      MessageHeader `json:"header"`      // 消息头
-		FromUID     string        `json:"from_uid"`    // 发送者UID
-		Subscribers []string      `json:"subscribers"` // 订阅者 如果此字段有值，表示消息只发给指定的订阅者
-		Payload     []byte        `json:"payload"`     // 消息内容
-	}
+	var req MessageSendBatchReq
 	if err := c.BindJSON(&req); err != nil {
 		m.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(err)
@@ -135,7 +438,7 @@ func (m *MessageAPI) sendBatch(c *okhttp.Context) {
 	reasons := make([]string, 0)
 	for _, subscriber := range req.Subscribers {
 		clientMsgNo := fmt.Sprintf("%s0", okutil.GenUUID())
-		_, _, err := m.sendMessageToChannel(MessageSendReq{
+		_, _, err := m.s.messageManager.SendMessageToChannel(MessageSendReq{
 			Header:      req.Header,
 			FromUID:     req.FromUID,
 			ChannelID:   subscriber,
@@ -147,9 +450,9 @@ func (m *MessageAPI) sendBatch(c *okhttp.Context) {
 			reasons = append(reasons, err.Error())
 		}
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"fail_uids": failUids,
-		"reason":    reasons,
+	c.JSON(http.StatusOK, MessageSendBatchResp{
+		FailUIDs: failUids,
+		Reason:   reasons,
 	})
 }
 
@@ -184,132 +487,29 @@ func (m *MessageAPI) send(c *okhttp.Context) {
 	}
 
 	// 发送消息
-	messageID, messageSeq, err := m.sendMessageToChannel(req, channelID, channelType, clientMsgNo, okproto.StreamFlagIng)
+	messageID, messageSeq, err := m.s.messageManager.SendMessageToChannel(req, channelID, channelType, clientMsgNo, okproto.StreamFlagIng)
 	if err != nil {
 		c.ResponseError(err)
 		return
 	}
-	c.ResponseOKWithData(map[string]interface{}{
-		"message_id":    messageID,
-		"client_msg_no": clientMsgNo,
-		"message_seq":   messageSeq,
+	c.ResponseOKWithData(MessageSendResp{
+		MessageID:   messageID,
+		ClientMsgNo: clientMsgNo,
+		MessageSeq:  messageSeq,
 	})
 }
 
-func (m *MessageAPI) sendMessageToChannel(req MessageSendReq, channelID string, channelType uint8, clientMsgNo string, streamFlag okproto.StreamFlag) (int64, uint32, error) {
-
-	m.s.monitor.SendPacketInc(req.Header.NoPersist != 1)
-	m.s.monitor.SendSystemMsgInc()
-
-	var messageID = m.s.dispatch.processor.genMessageID()
-
-	fakeChannelID := channelID
-	if channelType == okproto.ChannelTypePerson && req.FromUID != "" {
-		fakeChannelID = GetFakeChannelIDWith(req.FromUID, channelID)
-	}
-
-	// 获取频道
-	channel, err := m.s.channelManager.GetChannel(fakeChannelID, channelType)
-	if err != nil {
-		m.Error("查询频道信息失败！", zap.Error(err))
-		return 0, 0, errors.New("查询频道信息失败！")
-	}
-
-	if channel == nil {
-		return 0, 0, errors.New("频道信息不存在！")
-	}
-	if channel.Large && req.Header.SyncOnce == 1 {
-		m.Error("超大群不支持发送SyncOnce类型消息！", zap.String("channelID", channelID), zap.Uint8("channelType", channelType))
-		return 0, 0, errors.New("超大群不支持发送SyncOnce类型消息！")
-	}
-
-	// var messageSeq uint32
-	// if req.Header.NoPersist == 0 && req.Header.SyncOnce != 1 {
-	// 	messageSeq, err = m.l.store.GetNextMessageSeq(fakeChannelID, channelType)
-	// 	if err != nil {
-	// 		m.Error("获取频道消息序列号失败！", zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channelType), zap.Error(err))
-	// 		return errors.New("获取频道消息序列号失败！")
-	// 	}
-	// }
-	subscribers := req.Subscribers
-	if len(subscribers) > 0 {
-		subscribers = okutil.RemoveRepeatedElement(req.Subscribers)
-	}
-	var setting okproto.Setting
-	if len(strings.TrimSpace(req.StreamNo)) > 0 {
-		setting = setting.Set(okproto.SettingStream)
-	}
-
-	msg := &Message{
-		RecvPacket: &okproto.RecvPacket{
-			Framer: okproto.Framer{
-				RedDot:    okutil.IntToBool(req.Header.RedDot),
-				SyncOnce:  okutil.IntToBool(req.Header.SyncOnce),
-				NoPersist: okutil.IntToBool(req.Header.NoPersist),
-			},
-			Setting:     setting,
-			MessageID:   messageID,
-			ClientMsgNo: clientMsgNo,
-			StreamNo:    req.StreamNo,
-			StreamFlag:  streamFlag,
-			FromUID:     req.FromUID,
-			ChannelID:   channelID,
-			ChannelType: channelType,
-			Expire:      req.Expire,
-			Timestamp:   int32(time.Now().Unix()),
-			Payload:     req.Payload,
-		},
-		fromDeviceFlag: okproto.SYSTEM,
-		Subscribers:    subscribers,
-	}
-	messages := []okstore.Message{msg}
-	if !msg.NoPersist && !msg.SyncOnce && !m.s.opts.IsTmpChannel(channelID) {
-
-		if msg.StreamIng() {
-			streamSeq, err := m.s.store.AppendStreamItem(fakeChannelID, channelType, msg.StreamNo, &okstore.StreamItem{
-				ClientMsgNo: msg.ClientMsgNo,
-				Blob:        msg.Payload,
-			})
-			if err != nil {
-				m.Error("Failed to save stream message", zap.Error(err))
-				return 0, 0, errors.New("failed to save stream message")
-			}
-			msg.StreamSeq = streamSeq // stream seq
-		} else {
-			_, err = m.s.store.AppendMessages(fakeChannelID, channelType, messages)
-			if err != nil {
-				m.Error("Failed to save history message", zap.Error(err))
-				return 0, 0, errors.New("failed to save history message")
-			}
-		}
-
-	}
-	if m.s.opts.WebhookOn() {
-		if !msg.StreamIng() && (!msg.NoPersist || !msg.SyncOnce) {
-			// Add a message to the notification queue, the data in this queue will be notified to third-party applications
-			err = m.s.store.AppendMessageOfNotifyQueue(messages)
-			if err != nil {
-				m.Error("添加消息到通知队列失败！", zap.Error(err))
-				return 0, 0, errors.New("添加消息到通知队列失败！")
-			}
-		}
-	}
-	// 将消息放入频道
-	err = channel.Put([]*Message{msg}, msg.Subscribers, req.FromUID, okproto.DeviceFlag(okproto.DeviceLevelMaster), "system")
-	if err != nil {
-		m.Error("将消息放入频道内失败！", zap.Error(err))
-		return 0, 0, errors.New("将消息放入频道内失败！")
-	}
-	return messageID, msg.MessageSeq, nil
-}
-
 func (m *MessageAPI) streamMessageStart(c *okhttp.Context) {
-	var req messageStreamStartReq
+	var req MessageStreamStartReq
 	if err := c.BindJSON(&req); err != nil {
 		m.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(err)
 		return
 	}
+	// 流消息元数据按频道ID存储，个人频道的流消息在本节点处理
+	if req.ChannelType != okproto.ChannelTypePerson && m.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
 
 	channelID := req.ChannelID
 	channelType := req.ChannelType
@@ -329,7 +529,7 @@ func (m *MessageAPI) streamMessageStart(c *okhttp.Context) {
 	streamNo := okutil.GenUUID()
 	streamFlag := okproto.StreamFlagStart
 
-	messageID, messageSeq, err := m.sendMessageToChannel(MessageSendReq{
+	messageID, messageSeq, err := m.s.messageManager.SendMessageToChannel(MessageSendReq{
 		Header:      req.Header,
 		ClientMsgNo: clientMsgNo,
 		StreamNo:    streamNo,
@@ -358,20 +558,24 @@ func (m *MessageAPI) streamMessageStart(c *okhttp.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"stream_no": streamNo,
+	c.JSON(http.StatusOK, MessageStreamStartResp{
+		StreamNo: streamNo,
 	})
 
 }
 
 func (m *MessageAPI) streamMessageEnd(c *okhttp.Context) {
 
-	var req messageStreamEndReq
+	var req MessageStreamEndReq
 	if err := c.BindJSON(&req); err != nil {
 		m.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(err)
 		return
 	}
+	// 流消息元数据按频道ID存储，个人频道的流消息在本节点处理
+	if req.ChannelType != okproto.ChannelTypePerson && m.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
 	streamMeta, err := m.s.store.GetStreamMeta(req.ChannelID, req.ChannelType, req.StreamNo)
 	if err != nil {
 		m.Error("获取流消息元数据失败！", zap.Error(err))
@@ -17,6 +17,14 @@ import (
 	"go.uber.org/zap"
 )
 
+// 监控频道的前缀
+const defaultMonitorChannelPrefix = "__monitor"
+
+// 是否是监控频道（监控数据只投递给本节点的订阅者）
+func isMonitorChannel(channelID string) bool {
+	return strings.HasPrefix(channelID, defaultMonitorChannelPrefix+"_")
+}
+
 type MonitorAPI struct {
 	oklog.Log
 	s                    *Server
@@ -28,7 +36,7 @@ func NewMonitorAPI(s *Server) *MonitorAPI {
 	return &MonitorAPI{
 		Log:                  oklog.NewOKLog("MonitorAPI"),
 		s:                    s,
-		monitorChannelPrefix: "__monitor",
+		monitorChannelPrefix: defaultMonitorChannelPrefix,
 	}
 }
 
@@ -372,7 +380,7 @@ func (m *MonitorAPI) conversations(c *okhttp.Context) {
 		return
 	}
 
-	conversationResults := make([]*syncUserConversationResp, 0)
+	conversationResults := make([]*SyncUserConversationResp, 0)
 	if m.s.opts.Conversation.On {
 		conversations := m.s.conversationManager.GetConversations(uid, 0, nil)
 		if len(conversations) > 0 {
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/samlau0508/imserver/pkg/okhttp"
+	"github.com/samlau0508/imserver/pkg/oklog"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"go.uber.org/zap"
+)
+
+// PinAPI 置顶消息
+type PinAPI struct {
+	s *Server
+	oklog.Log
+}
+
+// NewPinAPI NewPinAPI
+func NewPinAPI(s *Server) *PinAPI {
+	return &PinAPI{
+		s:   s,
+		Log: oklog.NewOKLog("PinApi"),
+	}
+}
+
+// Route route
+func (p *PinAPI) Route(r *okhttp.OKHttp) {
+	r.POST("/message/pin", p.pin)     // 置顶消息
+	r.POST("/message/unpin", p.unpin) // 取消置顶消息
+	r.POST("/message/pins", p.pins)   // 同步频道的置顶消息
+}
+
+func (p *PinAPI) pin(c *okhttp.Context) {
+	p.handlePin(c, false)
+}
+
+func (p *PinAPI) unpin(c *okhttp.Context) {
+	p.handlePin(c, true)
+}
+
+func (p *PinAPI) handlePin(c *okhttp.Context, unpin bool) {
+	var req MessagePinReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if p.s.clusterManager.ForwardToChannelNodeIfNeed(c, req.UID, req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	reasonCode, err := p.s.messageManager.Pin(req, unpin)
+	if err != nil {
+		p.Error("置顶消息失败！", zap.Error(err), zap.String("uid", req.UID), zap.String("channelID", req.ChannelID), zap.Uint8("channelType", req.ChannelType), zap.Uint32("messageSeq", req.MessageSeq))
+		c.ResponseError(err)
+		return
+	}
+	if reasonCode != okproto.ReasonSuccess {
+		c.ResponseError(errors.New(reasonCode.String()))
+		return
+	}
+	c.ResponseOK()
+}
+
+func (p *PinAPI) pins(c *okhttp.Context) {
+	var req MessagePinSyncReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if p.s.clusterManager.ForwardToChannelNodeIfNeed(c, req.LoginUID, req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == okproto.ChannelTypePerson {
+		fakeChannelID = GetFakeChannelIDWith(req.LoginUID, req.ChannelID)
+	}
+	resp, err := p.s.messageManager.Pins(fakeChannelID, req.ChannelType, req.Version)
+	if err != nil {
+		p.Error("获取频道置顶消息失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		c.ResponseError(err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/samlau0508/imserver/pkg/okhttp"
+	"github.com/samlau0508/imserver/pkg/oklog"
+	"github.com/samlau0508/imserver/pkg/okutil"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"go.uber.org/zap"
+)
+
+// ReactionAPI 消息回应
+type ReactionAPI struct {
+	s *Server
+	oklog.Log
+}
+
+// NewReactionAPI NewReactionAPI
+func NewReactionAPI(s *Server) *ReactionAPI {
+	return &ReactionAPI{
+		s:   s,
+		Log: oklog.NewOKLog("ReactionApi"),
+	}
+}
+
+// Route route
+func (r *ReactionAPI) Route(rt *okhttp.OKHttp) {
+	rt.POST("/reaction/add", r.add)       // 添加消息回应
+	rt.POST("/reaction/remove", r.remove) // 移除消息回应
+	rt.POST("/reaction/sync", r.sync)     // 增量同步频道的消息回应
+}
+
+func (r *ReactionAPI) add(c *okhttp.Context) {
+	r.react(c, false)
+}
+
+func (r *ReactionAPI) remove(c *okhttp.Context) {
+	r.react(c, true)
+}
+
+func (r *ReactionAPI) react(c *okhttp.Context, remove bool) {
+	var req ReactionReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if r.s.clusterManager.ForwardToChannelNodeIfNeed(c, req.UID, req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	reasonCode, err := r.s.messageManager.React(req, remove)
+	if err != nil {
+		r.Error("处理消息回应失败！", zap.Error(err), zap.String("uid", req.UID), zap.String("channelID", req.ChannelID), zap.Uint8("channelType", req.ChannelType), zap.Int64("messageID", req.MessageID))
+		c.ResponseError(err)
+		return
+	}
+	if reasonCode != okproto.ReasonSuccess {
+		c.ResponseError(errors.New(reasonCode.String()))
+		return
+	}
+	c.ResponseOK()
+}
+
+func (r *ReactionAPI) sync(c *okhttp.Context) {
+	var req ReactionSyncReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if r.s.clusterManager.ForwardToChannelNodeIfNeed(c, req.LoginUID, req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == okproto.ChannelTypePerson {
+		fakeChannelID = GetFakeChannelIDWith(req.LoginUID, req.ChannelID)
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+	// 多查一条判断是否还有更多
+	reactions, err := r.s.messageManager.SyncReactions(fakeChannelID, req.ChannelType, req.Seq, limit+1)
+	if err != nil {
+		r.Error("同步消息回应失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		c.ResponseError(err)
+		return
+	}
+	more := len(reactions) > limit
+	if more {
+		reactions = reactions[:limit]
+	}
+	resps := make([]*ReactionResp, 0, len(reactions))
+	for _, reaction := range reactions {
+		resps = append(resps, newReactionResp(req.ChannelID, req.ChannelType, reaction))
+	}
+	c.JSON(http.StatusOK, &ReactionSyncResp{
+		More:      okutil.BoolToInt(more),
+		Reactions: resps,
+	})
+}
@@ -2,15 +2,15 @@ package server
 
 import (
 	"net/http"
+	"strings"
 
-	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 	"github.com/samlau0508/imserver/pkg/okhttp"
 	"github.com/samlau0508/imserver/pkg/oklog"
 	"go.uber.org/zap"
 )
 
-// 这个主要为了模拟proxy模式。
+// RouteAPI 获取用户所在节点的连接地址（开启分布式后按用户所在节点返回）
 type RouteAPI struct {
 	s *Server
 	oklog.Log
@@ -19,7 +19,8 @@ type RouteAPI struct {
 // NewRouteAPI NewRouteAPI
 func NewRouteAPI(s *Server) *RouteAPI {
 	return &RouteAPI{
-		s: s,
+		s:   s,
+		Log: oklog.NewOKLog("RouteAPI"),
 	}
 }
 
@@ -31,10 +32,17 @@ func (a *RouteAPI) Route(r *okhttp.OKHttp) {
 
 // 路由用户的IM连接地址
 func (a *RouteAPI) routeUserIMAddr(c *okhttp.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"tcp_addr": a.s.opts.External.TCPAddr,
-		"ws_addr":  a.s.opts.External.WSAddr,
-		"wss_addr": a.s.opts.External.WSSAddr,
+	uid := c.Query("uid")
+	node, err := a.nodeOfUser(uid)
+	if err != nil {
+		a.Error("获取用户所在节点失败！", zap.Error(err), zap.String("uid", uid))
+		c.ResponseError(err)
+		return
+	}
+	c.JSON(http.StatusOK, IMAddrResp{
+		TCPAddr: node.TCPAddr,
+		WSAddr:  node.WSAddr,
+		WSSAddr: node.WSSAddr,
 	})
 }
 
@@ -47,17 +55,72 @@ func (a *RouteAPI) routeUserIMAddrOfBatch(c *okhttp.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, []userAddrResp{
-		{
-			UIDs:    uids,
-			TCPAddr: a.s.opts.External.TCPAddr,
-			WSAddr:  a.s.opts.External.WSAddr,
-			WSSAddr: a.s.opts.External.WSSAddr,
-		},
-	})
+	nodeUIDMap := map[int64][]string{}
+	nodeIDs := make([]int64, 0)
+	for _, uid := range uids {
+		nodeID, err := a.s.clusterManager.NodeIDOfUser(uid)
+		if err != nil {
+			a.Error("获取用户所在节点失败！", zap.Error(err), zap.String("uid", uid))
+			c.ResponseError(err)
+			return
+		}
+		if _, ok := nodeUIDMap[nodeID]; !ok {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+		nodeUIDMap[nodeID] = append(nodeUIDMap[nodeID], uid)
+	}
+
+	resps := make([]UserAddrResp, 0, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		node, err := a.node(nodeID)
+		if err != nil {
+			c.ResponseError(err)
+			return
+		}
+		resps = append(resps, UserAddrResp{
+			UIDs:    nodeUIDMap[nodeID],
+			TCPAddr: node.TCPAddr,
+			WSAddr:  node.WSAddr,
+			WSSAddr: node.WSSAddr,
+		})
+	}
+	c.JSON(http.StatusOK, resps)
+}
+
+// 获取用户所在的节点（没有指定用户或没有开启分布式则返回当前节点）
+func (a *RouteAPI) nodeOfUser(uid string) (*ClusterNode, error) {
+	if strings.TrimSpace(uid) == "" || !a.s.clusterManager.On() {
+		return a.s.clusterManager.LocalNode(), nil
+	}
+	nodeID, err := a.s.clusterManager.NodeIDOfUser(uid)
+	if err != nil {
+		return nil, err
+	}
+	return a.node(nodeID)
+}
+
+func (a *RouteAPI) node(nodeID int64) (*ClusterNode, error) {
+	if a.s.clusterManager.IsLocal(nodeID) {
+		return a.s.clusterManager.LocalNode(), nil
+	}
+	node := a.s.clusterManager.Node(nodeID)
+	if node == nil {
+		return nil, ErrClusterNodeUnknown
+	}
+	if !a.s.clusterManager.NodeOnline(nodeID) {
+		return nil, errors.New("用户所在节点不在线！")
+	}
+	return node, nil
+}
+
+// IMAddrResp 用户所在节点的连接地址
+type IMAddrResp struct {
+	TCPAddr string `json:"tcp_addr"`
+	WSAddr  string `json:"ws_addr"`
+	WSSAddr string `json:"wss_addr"`
 }
 
-type userAddrResp struct {
+type UserAddrResp struct {
 	TCPAddr string   `json:"tcp_addr"`
 	WSAddr  string   `json:"ws_addr"`
 	WSSAddr string   `json:"wss_addr"`
@@ -6,6 +6,7 @@ import (
 
 	"github.com/samlau0508/imserver/pkg/okhttp"
 	"github.com/samlau0508/imserver/pkg/oklog"
+	"github.com/samlau0508/imserver/pkg/okstore"
 	"go.uber.org/zap"
 )
 
@@ -27,12 +28,14 @@ func (s *SystemAPI) Route(r *okhttp.OKHttp) {
 	r.POST("/system/ip/blacklist_add", s.ipBlacklistAdd)       // 添加ip黑名单
 	r.POST("/system/ip/blacklist_remove", s.ipBlacklistRemove) // 移除ip白名单
 	r.GET("/system/ip/blacklist", s.ipBlacklist)               // 获取ip黑名单列表
+	r.POST("/system/store/compact", s.storeCompact)            // 按保留策略压缩消息（不传channel_id则压缩当前节点的所有频道）
+	r.POST("/system/apikey_create", s.apiKeyCreate)            // 创建api密钥（密钥明文只在创建时返回）
+	r.POST("/system/apikey_remove", s.apiKeyRemove)            // 移除api密钥
+	r.GET("/system/apikeys", s.apiKeys)                        // 获取api密钥列表
 }
 
 func (s *SystemAPI) ipBlacklistAdd(c *okhttp.Context) {
-	var req struct {
-		IPs []string `json:"ips"`
-	}
+	var req IPBlacklistReq
 	if err := c.BindJSON(&req); err != nil {
 		s.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(err)
@@ -54,9 +57,7 @@ func (s *SystemAPI) ipBlacklistAdd(c *okhttp.Context) {
 }
 
 func (s *SystemAPI) ipBlacklistRemove(c *okhttp.Context) {
-	var req struct {
-		IPs []string `json:"ips"`
-	}
+	var req IPBlacklistReq
 	if err := c.BindJSON(&req); err != nil {
 		s.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(err)
@@ -86,3 +87,86 @@ func (s *SystemAPI) ipBlacklist(c *okhttp.Context) {
 	}
 	c.JSON(http.StatusOK, ips)
 }
+
+func (s *SystemAPI) storeCompact(c *okhttp.Context) {
+	var req StoreCompactReq
+	if err := c.BindJSON(&req); err != nil {
+		s.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	var (
+		result *okstore.CompactResult
+		err    error
+	)
+	if req.ChannelID != "" {
+		if s.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+			return
+		}
+		result, err = s.s.store.CompactChannel(req.ChannelID, req.ChannelType)
+	} else {
+		result, err = s.s.store.Compact()
+	}
+	if err != nil {
+		s.Error("压缩消息失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	s.Info("压缩消息", zap.String("channelID", req.ChannelID), zap.Uint8("channelType", req.ChannelType), zap.Int("segments", result.Segments), zap.Int("messages", result.Messages), zap.Int64("bytes", result.Bytes))
+	c.JSON(http.StatusOK, result)
+}
+
+func (s *SystemAPI) apiKeyCreate(c *okhttp.Context) {
+	var req APIKeyCreateReq
+	if err := c.BindJSON(&req); err != nil {
+		s.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	key, apiKey, err := s.s.apiKeyManager.Create(req.Name, req.Scopes)
+	if err != nil {
+		s.Error("创建api密钥失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	resp := newAPIKeyResp(apiKey)
+	resp.Key = key
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *SystemAPI) apiKeyRemove(c *okhttp.Context) {
+	var req APIKeyRemoveReq
+	if err := c.BindJSON(&req); err != nil {
+		s.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := s.s.apiKeyManager.Remove(req.ID, true); err != nil {
+		s.Error("移除api密钥失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseOK()
+}
+
+func (s *SystemAPI) apiKeys(c *okhttp.Context) {
+	apiKeys, err := s.s.store.GetAPIKeys()
+	if err != nil {
+		s.Error("获取api密钥列表失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	resps := make([]*APIKeyResp, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		resps = append(resps, newAPIKeyResp(apiKey))
+	}
+	c.JSON(http.StatusOK, resps)
+}
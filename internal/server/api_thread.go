@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/samlau0508/imserver/pkg/okhttp"
+	"github.com/samlau0508/imserver/pkg/oklog"
+	"go.uber.org/zap"
+)
+
+// ThreadAPI 子区
+type ThreadAPI struct {
+	s *Server
+	oklog.Log
+}
+
+// NewThreadAPI NewThreadAPI
+func NewThreadAPI(s *Server) *ThreadAPI {
+	return &ThreadAPI{
+		s:   s,
+		Log: oklog.NewOKLog("ThreadApi"),
+	}
+}
+
+// Route route
+func (t *ThreadAPI) Route(r *okhttp.OKHttp) {
+	r.POST("/thread/list", t.list) // 获取社区频道最近活跃的子区
+}
+
+func (t *ThreadAPI) list(c *okhttp.Context) {
+	var req ThreadListReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if t.s.clusterManager.ForwardToChannelNodeIfNeed(c, "", req.ChannelID, req.ChannelType, req) {
+		return
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > 100 {
+		limit = 100
+	}
+	threads, err := t.s.threadManager.Threads(req.ChannelID, req.Since, limit)
+	if err != nil {
+		t.Error("获取子区失败！", zap.Error(err), zap.String("channelID", req.ChannelID))
+		c.ResponseError(err)
+		return
+	}
+	c.JSON(http.StatusOK, threads)
+}
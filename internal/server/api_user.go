@@ -2,6 +2,7 @@ package server
 
 import (
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -31,21 +32,25 @@ func (u *UserAPI) Route(r *okhttp.OKHttp) {
 	r.POST("/user/token", u.updateToken)                  // 更新用户token
 	r.POST("/user/device_quit", u.deviceQuit)             // 强制设备退出
 	r.POST("/user/onlinestatus", u.getOnlineStatus)       // 获取用户在线状态
+	r.POST("/user/presence", u.presence)                  // 获取用户在线的设备类型和最后在线时间
 	r.POST("/user/systemuids_add", u.systemUIDsAdd)       // 添加系统uid
 	r.POST("/user/systemuids_remove", u.systemUIDsRemove) // 移除系统uid
+	r.POST("/user/ratelimit", u.rateLimit)                // 获取用户生效的发消息限流
+	r.POST("/user/ratelimit_set", u.rateLimitSet)         // 设置用户单独的发消息限流
+	r.POST("/user/ratelimit_remove", u.rateLimitRemove)   // 移除用户单独的发消息限流（使用全局配置的限流）
 
 }
 
 // 强制设备退出
 func (u *UserAPI) deviceQuit(c *okhttp.Context) {
-	var req struct {
-		UID        string `json:"uid"`         // 用户uid
-		DeviceFlag int    `json:"device_flag"` // 设备flag 这里 -1 为用户所有的设备
-	}
+	var req DeviceQuitReq
 	if err := c.BindJSON(&req); err != nil {
 		c.ResponseError(err)
 		return
 	}
+	if u.s.clusterManager.ForwardToUserNodeIfNeed(c, req.UID, req) {
+		return
+	}
 	if req.DeviceFlag == -1 {
 		u.quitUserDevice(req.UID, okproto.APP)
 		u.quitUserDevice(req.UID, okproto.WEB)
@@ -86,6 +91,10 @@ func (u *UserAPI) getOnlineStatus(c *okhttp.Context) {
 		c.ResponseError(err)
 		return
 	}
+	var remoteUIDMap map[int64][]string
+	if c.GetHeader(clusterForwardHeader) == "" {
+		uids, remoteUIDMap = u.s.clusterManager.SplitUIDsByNode(uids)
+	}
 	conns := u.s.connManager.GetOnlineConns(uids)
 
 	onlineStatusResps := make([]*OnlinestatusResp, 0, len(conns))
@@ -96,10 +105,45 @@ func (u *UserAPI) getOnlineStatus(c *okhttp.Context) {
 			Online:     1,
 		})
 	}
+	// 其他节点的用户去其所在节点查询
+	for nodeID, remoteUIDs := range remoteUIDMap {
+		var remoteResps []*OnlinestatusResp
+		err := u.s.clusterManager.requestNode(nodeID, "/user/onlinestatus", remoteUIDs, &remoteResps)
+		if err != nil {
+			u.Error("获取其他节点的用户在线状态失败！", zap.Error(err), zap.Int64("nodeID", nodeID))
+			c.ResponseError(err)
+			return
+		}
+		onlineStatusResps = append(onlineStatusResps, remoteResps...)
+	}
 
 	c.JSON(http.StatusOK, onlineStatusResps)
 }
 
+// 获取用户在线的设备类型和最后在线时间（其他节点转发过来的请求只查询本节点）
+func (u *UserAPI) presence(c *okhttp.Context) {
+	var uids []string
+	if err := c.BindJSON(&uids); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var (
+		presences []*PresenceResp
+		err       error
+	)
+	if c.GetHeader(clusterForwardHeader) == "" {
+		presences, err = u.s.presenceManager.Presences(uids)
+	} else {
+		presences, err = u.s.presenceManager.LocalPresences(uids)
+	}
+	if err != nil {
+		u.Error("获取用户在线状态失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	c.JSON(http.StatusOK, presences)
+}
+
 // 更新用户的token
 func (u *UserAPI) updateToken(c *okhttp.Context) {
 	var req UpdateTokenReq
@@ -111,6 +155,9 @@ func (u *UserAPI) updateToken(c *okhttp.Context) {
 		c.ResponseError(err)
 		return
 	}
+	if u.s.clusterManager.ForwardToUserNodeIfNeed(c, req.UID, req) {
+		return
+	}
 	u.Debug("req", zap.Any("req", req))
 
 	ban := false // 是否被封禁
@@ -166,9 +213,7 @@ func (u *UserAPI) updateToken(c *okhttp.Context) {
 
 // 添加系统uid
 func (u *UserAPI) systemUIDsAdd(c *okhttp.Context) {
-	var req struct {
-		UIDs []string `json:"uids"`
-	}
+	var req SystemUIDsReq
 	if err := c.BindJSON(&req); err != nil {
 		u.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(errors.New("数据格式有误！"))
@@ -188,9 +233,7 @@ func (u *UserAPI) systemUIDsAdd(c *okhttp.Context) {
 
 // 移除系统uid
 func (u *UserAPI) systemUIDsRemove(c *okhttp.Context) {
-	var req struct {
-		UIDs []string `json:"uids"`
-	}
+	var req SystemUIDsReq
 	if err := c.BindJSON(&req); err != nil {
 		u.Error("数据格式有误！", zap.Error(err))
 		c.ResponseError(errors.New("数据格式有误！"))
@@ -240,3 +283,72 @@ type OnlinestatusResp struct {
 	DeviceFlag uint8  `json:"device_flag"` // 设备标记 0. APP 1.web
 	Online     int    `json:"online"`      // 是否在线
 }
+
+func (u *UserAPI) rateLimit(c *okhttp.Context) {
+	var req UserReq
+	if err := c.BindJSON(&req); err != nil {
+		u.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	if strings.TrimSpace(req.UID) == "" {
+		c.ResponseError(errors.New("uid不能为空！"))
+		return
+	}
+	if u.s.clusterManager.ForwardToUserNodeIfNeed(c, req.UID, req) {
+		return
+	}
+	limit, custom, err := u.s.rateLimiter.GetUserLimit(req.UID)
+	if err != nil {
+		u.Error("获取用户的限流设置失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	c.JSON(http.StatusOK, UserRateLimitResp{RateLimit: limit, Custom: custom})
+}
+
+func (u *UserAPI) rateLimitSet(c *okhttp.Context) {
+	var req UserRateLimitReq
+	if err := c.BindJSON(&req); err != nil {
+		u.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	if err := req.Check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if u.s.clusterManager.ForwardToUserNodeIfNeed(c, req.UID, req) {
+		return
+	}
+	err := u.s.rateLimiter.SetUserLimit(req.UID, &req.RateLimit)
+	if err != nil {
+		u.Error("设置用户的限流失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseOK()
+}
+
+func (u *UserAPI) rateLimitRemove(c *okhttp.Context) {
+	var req UserReq
+	if err := c.BindJSON(&req); err != nil {
+		u.Error("数据格式有误！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	if strings.TrimSpace(req.UID) == "" {
+		c.ResponseError(errors.New("uid不能为空！"))
+		return
+	}
+	if u.s.clusterManager.ForwardToUserNodeIfNeed(c, req.UID, req) {
+		return
+	}
+	err := u.s.rateLimiter.SetUserLimit(req.UID, nil)
+	if err != nil {
+		u.Error("移除用户的限流失败！", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseOK()
+}
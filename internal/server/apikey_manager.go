@@ -0,0 +1,287 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/okhttp"
+	"github.com/samlau0508/imserver/pkg/oklog"
+	"github.com/samlau0508/imserver/pkg/okstore"
+	"github.com/samlau0508/imserver/pkg/okutil"
+	"go.uber.org/zap"
+)
+
+// api权限范围
+const (
+	APIScopeAdmin   = "admin"   // 所有api（包括api密钥管理、系统api）
+	APIScopeMonitor = "monitor" // 只读的监控api（/varz、/connz、/route等）
+	APIScopeMessage = "message" // 消息和最近会话相关api（发送、同步、撤回等）
+	APIScopeChannel = "channel" // 频道管理api（创建频道、订阅者、黑白名单等）
+	APIScopeUser    = "user"    // 用户token管理、设备退出、在线状态
+)
+
+// ErrAPIKeyLastAdmin 没有配置managerToken时最后一个admin密钥不能移除，否则没有办法再调用需要admin权限的api
+var ErrAPIKeyLastAdmin = errors.New("没有配置managerToken时不能移除最后一个admin权限的api密钥！")
+
+var apiScopes = []string{APIScopeAdmin, APIScopeMonitor, APIScopeMessage, APIScopeChannel, APIScopeUser}
+
+// apiRouteScopes 路由需要的权限，请求路径等于key或以key+"/"开头则匹配（匹配最长的key），没有匹配的路由需要admin权限
+var apiRouteScopes = map[string]string{
+	"/varz":                     APIScopeMonitor,
+	"/connz":                    APIScopeMonitor,
+	"/route":                    APIScopeMonitor,
+	"/cluster/nodes":            APIScopeMonitor,
+	"/message":                  APIScopeMessage,
+	"/streammessage":            APIScopeMessage,
+	"/conversations":            APIScopeMessage,
+	"/conversation":             APIScopeMessage,
+	"/channel/messagesync":      APIScopeMessage,
+	"/reaction":                 APIScopeMessage,
+	"/thread":                   APIScopeMessage,
+	"/channel":                  APIScopeChannel,
+	"/channel/retention_set":    APIScopeAdmin,
+	"/channel/retention_remove": APIScopeAdmin,
+	"/user/token":               APIScopeUser,
+	"/user/device_quit":         APIScopeUser,
+	"/user/onlinestatus":        APIScopeUser,
+	"/user/presence":            APIScopeUser,
+	"/system":                   APIScopeAdmin,
+	"/cluster":                  APIScopeAdmin,
+	"/user/systemuids_add":      APIScopeAdmin,
+	"/user/systemuids_remove":   APIScopeAdmin,
+}
+
+// APIKeyManager api密钥管理，不同的调用方使用不同权限范围的密钥，调用记录审计日志
+// 密钥存储在每个节点，集群内通过节点之间的api同步
+type APIKeyManager struct {
+	s        *Server
+	keys     map[string]*okstore.APIKey // key为密钥的sha256
+	enabled  bool                       // 是否开启了api密钥校验（创建过api密钥后即使密钥都被移除也继续校验）
+	keysLock sync.RWMutex
+	auditLog oklog.Log
+	oklog.Log
+}
+
+// NewAPIKeyManager NewAPIKeyManager
+func NewAPIKeyManager(s *Server) *APIKeyManager {
+	return &APIKeyManager{
+		s:        s,
+		keys:     map[string]*okstore.APIKey{},
+		auditLog: oklog.NewOKLog("APIAudit"),
+		Log:      oklog.NewOKLog("APIKeyManager"),
+	}
+}
+
+// Load 加载存储的api密钥
+func (a *APIKeyManager) Load() error {
+	apiKeys, err := a.s.store.GetAPIKeys()
+	if err != nil {
+		return err
+	}
+	enabled, err := a.s.store.GetAPIKeyEnabled()
+	if err != nil {
+		return err
+	}
+	if !enabled && len(apiKeys) > 0 { // 标记之前创建的api密钥
+		if err = a.s.store.SetAPIKeyEnabled(); err != nil {
+			return err
+		}
+		enabled = true
+	}
+	a.keysLock.Lock()
+	defer a.keysLock.Unlock()
+	a.enabled = enabled
+	for _, apiKey := range apiKeys {
+		a.keys[apiKey.KeyHash] = apiKey
+	}
+	return nil
+}
+
+// On 是否开启了api密钥校验（从没有创建过api密钥也没有配置managerToken则api不校验权限）
+func (a *APIKeyManager) On() bool {
+	a.keysLock.RLock()
+	defer a.keysLock.RUnlock()
+	return a.enabled
+}
+
+// Verify 校验密钥，返回密钥信息，密钥不存在返回nil
+func (a *APIKeyManager) Verify(key string) *okstore.APIKey {
+	if strings.TrimSpace(key) == "" {
+		return nil
+	}
+	a.keysLock.RLock()
+	defer a.keysLock.RUnlock()
+	return a.keys[okutil.SHA256(key)]
+}
+
+// Create 创建api密钥，返回密钥明文（只返回这一次，服务端只保存sha256）
+func (a *APIKeyManager) Create(name string, scopes []string) (string, *okstore.APIKey, error) {
+	key := fmt.Sprintf("ok_%s", okutil.GenUUID())
+	apiKey := &okstore.APIKey{
+		ID:        okutil.GenUUID()[:16],
+		Name:      name,
+		Scopes:    scopes,
+		KeyHash:   okutil.SHA256(key),
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := a.Put(apiKey); err != nil {
+		return "", nil, err
+	}
+	a.syncToNodes("/cluster/apikey/put", apiKey)
+	return key, apiKey, nil
+}
+
+// Put 保存api密钥（创建或者从其他节点同步）
+func (a *APIKeyManager) Put(apiKey *okstore.APIKey) error {
+	if !a.On() {
+		if err := a.s.store.SetAPIKeyEnabled(); err != nil {
+			return err
+		}
+	}
+	if err := a.s.store.AddOrUpdateAPIKey(apiKey); err != nil {
+		return err
+	}
+	a.keysLock.Lock()
+	defer a.keysLock.Unlock()
+	a.enabled = true
+	for keyHash, old := range a.keys {
+		if old.ID == apiKey.ID {
+			delete(a.keys, keyHash)
+		}
+	}
+	a.keys[apiKey.KeyHash] = apiKey
+	return nil
+}
+
+// Remove 移除api密钥 sync表示是否同步到其他节点
+func (a *APIKeyManager) Remove(id string, sync bool) error {
+	if !a.s.opts.ManagerTokenOn && a.isLastAdmin(id) {
+		return ErrAPIKeyLastAdmin
+	}
+	if err := a.s.store.RemoveAPIKey(id); err != nil {
+		return err
+	}
+	a.keysLock.Lock()
+	for keyHash, apiKey := range a.keys {
+		if apiKey.ID == id {
+			delete(a.keys, keyHash)
+		}
+	}
+	a.keysLock.Unlock()
+	if sync {
+		a.syncToNodes("/cluster/apikey/remove", &APIKeyRemoveReq{ID: id})
+	}
+	return nil
+}
+
+// 是否是最后一个admin权限的密钥
+func (a *APIKeyManager) isLastAdmin(id string) bool {
+	a.keysLock.RLock()
+	defer a.keysLock.RUnlock()
+	isAdmin := false
+	for _, apiKey := range a.keys {
+		if !apiKey.HasScope(APIScopeAdmin) {
+			continue
+		}
+		if apiKey.ID != id {
+			return false
+		}
+		isAdmin = true
+	}
+	return isAdmin
+}
+
+// 同步到其他节点（同步失败只记录日志，可以重新创建或移除）
+func (a *APIKeyManager) syncToNodes(path string, req interface{}) {
+	if !a.s.clusterManager.On() {
+		return
+	}
+	for _, node := range a.s.clusterManager.Nodes() {
+		if a.s.clusterManager.IsLocal(node.NodeID) {
+			continue
+		}
+		if err := a.s.clusterManager.requestNode(node.NodeID, path, req, nil); err != nil {
+			a.Error("同步api密钥到其他节点失败！", zap.Error(err), zap.Int64("nodeID", node.NodeID), zap.String("path", path))
+		}
+	}
+}
+
+// Authorize api权限校验中间件
+// 没有配置managerToken也没有创建过api密钥则不校验，managerToken拥有所有权限，api密钥按权限范围校验并记录审计日志
+func (a *APIKeyManager) Authorize(c *okhttp.Context) {
+	path := c.Request.URL.Path
+	clusterAuthed := a.s.clusterManager.VerifySecret(c.GetHeader(clusterSecretHeader))
+	if isClusterNodeRoute(path) { // 节点之间通讯的api只能由集群内的节点调用（未开启分布式时直接拒绝）
+		if !clusterAuthed {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+		return
+	}
+	if clusterAuthed || (!a.s.opts.ManagerTokenOn && !a.On()) { // 其他节点请求本节点的api使用共享密钥认证
+		c.Next()
+		return
+	}
+	scope := apiRouteScope(path)
+	token := c.GetHeader("token")
+	if a.s.opts.ManagerTokenOn && token == a.s.opts.ManagerToken {
+		c.Next()
+		return
+	}
+	apiKey := a.Verify(token)
+	if apiKey == nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	if !apiKey.HasScope(APIScopeAdmin) && !apiKey.HasScope(scope) {
+		a.audit(c, apiKey, http.StatusForbidden)
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	c.Next()
+	a.audit(c, apiKey, c.Writer.Status())
+}
+
+func (a *APIKeyManager) audit(c *okhttp.Context, apiKey *okstore.APIKey, status int) {
+	a.auditLog.Info("api调用", zap.String("keyID", apiKey.ID), zap.String("keyName", apiKey.Name), zap.String("method", c.Request.Method), zap.String("path", c.Request.URL.Path), zap.Int("status", status), zap.String("clientIP", c.ClientIP()))
+}
+
+// apiRouteScope 获取路由需要的权限
+func apiRouteScope(path string) string {
+	scope := APIScopeAdmin
+	matchLen := 0
+	for prefix, prefixScope := range apiRouteScopes {
+		if len(prefix) <= matchLen {
+			continue
+		}
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			scope = prefixScope
+			matchLen = len(prefix)
+		}
+	}
+	return scope
+}
+
+func checkAPIScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("scopes不能为空！")
+	}
+	for _, scope := range scopes {
+		valid := false
+		for _, apiScope := range apiScopes {
+			if scope == apiScope {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("不支持的权限范围[%s]！", scope)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/samlau0508/imserver/pkg/okhttp"
+	"github.com/samlau0508/imserver/pkg/okstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIRouteScope(t *testing.T) {
+	assert.Equal(t, APIScopeMonitor, apiRouteScope("/varz"))
+	assert.Equal(t, APIScopeMonitor, apiRouteScope("/route/batch"))
+	assert.Equal(t, APIScopeMessage, apiRouteScope("/message/send"))
+	assert.Equal(t, APIScopeMessage, apiRouteScope("/channel/messagesync"))
+	assert.Equal(t, APIScopeChannel, apiRouteScope("/channel/subscriber_add"))
+	assert.Equal(t, APIScopeAdmin, apiRouteScope("/channel/retention_set"))
+	assert.Equal(t, APIScopeChannel, apiRouteScope("/channel/retention"))
+	assert.Equal(t, APIScopeUser, apiRouteScope("/user/token"))
+	assert.Equal(t, APIScopeAdmin, apiRouteScope("/user/systemuids_add"))
+	assert.Equal(t, APIScopeAdmin, apiRouteScope("/system/apikeys"))
+	assert.Equal(t, APIScopeAdmin, apiRouteScope("/varzx"))
+}
+
+func TestAPIKeyAuthorize(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	opts.ManagerToken = "manager"
+	opts.ManagerTokenOn = true
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+
+	r := okhttp.New()
+	r.Use(s.apiKeyManager.Authorize)
+	r.GET("/varz", func(c *okhttp.Context) { c.ResponseOK() })
+	r.POST("/message/send", func(c *okhttp.Context) { c.ResponseOK() })
+	r.GET("/system/apikeys", func(c *okhttp.Context) { c.ResponseOK() })
+	r.POST("/cluster/apikey/put", func(c *okhttp.Context) { c.ResponseOK() })
+
+	request := func(method string, path string, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("token", token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	monitorKey, _, err := s.apiKeyManager.Create("monitor", []string{APIScopeMonitor})
+	assert.NoError(t, err)
+	backendKey, backend, err := s.apiKeyManager.Create("backend", []string{APIScopeMessage})
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/varz", ""))
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/varz", "wrong"))
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/varz", "manager"))
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/system/apikeys", "manager"))
+
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/varz", monitorKey))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/message/send", monitorKey))
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/message/send", backendKey))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/system/apikeys", backendKey))
+
+	// 没有开启分布式时节点之间通讯的api直接拒绝（managerToken也不行）
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/cluster/apikey/put", "manager"))
+
+	// 移除后立即失效，重启后从存储加载
+	err = s.apiKeyManager.Remove(backend.ID, true)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/message/send", backendKey))
+
+	m := NewAPIKeyManager(s)
+	err = m.Load()
+	assert.NoError(t, err)
+	assert.NotNil(t, m.Verify(monitorKey))
+	assert.Nil(t, m.Verify(backendKey))
+}
+
+// 没有配置managerToken时最后一个admin密钥不能移除，创建过密钥后即使密钥都被移除也继续校验
+func TestAPIKeyRemoveLast(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+
+	r := okhttp.New()
+	r.Use(s.apiKeyManager.Authorize)
+	r.GET("/varz", func(c *okhttp.Context) { c.ResponseOK() })
+
+	request := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/varz", nil)
+		if token != "" {
+			req.Header.Set("token", token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.False(t, s.apiKeyManager.On())
+	assert.Equal(t, http.StatusOK, request(""))
+
+	adminKey, admin, err := s.apiKeyManager.Create("admin", []string{APIScopeAdmin})
+	assert.NoError(t, err)
+	_, monitor, err := s.apiKeyManager.Create("monitor", []string{APIScopeMonitor})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, request(""))
+
+	err = s.apiKeyManager.Remove(admin.ID, true)
+	assert.Equal(t, ErrAPIKeyLastAdmin, err)
+	assert.Equal(t, http.StatusOK, request(adminKey))
+
+	// 有其他admin密钥时可以移除
+	_, admin2, err := s.apiKeyManager.Create("admin2", []string{APIScopeAdmin, APIScopeMonitor})
+	assert.NoError(t, err)
+	err = s.apiKeyManager.Remove(admin.ID, true)
+	assert.NoError(t, err)
+	err = s.apiKeyManager.Remove(monitor.ID, true)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrAPIKeyLastAdmin, s.apiKeyManager.Remove(admin2.ID, true))
+
+	// 配置了managerToken可以移除最后一个密钥，移除后仍然需要校验（重启后也是）
+	opts.ManagerToken = "manager"
+	opts.ManagerTokenOn = true
+	err = s.apiKeyManager.Remove(admin2.ID, true)
+	assert.NoError(t, err)
+	opts.ManagerToken = ""
+	opts.ManagerTokenOn = false
+	assert.Equal(t, http.StatusUnauthorized, request(""))
+	assert.Equal(t, http.StatusUnauthorized, request(adminKey))
+
+	m := NewAPIKeyManager(s)
+	err = m.Load()
+	assert.NoError(t, err)
+	assert.True(t, m.On())
+}
+
+func TestAPIKeyAuthorizeCluster(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	opts.Cluster.On = true
+	opts.Cluster.NodeID = 1
+	opts.Cluster.Secret = "secret"
+	opts.ManagerToken = "manager"
+	opts.ManagerTokenOn = true
+	s := NewTestServer(opts)
+
+	r := okhttp.New()
+	r.Use(s.apiKeyManager.Authorize)
+	r.POST("/cluster/apikey/put", func(c *okhttp.Context) { c.ResponseOK() })
+	r.GET("/cluster/nodes", func(c *okhttp.Context) { c.ResponseOK() })
+	r.POST("/message/send", func(c *okhttp.Context) { c.ResponseOK() })
+
+	request := func(method string, path string, token string, secret string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("token", token)
+		}
+		if secret != "" {
+			req.Header.Set(clusterSecretHeader, secret)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 节点之间通讯的api必须携带共享密钥
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/cluster/apikey/put", "", ""))
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/cluster/apikey/put", "manager", ""))
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/cluster/apikey/put", "", "wrong"))
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/cluster/apikey/put", "", "secret"))
+
+	// 查询集群节点是监控api，其他节点使用共享密钥请求普通api
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/cluster/nodes", "", ""))
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/cluster/nodes", "manager", ""))
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/message/send", "", "secret"))
+
+	// 开启分布式必须配置共享密钥
+	opts.Cluster.Secret = ""
+	assert.Panics(t, func() { NewClusterManager(&Server{opts: opts}) })
+}
@@ -3,6 +3,8 @@ package server
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/samlau0508/imserver/pkg/oklog"
@@ -19,7 +21,9 @@ type Channel struct {
 	subscriberMap sync.Map // 订阅者
 	s             *Server
 	oklog.Log
-	tmpSubscriberMap sync.Map // 临时订阅者
+	tmpSubscriberMap sync.Map     // 临时订阅者
+	lastSendTimes    sync.Map     // 慢速模式下成员最后一次发消息的时间（10位，到秒）
+	lastPruneTime    atomic.Int64 // 最后一次清理lastSendTimes的时间（10位，到秒）
 }
 
 // NewChannel NewChannel
@@ -72,6 +76,7 @@ func (c *Channel) initChannelInfo() error {
 		return err
 	}
 	if channelInfo != nil {
+		channelInfo.ChannelModeration = c.ChannelModeration // 禁言设置使用本地存储的
 		c.ChannelInfo = channelInfo
 		return nil
 	}
@@ -262,6 +267,16 @@ func (c *Channel) Allow(uid string) (bool, proto.ReasonCode) {
 		return false, proto.ReasonBan
 	}
 
+	now := time.Now().Unix()
+	if c.ChannelType != proto.ChannelTypePerson {
+		if c.MemberMuted(uid, now) { // 成员被禁言
+			return false, proto.ReasonMemberMuted
+		}
+		if c.Muted(now) && !c.IsAdmin(uid) { // 全员禁言
+			return false, proto.ReasonChannelMuted
+		}
+	}
+
 	if c.ChannelType == proto.ChannelTypePerson && c.s.opts.IsFakeChannel(c.ChannelID) {
 		if c.IsDenylist(uid) {
 			return false, proto.ReasonInBlacklist
@@ -294,6 +309,45 @@ func (c *Channel) Allow(uid string) (bool, proto.ReasonCode) {
 	return true, proto.ReasonSuccess
 }
 
+// CheckSlowMode 慢速模式下成员距离上次发消息需要超过间隔（管理员和系统账号除外），允许发送则记录本次发消息的时间
+// 每条消息都需要校验（频道信号等不算发消息）
+func (c *Channel) CheckSlowMode(uid string) (bool, proto.ReasonCode) {
+	if c.SlowMode == 0 || c.ChannelType == proto.ChannelTypePerson || c.ChannelType == proto.ChannelTypeInfo || c.ChannelType == proto.ChannelTypeCustomerService {
+		return true, proto.ReasonSuccess
+	}
+	if c.IsAdmin(uid) || c.s.systemUIDManager.SystemUID(uid) {
+		return true, proto.ReasonSuccess
+	}
+	now := time.Now().Unix()
+	c.pruneLastSendTimes(now)
+	for {
+		lastSendTime, loaded := c.lastSendTimes.LoadOrStore(uid, now)
+		if !loaded {
+			return true, proto.ReasonSuccess
+		}
+		if now-lastSendTime.(int64) < int64(c.SlowMode) {
+			return false, proto.ReasonSlowMode
+		}
+		if c.lastSendTimes.CompareAndSwap(uid, lastSendTime, now) {
+			return true, proto.ReasonSuccess
+		}
+	}
+}
+
+// 删除超过慢速模式间隔的发消息时间（已经不影响发消息），每个间隔最多清理一次
+func (c *Channel) pruneLastSendTimes(now int64) {
+	lastPruneTime := c.lastPruneTime.Load()
+	if now-lastPruneTime < int64(c.SlowMode) || !c.lastPruneTime.CompareAndSwap(lastPruneTime, now) {
+		return
+	}
+	c.lastSendTimes.Range(func(uid, lastSendTime interface{}) bool {
+		if now-lastSendTime.(int64) >= int64(c.SlowMode) {
+			c.lastSendTimes.CompareAndDelete(uid, lastSendTime)
+		}
+		return true
+	})
+}
+
 // real subscribers
 func (c *Channel) RealSubscribers(customSubscribers []string) ([]string, error) {
 
@@ -452,6 +506,22 @@ func (c *Channel) Put(messages []*Message, customSubscribers []string, fromUID s
 		return err
 	}
 
+	//########## forward to the nodes of subscribers ##########
+	if c.s.clusterManager.On() {
+		var remoteSubscriberMap map[int64][]string
+		subscribers, remoteSubscriberMap = c.s.clusterManager.SplitUIDsByNode(subscribers)
+		if len(remoteSubscriberMap) > 0 && len(messages) > 0 {
+			c.deliverToRemoteNodes(messages, remoteSubscriberMap, fromUID, fromDeviceFlag, fromDeviceID)
+		}
+	}
+
+	return c.putToSubscribers(messages, subscribers, fromUID, fromDeviceFlag, fromDeviceID)
+}
+
+// 将消息放入本节点订阅者的消息队列，更新最近会话并投递
+func (c *Channel) putToSubscribers(messages []*Message, subscribers []string, fromUID string, fromDeviceFlag proto.DeviceFlag, fromDeviceID string) error {
+	var err error
+
 	//########## store messages in user queue ##########
 	var messageSeqMap map[string]uint32
 	if len(messages) > 0 {
@@ -482,6 +552,26 @@ func (c *Channel) Put(messages []*Message, customSubscribers []string, fromUID s
 	return nil
 }
 
+// 将消息交给其他节点投递给其节点上的订阅者（投递失败只记录日志，不影响本节点的投递）
+func (c *Channel) deliverToRemoteNodes(messages []*Message, remoteSubscriberMap map[int64][]string, fromUID string, fromDeviceFlag proto.DeviceFlag, fromDeviceID string) {
+	messageDatas := encodeClusterMessages(messages)
+	for nodeID, subscribers := range remoteSubscriberMap {
+		err := c.s.clusterManager.DeliverMessages(nodeID, &clusterMessageDeliverReq{
+			ChannelID:      c.ChannelID,
+			ChannelType:    c.ChannelType,
+			Large:          c.Large,
+			Subscribers:    subscribers,
+			FromUID:        fromUID,
+			FromDeviceFlag: fromDeviceFlag.ToUint8(),
+			FromDeviceID:   fromDeviceID,
+			Messages:       messageDatas,
+		})
+		if err != nil {
+			c.Error("转发消息到订阅者所在节点失败！", zap.Error(err), zap.Int64("nodeID", nodeID), zap.Int("subscriberCount", len(subscribers)))
+		}
+	}
+}
+
 // store message to user queue if need
 func (c *Channel) storeMessageToUserQueueIfNeed(messages []*Message, subscribers []string) (map[string]uint32, error) {
 	if len(messages) == 0 {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/pkg/errors"
@@ -11,6 +12,7 @@ import (
 	"github.com/samlau0508/imserver/pkg/okstore"
 	"github.com/samlau0508/imserver/pkg/okutil"
 	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"go.uber.org/zap"
 )
 
 // ---------- 频道管理 ----------
@@ -21,6 +23,7 @@ type ChannelManager struct {
 	channelCache     *lru.Cache[string, *Channel]
 	tmpChannelCache  *lru.Cache[string, *Channel] // 系统消息临时频道
 	dataChannelCache sync.Map                     // 数据频道缓存，订阅者为0的时候应该删除频道本身
+	channelInfoLock  sync.Mutex                   // 频道信息的修改串行处理（基础信息和禁言设置分开修改）
 	oklog.Log
 }
 
@@ -208,3 +211,67 @@ func (cm *ChannelManager) DeleteChannelFromCache(channelID string, channelType u
 	cm.s.monitor.ChannelCacheCountDec()
 	cm.channelCache.Remove(fmt.Sprintf("%s-%d", channelID, channelType))
 }
+
+// AddOrUpdateChannelInfo 添加或更新频道的基础信息（保留原有的禁言设置）
+func (cm *ChannelManager) AddOrUpdateChannelInfo(channelInfo *okstore.ChannelInfo) error {
+	cm.channelInfoLock.Lock()
+	defer cm.channelInfoLock.Unlock()
+	oldChannelInfo, err := cm.s.store.GetChannel(channelInfo.ChannelID, channelInfo.ChannelType)
+	if err != nil {
+		return err
+	}
+	if oldChannelInfo != nil {
+		channelInfo.ChannelModeration = oldChannelInfo.ChannelModeration
+	}
+	return cm.s.store.AddOrUpdateChannel(channelInfo)
+}
+
+// UpdateModeration 修改频道的禁言设置，修改前会清除已到期的禁言 modifyFnc为nil表示只清除已到期的禁言
+func (cm *ChannelManager) UpdateModeration(channelID string, channelType uint8, modifyFnc func(moderation *okstore.ChannelModeration)) error {
+	cm.channelInfoLock.Lock()
+	defer cm.channelInfoLock.Unlock()
+	channelInfo, err := cm.s.store.GetChannel(channelID, channelType)
+	if err != nil {
+		return err
+	}
+	if channelInfo == nil {
+		if modifyFnc == nil {
+			return nil
+		}
+		if !cm.s.opts.Channel.CreateIfNoExist {
+			return ErrChannelNotFound
+		}
+		channelInfo = okstore.NewChannelInfo(channelID, channelType)
+	}
+	changed := channelInfo.ClearExpired(time.Now().Unix())
+	if modifyFnc != nil {
+		modifyFnc(&channelInfo.ChannelModeration)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if err = cm.s.store.AddOrUpdateChannel(channelInfo); err != nil {
+		return err
+	}
+	// 缓存的频道信息可能来自数据源，只替换禁言设置
+	channel := cm.getChannelFromCache(channelID, channelType)
+	if channel != nil {
+		newChannelInfo := *channel.ChannelInfo
+		newChannelInfo.ChannelModeration = channelInfo.ChannelModeration
+		channel.ChannelInfo = &newChannelInfo
+	}
+	return nil
+}
+
+// ScheduleModerationExpire 禁言到期后清除禁言（重启后未执行的清除会在下次修改禁言设置时进行，禁言是否生效始终按到期时间判断）
+func (cm *ChannelManager) ScheduleModerationExpire(channelID string, channelType uint8, expireAt int64) {
+	if expireAt <= 0 {
+		return
+	}
+	cm.s.timingWheel.AfterFunc(time.Until(time.Unix(expireAt, 0))+time.Second, func() {
+		if err := cm.UpdateModeration(channelID, channelType, nil); err != nil {
+			cm.Error("清除到期的禁言失败！", zap.Error(err), zap.String("channelID", channelID), zap.Uint8("channelType", channelType))
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/okstore"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelAllowModeration(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	opts.Channel.CreateIfNoExist = true
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+
+	channelInfo := okstore.NewChannelInfo("g1", okproto.ChannelTypeGroup)
+	channelInfo.Admins = []string{"admin"}
+	channel := NewChannel(channelInfo, s)
+
+	now := time.Now().Unix()
+	channel.MemberMutes = map[string]int64{"u1": 0, "u2": now - 1}
+	allow, reason := channel.Allow("u1")
+	assert.False(t, allow)
+	assert.Equal(t, okproto.ReasonMemberMuted, reason)
+	// 禁言已到期
+	allow, _ = channel.Allow("u2")
+	assert.True(t, allow)
+
+	channel.Mute = true
+	allow, reason = channel.Allow("u2")
+	assert.False(t, allow)
+	assert.Equal(t, okproto.ReasonChannelMuted, reason)
+	allow, _ = channel.Allow("admin")
+	assert.True(t, allow)
+
+	// 慢速模式只在发消息时校验，Allow不占用发消息的间隔
+	channel.Mute = false
+	channel.SlowMode = 60
+	allow, _ = channel.Allow("u3")
+	assert.True(t, allow)
+	allow, _ = channel.Allow("u3")
+	assert.True(t, allow)
+	allow, _ = channel.CheckSlowMode("u3")
+	assert.True(t, allow)
+	allow, reason = channel.CheckSlowMode("u3")
+	assert.False(t, allow)
+	assert.Equal(t, okproto.ReasonSlowMode, reason)
+	allow, _ = channel.CheckSlowMode("admin")
+	assert.True(t, allow)
+	allow, _ = channel.CheckSlowMode("admin")
+	assert.True(t, allow)
+
+	// 超过间隔的记录会被清理
+	channel.lastSendTimes.Store("u4", now-60)
+	channel.lastPruneTime.Store(now - 60)
+	allow, _ = channel.CheckSlowMode("u3")
+	assert.False(t, allow)
+	_, ok := channel.lastSendTimes.Load("u4")
+	assert.False(t, ok)
+	_, ok = channel.lastSendTimes.Load("u3")
+	assert.True(t, ok)
+}
+
+func TestChannelManagerUpdateModeration(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	opts.Channel.CreateIfNoExist = true
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+
+	err = s.channelManager.UpdateModeration("g1", okproto.ChannelTypeGroup, func(moderation *okstore.ChannelModeration) {
+		moderation.SlowMode = 10
+		moderation.MemberMutes = map[string]int64{"u1": time.Now().Unix() - 1, "u2": 0}
+	})
+	assert.NoError(t, err)
+
+	// 修改频道基础信息不影响禁言设置
+	channelInfo := okstore.NewChannelInfo("g1", okproto.ChannelTypeGroup)
+	channelInfo.Large = true
+	err = s.channelManager.AddOrUpdateChannelInfo(channelInfo)
+	assert.NoError(t, err)
+
+	// 只清除到期的禁言
+	err = s.channelManager.UpdateModeration("g1", okproto.ChannelTypeGroup, nil)
+	assert.NoError(t, err)
+
+	channelInfo, err = s.store.GetChannel("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.True(t, channelInfo.Large)
+	assert.Equal(t, uint32(10), channelInfo.SlowMode)
+	assert.Equal(t, map[string]int64{"u2": 0}, channelInfo.MemberMutes)
+}
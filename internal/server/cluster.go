@@ -0,0 +1,519 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/network"
+	"github.com/samlau0508/imserver/pkg/okhttp"
+	"github.com/samlau0508/imserver/pkg/oklog"
+	"github.com/samlau0508/imserver/pkg/okutil"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"go.uber.org/zap"
+)
+
+// 节点之间转发请求时携带的header，携带此header的请求不会被再次转发（防止循环转发）
+const clusterForwardHeader = "X-Cluster-Forward"
+
+// 节点之间请求携带的共享密钥header（值为cluster.secret）
+const clusterSecretHeader = "X-Cluster-Secret"
+
+var (
+	ErrClusterNoNode      = errors.New("集群没有可用节点！")
+	ErrClusterNodeUnknown = errors.New("集群节点不存在！")
+	ErrClusterNoSecret    = errors.New("开启分布式必须配置cluster.secret！")
+)
+
+// ClusterNode 集群节点
+type ClusterNode struct {
+	NodeID  int64  `json:"node_id"`  // 节点ID
+	APIURL  string `json:"api_url"`  // 节点api地址
+	TCPAddr string `json:"tcp_addr"` // 节点对外的tcp地址
+	WSAddr  string `json:"ws_addr"`  // 节点对外的ws地址
+	WSSAddr string `json:"wss_addr"` // 节点对外的wss地址
+
+	lastHeartbeat time.Time // 最后一次心跳成功的时间
+}
+
+// ClusterNodeResp 集群节点（包含节点是否在线）
+type ClusterNodeResp struct {
+	ClusterNode
+	Online bool `json:"online"` // 节点是否在线
+}
+
+// ClusterManager 集群管理
+// 节点成员为静态配置，用户和频道通过slot映射到节点（slot = crc32(key) % slotNum，节点 = 按ID排序后的节点[slot % 节点数量]）。
+// 用户的连接、token、消息队列、最近会话都在用户所在节点，频道的信息和消息都在频道所在节点。
+// 节点离线时不会迁移slot，属于此节点的用户和频道将不可用，直到节点恢复。
+// 客户端通过EVENT包发起的撤回、编辑、删除、已读、回应等操作交给频道所在节点处理，产生的事件再按订阅者所在节点分发投递。
+type ClusterManager struct {
+	s *Server
+	oklog.Log
+	nodes         map[int64]*ClusterNode
+	sortedNodeIDs []int64
+	nodeLock      sync.RWMutex
+	stopChan      chan struct{}
+}
+
+// NewClusterManager NewClusterManager
+func NewClusterManager(s *Server) *ClusterManager {
+	c := &ClusterManager{
+		s:        s,
+		Log:      oklog.NewOKLog("ClusterManager"),
+		nodes:    map[int64]*ClusterNode{},
+		stopChan: make(chan struct{}),
+	}
+	if s.opts.Cluster.On {
+		if strings.TrimSpace(s.opts.Cluster.Secret) == "" {
+			panic(ErrClusterNoSecret)
+		}
+		for _, nodeStr := range s.opts.Cluster.Nodes {
+			node, err := parseClusterNode(nodeStr)
+			if err != nil {
+				panic(err)
+			}
+			c.nodes[node.NodeID] = node
+		}
+		local := c.nodes[s.opts.Cluster.NodeID]
+		if local == nil {
+			local = &ClusterNode{NodeID: s.opts.Cluster.NodeID}
+			c.nodes[local.NodeID] = local
+		}
+		local.APIURL = s.opts.External.APIUrl
+		local.TCPAddr = s.opts.External.TCPAddr
+		local.WSAddr = s.opts.External.WSAddr
+		local.WSSAddr = s.opts.External.WSSAddr
+
+		for nodeID := range c.nodes {
+			c.sortedNodeIDs = append(c.sortedNodeIDs, nodeID)
+		}
+		sort.Slice(c.sortedNodeIDs, func(i, j int) bool {
+			return c.sortedNodeIDs[i] < c.sortedNodeIDs[j]
+		})
+	}
+	return c
+}
+
+// 解析节点配置 格式：节点ID@api地址
+func parseClusterNode(nodeStr string) (*ClusterNode, error) {
+	nodeStrs := strings.SplitN(strings.TrimSpace(nodeStr), "@", 2)
+	if len(nodeStrs) != 2 {
+		return nil, fmt.Errorf("集群节点配置格式有误！[%s]", nodeStr)
+	}
+	nodeID, err := strconv.ParseInt(nodeStrs[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("集群节点ID有误！[%s]", nodeStr)
+	}
+	return &ClusterNode{
+		NodeID: nodeID,
+		APIURL: strings.TrimSuffix(nodeStrs[1], "/"),
+	}, nil
+}
+
+// Start 开始
+func (c *ClusterManager) Start() {
+	if !c.On() {
+		return
+	}
+	c.s.Info(fmt.Sprintf("Cluster on, nodeID: %d nodes: %v", c.s.opts.Cluster.NodeID, c.sortedNodeIDs))
+	go c.loopHeartbeat()
+}
+
+// Stop 停止
+func (c *ClusterManager) Stop() {
+	if !c.On() {
+		return
+	}
+	close(c.stopChan)
+}
+
+// On 是否开启了集群
+func (c *ClusterManager) On() bool {
+	return c.s.opts.Cluster.On
+}
+
+// VerifySecret 校验节点之间请求携带的共享密钥
+func (c *ClusterManager) VerifySecret(secret string) bool {
+	if !c.On() || secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(c.s.opts.Cluster.Secret)) == 1
+}
+
+// isClusterNodeRoute 是否是节点之间通讯的api（/cluster/下除了查询集群节点的监控api）
+func isClusterNodeRoute(path string) bool {
+	return strings.HasPrefix(path, "/cluster/") && path != "/cluster/nodes"
+}
+
+// IsLocal 是否是当前节点
+func (c *ClusterManager) IsLocal(nodeID int64) bool {
+	return !c.On() || nodeID == c.s.opts.Cluster.NodeID
+}
+
+// LocalNode 当前节点
+func (c *ClusterManager) LocalNode() *ClusterNode {
+	if !c.On() {
+		return &ClusterNode{
+			NodeID:  c.s.opts.ID,
+			APIURL:  c.s.opts.External.APIUrl,
+			TCPAddr: c.s.opts.External.TCPAddr,
+			WSAddr:  c.s.opts.External.WSAddr,
+			WSSAddr: c.s.opts.External.WSSAddr,
+		}
+	}
+	return c.Node(c.s.opts.Cluster.NodeID)
+}
+
+// Node 获取节点
+func (c *ClusterManager) Node(nodeID int64) *ClusterNode {
+	c.nodeLock.RLock()
+	defer c.nodeLock.RUnlock()
+	node := c.nodes[nodeID]
+	if node == nil {
+		return nil
+	}
+	cloneNode := *node
+	return &cloneNode
+}
+
+// Nodes 获取所有节点
+func (c *ClusterManager) Nodes() []*ClusterNode {
+	nodes := make([]*ClusterNode, 0, len(c.sortedNodeIDs))
+	for _, nodeID := range c.sortedNodeIDs {
+		nodes = append(nodes, c.Node(nodeID))
+	}
+	return nodes
+}
+
+// NodeOnline 节点是否在线（当前节点始终在线）
+func (c *ClusterManager) NodeOnline(nodeID int64) bool {
+	if c.IsLocal(nodeID) {
+		return true
+	}
+	node := c.Node(nodeID)
+	if node == nil {
+		return false
+	}
+	return time.Since(node.lastHeartbeat) < c.s.opts.Cluster.NodeTimeout
+}
+
+// NodeIDOfUser 获取用户所在节点ID
+func (c *ClusterManager) NodeIDOfUser(uid string) (int64, error) {
+	return c.nodeIDOfKey(uid)
+}
+
+// NodeIDOfChannel 获取频道所在节点ID（个人频道需传入fakeChannelID，用户自己的个人频道跟随用户所在节点）
+func (c *ClusterManager) NodeIDOfChannel(channelID string, channelType uint8) (int64, error) {
+	if channelType == okproto.ChannelTypePerson && !strings.Contains(channelID, "@") {
+		return c.NodeIDOfUser(channelID)
+	}
+	if channelType == okproto.ChannelTypeCommunityTopic { // 社区话题频道的订阅者继承自社区频道，和社区频道在同一节点
+		if parentChannelID := GetCommunityTopicParentChannelID(channelID); parentChannelID != "" {
+			return c.nodeIDOfKey(fmt.Sprintf("%s-%d", parentChannelID, okproto.ChannelTypeCommunity))
+		}
+	}
+	return c.nodeIDOfKey(fmt.Sprintf("%s-%d", channelID, channelType))
+}
+
+func (c *ClusterManager) nodeIDOfKey(key string) (int64, error) {
+	if !c.On() {
+		return c.s.opts.ID, nil
+	}
+	if len(c.sortedNodeIDs) == 0 {
+		return 0, ErrClusterNoNode
+	}
+	slot := okutil.GetSlotNum(c.s.opts.SlotNum, key)
+	return c.sortedNodeIDs[int(slot)%len(c.sortedNodeIDs)], nil
+}
+
+// SplitUIDsByNode 将用户按所在节点分组 返回本节点的用户和其他节点的用户
+func (c *ClusterManager) SplitUIDsByNode(uids []string) ([]string, map[int64][]string) {
+	if !c.On() {
+		return uids, nil
+	}
+	localUIDs := make([]string, 0, len(uids))
+	remoteUIDMap := map[int64][]string{}
+	for _, uid := range uids {
+		nodeID, err := c.NodeIDOfUser(uid)
+		if err != nil || c.IsLocal(nodeID) {
+			localUIDs = append(localUIDs, uid)
+			continue
+		}
+		remoteUIDMap[nodeID] = append(remoteUIDMap[nodeID], uid)
+	}
+	return localUIDs, remoteUIDMap
+}
+
+// 定时发送心跳给其他节点
+func (c *ClusterManager) loopHeartbeat() {
+	tick := time.NewTicker(c.s.opts.Cluster.HeartbeatInterval)
+	defer tick.Stop()
+	c.heartbeat()
+	for {
+		select {
+		case <-tick.C:
+			c.heartbeat()
+		case <-c.stopChan:
+			return
+		}
+	}
+}
+
+func (c *ClusterManager) heartbeat() {
+	local := c.LocalNode()
+	for _, nodeID := range c.sortedNodeIDs {
+		if c.IsLocal(nodeID) {
+			continue
+		}
+		var remote ClusterNode
+		err := c.requestNode(nodeID, "/cluster/heartbeat", local, &remote)
+		if err != nil {
+			if c.NodeOnline(nodeID) {
+				c.Warn("节点心跳失败！", zap.Int64("nodeID", nodeID), zap.Error(err))
+			}
+			continue
+		}
+		c.updateNode(nodeID, &remote)
+	}
+}
+
+// 更新节点信息（心跳时交换节点的连接地址）
+func (c *ClusterManager) updateNode(nodeID int64, remote *ClusterNode) {
+	c.nodeLock.Lock()
+	defer c.nodeLock.Unlock()
+	node := c.nodes[nodeID]
+	if node == nil {
+		return
+	}
+	node.TCPAddr = remote.TCPAddr
+	node.WSAddr = remote.WSAddr
+	node.WSSAddr = remote.WSSAddr
+	node.lastHeartbeat = time.Now()
+}
+
+// 请求其他节点的api
+func (c *ClusterManager) requestNode(nodeID int64, path string, req interface{}, resp interface{}) error {
+	node := c.Node(nodeID)
+	if node == nil {
+		return ErrClusterNodeUnknown
+	}
+	result, err := network.Post(node.APIURL+path, []byte(okutil.ToJSON(req)), c.forwardHeaders())
+	if err != nil {
+		return err
+	}
+	if result.StatusCode != http.StatusOK {
+		return fmt.Errorf("http状态码错误！[%d]", result.StatusCode)
+	}
+	if resp == nil {
+		return nil
+	}
+	return okutil.ReadJSONByByte([]byte(result.Body), resp)
+}
+
+func (c *ClusterManager) forwardHeaders() map[string]string {
+	return map[string]string{
+		"Content-Type":       "application/json",
+		clusterForwardHeader: strconv.FormatInt(c.s.opts.Cluster.NodeID, 10),
+		clusterSecretHeader:  c.s.opts.Cluster.Secret,
+	}
+}
+
+// ForwardToUserNodeIfNeed 如果用户不在当前节点则将api请求转发到用户所在节点，返回true表示已处理
+func (c *ClusterManager) ForwardToUserNodeIfNeed(ctx *okhttp.Context, uid string, req interface{}) bool {
+	if !c.On() {
+		return false
+	}
+	nodeID, err := c.NodeIDOfUser(uid)
+	return c.forwardIfNeed(ctx, nodeID, err, req)
+}
+
+// ForwardToChannelNodeIfNeed 如果频道不在当前节点则将api请求转发到频道所在节点，返回true表示已处理（个人频道需传uid）
+func (c *ClusterManager) ForwardToChannelNodeIfNeed(ctx *okhttp.Context, uid string, channelID string, channelType uint8, req interface{}) bool {
+	if !c.On() {
+		return false
+	}
+	fakeChannelID := channelID
+	if channelType == okproto.ChannelTypePerson && uid != "" {
+		fakeChannelID = GetFakeChannelIDWith(uid, channelID)
+	}
+	nodeID, err := c.NodeIDOfChannel(fakeChannelID, channelType)
+	return c.forwardIfNeed(ctx, nodeID, err, req)
+}
+
+func (c *ClusterManager) forwardIfNeed(ctx *okhttp.Context, nodeID int64, err error, req interface{}) bool {
+	if ctx.GetHeader(clusterForwardHeader) != "" { // 已经是转发过来的请求，不再转发
+		return false
+	}
+	if err != nil {
+		c.Error("获取节点失败！", zap.Error(err))
+		ctx.ResponseError(err)
+		return true
+	}
+	if c.IsLocal(nodeID) {
+		return false
+	}
+	node := c.Node(nodeID)
+	if node == nil {
+		ctx.ResponseError(ErrClusterNodeUnknown)
+		return true
+	}
+	ctx.Request.Header.Set(clusterForwardHeader, strconv.FormatInt(c.s.opts.Cluster.NodeID, 10))
+	var body []byte
+	if req != nil {
+		body = []byte(okutil.ToJSON(req))
+	}
+	ctx.ForwardWithBody(node.APIURL+ctx.Request.URL.Path, body)
+	return true
+}
+
+// PutMessages 将消息交给频道所在节点处理（存储并投递）
+func (c *ClusterManager) PutMessages(nodeID int64, req *clusterMessagePutReq) (*clusterMessagePutResp, error) {
+	var resp clusterMessagePutResp
+	err := c.requestNode(nodeID, "/cluster/message/put", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeliverMessages 将消息交给订阅者所在节点投递
+func (c *ClusterManager) DeliverMessages(nodeID int64, req *clusterMessageDeliverReq) error {
+	return c.requestNode(nodeID, "/cluster/message/deliver", req, nil)
+}
+
+// SendMessage 将api发送的消息交给频道所在节点发送
+func (c *ClusterManager) SendMessage(nodeID int64, req *clusterMessageSendReq) (*clusterMessageSendResp, error) {
+	var resp clusterMessageSendResp
+	err := c.requestNode(nodeID, "/cluster/message/send", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ProcessEvent 将客户端发起的频道事件交给频道所在节点处理
+func (c *ClusterManager) ProcessEvent(nodeID int64, req *clusterEventReq) (*clusterEventResp, error) {
+	var resp clusterEventResp
+	err := c.requestNode(nodeID, "/cluster/event/process", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SubscribeChannel 将数据频道的订阅交给频道所在节点登记
+func (c *ClusterManager) SubscribeChannel(nodeID int64, req *clusterChannelSubscribeReq) (*clusterChannelSubscribeResp, error) {
+	var resp clusterChannelSubscribeResp
+	err := c.requestNode(nodeID, "/cluster/channel/subscribe", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeliverEvent 将频道事件交给订阅者所在节点投递
+func (c *ClusterManager) DeliverEvent(nodeID int64, req *clusterEventDeliverReq) error {
+	return c.requestNode(nodeID, "/cluster/event/deliver", req, nil)
+}
+
+type clusterChannelSubscribeReq struct {
+	UID              string `json:"uid"` // 订阅者
+	ChannelID        string `json:"channel_id"`
+	ChannelType      uint8  `json:"channel_type"`
+	Action           uint8  `json:"action"`            // 订阅或取消订阅
+	RemoveSubscriber bool   `json:"remove_subscriber"` // 取消订阅时是否移除订阅者（订阅者在其节点已没有连接）
+}
+
+type clusterChannelSubscribeResp struct {
+	ReasonCode okproto.ReasonCode `json:"reason_code"`
+}
+
+type clusterEventReq struct {
+	UID  string          `json:"uid"`  // 发起事件的用户
+	Type string          `json:"type"` // 事件类型
+	Data json.RawMessage `json:"data"` // 事件数据（已在客户端所在节点解析和校验，编辑内容已解密）
+}
+
+type clusterEventResp struct {
+	ReasonCode okproto.ReasonCode `json:"reason_code"`
+}
+
+type clusterEventDeliverReq struct {
+	ChannelID   string          `json:"channel_id"` // 频道ID（个人频道为fakeChannelID）
+	ChannelType uint8           `json:"channel_type"`
+	Subscribers []string        `json:"subscribers"` // 需要投递的订阅者（都属于目标节点）
+	Type        string          `json:"type"`        // 事件类型
+	Data        json.RawMessage `json:"data"`        // 事件数据（频道ID和加密的内容由订阅者所在节点按连接生成）
+}
+
+type clusterPresenceWatchReq struct {
+	NodeID int64    `json:"node_id"` // 订阅者所在节点
+	UIDs   []string `json:"uids"`    // 订阅的用户（都属于目标节点）
+}
+
+type clusterMessageSendReq struct {
+	Req         MessageSendReq `json:"req"`
+	ChannelID   string         `json:"channel_id"`
+	ChannelType uint8          `json:"channel_type"`
+	ClientMsgNo string         `json:"client_msg_no"`
+	StreamFlag  uint8          `json:"stream_flag"`
+}
+
+type clusterMessageSendResp struct {
+	MessageID  int64  `json:"message_id"`
+	MessageSeq uint32 `json:"message_seq"`
+}
+
+type clusterMessagePutReq struct {
+	ChannelID      string   `json:"channel_id"` // 频道ID（个人频道为fakeChannelID）
+	ChannelType    uint8    `json:"channel_type"`
+	FromUID        string   `json:"from_uid"`
+	FromDeviceFlag uint8    `json:"from_device_flag"`
+	FromDeviceID   string   `json:"from_device_id"`
+	Messages       [][]byte `json:"messages"` // Message.Encode()的数据
+}
+
+type clusterMessagePutResp struct {
+	ReasonCode  okproto.ReasonCode   `json:"reason_code"`
+	MessageSeqs []uint32             `json:"message_seqs"` // 与请求的消息一一对应
+	ReasonCodes []okproto.ReasonCode `json:"reason_codes"` // 与请求的消息一一对应 单条消息被拒绝（比如慢速模式）的原因，0表示跟随reason_code
+}
+
+type clusterMessageDeliverReq struct {
+	ChannelID      string   `json:"channel_id"` // 频道ID（个人频道为fakeChannelID）
+	ChannelType    uint8    `json:"channel_type"`
+	Large          bool     `json:"large"`
+	Subscribers    []string `json:"subscribers"` // 需要投递的订阅者（都属于目标节点）
+	FromUID        string   `json:"from_uid"`
+	FromDeviceFlag uint8    `json:"from_device_flag"`
+	FromDeviceID   string   `json:"from_device_id"`
+	Messages       [][]byte `json:"messages"` // Message.Encode()的数据
+}
+
+func encodeClusterMessages(messages []*Message) [][]byte {
+	data := make([][]byte, 0, len(messages))
+	for _, m := range messages {
+		data = append(data, m.Encode())
+	}
+	return data
+}
+
+func decodeClusterMessages(data [][]byte) ([]*Message, error) {
+	messages := make([]*Message, 0, len(data))
+	for _, d := range data {
+		m := &Message{}
+		if err := m.Decode(d); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
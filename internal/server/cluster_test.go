@@ -0,0 +1,301 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/mqtt"
+	"github.com/samlau0508/imserver/pkg/network"
+	"github.com/samlau0508/imserver/pkg/okutil"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterNodeOfUser(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Cluster.On = true
+	opts.Cluster.NodeID = 1
+	opts.Cluster.Secret = "secret"
+	opts.Cluster.Nodes = []string{"2@http://127.0.0.1:5002", "1@http://127.0.0.1:5001"}
+	c := NewClusterManager(&Server{opts: opts})
+
+	assert.Equal(t, []int64{1, 2}, c.sortedNodeIDs)
+
+	nodeCountMap := map[int64]int{}
+	for i := 0; i < 100; i++ {
+		uid := fmt.Sprintf("uid%d", i)
+		nodeID, err := c.NodeIDOfUser(uid)
+		assert.NoError(t, err)
+		nodeCountMap[nodeID]++
+
+		// 用户自己的个人频道跟随用户所在节点
+		channelNodeID, err := c.NodeIDOfChannel(uid, okproto.ChannelTypePerson)
+		assert.NoError(t, err)
+		assert.Equal(t, nodeID, channelNodeID)
+	}
+	assert.Equal(t, 2, len(nodeCountMap))
+
+	localUIDs, remoteUIDMap := c.SplitUIDsByNode([]string{"uid1", "uid2", "uid3", "uid4"})
+	assert.Equal(t, 4, len(localUIDs)+len(remoteUIDMap[2]))
+	for _, uid := range localUIDs {
+		nodeID, _ := c.NodeIDOfUser(uid)
+		assert.Equal(t, int64(1), nodeID)
+	}
+
+	_, err := parseClusterNode("http://127.0.0.1:5001")
+	assert.Error(t, err)
+}
+
+func TestClusterSendAndRecv(t *testing.T) {
+	servers := clusterTestStart(t)
+	assert.Equal(t, int64(2), servers[1].opts.ID)
+
+	// 找到分别属于两个节点的用户
+	uids := clusterTestUIDs(t, servers[0])
+
+	addr1 := servers[0].dispatch.engine.MQTTRealListenAddr().String()
+	addr2 := servers[1].dispatch.engine.MQTTRealListenAddr().String()
+
+	// 用户连接到不属于自己的节点
+	conn, err := net.Dial("tcp", addr1)
+	assert.NoError(t, err)
+	defer conn.Close()
+	connect := &mqtt.ConnectPacket{
+		FixedHeader:     mqtt.FixedHeader{Type: mqtt.CONNECT},
+		ProtocolVersion: mqtt.Version5,
+		CleanStart:      true,
+		KeepAlive:       30,
+		ClientID:        uids[1] + "-device",
+		UsernameFlag:    true,
+		Username:        uids[1],
+	}
+	err = connect.Encode(conn)
+	assert.NoError(t, err)
+	connack, ok := mqttTestRead(t, conn, mqtt.Version5).(*mqtt.ConnackPacket)
+	assert.True(t, ok)
+	assert.Equal(t, mqtt.UseAnotherServer, connack.ReasonCode)
+
+	conn1 := mqttTestConnect(t, addr1, uids[0], mqtt.Version5)
+	defer conn1.Close()
+	conn2 := mqttTestConnect(t, addr2, uids[1], mqtt.Version5)
+	defer conn2.Close()
+
+	// 节点1的用户发送给节点2的用户
+	publish := &mqtt.PublishPacket{
+		FixedHeader: mqtt.FixedHeader{Type: mqtt.PUBLISH, Version: mqtt.Version5},
+		TopicName:   fmt.Sprintf("$channel/1/%s", uids[1]),
+		PacketID:    1,
+		Payload:     []byte("hello"),
+	}
+	publish.SetFlags(false, 1, false)
+	err = publish.Encode(conn1)
+	assert.NoError(t, err)
+
+	puback, ok := mqttTestRead(t, conn1, mqtt.Version5).(*mqtt.PubackPacket)
+	assert.True(t, ok)
+	assert.Equal(t, mqtt.Success, puback.ReasonCode)
+
+	recv, ok := mqttTestRead(t, conn2, mqtt.Version5).(*mqtt.PublishPacket)
+	assert.True(t, ok)
+	assert.Equal(t, fmt.Sprintf("$channel/1/%s", uids[0]), recv.TopicName)
+	assert.Equal(t, []byte("hello"), recv.Payload)
+	err = mqtt.NewPuback(mqtt.Version5, recv.PacketID, mqtt.Success).Encode(conn2)
+	assert.NoError(t, err)
+
+	// 节点2的用户回复节点1的用户
+	publish.TopicName = fmt.Sprintf("$channel/1/%s", uids[0])
+	publish.PacketID = 2
+	publish.Payload = []byte("world")
+	err = publish.Encode(conn2)
+	assert.NoError(t, err)
+
+	puback, ok = mqttTestRead(t, conn2, mqtt.Version5).(*mqtt.PubackPacket)
+	assert.True(t, ok)
+	assert.Equal(t, mqtt.Success, puback.ReasonCode)
+
+	recv, ok = mqttTestRead(t, conn1, mqtt.Version5).(*mqtt.PublishPacket)
+	assert.True(t, ok)
+	assert.Equal(t, fmt.Sprintf("$channel/1/%s", uids[1]), recv.TopicName)
+	assert.Equal(t, []byte("world"), recv.Payload)
+}
+
+// 数据频道的订阅者和发布者在不同节点，订阅登记在频道所在节点，发布的消息投递到订阅者所在节点
+func TestClusterDataChannel(t *testing.T) {
+	servers := clusterTestStart(t)
+	uids := clusterTestUIDs(t, servers[0])
+	topics := make([]string, 2)
+	for i := 0; topics[0] == "" || topics[1] == ""; i++ {
+		topic := fmt.Sprintf("sensor/%d", i)
+		nodeID, err := servers[0].clusterManager.NodeIDOfChannel(topic, okproto.ChannelTypeData)
+		assert.NoError(t, err)
+		if topics[nodeID-1] == "" {
+			topics[nodeID-1] = topic
+		}
+	}
+
+	conn1 := mqttTestConnect(t, servers[0].dispatch.engine.MQTTRealListenAddr().String(), uids[0], mqtt.Version5)
+	defer conn1.Close()
+	conn2 := mqttTestConnect(t, servers[1].dispatch.engine.MQTTRealListenAddr().String(), uids[1], mqtt.Version5)
+	defer conn2.Close()
+
+	// 两个用户都订阅分别在两个节点的主题（发布者也需要是订阅者）
+	subscriptions := []mqtt.Subscription{{TopicFilter: topics[0], QoS: 1}, {TopicFilter: topics[1], QoS: 1}}
+	for _, conn := range []net.Conn{conn1, conn2} {
+		subscribe := &mqtt.SubscribePacket{
+			FixedHeader:   mqtt.FixedHeader{Type: mqtt.SUBSCRIBE, Version: mqtt.Version5},
+			PacketID:      1,
+			Subscriptions: subscriptions,
+		}
+		err := subscribe.Encode(conn)
+		assert.NoError(t, err)
+		suback, ok := mqttTestRead(t, conn, mqtt.Version5).(*mqtt.SubackPacket)
+		assert.True(t, ok)
+		assert.Equal(t, []mqtt.ReasonCode{mqtt.GrantedQoS1, mqtt.GrantedQoS1}, suback.ReasonCodes)
+	}
+
+	for i, topic := range topics {
+		publish := &mqtt.PublishPacket{
+			FixedHeader: mqtt.FixedHeader{Type: mqtt.PUBLISH, Version: mqtt.Version5},
+			TopicName:   topic,
+			PacketID:    uint16(i + 2),
+			Payload:     []byte(topic),
+		}
+		publish.SetFlags(false, 1, false)
+		err := publish.Encode(conn1)
+		assert.NoError(t, err)
+		puback, ok := mqttTestRead(t, conn1, mqtt.Version5).(*mqtt.PubackPacket)
+		assert.True(t, ok)
+		assert.Equal(t, mqtt.Success, puback.ReasonCode)
+
+		recv, ok := mqttTestRead(t, conn2, mqtt.Version5).(*mqtt.PublishPacket)
+		if assert.True(t, ok, topic) {
+			assert.Equal(t, topic, recv.TopicName)
+			assert.Equal(t, []byte(topic), recv.Payload)
+			err = mqtt.NewPuback(mqtt.Version5, recv.PacketID, mqtt.Success).Encode(conn2)
+			assert.NoError(t, err)
+		}
+	}
+}
+
+// 频道在节点2，节点1的用户通过EVENT包撤回消息，事件投递给两个节点上的订阅者
+func TestClusterChannelEvent(t *testing.T) {
+	servers := clusterTestStart(t)
+	uids := clusterTestUIDs(t, servers[0])
+	channelID := ""
+	for i := 0; channelID == ""; i++ {
+		nodeID, err := servers[0].clusterManager.NodeIDOfChannel(fmt.Sprintf("group%d", i), okproto.ChannelTypeGroup)
+		assert.NoError(t, err)
+		if nodeID == 2 {
+			channelID = fmt.Sprintf("group%d", i)
+		}
+	}
+
+	// 通过节点1的api创建频道和发送消息（转发到频道所在节点）
+	apiURL := servers[0].opts.External.APIUrl
+	clusterTestPost(t, apiURL+"/channel", &ChannelCreateReq{
+		ChannelInfoReq: ChannelInfoReq{ChannelID: channelID, ChannelType: okproto.ChannelTypeGroup},
+		Subscribers:    uids,
+	}, nil)
+	var sendResult struct {
+		Data *MessageSendResp `json:"data"`
+	}
+	clusterTestPost(t, apiURL+"/message/send", &MessageSendReq{
+		FromUID:     uids[0],
+		ChannelID:   channelID,
+		ChannelType: okproto.ChannelTypeGroup,
+		Payload:     []byte("hello"),
+	}, &sendResult)
+	assert.NotNil(t, sendResult.Data)
+
+	conn1 := protoTestConnect(t, servers[0].dispatch.engine.TCPRealListenAddr().String(), uids[0])
+	defer conn1.Close()
+	conn2 := protoTestConnect(t, servers[1].dispatch.engine.TCPRealListenAddr().String(), uids[1])
+	defer conn2.Close()
+
+	// 不是自己发送的消息不能撤回（在频道所在节点按客户端的权限处理）
+	reasonCode := conn2.sendEvent(EventTypeMessageRevoke, &MessageRevokeReq{ChannelID: channelID, ChannelType: okproto.ChannelTypeGroup, MessageSeq: sendResult.Data.MessageSeq})
+	assert.Equal(t, okproto.ReasonNoPermission, reasonCode)
+
+	reasonCode = conn1.sendEvent(EventTypeMessageRevoke, &MessageRevokeReq{ChannelID: channelID, ChannelType: okproto.ChannelTypeGroup, MessageSeq: sendResult.Data.MessageSeq})
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+	for _, conn := range []*protoTestConn{conn1, conn2} {
+		event := conn.readEvent(EventTypeMessageRevoke)
+		if !assert.NotNil(t, event) {
+			continue
+		}
+		var revokeEvent messageRevokeEvent
+		assert.NoError(t, okutil.ReadJSONByByte(event.Data, &revokeEvent))
+		assert.Equal(t, channelID, revokeEvent.ChannelID)
+		assert.Equal(t, sendResult.Data.MessageSeq, revokeEvent.MessageSeq)
+		assert.Equal(t, uids[0], revokeEvent.Revoker)
+	}
+}
+
+// 启动两个节点的集群
+func clusterTestStart(t *testing.T) []*Server {
+	httpAddrs := []string{clusterTestFreeAddr(t), clusterTestFreeAddr(t)}
+	nodes := []string{fmt.Sprintf("1@http://%s", httpAddrs[0]), fmt.Sprintf("2@http://%s", httpAddrs[1])}
+	servers := make([]*Server, 0, len(httpAddrs))
+	for i, httpAddr := range httpAddrs {
+		vp := viper.New()
+		vp.Set("rootDir", t.TempDir())
+		vp.Set("addr", "tcp://127.0.0.1:0")
+		vp.Set("wsAddr", "ws://127.0.0.1:0")
+		vp.Set("mqttAddr", "tcp://127.0.0.1:0")
+		vp.Set("httpAddr", httpAddr)
+		vp.Set("external.apiUrl", fmt.Sprintf("http://%s", httpAddr))
+		vp.Set("cluster.on", true)
+		vp.Set("cluster.nodeID", i+1)
+		vp.Set("cluster.nodes", nodes)
+		vp.Set("cluster.secret", "secret")
+		vp.Set("cluster.heartbeatInterval", "100ms")
+		vp.Set("monitor.on", false)
+		vp.Set("demo.on", false)
+		opts := NewTestOptions()
+		opts.ConfigureWithViper(vp)
+		opts.DataDir = t.TempDir()
+		s := NewTestServer(opts)
+		err := s.Start()
+		assert.NoError(t, err)
+		t.Cleanup(func() { s.Stop() })
+		servers = append(servers, s)
+	}
+	assert.Eventually(t, func() bool {
+		return servers[0].clusterManager.NodeOnline(2) && servers[1].clusterManager.NodeOnline(1)
+	}, time.Second*5, time.Millisecond*50)
+	return servers
+}
+
+// 找到分别属于节点1和节点2的用户
+func clusterTestUIDs(t *testing.T, s *Server) []string {
+	uids := make([]string, 2)
+	for i := 0; uids[0] == "" || uids[1] == ""; i++ {
+		uid := fmt.Sprintf("uid%d", i)
+		nodeID, err := s.clusterManager.NodeIDOfUser(uid)
+		assert.NoError(t, err)
+		if uids[nodeID-1] == "" {
+			uids[nodeID-1] = uid
+		}
+	}
+	return uids
+}
+
+func clusterTestPost(t *testing.T, url string, req interface{}, resp interface{}) {
+	result, err := network.Post(url, []byte(okutil.ToJSON(req)), map[string]string{"Content-Type": "application/json"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, result.StatusCode, result.Body)
+	if resp != nil {
+		assert.NoError(t, okutil.ReadJSONByByte([]byte(result.Body), resp))
+	}
+}
+
+func clusterTestFreeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().String()
+}
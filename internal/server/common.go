@@ -9,6 +9,7 @@ import (
 	"github.com/samlau0508/imserver/pkg/oklog"
 	"github.com/samlau0508/imserver/pkg/oknet"
 	"github.com/samlau0508/imserver/pkg/okutil"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
 	"go.uber.org/zap"
 )
 
@@ -45,6 +46,55 @@ const (
 	aesIVKey  = "aesIV"
 )
 
+// EVENT包的事件类型
+const (
+	// EventTypeMessageRevoke 消息撤回
+	EventTypeMessageRevoke = "message.revoke"
+	// EventTypeMessageEdit 消息编辑
+	EventTypeMessageEdit = "message.edit"
+	// EventTypeMessageDelete 消息删除（c2s只能对自己删除，s2c通知删除标记）
+	EventTypeMessageDelete = "message.delete"
+	// EventTypeMessageRead 消息已读（c2s）
+	EventTypeMessageRead = "message.read"
+	// EventTypeMessageReceipt 消息回执（s2c）
+	EventTypeMessageReceipt = "message.receipt"
+	// EventTypeChannelSignal 频道信号（c2s和s2c，不存储只投递给在线的订阅者）
+	EventTypeChannelSignal = "channel.signal"
+	// EventTypePresenceSubscribe 订阅用户的在线状态（c2s）
+	EventTypePresenceSubscribe = "presence.subscribe"
+	// EventTypePresenceUnsubscribe 取消订阅用户的在线状态（c2s）
+	EventTypePresenceUnsubscribe = "presence.unsubscribe"
+	// EventTypePresence 用户的在线状态（s2c，订阅后推送当前状态，之后用户的设备上线或下线时推送最新状态）
+	EventTypePresence = "presence"
+	// EventTypeReactionAdd 添加消息回应（c2s和s2c）
+	EventTypeReactionAdd = "reaction.add"
+	// EventTypeReactionRemove 移除消息回应（c2s和s2c）
+	EventTypeReactionRemove = "reaction.remove"
+	// EventTypeConversationUpdate 会话的未读数量有变化（s2c，通知用户的其他设备更新会话列表）
+	EventTypeConversationUpdate = "conversation.update"
+)
+
+// 频道信号
+const (
+	// ChannelSignalTyping 正在输入
+	ChannelSignalTyping = "typing"
+	// ChannelSignalRecording 正在录音
+	ChannelSignalRecording = "recording"
+
+	channelSignalMaxLen      = 64   // 信号类型的最大长度
+	channelSignalDataMaxSize = 1024 // 信号数据的最大字节数
+)
+
+const reactionEmojiMaxLen = 64 // 回应表情的最大长度
+
+// 置顶消息
+const (
+	// ContentTypeMessagePin 置顶或取消置顶消息时发送到频道的系统通知的消息类型（payload里的type）
+	ContentTypeMessagePin = 1101
+	MessagePinActionPin   = "pin"   // 置顶
+	MessagePinActionUnpin = "unpin" // 取消置顶
+)
+
 // GetFakeChannelIDWith GetFakeChannelIDWith
 func GetFakeChannelIDWith(fromUID, toUID string) string {
 	// TODO：这里可能会出现相等的情况 ，如果相等可以截取一部分再做hash直到不相等，后续完善
@@ -68,6 +118,18 @@ func GetFromUIDAndToUIDWith(channelID string) (string, string) {
 	return "", ""
 }
 
+// 获取指定用户看到的频道ID（个人频道的时候为对方的uid）
+func getChannelIDForUID(fakeChannelID string, channelType uint8, uid string) string {
+	if channelType != okproto.ChannelTypePerson {
+		return fakeChannelID
+	}
+	uid1, uid2 := GetFromUIDAndToUIDWith(fakeChannelID)
+	if uid1 == uid {
+		return uid2
+	}
+	return uid1
+}
+
 // GetCommunityTopicParentChannelID 获取社区话题频道的父频道ID
 func GetCommunityTopicParentChannelID(channelID string) string {
 	channelIDs := strings.Split(channelID, "@")
@@ -77,6 +139,24 @@ func GetCommunityTopicParentChannelID(channelID string) string {
 	return ""
 }
 
+// GetThreadChannelID 获取社区频道消息的子区频道ID（子区频道是话题为被回复消息序号的社区话题频道）
+func GetThreadChannelID(channelID string, rootMessageSeq uint32) string {
+	return fmt.Sprintf("%s@%d", channelID, rootMessageSeq)
+}
+
+// 解析子区频道ID，返回社区频道ID和被回复的消息序号，话题不是消息序号的返回false
+func parseThreadChannelID(channelID string) (string, uint32, bool) {
+	channelIDs := strings.Split(channelID, "@")
+	if len(channelIDs) != 2 || channelIDs[0] == "" {
+		return "", 0, false
+	}
+	rootMessageSeq, err := strconv.ParseUint(channelIDs[1], 10, 32)
+	if err != nil || rootMessageSeq == 0 {
+		return "", 0, false
+	}
+	return channelIDs[0], uint32(rootMessageSeq), true
+}
+
 var (
 	ErrChannelNotFound = fmt.Errorf("channel not found")
 	ErrParamInvalid    = fmt.Errorf("param invalid")
@@ -96,6 +176,20 @@ func encryptMessagePayload(payload []byte, conn oknet.Conn) ([]byte, error) {
 	return payloadEnc, nil
 }
 
+// 解密消息
+func decryptMessagePayload(payloadEnc []byte, conn oknet.Conn) ([]byte, error) {
+	var (
+		aesKey = conn.Value(aesKeyKey).(string)
+		aesIV  = conn.Value(aesIVKey).(string)
+	)
+	// 解密payload
+	payload, err := okutil.AesDecryptPkcs7Base64(payloadEnc, []byte(aesKey), []byte(aesIV))
+	if err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
 func makeMsgKey(signStr string, conn oknet.Conn) (string, error) {
 	var (
 		aesKey = conn.Value(aesKeyKey).(string)
@@ -10,6 +10,7 @@ import (
 	"github.com/robfig/cron/v3"
 	"github.com/samlau0508/imserver/pkg/keylock"
 	"github.com/samlau0508/imserver/pkg/oklog"
+	"github.com/samlau0508/imserver/pkg/oknet"
 	"github.com/samlau0508/imserver/pkg/okstore"
 	"github.com/samlau0508/imserver/pkg/okutil"
 	okproto "github.com/samlau0508/imserver/pkg/proto"
@@ -18,8 +19,9 @@ import (
 
 // ConversationManager ConversationManager
 type ConversationManager struct {
-	channelLock *keylock.KeyLock
-	s           *Server
+	channelLock      *keylock.KeyLock
+	conversationLock *keylock.KeyLock // 修改用户某个最近会话的锁（缓存里的会话不直接修改，修改副本后替换）
+	s                *Server
 	oklog.Log
 	queue                          *Queue
 	userConversationMapBuckets     []map[string]*lru.Cache[string, *okstore.Conversation]
@@ -40,6 +42,7 @@ func NewConversationManager(s *Server) *ConversationManager {
 		bucketNum:               10,
 		Log:                     oklog.NewOKLog("ConversationManager"),
 		channelLock:             keylock.NewKeyLock(),
+		conversationLock:        keylock.NewKeyLock(),
 		needSaveConversationMap: map[string]bool{},
 		stopChan:                make(chan struct{}),
 		calcChan:                make(chan interface{}),
@@ -73,6 +76,7 @@ func NewConversationManager(s *Server) *ConversationManager {
 func (cm *ConversationManager) Start() {
 	if cm.s.opts.Conversation.On {
 		cm.channelLock.StartCleanLoop()
+		cm.conversationLock.StartCleanLoop()
 		go cm.saveloop()
 		go cm.calcLoop()
 		cm.crontab.Start()
@@ -85,6 +89,7 @@ func (cm *ConversationManager) Stop() {
 	if cm.s.opts.Conversation.On {
 		close(cm.stopChan)
 		cm.channelLock.StopCleanLoop()
+		cm.conversationLock.StopCleanLoop()
 		// Wait for the queue to complete
 		cm.queue.Wait()
 
@@ -179,53 +184,133 @@ func (cm *ConversationManager) PushMessage(message *Message, subscribers []strin
 	})
 }
 
-// SetConversationUnread set unread data from conversation
+// SetConversationUnread set unread data from conversation（同时更新数据版本并通知用户的所有设备）
 func (cm *ConversationManager) SetConversationUnread(uid string, channelID string, channelType uint8, unread int, messageSeq uint32) error {
-	conversationCache := cm.getUserConversationCache(uid)
-	for _, key := range conversationCache.Keys() {
-		conversation, _ := conversationCache.Get(key)
-		if channelID == conversation.ChannelID && channelType == conversation.ChannelType {
-			conversation.UnreadCount = unread
-			if messageSeq > 0 {
-				conversation.LastMsgSeq = messageSeq
-			}
-			cm.setNeedSave(uid)
-			return nil
+	conversation, err := cm.updateConversation(uid, channelID, channelType, func(conversation *okstore.Conversation) bool {
+		conversation.UnreadCount = unread
+		if messageSeq > 0 {
+			conversation.LastMsgSeq = messageSeq
 		}
-	}
-	conversation, err := cm.s.store.GetConversation(uid, channelID, channelType)
+		conversation.Version = time.Now().UnixNano() / 1e6
+		return true
+	})
 	if err != nil {
 		return err
 	}
 	if conversation != nil {
-		conversation.UnreadCount = unread
-		if messageSeq > 0 {
-			conversation.LastMsgSeq = messageSeq
-		}
-		conversationCache.Add(cm.getChannelKey(conversation.ChannelID, conversation.ChannelType), conversation)
-		cm.setNeedSave(uid)
+		cm.notifyConversationUpdate(uid, conversation, 0)
 	}
 	return nil
 }
 
-func (cm *ConversationManager) GetConversation(uid string, channelID string, channelType uint8) *okstore.Conversation {
-
-	conversations := cm.getUserCacheConversations(uid)
-	if len(conversations) > 0 {
-		for _, conversation := range conversations {
-			if conversation.ChannelID == channelID && conversation.ChannelType == channelType {
-				return conversation
-			}
+// ReadConversation 用户在某个设备上已读到messageSeq（包含），更新会话的未读数量和数据版本并通知用户的其他设备
+// 未读数量不会超过messageSeq之后的消息数量，上报的已读位置比之前的旧时不会有变化；服务端不维护的会话（比如超大群）忽略
+func (cm *ConversationManager) ReadConversation(uid string, channelID string, channelType uint8, messageSeq uint32, fromConnID int64) {
+	if !cm.s.opts.Conversation.On {
+		return
+	}
+	conversation, err := cm.updateConversation(uid, channelID, channelType, func(conversation *okstore.Conversation) bool {
+		unread := 0
+		if messageSeq < conversation.LastMsgSeq {
+			unread = int(conversation.LastMsgSeq - messageSeq)
+		}
+		if unread >= conversation.UnreadCount {
+			return false
 		}
+		conversation.UnreadCount = unread
+		conversation.Version = time.Now().UnixNano() / 1e6
+		return true
+	})
+	if err != nil {
+		cm.Error("查询最近会话失败！", zap.Error(err), zap.String("uid", uid), zap.String("channelID", channelID), zap.Uint8("channelType", channelType))
+		return
 	}
+	if conversation != nil {
+		cm.notifyConversationUpdate(uid, conversation, fromConnID)
+	}
+}
 
-	conversation, err := cm.s.store.GetConversation(uid, channelID, channelType)
+// updateConversation 修改用户的最近会话，update返回false表示不需要修改，返回修改后的会话（没有会话或没有修改返回nil）
+// 持久化时会读取缓存里的会话，所以修改的是会话的副本，修改后替换缓存里的会话
+func (cm *ConversationManager) updateConversation(uid string, channelID string, channelType uint8, update func(conversation *okstore.Conversation) bool) (*okstore.Conversation, error) {
+	lockKey := cm.getUserChannelKey(uid, channelID, channelType)
+	cm.conversationLock.Lock(lockKey)
+	defer cm.conversationLock.Unlock(lockKey)
+
+	conversation, err := cm.getConversation(uid, channelID, channelType)
+	if err != nil || conversation == nil {
+		return nil, err
+	}
+	newConversation := *conversation
+	if !update(&newConversation) {
+		return nil, nil
+	}
+	cm.AddOrUpdateConversation(uid, &newConversation)
+	return &newConversation, nil
+}
+
+// 通知用户在线的设备会话有变化 excludeConnID为不需要通知的连接（比如上报已读的连接）
+func (cm *ConversationManager) notifyConversationUpdate(uid string, conversation *okstore.Conversation, excludeConnID int64) {
+	event := newSyncUserConversationResp(conversation)
+	cm.s.deliveryManager.startDeliveryEvent([]string{uid}, EventTypeConversationUpdate, func(conn oknet.Conn) interface{} {
+		if excludeConnID != 0 && conn.ID() == excludeConnID {
+			return nil
+		}
+		return event
+	})
+}
+
+func (cm *ConversationManager) GetConversation(uid string, channelID string, channelType uint8) *okstore.Conversation {
+	conversation, err := cm.getConversation(uid, channelID, channelType)
 	if err != nil {
 		cm.Error("查询最近会话失败！", zap.Error(err), zap.String("uid", uid), zap.String("channelID", channelID), zap.Uint8("channelType", channelType))
 	}
-
 	return conversation
+}
 
+// 获取用户的最近会话，缓存里没有则从存储获取
+func (cm *ConversationManager) getConversation(uid string, channelID string, channelType uint8) (*okstore.Conversation, error) {
+	channelKey := cm.getChannelKey(channelID, channelType)
+	conversationCache := cm.getUserConversationCache(uid)
+	cm.channelLock.Lock(channelKey)
+	conversation, _ := conversationCache.Get(channelKey)
+	cm.channelLock.Unlock(channelKey)
+	if conversation != nil {
+		return conversation, nil
+	}
+	return cm.s.store.GetConversation(uid, channelID, channelType)
+}
+
+// UpdateConversationVersionOfMessage 如果用户最近会话的最后一条消息为指定的消息，则更新最近会话的数据版本（消息被撤回或编辑后客户端能增量同步到）
+func (cm *ConversationManager) UpdateConversationVersionOfMessage(uids []string, fakeChannelID string, channelType uint8, messageSeq uint32) {
+	cm.updateConversationVersionIf(uids, fakeChannelID, channelType, func(conversation *okstore.Conversation) bool {
+		return conversation.LastMsgSeq == messageSeq
+	})
+}
+
+// UpdateConversationVersionOfDelete 如果用户最近会话的最后一条消息被删除，则更新最近会话的数据版本（客户端能增量同步到新的最后一条消息）
+func (cm *ConversationManager) UpdateConversationVersionOfDelete(uids []string, fakeChannelID string, channelType uint8, marker *okstore.MessageDeleteMarker) {
+	cm.updateConversationVersionIf(uids, fakeChannelID, channelType, func(conversation *okstore.Conversation) bool {
+		return marker.Deleted(conversation.LastMsgSeq, conversation.LastMsgID)
+	})
+}
+
+func (cm *ConversationManager) updateConversationVersionIf(uids []string, fakeChannelID string, channelType uint8, match func(conversation *okstore.Conversation) bool) {
+	if !cm.s.opts.Conversation.On || len(uids) == 0 {
+		return
+	}
+	for _, uid := range uids {
+		_, err := cm.updateConversation(uid, getChannelIDForUID(fakeChannelID, channelType, uid), channelType, func(conversation *okstore.Conversation) bool {
+			if !match(conversation) {
+				return false
+			}
+			conversation.Version = time.Now().UnixNano() / 1e6
+			return true
+		})
+		if err != nil {
+			cm.Error("查询最近会话失败！", zap.Error(err), zap.String("uid", uid), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channelType))
+		}
+	}
 }
 
 // DeleteConversation 删除最近会话
@@ -316,21 +401,25 @@ func (cm *ConversationManager) flushUserConversations(uid string) {
 func (cm *ConversationManager) getUserConversationCache(uid string) *lru.Cache[string, *okstore.Conversation] {
 	pos := int(okutil.HashCrc32(uid) % uint32(cm.bucketNum))
 	cm.userConversationMapBucketLocks[pos].RLock()
+	cache := cm.userConversationMapBuckets[pos][uid]
+	cm.userConversationMapBucketLocks[pos].RUnlock()
+	if cache != nil {
+		return cache
+	}
+
+	// 同一个桶里的用户共用一个map，创建时需要桶的写锁
+	cm.userConversationMapBucketLocks[pos].Lock()
+	defer cm.userConversationMapBucketLocks[pos].Unlock()
 	userConversationMap := cm.userConversationMapBuckets[pos]
 	if userConversationMap == nil {
 		userConversationMap = make(map[string]*lru.Cache[string, *okstore.Conversation])
 		cm.userConversationMapBuckets[pos] = userConversationMap
 	}
-
-	cm.userConversationMapBucketLocks[pos].RUnlock()
-	cm.channelLock.Lock(uid)
-	cache := userConversationMap[uid]
+	cache = userConversationMap[uid]
 	if cache == nil {
 		cache = cm.newLRUCache()
 		userConversationMap[uid] = cache
 	}
-	cm.channelLock.Unlock(uid)
-
 	return cache
 }
 
@@ -371,6 +460,10 @@ func (cm *ConversationManager) calConversation(message *Message, subscriber stri
 	}
 	channelKey := cm.getChannelKey(channelID, message.ChannelType)
 
+	lockKey := cm.getUserChannelKey(subscriber, channelID, message.ChannelType)
+	cm.conversationLock.Lock(lockKey)
+	defer cm.conversationLock.Unlock(lockKey)
+
 	cm.channelLock.Lock(channelKey)
 	conversation, _ := conversationCache.Get(channelKey)
 	cm.channelLock.Unlock(channelKey)
@@ -402,6 +495,8 @@ func (cm *ConversationManager) calConversation(message *Message, subscriber stri
 		}
 		modify = true
 	} else {
+		newConversation := *conversation // 缓存里的会话不直接修改
+		conversation = &newConversation
 
 		if message.RedDot && message.FromUID != subscriber { //  message.FromUID != subscriber 自己发的消息不显示红点
 			conversation.UnreadCount++
@@ -493,6 +588,10 @@ func (cm *ConversationManager) setNeedSave(uid string) {
 	cm.needSaveChan <- uid
 }
 
+func (cm *ConversationManager) getUserChannelKey(uid string, channelID string, channelType uint8) string {
+	return fmt.Sprintf("%s@%s", uid, cm.getChannelKey(channelID, channelType))
+}
+
 func (cm *ConversationManager) getChannelKey(channelID string, channelType uint8) string {
 	return fmt.Sprintf("%s-%d", channelID, channelType)
 }
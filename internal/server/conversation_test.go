@@ -4,14 +4,18 @@ import (
 	"testing"
 	"time"
 
+	"github.com/samlau0508/imserver/pkg/okstore"
 	okproto "github.com/samlau0508/imserver/pkg/proto"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestGetConversations(t *testing.T) {
 	opts := NewTestOptions()
+	opts.DataDir = t.TempDir()
 	opts.Conversation.SyncOnce = 0
 	l := NewTestServer(opts)
+	err := l.store.Open()
+	assert.NoError(t, err)
 	cm := NewConversationManager(l)
 	cm.Start()
 
@@ -54,3 +58,63 @@ func TestGetConversations(t *testing.T) {
 	cm.s.store.Close()
 
 }
+
+func TestReadConversation(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	opts.Conversation.SyncOnce = 0
+	l := NewTestServer(opts)
+	cm := NewConversationManager(l)
+	cm.Start()
+
+	defer cm.Stop()
+	for seq := uint32(1); seq <= 5; seq++ {
+		cm.PushMessage(&Message{
+			RecvPacket: &okproto.RecvPacket{
+				Framer: okproto.Framer{
+					RedDot: true,
+				},
+				MessageID:   int64(seq),
+				MessageSeq:  seq,
+				ChannelID:   "group1",
+				ChannelType: 2,
+				FromUID:     "sender",
+				Timestamp:   int32(time.Now().Unix()),
+				Payload:     []byte("hello"),
+			},
+		}, []string{"test"})
+	}
+	time.Sleep(time.Millisecond * 100) // wait calc conversation
+
+	conversation := cm.GetConversation("test", "group1", 2)
+	assert.NotNil(t, conversation)
+	assert.Equal(t, 5, conversation.UnreadCount)
+	version := conversation.Version
+
+	// 已读到第3条
+	time.Sleep(time.Millisecond * 2)
+	cm.ReadConversation("test", "group1", 2, 3, 0)
+	conversation = cm.GetConversation("test", "group1", 2)
+	assert.Equal(t, 2, conversation.UnreadCount)
+	assert.Greater(t, conversation.Version, version)
+	version = conversation.Version
+
+	// 其他设备上报的旧的已读位置不影响未读数量
+	cm.ReadConversation("test", "group1", 2, 1, 0)
+	conversation = cm.GetConversation("test", "group1", 2)
+	assert.Equal(t, 2, conversation.UnreadCount)
+	assert.Equal(t, version, conversation.Version)
+
+	// 增量同步能同步到已读后的会话
+	conversations := cm.GetConversations("test", version-1, nil)
+	assert.Equal(t, 1, len(conversations))
+
+	cm.ReadConversation("test", "group1", 2, 5, 0)
+	assert.Equal(t, 0, cm.GetConversation("test", "group1", 2).UnreadCount)
+
+	// 没有的会话忽略
+	cm.ReadConversation("test", "group2", 2, 5, 0)
+	assert.Nil(t, cm.GetConversation("test", "group2", 2))
+
+	cm.s.store.Close()
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -9,6 +10,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/samlau0508/imserver/pkg/oklog"
 	"github.com/samlau0508/imserver/pkg/oknet"
+	"github.com/samlau0508/imserver/pkg/okstore"
+	"github.com/samlau0508/imserver/pkg/okutil"
 	okproto "github.com/samlau0508/imserver/pkg/proto"
 	"go.uber.org/zap"
 )
@@ -93,26 +96,11 @@ func (d *DeliveryManager) deliveryMessages(messages []*Message, large bool, sync
 				if !cloneMsg.NoPersist { // 需要存储的消息才进行重试
 					d.s.retryQueue.startInFlightTimeout(cloneMsg)
 				}
-				recvPacket := cloneMsg.RecvPacket
-				if subscriber == recvPacket.FromUID { // 如果是自己则不显示红点
-					recvPacket.RedDot = false
-				}
-				payloadEnc, err := encryptMessagePayload(recvPacket.Payload, recvConn)
-				if err != nil {
-					d.Error("加密payload失败！", zap.Error(err))
-					continue
-				}
-				recvPacket.Payload = payloadEnc
-
-				signStr := recvPacket.VerityString()
-				msgKey, err := makeMsgKey(signStr, recvConn)
+				recvPacket, err := d.makeRecvPacket(cloneMsg, recvConn)
 				if err != nil {
-					d.Error("生成MsgKey失败！", zap.Error(err))
 					continue
 				}
-				recvPacket.MsgKey = msgKey
-
-				recvPackets = append(recvPackets, cloneMsg.RecvPacket)
+				recvPackets = append(recvPackets, recvPacket)
 			}
 			d.s.dispatch.dataOut(recvConn, recvPackets...)
 			cost := time.Since(startTime)
@@ -154,6 +142,10 @@ func (d *DeliveryManager) retryDeliveryMsg(msg *Message) {
 		d.Debug("超过最大重试次数！", zap.Int64("messageID", msg.MessageID), zap.Int("messageMaxRetryCount", d.s.opts.MessageRetry.MaxCount))
 		return
 	}
+	if okstore.MessageExpired(msg, time.Now().Unix()) {
+		d.Debug("消息已过期，不再重试！", zap.Int64("messageID", msg.MessageID), zap.Int32("msgTimestamp", msg.Timestamp), zap.Uint32("expire", msg.Expire))
+		return
+	}
 	recvConn := d.getRecvConn(msg.ToUID, msg.toDeviceID)
 	if recvConn == nil {
 		d.Debug("用户设备没在线，重试消息结束！", zap.String("uid", msg.ToUID), zap.Int32("msgTimestamp", msg.Timestamp), zap.Int64("messageID", msg.MessageID), zap.String("channelID", msg.ChannelID), zap.Uint8("channelType", msg.ChannelType), zap.String("toDeviceID", msg.toDeviceID))
@@ -163,13 +155,185 @@ func (d *DeliveryManager) retryDeliveryMsg(msg *Message) {
 	if msg.ChannelType == okproto.ChannelTypePerson && msg.ChannelID == msg.ToUID {
 		channelID = msg.FromUID
 	}
-	recvPacket := msg.RecvPacket
-	recvPacket.ChannelID = channelID
+	msg.RecvPacket.ChannelID = channelID
 
 	d.s.retryQueue.startInFlightTimeout(msg)
+	recvPacket, err := d.makeRecvPacket(msg, recvConn)
+	if err != nil {
+		return
+	}
 	d.s.dispatch.dataOut(recvConn, recvPacket)
 }
 
+// makeRecvPacket 生成投递给指定连接的RECV包（payload按连接加密）
+// 不修改消息本身，重试的时候按当前连接重新加密（设备重新连接后密钥会变）
+func (d *DeliveryManager) makeRecvPacket(msg *Message, recvConn oknet.Conn) (*okproto.RecvPacket, error) {
+	recvPacket := *msg.RecvPacket
+	if msg.ToUID == recvPacket.FromUID { // 如果是自己则不显示红点
+		recvPacket.RedDot = false
+	}
+	payloadEnc, err := encryptMessagePayload(recvPacket.Payload, recvConn)
+	if err != nil {
+		d.Error("加密payload失败！", zap.Error(err))
+		return nil, err
+	}
+	recvPacket.Payload = payloadEnc
+
+	signStr := recvPacket.VerityString()
+	msgKey, err := makeMsgKey(signStr, recvConn)
+	if err != nil {
+		d.Error("生成MsgKey失败！", zap.Error(err))
+		return nil, err
+	}
+	recvPacket.MsgKey = msgKey
+	return &recvPacket, nil
+}
+
+// startDeliveryEvent 投递事件给在线的订阅者（事件不存储也不重试，只投递给支持EVENT包的连接）
+// dataFnc 返回指定连接的事件数据（比如需要按连接加密的内容），返回nil则不投递给此连接
+func (d *DeliveryManager) startDeliveryEvent(subscribers []string, eventType string, dataFnc func(conn oknet.Conn) interface{}) {
+	err := d.deliveryMsgPool.Submit(func() {
+		d.deliveryEvent(subscribers, eventType, dataFnc)
+	})
+	if err != nil {
+		d.Error("开始事件投递失败！", zap.Error(err))
+	}
+}
+
+func (d *DeliveryManager) deliveryEvent(subscribers []string, eventType string, dataFnc func(conn oknet.Conn) interface{}) {
+	timestamp := time.Now().UnixNano() / 1e6
+	for _, subscriber := range subscribers {
+		toConns := d.s.connManager.GetConnsWithUID(subscriber)
+		for _, conn := range toConns {
+			if conn.ProtoVersion() < okproto.EventMinVersion {
+				continue
+			}
+			data := dataFnc(conn)
+			if data == nil {
+				continue
+			}
+			d.s.dispatch.dataOut(conn, &okproto.EventPacket{
+				ID:        okutil.GenUUID(),
+				Type:      eventType,
+				Timestamp: timestamp,
+				Data:      []byte(okutil.ToJSON(data)),
+			})
+		}
+	}
+}
+
+// channelEvent 频道的事件（撤回、编辑、删除、回执、回应等）
+type channelEvent interface {
+	// forConn 生成投递给指定连接的事件数据（频道ID转换为接收者视角，需要加密的内容按连接加密）
+	forConn(fakeChannelID string, channelType uint8, conn oknet.Conn) (interface{}, error)
+}
+
+// 按事件类型解码其他节点转发过来的频道事件
+func decodeChannelEvent(eventType string, data []byte) (channelEvent, error) {
+	var event channelEvent
+	switch eventType {
+	case EventTypeMessageRevoke:
+		event = &messageRevokeEvent{}
+	case EventTypeMessageEdit:
+		event = &messageEditEvent{}
+	case EventTypeMessageDelete:
+		event = &messageDeleteEvent{}
+	case EventTypeMessageReceipt:
+		event = &messageReceiptEvent{}
+	case EventTypeReactionAdd, EventTypeReactionRemove:
+		event = &ReactionResp{}
+	default:
+		return nil, fmt.Errorf("不支持的频道事件类型！[%s]", eventType)
+	}
+	if err := okutil.ReadJSONByByte(data, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// startDeliveryChannelEvent 投递频道的事件给在线的订阅者，集群模式下其他节点的订阅者交给所在节点投递
+func (d *DeliveryManager) startDeliveryChannelEvent(subscribers []string, fakeChannelID string, channelType uint8, eventType string, event channelEvent) {
+	localSubscribers, remoteSubscriberMap := d.s.clusterManager.SplitUIDsByNode(subscribers)
+	if len(remoteSubscriberMap) > 0 {
+		err := d.deliveryMsgPool.Submit(func() {
+			d.deliveryChannelEventToRemoteNodes(remoteSubscriberMap, fakeChannelID, channelType, eventType, event)
+		})
+		if err != nil {
+			d.Error("开始事件转发失败！", zap.Error(err))
+		}
+	}
+	d.startDeliveryLocalChannelEvent(localSubscribers, fakeChannelID, channelType, eventType, event)
+}
+
+// startDeliveryLocalChannelEvent 投递频道的事件给本节点在线的订阅者
+func (d *DeliveryManager) startDeliveryLocalChannelEvent(subscribers []string, fakeChannelID string, channelType uint8, eventType string, event channelEvent) {
+	if len(subscribers) == 0 {
+		return
+	}
+	d.startDeliveryEvent(subscribers, eventType, func(conn oknet.Conn) interface{} {
+		data, err := event.forConn(fakeChannelID, channelType, conn)
+		if err != nil {
+			d.Warn("生成事件数据失败！", zap.Error(err), zap.String("eventType", eventType), zap.String("uid", conn.UID()), zap.String("deviceID", conn.DeviceID()))
+			return nil
+		}
+		return data
+	})
+}
+
+// 将频道事件交给其他节点投递给其节点上的订阅者（投递失败只记录日志，不影响本节点的投递）
+func (d *DeliveryManager) deliveryChannelEventToRemoteNodes(remoteSubscriberMap map[int64][]string, fakeChannelID string, channelType uint8, eventType string, event channelEvent) {
+	data := []byte(okutil.ToJSON(event))
+	for nodeID, subscribers := range remoteSubscriberMap {
+		err := d.s.clusterManager.DeliverEvent(nodeID, &clusterEventDeliverReq{
+			ChannelID:   fakeChannelID,
+			ChannelType: channelType,
+			Subscribers: subscribers,
+			Type:        eventType,
+			Data:        data,
+		})
+		if err != nil {
+			d.Error("转发事件到订阅者所在节点失败！", zap.Error(err), zap.Int64("nodeID", nodeID), zap.String("eventType", eventType), zap.Int("subscriberCount", len(subscribers)))
+		}
+	}
+}
+
+// startDeliverySignal 投递频道信号给在线的订阅者（发送者自己的连接不投递）
+func (d *DeliveryManager) startDeliverySignal(subscribers []string, fromUID string, fakeChannelID string, channelType uint8, signal string, data json.RawMessage) {
+	err := d.deliveryMsgPool.Submit(func() {
+		d.deliverySignal(subscribers, fromUID, fakeChannelID, channelType, signal, data)
+	})
+	if err != nil {
+		d.Error("开始信号投递失败！", zap.Error(err))
+	}
+}
+
+func (d *DeliveryManager) deliverySignal(subscribers []string, fromUID string, fakeChannelID string, channelType uint8, signal string, data json.RawMessage) {
+	toUIDs := make([]string, 0, len(subscribers))
+	for _, subscriber := range subscribers {
+		if subscriber != fromUID {
+			toUIDs = append(toUIDs, subscriber)
+		}
+	}
+	timestamp := time.Now().UnixNano() / 1e6
+	for _, conn := range d.s.connManager.GetOnlineConns(toUIDs) {
+		if conn.ProtoVersion() < okproto.EventMinVersion {
+			continue
+		}
+		d.s.dispatch.dataOut(conn, &okproto.EventPacket{
+			ID:        okutil.GenUUID(),
+			Type:      EventTypeChannelSignal,
+			Timestamp: timestamp,
+			Data: []byte(okutil.ToJSON(&channelSignalEvent{
+				ChannelID:   getChannelIDForUID(fakeChannelID, channelType, conn.UID()),
+				ChannelType: channelType,
+				FromUID:     fromUID,
+				Signal:      signal,
+				Data:        data,
+			})),
+		})
+	}
+}
+
 // get recv
 func (d *DeliveryManager) getRecvConns(subscriber string, fromUID string, fromDeivceFlag okproto.DeviceFlag, fromDeviceID string) []oknet.Conn {
 	toConns := d.s.connManager.GetConnsWithUID(subscriber)
@@ -22,7 +22,7 @@ type Dispatch struct {
 
 func NewDispatch(s *Server) *Dispatch {
 	return &Dispatch{
-		engine:    oknet.NewEngine(oknet.WithAddr(s.opts.Addr), oknet.WithWSAddr(s.opts.WSAddr), oknet.WithWSSAddr(s.opts.WSSAddr), oknet.WithWSTLSConfig(s.opts.WSTLSConfig)),
+		engine:    oknet.NewEngine(oknet.WithAddr(s.opts.Addr), oknet.WithWSAddr(s.opts.WSAddr), oknet.WithWSSAddr(s.opts.WSSAddr), oknet.WithMQTTAddr(s.opts.MQTTAddr), oknet.WithWSTLSConfig(s.opts.WSTLSConfig), oknet.WithTCPTLSConfig(s.opts.TCPTLSConfig)),
 		s:         s,
 		processor: NewProcessor(s),
 		Log:       oklog.NewOKLog("Dispatch"),
@@ -57,6 +57,10 @@ func (d *Dispatch) dataIn(conn oknet.Conn) error {
 		return nil
 	}
 
+	if mqttConn, ok := conn.(*oknet.MQTTConn); ok { // mqtt连接
+		return d.mqttDataIn(mqttConn)
+	}
+
 	buff, err := conn.Peek(-1)
 	if err != nil {
 		return err
@@ -135,6 +139,11 @@ func (d *Dispatch) dataOut(conn oknet.Conn, frames ...okproto.Frame) {
 	d.s.outMsgs.Add(int64(len(frames)))
 	connStats.OutMsgs.Add(int64(len(frames)))
 
+	if mqttConn, ok := conn.(*oknet.MQTTConn); ok { // mqtt连接
+		d.mqttDataOut(mqttConn, frames...)
+		return
+	}
+
 	wsConn, wsok := conn.(oknet.IWSConn) // websocket连接
 	for _, frame := range frames {
 		data, err := d.s.opts.Proto.EncodeFrame(frame, uint8(conn.ProtoVersion()))
@@ -0,0 +1,981 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/keylock"
+	"github.com/samlau0508/imserver/pkg/oklog"
+	"github.com/samlau0508/imserver/pkg/okstore"
+	"github.com/samlau0508/imserver/pkg/okutil"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"go.uber.org/zap"
+)
+
+// MessageManager 消息的管理（系统发送、撤回、编辑、回执等）
+// 消息在topic里是追加写入的，对消息的变更通过消息扩展数据（MessageExtra）记录
+type MessageManager struct {
+	s         *Server
+	extraLock *keylock.KeyLock // 同一个频道的消息扩展数据串行修改
+	oklog.Log
+}
+
+// NewMessageManager NewMessageManager
+func NewMessageManager(s *Server) *MessageManager {
+	return &MessageManager{
+		s:         s,
+		extraLock: keylock.NewKeyLock(),
+		Log:       oklog.NewOKLog("MessageManager"),
+	}
+}
+
+// Start Start
+func (m *MessageManager) Start() {
+	m.extraLock.StartCleanLoop()
+}
+
+// Stop Stop
+func (m *MessageManager) Stop() {
+	m.extraLock.StopCleanLoop()
+}
+
+// SendMessageToChannel 以系统身份发送消息到频道（api发送的消息和置顶通知等），频道在其他节点则交给频道所在节点发送
+func (m *MessageManager) SendMessageToChannel(req MessageSendReq, channelID string, channelType uint8, clientMsgNo string, streamFlag okproto.StreamFlag) (int64, uint32, error) {
+	m.s.monitor.SendPacketInc(req.Header.NoPersist != 1)
+	m.s.monitor.SendSystemMsgInc()
+
+	var messageID = m.s.dispatch.processor.genMessageID()
+
+	fakeChannelID := channelID
+	if channelType == okproto.ChannelTypePerson && req.FromUID != "" {
+		fakeChannelID = GetFakeChannelIDWith(req.FromUID, channelID)
+	}
+
+	// 频道在其他节点则交给频道所在节点发送（临时频道只存在于创建它的节点）
+	if m.s.clusterManager.On() && !m.s.opts.IsTmpChannel(channelID) {
+		nodeID, err := m.s.clusterManager.NodeIDOfChannel(fakeChannelID, channelType)
+		if err != nil {
+			m.Error("获取频道所在节点失败！", zap.Error(err))
+			return 0, 0, errors.New("获取频道所在节点失败！")
+		}
+		if !m.s.clusterManager.IsLocal(nodeID) {
+			resp, err := m.s.clusterManager.SendMessage(nodeID, &clusterMessageSendReq{
+				Req:         req,
+				ChannelID:   channelID,
+				ChannelType: channelType,
+				ClientMsgNo: clientMsgNo,
+				StreamFlag:  uint8(streamFlag),
+			})
+			if err != nil {
+				m.Error("转发消息到频道所在节点失败！", zap.Error(err), zap.Int64("nodeID", nodeID))
+				return 0, 0, errors.New("转发消息到频道所在节点失败！")
+			}
+			return resp.MessageID, resp.MessageSeq, nil
+		}
+	}
+
+	// 获取频道
+	channel, err := m.s.channelManager.GetChannel(fakeChannelID, channelType)
+	if err != nil {
+		m.Error("查询频道信息失败！", zap.Error(err))
+		return 0, 0, errors.New("查询频道信息失败！")
+	}
+
+	if channel == nil {
+		return 0, 0, errors.New("频道信息不存在！")
+	}
+	if channel.Large && req.Header.SyncOnce == 1 {
+		m.Error("超大群不支持发送SyncOnce类型消息！", zap.String("channelID", channelID), zap.Uint8("channelType", channelType))
+		return 0, 0, errors.New("超大群不支持发送SyncOnce类型消息！")
+	}
+
+	// var messageSeq uint32
+	// if req.Header.NoPersist == 0 && req.Header.SyncOnce != 1 {
+	// 	messageSeq, err = m.l.store.GetNextMessageSeq(fakeChannelID, channelType)
+	// 	if err != nil {
+	// 		m.Error("获取频道消息序列号失败！", zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channelType), zap.Error(err))
+	// 		return errors.New("获取频道消息序列号失败！")
+	// 	}
+	// }
+	subscribers := req.Subscribers
+	if len(subscribers) > 0 {
+		subscribers = okutil.RemoveRepeatedElement(req.Subscribers)
+	}
+	var setting okproto.Setting
+	if len(strings.TrimSpace(req.StreamNo)) > 0 {
+		setting = setting.Set(okproto.SettingStream)
+	}
+
+	msg := &Message{
+		RecvPacket: &okproto.RecvPacket{
+			Framer: okproto.Framer{
+				RedDot:    okutil.IntToBool(req.Header.RedDot),
+				SyncOnce:  okutil.IntToBool(req.Header.SyncOnce),
+				NoPersist: okutil.IntToBool(req.Header.NoPersist),
+			},
+			Setting:     setting,
+			MessageID:   messageID,
+			ClientMsgNo: clientMsgNo,
+			StreamNo:    req.StreamNo,
+			StreamFlag:  streamFlag,
+			FromUID:     req.FromUID,
+			ChannelID:   channelID,
+			ChannelType: channelType,
+			Expire:      req.Expire,
+			Timestamp:   int32(time.Now().Unix()),
+			Payload:     req.Payload,
+		},
+		fromDeviceFlag: okproto.SYSTEM,
+		Subscribers:    subscribers,
+	}
+	messages := []okstore.Message{msg}
+	if !msg.NoPersist && !msg.SyncOnce && !m.s.opts.IsTmpChannel(channelID) {
+
+		if msg.StreamIng() {
+			streamSeq, err := m.s.store.AppendStreamItem(fakeChannelID, channelType, msg.StreamNo, &okstore.StreamItem{
+				ClientMsgNo: msg.ClientMsgNo,
+				Blob:        msg.Payload,
+			})
+			if err != nil {
+				m.Error("Failed to save stream message", zap.Error(err))
+				return 0, 0, errors.New("failed to save stream message")
+			}
+			msg.StreamSeq = streamSeq // stream seq
+		} else {
+			_, err = m.s.store.AppendMessages(fakeChannelID, channelType, messages)
+			if err != nil {
+				m.Error("Failed to save history message", zap.Error(err))
+				return 0, 0, errors.New("failed to save history message")
+			}
+			m.s.searchManager.IndexMessages(fakeChannelID, channelType, messages)
+			m.s.threadManager.AddReplies(fakeChannelID, channelType, messages)
+		}
+
+	}
+	if m.s.opts.WebhookOn() {
+		if !msg.StreamIng() && (!msg.NoPersist || !msg.SyncOnce) {
+			// Add a message to the notification queue, the data in this queue will be notified to third-party applications
+			err = m.s.store.AppendMessageOfNotifyQueue(messages)
+			if err != nil {
+				m.Error("添加消息到通知队列失败！", zap.Error(err))
+				return 0, 0, errors.New("添加消息到通知队列失败！")
+			}
+		}
+	}
+	// 将消息放入频道
+	err = channel.Put([]*Message{msg}, msg.Subscribers, req.FromUID, okproto.DeviceFlag(okproto.DeviceLevelMaster), "system")
+	if err != nil {
+		m.Error("将消息放入频道内失败！", zap.Error(err))
+		return 0, 0, errors.New("将消息放入频道内失败！")
+	}
+	return messageID, msg.MessageSeq, nil
+}
+
+// Revoke 撤回消息
+// fromClient 为true表示客户端发起的撤回，只能撤回自己发送的消息并且受撤回时间限制，api发起的撤回不做限制
+func (m *MessageManager) Revoke(req MessageRevokeReq, fromClient bool) (okproto.ReasonCode, error) {
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == okproto.ChannelTypePerson {
+		fakeChannelID = GetFakeChannelIDWith(req.UID, req.ChannelID)
+	}
+
+	message, reasonCode, err := m.loadMessage(fakeChannelID, req.ChannelType, req.MessageSeq, req.MessageID)
+	if err != nil || reasonCode != okproto.ReasonSuccess {
+		return reasonCode, err
+	}
+	if fromClient {
+		if message.FromUID != req.UID {
+			m.Warn("只能撤回自己发送的消息！", zap.String("uid", req.UID), zap.String("fromUID", message.FromUID), zap.Int64("messageID", message.MessageID))
+			return okproto.ReasonNoPermission, nil
+		}
+		revokeTimeout := m.s.opts.Message.RevokeTimeout
+		if revokeTimeout > 0 && time.Since(time.Unix(int64(message.Timestamp), 0)) > revokeTimeout {
+			return okproto.ReasonRevokeTimeout, nil
+		}
+	}
+
+	var extra *okstore.MessageExtra
+	reasonCode, err = m.updateMessageExtra(fakeChannelID, req.ChannelType, message, func(ext *okstore.MessageExtra) okproto.ReasonCode {
+		ext.Revoke = true
+		ext.Revoker = req.UID
+		extra = ext
+		return okproto.ReasonSuccess
+	})
+	if err != nil || reasonCode != okproto.ReasonSuccess {
+		return reasonCode, err
+	}
+
+	subscribers := m.getChannelSubscribers(fakeChannelID, req.ChannelType)
+	// 如果撤回的是最近会话的最后一条消息，需要更新最近会话的版本，让客户端能同步到撤回状态
+	m.s.conversationManager.UpdateConversationVersionOfMessage(subscribers, fakeChannelID, req.ChannelType, extra.MessageSeq)
+
+	// 通知在线的订阅者
+	m.s.deliveryManager.startDeliveryChannelEvent(subscribers, fakeChannelID, req.ChannelType, EventTypeMessageRevoke, &messageRevokeEvent{
+		ChannelType: req.ChannelType,
+		MessageID:   extra.MessageID,
+		MessageSeq:  extra.MessageSeq,
+		Revoker:     extra.Revoker,
+		Version:     extra.Version,
+	})
+	// 通知第三方
+	m.s.webhook.TriggerEvent(&Event{
+		Event: EventMsgRevoke,
+		Data: &messageRevokeEvent{
+			ChannelID:   req.ChannelID,
+			ChannelType: req.ChannelType,
+			MessageID:   extra.MessageID,
+			MessageSeq:  extra.MessageSeq,
+			Revoker:     extra.Revoker,
+			Version:     extra.Version,
+		},
+	})
+	return okproto.ReasonSuccess, nil
+}
+
+// Edit 编辑消息，编辑不会产生新的messageSeq，最新内容记录在消息扩展数据里，每次编辑都会追加一条编辑记录
+// fromClient 为true表示客户端发起的编辑，只能编辑自己发送的消息，api发起的编辑不做限制
+func (m *MessageManager) Edit(req MessageEditReq, fromClient bool) (okproto.ReasonCode, error) {
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == okproto.ChannelTypePerson {
+		fakeChannelID = GetFakeChannelIDWith(req.UID, req.ChannelID)
+	}
+
+	message, reasonCode, err := m.loadMessage(fakeChannelID, req.ChannelType, req.MessageSeq, req.MessageID)
+	if err != nil || reasonCode != okproto.ReasonSuccess {
+		return reasonCode, err
+	}
+	if fromClient && message.FromUID != req.UID {
+		m.Warn("只能编辑自己发送的消息！", zap.String("uid", req.UID), zap.String("fromUID", message.FromUID), zap.Int64("messageID", message.MessageID))
+		return okproto.ReasonNoPermission, nil
+	}
+
+	var extra *okstore.MessageExtra
+	reasonCode, err = m.updateMessageExtra(fakeChannelID, req.ChannelType, message, func(ext *okstore.MessageExtra) okproto.ReasonCode {
+		if ext.Revoke {
+			return okproto.ReasonMessageRevoked
+		}
+		ext.EditVersion++
+		ext.ContentEdit = req.Payload
+		ext.EditedAt = time.Now().Unix()
+		extra = ext
+		return okproto.ReasonSuccess
+	})
+	if err != nil || reasonCode != okproto.ReasonSuccess {
+		return reasonCode, err
+	}
+	err = m.s.store.AppendMessageEdit(fakeChannelID, req.ChannelType, &okstore.MessageEdit{
+		MessageID:   extra.MessageID,
+		MessageSeq:  extra.MessageSeq,
+		EditVersion: extra.EditVersion,
+		Content:     extra.ContentEdit,
+		Editor:      req.UID,
+		EditedAt:    extra.EditedAt,
+	})
+	if err != nil { // 编辑记录只用于查询历史，保存失败不影响编辑结果
+		m.Error("保存消息编辑记录失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType), zap.Uint32("messageSeq", extra.MessageSeq))
+	}
+	m.s.searchManager.IndexEdit(fakeChannelID, req.ChannelType, extra.MessageSeq, extra.ContentEdit)
+
+	// 如果编辑的是最近会话的最后一条消息，需要更新最近会话的版本，让客户端能同步到最新的内容
+	subscribers := m.getChannelSubscribers(fakeChannelID, req.ChannelType)
+	m.s.conversationManager.UpdateConversationVersionOfMessage(subscribers, fakeChannelID, req.ChannelType, extra.MessageSeq)
+
+	// 通知在线的订阅者（内容按连接加密）
+	m.s.deliveryManager.startDeliveryChannelEvent(subscribers, fakeChannelID, req.ChannelType, EventTypeMessageEdit, &messageEditEvent{
+		ChannelType: req.ChannelType,
+		MessageID:   extra.MessageID,
+		MessageSeq:  extra.MessageSeq,
+		Payload:     extra.ContentEdit,
+		Editor:      req.UID,
+		EditVersion: extra.EditVersion,
+		EditedAt:    extra.EditedAt,
+		Version:     extra.Version,
+	})
+	// 通知第三方
+	m.s.webhook.TriggerEvent(&Event{
+		Event: EventMsgEdit,
+		Data: &messageEditEvent{
+			ChannelID:   req.ChannelID,
+			ChannelType: req.ChannelType,
+			MessageID:   extra.MessageID,
+			MessageSeq:  extra.MessageSeq,
+			Payload:     extra.ContentEdit,
+			Editor:      req.UID,
+			EditVersion: extra.EditVersion,
+			EditedAt:    extra.EditedAt,
+			Version:     extra.Version,
+		},
+	})
+	return okproto.ReasonSuccess, nil
+}
+
+// EditHistory 获取消息的编辑记录
+func (m *MessageManager) EditHistory(fakeChannelID string, channelType uint8, messageSeq uint32) ([]*okstore.MessageEdit, error) {
+	return m.s.store.GetMessageEdits(fakeChannelID, channelType, messageSeq)
+}
+
+// Delete 删除消息，只记录删除标记，同步消息的时候过滤掉被删除的消息
+// fromClient 为true表示客户端发起的删除，只能对自己删除，api发起的删除可以对所有人删除
+func (m *MessageManager) Delete(req MessageDeleteReq, fromClient bool) (okproto.ReasonCode, error) {
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == okproto.ChannelTypePerson {
+		fakeChannelID = GetFakeChannelIDWith(req.UID, req.ChannelID)
+	}
+	everyone := req.Everyone == 1 && !fromClient
+	marker := &okstore.MessageDeleteMarker{
+		StartMessageSeq: req.StartMessageSeq,
+		EndMessageSeq:   req.EndMessageSeq,
+		MessageIDs:      req.MessageIDs,
+		Deleter:         req.UID,
+		DeletedAt:       time.Now().Unix(),
+	}
+	if !everyone {
+		marker.UID = req.UID
+	}
+	err := m.s.store.AddMessageDeleteMarker(fakeChannelID, req.ChannelType, marker)
+	if err != nil {
+		m.Error("保存消息删除标记失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return okproto.ReasonSystemError, err
+	}
+
+	var uids []string
+	if everyone {
+		uids = m.getChannelSubscribers(fakeChannelID, req.ChannelType)
+	} else {
+		uids = []string{req.UID}
+	}
+	// 如果删除了最近会话的最后一条消息，需要更新最近会话的版本，让客户端能同步到新的最后一条消息
+	m.s.conversationManager.UpdateConversationVersionOfDelete(uids, fakeChannelID, req.ChannelType, marker)
+
+	// 通知在线的用户（只对自己删除的通知自己的其他设备）
+	m.s.deliveryManager.startDeliveryChannelEvent(uids, fakeChannelID, req.ChannelType, EventTypeMessageDelete, newMessageDeleteEvent("", req.ChannelType, marker))
+	// 通知第三方
+	m.s.webhook.TriggerEvent(&Event{
+		Event: EventMsgDelete,
+		Data:  newMessageDeleteEvent(req.ChannelID, req.ChannelType, marker),
+	})
+	return okproto.ReasonSuccess, nil
+}
+
+// DeleteMarkers 获取频道对所有人以及对uid的消息删除标记，频道不在本节点则到频道所在节点获取
+func (m *MessageManager) DeleteMarkers(uid string, fakeChannelID string, channelType uint8) (okstore.MessageDeleteMarkers, error) {
+	if m.s.clusterManager.On() {
+		nodeID, err := m.s.clusterManager.NodeIDOfChannel(fakeChannelID, channelType)
+		if err != nil {
+			return nil, err
+		}
+		if !m.s.clusterManager.IsLocal(nodeID) {
+			var markers okstore.MessageDeleteMarkers
+			err = m.s.clusterManager.requestNode(nodeID, "/message/delete/markers", &MessageDeleteMarkersReq{
+				UID:         uid,
+				ChannelID:   getChannelIDForUID(fakeChannelID, channelType, uid),
+				ChannelType: channelType,
+			}, &markers)
+			return markers, err
+		}
+	}
+	return m.s.store.GetMessageDeleteMarkers(fakeChannelID, channelType, uid)
+}
+
+// filterDeletedMessages 过滤掉频道内对uid已删除的消息（频道消息按消息序号和消息ID判断）
+func (m *MessageManager) filterDeletedMessages(uid string, fakeChannelID string, channelType uint8, messages []okstore.Message) []okstore.Message {
+	if len(messages) == 0 {
+		return messages
+	}
+	markers, err := m.s.store.GetMessageDeleteMarkers(fakeChannelID, channelType, uid)
+	if err != nil {
+		m.Error("获取消息删除标记失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channelType))
+		return messages
+	}
+	if len(markers) == 0 {
+		return messages
+	}
+	newMessages := make([]okstore.Message, 0, len(messages))
+	for _, message := range messages {
+		if markers.Deleted(message.GetSeq(), message.GetMessageID()) {
+			continue
+		}
+		newMessages = append(newMessages, message)
+	}
+	return newMessages
+}
+
+// filterDeletedMessagesOfUser 过滤掉用户队列里已删除的消息（用户队列的消息序号不是频道的消息序号，只能按消息ID判断）
+func (m *MessageManager) filterDeletedMessagesOfUser(uid string, messages []okstore.Message) []okstore.Message {
+	if len(messages) == 0 {
+		return messages
+	}
+	markersMap := map[string]okstore.MessageDeleteMarkers{}
+	newMessages := make([]okstore.Message, 0, len(messages))
+	for _, msg := range messages {
+		message := msg.(*Message)
+		fakeChannelID := message.ChannelID
+		if message.ChannelType == okproto.ChannelTypePerson {
+			fakeChannelID = GetFakeChannelIDWith(uid, message.ChannelID)
+		}
+		channelKey := fmt.Sprintf("%s-%d", fakeChannelID, message.ChannelType)
+		markers, ok := markersMap[channelKey]
+		if !ok {
+			var err error
+			markers, err = m.DeleteMarkers(uid, fakeChannelID, message.ChannelType)
+			if err != nil {
+				m.Error("获取消息删除标记失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", message.ChannelType))
+			}
+			markersMap[channelKey] = markers
+		}
+		if markers.Deleted(0, message.MessageID) {
+			continue
+		}
+		newMessages = append(newMessages, msg)
+	}
+	return newMessages
+}
+
+// loadLastVisibleMessage 获取频道内lastMsgSeq（包含）之前对uid没有被删除的最后一条消息，没有则返回nil
+func (m *MessageManager) loadLastVisibleMessage(uid string, fakeChannelID string, channelType uint8, lastMsgSeq uint32) (okstore.Message, error) {
+	if lastMsgSeq == 0 {
+		return nil, nil
+	}
+	markers, err := m.s.store.GetMessageDeleteMarkers(fakeChannelID, channelType, uid)
+	if err != nil {
+		return nil, err
+	}
+	if len(markers) == 0 {
+		return m.s.store.LoadMsg(fakeChannelID, channelType, lastMsgSeq)
+	}
+	const batchCount = 50
+	startMsgSeq := lastMsgSeq
+	for startMsgSeq > 0 {
+		startMsgSeq = skipDeletedRange(markers, startMsgSeq)
+		if startMsgSeq == 0 {
+			return nil, nil
+		}
+		messages, err := m.s.store.LoadPrevRangeMsgs(fakeChannelID, channelType, startMsgSeq, 0, batchCount)
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) == 0 {
+			return nil, nil
+		}
+		for i := len(messages) - 1; i >= 0; i-- {
+			if !markers.Deleted(messages[i].GetSeq(), messages[i].GetMessageID()) {
+				return messages[i], nil
+			}
+		}
+		startMsgSeq = messages[0].GetSeq() - 1
+	}
+	return nil, nil
+}
+
+// skipDeletedRange 如果消息序号在删除的范围内，则跳到删除范围之前的消息序号
+func skipDeletedRange(markers okstore.MessageDeleteMarkers, messageSeq uint32) uint32 {
+	for skipped := true; skipped && messageSeq > 0; {
+		skipped = false
+		for _, marker := range markers {
+			if marker.StartMessageSeq != 0 && messageSeq >= marker.StartMessageSeq && messageSeq <= marker.EndMessageSeq {
+				messageSeq = marker.StartMessageSeq - 1
+				skipped = true
+			}
+		}
+	}
+	return messageSeq
+}
+
+// Read 用户已读到频道的指定消息（包含），开启了回执的消息会记录已读用户并通知发送者
+func (m *MessageManager) Read(req MessageReadReq) (okproto.ReasonCode, error) {
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == okproto.ChannelTypePerson {
+		fakeChannelID = GetFakeChannelIDWith(req.UID, req.ChannelID)
+	}
+	channel, err := m.s.channelManager.GetChannel(fakeChannelID, req.ChannelType)
+	if err != nil {
+		m.Error("获取频道失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return okproto.ReasonSystemError, err
+	}
+	if channel == nil {
+		return okproto.ReasonChannelNotExist, nil
+	}
+	if req.ChannelType != okproto.ChannelTypePerson && !channel.IsSubscriber(req.UID) {
+		m.Warn("非频道订阅者不能上报已读！", zap.String("uid", req.UID), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return okproto.ReasonNoPermission, nil
+	}
+
+	lastMsgSeq, err := m.s.store.GetLastMsgSeq(fakeChannelID, req.ChannelType)
+	if err != nil {
+		m.Error("获取频道最新消息序号失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return okproto.ReasonSystemError, err
+	}
+	readedSeq := req.MessageSeq
+	if readedSeq > lastMsgSeq {
+		readedSeq = lastMsgSeq
+	}
+	// 已读序号在已读用户和已读人数都保存成功后才更新，失败了客户端重新上报时会再处理一次
+	oldReadedSeq, err := m.s.store.GetChannelReadedSeq(req.UID, fakeChannelID, req.ChannelType)
+	if err != nil {
+		m.Error("获取已读序号失败！", zap.Error(err), zap.String("uid", req.UID), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return okproto.ReasonSystemError, err
+	}
+	if readedSeq <= oldReadedSeq { // 已经读过了
+		return okproto.ReasonSuccess, nil
+	}
+
+	// 一次最多处理ReceiptMaxReadCount条消息，更早的消息不再计算回执
+	startSeq := oldReadedSeq + 1
+	maxReadCount := uint32(m.s.opts.Message.ReceiptMaxReadCount)
+	if maxReadCount > 0 && readedSeq-oldReadedSeq > maxReadCount {
+		startSeq = readedSeq - maxReadCount + 1
+	}
+	msgs, err := m.s.store.LoadNextRangeMsgs(fakeChannelID, req.ChannelType, startSeq, readedSeq+1, int(readedSeq-startSeq+1))
+	if err != nil {
+		m.Error("获取已读范围内的消息失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return okproto.ReasonSystemError, err
+	}
+	receiptMessageMap := make(map[uint32]*Message)
+	receiptMessages := make([]*Message, 0)
+	receiptMessageSeqs := make([]uint32, 0)
+	for _, msg := range msgs {
+		message := msg.(*Message)
+		if !message.Setting.IsSet(okproto.SettingReceiptEnabled) || message.FromUID == req.UID { // 没开启回执或者是自己发的消息
+			continue
+		}
+		receiptMessageMap[message.MessageSeq] = message
+		receiptMessages = append(receiptMessages, message)
+		receiptMessageSeqs = append(receiptMessageSeqs, message.MessageSeq)
+	}
+	var extras []*okstore.MessageExtra
+	if len(receiptMessageSeqs) > 0 {
+		_, err = m.s.store.AddMessageReaders(fakeChannelID, req.ChannelType, req.UID, receiptMessageSeqs, time.Now().Unix())
+		if err != nil {
+			m.Error("保存消息已读用户失败！", zap.Error(err), zap.String("uid", req.UID), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+			return okproto.ReasonSystemError, err
+		}
+		// 已读人数取已读用户的数量（不做累加），上次保存了已读用户但没更新已读人数的这次会修正
+		var readersErr error
+		var reasonCode okproto.ReasonCode
+		extras, reasonCode, err = m.updateMessageExtras(fakeChannelID, req.ChannelType, receiptMessages, func(extra *okstore.MessageExtra) okproto.ReasonCode {
+			readers, err := m.s.store.GetMessageReaders(fakeChannelID, req.ChannelType, extra.MessageSeq)
+			if err != nil {
+				readersErr = err
+				return okproto.ReasonSystemError
+			}
+			extra.ReadedCount = len(readers)
+			return okproto.ReasonSuccess
+		})
+		if readersErr != nil {
+			m.Error("获取消息已读用户失败！", zap.Error(readersErr), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+			return reasonCode, readersErr
+		}
+		if err != nil || reasonCode != okproto.ReasonSuccess {
+			return reasonCode, err
+		}
+	}
+	_, err = m.s.store.UpdateChannelReadedSeqIfNeed(req.UID, fakeChannelID, req.ChannelType, readedSeq)
+	if err != nil {
+		m.Error("更新已读序号失败！", zap.Error(err), zap.String("uid", req.UID), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return okproto.ReasonSystemError, err
+	}
+	if len(extras) == 0 {
+		return okproto.ReasonSuccess, nil
+	}
+
+	// 按发送者通知回执
+	receiverCount := m.getReceiptReceiverCount(channel)
+	senderReceiptMap := make(map[string][]*MessageReceiptResp)
+	for _, extra := range extras {
+		fromUID := receiptMessageMap[extra.MessageSeq].FromUID
+		senderReceiptMap[fromUID] = append(senderReceiptMap[fromUID], newMessageReceiptResp(extra.MessageID, extra.MessageSeq, extra.ReadedCount, receiverCount))
+	}
+	for fromUID, receipts := range senderReceiptMap {
+		m.s.deliveryManager.startDeliveryChannelEvent([]string{fromUID}, fakeChannelID, req.ChannelType, EventTypeMessageReceipt, &messageReceiptEvent{
+			ChannelType: req.ChannelType,
+			Reader:      req.UID,
+			Receipts:    receipts,
+		})
+	}
+	return okproto.ReasonSuccess, nil
+}
+
+// React 添加或移除消息的回应，回应不生成消息，不影响最近会话和未读数
+func (m *MessageManager) React(req ReactionReq, remove bool) (okproto.ReasonCode, error) {
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == okproto.ChannelTypePerson {
+		fakeChannelID = GetFakeChannelIDWith(req.UID, req.ChannelID)
+	}
+	channel, err := m.s.channelManager.GetChannel(fakeChannelID, req.ChannelType)
+	if err != nil {
+		m.Error("获取频道失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return okproto.ReasonSystemError, err
+	}
+	if channel == nil {
+		return okproto.ReasonChannelNotExist, nil
+	}
+	if req.ChannelType != okproto.ChannelTypePerson && !channel.IsSubscriber(req.UID) {
+		m.Warn("非频道订阅者不能回应消息！", zap.String("uid", req.UID), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return okproto.ReasonNoPermission, nil
+	}
+	message, reasonCode, err := m.loadMessage(fakeChannelID, req.ChannelType, req.MessageSeq, req.MessageID)
+	if err != nil || reasonCode != okproto.ReasonSuccess {
+		return reasonCode, err
+	}
+
+	reaction, err := m.s.store.SetReaction(fakeChannelID, req.ChannelType, &okstore.Reaction{
+		MessageID:  message.MessageID,
+		MessageSeq: message.MessageSeq,
+		UID:        req.UID,
+		Emoji:      req.Emoji,
+		Deleted:    remove,
+		CreatedAt:  time.Now().Unix(),
+	})
+	if err != nil {
+		m.Error("保存消息回应失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType), zap.Int64("messageID", message.MessageID))
+		return okproto.ReasonSystemError, err
+	}
+	if reaction == nil { // 重复添加或移除不存在的回应
+		return okproto.ReasonSuccess, nil
+	}
+
+	// 通知在线的订阅者
+	eventType := EventTypeReactionAdd
+	if remove {
+		eventType = EventTypeReactionRemove
+	}
+	m.s.deliveryManager.startDeliveryChannelEvent(m.getChannelSubscribers(fakeChannelID, req.ChannelType), fakeChannelID, req.ChannelType, eventType, newReactionResp("", req.ChannelType, reaction))
+	return okproto.ReasonSuccess, nil
+}
+
+// SyncReactions 增量同步频道的消息回应
+func (m *MessageManager) SyncReactions(fakeChannelID string, channelType uint8, seq uint64, limit int) ([]*okstore.Reaction, error) {
+	return m.s.store.SyncReactions(fakeChannelID, channelType, seq, limit)
+}
+
+// Pin 置顶或取消置顶消息，置顶列表变化后给频道发送一条系统通知（和普通消息一样存储和投递）
+func (m *MessageManager) Pin(req MessagePinReq, unpin bool) (okproto.ReasonCode, error) {
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == okproto.ChannelTypePerson {
+		fakeChannelID = GetFakeChannelIDWith(req.UID, req.ChannelID)
+	}
+	channel, err := m.s.channelManager.GetChannel(fakeChannelID, req.ChannelType)
+	if err != nil {
+		m.Error("获取频道失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return okproto.ReasonSystemError, err
+	}
+	if channel == nil {
+		return okproto.ReasonChannelNotExist, nil
+	}
+	if req.ChannelType != okproto.ChannelTypePerson && !channel.IsSubscriber(req.UID) {
+		m.Warn("非频道订阅者不能置顶消息！", zap.String("uid", req.UID), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return okproto.ReasonNoPermission, nil
+	}
+
+	lockKey := fmt.Sprintf("pin:%s-%d", fakeChannelID, req.ChannelType)
+	m.extraLock.Lock(lockKey)
+	pins, notify, reasonCode, err := m.updatePins(fakeChannelID, req, unpin)
+	m.extraLock.Unlock(lockKey)
+	if err != nil || reasonCode != okproto.ReasonSuccess || notify == nil {
+		return reasonCode, err
+	}
+
+	// 系统通知走正常的消息发送流程
+	_, _, err = m.SendMessageToChannel(MessageSendReq{
+		FromUID:     req.UID,
+		ChannelID:   req.ChannelID,
+		ChannelType: req.ChannelType,
+		Payload:     []byte(okutil.ToJSON(notify)),
+	}, req.ChannelID, req.ChannelType, fmt.Sprintf("%s0", okutil.GenUUID()), okproto.StreamFlagIng)
+	if err != nil {
+		m.Error("发送置顶通知失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType), zap.Uint64("version", pins.Version))
+		return okproto.ReasonSystemError, err
+	}
+	return okproto.ReasonSuccess, nil
+}
+
+// 修改频道的置顶列表，没有变化（重复置顶或取消没有置顶的消息）返回的通知为nil
+func (m *MessageManager) updatePins(fakeChannelID string, req MessagePinReq, unpin bool) (*okstore.ChannelPins, *messagePinNotify, okproto.ReasonCode, error) {
+	pins, err := m.s.store.GetChannelPins(fakeChannelID, req.ChannelType)
+	if err != nil {
+		m.Error("获取频道置顶消息失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return nil, nil, okproto.ReasonSystemError, err
+	}
+	index := -1
+	for i, pin := range pins.Pins {
+		if pin.MessageSeq == req.MessageSeq {
+			index = i
+			break
+		}
+	}
+	var (
+		messageID int64
+		action    = MessagePinActionPin
+	)
+	if unpin {
+		if index < 0 {
+			return pins, nil, okproto.ReasonSuccess, nil
+		}
+		messageID = pins.Pins[index].MessageID
+		action = MessagePinActionUnpin
+		pins.Pins = append(pins.Pins[:index], pins.Pins[index+1:]...)
+	} else {
+		if index >= 0 {
+			return pins, nil, okproto.ReasonSuccess, nil
+		}
+		if m.s.opts.Channel.MaxPinCount > 0 && len(pins.Pins) >= m.s.opts.Channel.MaxPinCount {
+			return nil, nil, okproto.ReasonPinLimit, nil
+		}
+		message, reasonCode, err := m.loadMessage(fakeChannelID, req.ChannelType, req.MessageSeq, req.MessageID)
+		if err != nil || reasonCode != okproto.ReasonSuccess {
+			return nil, nil, reasonCode, err
+		}
+		extras, err := m.s.store.GetMessageExtras(fakeChannelID, req.ChannelType, []uint32{message.MessageSeq})
+		if err != nil {
+			m.Error("获取消息扩展数据失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+			return nil, nil, okproto.ReasonSystemError, err
+		}
+		if len(extras) > 0 && extras[0].MessageID == message.MessageID && extras[0].Revoke {
+			return nil, nil, okproto.ReasonMessageRevoked, nil
+		}
+		messageID = message.MessageID
+		pins.Pins = append(pins.Pins, &okstore.Pin{
+			MessageID:  message.MessageID,
+			MessageSeq: message.MessageSeq,
+			UID:        req.UID,
+			PinnedAt:   time.Now().Unix(),
+		})
+	}
+	pins.Version++
+	if err = m.s.store.SetChannelPins(fakeChannelID, req.ChannelType, pins); err != nil {
+		m.Error("保存频道置顶消息失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return nil, nil, okproto.ReasonSystemError, err
+	}
+	return pins, &messagePinNotify{
+		Type:       ContentTypeMessagePin,
+		Action:     action,
+		UID:        req.UID,
+		MessageID:  messageID,
+		MessageSeq: req.MessageSeq,
+		Version:    pins.Version,
+	}, okproto.ReasonSuccess, nil
+}
+
+// Pins 获取频道的置顶消息，version和当前版本号一致则不返回置顶列表
+func (m *MessageManager) Pins(fakeChannelID string, channelType uint8, version uint64) (*MessagePinSyncResp, error) {
+	pins, err := m.s.store.GetChannelPins(fakeChannelID, channelType)
+	if err != nil {
+		return nil, err
+	}
+	resp := &MessagePinSyncResp{
+		Version: pins.Version,
+		Pins:    make([]*MessagePinResp, 0, len(pins.Pins)),
+	}
+	if version == pins.Version {
+		return resp, nil
+	}
+	resp.Changed = 1
+	messageResps := make([]*MessageResp, 0, len(pins.Pins))
+	for _, pin := range pins.Pins {
+		pinResp := &MessagePinResp{
+			MessageID:  pin.MessageID,
+			MessageSeq: pin.MessageSeq,
+			UID:        pin.UID,
+			PinnedAt:   pin.PinnedAt,
+		}
+		msg, err := m.s.store.LoadMsg(fakeChannelID, channelType, pin.MessageSeq)
+		if err != nil {
+			m.Error("获取置顶的消息失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channelType), zap.Uint32("messageSeq", pin.MessageSeq))
+			return nil, err
+		}
+		if msg != nil && msg.(*Message).MessageID == pin.MessageID { // 消息可能已按保留策略删除
+			pinResp.Message = &MessageResp{}
+			pinResp.Message.from(msg.(*Message), m.s.store)
+			messageResps = append(messageResps, pinResp.Message)
+		}
+		resp.Pins = append(resp.Pins, pinResp)
+	}
+	m.fillMessageExtras(fakeChannelID, channelType, messageResps)
+	return resp, nil
+}
+
+// Receipts 获取消息的回执数据（已读和未读数量）
+func (m *MessageManager) Receipts(fakeChannelID string, channelType uint8, messageSeqs []uint32) ([]*MessageReceiptResp, error) {
+	channel, err := m.s.channelManager.GetChannel(fakeChannelID, channelType)
+	if err != nil {
+		return nil, err
+	}
+	if channel == nil {
+		return nil, errors.New("频道不存在！")
+	}
+	receiverCount := m.getReceiptReceiverCount(channel)
+
+	extras, err := m.s.store.GetMessageExtras(fakeChannelID, channelType, messageSeqs)
+	if err != nil {
+		return nil, err
+	}
+	extraMap := make(map[uint32]*okstore.MessageExtra, len(extras))
+	for _, extra := range extras {
+		extraMap[extra.MessageSeq] = extra
+	}
+	receipts := make([]*MessageReceiptResp, 0, len(messageSeqs))
+	for _, messageSeq := range messageSeqs {
+		message, reasonCode, err := m.loadMessage(fakeChannelID, channelType, messageSeq, 0)
+		if err != nil {
+			return nil, err
+		}
+		if reasonCode != okproto.ReasonSuccess || !message.Setting.IsSet(okproto.SettingReceiptEnabled) {
+			continue
+		}
+		readedCount := 0
+		if extra := extraMap[messageSeq]; extra != nil && extra.MessageID == message.MessageID {
+			readedCount = extra.ReadedCount
+		}
+		receipts = append(receipts, newMessageReceiptResp(message.MessageID, message.MessageSeq, readedCount, receiverCount))
+	}
+	return receipts, nil
+}
+
+// Readers 获取消息的已读用户
+func (m *MessageManager) Readers(fakeChannelID string, channelType uint8, messageSeq uint32) ([]*okstore.MessageReader, error) {
+	return m.s.store.GetMessageReaders(fakeChannelID, channelType, messageSeq)
+}
+
+// 获取回执的接收人数（个人频道为1，其他频道为订阅者数量减去发送者）
+func (m *MessageManager) getReceiptReceiverCount(channel *Channel) int {
+	if channel.ChannelType == okproto.ChannelTypePerson {
+		return 1
+	}
+	subscribers, err := channel.RealSubscribers(nil)
+	if err != nil {
+		m.Error("获取频道订阅者失败！", zap.Error(err))
+		return 0
+	}
+	if len(subscribers) == 0 {
+		return 0
+	}
+	return len(subscribers) - 1
+}
+
+// 修改消息的扩展数据，modifyFnc返回非成功的原因码则不保存
+func (m *MessageManager) updateMessageExtra(fakeChannelID string, channelType uint8, message *Message, modifyFnc func(extra *okstore.MessageExtra) okproto.ReasonCode) (okproto.ReasonCode, error) {
+	_, reasonCode, err := m.updateMessageExtras(fakeChannelID, channelType, []*Message{message}, modifyFnc)
+	return reasonCode, err
+}
+
+// 批量修改消息的扩展数据，任意一条消息的modifyFnc返回非成功的原因码则都不保存
+func (m *MessageManager) updateMessageExtras(fakeChannelID string, channelType uint8, messages []*Message, modifyFnc func(extra *okstore.MessageExtra) okproto.ReasonCode) ([]*okstore.MessageExtra, okproto.ReasonCode, error) {
+	if len(messages) == 0 {
+		return nil, okproto.ReasonSuccess, nil
+	}
+	lockKey := fmt.Sprintf("%s-%d", fakeChannelID, channelType)
+	m.extraLock.Lock(lockKey)
+	defer m.extraLock.Unlock(lockKey)
+
+	messageSeqs := make([]uint32, 0, len(messages))
+	for _, message := range messages {
+		messageSeqs = append(messageSeqs, message.MessageSeq)
+	}
+	oldExtras, err := m.s.store.GetMessageExtras(fakeChannelID, channelType, messageSeqs)
+	if err != nil {
+		m.Error("获取消息扩展数据失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channelType))
+		return nil, okproto.ReasonSystemError, err
+	}
+	oldExtraMap := make(map[uint32]*okstore.MessageExtra, len(oldExtras))
+	for _, oldExtra := range oldExtras {
+		oldExtraMap[oldExtra.MessageSeq] = oldExtra
+	}
+	version := time.Now().UnixNano() / 1e6
+	extras := make([]*okstore.MessageExtra, 0, len(messages))
+	for _, message := range messages {
+		extra := oldExtraMap[message.MessageSeq]
+		if extra == nil || extra.MessageID != message.MessageID {
+			extra = &okstore.MessageExtra{
+				MessageID:  message.MessageID,
+				MessageSeq: message.MessageSeq,
+			}
+		}
+		if reasonCode := modifyFnc(extra); reasonCode != okproto.ReasonSuccess {
+			return nil, reasonCode, nil
+		}
+		extra.Version = version
+		extras = append(extras, extra)
+	}
+	err = m.s.store.AddOrUpdateMessageExtras(fakeChannelID, channelType, extras)
+	if err != nil {
+		m.Error("保存消息扩展数据失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channelType))
+		return nil, okproto.ReasonSystemError, err
+	}
+	return extras, okproto.ReasonSuccess, nil
+}
+
+// 获取频道内的消息 messageID不为0的时候会校验消息ID是否一致
+func (m *MessageManager) loadMessage(fakeChannelID string, channelType uint8, messageSeq uint32, messageID int64) (*Message, okproto.ReasonCode, error) {
+	lastMsgSeq, err := m.s.store.GetLastMsgSeq(fakeChannelID, channelType)
+	if err != nil {
+		m.Error("获取频道最新消息序号失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channelType))
+		return nil, okproto.ReasonSystemError, err
+	}
+	if messageSeq == 0 || messageSeq > lastMsgSeq {
+		return nil, okproto.ReasonMessageNotExist, nil
+	}
+	msg, err := m.s.store.LoadMsg(fakeChannelID, channelType, messageSeq)
+	if err != nil {
+		m.Error("获取消息失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channelType), zap.Uint32("messageSeq", messageSeq))
+		return nil, okproto.ReasonSystemError, err
+	}
+	if msg == nil {
+		return nil, okproto.ReasonMessageNotExist, nil
+	}
+	message := msg.(*Message)
+	if messageID != 0 && message.MessageID != messageID {
+		return nil, okproto.ReasonMessageNotExist, nil
+	}
+	return message, okproto.ReasonSuccess, nil
+}
+
+// 获取频道的订阅者
+func (m *MessageManager) getChannelSubscribers(fakeChannelID string, channelType uint8) []string {
+	channel, err := m.s.channelManager.GetChannel(fakeChannelID, channelType)
+	if err != nil {
+		m.Error("获取频道失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channelType))
+		return nil
+	}
+	if channel == nil {
+		m.Warn("频道不存在！", zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channelType))
+		return nil
+	}
+	subscribers, err := channel.RealSubscribers(nil)
+	if err != nil {
+		m.Error("获取频道订阅者失败！", zap.Error(err))
+		return nil
+	}
+	return subscribers
+}
+
+// fillMessageExtras 将消息扩展数据（撤回、编辑等）填充到消息返回里
+func (m *MessageManager) fillMessageExtras(fakeChannelID string, channelType uint8, messageResps []*MessageResp) {
+	if len(messageResps) == 0 {
+		return
+	}
+	messageSeqs := make([]uint32, 0, len(messageResps))
+	for _, messageResp := range messageResps {
+		messageSeqs = append(messageSeqs, messageResp.MessageSeq)
+	}
+	extras, err := m.s.store.GetMessageExtras(fakeChannelID, channelType, messageSeqs)
+	if err != nil {
+		m.Error("获取消息扩展数据失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channelType))
+		return
+	}
+	if len(extras) == 0 {
+		return
+	}
+	extraMap := make(map[uint32]*okstore.MessageExtra, len(extras))
+	for _, extra := range extras {
+		extraMap[extra.MessageSeq] = extra
+	}
+	for _, messageResp := range messageResps {
+		extra := extraMap[messageResp.MessageSeq]
+		if extra == nil || extra.MessageID != messageResp.MessageID {
+			continue
+		}
+		messageResp.fromExtra(extra)
+	}
+}
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/okstore"
+	"github.com/samlau0508/imserver/pkg/okutil"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageManagerDeleteMarkers(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+
+	messages := make([]okstore.Message, 0, 5)
+	for i := 1; i <= 5; i++ {
+		messages = append(messages, &Message{
+			RecvPacket: &okproto.RecvPacket{
+				MessageID:   int64(i),
+				ChannelID:   "group1",
+				ChannelType: okproto.ChannelTypeGroup,
+				FromUID:     "test",
+				Timestamp:   int32(time.Now().Unix()),
+				Payload:     []byte("hello"),
+			},
+		})
+	}
+	_, err = s.store.AppendMessages("group1", okproto.ChannelTypeGroup, messages)
+	assert.NoError(t, err)
+
+	// 对所有人删除4-5，只对u1删除消息ID为3的消息
+	err = s.store.AddMessageDeleteMarker("group1", okproto.ChannelTypeGroup, &okstore.MessageDeleteMarker{StartMessageSeq: 4, EndMessageSeq: 5})
+	assert.NoError(t, err)
+	err = s.store.AddMessageDeleteMarker("group1", okproto.ChannelTypeGroup, &okstore.MessageDeleteMarker{UID: "u1", MessageIDs: []int64{3}})
+	assert.NoError(t, err)
+
+	visibleMessages := s.messageManager.filterDeletedMessages("u1", "group1", okproto.ChannelTypeGroup, messages)
+	assert.Equal(t, 2, len(visibleMessages))
+	visibleMessages = s.messageManager.filterDeletedMessages("u2", "group1", okproto.ChannelTypeGroup, messages)
+	assert.Equal(t, 3, len(visibleMessages))
+
+	lastMessage, err := s.messageManager.loadLastVisibleMessage("u1", "group1", okproto.ChannelTypeGroup, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), lastMessage.GetSeq())
+	lastMessage, err = s.messageManager.loadLastVisibleMessage("u2", "group1", okproto.ChannelTypeGroup, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), lastMessage.GetSeq())
+
+	err = s.store.AddMessageDeleteMarker("group1", okproto.ChannelTypeGroup, &okstore.MessageDeleteMarker{StartMessageSeq: 1, EndMessageSeq: 3})
+	assert.NoError(t, err)
+	lastMessage, err = s.messageManager.loadLastVisibleMessage("u2", "group1", okproto.ChannelTypeGroup, 5)
+	assert.NoError(t, err)
+	assert.Nil(t, lastMessage)
+}
+
+func TestMessageManagerEdit(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+
+	err = s.store.AddOrUpdateChannel(okstore.NewChannelInfo("group1", okproto.ChannelTypeGroup))
+	assert.NoError(t, err)
+	err = s.store.AddSubscribers("group1", okproto.ChannelTypeGroup, []string{"u1", "u2"})
+	assert.NoError(t, err)
+	_, err = s.store.AppendMessages("group1", okproto.ChannelTypeGroup, []okstore.Message{
+		&Message{RecvPacket: &okproto.RecvPacket{MessageID: 100, ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, FromUID: "u1", Timestamp: int32(time.Now().Unix()), Payload: []byte("hello")}},
+		&Message{RecvPacket: &okproto.RecvPacket{MessageID: 101, ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, FromUID: "u1", Timestamp: int32(time.Now().Unix()), Payload: []byte("world")}},
+	})
+	assert.NoError(t, err)
+
+	// 客户端只能编辑自己发送的消息，api编辑不受限制
+	reasonCode, err := s.messageManager.Edit(MessageEditReq{UID: "u2", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageSeq: 1, Payload: []byte("hello2")}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonNoPermission, reasonCode)
+	reasonCode, err = s.messageManager.Edit(MessageEditReq{UID: "u1", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageID: 100, MessageSeq: 1, Payload: []byte("hello2")}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+	reasonCode, err = s.messageManager.Edit(MessageEditReq{UID: "u2", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageSeq: 1, Payload: []byte("hello3")}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+
+	edits, err := s.messageManager.EditHistory("group1", okproto.ChannelTypeGroup, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(edits))
+	assert.Equal(t, "u2", edits[1].Editor)
+
+	// 撤回的消息不能编辑
+	reasonCode, err = s.messageManager.Revoke(MessageRevokeReq{UID: "u1", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageSeq: 2}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+	reasonCode, err = s.messageManager.Edit(MessageEditReq{UID: "u1", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageSeq: 2, Payload: []byte("world2")}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonMessageRevoked, reasonCode)
+
+	// 同步消息时返回编辑后的内容和编辑版本
+	msg, err := s.store.LoadMsg("group1", okproto.ChannelTypeGroup, 1)
+	assert.NoError(t, err)
+	messageResp := &MessageResp{}
+	messageResp.from(msg.(*Message), s.store)
+	s.messageManager.fillMessageExtras("group1", okproto.ChannelTypeGroup, []*MessageResp{messageResp})
+	assert.Equal(t, []byte("hello3"), messageResp.Payload)
+	assert.Equal(t, uint32(2), messageResp.EditVersion)
+	assert.NotEqual(t, int64(0), messageResp.EditedAt)
+}
+
+func TestMessageManagerRevoke(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	opts.Message.RevokeTimeout = time.Minute
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+
+	err = s.store.AddOrUpdateChannel(okstore.NewChannelInfo("group1", okproto.ChannelTypeGroup))
+	assert.NoError(t, err)
+	err = s.store.AddSubscribers("group1", okproto.ChannelTypeGroup, []string{"u1", "u2"})
+	assert.NoError(t, err)
+	now := time.Now().Unix()
+	_, err = s.store.AppendMessages("group1", okproto.ChannelTypeGroup, []okstore.Message{
+		&Message{RecvPacket: &okproto.RecvPacket{MessageID: 100, ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, FromUID: "u1", Timestamp: int32(now - 50), Payload: []byte("hello")}},
+		&Message{RecvPacket: &okproto.RecvPacket{MessageID: 101, ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, FromUID: "u1", Timestamp: int32(now - 70), Payload: []byte("world")}},
+	})
+	assert.NoError(t, err)
+
+	// 客户端只能撤回自己发送的消息
+	reasonCode, err := s.messageManager.Revoke(MessageRevokeReq{UID: "u2", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageSeq: 1}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonNoPermission, reasonCode)
+	// 消息ID不一致
+	reasonCode, _ = s.messageManager.Revoke(MessageRevokeReq{UID: "u1", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageID: 101, MessageSeq: 1}, true)
+	assert.Equal(t, okproto.ReasonMessageNotExist, reasonCode)
+	reasonCode, err = s.messageManager.Revoke(MessageRevokeReq{UID: "u1", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageID: 100, MessageSeq: 1}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+
+	// 超过撤回时间客户端不能撤回，api撤回不受限制
+	reasonCode, _ = s.messageManager.Revoke(MessageRevokeReq{UID: "u1", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageSeq: 2}, true)
+	assert.Equal(t, okproto.ReasonRevokeTimeout, reasonCode)
+	reasonCode, err = s.messageManager.Revoke(MessageRevokeReq{UID: "u2", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageSeq: 2}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+
+	// 同步消息时撤回的消息不返回内容
+	messageResps := make([]*MessageResp, 0, 2)
+	for seq := uint32(1); seq <= 2; seq++ {
+		msg, err := s.store.LoadMsg("group1", okproto.ChannelTypeGroup, seq)
+		assert.NoError(t, err)
+		messageResp := &MessageResp{}
+		messageResp.from(msg.(*Message), s.store)
+		messageResp.Streams = []*StreamItemResp{{StreamSeq: 1, Blob: []byte("stream")}}
+		messageResps = append(messageResps, messageResp)
+	}
+	s.messageManager.fillMessageExtras("group1", okproto.ChannelTypeGroup, messageResps)
+	assert.Equal(t, 1, messageResps[0].Revoke)
+	assert.Equal(t, "u1", messageResps[0].Revoker)
+	assert.Nil(t, messageResps[0].Payload)
+	assert.Nil(t, messageResps[0].Streams)
+	assert.Equal(t, 1, messageResps[1].Revoke)
+	assert.Equal(t, "u2", messageResps[1].Revoker)
+	assert.Nil(t, messageResps[1].Payload)
+}
+
+func TestMessageManagerRead(t *testing.T) {
+	s := protoTestStart(t, func(opts *Options) {
+		opts.Store.Driver = okstore.DriverMemory
+	})
+	err := s.store.AddOrUpdateChannel(okstore.NewChannelInfo("group1", okproto.ChannelTypeGroup))
+	assert.NoError(t, err)
+	err = s.store.AddSubscribers("group1", okproto.ChannelTypeGroup, []string{"u1", "u2", "u3"})
+	assert.NoError(t, err)
+	_, err = s.store.AppendMessages("group1", okproto.ChannelTypeGroup, []okstore.Message{
+		&Message{RecvPacket: &okproto.RecvPacket{Setting: okproto.SettingReceiptEnabled, MessageID: 100, ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, FromUID: "u1", Payload: []byte("hello")}},
+		&Message{RecvPacket: &okproto.RecvPacket{Setting: okproto.SettingReceiptEnabled, MessageID: 101, ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, FromUID: "u1", Payload: []byte("world")}},
+		&Message{RecvPacket: &okproto.RecvPacket{MessageID: 102, ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, FromUID: "u1", Payload: []byte("no receipt")}},
+	})
+	assert.NoError(t, err)
+
+	conn := protoTestConnect(t, s.dispatch.engine.TCPRealListenAddr().String(), "u1")
+	defer conn.Close()
+
+	// 非订阅者不能上报已读
+	reasonCode, err := s.messageManager.Read(MessageReadReq{UID: "u4", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageSeq: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonNoPermission, reasonCode)
+
+	// 已读后通知发送者开启了回执的消息
+	reasonCode, err = s.messageManager.Read(MessageReadReq{UID: "u2", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageSeq: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+	event := conn.readEvent(EventTypeMessageReceipt)
+	if assert.NotNil(t, event) {
+		var receiptEvent messageReceiptEvent
+		assert.NoError(t, okutil.ReadJSONByByte(event.Data, &receiptEvent))
+		assert.Equal(t, "group1", receiptEvent.ChannelID)
+		assert.Equal(t, "u2", receiptEvent.Reader)
+		assert.Equal(t, 2, len(receiptEvent.Receipts))
+		for _, receipt := range receiptEvent.Receipts {
+			assert.Equal(t, 1, receipt.ReadedCount)
+			assert.Equal(t, 1, receipt.UnreadCount)
+		}
+	}
+	readedSeq, err := s.store.GetChannelReadedSeq("u2", "group1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), readedSeq)
+
+	// 已读用户保存了但已读人数没有更新（比如中途宕机），再次上报已读会修正已读人数
+	_, err = s.store.AddMessageReaders("group1", okproto.ChannelTypeGroup, "u3", []uint32{1}, time.Now().Unix())
+	assert.NoError(t, err)
+	reasonCode, err = s.messageManager.Read(MessageReadReq{UID: "u3", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageSeq: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+
+	receipts, err := s.messageManager.Receipts("group1", okproto.ChannelTypeGroup, []uint32{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(receipts))
+	assert.Equal(t, 2, receipts[0].ReadedCount)
+	assert.Equal(t, 0, receipts[0].UnreadCount)
+	assert.Equal(t, 2, receipts[1].ReadedCount)
+	readers, err := s.messageManager.Readers("group1", okproto.ChannelTypeGroup, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(readers))
+	assert.Equal(t, "u2", readers[0].UID)
+	assert.Equal(t, "u3", readers[1].UID)
+}
+
+func TestMessageManagerReact(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+
+	err = s.store.AddOrUpdateChannel(okstore.NewChannelInfo("group1", okproto.ChannelTypeGroup))
+	assert.NoError(t, err)
+	err = s.store.AddSubscribers("group1", okproto.ChannelTypeGroup, []string{"u1", "u2"})
+	assert.NoError(t, err)
+	_, err = s.store.AppendMessages("group1", okproto.ChannelTypeGroup, []okstore.Message{&Message{
+		RecvPacket: &okproto.RecvPacket{
+			MessageID:   100,
+			ChannelID:   "group1",
+			ChannelType: okproto.ChannelTypeGroup,
+			FromUID:     "u1",
+			Timestamp:   int32(time.Now().Unix()),
+			Payload:     []byte("hello"),
+		},
+	}})
+	assert.NoError(t, err)
+
+	req := ReactionReq{UID: "u2", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageID: 100, MessageSeq: 1, Emoji: "👍"}
+	reasonCode, err := s.messageManager.React(req, false)
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+	reasonCode, err = s.messageManager.React(req, true)
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+
+	// 非订阅者不能回应，消息ID不一致的不能回应
+	reasonCode, _ = s.messageManager.React(ReactionReq{UID: "u3", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageID: 100, MessageSeq: 1, Emoji: "👍"}, false)
+	assert.Equal(t, okproto.ReasonNoPermission, reasonCode)
+	reasonCode, _ = s.messageManager.React(ReactionReq{UID: "u1", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageID: 101, MessageSeq: 1, Emoji: "👍"}, false)
+	assert.Equal(t, okproto.ReasonMessageNotExist, reasonCode)
+
+	reactions, err := s.messageManager.SyncReactions("group1", okproto.ChannelTypeGroup, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(reactions))
+	assert.Equal(t, uint64(2), reactions[0].Seq)
+	assert.True(t, reactions[0].Deleted)
+
+	// 回应不影响最近会话
+	conversation, err := s.store.GetConversation("u1", "group1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Nil(t, conversation)
+}
+
+func TestMessageManagerPin(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	opts.Channel.MaxPinCount = 1
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+
+	err = s.store.AddOrUpdateChannel(okstore.NewChannelInfo("group1", okproto.ChannelTypeGroup))
+	assert.NoError(t, err)
+	err = s.store.AddSubscribers("group1", okproto.ChannelTypeGroup, []string{"u1", "u2"})
+	assert.NoError(t, err)
+	_, err = s.store.AppendMessages("group1", okproto.ChannelTypeGroup, []okstore.Message{
+		&Message{RecvPacket: &okproto.RecvPacket{MessageID: 100, ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, FromUID: "u1", Payload: []byte("hello")}},
+		&Message{RecvPacket: &okproto.RecvPacket{MessageID: 101, ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, FromUID: "u1", Payload: []byte("world")}},
+	})
+	assert.NoError(t, err)
+
+	req := MessagePinReq{UID: "u2", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageSeq: 1}
+	reasonCode, err := s.messageManager.Pin(req, false)
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+	// 重复置顶不产生新的版本
+	reasonCode, err = s.messageManager.Pin(req, false)
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+	reasonCode, _ = s.messageManager.Pin(MessagePinReq{UID: "u2", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageSeq: 2}, false)
+	assert.Equal(t, okproto.ReasonPinLimit, reasonCode)
+	reasonCode, _ = s.messageManager.Pin(MessagePinReq{UID: "u3", ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup, MessageSeq: 2}, true)
+	assert.Equal(t, okproto.ReasonNoPermission, reasonCode)
+
+	resp, err := s.messageManager.Pins("group1", okproto.ChannelTypeGroup, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), resp.Version)
+	assert.Equal(t, 1, resp.Changed)
+	assert.Equal(t, 1, len(resp.Pins))
+	assert.Equal(t, int64(100), resp.Pins[0].MessageID)
+	assert.Equal(t, "u2", resp.Pins[0].UID)
+	assert.Equal(t, []byte("hello"), resp.Pins[0].Message.Payload)
+
+	resp, err = s.messageManager.Pins("group1", okproto.ChannelTypeGroup, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Changed)
+	assert.Empty(t, resp.Pins)
+
+	reasonCode, err = s.messageManager.Pin(req, true)
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+	resp, err = s.messageManager.Pins("group1", okproto.ChannelTypeGroup, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), resp.Version)
+	assert.Empty(t, resp.Pins)
+
+	// 置顶和取消置顶都会给频道发送系统通知
+	lastMsgSeq, err := s.store.GetLastMsgSeq("group1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(4), lastMsgSeq)
+	msg, err := s.store.LoadMsg("group1", okproto.ChannelTypeGroup, 4)
+	assert.NoError(t, err)
+	notify := &messagePinNotify{}
+	err = okutil.ReadJSONByByte(msg.(*Message).Payload, notify)
+	assert.NoError(t, err)
+	assert.Equal(t, ContentTypeMessagePin, notify.Type)
+	assert.Equal(t, MessagePinActionUnpin, notify.Action)
+	assert.Equal(t, int64(100), notify.MessageID)
+	assert.Equal(t, uint64(2), notify.Version)
+}
@@ -1,12 +1,14 @@
 package server
 
 import (
+	"encoding/json"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/samlau0508/imserver/pkg/oklog"
+	"github.com/samlau0508/imserver/pkg/oknet"
 	"github.com/samlau0508/imserver/pkg/okstore"
 	"github.com/samlau0508/imserver/pkg/okutil"
 	okproto "github.com/samlau0508/imserver/pkg/proto"
@@ -30,10 +32,13 @@ type Message struct {
 	// 重试相同的toDeviceID
 	toDeviceID string // 指定设备ID
 	large      bool   // 是否是超大群
+	// 单条消息被拒绝的原因（比如慢速模式），为0表示跟随同一批次消息的处理结果
+	reasonCode okproto.ReasonCode
 	// ------- 优先队列用到 ------
 	index      int   //在切片中的索引值
 	pri        int64 // 优先级的时间点 值越小越优先
 	retryCount int   // 当前重试次数
+	inFlightAt int64 // 第一次投递的时间（10位时间戳）
 }
 
 func (m *Message) GetMessageID() int64 {
@@ -48,6 +53,14 @@ func (m *Message) GetSeq() uint32 {
 	return m.MessageSeq
 }
 
+// GetExpireAt 消息过期的时间点（10位时间戳） 0表示永不过期
+func (m *Message) GetExpireAt() int64 {
+	if m.Expire == 0 {
+		return 0
+	}
+	return int64(m.Timestamp) + int64(m.Expire)
+}
+
 func (m *Message) Encode() []byte {
 	var version uint8 = 1
 	data := MarshalMessage(version, m)
@@ -228,7 +241,7 @@ func UnmarshalMessage(data []byte, m *Message) error {
 	return nil
 }
 
-type conversationResp struct {
+type ConversationResp struct {
 	ChannelID   string       `json:"channel_id"`   // 频道ID
 	ChannelType uint8        `json:"channel_type"` // 频道类型
 	Unread      int          `json:"unread"`       // 未读数
@@ -247,23 +260,31 @@ func (m MessageRespSlice) Less(i, j int) bool { return m[i].MessageSeq < m[j].Me
 
 // MessageResp 消息返回
 type MessageResp struct {
-	Header       MessageHeader      `json:"header"`                // 消息头
-	Setting      uint8              `json:"setting"`               // 设置
-	MessageID    int64              `json:"message_id"`            // 服务端的消息ID(全局唯一)
-	MessageIDStr string             `json:"message_idstr"`         // 服务端的消息ID(全局唯一)
-	ClientMsgNo  string             `json:"client_msg_no"`         // 客户端消息唯一编号
-	StreamNo     string             `json:"stream_no,omitempty"`   // 流编号
-	StreamSeq    uint32             `json:"stream_seq,omitempty"`  // 流序号
-	StreamFlag   okproto.StreamFlag `json:"stream_flag,omitempty"` // 流标记
-	MessageSeq   uint32             `json:"message_seq"`           // 消息序列号 （用户唯一，有序递增）
-	FromUID      string             `json:"from_uid"`              // 发送者UID
-	ChannelID    string             `json:"channel_id"`            // 频道ID
-	ChannelType  uint8              `json:"channel_type"`          // 频道类型
-	Topic        string             `json:"topic,omitempty"`       // 话题ID
-	Expire       uint32             `json:"expire"`                // 消息过期时间
-	Timestamp    int32              `json:"timestamp"`             // 服务器消息时间戳(10位，到秒)
-	Payload      []byte             `json:"payload"`               // 消息内容
-	Streams      []*StreamItemResp  `json:"streams,omitempty"`     // 消息流内容
+	Header       MessageHeader      `json:"header"`                   // 消息头
+	Setting      uint8              `json:"setting"`                  // 设置
+	MessageID    int64              `json:"message_id"`               // 服务端的消息ID(全局唯一)
+	MessageIDStr string             `json:"message_idstr"`            // 服务端的消息ID(全局唯一)
+	ClientMsgNo  string             `json:"client_msg_no"`            // 客户端消息唯一编号
+	StreamNo     string             `json:"stream_no,omitempty"`      // 流编号
+	StreamSeq    uint32             `json:"stream_seq,omitempty"`     // 流序号
+	StreamFlag   okproto.StreamFlag `json:"stream_flag,omitempty"`    // 流标记
+	MessageSeq   uint32             `json:"message_seq"`              // 消息序列号 （用户唯一，有序递增）
+	FromUID      string             `json:"from_uid"`                 // 发送者UID
+	ChannelID    string             `json:"channel_id"`               // 频道ID
+	ChannelType  uint8              `json:"channel_type"`             // 频道类型
+	Topic        string             `json:"topic,omitempty"`          // 话题ID
+	Expire       uint32             `json:"expire"`                   // 消息过期时间
+	Timestamp    int32              `json:"timestamp"`                // 服务器消息时间戳(10位，到秒)
+	Payload      []byte             `json:"payload"`                  // 消息内容
+	Streams      []*StreamItemResp  `json:"streams,omitempty"`        // 消息流内容
+	Revoke       int                `json:"revoke"`                   // 是否已撤回 1.是 0.否
+	Revoker      string             `json:"revoker,omitempty"`        // 撤回者UID
+	EditVersion  uint32             `json:"edit_version"`             // 编辑版本 0.未编辑
+	EditedAt     int64              `json:"edited_at,omitempty"`      // 最后一次编辑时间(10位，到秒)
+	ReadedCount  int                `json:"readed_count"`             // 已读人数（开启回执的消息才有）
+	ReplyCount   uint32             `json:"reply_count,omitempty"`    // 子区回复数（社区频道的消息才有）
+	LastReplyUID string             `json:"last_reply_uid,omitempty"` // 子区最后一条回复的发送者
+	LastReplyAt  int64              `json:"last_reply_at,omitempty"`  // 子区最后一条回复的时间(10位，到秒)
 }
 
 func (m *MessageResp) from(messageD *Message, store okstore.Store) {
@@ -313,6 +334,24 @@ func (m *MessageResp) from(messageD *Message, store okstore.Store) {
 	}
 }
 
+// fromExtra 填充消息扩展数据
+func (m *MessageResp) fromExtra(extra *okstore.MessageExtra) {
+	m.Revoke = okutil.BoolToInt(extra.Revoke)
+	m.Revoker = extra.Revoker
+	m.ReadedCount = extra.ReadedCount
+	m.ReplyCount = extra.ReplyCount
+	m.LastReplyUID = extra.LastReplyUID
+	m.LastReplyAt = extra.LastReplyAt
+	if extra.Revoke { // 已撤回的消息不再返回内容（包括编辑后的内容）
+		m.Payload = nil
+		m.Streams = nil
+	} else if extra.EditVersion > 0 { // 返回编辑后的最新内容
+		m.Payload = extra.ContentEdit
+		m.EditVersion = extra.EditVersion
+		m.EditedAt = extra.EditedAt
+	}
+}
+
 type StreamItemResp struct {
 	StreamSeq   uint32 `json:"stream_seq"`    // 流序号
 	ClientMsgNo string `json:"client_msg_no"` // 客户端消息唯一编号
@@ -343,14 +382,14 @@ type MessageHeader struct {
 	SyncOnce  int `json:"sync_once"`  // This message is only synchronized or consumed once
 }
 
-type clearConversationUnreadReq struct {
+type ClearConversationUnreadReq struct {
 	UID         string `json:"uid"`
 	ChannelID   string `json:"channel_id"`
 	ChannelType uint8  `json:"channel_type"`
 	MessageSeq  uint32 `json:"message_seq"` // messageSeq 只有超大群才会传 因为超大群最近会话服务器不会维护，需要客户端传递messageSeq进行主动维护
 }
 
-func (req clearConversationUnreadReq) Check() error {
+func (req ClearConversationUnreadReq) Check() error {
 	if req.UID == "" {
 		return errors.New("uid cannot be empty")
 	}
@@ -360,13 +399,22 @@ func (req clearConversationUnreadReq) Check() error {
 	return nil
 }
 
-type deleteChannelReq struct {
+// SetConversationUnreadReq 设置会话未读数量
+type SetConversationUnreadReq struct {
 	UID         string `json:"uid"`
 	ChannelID   string `json:"channel_id"`
 	ChannelType uint8  `json:"channel_type"`
+	Unread      int    `json:"unread"`
+	MessageSeq  uint32 `json:"message_seq"` // messageSeq 只有超大群才会传 因为超大群最近会话服务器不会维护，需要客户端传递messageSeq进行主动维护
 }
 
-func (req deleteChannelReq) Check() error {
+type ConversationDeleteReq struct {
+	UID         string `json:"uid"`
+	ChannelID   string `json:"channel_id"`
+	ChannelType uint8  `json:"channel_type"`
+}
+
+func (req ConversationDeleteReq) Check() error {
 	if len(req.UID) <= 0 {
 		return errors.New("Uid cannot be empty")
 	}
@@ -376,7 +424,16 @@ func (req deleteChannelReq) Check() error {
 	return nil
 }
 
-type syncUserConversationResp struct {
+// SyncUserConversationReq 同步用户的会话
+type SyncUserConversationReq struct {
+	UID         string             `json:"uid"`
+	Version     int64              `json:"version"`       // 当前客户端的会话最大版本号(客户端最新会话的时间戳)
+	LastMsgSeqs string             `json:"last_msg_seqs"` // 客户端所有会话的最后一条消息序列号 格式： channelID:channelType:last_msg_seq|channelID:channelType:last_msg_seq
+	MsgCount    int64              `json:"msg_count"`     // 每个会话消息数量
+	Larges      []*okproto.Channel `json:"larges"`        // 超大频道集合
+}
+
+type SyncUserConversationResp struct {
 	ChannelID       string         `json:"channel_id"`         // 频道ID
 	ChannelType     uint8          `json:"channel_type"`       // 频道类型
 	Unread          int            `json:"unread"`             // 未读消息
@@ -388,9 +445,9 @@ type syncUserConversationResp struct {
 	Recents         []*MessageResp `json:"recents"`            // 最近N条消息
 }
 
-func newSyncUserConversationResp(conversation *okstore.Conversation) *syncUserConversationResp {
+func newSyncUserConversationResp(conversation *okstore.Conversation) *SyncUserConversationResp {
 
-	return &syncUserConversationResp{
+	return &SyncUserConversationResp{
 		ChannelID:       conversation.ChannelID,
 		ChannelType:     conversation.ChannelType,
 		Unread:          conversation.UnreadCount,
@@ -401,13 +458,20 @@ func newSyncUserConversationResp(conversation *okstore.Conversation) *syncUserCo
 	}
 }
 
-type channelRecentMessageReq struct {
+// SyncRecentMessagesReq 同步会话的最近消息
+type SyncRecentMessagesReq struct {
+	UID      string                     `json:"uid"`
+	Channels []*ChannelRecentMessageReq `json:"channels"`
+	MsgCount int                        `json:"msg_count"`
+}
+
+type ChannelRecentMessageReq struct {
 	ChannelID   string `json:"channel_id"`
 	ChannelType uint8  `json:"channel_type"`
 	LastMsgSeq  uint32 `json:"last_msg_seq"`
 }
 
-type channelRecentMessage struct {
+type ChannelRecentMessage struct {
 	ChannelID   string         `json:"channel_id"`
 	ChannelType uint8          `json:"channel_type"`
 	Messages    []*MessageResp `json:"messages"`
@@ -434,6 +498,27 @@ func (m MessageSendReq) Check() error {
 	return nil
 }
 
+// MessageSendResp 消息发送结果（在返回的data里）
+type MessageSendResp struct {
+	MessageID   int64  `json:"message_id"`    // 服务端的消息ID
+	ClientMsgNo string `json:"client_msg_no"` // 客户端消息编号
+	MessageSeq  uint32 `json:"message_seq"`   // 消息序列号
+}
+
+// MessageSendBatchReq 批量给用户发送消息
+type MessageSendBatchReq struct {
+	Header      MessageHeader `json:"header"`      // 消息头
+	FromUID     string        `json:"from_uid"`    // 发送者UID
+	Subscribers []string      `json:"subscribers"` // 订阅者 如果此字段有值，表示消息只发给指定的订阅者
+	Payload     []byte        `json:"payload"`     // 消息内容
+}
+
+// MessageSendBatchResp 批量发送消息的结果
+type MessageSendBatchResp struct {
+	FailUIDs []string `json:"fail_uids"` // 发送失败的用户
+	Reason   []string `json:"reason"`    // 对应用户发送失败的原因
+}
+
 // ChannelInfoReq ChannelInfoReq
 type ChannelInfoReq struct {
 	ChannelID   string `json:"channel_id"`   // 频道ID
@@ -480,7 +565,7 @@ func (r ChannelCreateReq) Check() error {
 	return nil
 }
 
-type subscriberAddReq struct {
+type SubscriberAddReq struct {
 	ChannelID      string   `json:"channel_id"`      // 频道ID
 	ChannelType    uint8    `json:"channel_type"`    // 频道类型
 	Reset          int      `json:"reset"`           // 是否重置订阅者 （0.不重置 1.重置），选择重置，将删除原来的所有成员
@@ -488,7 +573,7 @@ type subscriberAddReq struct {
 	Subscribers    []string `json:"subscribers"`     // 订阅者
 }
 
-func (s subscriberAddReq) Check() error {
+func (s SubscriberAddReq) Check() error {
 	if strings.TrimSpace(s.ChannelID) == "" {
 		return errors.New("频道ID不能为空！")
 	}
@@ -498,14 +583,14 @@ func (s subscriberAddReq) Check() error {
 	return nil
 }
 
-type subscriberRemoveReq struct {
+type SubscriberRemoveReq struct {
 	ChannelID      string   `json:"channel_id"`
 	ChannelType    uint8    `json:"channel_type"`
 	TempSubscriber int      `json:"temp_subscriber"` //  是否是临时订阅者 (1. 是 0. 否)
 	Subscribers    []string `json:"subscribers"`
 }
 
-func (s subscriberRemoveReq) Check() error {
+func (s SubscriberRemoveReq) Check() error {
 	if strings.TrimSpace(s.ChannelID) == "" {
 		return errors.New("频道ID不能为空！")
 	}
@@ -528,13 +613,13 @@ func stringArrayIsEmpty(array []string) bool {
 	return emptyCount >= len(array)
 }
 
-type blacklistReq struct {
+type BlacklistReq struct {
 	ChannelID   string   `json:"channel_id"`   // 频道ID
 	ChannelType uint8    `json:"channel_type"` // 频道类型
 	UIDs        []string `json:"uids"`         // 订阅者
 }
 
-func (r blacklistReq) Check() error {
+func (r BlacklistReq) Check() error {
 	if r.ChannelID == "" {
 		return errors.New("channel_id不能为空！")
 	}
@@ -547,19 +632,183 @@ func (r blacklistReq) Check() error {
 	return nil
 }
 
+// ChannelRetentionGetReq 获取或移除频道的消息保留策略（个人频道的channel_id为fake频道ID）
+type ChannelRetentionGetReq struct {
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+}
+
+func (r ChannelRetentionGetReq) Check() error {
+	if strings.TrimSpace(r.ChannelID) == "" {
+		return errors.New("channel_id不能为空！")
+	}
+	if r.ChannelType == 0 {
+		return errors.New("频道类型不能为0！")
+	}
+	return nil
+}
+
+// ChannelRetentionReq 设置频道的消息保留策略（个人频道的channel_id为fake频道ID）
+type ChannelRetentionReq struct {
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+	okstore.RetentionPolicy
+}
+
+func (r ChannelRetentionReq) Check() error {
+	if r.ChannelID == "" {
+		return errors.New("channel_id不能为空！")
+	}
+	if r.ChannelType == 0 {
+		return errors.New("频道类型不能为0！")
+	}
+	if r.MaxAge < 0 || r.MaxBytes < 0 {
+		return errors.New("max_age和max_bytes不能小于0！")
+	}
+	if r.RetentionPolicy.IsZero() {
+		return errors.New("max_age、max_messages、max_bytes不能都为0！")
+	}
+	return nil
+}
+
+// channelModerationBinder 禁言设置相关的请求
+type channelModerationBinder interface {
+	Check() error
+	channel() (string, uint8)
+}
+
+// ChannelModerationReq 获取频道的禁言设置
+type ChannelModerationReq struct {
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+}
+
+func (r ChannelModerationReq) Check() error {
+	if strings.TrimSpace(r.ChannelID) == "" {
+		return errors.New("channel_id不能为空！")
+	}
+	if r.ChannelType == 0 {
+		return errors.New("频道类型不能为0！")
+	}
+	return nil
+}
+
+func (r ChannelModerationReq) channel() (string, uint8) {
+	return r.ChannelID, r.ChannelType
+}
+
+// ChannelAdminReq 添加或移除频道管理员
+type ChannelAdminReq struct {
+	ChannelModerationReq
+	UIDs []string `json:"uids"` // 管理员
+}
+
+func (r ChannelAdminReq) Check() error {
+	if err := r.ChannelModerationReq.Check(); err != nil {
+		return err
+	}
+	if stringArrayIsEmpty(r.UIDs) {
+		return errors.New("uids不能为空！")
+	}
+	return nil
+}
+
+// ChannelMuteReq 设置全员禁言
+type ChannelMuteReq struct {
+	ChannelModerationReq
+	Mute     int   `json:"mute"`     // 是否全员禁言 1.是 0.否
+	Duration int64 `json:"duration"` // 禁言时长（秒） 0表示不过期
+}
+
+func (r ChannelMuteReq) Check() error {
+	if err := r.ChannelModerationReq.Check(); err != nil {
+		return err
+	}
+	if r.Duration < 0 {
+		return errors.New("duration不能小于0！")
+	}
+	return nil
+}
+
+// ChannelSlowModeReq 设置慢速模式
+type ChannelSlowModeReq struct {
+	ChannelModerationReq
+	Interval uint32 `json:"interval"` // 每个成员每interval秒只能发一次消息 0表示关闭
+}
+
+// ChannelMemberMuteReq 禁言或解除禁言成员
+type ChannelMemberMuteReq struct {
+	ChannelModerationReq
+	UIDs     []string `json:"uids"`     // 成员
+	Duration int64    `json:"duration"` // 禁言时长（秒） 0表示不过期（解除禁言时忽略）
+}
+
+func (r ChannelMemberMuteReq) Check() error {
+	if err := r.ChannelModerationReq.Check(); err != nil {
+		return err
+	}
+	if stringArrayIsEmpty(r.UIDs) {
+		return errors.New("uids不能为空！")
+	}
+	if r.Duration < 0 {
+		return errors.New("duration不能小于0！")
+	}
+	return nil
+}
+
+// 时长（秒）转为到期时间 0表示不过期
+func durationToExpireAt(duration int64) int64 {
+	if duration <= 0 {
+		return 0
+	}
+	return time.Now().Unix() + duration
+}
+
+// ChannelModerationResp 频道的禁言设置（不包含已到期的禁言）
+type ChannelModerationResp struct {
+	Admins       []string         `json:"admins"`         // 管理员
+	Mute         int              `json:"mute"`           // 是否全员禁言 1.是 0.否
+	MuteExpireAt int64            `json:"mute_expire_at"` // 全员禁言的到期时间（10位，到秒） 0表示不过期
+	SlowMode     uint32           `json:"slow_mode"`      // 慢速模式的间隔（秒） 0表示关闭
+	MemberMutes  map[string]int64 `json:"member_mutes"`   // 被禁言的成员，value为禁言的到期时间（10位，到秒） 0表示不过期
+}
+
+func newChannelModerationResp(moderation okstore.ChannelModeration) *ChannelModerationResp {
+	resp := &ChannelModerationResp{
+		Admins:       moderation.Admins,
+		Mute:         okutil.BoolToInt(moderation.Mute),
+		MuteExpireAt: moderation.MuteExpireAt,
+		SlowMode:     moderation.SlowMode,
+		MemberMutes:  moderation.MemberMutes,
+	}
+	if resp.Admins == nil {
+		resp.Admins = make([]string, 0)
+	}
+	if resp.MemberMutes == nil {
+		resp.MemberMutes = map[string]int64{}
+	}
+	return resp
+}
+
+// ChannelRetentionResp 频道生效的消息保留策略
+type ChannelRetentionResp struct {
+	okstore.RetentionPolicy
+	Custom bool `json:"custom"` // 是否是频道单独设置的保留策略（否则为频道类型或默认的保留策略）
+}
+
 // ChannelDeleteReq 删除频道请求
 type ChannelDeleteReq struct {
 	ChannelID   string `json:"channel_id"`   // 频道ID
 	ChannelType uint8  `json:"channel_type"` // 频道类型
 }
 
-type whitelistReq struct {
+type WhitelistReq struct {
 	ChannelID   string   `json:"channel_id"`   // 频道ID
 	ChannelType uint8    `json:"channel_type"` // 频道类型
 	UIDs        []string `json:"uids"`         // 订阅者
 }
 
-func (r whitelistReq) Check() error {
+func (r WhitelistReq) Check() error {
 	if r.ChannelID == "" {
 		return errors.New("channel_id不能为空！")
 	}
@@ -572,13 +821,13 @@ func (r whitelistReq) Check() error {
 	return nil
 }
 
-type syncReq struct {
+type SyncReq struct {
 	UID        string `json:"uid"`         // 用户uid
 	MessageSeq uint32 `json:"message_seq"` // 客户端最大消息序列号
 	Limit      int    `json:"limit"`       // 消息数量限制
 }
 
-func (r syncReq) Check() error {
+func (r SyncReq) Check() error {
 	if strings.TrimSpace(r.UID) == "" {
 		return errors.New("用户uid不能为空！")
 	}
@@ -588,21 +837,32 @@ func (r syncReq) Check() error {
 	return nil
 }
 
-type syncMessageResp struct {
+// ChannelMessageSyncReq 同步频道内的消息
+type ChannelMessageSyncReq struct {
+	LoginUID        string   `json:"login_uid"` // 当前登录用户的uid
+	ChannelID       string   `json:"channel_id"`
+	ChannelType     uint8    `json:"channel_type"`
+	StartMessageSeq uint32   `json:"start_message_seq"` //开始消息列号（结果包含start_message_seq的消息）
+	EndMessageSeq   uint32   `json:"end_message_seq"`   // 结束消息列号（结果不包含end_message_seq的消息）
+	Limit           int      `json:"limit"`             // 每次同步数量限制
+	PullMode        PullMode `json:"pull_mode"`         // 拉取模式 0:向下拉取 1:向上拉取
+}
+
+type SyncMessageResp struct {
 	StartMessageSeq uint32         `json:"start_message_seq"` // 开始序列号
 	EndMessageSeq   uint32         `json:"end_message_seq"`   // 结束序列号
 	More            int            `json:"more"`              // 是否还有更多 1.是 0.否
 	Messages        []*MessageResp `json:"messages"`          // 消息数据
 }
 
-type syncackReq struct {
+type SyncackReq struct {
 	// 用户uid
 	UID string `json:"uid"`
 	// 最后一次同步的message_seq
 	LastMessageSeq uint32 `json:"last_message_seq"`
 }
 
-func (s syncackReq) Check() error {
+func (s SyncackReq) Check() error {
 	if strings.TrimSpace(s.UID) == "" {
 		return errors.New("用户UID不能为空！")
 	}
@@ -612,7 +872,7 @@ func (s syncackReq) Check() error {
 	return nil
 }
 
-type messageStreamStartReq struct {
+type MessageStreamStartReq struct {
 	Header      MessageHeader `json:"header"`        // 消息头
 	ClientMsgNo string        `json:"client_msg_no"` // 客户端消息编号（相同编号，客户端只会显示一条）
 	FromUID     string        `json:"from_uid"`      // 发送者UID
@@ -621,8 +881,692 @@ type messageStreamStartReq struct {
 	Payload     []byte        `json:"payload"`       // 消息内容
 }
 
-type messageStreamEndReq struct {
+// MessageStreamStartResp 流消息开始的结果
+type MessageStreamStartResp struct {
+	StreamNo string `json:"stream_no"` // 消息流编号
+}
+
+type MessageStreamEndReq struct {
 	StreamNo    string `json:"stream_no"`    // 消息流编号
 	ChannelID   string `json:"channel_id"`   // 频道ID
 	ChannelType uint8  `json:"channel_type"` // 频道类型
 }
+
+type MessageRevokeReq struct {
+	UID         string `json:"uid"`          // 操作者UID（个人频道必传）
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+	MessageID   int64  `json:"message_id"`   // 消息ID（不为0的时候会校验消息ID）
+	MessageSeq  uint32 `json:"message_seq"`  // 消息序列号
+}
+
+func (req MessageRevokeReq) Check() error {
+	if req.ChannelID == "" || req.ChannelType == 0 {
+		return errors.New("channel_id or channel_type cannot be empty")
+	}
+	if req.MessageSeq == 0 {
+		return errors.New("message_seq cannot be 0")
+	}
+	if req.ChannelType == okproto.ChannelTypePerson && strings.TrimSpace(req.UID) == "" {
+		return errors.New("uid cannot be empty")
+	}
+	return nil
+}
+
+// 消息撤回事件
+type messageRevokeEvent struct {
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+	MessageID   int64  `json:"message_id"`   // 消息ID
+	MessageSeq  uint32 `json:"message_seq"`  // 消息序列号
+	Revoker     string `json:"revoker"`      // 撤回者UID
+	Version     int64  `json:"version"`      // 数据版本（毫秒时间戳）
+}
+
+func (e messageRevokeEvent) forConn(fakeChannelID string, channelType uint8, conn oknet.Conn) (interface{}, error) {
+	e.ChannelID = getChannelIDForUID(fakeChannelID, channelType, conn.UID())
+	return &e, nil
+}
+
+type MessageEditReq struct {
+	UID         string `json:"uid"`          // 操作者UID（个人频道必传）
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+	MessageID   int64  `json:"message_id"`   // 消息ID（不为0的时候会校验消息ID）
+	MessageSeq  uint32 `json:"message_seq"`  // 消息序列号
+	Payload     []byte `json:"payload"`      // 编辑后的消息内容
+}
+
+func (req MessageEditReq) Check() error {
+	if req.ChannelID == "" || req.ChannelType == 0 {
+		return errors.New("channel_id or channel_type cannot be empty")
+	}
+	if req.MessageSeq == 0 {
+		return errors.New("message_seq cannot be 0")
+	}
+	if req.ChannelType == okproto.ChannelTypePerson && strings.TrimSpace(req.UID) == "" {
+		return errors.New("uid cannot be empty")
+	}
+	if len(req.Payload) == 0 {
+		return errors.New("payload cannot be empty")
+	}
+	return nil
+}
+
+type MessageSearchReq struct {
+	UID             string                  `json:"uid"`                // 搜索者UID（个人频道或不指定频道时必传）
+	ChannelID       string                  `json:"channel_id"`         // 频道ID（为空则搜索uid的所有最近会话频道）
+	ChannelType     uint8                   `json:"channel_type"`       // 频道类型
+	Keyword         string                  `json:"keyword"`            // 关键字
+	StartMessageSeq uint32                  `json:"start_message_seq"`  // 从此消息序号往前搜索（不包含），0表示从最新的消息开始（指定频道时有效）
+	Limit           int                     `json:"limit"`              // 每个频道返回的消息数量
+	Channels        []*MessageSearchChannel `json:"channels,omitempty"` // 集群内部使用，指定本节点需要搜索的频道
+}
+
+func (req *MessageSearchReq) Check() error {
+	if strings.TrimSpace(req.Keyword) == "" {
+		return errors.New("keyword cannot be empty")
+	}
+	if req.ChannelID == "" && strings.TrimSpace(req.UID) == "" {
+		return errors.New("channel_id or uid cannot be empty")
+	}
+	if req.ChannelID != "" && req.ChannelType == okproto.ChannelTypePerson && strings.TrimSpace(req.UID) == "" {
+		return errors.New("uid cannot be empty")
+	}
+	if req.Limit <= 0 {
+		req.Limit = 20
+	}
+	return nil
+}
+
+type MessageSearchChannel struct {
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+}
+
+type MessageSearchResult struct {
+	ChannelID   string         `json:"channel_id"`   // 频道ID
+	ChannelType uint8          `json:"channel_type"` // 频道类型
+	Messages    []*MessageResp `json:"messages"`     // 匹配的消息（消息序号从大到小）
+	More        int            `json:"more"`         // 是否还有更多匹配的消息 1.是 0.否
+}
+
+// 消息编辑事件
+type messageEditEvent struct {
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+	MessageID   int64  `json:"message_id"`   // 消息ID
+	MessageSeq  uint32 `json:"message_seq"`  // 消息序列号
+	Payload     []byte `json:"payload"`      // 编辑后的消息内容（投递给客户端的时候是加密的）
+	Editor      string `json:"editor"`       // 编辑者UID
+	EditVersion uint32 `json:"edit_version"` // 编辑版本
+	EditedAt    int64  `json:"edited_at"`    // 编辑时间(10位，到秒)
+	Version     int64  `json:"version"`      // 数据版本（毫秒时间戳）
+}
+
+func (e messageEditEvent) forConn(fakeChannelID string, channelType uint8, conn oknet.Conn) (interface{}, error) {
+	payloadEnc, err := encryptMessagePayload(e.Payload, conn)
+	if err != nil {
+		return nil, err
+	}
+	e.ChannelID = getChannelIDForUID(fakeChannelID, channelType, conn.UID())
+	e.Payload = payloadEnc
+	return &e, nil
+}
+
+type MessageDeleteReq struct {
+	UID             string  `json:"uid"`               // 操作者UID（个人频道或只对自己删除时必传）
+	ChannelID       string  `json:"channel_id"`        // 频道ID
+	ChannelType     uint8   `json:"channel_type"`      // 频道类型
+	StartMessageSeq uint32  `json:"start_message_seq"` // 删除的消息序号范围（包含）
+	EndMessageSeq   uint32  `json:"end_message_seq"`   // 为0则只删除start_message_seq
+	MessageIDs      []int64 `json:"message_ids"`       // 按消息ID删除（写扩散的消息只能按消息ID删除）
+	Everyone        int     `json:"everyone"`          // 是否对所有人删除 1.是 0.否（只对uid删除）
+}
+
+func (req *MessageDeleteReq) Check() error {
+	if req.ChannelID == "" || req.ChannelType == 0 {
+		return errors.New("channel_id or channel_type cannot be empty")
+	}
+	if req.StartMessageSeq == 0 && len(req.MessageIDs) == 0 {
+		return errors.New("start_message_seq or message_ids cannot be empty")
+	}
+	if req.StartMessageSeq != 0 && req.EndMessageSeq == 0 {
+		req.EndMessageSeq = req.StartMessageSeq
+	}
+	if req.EndMessageSeq < req.StartMessageSeq {
+		return errors.New("end_message_seq cannot be less than start_message_seq")
+	}
+	if (req.ChannelType == okproto.ChannelTypePerson || req.Everyone != 1) && strings.TrimSpace(req.UID) == "" {
+		return errors.New("uid cannot be empty")
+	}
+	return nil
+}
+
+// 消息删除事件
+type messageDeleteEvent struct {
+	ChannelID       string  `json:"channel_id"`                  // 频道ID
+	ChannelType     uint8   `json:"channel_type"`                // 频道类型
+	UID             string  `json:"uid,omitempty"`               // 只对此用户删除，为空表示对所有人删除
+	StartMessageSeq uint32  `json:"start_message_seq,omitempty"` // 删除的消息序号范围（包含）
+	EndMessageSeq   uint32  `json:"end_message_seq,omitempty"`
+	MessageIDs      []int64 `json:"message_ids,omitempty"` // 删除的消息ID
+	Deleter         string  `json:"deleter"`               // 删除者UID
+	DeletedAt       int64   `json:"deleted_at"`            // 删除时间(10位，到秒)
+}
+
+func (e messageDeleteEvent) forConn(fakeChannelID string, channelType uint8, conn oknet.Conn) (interface{}, error) {
+	e.ChannelID = getChannelIDForUID(fakeChannelID, channelType, conn.UID())
+	return &e, nil
+}
+
+func newMessageDeleteEvent(channelID string, channelType uint8, marker *okstore.MessageDeleteMarker) *messageDeleteEvent {
+	return &messageDeleteEvent{
+		ChannelID:       channelID,
+		ChannelType:     channelType,
+		UID:             marker.UID,
+		StartMessageSeq: marker.StartMessageSeq,
+		EndMessageSeq:   marker.EndMessageSeq,
+		MessageIDs:      marker.MessageIDs,
+		Deleter:         marker.Deleter,
+		DeletedAt:       marker.DeletedAt,
+	}
+}
+
+type MessageDeleteMarkersReq struct {
+	UID         string `json:"uid"`          // 查询者UID（个人频道必传，不为空则同时返回只对此用户的删除标记）
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+}
+
+func (req MessageDeleteMarkersReq) Check() error {
+	if req.ChannelID == "" || req.ChannelType == 0 {
+		return errors.New("channel_id or channel_type cannot be empty")
+	}
+	if req.ChannelType == okproto.ChannelTypePerson && strings.TrimSpace(req.UID) == "" {
+		return errors.New("uid cannot be empty")
+	}
+	return nil
+}
+
+type MessageEditHistoryReq struct {
+	UID         string `json:"uid"`          // 查询者UID（个人频道必传）
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+	MessageSeq  uint32 `json:"message_seq"`  // 消息序列号
+}
+
+func (req MessageEditHistoryReq) Check() error {
+	if req.ChannelID == "" || req.ChannelType == 0 {
+		return errors.New("channel_id or channel_type cannot be empty")
+	}
+	if req.MessageSeq == 0 {
+		return errors.New("message_seq cannot be 0")
+	}
+	if req.ChannelType == okproto.ChannelTypePerson && strings.TrimSpace(req.UID) == "" {
+		return errors.New("uid cannot be empty")
+	}
+	return nil
+}
+
+type MessageEditResp struct {
+	MessageID   int64  `json:"message_id"`   // 消息ID
+	MessageSeq  uint32 `json:"message_seq"`  // 消息序列号
+	EditVersion uint32 `json:"edit_version"` // 编辑版本
+	Payload     []byte `json:"payload"`      // 编辑后的消息内容
+	Editor      string `json:"editor"`       // 编辑者UID
+	EditedAt    int64  `json:"edited_at"`    // 编辑时间(10位，到秒)
+}
+
+func newMessageEditResp(edit *okstore.MessageEdit) *MessageEditResp {
+	return &MessageEditResp{
+		MessageID:   edit.MessageID,
+		MessageSeq:  edit.MessageSeq,
+		EditVersion: edit.EditVersion,
+		Payload:     edit.Content,
+		Editor:      edit.Editor,
+		EditedAt:    edit.EditedAt,
+	}
+}
+
+type MessageReadReq struct {
+	UID         string `json:"uid"`          // 已读用户UID
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+	MessageSeq  uint32 `json:"message_seq"`  // 已读到的消息序列号（包含）
+}
+
+func (req MessageReadReq) Check() error {
+	if strings.TrimSpace(req.UID) == "" {
+		return errors.New("uid cannot be empty")
+	}
+	if req.ChannelID == "" || req.ChannelType == 0 {
+		return errors.New("channel_id or channel_type cannot be empty")
+	}
+	if req.MessageSeq == 0 {
+		return errors.New("message_seq cannot be 0")
+	}
+	return nil
+}
+
+type MessageReceiptReq struct {
+	UID         string   `json:"uid"`          // 查询者UID（个人频道必传）
+	ChannelID   string   `json:"channel_id"`   // 频道ID
+	ChannelType uint8    `json:"channel_type"` // 频道类型
+	MessageSeqs []uint32 `json:"message_seqs"` // 消息序列号集合
+}
+
+func (req MessageReceiptReq) Check() error {
+	if req.ChannelID == "" || req.ChannelType == 0 {
+		return errors.New("channel_id or channel_type cannot be empty")
+	}
+	if len(req.MessageSeqs) == 0 {
+		return errors.New("message_seqs cannot be empty")
+	}
+	if len(req.MessageSeqs) > 100 {
+		return errors.New("message_seqs cannot exceed 100")
+	}
+	if req.ChannelType == okproto.ChannelTypePerson && strings.TrimSpace(req.UID) == "" {
+		return errors.New("uid cannot be empty")
+	}
+	return nil
+}
+
+type MessageReceiptResp struct {
+	MessageID    int64  `json:"message_id"`    // 消息ID
+	MessageIDStr string `json:"message_idstr"` // 消息ID
+	MessageSeq   uint32 `json:"message_seq"`   // 消息序列号
+	ReadedCount  int    `json:"readed_count"`  // 已读人数
+	UnreadCount  int    `json:"unread_count"`  // 未读人数
+}
+
+func newMessageReceiptResp(messageID int64, messageSeq uint32, readedCount int, receiverCount int) *MessageReceiptResp {
+	unreadCount := receiverCount - readedCount
+	if unreadCount < 0 { // 订阅者有变动
+		unreadCount = 0
+	}
+	return &MessageReceiptResp{
+		MessageID:    messageID,
+		MessageIDStr: strconv.FormatInt(messageID, 10),
+		MessageSeq:   messageSeq,
+		ReadedCount:  readedCount,
+		UnreadCount:  unreadCount,
+	}
+}
+
+// 消息回执事件（通知给消息发送者）
+type messageReceiptEvent struct {
+	ChannelID   string                `json:"channel_id"`   // 频道ID
+	ChannelType uint8                 `json:"channel_type"` // 频道类型
+	Reader      string                `json:"reader"`       // 已读用户UID
+	Receipts    []*MessageReceiptResp `json:"receipts"`     // 消息回执
+}
+
+func (e messageReceiptEvent) forConn(fakeChannelID string, channelType uint8, conn oknet.Conn) (interface{}, error) {
+	e.ChannelID = getChannelIDForUID(fakeChannelID, channelType, conn.UID())
+	return &e, nil
+}
+
+type MessageReadersReq struct {
+	UID         string `json:"uid"`          // 查询者UID（个人频道必传）
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+	MessageSeq  uint32 `json:"message_seq"`  // 消息序列号
+}
+
+func (req MessageReadersReq) Check() error {
+	if req.ChannelID == "" || req.ChannelType == 0 {
+		return errors.New("channel_id or channel_type cannot be empty")
+	}
+	if req.MessageSeq == 0 {
+		return errors.New("message_seq cannot be 0")
+	}
+	if req.ChannelType == okproto.ChannelTypePerson && strings.TrimSpace(req.UID) == "" {
+		return errors.New("uid cannot be empty")
+	}
+	return nil
+}
+
+type MessageReaderResp struct {
+	UID      string `json:"uid"`       // 已读用户UID
+	ReadedAt int64  `json:"readed_at"` // 已读时间(10位，到秒)
+}
+
+// channelSignalReq 频道信号（正在输入等临时状态，c2s）
+type channelSignalReq struct {
+	ChannelID   string          `json:"channel_id"`     // 频道ID
+	ChannelType uint8           `json:"channel_type"`   // 频道类型
+	Signal      string          `json:"signal"`         // 信号类型 typing：正在输入 recording：正在录音 也可以是自定义的信号
+	Data        json.RawMessage `json:"data,omitempty"` // 信号数据（原样转发）
+}
+
+func (req channelSignalReq) Check() error {
+	if req.ChannelID == "" || req.ChannelType == 0 {
+		return errors.New("channel_id or channel_type cannot be empty")
+	}
+	if strings.TrimSpace(req.Signal) == "" {
+		return errors.New("signal cannot be empty")
+	}
+	if len(req.Signal) > channelSignalMaxLen {
+		return errors.New("signal is too long")
+	}
+	if len(req.Data) > channelSignalDataMaxSize {
+		return errors.New("data is too large")
+	}
+	return nil
+}
+
+// ReactionReq 添加或移除消息回应
+type ReactionReq struct {
+	UID         string `json:"uid"`          // 回应者UID
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+	MessageID   int64  `json:"message_id"`   // 消息ID
+	MessageSeq  uint32 `json:"message_seq"`  // 消息序列号
+	Emoji       string `json:"emoji"`        // 表情
+}
+
+func (req ReactionReq) Check() error {
+	if strings.TrimSpace(req.UID) == "" {
+		return errors.New("uid cannot be empty")
+	}
+	if req.ChannelID == "" || req.ChannelType == 0 {
+		return errors.New("channel_id or channel_type cannot be empty")
+	}
+	if req.MessageID == 0 || req.MessageSeq == 0 {
+		return errors.New("message_id or message_seq cannot be 0")
+	}
+	if strings.TrimSpace(req.Emoji) == "" {
+		return errors.New("emoji cannot be empty")
+	}
+	if len(req.Emoji) > reactionEmojiMaxLen {
+		return errors.New("emoji is too long")
+	}
+	return nil
+}
+
+// MessagePinReq 置顶或取消置顶消息
+type MessagePinReq struct {
+	UID         string `json:"uid"`          // 操作者UID
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+	MessageSeq  uint32 `json:"message_seq"`  // 消息序列号
+	MessageID   int64  `json:"message_id"`   // 消息ID（不为0时校验消息ID是否一致）
+}
+
+func (req MessagePinReq) Check() error {
+	if strings.TrimSpace(req.UID) == "" {
+		return errors.New("uid cannot be empty")
+	}
+	if req.ChannelID == "" || req.ChannelType == 0 {
+		return errors.New("channel_id or channel_type cannot be empty")
+	}
+	if req.MessageSeq == 0 {
+		return errors.New("message_seq cannot be 0")
+	}
+	return nil
+}
+
+// MessagePinSyncReq 同步频道的置顶消息
+type MessagePinSyncReq struct {
+	LoginUID    string `json:"login_uid"`    // 当前登录用户的uid（个人频道必传）
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+	Version     uint64 `json:"version"`      // 客户端的置顶版本号，和服务端一致则不返回置顶列表
+}
+
+func (req MessagePinSyncReq) Check() error {
+	if req.ChannelID == "" || req.ChannelType == 0 {
+		return errors.New("channel_id or channel_type cannot be empty")
+	}
+	if req.ChannelType == okproto.ChannelTypePerson && strings.TrimSpace(req.LoginUID) == "" {
+		return errors.New("login_uid cannot be empty")
+	}
+	return nil
+}
+
+type MessagePinResp struct {
+	MessageID  int64        `json:"message_id"`        // 消息ID
+	MessageSeq uint32       `json:"message_seq"`       // 消息序列号
+	UID        string       `json:"uid"`               // 置顶者UID
+	PinnedAt   int64        `json:"pinned_at"`         // 置顶时间（10位，到秒）
+	Message    *MessageResp `json:"message,omitempty"` // 置顶的消息（已删除的不返回）
+}
+
+type MessagePinSyncResp struct {
+	Version uint64            `json:"version"` // 置顶版本号
+	Changed int               `json:"changed"` // 版本号是否有变化 1.是 0.否（没有变化不返回pins）
+	Pins    []*MessagePinResp `json:"pins"`    // 置顶的消息（按置顶时间升序）
+}
+
+// messagePinNotify 置顶或取消置顶消息的系统通知内容
+type messagePinNotify struct {
+	Type       int    `json:"type"`        // 消息类型 ContentTypeMessagePin
+	Action     string `json:"action"`      // pin.置顶 unpin.取消置顶
+	UID        string `json:"uid"`         // 操作者UID
+	MessageID  int64  `json:"message_id"`  // 消息ID
+	MessageSeq uint32 `json:"message_seq"` // 消息序列号
+	Version    uint64 `json:"version"`     // 操作后的置顶版本号
+}
+
+// ReactionSyncReq 增量同步频道的消息回应
+type ReactionSyncReq struct {
+	LoginUID    string `json:"login_uid"`    // 当前登录用户的uid（个人频道必传）
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+	Seq         uint64 `json:"seq"`          // 客户端已同步到的回应序号（结果不包含此序号），0表示从头同步
+	Limit       int    `json:"limit"`        // 每次同步数量限制
+}
+
+func (req ReactionSyncReq) Check() error {
+	if req.ChannelID == "" || req.ChannelType == 0 {
+		return errors.New("channel_id or channel_type cannot be empty")
+	}
+	if req.ChannelType == okproto.ChannelTypePerson && strings.TrimSpace(req.LoginUID) == "" {
+		return errors.New("login_uid cannot be empty")
+	}
+	return nil
+}
+
+// ReactionResp 消息回应（同时作为回应事件的数据）
+type ReactionResp struct {
+	ChannelID   string `json:"channel_id"`   // 频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型
+	MessageID   int64  `json:"message_id"`   // 消息ID
+	MessageSeq  uint32 `json:"message_seq"`  // 消息序列号
+	UID         string `json:"uid"`          // 回应者UID
+	Emoji       string `json:"emoji"`        // 表情
+	Deleted     int    `json:"deleted"`      // 是否已移除 1.是 0.否
+	Seq         uint64 `json:"seq"`          // 频道内的回应序号
+	CreatedAt   int64  `json:"created_at"`   // 添加或移除的时间（10位，到秒）
+}
+
+func (r ReactionResp) forConn(fakeChannelID string, channelType uint8, conn oknet.Conn) (interface{}, error) {
+	r.ChannelID = getChannelIDForUID(fakeChannelID, channelType, conn.UID())
+	return &r, nil
+}
+
+func newReactionResp(channelID string, channelType uint8, reaction *okstore.Reaction) *ReactionResp {
+	return &ReactionResp{
+		ChannelID:   channelID,
+		ChannelType: channelType,
+		MessageID:   reaction.MessageID,
+		MessageSeq:  reaction.MessageSeq,
+		UID:         reaction.UID,
+		Emoji:       reaction.Emoji,
+		Deleted:     okutil.BoolToInt(reaction.Deleted),
+		Seq:         reaction.Seq,
+		CreatedAt:   reaction.CreatedAt,
+	}
+}
+
+type ReactionSyncResp struct {
+	More      int             `json:"more"`      // 是否还有更多 1.是 0.否
+	Reactions []*ReactionResp `json:"reactions"` // 回应数据（按回应序号升序）
+}
+
+type ThreadListReq struct {
+	ChannelID   string `json:"channel_id"`   // 社区频道ID
+	ChannelType uint8  `json:"channel_type"` // 频道类型（只支持社区频道）
+	Since       int64  `json:"since"`        // 只返回最后回复时间不早于此时间的子区（10位，到秒），0表示不限制
+	Limit       int    `json:"limit"`        // 数量限制
+}
+
+func (req ThreadListReq) Check() error {
+	if strings.TrimSpace(req.ChannelID) == "" {
+		return errors.New("channel_id cannot be empty")
+	}
+	if req.ChannelType != okproto.ChannelTypeCommunity {
+		return errors.New("only community channel has threads")
+	}
+	return nil
+}
+
+// ThreadResp 子区
+type ThreadResp struct {
+	ChannelID           string       `json:"channel_id"`             // 子区频道ID（频道类型为社区话题频道）
+	RootMessageID       int64        `json:"root_message_id"`        // 被回复的消息ID
+	RootMessageSeq      uint32       `json:"root_message_seq"`       // 被回复的消息序号
+	ReplyCount          uint32       `json:"reply_count"`            // 回复数
+	LastReplyUID        string       `json:"last_reply_uid"`         // 最后一条回复的发送者
+	LastReplyMessageID  int64        `json:"last_reply_message_id"`  // 最后一条回复的消息ID
+	LastReplyMessageSeq uint32       `json:"last_reply_message_seq"` // 最后一条回复在子区频道的消息序号
+	LastReplyAt         int64        `json:"last_reply_at"`          // 最后一条回复的时间（10位，到秒）
+	RootMessage         *MessageResp `json:"root_message,omitempty"` // 被回复的消息（已删除的不返回）
+}
+
+func newThreadResp(channelID string, thread *okstore.Thread) *ThreadResp {
+	return &ThreadResp{
+		ChannelID:           GetThreadChannelID(channelID, thread.RootMessageSeq),
+		RootMessageID:       thread.RootMessageID,
+		RootMessageSeq:      thread.RootMessageSeq,
+		ReplyCount:          thread.ReplyCount,
+		LastReplyUID:        thread.LastReplyUID,
+		LastReplyMessageID:  thread.LastReplyMessageID,
+		LastReplyMessageSeq: thread.LastReplyMessageSeq,
+		LastReplyAt:         thread.LastReplyAt,
+	}
+}
+
+// presenceSubscribeReq 订阅（取消订阅）用户的在线状态（c2s）
+type presenceSubscribeReq struct {
+	UIDs []string `json:"uids"` // 用户UID列表（取消订阅时为空表示取消所有订阅）
+}
+
+func (req presenceSubscribeReq) Check() error {
+	for _, uid := range req.UIDs {
+		if strings.TrimSpace(uid) == "" {
+			return errors.New("uid cannot be empty")
+		}
+	}
+	return nil
+}
+
+// PresenceResp 用户的在线状态
+type PresenceResp struct {
+	UID         string  `json:"uid"`          // 用户UID
+	Online      uint8   `json:"online"`       // 是否有设备在线 1.在线 0.离线
+	DeviceFlags []uint8 `json:"device_flags"` // 在线的设备类型 0.app 1.web 2.pc
+	LastSeen    int64   `json:"last_seen"`    // 最后在线时间（10位时间戳，用户的设备上线或下线时更新）
+}
+
+// channelSignalEvent 频道信号事件（s2c）
+type channelSignalEvent struct {
+	ChannelID   string          `json:"channel_id"`     // 频道ID
+	ChannelType uint8           `json:"channel_type"`   // 频道类型
+	FromUID     string          `json:"from_uid"`       // 发送者UID
+	Signal      string          `json:"signal"`         // 信号类型
+	Data        json.RawMessage `json:"data,omitempty"` // 信号数据
+}
+
+// DeviceQuitReq 强制设备退出
+type DeviceQuitReq struct {
+	UID        string `json:"uid"`         // 用户uid
+	DeviceFlag int    `json:"device_flag"` // 设备flag 这里 -1 为用户所有的设备
+}
+
+// SystemUIDsReq 添加或移除系统uid
+type SystemUIDsReq struct {
+	UIDs []string `json:"uids"`
+}
+
+// UserReq 只需要用户uid的请求
+type UserReq struct {
+	UID string `json:"uid"` // 用户uid
+}
+
+// UserRateLimitReq 设置用户单独的发消息限流（同时作用于用户和用户每个设备的限流）
+type UserRateLimitReq struct {
+	UID string `json:"uid"` // 用户uid
+	okstore.RateLimit
+}
+
+func (r UserRateLimitReq) Check() error {
+	if strings.TrimSpace(r.UID) == "" {
+		return errors.New("uid不能为空！")
+	}
+	if r.Rate < 0 || r.Burst < 0 {
+		return errors.New("rate和burst不能小于0！")
+	}
+	return nil
+}
+
+// UserRateLimitResp 用户生效的发消息限流
+type UserRateLimitResp struct {
+	okstore.RateLimit
+	Custom bool `json:"custom"` // 是否是用户单独设置的限流（否则为全局配置的限流）
+}
+
+// IPBlacklistReq 添加或移除ip黑名单
+type IPBlacklistReq struct {
+	IPs []string `json:"ips"`
+}
+
+// StoreCompactReq 按保留策略压缩消息（不传channel_id则压缩当前节点的所有频道）
+type StoreCompactReq struct {
+	ChannelID   string `json:"channel_id"`
+	ChannelType uint8  `json:"channel_type"`
+}
+
+// APIKeyCreateReq 创建api密钥
+type APIKeyCreateReq struct {
+	Name   string   `json:"name"`   // 名称（比如运维监控、业务后台）
+	Scopes []string `json:"scopes"` // 权限范围 admin、monitor、message、channel、user
+}
+
+func (r APIKeyCreateReq) Check() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name不能为空！")
+	}
+	return checkAPIScopes(r.Scopes)
+}
+
+// APIKeyRemoveReq 移除api密钥
+type APIKeyRemoveReq struct {
+	ID string `json:"id"` // 密钥ID
+}
+
+func (r APIKeyRemoveReq) Check() error {
+	if strings.TrimSpace(r.ID) == "" {
+		return errors.New("id不能为空！")
+	}
+	return nil
+}
+
+// APIKeyResp api密钥（不返回密钥的sha256）
+type APIKeyResp struct {
+	ID        string   `json:"id"`            // 密钥ID
+	Name      string   `json:"name"`          // 名称
+	Scopes    []string `json:"scopes"`        // 权限范围
+	CreatedAt int64    `json:"created_at"`    // 创建时间（10位，到秒）
+	Key       string   `json:"key,omitempty"` // 密钥明文（只在创建时返回），请求api时放在header的token里
+}
+
+func newAPIKeyResp(apiKey *okstore.APIKey) *APIKeyResp {
+	return &APIKeyResp{
+		ID:        apiKey.ID,
+		Name:      apiKey.Name,
+		Scopes:    apiKey.Scopes,
+		CreatedAt: apiKey.CreatedAt,
+	}
+}
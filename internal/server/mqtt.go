@@ -0,0 +1,676 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/mqtt"
+	"github.com/samlau0508/imserver/pkg/oknet"
+	"github.com/samlau0508/imserver/pkg/okutil"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"go.uber.org/zap"
+)
+
+const (
+	mqttSessionKey = "mqttSession"
+	// mqttChannelTopicPrefix 非数据频道的主题前缀 格式：$channel/{channelType}/{channelID}
+	mqttChannelTopicPrefix = "$channel/"
+)
+
+// mqttInflight 下发给MQTT客户端等待PUBACK的消息
+type mqttInflight struct {
+	messageID  int64
+	messageSeq uint32
+	syncOnce   bool
+}
+
+// mqttPendingAck 等待聚合的SUBACK/UNSUBACK（一个MQTT的SUBSCRIBE对应多个SUB包）
+type mqttPendingAck struct {
+	unsubscribe bool
+	topics      []string
+	qos         []byte // 订阅请求的最大QoS
+	reasonCodes []mqtt.ReasonCode
+	remaining   int
+}
+
+// mqttSession MQTT连接的会话状态
+type mqttSession struct {
+	sync.Mutex
+	version          byte                       // MQTT协议版本
+	keepAlive        uint16                     // 保持连接（秒）
+	assignedClientID string                     // 服务端分配的客户端标识（客户端没传ClientID时）
+	lastPacketID     uint16                     // 最后分配的报文标识符
+	inflights        map[uint16]*mqttInflight   // 下发的QoS1消息 key: packetID
+	inflightIDs      map[int64]uint16           // key: messageID value: packetID（重发时沿用同一个packetID）
+	pendingAcks      map[uint16]*mqttPendingAck // 等待聚合的订阅确认 key: packetID
+	qos2Received     map[uint16]struct{}        // 已收到还未PUBREL的QoS2消息
+	grantedQoS       map[string]byte            // 订阅成功的主题授予的QoS key: topic
+}
+
+func newMQTTSession() *mqttSession {
+	return &mqttSession{
+		version:      mqtt.Version311,
+		inflights:    map[uint16]*mqttInflight{},
+		inflightIDs:  map[int64]uint16{},
+		pendingAcks:  map[uint16]*mqttPendingAck{},
+		qos2Received: map[uint16]struct{}{},
+		grantedQoS:   map[string]byte{},
+	}
+}
+
+// 分配一个未使用的报文标识符（调用方需加锁）
+func (m *mqttSession) nextPacketID() uint16 {
+	for i := 0; i < 65535; i++ {
+		m.lastPacketID++
+		if m.lastPacketID == 0 {
+			m.lastPacketID = 1
+		}
+		if _, ok := m.inflights[m.lastPacketID]; !ok {
+			return m.lastPacketID
+		}
+	}
+	return 0
+}
+
+func getMQTTSession(conn oknet.Conn) *mqttSession {
+	session, ok := conn.Value(mqttSessionKey).(*mqttSession)
+	if !ok {
+		session = newMQTTSession()
+		conn.SetValue(mqttSessionKey, session)
+	}
+	return session
+}
+
+// MQTT数据入口
+func (d *Dispatch) mqttDataIn(conn *oknet.MQTTConn) error {
+	buff, err := conn.Peek(-1)
+	if err != nil {
+		return err
+	}
+	if len(buff) == 0 {
+		return nil
+	}
+	session := getMQTTSession(conn)
+	var (
+		offset    = 0
+		hasFrames = false
+	)
+	for len(buff) > offset {
+		size, err := mqtt.DecodePacketLength(buff[offset:])
+		if err != nil {
+			d.Warn("Failed to decode the mqtt packet length", zap.Error(err))
+			conn.Close()
+			return nil
+		}
+		if size == 0 || offset+size > len(buff) { // 数据不完整
+			break
+		}
+		session.Lock()
+		version := session.version
+		session.Unlock()
+		packet, err := mqtt.ReadFromVersion(bytes.NewReader(buff[offset:offset+size]), version)
+		if err != nil {
+			d.Warn("Failed to decode the mqtt packet", zap.Error(err))
+			conn.Close()
+			return nil
+		}
+		offset += size
+
+		if !conn.IsAuthed() { // conn is not authed must be connect packet
+			connectPacket, ok := packet.(*mqtt.ConnectPacket)
+			if !ok {
+				d.Warn("请先进行连接！", zap.String("packetType", packet.GetFixedHeader().Type.String()))
+				conn.Close()
+				return nil
+			}
+			conn.Discard(offset)
+			buff = buff[offset:]
+			offset = 0
+			d.mqttConnect(conn, session, connectPacket)
+			if !conn.IsAuthed() {
+				return nil
+			}
+			continue
+		}
+
+		// 统计
+		d.s.monitor.UpstreamPackageAdd(1)
+		d.s.monitor.UpstreamTrafficAdd(size)
+		d.s.stats.inMsgs.Add(1)
+		d.s.stats.inBytes.Add(int64(size))
+
+		connStats := conn.ConnStats()
+		connStats.InMsgs.Add(1)
+		connStats.InBytes.Add(int64(size))
+
+		frames, err := d.mqttToFrames(conn, session, packet)
+		if err != nil {
+			d.Warn("Failed to process the mqtt packet", zap.Error(err), zap.String("packetType", packet.GetFixedHeader().Type.String()))
+			conn.Close()
+			return nil
+		}
+		if len(frames) > 0 {
+			connCtx := conn.Context().(*connContext)
+			for _, frame := range frames {
+				connCtx.putFrame(frame)
+			}
+			hasFrames = true
+		}
+	}
+	conn.Discard(offset)
+	if hasFrames {
+		d.processor.process(conn)
+	}
+	return nil
+}
+
+// 处理MQTT的CONNECT（转换为IM的CONNECT进行认证）
+func (d *Dispatch) mqttConnect(conn *oknet.MQTTConn, session *mqttSession, connectPacket *mqtt.ConnectPacket) {
+	version := connectPacket.ProtocolVersion
+	if version != mqtt.Version31 && version != mqtt.Version311 && version != mqtt.Version5 {
+		d.Warn("不支持的MQTT协议版本！", zap.Uint8("version", version))
+		d.mqttWrite(conn, &mqtt.ConnackPacket{
+			FixedHeader: mqtt.FixedHeader{Type: mqtt.CONNACK},
+			ReasonCode:  mqtt.ConnectRefusedUnacceptableProtocol,
+		})
+		d.s.timingWheel.AfterFunc(time.Second, func() {
+			conn.Close()
+		})
+		return
+	}
+	session.Lock()
+	session.version = version
+	session.keepAlive = connectPacket.KeepAlive
+	session.Unlock()
+
+	if !connectPacket.UsernameFlag || strings.TrimSpace(connectPacket.Username) == "" {
+		d.Warn("MQTT连接的用户名（UID）不能为空！", zap.String("clientID", connectPacket.ClientID))
+		d.mqttConnack(conn, session, okproto.ReasonAuthFail)
+		return
+	}
+	deviceID := connectPacket.ClientID
+	if deviceID == "" {
+		deviceID = okutil.GenUUID()
+		session.Lock()
+		session.assignedClientID = deviceID
+		session.Unlock()
+	}
+	// MQTT客户端没有IM协议的密钥协商，这里由服务端代为生成客户端公钥，下发的消息在服务端解密后再转发给MQTT客户端
+	_, dhClientPublicKey := okutil.GetCurve25519KeypPair()
+	d.processor.processAuth(conn, &okproto.ConnectPacket{
+		Version:         okproto.LatestVersion,
+		ClientKey:       base64.StdEncoding.EncodeToString(dhClientPublicKey[:]),
+		DeviceID:        deviceID,
+		DeviceFlag:      okproto.DeviceFlag(d.s.opts.MQTTDeviceFlag),
+		ClientTimestamp: time.Now().UnixNano() / 1000 / 1000,
+		UID:             connectPacket.Username,
+		Token:           string(connectPacket.Password),
+	})
+	if conn.IsAuthed() && connectPacket.KeepAlive > 0 {
+		conn.SetMaxIdle(time.Duration(connectPacket.KeepAlive) * time.Second * 3 / 2) // 超过1.5倍的保持连接时间没有收到数据则断开
+	}
+}
+
+// 将MQTT包转换为IM的包
+func (d *Dispatch) mqttToFrames(conn *oknet.MQTTConn, session *mqttSession, packet mqtt.ControlPacket) ([]okproto.Frame, error) {
+	switch p := packet.(type) {
+	case *mqtt.PublishPacket:
+		return d.mqttPublish(conn, session, p)
+	case *mqtt.PubackPacket:
+		session.Lock()
+		inflight := session.inflights[p.PacketID]
+		if inflight != nil {
+			delete(session.inflights, p.PacketID)
+			delete(session.inflightIDs, inflight.messageID)
+		}
+		session.Unlock()
+		if inflight == nil {
+			return nil, nil
+		}
+		return []okproto.Frame{&okproto.RecvackPacket{
+			Framer: okproto.Framer{
+				SyncOnce: inflight.syncOnce,
+			},
+			MessageID:  inflight.messageID,
+			MessageSeq: inflight.messageSeq,
+		}}, nil
+	case *mqtt.PubrelPacket:
+		session.Lock()
+		delete(session.qos2Received, p.PacketID)
+		session.Unlock()
+		d.mqttWrite(conn, mqtt.NewPubcomp(session.version, p.PacketID, mqtt.Success))
+		return nil, nil
+	case *mqtt.PubrecPacket, *mqtt.PubcompPacket:
+		// 服务端下发的消息最高为QoS1，不会收到这两种包
+		return nil, nil
+	case *mqtt.SubscribePacket:
+		return d.mqttSubscribe(conn, session, p)
+	case *mqtt.UnsubscribePacket:
+		return d.mqttUnsubscribe(conn, session, p)
+	case *mqtt.PingreqPacket:
+		return []okproto.Frame{&okproto.PingPacket{}}, nil
+	case *mqtt.DisconnectPacket:
+		d.Debug("mqtt client disconnect", zap.Any("conn", conn), zap.Uint8("reasonCode", uint8(p.ReasonCode)))
+		conn.Close()
+		return nil, nil
+	case *mqtt.ConnectPacket:
+		return nil, fmt.Errorf("%w: duplicate connect packet", mqtt.ErrProtocolViolation)
+	}
+	return nil, fmt.Errorf("%w: unsupported packet type %s", mqtt.ErrProtocolViolation, packet.GetFixedHeader().Type.String())
+}
+
+// 发布消息
+func (d *Dispatch) mqttPublish(conn *oknet.MQTTConn, session *mqttSession, publishPacket *mqtt.PublishPacket) ([]okproto.Frame, error) {
+	qos := publishPacket.QoS()
+	if qos == 2 {
+		session.Lock()
+		_, received := session.qos2Received[publishPacket.PacketID]
+		if !received {
+			session.qos2Received[publishPacket.PacketID] = struct{}{}
+		}
+		session.Unlock()
+		if received { // 重复的QoS2消息，只回复PUBREC不再发送
+			d.mqttWrite(conn, mqtt.NewPubrec(session.version, publishPacket.PacketID, mqtt.Success))
+			return nil, nil
+		}
+	}
+	channelID, channelType, err := mqttTopicToChannel(publishPacket.TopicName)
+	if err != nil {
+		d.Warn("MQTT主题不合法！", zap.Error(err), zap.String("topic", publishPacket.TopicName))
+		d.mqttPubResult(conn, session, qos, publishPacket.PacketID, mqtt.TopicNameInvalid)
+		return nil, nil
+	}
+	payloadEnc, err := encryptMessagePayload(publishPacket.Payload, conn)
+	if err != nil {
+		return nil, err
+	}
+	sendPacket := &okproto.SendPacket{
+		Framer: okproto.Framer{
+			RedDot: true,
+		},
+		ClientSeq:   uint64(qos)<<16 | uint64(publishPacket.PacketID),
+		ClientMsgNo: okutil.GenUUID(),
+		ChannelID:   channelID,
+		ChannelType: channelType,
+		Payload:     payloadEnc,
+	}
+	if publishPacket.Properties != nil && publishPacket.Properties.MessageExpiry != nil {
+		sendPacket.Expire = *publishPacket.Properties.MessageExpiry
+	}
+	sendPacket.MsgKey, err = makeMsgKey(sendPacket.VerityString(), conn)
+	if err != nil {
+		return nil, err
+	}
+	return []okproto.Frame{sendPacket}, nil
+}
+
+// 回复PUBACK或PUBREC
+func (d *Dispatch) mqttPubResult(conn *oknet.MQTTConn, session *mqttSession, qos byte, packetID uint16, reasonCode mqtt.ReasonCode) {
+	switch qos {
+	case 1:
+		d.mqttWrite(conn, mqtt.NewPuback(session.version, packetID, reasonCode))
+	case 2:
+		if reasonCode >= mqtt.UnspecifiedError { // 失败的QoS2消息不会再有PUBREL
+			session.Lock()
+			delete(session.qos2Received, packetID)
+			session.Unlock()
+		}
+		d.mqttWrite(conn, mqtt.NewPubrec(session.version, packetID, reasonCode))
+	}
+}
+
+// 订阅
+func (d *Dispatch) mqttSubscribe(conn *oknet.MQTTConn, session *mqttSession, subscribePacket *mqtt.SubscribePacket) ([]okproto.Frame, error) {
+	topics := make([]string, 0, len(subscribePacket.Subscriptions))
+	qos := make([]byte, 0, len(subscribePacket.Subscriptions))
+	for _, sub := range subscribePacket.Subscriptions {
+		topics = append(topics, sub.TopicFilter)
+		qos = append(qos, sub.QoS)
+	}
+	return d.mqttSubFrames(conn, session, subscribePacket.PacketID, topics, qos, okproto.Subscribe), nil
+}
+
+// 取消订阅
+func (d *Dispatch) mqttUnsubscribe(conn *oknet.MQTTConn, session *mqttSession, unsubscribePacket *mqtt.UnsubscribePacket) ([]okproto.Frame, error) {
+	return d.mqttSubFrames(conn, session, unsubscribePacket.PacketID, unsubscribePacket.TopicFilters, nil, okproto.UnSubscribe), nil
+}
+
+func (d *Dispatch) mqttSubFrames(conn *oknet.MQTTConn, session *mqttSession, packetID uint16, topics []string, qos []byte, action okproto.Action) []okproto.Frame {
+	pendingAck := &mqttPendingAck{
+		unsubscribe: action == okproto.UnSubscribe,
+		topics:      topics,
+		qos:         qos,
+		reasonCodes: make([]mqtt.ReasonCode, len(topics)),
+	}
+	frames := make([]okproto.Frame, 0, len(topics))
+	for i, topic := range topics {
+		reasonCode := mqttCheckTopicFilter(topic)
+		if reasonCode != mqtt.Success {
+			pendingAck.reasonCodes[i] = reasonCode
+			continue
+		}
+		channelID, channelType, err := mqttTopicToChannel(topic)
+		if err != nil {
+			pendingAck.reasonCodes[i] = mqtt.TopicFilterInvalid
+			continue
+		}
+		frames = append(frames, &okproto.SubPacket{
+			SubNo:       fmt.Sprintf("%d:%d", packetID, i),
+			ChannelID:   channelID,
+			ChannelType: channelType,
+			Action:      action,
+		})
+	}
+	pendingAck.remaining = len(frames)
+	if pendingAck.remaining == 0 {
+		d.mqttWrite(conn, session.newSubResult(packetID, pendingAck))
+		return nil
+	}
+	session.Lock()
+	session.pendingAcks[packetID] = pendingAck
+	session.Unlock()
+	return frames
+}
+
+// 生成SUBACK或UNSUBACK
+func (m *mqttSession) newSubResult(packetID uint16, pendingAck *mqttPendingAck) mqtt.ControlPacket {
+	reasonCodes := make([]mqtt.ReasonCode, 0, len(pendingAck.reasonCodes))
+	for _, reasonCode := range pendingAck.reasonCodes {
+		if m.version < mqtt.Version5 && reasonCode >= mqtt.UnspecifiedError {
+			reasonCode = mqtt.SubscribeFailure // v3.1.1只有一种失败码
+		}
+		reasonCodes = append(reasonCodes, reasonCode)
+	}
+	if pendingAck.unsubscribe {
+		return &mqtt.UnsubackPacket{
+			FixedHeader: mqtt.FixedHeader{Type: mqtt.UNSUBACK, Version: m.version},
+			PacketID:    packetID,
+			ReasonCodes: reasonCodes,
+		}
+	}
+	return &mqtt.SubackPacket{
+		FixedHeader: mqtt.FixedHeader{Type: mqtt.SUBACK, Version: m.version},
+		PacketID:    packetID,
+		ReasonCodes: reasonCodes,
+	}
+}
+
+// MQTT数据出口（将IM的包转换为MQTT的包）
+func (d *Dispatch) mqttDataOut(conn *oknet.MQTTConn, frames ...okproto.Frame) {
+	session := getMQTTSession(conn)
+	closeConn := false
+	for _, frame := range frames {
+		switch f := frame.(type) {
+		case *okproto.ConnackPacket:
+			d.mqttConnack(conn, session, f.ReasonCode)
+		case *okproto.SendackPacket:
+			qos := byte(f.ClientSeq >> 16)
+			packetID := uint16(f.ClientSeq)
+			d.mqttPubResult(conn, session, qos, packetID, mqttReasonCode(f.ReasonCode))
+		case *okproto.RecvPacket:
+			d.mqttRecv(conn, session, f)
+		case *okproto.SubackPacket:
+			d.mqttSuback(conn, session, f)
+		case *okproto.PongPacket:
+			d.mqttWrite(conn, &mqtt.PingrespPacket{FixedHeader: mqtt.FixedHeader{Type: mqtt.PINGRESP}})
+		case *okproto.DisconnectPacket:
+			if session.version >= mqtt.Version5 {
+				disconnect := &mqtt.DisconnectPacket{
+					FixedHeader: mqtt.FixedHeader{Type: mqtt.DISCONNECT, Version: session.version},
+					ReasonCode:  mqtt.AdministrativeAction,
+				}
+				if f.ReasonCode == okproto.ReasonConnectKick {
+					disconnect.ReasonCode = mqtt.SessionTakenOver
+				}
+				if f.Reason != "" {
+					disconnect.Properties = &mqtt.Properties{ReasonString: f.Reason}
+				}
+				d.mqttWrite(conn, disconnect)
+			}
+			closeConn = true
+		default:
+			// EVENT等IM协议专有的包MQTT客户端不支持，直接丢弃
+			d.Debug("mqtt conn not support the frame", zap.String("frameType", frame.GetFrameType().String()))
+		}
+	}
+	if closeConn { // MQTT协议在DISCONNECT后必须断开连接
+		d.s.timingWheel.AfterFunc(time.Second, func() {
+			conn.Close()
+		})
+	}
+}
+
+func (d *Dispatch) mqttConnack(conn *oknet.MQTTConn, session *mqttSession, reasonCode okproto.ReasonCode) {
+	session.Lock()
+	version := session.version
+	assignedClientID := session.assignedClientID
+	session.Unlock()
+
+	connack := &mqtt.ConnackPacket{
+		FixedHeader: mqtt.FixedHeader{Type: mqtt.CONNACK, Version: version},
+	}
+	if version >= mqtt.Version5 {
+		switch reasonCode {
+		case okproto.ReasonSuccess:
+			connack.ReasonCode = mqtt.Success
+			connack.Properties = &mqtt.Properties{
+				AssignedClientID:     assignedClientID,
+				MaximumQoS:           mqtt.Byte(2),
+				RetainAvailable:      mqtt.Byte(0),
+				WildcardSubAvailable: mqtt.Byte(0),
+				SharedSubAvailable:   mqtt.Byte(0),
+			}
+		case okproto.ReasonAuthFail:
+			connack.ReasonCode = mqtt.BadUserNameOrPassword
+		case okproto.ReasonBan:
+			connack.ReasonCode = mqtt.Banned
+		case okproto.ReasonNodeNotMatch: // 用户不属于此节点
+			connack.ReasonCode = mqtt.UseAnotherServer
+		case okproto.ReasonNodeMatchError:
+			connack.ReasonCode = mqtt.ServerUnavailable
+		case okproto.ReasonRateLimit:
+			connack.ReasonCode = mqtt.ConnectionRateExceeded
+		default:
+			connack.ReasonCode = mqtt.UnspecifiedError
+		}
+	} else {
+		switch reasonCode {
+		case okproto.ReasonSuccess:
+			connack.ReasonCode = mqtt.ConnectAccepted
+		case okproto.ReasonAuthFail:
+			connack.ReasonCode = mqtt.ConnectRefusedBadUsernamePassword
+		case okproto.ReasonBan:
+			connack.ReasonCode = mqtt.ConnectRefusedNotAuthorized
+		case okproto.ReasonNodeNotMatch, okproto.ReasonNodeMatchError:
+			connack.ReasonCode = mqtt.ConnectRefusedServerUnavailable
+		default:
+			connack.ReasonCode = mqtt.ConnectRefusedServerUnavailable
+		}
+	}
+	d.mqttWrite(conn, connack)
+}
+
+// 下发消息
+func (d *Dispatch) mqttRecv(conn *oknet.MQTTConn, session *mqttSession, recvPacket *okproto.RecvPacket) {
+	payload, err := decryptMessagePayload(recvPacket.Payload, conn)
+	if err != nil {
+		d.Warn("Failed to decrypt the message payload", zap.Error(err), zap.Int64("messageID", recvPacket.MessageID))
+		return
+	}
+	session.Lock()
+	version := session.version
+	publishPacket := &mqtt.PublishPacket{
+		FixedHeader: mqtt.FixedHeader{Type: mqtt.PUBLISH, Version: version},
+		TopicName:   mqttChannelToTopic(recvPacket.ChannelID, recvPacket.ChannelType),
+		Payload:     payload,
+	}
+	grantedQoS, subscribed := session.grantedQoS[publishPacket.TopicName]
+	if recvPacket.NoPersist || (subscribed && grantedQoS == 0) { // 不存储的消息不会重试，订阅时只授予了QoS0的主题也使用QoS0
+		publishPacket.SetFlags(false, 0, false)
+	} else {
+		packetID, dup := session.inflightIDs[recvPacket.MessageID]
+		if !dup {
+			packetID = session.nextPacketID()
+			if packetID == 0 {
+				session.Unlock()
+				d.Warn("mqtt inflight is full", zap.Any("conn", conn), zap.Int64("messageID", recvPacket.MessageID))
+				return
+			}
+			session.inflightIDs[recvPacket.MessageID] = packetID
+			session.inflights[packetID] = &mqttInflight{
+				messageID:  recvPacket.MessageID,
+				messageSeq: recvPacket.MessageSeq,
+				syncOnce:   recvPacket.SyncOnce,
+			}
+		}
+		publishPacket.PacketID = packetID
+		publishPacket.SetFlags(dup, 1, false)
+	}
+	session.Unlock()
+
+	if version >= mqtt.Version5 {
+		properties := &mqtt.Properties{}
+		properties.AddUser("from_uid", recvPacket.FromUID)
+		properties.AddUser("message_id", strconv.FormatInt(recvPacket.MessageID, 10))
+		properties.AddUser("message_seq", strconv.FormatUint(uint64(recvPacket.MessageSeq), 10))
+		properties.AddUser("client_msg_no", recvPacket.ClientMsgNo)
+		if recvPacket.Expire > 0 {
+			properties.MessageExpiry = mqtt.Uint32(recvPacket.Expire)
+		}
+		publishPacket.Properties = properties
+	}
+	d.mqttWrite(conn, publishPacket)
+}
+
+// 订阅结果（多个SUBACK聚合为一个MQTT的SUBACK）
+func (d *Dispatch) mqttSuback(conn *oknet.MQTTConn, session *mqttSession, subackPacket *okproto.SubackPacket) {
+	var packetID, index int
+	if _, err := fmt.Sscanf(subackPacket.SubNo, "%d:%d", &packetID, &index); err != nil {
+		d.Warn("mqtt suback subNo is illegal", zap.String("subNo", subackPacket.SubNo))
+		return
+	}
+	session.Lock()
+	pendingAck := session.pendingAcks[uint16(packetID)]
+	if pendingAck == nil || index < 0 || index >= len(pendingAck.reasonCodes) {
+		session.Unlock()
+		return
+	}
+	if subackPacket.ReasonCode == okproto.ReasonSuccess {
+		topic := pendingAck.topics[index]
+		if pendingAck.unsubscribe {
+			pendingAck.reasonCodes[index] = mqtt.Success
+			delete(session.grantedQoS, topic)
+		} else {
+			// 授予的QoS不能超过请求的QoS，下发最高只支持QoS1
+			grantedQoS := pendingAck.qos[index]
+			if grantedQoS > 1 {
+				grantedQoS = 1
+			}
+			pendingAck.reasonCodes[index] = mqtt.ReasonCode(grantedQoS)
+			session.grantedQoS[topic] = grantedQoS
+		}
+	} else {
+		pendingAck.reasonCodes[index] = mqttReasonCode(subackPacket.ReasonCode)
+	}
+	pendingAck.remaining--
+	done := pendingAck.remaining <= 0
+	if done {
+		delete(session.pendingAcks, uint16(packetID))
+	}
+	session.Unlock()
+	if done {
+		d.mqttWrite(conn, session.newSubResult(uint16(packetID), pendingAck))
+	}
+}
+
+func (d *Dispatch) mqttWrite(conn *oknet.MQTTConn, packet mqtt.ControlPacket) {
+	var buf bytes.Buffer
+	if err := packet.Encode(&buf); err != nil {
+		d.Warn("Failed to encode the mqtt packet", zap.Error(err))
+		return
+	}
+	// 统计
+	dataLen := buf.Len()
+	d.s.monitor.DownstreamTrafficAdd(dataLen)
+	d.s.outBytes.Add(int64(dataLen))
+	conn.ConnStats().OutBytes.Add(int64(dataLen))
+
+	if _, err := conn.WriteToOutboundBuffer(buf.Bytes()); err != nil {
+		d.Warn("Failed to write the mqtt packet", zap.Error(err))
+		return
+	}
+	if err := conn.WakeWrite(); err != nil {
+		d.Warn("Failed to wake write", zap.Error(err))
+	}
+}
+
+// IM的原因码转换为MQTT的原因码
+func mqttReasonCode(reasonCode okproto.ReasonCode) mqtt.ReasonCode {
+	switch reasonCode {
+	case okproto.ReasonSuccess:
+		return mqtt.Success
+	case okproto.ReasonAuthFail, okproto.ReasonSubscriberNotExist, okproto.ReasonInBlacklist, okproto.ReasonNotAllowSend,
+		okproto.ReasonNotInWhitelist, okproto.ReasonBan, okproto.ReasonNoPermission:
+		return mqtt.NotAuthorized
+	case okproto.ReasonChannelNotExist, okproto.ReasonChannelIDError, okproto.ReasonNotSupportChannelType:
+		return mqtt.TopicNameInvalid
+	case okproto.ReasonMsgKeyError, okproto.ReasonPayloadDecodeError:
+		return mqtt.PayloadFormatInvalid
+	case okproto.ReasonRateLimit:
+		return mqtt.QuotaExceeded
+	}
+	return mqtt.UnspecifiedError
+}
+
+// 检查订阅的主题过滤器（不支持通配符和共享订阅）
+func mqttCheckTopicFilter(topic string) mqtt.ReasonCode {
+	if strings.TrimSpace(topic) == "" {
+		return mqtt.TopicFilterInvalid
+	}
+	if strings.HasPrefix(topic, "$share/") {
+		return mqtt.SharedSubscriptionsNotSupported
+	}
+	if strings.ContainsAny(topic, "+#") {
+		return mqtt.WildcardSubscriptionsNotSupported
+	}
+	return mqtt.Success
+}
+
+// 主题转换为频道 普通主题为数据频道（ChannelTypeData），其他类型的频道使用 $channel/{channelType}/{channelID}
+func mqttTopicToChannel(topic string) (string, uint8, error) {
+	if strings.TrimSpace(topic) == "" || strings.ContainsAny(topic, "+#") {
+		return "", 0, fmt.Errorf("topic[%s] is illegal", topic)
+	}
+	channelID := topic
+	channelType := okproto.ChannelTypeData
+	if strings.HasPrefix(topic, mqttChannelTopicPrefix) {
+		parts := strings.SplitN(strings.TrimPrefix(topic, mqttChannelTopicPrefix), "/", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return "", 0, fmt.Errorf("topic[%s] is illegal", topic)
+		}
+		channelTypeI, err := strconv.ParseUint(parts[0], 10, 8)
+		if err != nil {
+			return "", 0, fmt.Errorf("topic[%s] channel type is illegal", topic)
+		}
+		channelID = parts[1]
+		channelType = uint8(channelTypeI)
+	}
+	if channelIDURL, err := url.Parse(channelID); err != nil || strings.TrimSpace(channelIDURL.Path) == "" {
+		return "", 0, fmt.Errorf("topic[%s] channel id is illegal", topic)
+	}
+	return channelID, channelType, nil
+}
+
+// 频道转换为主题
+func mqttChannelToTopic(channelID string, channelType uint8) string {
+	if channelType == okproto.ChannelTypeData {
+		return channelID
+	}
+	return fmt.Sprintf("%s%d/%s", mqttChannelTopicPrefix, channelType, channelID)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/mqtt"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMQTTTopicToChannel(t *testing.T) {
+	channelID, channelType, err := mqttTopicToChannel("sensor/1")
+	assert.NoError(t, err)
+	assert.Equal(t, "sensor/1", channelID)
+	assert.Equal(t, okproto.ChannelTypeData, channelType)
+
+	channelID, channelType, err = mqttTopicToChannel("$channel/2/group1")
+	assert.NoError(t, err)
+	assert.Equal(t, "group1", channelID)
+	assert.Equal(t, okproto.ChannelTypeGroup, channelType)
+	assert.Equal(t, "$channel/2/group1", mqttChannelToTopic(channelID, channelType))
+
+	_, _, err = mqttTopicToChannel("$channel/x/group1")
+	assert.Error(t, err)
+	_, _, err = mqttTopicToChannel("sensor/+")
+	assert.Error(t, err)
+
+	assert.Equal(t, mqtt.WildcardSubscriptionsNotSupported, mqttCheckTopicFilter("sensor/#"))
+	assert.Equal(t, mqtt.SharedSubscriptionsNotSupported, mqttCheckTopicFilter("$share/g/sensor"))
+}
+
+func TestMQTTSendAndRecv(t *testing.T) {
+	vp := viper.New()
+	vp.Set("rootDir", t.TempDir())
+	vp.Set("addr", "tcp://127.0.0.1:0")
+	vp.Set("wsAddr", "ws://127.0.0.1:0")
+	vp.Set("mqttAddr", "tcp://127.0.0.1:0")
+	vp.Set("httpAddr", "127.0.0.1:0")
+	opts := NewTestOptions()
+	opts.ConfigureWithViper(vp)
+	opts.DataDir = t.TempDir()
+	s := NewTestServer(opts)
+	err := s.Start()
+	assert.NoError(t, err)
+	defer s.Stop()
+
+	addr := s.dispatch.engine.MQTTRealListenAddr().String()
+
+	conn1 := mqttTestConnect(t, addr, "uid1", mqtt.Version5)
+	defer conn1.Close()
+	conn2 := mqttTestConnect(t, addr, "uid2", mqtt.Version311)
+	defer conn2.Close()
+
+	// uid1发送给uid2
+	publish := &mqtt.PublishPacket{
+		FixedHeader: mqtt.FixedHeader{Type: mqtt.PUBLISH, Version: mqtt.Version5},
+		TopicName:   "$channel/1/uid2",
+		PacketID:    1,
+		Payload:     []byte("hello"),
+	}
+	publish.SetFlags(false, 1, false)
+	err = publish.Encode(conn1)
+	assert.NoError(t, err)
+
+	packet := mqttTestRead(t, conn1, mqtt.Version5)
+	puback, ok := packet.(*mqtt.PubackPacket)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(1), puback.PacketID)
+	assert.Equal(t, mqtt.Success, puback.ReasonCode)
+
+	packet = mqttTestRead(t, conn2, mqtt.Version311)
+	recv, ok := packet.(*mqtt.PublishPacket)
+	assert.True(t, ok)
+	assert.Equal(t, "$channel/1/uid1", recv.TopicName)
+	assert.Equal(t, byte(1), recv.QoS())
+	assert.Equal(t, []byte("hello"), recv.Payload)
+	err = mqtt.NewPuback(mqtt.Version311, recv.PacketID, mqtt.Success).Encode(conn2)
+	assert.NoError(t, err)
+
+	// 不支持通配符订阅
+	subscribe := &mqtt.SubscribePacket{
+		FixedHeader:   mqtt.FixedHeader{Type: mqtt.SUBSCRIBE, Version: mqtt.Version5},
+		PacketID:      2,
+		Subscriptions: []mqtt.Subscription{{TopicFilter: "sensor/#", QoS: 1}},
+	}
+	err = subscribe.Encode(conn1)
+	assert.NoError(t, err)
+	packet = mqttTestRead(t, conn1, mqtt.Version5)
+	suback, ok := packet.(*mqtt.SubackPacket)
+	assert.True(t, ok)
+	assert.Equal(t, []mqtt.ReasonCode{mqtt.WildcardSubscriptionsNotSupported}, suback.ReasonCodes)
+
+	// 授予的QoS不超过请求的QoS（最高QoS1）
+	subscribe = &mqtt.SubscribePacket{
+		FixedHeader:   mqtt.FixedHeader{Type: mqtt.SUBSCRIBE, Version: mqtt.Version311},
+		PacketID:      3,
+		Subscriptions: []mqtt.Subscription{{TopicFilter: "sensor/1", QoS: 0}, {TopicFilter: "sensor/2", QoS: 2}},
+	}
+	err = subscribe.Encode(conn2)
+	assert.NoError(t, err)
+	suback, ok = mqttTestRead(t, conn2, mqtt.Version311).(*mqtt.SubackPacket)
+	assert.True(t, ok)
+	assert.Equal(t, []mqtt.ReasonCode{mqtt.GrantedQoS0, mqtt.GrantedQoS1}, suback.ReasonCodes)
+	subscribe = &mqtt.SubscribePacket{
+		FixedHeader:   mqtt.FixedHeader{Type: mqtt.SUBSCRIBE, Version: mqtt.Version5},
+		PacketID:      4,
+		Subscriptions: []mqtt.Subscription{{TopicFilter: "sensor/1", QoS: 1}},
+	}
+	err = subscribe.Encode(conn1)
+	assert.NoError(t, err)
+	suback, ok = mqttTestRead(t, conn1, mqtt.Version5).(*mqtt.SubackPacket)
+	assert.True(t, ok)
+	assert.Equal(t, []mqtt.ReasonCode{mqtt.GrantedQoS1}, suback.ReasonCodes)
+
+	// 只授予了QoS0的主题使用QoS0下发
+	publish = &mqtt.PublishPacket{
+		FixedHeader: mqtt.FixedHeader{Type: mqtt.PUBLISH, Version: mqtt.Version5},
+		TopicName:   "sensor/1",
+		PacketID:    5,
+		Payload:     []byte("22.5"),
+	}
+	publish.SetFlags(false, 1, false)
+	err = publish.Encode(conn1)
+	assert.NoError(t, err)
+	puback, ok = mqttTestRead(t, conn1, mqtt.Version5).(*mqtt.PubackPacket)
+	assert.True(t, ok)
+	assert.Equal(t, mqtt.Success, puback.ReasonCode)
+	recv, ok = mqttTestRead(t, conn2, mqtt.Version311).(*mqtt.PublishPacket)
+	assert.True(t, ok)
+	assert.Equal(t, "sensor/1", recv.TopicName)
+	assert.Equal(t, byte(0), recv.QoS())
+	assert.Equal(t, []byte("22.5"), recv.Payload)
+
+	// 心跳
+	err = (&mqtt.PingreqPacket{FixedHeader: mqtt.FixedHeader{Type: mqtt.PINGREQ}}).Encode(conn2)
+	assert.NoError(t, err)
+	packet = mqttTestRead(t, conn2, mqtt.Version311)
+	_, ok = packet.(*mqtt.PingrespPacket)
+	assert.True(t, ok)
+}
+
+func mqttTestConnect(t *testing.T, addr string, uid string, version byte) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	connect := &mqtt.ConnectPacket{
+		FixedHeader:     mqtt.FixedHeader{Type: mqtt.CONNECT},
+		ProtocolVersion: version,
+		CleanStart:      true,
+		KeepAlive:       30,
+		ClientID:        uid + "-device",
+		UsernameFlag:    true,
+		Username:        uid,
+	}
+	err = connect.Encode(conn)
+	assert.NoError(t, err)
+
+	packet := mqttTestRead(t, conn, version)
+	connack, ok := packet.(*mqtt.ConnackPacket)
+	assert.True(t, ok)
+	assert.Equal(t, mqtt.Success, connack.ReasonCode)
+	return conn
+}
+
+func mqttTestRead(t *testing.T, conn net.Conn, version byte) mqtt.ControlPacket {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	packet, err := mqtt.ReadFromVersion(conn, version)
+	assert.NoError(t, err)
+	return packet
+}
@@ -14,6 +14,9 @@ import (
 	"github.com/samlau0508/imserver/pkg/oknet/crypto/tls"
 
 	"github.com/gin-gonic/gin"
+	"github.com/samlau0508/imserver/pkg/okauth"
+	"github.com/samlau0508/imserver/pkg/oksearch"
+	"github.com/samlau0508/imserver/pkg/okstore"
 	"github.com/samlau0508/imserver/pkg/okutil"
 	okproto "github.com/samlau0508/imserver/pkg/proto"
 	"github.com/samlau0508/imserver/version"
@@ -46,11 +49,20 @@ type Options struct {
 	GinMode     string       // gin框架的模式
 	WSAddr      string       // websocket 监听地址 例如：ws://0.0.0.0:5200
 	WSSAddr     string       // wss 监听地址 例如：wss://0.0.0.0:5210
+	MQTTAddr    string       // mqtt 监听地址 例如：tcp://0.0.0.0:1883 （为空则不开启）
 	WSTLSConfig *tls.Config
 	WSSConfig   struct { // wss的证书配置
 		CertFile string // 证书文件
 		KeyFile  string // 私钥文件
 	}
+	TCPTLSConfig *tls.Config
+	TLSConfig    struct { // tcp长连接的证书配置（配置后tcp长连接使用TLS）
+		CertFile       string        // 证书文件
+		KeyFile        string        // 私钥文件
+		ClientCAFile   string        // 客户端CA证书文件（配置后要求客户端提供证书，双向认证）
+		ReloadInterval time.Duration // 检查证书文件是否变化的间隔，变化后新的连接使用新证书 0表示不检查
+	}
+	MQTTDeviceFlag uint8 // mqtt连接使用的设备标识 默认为0（APP）
 
 	Logger struct {
 		Dir     string // 日志存储目录
@@ -77,7 +89,7 @@ type Options struct {
 		CacheCount                int  // 频道缓存数量
 		CreateIfNoExist           bool // 如果频道不存在是否创建
 		SubscriberCompressOfCount int  // 订订阅者数组多大开始压缩（离线推送的时候订阅者数组太大 可以设置此参数进行压缩 默认为0 表示不压缩 ）
-
+		MaxPinCount               int  // 每个频道最多置顶的消息数量 0表示不限制
 	}
 	TmpChannel struct { // 临时频道配置
 		Suffix     string // 临时频道的后缀
@@ -120,19 +132,69 @@ type Options struct {
 
 	TokenAuthOn bool // 是否开启token验证 不配置将根据mode属性判断 debug模式下默认为false release模式为true
 
+	Auth struct { // 连接认证配置（开启token验证时生效）
+		Verifier string                // 认证方式 store：校验通过/user/token更新的token（默认） jwt：校验jwt callback：回调http或grpc接口认证
+		JWT      okauth.JWTConfig      // jwt认证配置
+		Callback okauth.CallbackConfig // 回调认证配置
+	}
+
 	EventPoolSize int // 事件协程池大小,此池主要处理im的一些通知事件 比如webhook，上下线等等 默认为1024
 
 	WhitelistOffOfPerson int
 	DeliveryMsgPoolSize  int // 投递消息协程池大小，此池的协程主要用来将消息投递给在线用户 默认大小为 10240
 
 	MessageRetry struct {
-		Interval     time.Duration // 消息重试间隔，如果消息发送后在此间隔内没有收到ack，将会在此间隔后重新发送
-		MaxCount     int           // 消息最大重试次数
-		ScanInterval time.Duration //  每隔多久扫描一次超时队列，看超时队列里是否有需要重试的消息
+		Interval      time.Duration // 消息重试间隔，如果消息发送后在此间隔内没有收到ack，将会在此间隔后重新发送
+		MaxCount      int           // 消息最大重试次数
+		ScanInterval  time.Duration //  每隔多久扫描一次超时队列，看超时队列里是否有需要重试的消息
+		ResumeTimeout time.Duration // 投递中的消息会持久化，服务重启后设备在此时间内重新连接则继续重试，超过则丢弃
+	}
+
+	Message struct {
+		RevokeTimeout       time.Duration // 客户端可撤回消息的时间，超过此时间将不能撤回，0为不限制（api撤回不受此限制）
+		ReceiptMaxReadCount int           // 一次已读上报最多计算回执的消息数量，超过的更早的消息将不计算回执，0为不限制
 	}
 
 	SlotNum int // 槽数量
 
+	Store struct { // 存储配置
+		Driver             string        // 存储驱动 file：文件存储 memory：内存存储（不持久化，重启后数据丢失，适用于测试和临时部署） 默认为file
+		ExpireScanInterval time.Duration // 扫描并删除过期消息的间隔（同时按保留策略压缩已加载的频道）
+		ArchiveDir         string        // 归档目录 不为空则超出保留策略的消息文件移动到归档目录，否则直接删除（需要和数据目录在同一个文件系统）
+
+		Retention             okstore.RetentionPolicy           // 默认的消息保留策略
+		ChannelTypeRetentions map[uint8]okstore.RetentionPolicy // 频道类型的消息保留策略（优先于默认的保留策略）
+	}
+
+	Search struct { // 消息搜索配置
+		On        bool   // 是否开启消息搜索 开启后新存储的消息会建立全文索引（开启之前的消息不会被搜索到）
+		Tokenizer string // 分词器 standard：字母数字按单词，中日韩文字按二元组 whitespace：按空白分词 默认为standard
+	}
+
+	RateLimit struct { // 限流配置（令牌桶） rate为每秒允许的次数，burst为允许突发的次数，rate为0表示不限制
+		On             bool              // 是否开启限流 被限流的消息将返回ReasonRateLimit的发送回执，被限流的连接将返回ReasonRateLimit的连接回执
+		SendPerUID     okstore.RateLimit // 每个用户发消息的限流（可通过api给用户单独设置，管理者和系统账号不限制）
+		SendPerDevice  okstore.RateLimit // 每个设备发消息的限流
+		SendPerIP      okstore.RateLimit // 每个来源ip发消息的限流
+		SignalPerUID   okstore.RateLimit // 每个用户发送频道信号（比如正在输入）的限流
+		ConnectPerIP   okstore.RateLimit // 每个来源ip发起连接的限流
+		UserCacheCount int               // 用户单独设置的限流缓存数量
+	}
+
+	Presence struct { // 在线状态订阅配置
+		MaxSubscriptions int           // 每个连接最多订阅的用户数量 0表示不限制 默认1000
+		NotifyInterval   time.Duration // 在线状态变化的推送间隔（间隔内同一用户的多次变化合并推送） 默认1秒
+	}
+
+	Cluster struct { // 分布式配置
+		On                bool          // 是否开启分布式
+		NodeID            int64         // 当前节点ID（同时作为消息ID生成的节点ID，集群内必须唯一）
+		Nodes             []string      // 集群所有节点（包含自己） 格式：节点ID@api地址 例如：1@http://127.0.0.1:5001
+		Secret            string        // 节点之间请求的共享密钥（集群内所有节点必须一致），节点之间的/cluster/接口必须携带
+		HeartbeatInterval time.Duration // 节点心跳间隔 默认2秒
+		NodeTimeout       time.Duration // 超过此时间没有心跳则认为节点离线 默认10秒
+	}
+
 	// MsgRetryInterval     time.Duration // Message sending timeout time, after this time it will try again
 	// MessageMaxRetryCount int           // 消息最大重试次数
 	// TimeoutScanInterval time.Duration // 每隔多久扫描一次超时队列，看超时队列里是否有需要重试的消息
@@ -178,14 +240,24 @@ func NewOptions() *Options {
 			Suffix:     "@tmp",
 			CacheCount: 500,
 		},
+		TLSConfig: struct {
+			CertFile       string
+			KeyFile        string
+			ClientCAFile   string
+			ReloadInterval time.Duration
+		}{
+			ReloadInterval: time.Minute,
+		},
 		Channel: struct {
 			CacheCount                int
 			CreateIfNoExist           bool
 			SubscriberCompressOfCount int
+			MaxPinCount               int
 		}{
 			CacheCount:                1000,
 			CreateIfNoExist:           true,
 			SubscriberCompressOfCount: 0,
+			MaxPinCount:               50,
 		},
 		Datasource: struct {
 			Addr          string
@@ -211,13 +283,22 @@ func NewOptions() *Options {
 		DeliveryMsgPoolSize: 10240,
 		EventPoolSize:       1024,
 		MessageRetry: struct {
-			Interval     time.Duration
-			MaxCount     int
-			ScanInterval time.Duration
+			Interval      time.Duration
+			MaxCount      int
+			ScanInterval  time.Duration
+			ResumeTimeout time.Duration
+		}{
+			Interval:      time.Second * 60,
+			ScanInterval:  time.Second * 5,
+			MaxCount:      5,
+			ResumeTimeout: time.Hour * 24,
+		},
+		Message: struct {
+			RevokeTimeout       time.Duration
+			ReceiptMaxReadCount int
 		}{
-			Interval:     time.Second * 60,
-			ScanInterval: time.Second * 5,
-			MaxCount:     5,
+			RevokeTimeout:       time.Minute * 2,
+			ReceiptMaxReadCount: 500,
 		},
 		Webhook: struct {
 			HTTPAddr                    string
@@ -245,6 +326,67 @@ func NewOptions() *Options {
 			Addr: "0.0.0.0:5172",
 		},
 		SlotNum: 256,
+		Store: struct {
+			Driver             string
+			ExpireScanInterval time.Duration
+			ArchiveDir         string
+
+			Retention             okstore.RetentionPolicy
+			ChannelTypeRetentions map[uint8]okstore.RetentionPolicy
+		}{
+			Driver:                okstore.DriverFile,
+			ExpireScanInterval:    time.Minute * 10,
+			ChannelTypeRetentions: map[uint8]okstore.RetentionPolicy{},
+		},
+		Search: struct {
+			On        bool
+			Tokenizer string
+		}{
+			Tokenizer: oksearch.TokenizerStandard,
+		},
+		Auth: struct {
+			Verifier string
+			JWT      okauth.JWTConfig
+			Callback okauth.CallbackConfig
+		}{
+			Verifier: okauth.VerifierStore,
+			JWT:      okauth.NewConfig().JWT,
+			Callback: okauth.NewConfig().Callback,
+		},
+		RateLimit: struct {
+			On             bool
+			SendPerUID     okstore.RateLimit
+			SendPerDevice  okstore.RateLimit
+			SendPerIP      okstore.RateLimit
+			SignalPerUID   okstore.RateLimit
+			ConnectPerIP   okstore.RateLimit
+			UserCacheCount int
+		}{
+			SendPerUID:     okstore.RateLimit{Rate: 10, Burst: 50},
+			SendPerDevice:  okstore.RateLimit{Rate: 10, Burst: 50},
+			SendPerIP:      okstore.RateLimit{Rate: 100, Burst: 500},
+			SignalPerUID:   okstore.RateLimit{Rate: 5, Burst: 20},
+			ConnectPerIP:   okstore.RateLimit{Rate: 5, Burst: 20},
+			UserCacheCount: 10000,
+		},
+		Presence: struct {
+			MaxSubscriptions int
+			NotifyInterval   time.Duration
+		}{
+			MaxSubscriptions: 1000,
+			NotifyInterval:   time.Second,
+		},
+		Cluster: struct {
+			On                bool
+			NodeID            int64
+			Nodes             []string
+			Secret            string
+			HeartbeatInterval time.Duration
+			NodeTimeout       time.Duration
+		}{
+			HeartbeatInterval: time.Second * 2,
+			NodeTimeout:       time.Second * 10,
+		},
 	}
 }
 
@@ -295,13 +437,21 @@ func (o *Options) ConfigureWithViper(vp *viper.Viper) {
 
 	o.WSAddr = o.getString("wsAddr", o.WSAddr)
 	o.WSSAddr = o.getString("wssAddr", o.WSSAddr)
+	o.MQTTAddr = o.getString("mqttAddr", o.MQTTAddr)
+	o.MQTTDeviceFlag = uint8(o.getInt("mqttDeviceFlag", int(o.MQTTDeviceFlag)))
 
 	o.WSSConfig.CertFile = o.getString("wssConfig.certFile", o.WSSConfig.CertFile)
 	o.WSSConfig.KeyFile = o.getString("wssConfig.keyFile", o.WSSConfig.KeyFile)
 
+	o.TLSConfig.CertFile = o.getString("tlsConfig.certFile", o.TLSConfig.CertFile)
+	o.TLSConfig.KeyFile = o.getString("tlsConfig.keyFile", o.TLSConfig.KeyFile)
+	o.TLSConfig.ClientCAFile = o.getString("tlsConfig.clientCAFile", o.TLSConfig.ClientCAFile)
+	o.TLSConfig.ReloadInterval = o.getDuration("tlsConfig.reloadInterval", o.TLSConfig.ReloadInterval)
+
 	o.Channel.CacheCount = o.getInt("channel.cacheCount", o.Channel.CacheCount)
 	o.Channel.CreateIfNoExist = o.getBool("channel.createIfNoExist", o.Channel.CreateIfNoExist)
 	o.Channel.SubscriberCompressOfCount = o.getInt("channel.subscriberCompressOfCount", o.Channel.SubscriberCompressOfCount)
+	o.Channel.MaxPinCount = o.getInt("channel.maxPinCount", o.Channel.MaxPinCount)
 
 	o.ConnIdleTime = o.getDuration("connIdleTime", o.ConnIdleTime)
 
@@ -312,6 +462,20 @@ func (o *Options) ConfigureWithViper(vp *viper.Viper) {
 
 	o.TokenAuthOn = o.getBool("tokenAuthOn", o.TokenAuthOn)
 
+	o.Auth.Verifier = o.getString("auth.verifier", o.Auth.Verifier)
+	o.Auth.JWT.Alg = o.getString("auth.jwt.alg", o.Auth.JWT.Alg)
+	o.Auth.JWT.Secret = o.getString("auth.jwt.secret", o.Auth.JWT.Secret)
+	o.Auth.JWT.SecretFile = o.getString("auth.jwt.secretFile", o.Auth.JWT.SecretFile)
+	o.Auth.JWT.PublicKeyFile = o.getString("auth.jwt.publicKeyFile", o.Auth.JWT.PublicKeyFile)
+	o.Auth.JWT.UIDClaim = o.getString("auth.jwt.uidClaim", o.Auth.JWT.UIDClaim)
+	o.Auth.JWT.DeviceLevelClaim = o.getString("auth.jwt.deviceLevelClaim", o.Auth.JWT.DeviceLevelClaim)
+	o.Auth.JWT.Issuer = o.getString("auth.jwt.issuer", o.Auth.JWT.Issuer)
+	o.Auth.JWT.Audience = o.getString("auth.jwt.audience", o.Auth.JWT.Audience)
+	o.Auth.JWT.Leeway = o.getDuration("auth.jwt.leeway", o.Auth.JWT.Leeway)
+	o.Auth.Callback.HTTPAddr = o.getString("auth.callback.httpAddr", o.Auth.Callback.HTTPAddr)
+	o.Auth.Callback.GRPCAddr = o.getString("auth.callback.grpcAddr", o.Auth.Callback.GRPCAddr)
+	o.Auth.Callback.Timeout = o.getDuration("auth.callback.timeout", o.Auth.Callback.Timeout)
+
 	o.UnitTest = o.vp.GetBool("unitTest")
 
 	o.Webhook.GRPCAddr = o.getString("webhook.grpcAddr", o.Webhook.GRPCAddr)
@@ -335,6 +499,10 @@ func (o *Options) ConfigureWithViper(vp *viper.Viper) {
 	o.MessageRetry.Interval = o.getDuration("messageRetry.interval", o.MessageRetry.Interval)
 	o.MessageRetry.ScanInterval = o.getDuration("messageRetry.scanInterval", o.MessageRetry.ScanInterval)
 	o.MessageRetry.MaxCount = o.getInt("messageRetry.maxCount", o.MessageRetry.MaxCount)
+	o.MessageRetry.ResumeTimeout = o.getDuration("messageRetry.resumeTimeout", o.MessageRetry.ResumeTimeout)
+
+	o.Message.RevokeTimeout = o.getDuration("message.revokeTimeout", o.Message.RevokeTimeout)
+	o.Message.ReceiptMaxReadCount = o.getInt("message.receiptMaxReadCount", o.Message.ReceiptMaxReadCount)
 
 	o.Conversation.On = o.getBool("conversation.on", o.Conversation.On)
 	o.Conversation.CacheExpire = o.getDuration("conversation.cacheExpire", o.Conversation.CacheExpire)
@@ -344,6 +512,45 @@ func (o *Options) ConfigureWithViper(vp *viper.Viper) {
 
 	o.SlotNum = o.getInt("slotNum", o.SlotNum)
 
+	o.Store.Driver = o.getString("store.driver", o.Store.Driver)
+	o.Store.ExpireScanInterval = o.getDuration("store.expireScanInterval", o.Store.ExpireScanInterval)
+	o.Store.ArchiveDir = o.getString("store.archiveDir", o.Store.ArchiveDir)
+	o.Store.Retention = o.getRetention("store.retention", o.Store.Retention)
+	for channelTypeStr := range o.vp.GetStringMap("store.channelTypeRetentions") {
+		channelType, err := strconv.ParseUint(channelTypeStr, 10, 8)
+		if err != nil {
+			panic(fmt.Sprintf("store.channelTypeRetentions的频道类型[%s]格式有误！", channelTypeStr))
+		}
+		o.Store.ChannelTypeRetentions[uint8(channelType)] = o.getRetention(fmt.Sprintf("store.channelTypeRetentions.%s", channelTypeStr), okstore.RetentionPolicy{})
+	}
+
+	o.Search.On = o.getBool("search.on", o.Search.On)
+	o.Search.Tokenizer = o.getString("search.tokenizer", o.Search.Tokenizer)
+
+	o.RateLimit.On = o.getBool("rateLimit.on", o.RateLimit.On)
+	o.RateLimit.SendPerUID = o.getRateLimit("rateLimit.sendPerUID", o.RateLimit.SendPerUID)
+	o.RateLimit.SendPerDevice = o.getRateLimit("rateLimit.sendPerDevice", o.RateLimit.SendPerDevice)
+	o.RateLimit.SendPerIP = o.getRateLimit("rateLimit.sendPerIP", o.RateLimit.SendPerIP)
+	o.RateLimit.SignalPerUID = o.getRateLimit("rateLimit.signalPerUID", o.RateLimit.SignalPerUID)
+	o.RateLimit.ConnectPerIP = o.getRateLimit("rateLimit.connectPerIP", o.RateLimit.ConnectPerIP)
+	o.RateLimit.UserCacheCount = o.getInt("rateLimit.userCacheCount", o.RateLimit.UserCacheCount)
+
+	o.Presence.MaxSubscriptions = o.getInt("presence.maxSubscriptions", o.Presence.MaxSubscriptions)
+	o.Presence.NotifyInterval = o.getDuration("presence.notifyInterval", o.Presence.NotifyInterval)
+	if o.Presence.NotifyInterval <= 0 {
+		o.Presence.NotifyInterval = time.Second
+	}
+
+	o.Cluster.On = o.getBool("cluster.on", o.Cluster.On)
+	o.Cluster.NodeID = o.getInt64("cluster.nodeID", o.Cluster.NodeID)
+	o.Cluster.Nodes = o.getStringSlice("cluster.nodes", o.Cluster.Nodes)
+	o.Cluster.Secret = o.getString("cluster.secret", o.Cluster.Secret)
+	o.Cluster.HeartbeatInterval = o.getDuration("cluster.heartbeatInterval", o.Cluster.HeartbeatInterval)
+	o.Cluster.NodeTimeout = o.getDuration("cluster.nodeTimeout", o.Cluster.NodeTimeout)
+	if o.Cluster.On {
+		o.ID = o.Cluster.NodeID // 消息ID按节点ID生成，保证集群内唯一
+	}
+
 	if o.WSSConfig.CertFile != "" && o.WSSConfig.KeyFile != "" {
 		certificate, err := tls.LoadX509KeyPair(o.WSSConfig.CertFile, o.WSSConfig.KeyFile)
 		if err != nil {
@@ -355,6 +562,13 @@ func (o *Options) ConfigureWithViper(vp *viper.Viper) {
 			},
 		}
 	}
+	if o.TLSConfig.CertFile != "" && o.TLSConfig.KeyFile != "" {
+		tlsConfig, err := newTCPTLSConfig(o.TLSConfig.CertFile, o.TLSConfig.KeyFile, o.TLSConfig.ClientCAFile, o.TLSConfig.ReloadInterval)
+		if err != nil {
+			panic(err)
+		}
+		o.TCPTLSConfig = tlsConfig
+	}
 
 	o.configureDataDir() // 数据目录
 	o.configureLog(vp)   // 日志配置
@@ -481,6 +695,14 @@ func (o *Options) getInt64(key string, defaultValue int64) int64 {
 	return v
 }
 
+func (o *Options) getStringSlice(key string, defaultValue []string) []string {
+	v := o.vp.GetStringSlice(key)
+	if len(v) == 0 {
+		return defaultValue
+	}
+	return v
+}
+
 func (o *Options) getDuration(key string, defaultValue time.Duration) time.Duration {
 	v := o.vp.GetDuration(key)
 	if v == 0 {
@@ -489,6 +711,26 @@ func (o *Options) getDuration(key string, defaultValue time.Duration) time.Durat
 	return v
 }
 
+// getRetention 读取消息保留策略配置 maxAge为时间格式（比如720h）
+func (o *Options) getRetention(key string, defaultValue okstore.RetentionPolicy) okstore.RetentionPolicy {
+	return okstore.RetentionPolicy{
+		MaxAge:      int64(o.getDuration(key+".maxAge", time.Duration(defaultValue.MaxAge)*time.Second).Seconds()),
+		MaxMessages: uint32(o.getInt64(key+".maxMessages", int64(defaultValue.MaxMessages))),
+		MaxBytes:    o.getInt64(key+".maxBytes", defaultValue.MaxBytes),
+	}
+}
+
+// getRateLimit 读取限流配置 rate配置为0表示不限制（不使用默认值）
+func (o *Options) getRateLimit(key string, defaultValue okstore.RateLimit) okstore.RateLimit {
+	if o.vp.Get(key+".rate") == nil {
+		return defaultValue
+	}
+	return okstore.RateLimit{
+		Rate:  o.vp.GetFloat64(key + ".rate"),
+		Burst: o.getInt(key+".burst", defaultValue.Burst),
+	}
+}
+
 // WebhookOn WebhookOn
 func (o *Options) WebhookOn() bool {
 	return strings.TrimSpace(o.Webhook.HTTPAddr) != "" || o.WebhookGRPCOn()
@@ -0,0 +1,379 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/oklog"
+	"github.com/samlau0508/imserver/pkg/oknet"
+	"github.com/samlau0508/imserver/pkg/okutil"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"go.uber.org/zap"
+)
+
+// 其他节点登记的订阅的有效期，订阅者所在节点会定时续期
+const presenceWatchTTL = time.Minute * 3
+
+// PresenceManager 在线状态订阅
+// 客户端订阅一批用户的在线状态，用户的设备上线或下线时推送最新的在线状态给订阅者（订阅跟随连接，连接关闭后订阅失效）
+// 集群模式下用户的连接都在用户所在节点，订阅其他节点的用户时需要在用户所在节点登记本节点
+type PresenceManager struct {
+	s *Server
+
+	subLock     sync.RWMutex
+	subscribers map[string]map[int64]struct{} // 被订阅的uid -> 订阅的连接ID
+	connSubs    map[int64]map[string]struct{} // 连接ID -> 连接订阅的uid
+
+	watchLock sync.Mutex
+	watchers  map[string]map[int64]int64 // 本节点的uid -> 订阅了此用户的其他节点 -> 过期时间（10位时间戳）
+
+	changeLock sync.Mutex
+	changes    map[string]int64 // 在线状态发生变化的本节点用户 -> 变化时间（10位时间戳）
+
+	stopChan chan struct{}
+	oklog.Log
+}
+
+// NewPresenceManager NewPresenceManager
+func NewPresenceManager(s *Server) *PresenceManager {
+	return &PresenceManager{
+		s:           s,
+		subscribers: map[string]map[int64]struct{}{},
+		connSubs:    map[int64]map[string]struct{}{},
+		watchers:    map[string]map[int64]int64{},
+		changes:     map[string]int64{},
+		stopChan:    make(chan struct{}),
+		Log:         oklog.NewOKLog("PresenceManager"),
+	}
+}
+
+// Start Start
+func (pm *PresenceManager) Start() {
+	go pm.loop()
+}
+
+// Stop Stop
+func (pm *PresenceManager) Stop() {
+	close(pm.stopChan)
+	pm.flush() // 保存最后在线时间
+}
+
+// Change 本节点用户的在线状态发生变化（同一个推送间隔内的多次变化合并推送）
+func (pm *PresenceManager) Change(uid string) {
+	pm.changeLock.Lock()
+	pm.changes[uid] = time.Now().Unix()
+	pm.changeLock.Unlock()
+}
+
+// Subscribe 连接订阅一批用户的在线状态，订阅成功后会先推送这些用户当前的在线状态
+func (pm *PresenceManager) Subscribe(conn oknet.Conn, uids []string) okproto.ReasonCode {
+	uids = okutil.RemoveRepeatedElement(uids)
+	pm.subLock.Lock()
+	subs := pm.connSubs[conn.ID()]
+	if subs == nil {
+		subs = map[string]struct{}{}
+	}
+	newUIDs := make([]string, 0, len(uids))
+	for _, uid := range uids {
+		if _, ok := subs[uid]; !ok {
+			newUIDs = append(newUIDs, uid)
+		}
+	}
+	maxSubscriptions := pm.s.opts.Presence.MaxSubscriptions
+	if maxSubscriptions > 0 && len(subs)+len(newUIDs) > maxSubscriptions {
+		pm.subLock.Unlock()
+		pm.Warn("订阅的用户数量超过限制！", zap.String("uid", conn.UID()), zap.Int("count", len(subs)+len(newUIDs)), zap.Int("max", maxSubscriptions))
+		return okproto.ReasonSubscriptionLimit
+	}
+	pm.connSubs[conn.ID()] = subs
+	for _, uid := range newUIDs {
+		subs[uid] = struct{}{}
+		connIDs := pm.subscribers[uid]
+		if connIDs == nil {
+			connIDs = map[int64]struct{}{}
+			pm.subscribers[uid] = connIDs
+		}
+		connIDs[conn.ID()] = struct{}{}
+	}
+	pm.subLock.Unlock()
+
+	// 查询在线状态和登记订阅可能需要请求其他节点，不能阻塞连接的事件循环
+	err := pm.s.handleGoroutinePool.Submit(func() {
+		remoteUIDMap := pm.watchRemote(newUIDs)
+		presences, err := pm.presences(uids, remoteUIDMap)
+		if err != nil {
+			pm.Error("获取用户在线状态失败！", zap.Error(err), zap.String("uid", conn.UID()))
+			return
+		}
+		pm.push(conn, presences)
+	})
+	if err != nil {
+		pm.Error("提交在线状态订阅任务失败！", zap.Error(err))
+	}
+	return okproto.ReasonSuccess
+}
+
+// Unsubscribe 连接取消订阅一批用户的在线状态，uids为空则取消连接的所有订阅
+func (pm *PresenceManager) Unsubscribe(conn oknet.Conn, uids []string) {
+	pm.removeConnSubs(conn.ID(), uids)
+}
+
+// RemoveConn 连接关闭，移除连接的所有订阅
+func (pm *PresenceManager) RemoveConn(connID int64) {
+	pm.removeConnSubs(connID, nil)
+}
+
+func (pm *PresenceManager) removeConnSubs(connID int64, uids []string) {
+	pm.subLock.Lock()
+	defer pm.subLock.Unlock()
+	subs := pm.connSubs[connID]
+	if subs == nil {
+		return
+	}
+	if len(uids) == 0 {
+		uids = make([]string, 0, len(subs))
+		for uid := range subs {
+			uids = append(uids, uid)
+		}
+	}
+	for _, uid := range uids {
+		delete(subs, uid)
+		connIDs := pm.subscribers[uid]
+		delete(connIDs, connID)
+		if len(connIDs) == 0 {
+			delete(pm.subscribers, uid)
+		}
+	}
+	if len(subs) == 0 {
+		delete(pm.connSubs, connID)
+	}
+}
+
+// Presences 获取一批用户的在线状态（集群模式下其他节点的用户去其所在节点查询）
+func (pm *PresenceManager) Presences(uids []string) ([]*PresenceResp, error) {
+	_, remoteUIDMap := pm.s.clusterManager.SplitUIDsByNode(uids)
+	return pm.presences(uids, remoteUIDMap)
+}
+
+func (pm *PresenceManager) presences(uids []string, remoteUIDMap map[int64][]string) ([]*PresenceResp, error) {
+	remoteUIDs := map[string]struct{}{}
+	for _, nodeUIDs := range remoteUIDMap {
+		for _, uid := range nodeUIDs {
+			remoteUIDs[uid] = struct{}{}
+		}
+	}
+	localUIDs := make([]string, 0, len(uids))
+	for _, uid := range uids {
+		if _, ok := remoteUIDs[uid]; !ok {
+			localUIDs = append(localUIDs, uid)
+		}
+	}
+	presences, err := pm.LocalPresences(localUIDs)
+	if err != nil {
+		return nil, err
+	}
+	for nodeID, nodeUIDs := range remoteUIDMap {
+		var remotePresences []*PresenceResp
+		err = pm.s.clusterManager.requestNode(nodeID, "/user/presence", nodeUIDs, &remotePresences)
+		if err != nil {
+			pm.Error("获取其他节点的用户在线状态失败！", zap.Error(err), zap.Int64("nodeID", nodeID))
+			return nil, err
+		}
+		presences = append(presences, remotePresences...)
+	}
+	return presences, nil
+}
+
+// LocalPresences 获取本节点用户的在线状态
+func (pm *PresenceManager) LocalPresences(uids []string) ([]*PresenceResp, error) {
+	presences := make([]*PresenceResp, 0, len(uids))
+	for _, uid := range uids {
+		lastSeen, err := pm.s.store.GetUserLastSeen(uid)
+		if err != nil {
+			return nil, err
+		}
+		presence := &PresenceResp{
+			UID:         uid,
+			DeviceFlags: make([]uint8, 0),
+			LastSeen:    lastSeen,
+		}
+		deviceFlagMap := map[uint8]struct{}{}
+		for _, conn := range pm.s.connManager.GetConnsWithUID(uid) {
+			deviceFlagMap[conn.DeviceFlag()] = struct{}{}
+		}
+		for deviceFlag := range deviceFlagMap {
+			presence.DeviceFlags = append(presence.DeviceFlags, deviceFlag)
+		}
+		if len(presence.DeviceFlags) > 0 {
+			presence.Online = 1
+			sort.Slice(presence.DeviceFlags, func(i, j int) bool {
+				return presence.DeviceFlags[i] < presence.DeviceFlags[j]
+			})
+		}
+		presences = append(presences, presence)
+	}
+	return presences, nil
+}
+
+// Watch 其他节点登记（续期）订阅本节点的用户
+func (pm *PresenceManager) Watch(nodeID int64, uids []string) {
+	expireAt := time.Now().Add(presenceWatchTTL).Unix()
+	pm.watchLock.Lock()
+	defer pm.watchLock.Unlock()
+	for _, uid := range uids {
+		nodeIDs := pm.watchers[uid]
+		if nodeIDs == nil {
+			nodeIDs = map[int64]int64{}
+			pm.watchers[uid] = nodeIDs
+		}
+		nodeIDs[nodeID] = expireAt
+	}
+}
+
+// NotifyLocal 推送在线状态给本节点的订阅者
+func (pm *PresenceManager) NotifyLocal(presences []*PresenceResp) {
+	connPresences := map[int64][]*PresenceResp{}
+	pm.subLock.RLock()
+	for _, presence := range presences {
+		for connID := range pm.subscribers[presence.UID] {
+			connPresences[connID] = append(connPresences[connID], presence)
+		}
+	}
+	pm.subLock.RUnlock()
+	for connID, presences := range connPresences {
+		conn := pm.s.connManager.GetConn(connID)
+		if conn == nil {
+			continue
+		}
+		pm.push(conn, presences)
+	}
+}
+
+func (pm *PresenceManager) push(conn oknet.Conn, presences []*PresenceResp) {
+	if len(presences) == 0 || conn.ProtoVersion() < okproto.EventMinVersion {
+		return
+	}
+	pm.s.dispatch.dataOut(conn, &okproto.EventPacket{
+		ID:        okutil.GenUUID(),
+		Type:      EventTypePresence,
+		Timestamp: time.Now().UnixNano() / 1e6,
+		Data:      []byte(okutil.ToJSON(presences)),
+	})
+}
+
+// 在用户所在节点登记订阅，返回其他节点的用户
+func (pm *PresenceManager) watchRemote(uids []string) map[int64][]string {
+	_, remoteUIDMap := pm.s.clusterManager.SplitUIDsByNode(uids)
+	for nodeID, nodeUIDs := range remoteUIDMap {
+		err := pm.s.clusterManager.requestNode(nodeID, "/cluster/presence/watch", &clusterPresenceWatchReq{
+			NodeID: pm.s.opts.Cluster.NodeID,
+			UIDs:   nodeUIDs,
+		}, nil)
+		if err != nil {
+			pm.Warn("在其他节点登记在线状态订阅失败！", zap.Error(err), zap.Int64("nodeID", nodeID))
+		}
+	}
+	return remoteUIDMap
+}
+
+func (pm *PresenceManager) loop() {
+	notifyTick := time.NewTicker(pm.s.opts.Presence.NotifyInterval)
+	defer notifyTick.Stop()
+	watchTick := time.NewTicker(presenceWatchTTL / 3)
+	defer watchTick.Stop()
+	for {
+		select {
+		case <-notifyTick.C:
+			pm.flush()
+		case <-watchTick.C:
+			if pm.s.clusterManager.On() {
+				pm.renewWatches()
+			}
+		case <-pm.stopChan:
+			return
+		}
+	}
+}
+
+// 保存最后在线时间并推送发生变化的用户的在线状态
+func (pm *PresenceManager) flush() {
+	pm.changeLock.Lock()
+	if len(pm.changes) == 0 {
+		pm.changeLock.Unlock()
+		return
+	}
+	changes := pm.changes
+	pm.changes = map[string]int64{}
+	pm.changeLock.Unlock()
+
+	uids := make([]string, 0, len(changes))
+	for uid, changedAt := range changes {
+		if err := pm.s.store.UpdateUserLastSeen(uid, changedAt); err != nil {
+			pm.Error("更新用户最后在线时间失败！", zap.Error(err), zap.String("uid", uid))
+		}
+		uids = append(uids, uid)
+	}
+	presences, err := pm.LocalPresences(uids)
+	if err != nil {
+		pm.Error("获取用户在线状态失败！", zap.Error(err))
+		return
+	}
+	pm.NotifyLocal(presences)
+	pm.notifyWatchers(presences)
+}
+
+// 推送在线状态给订阅了这些用户的其他节点
+func (pm *PresenceManager) notifyWatchers(presences []*PresenceResp) {
+	if !pm.s.clusterManager.On() {
+		return
+	}
+	now := time.Now().Unix()
+	nodePresences := map[int64][]*PresenceResp{}
+	pm.watchLock.Lock()
+	for _, presence := range presences {
+		nodeIDs := pm.watchers[presence.UID]
+		for nodeID, expireAt := range nodeIDs {
+			if expireAt < now {
+				delete(nodeIDs, nodeID)
+				continue
+			}
+			nodePresences[nodeID] = append(nodePresences[nodeID], presence)
+		}
+		if len(nodeIDs) == 0 {
+			delete(pm.watchers, presence.UID)
+		}
+	}
+	pm.watchLock.Unlock()
+	for nodeID, presences := range nodePresences {
+		err := pm.s.clusterManager.requestNode(nodeID, "/cluster/presence/notify", presences, nil)
+		if err != nil {
+			pm.Warn("推送在线状态给其他节点失败！", zap.Error(err), zap.Int64("nodeID", nodeID))
+		}
+	}
+}
+
+// 续期本节点在其他节点登记的订阅，并清理过期的登记
+func (pm *PresenceManager) renewWatches() {
+	pm.subLock.RLock()
+	uids := make([]string, 0, len(pm.subscribers))
+	for uid := range pm.subscribers {
+		uids = append(uids, uid)
+	}
+	pm.subLock.RUnlock()
+	pm.watchRemote(uids)
+
+	now := time.Now().Unix()
+	pm.watchLock.Lock()
+	for uid, nodeIDs := range pm.watchers {
+		for nodeID, expireAt := range nodeIDs {
+			if expireAt < now {
+				delete(nodeIDs, nodeID)
+			}
+		}
+		if len(nodeIDs) == 0 {
+			delete(pm.watchers, uid)
+		}
+	}
+	pm.watchLock.Unlock()
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/samlau0508/imserver/pkg/oknet"
+	"github.com/samlau0508/imserver/pkg/okstore"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+type presenceTestConn struct {
+	oknet.Conn
+	id  int64
+	uid string
+}
+
+func (c *presenceTestConn) ID() int64         { return c.id }
+func (c *presenceTestConn) UID() string       { return c.uid }
+func (c *presenceTestConn) ProtoVersion() int { return 0 }
+
+func TestPresenceManagerSubscribe(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	opts.Presence.MaxSubscriptions = 3
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+
+	pm := s.presenceManager
+	c1 := &presenceTestConn{id: 1, uid: "u1"}
+	c2 := &presenceTestConn{id: 2, uid: "u2"}
+
+	assert.Equal(t, okproto.ReasonSuccess, pm.Subscribe(c1, []string{"a", "b", "b"}))
+	assert.Equal(t, okproto.ReasonSuccess, pm.Subscribe(c2, []string{"a"}))
+	// 已订阅的用户不重复计数
+	assert.Equal(t, okproto.ReasonSuccess, pm.Subscribe(c1, []string{"a", "c"}))
+	assert.Equal(t, okproto.ReasonSubscriptionLimit, pm.Subscribe(c1, []string{"d"}))
+	assert.Equal(t, 2, len(pm.subscribers["a"]))
+
+	pm.Unsubscribe(c1, []string{"a"})
+	assert.Equal(t, 1, len(pm.subscribers["a"]))
+	assert.Equal(t, 2, len(pm.connSubs[c1.ID()]))
+
+	pm.RemoveConn(c1.ID())
+	pm.Unsubscribe(c2, nil)
+	assert.Empty(t, pm.subscribers)
+	assert.Empty(t, pm.connSubs)
+}
+
+func TestPresenceManagerLastSeen(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+
+	pm := s.presenceManager
+	pm.Change("u1")
+	pm.flush()
+
+	presences, err := pm.LocalPresences([]string{"u1", "u2"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(presences))
+	assert.Equal(t, uint8(0), presences[0].Online)
+	assert.Empty(t, presences[0].DeviceFlags)
+	assert.NotZero(t, presences[0].LastSeen)
+	assert.Zero(t, presences[1].LastSeen)
+}
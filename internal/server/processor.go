@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/bwmarrin/snowflake"
+	"github.com/samlau0508/imserver/pkg/okauth"
 	"github.com/samlau0508/imserver/pkg/oklog"
 	"github.com/samlau0508/imserver/pkg/oknet"
 	"github.com/samlau0508/imserver/pkg/okstore"
@@ -80,6 +81,11 @@ func (p *Processor) processSameFrame(conn oknet.Conn, frameType okproto.FrameTyp
 		}
 		p.processSubs(conn, tmpFrames)
 		p.framePool.PutSubPackets(tmpFrames)
+
+	case okproto.EVENT: // 事件
+		for _, frame := range frames {
+			p.processEvent(conn, frame.(*okproto.EventPacket))
+		}
 	}
 	// 完成frame处理
 	conn.Context().(*connContext).finishFrames(len(frames))
@@ -93,12 +99,31 @@ func (p *Processor) processAuth(conn oknet.Conn, connectPacket *okproto.ConnectP
 		devceLevel  okproto.DeviceLevel = okproto.DeviceLevelMaster
 		err         error
 		devceLevelI uint8
-		token       string
 	)
 	if strings.TrimSpace(connectPacket.ClientKey) == "" {
 		p.responseConnackAuthFail(conn)
 		return
 	}
+	// -------------------- rate limit --------------------
+	if !p.s.rateLimiter.AllowConnect(conn) {
+		p.Warn("connect is rate limited", zap.String("uid", uid), zap.String("ip", connIP(conn)))
+		p.responseConnack(conn, 0, okproto.ReasonRateLimit)
+		return
+	}
+	// -------------------- node match --------------------
+	if p.s.clusterManager.On() {
+		nodeID, err := p.s.clusterManager.NodeIDOfUser(uid)
+		if err != nil {
+			p.Error("get node of user err", zap.Error(err), zap.String("uid", uid))
+			p.responseConnack(conn, 0, okproto.ReasonNodeMatchError)
+			return
+		}
+		if !p.s.clusterManager.IsLocal(nodeID) { // 用户不属于此节点，客户端需要通过/route获取正确的节点地址
+			p.Warn("user not belong to this node", zap.String("uid", uid), zap.Int64("nodeID", nodeID))
+			p.responseConnack(conn, 0, okproto.ReasonNodeNotMatch)
+			return
+		}
+	}
 	// -------------------- token verify --------------------
 	if connectPacket.UID == p.s.opts.ManagerUID {
 		if p.s.opts.ManagerTokenOn && connectPacket.Token != p.s.opts.ManagerToken {
@@ -113,17 +138,20 @@ func (p *Processor) processAuth(conn oknet.Conn, connectPacket *okproto.ConnectP
 			p.responseConnackAuthFail(conn)
 			return
 		}
-		token, devceLevelI, err = p.s.store.GetUserToken(uid, connectPacket.DeviceFlag.ToUint8())
+		var authResult *okauth.Result
+		authResult, err = p.s.authVerifier.Verify(&okauth.Request{
+			UID:        uid,
+			Token:      connectPacket.Token,
+			DeviceFlag: connectPacket.DeviceFlag.ToUint8(),
+			DeviceID:   connectPacket.DeviceID,
+			ClientIP:   connIP(conn),
+		})
 		if err != nil {
-			p.Error("get user token err", zap.Error(err))
-			p.responseConnackAuthFail(conn)
-			return
-		}
-		if token != connectPacket.Token {
-			p.Error("token verify fail", zap.String("expectToken", token), zap.String("actToken", connectPacket.Token), zap.Any("conn", conn))
+			p.Error("token verify fail", zap.Error(err), zap.String("uid", uid), zap.Any("conn", conn))
 			p.responseConnackAuthFail(conn)
 			return
 		}
+		devceLevelI = authResult.DeviceLevel
 		devceLevel = okproto.DeviceLevel(devceLevelI)
 	} else {
 		devceLevel = okproto.DeviceLevelSlave // 默认都是slave设备
@@ -237,7 +265,12 @@ func (p *Processor) processAuth(conn oknet.Conn, connectPacket *okproto.ConnectP
 	// 在线webhook
 	onlineCount, totalOnlineCount := p.s.connManager.GetConnCountWith(uid, connectPacket.DeviceFlag)
 	p.s.webhook.Online(uid, connectPacket.DeviceFlag, conn.ID(), onlineCount, totalOnlineCount)
+	if onlineCount == 1 { // 此设备类型上线
+		p.s.presenceManager.Change(uid)
+	}
 
+	// 继续重试服务重启前投递给此设备还没有收到ack的消息
+	p.s.retryQueue.resumeInFlightMessages(uid, connectPacket.DeviceID)
 }
 
 // #################### ping ####################
@@ -257,6 +290,10 @@ func (p *Processor) processMsgs(conn oknet.Conn, sendPackets []*okproto.SendPack
 
 	// ########## split sendPacket by channel ##########
 	for _, sendPacket := range sendPackets {
+		if !p.s.rateLimiter.AllowSend(conn) { // 被限流的消息直接回执，不再处理
+			sendackPackets = append(sendackPackets, p.getSendackPacketWithSendPacket(sendPacket, okproto.ReasonRateLimit))
+			continue
+		}
 		channelKey := fmt.Sprintf("%s-%d", sendPacket.ChannelID, sendPacket.ChannelType)
 		channelSendpackets := channelSendPacketMap[channelKey]
 		if channelSendpackets == nil {
@@ -282,43 +319,27 @@ func (p *Processor) processMsgs(conn oknet.Conn, sendPackets []*okproto.SendPack
 
 func (p *Processor) prcocessChannelMessages(conn oknet.Conn, channelID string, channelType uint8, sendPackets []*okproto.SendPacket) ([]okproto.Frame, error) {
 	var (
-		sendackPackets        = make([]okproto.Frame, 0, len(sendPackets)) // response sendack packets
-		messages              = make([]*Message, 0, len(sendPackets))      // recv packets
-		err                   error
-		respSendackPacketsFnc = func(sendPackets []*okproto.SendPacket, reasonCode okproto.ReasonCode) []okproto.Frame {
-			for _, sendPacket := range sendPackets {
-				sendackPackets = append(sendackPackets, p.getSendackPacketWithSendPacket(sendPacket, reasonCode))
-			}
-			return sendackPackets
-		}
+		sendackPackets                = make([]okproto.Frame, 0, len(sendPackets)) // response sendack packets
+		messages                      = make([]*Message, 0, len(sendPackets))      // recv packets
+		err                           error
 		respSendackPacketsWithRecvFnc = func(messages []*Message, reasonCode okproto.ReasonCode) []okproto.Frame {
 			for _, m := range messages {
+				if reasonCode == okproto.ReasonSuccess && m.reasonCode != okproto.ReasonUnknown {
+					sendackPackets = append(sendackPackets, p.getSendackPacket(m, m.reasonCode))
+					continue
+				}
 				sendackPackets = append(sendackPackets, p.getSendackPacket(m, reasonCode))
 			}
 			return sendackPackets
 		}
 	)
 
-	//########## get channel and assert permission ##########
 	fakeChannelID := channelID
 	if channelType == okproto.ChannelTypePerson {
 		fakeChannelID = GetFakeChannelIDWith(conn.UID(), channelID)
 	}
-	channel, err := p.s.channelManager.GetChannel(fakeChannelID, channelType)
-	if err != nil {
-		p.Error("getChannel is error", zap.Error(err), zap.String("fakeChannelID", fakeChannelID), zap.Uint8("channelType", channelType))
-		return respSendackPacketsFnc(sendPackets, okproto.ReasonSystemError), nil
-	}
-	if channel == nil {
-		p.Error("the channel does not exist or has been disbanded", zap.String("channel_id", fakeChannelID), zap.Uint8("channel_type", channelType))
-		return respSendackPacketsFnc(sendPackets, okproto.ReasonChannelNotExist), nil
-	}
-	hasPerm, reasonCode := p.hasPermission(channel, conn.UID())
-	if !hasPerm {
-		return respSendackPacketsFnc(sendPackets, reasonCode), nil
-	}
 
-	// ########## message decrypt and message store ##########
+	// ########## message decrypt ##########
 	for _, sendPacket := range sendPackets {
 		var messageID = p.genMessageID() // generate messageID
 
@@ -369,41 +390,112 @@ func (p *Processor) prcocessChannelMessages(conn oknet.Conn, channelID string, c
 			},
 			fromDeviceFlag: okproto.DeviceFlag(conn.DeviceFlag()),
 			fromDeviceID:   conn.DeviceID(),
-			large:          channel.Large,
 		})
 	}
 	if len(messages) == 0 {
 		return sendackPackets, nil
 	}
-	err = p.storeChannelMessagesIfNeed(conn.UID(), messages) // only have messageSeq after message save
+
+	//########## message put to the node of channel ##########
+	nodeID, err := p.s.clusterManager.NodeIDOfChannel(fakeChannelID, channelType)
+	if err != nil {
+		p.Error("get node of channel err", zap.Error(err), zap.String("fakeChannelID", fakeChannelID), zap.Uint8("channelType", channelType))
+		return respSendackPacketsWithRecvFnc(messages, okproto.ReasonNodeMatchError), err
+	}
+	var reasonCode okproto.ReasonCode
+	if p.s.clusterManager.IsLocal(nodeID) {
+		reasonCode, err = p.putChannelMessages(fakeChannelID, channelType, conn.UID(), okproto.DeviceFlag(conn.DeviceFlag()), conn.DeviceID(), messages)
+	} else {
+		reasonCode, err = p.forwardChannelMessages(nodeID, fakeChannelID, channelType, conn, messages)
+	}
+	if reasonCode != okproto.ReasonSuccess {
+		return respSendackPacketsWithRecvFnc(messages, reasonCode), err
+	}
+
+	//########## respose ##########
+	return respSendackPacketsWithRecvFnc(messages, okproto.ReasonSuccess), nil
+}
+
+// 频道在本节点，校验权限后存储消息并放入频道
+func (p *Processor) putChannelMessages(fakeChannelID string, channelType uint8, fromUID string, fromDeviceFlag okproto.DeviceFlag, fromDeviceID string, messages []*Message) (okproto.ReasonCode, error) {
+	//########## get channel and assert permission ##########
+	channel, err := p.s.channelManager.GetChannel(fakeChannelID, channelType)
+	if err != nil {
+		p.Error("getChannel is error", zap.Error(err), zap.String("fakeChannelID", fakeChannelID), zap.Uint8("channelType", channelType))
+		return okproto.ReasonSystemError, nil
+	}
+	if channel == nil {
+		p.Error("the channel does not exist or has been disbanded", zap.String("channel_id", fakeChannelID), zap.Uint8("channel_type", channelType))
+		return okproto.ReasonChannelNotExist, nil
+	}
+	hasPerm, reasonCode := p.hasPermission(channel, fromUID)
+	if !hasPerm {
+		return reasonCode, nil
+	}
+	// 慢速模式按每条消息校验（同一批次的多条消息不能一起通过），被拒绝的消息不存储也不投递
+	allowMessages := make([]*Message, 0, len(messages))
+	for _, m := range messages {
+		m.large = channel.Large
+		if allow, reasonCode := channel.CheckSlowMode(fromUID); !allow {
+			m.reasonCode = reasonCode
+			continue
+		}
+		allowMessages = append(allowMessages, m)
+	}
+	if len(allowMessages) == 0 {
+		return okproto.ReasonSlowMode, nil
+	}
+	messages = allowMessages
+
+	// ########## message store ##########
+	err = p.storeChannelMessagesIfNeed(fromUID, messages) // only have messageSeq after message save
 	if err != nil {
 		p.Error("store channel messages err", zap.Error(err))
-		return respSendackPacketsWithRecvFnc(messages, okproto.ReasonSystemError), err
+		return okproto.ReasonSystemError, err
 	}
 	//########## message store to queue ##########
 	if p.s.opts.WebhookOn() {
 		err = p.storeChannelMessagesToNotifyQueue(messages)
 		if err != nil {
 			p.Error("store channel messages to notify queue err", zap.Error(err))
-			return respSendackPacketsWithRecvFnc(messages, okproto.ReasonSystemError), err
+			return okproto.ReasonSystemError, err
 		}
 	}
 
 	//########## message put to channel ##########
-	err = channel.Put(messages, nil, conn.UID(), okproto.DeviceFlag(conn.DeviceFlag()), conn.DeviceID())
+	err = channel.Put(messages, nil, fromUID, fromDeviceFlag, fromDeviceID)
 	if err != nil {
 		p.Error("put message to channel err", zap.Error(err))
-		return respSendackPacketsWithRecvFnc(messages, okproto.ReasonSystemError), err
+		return okproto.ReasonSystemError, err
 	}
+	return okproto.ReasonSuccess, nil
+}
 
-	//########## respose ##########
-	if len(messages) > 0 {
-		for _, message := range messages {
-			sendackPackets = append(sendackPackets, p.getSendackPacket(message, okproto.ReasonSuccess))
+// 频道在其他节点，将消息转发给频道所在节点处理
+func (p *Processor) forwardChannelMessages(nodeID int64, fakeChannelID string, channelType uint8, conn oknet.Conn, messages []*Message) (okproto.ReasonCode, error) {
+	resp, err := p.s.clusterManager.PutMessages(nodeID, &clusterMessagePutReq{
+		ChannelID:      fakeChannelID,
+		ChannelType:    channelType,
+		FromUID:        conn.UID(),
+		FromDeviceFlag: conn.DeviceFlag(),
+		FromDeviceID:   conn.DeviceID(),
+		Messages:       encodeClusterMessages(messages),
+	})
+	if err != nil {
+		p.Error("forward messages to the node of channel err", zap.Error(err), zap.Int64("nodeID", nodeID), zap.String("fakeChannelID", fakeChannelID))
+		return okproto.ReasonForwardSendPacketError, err
+	}
+	for i, messageSeq := range resp.MessageSeqs {
+		if i < len(messages) {
+			messages[i].MessageSeq = messageSeq
 		}
-
 	}
-	return sendackPackets, nil
+	for i, reasonCode := range resp.ReasonCodes {
+		if i < len(messages) {
+			messages[i].reasonCode = reasonCode
+		}
+	}
+	return resp.ReasonCode, nil
 }
 
 // if has permission for sender
@@ -483,6 +575,8 @@ func (p *Processor) storeChannelMessagesIfNeed(fromUID string, messages []*Messa
 		p.Error("store message err", zap.Error(err))
 		return err
 	}
+	p.s.searchManager.IndexMessages(fakeChannelID, firstMessage.ChannelType, storeMessages)
+	p.s.threadManager.AddReplies(fakeChannelID, firstMessage.ChannelType, storeMessages)
 	return nil
 }
 
@@ -579,19 +673,8 @@ func (p *Processor) processSub(conn oknet.Conn, subPacket *okproto.SubPacket) {
 		return
 	}
 
-	channel, err := p.s.channelManager.GetChannel(channelID, subPacket.ChannelType)
-	if err != nil {
-		p.Warn("获取频道失败！", zap.Error(err))
-		p.response(conn, p.getSuback(subPacket, channelID, okproto.ReasonSystemError))
-		return
-	}
-	if channel == nil {
-		p.Warn("频道不存在！", zap.String("channelID", channelID), zap.Uint8("channelType", subPacket.ChannelType))
-		p.response(conn, p.getSuback(subPacket, channelID, okproto.ReasonChannelNotExist))
-		return
-	}
-
 	connCtx := conn.Context().(*connContext)
+	var reasonCode okproto.ReasonCode
 	if subPacket.Action == okproto.Subscribe {
 		if strings.TrimSpace(subPacket.Param) != "" {
 			paramM, _ := okutil.JSONToMap(subPacket.Param)
@@ -601,19 +684,65 @@ func (p *Processor) processSub(conn oknet.Conn, subPacket *okproto.SubPacket) {
 				}
 			}
 		}
-		channel.AddSubscriber(conn.UID())
-		connCtx.subscribeChannel(channelID, subPacket.ChannelType, paramMap)
+		reasonCode = p.updateDataChannelSubscriber(conn.UID(), channelID, subPacket.ChannelType, subPacket.Action, false)
+		if reasonCode == okproto.ReasonSuccess {
+			connCtx.subscribeChannel(channelID, subPacket.ChannelType, paramMap)
+		}
 	} else {
+		reasonCode = p.updateDataChannelSubscriber(conn.UID(), channelID, subPacket.ChannelType, subPacket.Action, !p.s.connManager.ExistConnsWithUID(conn.UID()))
+		connCtx.unscribeChannel(channelID, subPacket.ChannelType)
+	}
+	p.response(conn, p.getSuback(subPacket, channelID, reasonCode))
+}
 
-		if !p.s.connManager.ExistConnsWithUID(conn.UID()) {
-			channel.RemoveSubscriber(conn.UID())
+// 更新数据频道的订阅者，订阅者登记在频道所在节点（发布到频道的消息由频道所在节点投递给订阅者所在节点），频道不在本节点则交给频道所在节点处理
+func (p *Processor) updateDataChannelSubscriber(uid string, channelID string, channelType uint8, action okproto.Action, removeSubscriber bool) okproto.ReasonCode {
+	if p.s.clusterManager.On() && !isMonitorChannel(channelID) { // 监控频道只投递本节点的监控数据给本节点的订阅者
+		nodeID, err := p.s.clusterManager.NodeIDOfChannel(channelID, channelType)
+		if err != nil {
+			p.Error("获取频道所在节点失败！", zap.Error(err), zap.String("channelID", channelID), zap.Uint8("channelType", channelType))
+			return okproto.ReasonNodeMatchError
 		}
-		if subPacket.ChannelType == okproto.ChannelTypeData && len(channel.GetAllSubscribers()) == 0 {
-			p.s.channelManager.RemoveDataChannel(channelID, subPacket.ChannelType)
+		if !p.s.clusterManager.IsLocal(nodeID) {
+			resp, err := p.s.clusterManager.SubscribeChannel(nodeID, &clusterChannelSubscribeReq{
+				UID:              uid,
+				ChannelID:        channelID,
+				ChannelType:      channelType,
+				Action:           action.Uint8(),
+				RemoveSubscriber: removeSubscriber,
+			})
+			if err != nil {
+				p.Error("转发订阅到频道所在节点失败！", zap.Error(err), zap.Int64("nodeID", nodeID), zap.String("uid", uid), zap.String("channelID", channelID))
+				return okproto.ReasonSystemError
+			}
+			return resp.ReasonCode
 		}
-		connCtx.unscribeChannel(channelID, subPacket.ChannelType)
 	}
-	p.response(conn, p.getSuback(subPacket, channelID, okproto.ReasonSuccess))
+	return p.updateLocalDataChannelSubscriber(uid, channelID, channelType, action, removeSubscriber)
+}
+
+// 更新本节点数据频道的订阅者（removeSubscriber为false时取消订阅不移除订阅者，用户还有其他连接）
+func (p *Processor) updateLocalDataChannelSubscriber(uid string, channelID string, channelType uint8, action okproto.Action, removeSubscriber bool) okproto.ReasonCode {
+	channel, err := p.s.channelManager.GetChannel(channelID, channelType)
+	if err != nil {
+		p.Warn("获取频道失败！", zap.Error(err))
+		return okproto.ReasonSystemError
+	}
+	if channel == nil {
+		p.Warn("频道不存在！", zap.String("channelID", channelID), zap.Uint8("channelType", channelType))
+		return okproto.ReasonChannelNotExist
+	}
+	if action == okproto.Subscribe {
+		channel.AddSubscriber(uid)
+		return okproto.ReasonSuccess
+	}
+	if removeSubscriber {
+		channel.RemoveSubscriber(uid)
+	}
+	if channelType == okproto.ChannelTypeData && len(channel.GetAllSubscribers()) == 0 {
+		p.s.channelManager.RemoveDataChannel(channelID, channelType)
+	}
+	return okproto.ReasonSuccess
 }
 
 func (p *Processor) getSuback(subPacket *okproto.SubPacket, channelID string, reasonCode okproto.ReasonCode) *okproto.SubackPacket {
@@ -626,6 +755,304 @@ func (p *Processor) getSuback(subPacket *okproto.SubPacket, channelID string, re
 	}
 }
 
+// #################### event ####################
+func (p *Processor) processEvent(conn oknet.Conn, eventPacket *okproto.EventPacket) {
+	var reasonCode okproto.ReasonCode
+	switch eventPacket.Type {
+	case EventTypeMessageRevoke: // 撤回消息
+		reasonCode = p.processMessageRevokeEvent(conn, eventPacket)
+	case EventTypeMessageEdit: // 编辑消息
+		reasonCode = p.processMessageEditEvent(conn, eventPacket)
+	case EventTypeMessageRead: // 消息已读
+		reasonCode = p.processMessageReadEvent(conn, eventPacket)
+	case EventTypeMessageDelete: // 删除消息
+		reasonCode = p.processMessageDeleteEvent(conn, eventPacket)
+	case EventTypeChannelSignal: // 频道信号（正在输入等）
+		reasonCode = p.processChannelSignalEvent(conn, eventPacket)
+	case EventTypeReactionAdd: // 添加消息回应
+		reasonCode = p.processReactionEvent(conn, eventPacket, false)
+	case EventTypeReactionRemove: // 移除消息回应
+		reasonCode = p.processReactionEvent(conn, eventPacket, true)
+	case EventTypePresenceSubscribe: // 订阅在线状态
+		reasonCode = p.processPresenceSubscribeEvent(conn, eventPacket)
+	case EventTypePresenceUnsubscribe: // 取消订阅在线状态
+		reasonCode = p.processPresenceUnsubscribeEvent(conn, eventPacket)
+	default:
+		p.Warn("不支持的事件类型！", zap.String("uid", conn.UID()), zap.String("type", eventPacket.Type))
+		reasonCode = okproto.ReasonNotSupportEvent
+	}
+	p.response(conn, &okproto.EventackPacket{
+		ID:         eventPacket.ID,
+		Type:       eventPacket.Type,
+		ReasonCode: reasonCode,
+	})
+}
+
+func (p *Processor) processMessageRevokeEvent(conn oknet.Conn, eventPacket *okproto.EventPacket) okproto.ReasonCode {
+	var req MessageRevokeReq
+	if err := okutil.ReadJSONByByte(eventPacket.Data, &req); err != nil {
+		p.Warn("解析撤回事件数据失败！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	req.UID = conn.UID() // 客户端只能以自己的身份撤回
+	if err := req.Check(); err != nil {
+		p.Warn("撤回事件数据不合法！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	if reasonCode, forwarded := p.forwardEventIfNeed(eventPacket.Type, req.UID, req.ChannelID, req.ChannelType, req); forwarded {
+		return reasonCode
+	}
+	reasonCode, err := p.s.messageManager.Revoke(req, true)
+	if err != nil {
+		p.Error("撤回消息失败！", zap.Error(err), zap.String("uid", conn.UID()))
+	}
+	return reasonCode
+}
+
+func (p *Processor) processMessageEditEvent(conn oknet.Conn, eventPacket *okproto.EventPacket) okproto.ReasonCode {
+	var req MessageEditReq
+	if err := okutil.ReadJSONByByte(eventPacket.Data, &req); err != nil {
+		p.Warn("解析编辑事件数据失败！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	req.UID = conn.UID()      // 客户端只能以自己的身份编辑
+	if len(req.Payload) > 0 { // 客户端发送的内容是加密的，与SEND包一致
+		payload, err := decryptMessagePayload(req.Payload, conn)
+		if err != nil {
+			p.Warn("解密编辑内容失败！", zap.Error(err), zap.String("uid", conn.UID()))
+			return okproto.ReasonPayloadDecodeError
+		}
+		req.Payload = payload
+	}
+	if err := req.Check(); err != nil {
+		p.Warn("编辑事件数据不合法！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	if reasonCode, forwarded := p.forwardEventIfNeed(eventPacket.Type, req.UID, req.ChannelID, req.ChannelType, req); forwarded {
+		return reasonCode
+	}
+	reasonCode, err := p.s.messageManager.Edit(req, true)
+	if err != nil {
+		p.Error("编辑消息失败！", zap.Error(err), zap.String("uid", conn.UID()))
+	}
+	return reasonCode
+}
+
+func (p *Processor) processMessageDeleteEvent(conn oknet.Conn, eventPacket *okproto.EventPacket) okproto.ReasonCode {
+	var req MessageDeleteReq
+	if err := okutil.ReadJSONByByte(eventPacket.Data, &req); err != nil {
+		p.Warn("解析删除事件数据失败！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	req.UID = conn.UID() // 客户端只能以自己的身份对自己删除
+	req.Everyone = 0
+	if err := req.Check(); err != nil {
+		p.Warn("删除事件数据不合法！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	if reasonCode, forwarded := p.forwardEventIfNeed(eventPacket.Type, req.UID, req.ChannelID, req.ChannelType, req); forwarded {
+		return reasonCode
+	}
+	reasonCode, err := p.s.messageManager.Delete(req, true)
+	if err != nil {
+		p.Error("删除消息失败！", zap.Error(err), zap.String("uid", conn.UID()))
+	}
+	return reasonCode
+}
+
+func (p *Processor) processMessageReadEvent(conn oknet.Conn, eventPacket *okproto.EventPacket) okproto.ReasonCode {
+	var req MessageReadReq
+	if err := okutil.ReadJSONByByte(eventPacket.Data, &req); err != nil {
+		p.Warn("解析已读事件数据失败！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	req.UID = conn.UID()
+	if err := req.Check(); err != nil {
+		p.Warn("已读事件数据不合法！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	reasonCode, forwarded := p.forwardEventIfNeed(eventPacket.Type, req.UID, req.ChannelID, req.ChannelType, req)
+	if !forwarded {
+		var err error
+		reasonCode, err = p.s.messageManager.Read(req)
+		if err != nil {
+			p.Error("处理消息已读失败！", zap.Error(err), zap.String("uid", conn.UID()))
+		}
+	}
+	if reasonCode == okproto.ReasonSuccess { // 最近会话在用户所在节点，更新未读数量并通知用户的其他设备
+		p.s.conversationManager.ReadConversation(req.UID, req.ChannelID, req.ChannelType, req.MessageSeq, conn.ID())
+	}
+	return reasonCode
+}
+
+func (p *Processor) processReactionEvent(conn oknet.Conn, eventPacket *okproto.EventPacket, remove bool) okproto.ReasonCode {
+	var req ReactionReq
+	if err := okutil.ReadJSONByByte(eventPacket.Data, &req); err != nil {
+		p.Warn("解析回应事件数据失败！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	req.UID = conn.UID() // 客户端只能以自己的身份回应
+	if err := req.Check(); err != nil {
+		p.Warn("回应事件数据不合法！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	if reasonCode, forwarded := p.forwardEventIfNeed(eventPacket.Type, req.UID, req.ChannelID, req.ChannelType, req); forwarded {
+		return reasonCode
+	}
+	reasonCode, err := p.s.messageManager.React(req, remove)
+	if err != nil {
+		p.Error("处理消息回应失败！", zap.Error(err), zap.String("uid", conn.UID()))
+	}
+	return reasonCode
+}
+
+// 频道不在本节点时将客户端发起的事件交给频道所在节点处理，返回true表示已转发
+func (p *Processor) forwardEventIfNeed(eventType string, uid string, channelID string, channelType uint8, req interface{}) (okproto.ReasonCode, bool) {
+	if !p.s.clusterManager.On() {
+		return okproto.ReasonSuccess, false
+	}
+	fakeChannelID := channelID
+	if channelType == okproto.ChannelTypePerson {
+		fakeChannelID = GetFakeChannelIDWith(uid, channelID)
+	}
+	nodeID, err := p.s.clusterManager.NodeIDOfChannel(fakeChannelID, channelType)
+	if err != nil {
+		p.Error("获取频道所在节点失败！", zap.Error(err), zap.String("fakeChannelID", fakeChannelID), zap.Uint8("channelType", channelType))
+		return okproto.ReasonNodeMatchError, true
+	}
+	if p.s.clusterManager.IsLocal(nodeID) {
+		return okproto.ReasonSuccess, false
+	}
+	resp, err := p.s.clusterManager.ProcessEvent(nodeID, &clusterEventReq{
+		UID:  uid,
+		Type: eventType,
+		Data: []byte(okutil.ToJSON(req)),
+	})
+	if err != nil {
+		p.Error("转发事件到频道所在节点失败！", zap.Error(err), zap.Int64("nodeID", nodeID), zap.String("type", eventType), zap.String("uid", uid))
+		return okproto.ReasonSystemError, true
+	}
+	return resp.ReasonCode, true
+}
+
+// 处理其他节点转发过来的客户端事件（和客户端直接发起的一样按客户端的权限处理）
+func (p *Processor) processClusterEvent(req *clusterEventReq) okproto.ReasonCode {
+	var (
+		reasonCode okproto.ReasonCode
+		err        error
+	)
+	switch req.Type {
+	case EventTypeMessageRevoke:
+		var revokeReq MessageRevokeReq
+		if err = okutil.ReadJSONByByte(req.Data, &revokeReq); err == nil {
+			revokeReq.UID = req.UID
+			reasonCode, err = p.s.messageManager.Revoke(revokeReq, true)
+		}
+	case EventTypeMessageEdit:
+		var editReq MessageEditReq
+		if err = okutil.ReadJSONByByte(req.Data, &editReq); err == nil {
+			editReq.UID = req.UID
+			reasonCode, err = p.s.messageManager.Edit(editReq, true)
+		}
+	case EventTypeMessageDelete:
+		var deleteReq MessageDeleteReq
+		if err = okutil.ReadJSONByByte(req.Data, &deleteReq); err == nil {
+			deleteReq.UID = req.UID
+			reasonCode, err = p.s.messageManager.Delete(deleteReq, true)
+		}
+	case EventTypeMessageRead:
+		var readReq MessageReadReq
+		if err = okutil.ReadJSONByByte(req.Data, &readReq); err == nil {
+			readReq.UID = req.UID
+			reasonCode, err = p.s.messageManager.Read(readReq)
+		}
+	case EventTypeReactionAdd, EventTypeReactionRemove:
+		var reactionReq ReactionReq
+		if err = okutil.ReadJSONByByte(req.Data, &reactionReq); err == nil {
+			reactionReq.UID = req.UID
+			reasonCode, err = p.s.messageManager.React(reactionReq, req.Type == EventTypeReactionRemove)
+		}
+	default:
+		p.Warn("不支持转发的事件类型！", zap.String("uid", req.UID), zap.String("type", req.Type))
+		return okproto.ReasonNotSupportEvent
+	}
+	if err != nil {
+		p.Error("处理转发的事件失败！", zap.Error(err), zap.String("uid", req.UID), zap.String("type", req.Type))
+		if reasonCode == okproto.ReasonUnknown { // 解析事件数据失败
+			return okproto.ReasonEventDataError
+		}
+	}
+	return reasonCode
+}
+
+// 频道信号只投递给频道在线的订阅者，不生成消息ID、不存储、不更新最近会话也不触发webhook
+func (p *Processor) processChannelSignalEvent(conn oknet.Conn, eventPacket *okproto.EventPacket) okproto.ReasonCode {
+	if !p.s.rateLimiter.AllowSignal(conn) {
+		return okproto.ReasonRateLimit
+	}
+	var req channelSignalReq
+	if err := okutil.ReadJSONByByte(eventPacket.Data, &req); err != nil {
+		p.Warn("解析频道信号数据失败！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	if err := req.Check(); err != nil {
+		p.Warn("频道信号数据不合法！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == okproto.ChannelTypePerson {
+		fakeChannelID = GetFakeChannelIDWith(conn.UID(), req.ChannelID)
+	}
+	channel, err := p.s.channelManager.GetChannel(fakeChannelID, req.ChannelType)
+	if err != nil {
+		p.Error("获取频道失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return okproto.ReasonSystemError
+	}
+	if channel == nil {
+		return okproto.ReasonChannelNotExist
+	}
+	hasPerm, reasonCode := p.hasPermission(channel, conn.UID())
+	if !hasPerm {
+		return reasonCode
+	}
+	subscribers, err := channel.RealSubscribers(nil)
+	if err != nil {
+		p.Error("获取频道订阅者失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", req.ChannelType))
+		return okproto.ReasonSystemError
+	}
+	p.s.deliveryManager.startDeliverySignal(subscribers, conn.UID(), fakeChannelID, req.ChannelType, req.Signal, req.Data)
+	return okproto.ReasonSuccess
+}
+
+func (p *Processor) processPresenceSubscribeEvent(conn oknet.Conn, eventPacket *okproto.EventPacket) okproto.ReasonCode {
+	var req presenceSubscribeReq
+	if err := okutil.ReadJSONByByte(eventPacket.Data, &req); err != nil {
+		p.Warn("解析在线状态订阅数据失败！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	if err := req.Check(); err != nil || len(req.UIDs) == 0 {
+		p.Warn("在线状态订阅数据不合法！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	return p.s.presenceManager.Subscribe(conn, req.UIDs)
+}
+
+func (p *Processor) processPresenceUnsubscribeEvent(conn oknet.Conn, eventPacket *okproto.EventPacket) okproto.ReasonCode {
+	var req presenceSubscribeReq
+	if len(eventPacket.Data) > 0 {
+		if err := okutil.ReadJSONByByte(eventPacket.Data, &req); err != nil {
+			p.Warn("解析取消在线状态订阅数据失败！", zap.Error(err), zap.String("uid", conn.UID()))
+			return okproto.ReasonEventDataError
+		}
+	}
+	if err := req.Check(); err != nil {
+		p.Warn("取消在线状态订阅数据不合法！", zap.Error(err), zap.String("uid", conn.UID()))
+		return okproto.ReasonEventDataError
+	}
+	p.s.presenceManager.Unsubscribe(conn, req.UIDs)
+	return okproto.ReasonSuccess
+}
+
 // #################### recv ack ####################
 func (p *Processor) processRecvacks(conn oknet.Conn, acks []*okproto.RecvackPacket) {
 	if len(acks) == 0 {
@@ -663,6 +1090,10 @@ func (p *Processor) processClose(conn oknet.Conn) {
 
 		onlineCount, totalOnlineCount := p.s.connManager.GetConnCountWith(conn.UID(), okproto.DeviceFlag(conn.DeviceFlag())) // 指定的uid和设备下没有新的客户端才算真真的下线（TODO: 有时候离线要比在线晚触发导致不正确）
 		p.s.webhook.Offline(conn.UID(), okproto.DeviceFlag(conn.DeviceFlag()), conn.ID(), onlineCount, totalOnlineCount)     // 触发离线webhook
+		p.s.presenceManager.RemoveConn(conn.ID())
+		if onlineCount == 0 { // 此设备类型下线
+			p.s.presenceManager.Change(conn.UID())
+		}
 	}
 }
 
@@ -1,9 +1,15 @@
 package server
 
 import (
+	"encoding/base64"
+	"net"
 	"testing"
+	"time"
 
+	"github.com/samlau0508/imserver/pkg/okstore"
 	"github.com/samlau0508/imserver/pkg/okutil"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,3 +24,254 @@ func TestSameFrames(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "bcb93b08aad683e26d3f4c7e7a0c24b0", string(okutil.MD5(string(actMsgKey))))
 }
+
+// 使用IM协议的测试连接（支持EVENT包的最新协议版本）
+type protoTestConn struct {
+	net.Conn
+	t       *testing.T
+	skipped []okproto.Frame // 读取指定包时跳过的包，之后的读取会先从这里匹配
+}
+
+func protoTestConnect(t *testing.T, addr string, uid string) *protoTestConn {
+	return protoTestConnectWithDevice(t, addr, uid, okproto.APP)
+}
+
+// 使用指定设备连接（同一用户相同设备类型的连接会互踢）
+func protoTestConnectWithDevice(t *testing.T, addr string, uid string, deviceFlag okproto.DeviceFlag) *protoTestConn {
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	c := &protoTestConn{Conn: conn, t: t}
+	_, clientPubKey := okutil.GetCurve25519KeypPair()
+	c.write(&okproto.ConnectPacket{
+		Version:         okproto.LatestVersion,
+		DeviceID:        okutil.GenUUID(),
+		DeviceFlag:      deviceFlag,
+		ClientKey:       base64.StdEncoding.EncodeToString(clientPubKey[:]),
+		ClientTimestamp: time.Now().Unix(),
+		UID:             uid,
+	})
+	connack, ok := c.read(func(frame okproto.Frame) bool {
+		return frame.GetFrameType() == okproto.CONNACK
+	}).(*okproto.ConnackPacket)
+	assert.True(t, ok)
+	assert.Equal(t, okproto.ReasonSuccess, connack.ReasonCode)
+	return c
+}
+
+func (c *protoTestConn) write(frame okproto.Frame) {
+	data, err := okproto.New().EncodeFrame(frame, okproto.LatestVersion)
+	assert.NoError(c.t, err)
+	_, err = c.Write(data)
+	assert.NoError(c.t, err)
+}
+
+// 读取第一个匹配的包，超时返回nil
+func (c *protoTestConn) read(match func(frame okproto.Frame) bool) okproto.Frame {
+	for i, frame := range c.skipped {
+		if match(frame) {
+			c.skipped = append(c.skipped[:i], c.skipped[i+1:]...)
+			return frame
+		}
+	}
+	for {
+		_ = c.SetReadDeadline(time.Now().Add(time.Second * 5))
+		frame, err := okproto.New().DecodePacketWithConn(c.Conn, okproto.LatestVersion)
+		if err != nil {
+			assert.NoError(c.t, err)
+			return nil
+		}
+		if match(frame) {
+			return frame
+		}
+		c.skipped = append(c.skipped, frame)
+	}
+}
+
+// 读取指定类型的事件
+func (c *protoTestConn) readEvent(eventType string) *okproto.EventPacket {
+	frame := c.read(func(frame okproto.Frame) bool {
+		event, ok := frame.(*okproto.EventPacket)
+		return ok && event.Type == eventType
+	})
+	if frame == nil {
+		return nil
+	}
+	return frame.(*okproto.EventPacket)
+}
+
+// 发送事件并返回事件回执的原因码
+func (c *protoTestConn) sendEvent(eventType string, data interface{}) okproto.ReasonCode {
+	eventID := okutil.GenUUID()
+	c.write(&okproto.EventPacket{
+		ID:        eventID,
+		Type:      eventType,
+		Timestamp: time.Now().UnixNano() / 1e6,
+		Data:      []byte(okutil.ToJSON(data)),
+	})
+	frame := c.read(func(frame okproto.Frame) bool {
+		eventack, ok := frame.(*okproto.EventackPacket)
+		return ok && eventack.ID == eventID
+	})
+	if frame == nil {
+		return okproto.ReasonUnknown
+	}
+	return frame.(*okproto.EventackPacket).ReasonCode
+}
+
+func TestProcessChannelSignalEvent(t *testing.T) {
+	s := protoTestStart(t, func(opts *Options) {
+		opts.RateLimit.On = true
+		opts.RateLimit.SignalPerUID = okstore.RateLimit{Rate: 0.001, Burst: 2}
+	})
+
+	addr := s.dispatch.engine.TCPRealListenAddr().String()
+	conn1 := protoTestConnect(t, addr, "uid1")
+	defer conn1.Close()
+	conn2 := protoTestConnect(t, addr, "uid2")
+	defer conn2.Close()
+
+	// 信号只投递给频道的其他订阅者
+	reasonCode := conn1.sendEvent(EventTypeChannelSignal, &channelSignalReq{ChannelID: "uid2", ChannelType: okproto.ChannelTypePerson, Signal: "typing"})
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+	event := conn2.readEvent(EventTypeChannelSignal)
+	if assert.NotNil(t, event) {
+		var signalEvent channelSignalEvent
+		assert.NoError(t, okutil.ReadJSONByByte(event.Data, &signalEvent))
+		assert.Equal(t, "uid1", signalEvent.ChannelID)
+		assert.Equal(t, "uid1", signalEvent.FromUID)
+		assert.Equal(t, "typing", signalEvent.Signal)
+	}
+
+	reasonCode = conn1.sendEvent(EventTypeChannelSignal, &channelSignalReq{ChannelID: "uid2", ChannelType: okproto.ChannelTypePerson})
+	assert.Equal(t, okproto.ReasonEventDataError, reasonCode)
+
+	// 超过限流的信号直接拒绝，不影响其他用户
+	reasonCode = conn1.sendEvent(EventTypeChannelSignal, &channelSignalReq{ChannelID: "uid2", ChannelType: okproto.ChannelTypePerson, Signal: "typing"})
+	assert.Equal(t, okproto.ReasonRateLimit, reasonCode)
+	reasonCode = conn2.sendEvent(EventTypeChannelSignal, &channelSignalReq{ChannelID: "uid1", ChannelType: okproto.ChannelTypePerson, Signal: "typing"})
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+}
+
+// 消息已读同时更新最近会话的未读数量，只通知用户的其他连接，上报已读的连接不会收到会话更新
+func TestProcessMessageReadEventConversation(t *testing.T) {
+	s := protoTestStart(t, func(opts *Options) {
+		opts.Conversation.On = true
+	})
+	s.conversationManager.AddOrUpdateConversation("uid1", &okstore.Conversation{
+		UID:         "uid1",
+		ChannelID:   "uid2",
+		ChannelType: okproto.ChannelTypePerson,
+		UnreadCount: 3,
+		LastMsgSeq:  5,
+	})
+
+	addr := s.dispatch.engine.TCPRealListenAddr().String()
+	conn1 := protoTestConnectWithDevice(t, addr, "uid1", okproto.APP)
+	defer conn1.Close()
+	conn2 := protoTestConnectWithDevice(t, addr, "uid1", okproto.PC)
+	defer conn2.Close()
+
+	reasonCode := conn1.sendEvent(EventTypeMessageRead, &MessageReadReq{ChannelID: "uid2", ChannelType: okproto.ChannelTypePerson, MessageSeq: 4})
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+	event := conn2.readEvent(EventTypeConversationUpdate)
+	if assert.NotNil(t, event) {
+		var updateEvent SyncUserConversationResp
+		assert.NoError(t, okutil.ReadJSONByByte(event.Data, &updateEvent))
+		assert.Equal(t, "uid2", updateEvent.ChannelID)
+		assert.Equal(t, 1, updateEvent.Unread)
+		assert.Equal(t, uint32(5), updateEvent.LastMsgSeq)
+	}
+
+	// 上报已读的连接收不到会话更新
+	frames := append([]okproto.Frame{}, conn1.skipped...)
+	for {
+		_ = conn1.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
+		frame, err := okproto.New().DecodePacketWithConn(conn1.Conn, okproto.LatestVersion)
+		if err != nil {
+			break
+		}
+		frames = append(frames, frame)
+	}
+	for _, frame := range frames {
+		event, ok := frame.(*okproto.EventPacket)
+		assert.False(t, ok && event.Type == EventTypeConversationUpdate)
+	}
+}
+
+// 慢速模式按每条消息校验，同一批次的多条消息只有第一条能发送，频道信号不占用发消息的间隔
+func TestPutChannelMessagesSlowMode(t *testing.T) {
+	s := protoTestStart(t, nil)
+
+	err := s.channelManager.AddOrUpdateChannelInfo(okstore.NewChannelInfo("g1", okproto.ChannelTypeGroup))
+	assert.NoError(t, err)
+	err = s.store.AddSubscribers("g1", okproto.ChannelTypeGroup, []string{"uid1", "uid2"})
+	assert.NoError(t, err)
+	err = s.channelManager.UpdateModeration("g1", okproto.ChannelTypeGroup, func(moderation *okstore.ChannelModeration) {
+		moderation.SlowMode = 60
+	})
+	assert.NoError(t, err)
+
+	newMessages := func(fromUID string, count int) []*Message {
+		messages := make([]*Message, 0, count)
+		for i := 0; i < count; i++ {
+			messages = append(messages, &Message{
+				RecvPacket: &okproto.RecvPacket{
+					MessageID:   s.dispatch.processor.genMessageID(),
+					ClientMsgNo: okutil.GenUUID(),
+					FromUID:     fromUID,
+					ChannelID:   "g1",
+					ChannelType: okproto.ChannelTypeGroup,
+					Timestamp:   int32(time.Now().Unix()),
+					Payload:     []byte("hello"),
+				},
+			})
+		}
+		return messages
+	}
+	p := s.dispatch.processor
+
+	messages := newMessages("uid1", 3)
+	reasonCode, err := p.putChannelMessages("g1", okproto.ChannelTypeGroup, "uid1", okproto.APP, "device1", messages)
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+	assert.Equal(t, okproto.ReasonUnknown, messages[0].reasonCode)
+	assert.NotEqual(t, uint32(0), messages[0].MessageSeq)
+	for _, m := range messages[1:] {
+		assert.Equal(t, okproto.ReasonSlowMode, m.reasonCode)
+		assert.Equal(t, uint32(0), m.MessageSeq)
+	}
+	reasonCode, _ = p.putChannelMessages("g1", okproto.ChannelTypeGroup, "uid1", okproto.APP, "device1", newMessages("uid1", 1))
+	assert.Equal(t, okproto.ReasonSlowMode, reasonCode)
+
+	channel, err := s.channelManager.GetChannel("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		hasPerm, _ := p.hasPermission(channel, "uid2")
+		assert.True(t, hasPerm)
+	}
+	reasonCode, _ = p.putChannelMessages("g1", okproto.ChannelTypeGroup, "uid2", okproto.APP, "device2", newMessages("uid2", 1))
+	assert.Equal(t, okproto.ReasonSuccess, reasonCode)
+}
+
+// 启动使用随机端口的测试服务
+func protoTestStart(t *testing.T, configure func(opts *Options)) *Server {
+	vp := viper.New()
+	vp.Set("rootDir", t.TempDir())
+	vp.Set("addr", "tcp://127.0.0.1:0")
+	vp.Set("wsAddr", "ws://127.0.0.1:0")
+	vp.Set("mqttAddr", "tcp://127.0.0.1:0")
+	vp.Set("httpAddr", "127.0.0.1:0")
+	vp.Set("monitor.on", false)
+	vp.Set("demo.on", false)
+	opts := NewTestOptions()
+	opts.ConfigureWithViper(vp)
+	opts.DataDir = t.TempDir()
+	if configure != nil {
+		configure(opts)
+	}
+	s := NewTestServer(opts)
+	err := s.Start()
+	assert.NoError(t, err)
+	t.Cleanup(func() { s.Stop() })
+	return s
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/samlau0508/imserver/pkg/oklog"
+	"github.com/samlau0508/imserver/pkg/oknet"
+	"github.com/samlau0508/imserver/pkg/okstore"
+	"github.com/samlau0508/imserver/pkg/ratelimit"
+	"go.uber.org/zap"
+)
+
+// RateLimiter 限流 按用户、设备、来源ip限制发消息的频率，按用户限制发送频道信号的频率，按来源ip限制连接的频率
+type RateLimiter struct {
+	s              *Server
+	sendOfUID      *ratelimit.Limiter
+	sendOfDevice   *ratelimit.Limiter
+	sendOfIP       *ratelimit.Limiter
+	signalOfUID    *ratelimit.Limiter
+	connectOfIP    *ratelimit.Limiter
+	userLimitCache *lru.Cache[string, *okstore.RateLimit] // 用户单独设置的限流缓存（值为nil表示没有单独设置）
+	oklog.Log
+}
+
+// NewRateLimiter NewRateLimiter
+func NewRateLimiter(s *Server) *RateLimiter {
+	userLimitCache, err := lru.New[string, *okstore.RateLimit](s.opts.RateLimit.UserCacheCount)
+	if err != nil {
+		panic(err)
+	}
+	return &RateLimiter{
+		s:              s,
+		sendOfUID:      ratelimit.NewLimiter(),
+		sendOfDevice:   ratelimit.NewLimiter(),
+		sendOfIP:       ratelimit.NewLimiter(),
+		signalOfUID:    ratelimit.NewLimiter(),
+		connectOfIP:    ratelimit.NewLimiter(),
+		userLimitCache: userLimitCache,
+		Log:            oklog.NewOKLog("RateLimiter"),
+	}
+}
+
+// Start 定时清理空闲（令牌已满）的令牌桶
+func (r *RateLimiter) Start() {
+	if !r.s.opts.RateLimit.On {
+		return
+	}
+	r.s.Schedule(time.Minute, func() {
+		r.clean()
+	})
+}
+
+// AllowConnect 来源ip是否允许连接
+func (r *RateLimiter) AllowConnect(conn oknet.Conn) bool {
+	if !r.s.opts.RateLimit.On {
+		return true
+	}
+	ip := connIP(conn)
+	if ip == "" {
+		return true
+	}
+	limit := r.s.opts.RateLimit.ConnectPerIP
+	return r.connectOfIP.Allow(ip, limit.Rate, limit.Burst)
+}
+
+// AllowSend 连接是否允许发送消息（管理者和系统账号不限制）
+func (r *RateLimiter) AllowSend(conn oknet.Conn) bool {
+	if !r.s.opts.RateLimit.On {
+		return true
+	}
+	uid := conn.UID()
+	if uid == r.s.opts.ManagerUID || r.s.systemUIDManager.SystemUID(uid) {
+		return true
+	}
+	deviceLimit := r.s.opts.RateLimit.SendPerDevice
+	uidLimit := r.s.opts.RateLimit.SendPerUID
+	if userLimit := r.getUserLimit(uid); userLimit != nil { // 用户单独设置的限流同时作用于用户和用户的每个设备
+		deviceLimit = *userLimit
+		uidLimit = *userLimit
+	}
+	if !r.sendOfDevice.Allow(r.deviceKey(uid, conn.DeviceID()), deviceLimit.Rate, deviceLimit.Burst) {
+		r.Debug("设备发消息被限流！", zap.String("uid", uid), zap.String("deviceID", conn.DeviceID()))
+		return false
+	}
+	if !r.sendOfUID.Allow(uid, uidLimit.Rate, uidLimit.Burst) {
+		r.Debug("用户发消息被限流！", zap.String("uid", uid))
+		return false
+	}
+	if ip := connIP(conn); ip != "" {
+		ipLimit := r.s.opts.RateLimit.SendPerIP
+		if !r.sendOfIP.Allow(ip, ipLimit.Rate, ipLimit.Burst) {
+			r.Debug("ip发消息被限流！", zap.String("uid", uid), zap.String("ip", ip))
+			return false
+		}
+	}
+	return true
+}
+
+// AllowSignal 连接是否允许发送频道信号（管理者和系统账号不限制）
+func (r *RateLimiter) AllowSignal(conn oknet.Conn) bool {
+	if !r.s.opts.RateLimit.On {
+		return true
+	}
+	uid := conn.UID()
+	if uid == r.s.opts.ManagerUID || r.s.systemUIDManager.SystemUID(uid) {
+		return true
+	}
+	limit := r.s.opts.RateLimit.SignalPerUID
+	if !r.signalOfUID.Allow(uid, limit.Rate, limit.Burst) {
+		r.Debug("用户发送频道信号被限流！", zap.String("uid", uid))
+		return false
+	}
+	return true
+}
+
+// GetUserLimit 获取用户生效的发消息限流，custom表示是否是用户单独设置的
+func (r *RateLimiter) GetUserLimit(uid string) (limit okstore.RateLimit, custom bool, err error) {
+	userLimit, err := r.s.store.GetUserRateLimit(uid)
+	if err != nil {
+		return okstore.RateLimit{}, false, err
+	}
+	if userLimit != nil {
+		return *userLimit, true, nil
+	}
+	return r.s.opts.RateLimit.SendPerUID, false, nil
+}
+
+// SetUserLimit 设置用户单独的发消息限流 limit为nil表示移除（使用全局配置的限流）
+func (r *RateLimiter) SetUserLimit(uid string, limit *okstore.RateLimit) error {
+	if err := r.s.store.SetUserRateLimit(uid, limit); err != nil {
+		return err
+	}
+	r.userLimitCache.Remove(uid)
+	// 令牌桶按新的限流重新计算
+	r.sendOfUID.Remove(uid)
+	for _, conn := range r.s.connManager.GetConnsWithUID(uid) {
+		r.sendOfDevice.Remove(r.deviceKey(uid, conn.DeviceID()))
+	}
+	return nil
+}
+
+func (r *RateLimiter) getUserLimit(uid string) *okstore.RateLimit {
+	if userLimit, ok := r.userLimitCache.Get(uid); ok {
+		return userLimit
+	}
+	userLimit, err := r.s.store.GetUserRateLimit(uid)
+	if err != nil {
+		r.Error("获取用户的限流设置失败！", zap.Error(err), zap.String("uid", uid))
+		return nil
+	}
+	r.userLimitCache.Add(uid, userLimit)
+	return userLimit
+}
+
+func (r *RateLimiter) clean() {
+	r.sendOfUID.Clean()
+	r.sendOfDevice.Clean()
+	r.sendOfIP.Clean()
+	r.signalOfUID.Clean()
+	r.connectOfIP.Clean()
+}
+
+func (r *RateLimiter) deviceKey(uid string, deviceID string) string {
+	return fmt.Sprintf("%s-%s", uid, deviceID)
+}
+
+// connIP 获取连接的来源ip
+func connIP(conn oknet.Conn) string {
+	remoteAddr := conn.RemoteAddr()
+	if remoteAddr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(remoteAddr.String())
+	if err != nil {
+		return remoteAddr.String()
+	}
+	return host
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/samlau0508/imserver/pkg/oknet"
+	"github.com/samlau0508/imserver/pkg/okstore"
+	"github.com/stretchr/testify/assert"
+)
+
+type rateLimitTestConn struct {
+	oknet.Conn
+	uid      string
+	deviceID string
+	addr     net.Addr
+}
+
+func (c *rateLimitTestConn) UID() string          { return c.uid }
+func (c *rateLimitTestConn) DeviceID() string     { return c.deviceID }
+func (c *rateLimitTestConn) RemoteAddr() net.Addr { return c.addr }
+
+func TestRateLimiterSend(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	opts.RateLimit.On = true
+	opts.RateLimit.SendPerUID = okstore.RateLimit{Rate: 0.001, Burst: 3}
+	opts.RateLimit.SendPerDevice = okstore.RateLimit{Rate: 0.001, Burst: 2}
+	opts.RateLimit.SendPerIP = okstore.RateLimit{Rate: 0.001, Burst: 4}
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+
+	r := s.rateLimiter
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}
+	d1 := &rateLimitTestConn{uid: "u1", deviceID: "d1", addr: addr}
+	d2 := &rateLimitTestConn{uid: "u1", deviceID: "d2", addr: addr}
+	u2 := &rateLimitTestConn{uid: "u2", deviceID: "d1", addr: addr}
+
+	// 设备限流
+	assert.True(t, r.AllowSend(d1))
+	assert.True(t, r.AllowSend(d1))
+	assert.False(t, r.AllowSend(d1))
+	// 用户限流（多个设备共享）
+	assert.True(t, r.AllowSend(d2))
+	assert.False(t, r.AllowSend(d2))
+	// ip限流（多个用户共享）
+	assert.True(t, r.AllowSend(u2))
+	assert.False(t, r.AllowSend(u2))
+
+	// 用户单独设置的限流立即生效
+	err = r.SetUserLimit("u1", &okstore.RateLimit{Rate: 0})
+	assert.NoError(t, err)
+	limit, custom, err := r.GetUserLimit("u1")
+	assert.NoError(t, err)
+	assert.True(t, custom)
+	assert.True(t, limit.IsZero())
+	assert.False(t, r.AllowSend(d1)) // ip限流不受用户单独设置的影响
+
+	err = r.SetUserLimit("u1", nil)
+	assert.NoError(t, err)
+	_, custom, err = r.GetUserLimit("u1")
+	assert.NoError(t, err)
+	assert.False(t, custom)
+
+	// 管理者不限流
+	manager := &rateLimitTestConn{uid: s.opts.ManagerUID, deviceID: "d1", addr: addr}
+	assert.True(t, r.AllowSend(manager))
+}
+
+func TestRateLimiterConnect(t *testing.T) {
+	opts := NewTestOptions()
+	opts.RateLimit.On = true
+	opts.RateLimit.ConnectPerIP = okstore.RateLimit{Rate: 0.001, Burst: 1}
+	s := NewTestServer(opts)
+
+	conn1 := &rateLimitTestConn{addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}}
+	conn2 := &rateLimitTestConn{addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1001}}
+	conn3 := &rateLimitTestConn{addr: &net.TCPAddr{IP: net.ParseIP("::1"), Port: 1000}}
+	assert.True(t, s.rateLimiter.AllowConnect(conn1))
+	assert.False(t, s.rateLimiter.AllowConnect(conn2))
+	assert.True(t, s.rateLimiter.AllowConnect(conn3))
+}
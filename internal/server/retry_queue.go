@@ -6,16 +6,27 @@ import (
 	"sync"
 	"time"
 
+	"github.com/samlau0508/imserver/pkg/okstore"
 	"go.uber.org/zap"
 )
 
+// 投递中的消息持久化的间隔，间隔内同一条消息的添加和移除会合并（大部分消息很快就会收到ack，不需要写入存储）
+const inFlightPersistInterval = time.Millisecond * 200
+
 // RetryQueue 重试队列
+// 投递中的消息会异步持久化，服务重启后加载到resumeMessages，等设备重新连接后继续重试
 type RetryQueue struct {
 	inFlightPQ       inFlightPqueue
 	inFlightMessages map[string]*Message
 	inFlightMutex    sync.Mutex
 	s                *Server
 	fakeMessageID    int64
+
+	persistPending map[string]*okstore.InFlightMessage // 待持久化的投递中消息 Data为空表示待移除
+	persistMutex   sync.Mutex
+	flushMutex     sync.Mutex            // 保证持久化按顺序写入
+	resumeMessages map[string][]*Message // 服务重启前投递中的消息，key为uid和设备ID
+	resumeMutex    sync.Mutex
 }
 
 // NewRetryQueue NewRetryQueue
@@ -26,14 +37,20 @@ func NewRetryQueue(s *Server) *RetryQueue {
 		inFlightMessages: make(map[string]*Message),
 		s:                s,
 		fakeMessageID:    10000,
+		persistPending:   make(map[string]*okstore.InFlightMessage),
+		resumeMessages:   make(map[string][]*Message),
 	}
 }
 
 func (r *RetryQueue) startInFlightTimeout(msg *Message) {
 	now := time.Now()
 	msg.pri = now.Add(r.s.opts.MessageRetry.Interval).UnixNano()
+	if msg.inFlightAt == 0 {
+		msg.inFlightAt = now.Unix()
+	}
 	r.pushInFlightMessage(msg)
 	r.addToInFlightPQ(msg)
+	r.persistAdd(msg)
 
 	r.s.monitor.RetryQueueMsgInc()
 }
@@ -77,6 +94,7 @@ func (r *RetryQueue) finishMessage(uid string, deviceID string, messageID int64)
 		return err
 	}
 	r.removeFromInFlightPQ(msg)
+	r.persistRemove(msg)
 
 	r.s.monitor.RetryQueueMsgDec()
 
@@ -110,8 +128,165 @@ func (r *RetryQueue) processInFlightQueue(t int64) {
 	}
 }
 
+// persistAdd 添加或更新持久化的投递中消息（消息数据在投递前编码，payload是未加密的）
+func (r *RetryQueue) persistAdd(msg *Message) {
+	inFlightMessage := &okstore.InFlightMessage{
+		UID:        msg.ToUID,
+		DeviceID:   msg.toDeviceID,
+		MessageID:  msg.MessageID,
+		RetryCount: msg.retryCount,
+		Data:       msg.Encode(),
+		CreatedAt:  msg.inFlightAt,
+	}
+	r.persistMutex.Lock()
+	r.persistPending[r.getInFlightKey(msg.ToUID, msg.toDeviceID, msg.MessageID)] = inFlightMessage
+	r.persistMutex.Unlock()
+}
+
+// persistRemove 移除持久化的投递中消息
+func (r *RetryQueue) persistRemove(msg *Message) {
+	r.persistMutex.Lock()
+	r.persistPending[r.getInFlightKey(msg.ToUID, msg.toDeviceID, msg.MessageID)] = &okstore.InFlightMessage{
+		UID:       msg.ToUID,
+		DeviceID:  msg.toDeviceID,
+		MessageID: msg.MessageID,
+	}
+	r.persistMutex.Unlock()
+}
+
+// flushPersist 将待持久化的投递中消息写入存储
+func (r *RetryQueue) flushPersist() {
+	r.flushMutex.Lock()
+	defer r.flushMutex.Unlock()
+
+	r.persistMutex.Lock()
+	if len(r.persistPending) == 0 {
+		r.persistMutex.Unlock()
+		return
+	}
+	pending := r.persistPending
+	r.persistPending = make(map[string]*okstore.InFlightMessage, len(pending))
+	r.persistMutex.Unlock()
+
+	adds := make([]*okstore.InFlightMessage, 0, len(pending))
+	removes := make([]*okstore.InFlightMessage, 0, len(pending))
+	for _, inFlightMessage := range pending {
+		if len(inFlightMessage.Data) > 0 {
+			adds = append(adds, inFlightMessage)
+		} else {
+			removes = append(removes, inFlightMessage)
+		}
+	}
+	if err := r.s.store.AddOrUpdateInFlightMessages(adds); err != nil {
+		r.s.Error("保存投递中的消息失败！", zap.Error(err), zap.Int("count", len(adds)))
+		r.requeuePersist(adds)
+	}
+	if err := r.s.store.RemoveInFlightMessages(removes); err != nil {
+		r.s.Error("移除投递中的消息失败！", zap.Error(err), zap.Int("count", len(removes)))
+		r.requeuePersist(removes)
+	}
+}
+
+// requeuePersist 写入失败的投递中消息放回待持久化，下次持久化时重试（写入期间有更新的则以新的为准）
+func (r *RetryQueue) requeuePersist(inFlightMessages []*okstore.InFlightMessage) {
+	r.persistMutex.Lock()
+	defer r.persistMutex.Unlock()
+	for _, inFlightMessage := range inFlightMessages {
+		key := r.getInFlightKey(inFlightMessage.UID, inFlightMessage.DeviceID, inFlightMessage.MessageID)
+		if _, ok := r.persistPending[key]; ok {
+			continue
+		}
+		r.persistPending[key] = inFlightMessage
+	}
+}
+
+// loadInFlightMessages 加载服务重启前投递中的消息，等设备重新连接后继续重试
+func (r *RetryQueue) loadInFlightMessages() {
+	inFlightMessages, err := r.s.store.GetInFlightMessages()
+	if err != nil {
+		r.s.Error("加载投递中的消息失败！", zap.Error(err))
+		return
+	}
+	if len(inFlightMessages) == 0 {
+		return
+	}
+	now := time.Now()
+	expires := make([]*okstore.InFlightMessage, 0)
+	r.resumeMutex.Lock()
+	defer r.resumeMutex.Unlock()
+	for _, inFlightMessage := range inFlightMessages {
+		if r.resumeExpired(inFlightMessage.CreatedAt, now) {
+			expires = append(expires, inFlightMessage)
+			continue
+		}
+		msg := &Message{}
+		if err = msg.Decode(inFlightMessage.Data); err != nil {
+			r.s.Warn("解码投递中的消息失败！", zap.Error(err), zap.String("uid", inFlightMessage.UID), zap.Int64("messageID", inFlightMessage.MessageID))
+			expires = append(expires, inFlightMessage)
+			continue
+		}
+		msg.ToUID = inFlightMessage.UID
+		msg.toDeviceID = inFlightMessage.DeviceID
+		msg.retryCount = inFlightMessage.RetryCount
+		msg.inFlightAt = inFlightMessage.CreatedAt
+		key := r.getResumeKey(msg.ToUID, msg.toDeviceID)
+		r.resumeMessages[key] = append(r.resumeMessages[key], msg)
+	}
+	if err = r.s.store.RemoveInFlightMessages(expires); err != nil {
+		r.s.Error("移除过期的投递中消息失败！", zap.Error(err))
+	}
+	r.s.Info("加载投递中的消息", zap.Int("count", len(inFlightMessages)-len(expires)), zap.Int("expired", len(expires)))
+}
+
+// resumeInFlightMessages 设备重新连接后继续重试服务重启前投递中的消息
+func (r *RetryQueue) resumeInFlightMessages(uid string, deviceID string) {
+	key := r.getResumeKey(uid, deviceID)
+	r.resumeMutex.Lock()
+	messages := r.resumeMessages[key]
+	delete(r.resumeMessages, key)
+	r.resumeMutex.Unlock()
+	for _, msg := range messages {
+		r.persistRemove(msg) // 重试的时候会重新添加，超过重试次数或过期了则移除
+		r.s.deliveryManager.startRetryDeliveryMsg(msg)
+	}
+}
+
+// clearExpiredResumeMessages 清除超过时间设备还没有重新连接的消息
+func (r *RetryQueue) clearExpiredResumeMessages() {
+	now := time.Now()
+	r.resumeMutex.Lock()
+	defer r.resumeMutex.Unlock()
+	for key, messages := range r.resumeMessages {
+		newMessages := messages[:0]
+		for _, msg := range messages {
+			if r.resumeExpired(msg.inFlightAt, now) {
+				r.persistRemove(msg)
+				continue
+			}
+			newMessages = append(newMessages, msg)
+		}
+		if len(newMessages) == 0 {
+			delete(r.resumeMessages, key)
+		} else {
+			r.resumeMessages[key] = newMessages
+		}
+	}
+}
+
+func (r *RetryQueue) resumeExpired(inFlightAt int64, now time.Time) bool {
+	resumeTimeout := r.s.opts.MessageRetry.ResumeTimeout
+	return resumeTimeout > 0 && now.Sub(time.Unix(inFlightAt, 0)) > resumeTimeout
+}
+
+func (r *RetryQueue) getResumeKey(uid string, deviceID string) string {
+	return fmt.Sprintf("%s_%s", uid, deviceID)
+}
+
 // Start 开始运行重试
 func (r *RetryQueue) Start() {
+	r.loadInFlightMessages()
+
+	r.s.Schedule(inFlightPersistInterval, r.flushPersist)
 	r.s.Schedule(r.s.opts.MessageRetry.ScanInterval, func() {
 		now := time.Now().UnixNano()
 		r.processInFlightQueue(now)
@@ -122,8 +297,9 @@ func (r *RetryQueue) Start() {
 		defer r.inFlightMutex.Unlock()
 		r.s.monitor.InFlightMessagesSet(len(r.inFlightMessages))
 	})
+	r.s.Schedule(time.Minute*5, r.clearExpiredResumeMessages)
 }
 
 func (r *RetryQueue) Stop() {
-
+	r.flushPersist()
 }
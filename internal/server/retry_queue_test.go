@@ -0,0 +1,143 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/okstore"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryQueuePersist(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+
+	r := s.retryQueue
+	msg := &Message{
+		RecvPacket: &okproto.RecvPacket{
+			MessageID:   1,
+			MessageSeq:  1,
+			ChannelID:   "group1",
+			ChannelType: okproto.ChannelTypeGroup,
+			FromUID:     "test",
+			Timestamp:   int32(time.Now().Unix()),
+			Payload:     []byte("hello"),
+		},
+		ToUID:      "u1",
+		toDeviceID: "d1",
+	}
+	r.startInFlightTimeout(msg)
+	r.flushPersist()
+	inFlightMessages, err := s.store.GetInFlightMessages()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(inFlightMessages))
+
+	// 服务重启后加载投递中的消息，等设备重新连接后继续重试
+	r2 := NewRetryQueue(s)
+	r2.loadInFlightMessages()
+	messages := r2.resumeMessages[r2.getResumeKey("u1", "d1")]
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, int64(1), messages[0].MessageID)
+	assert.Equal(t, []byte("hello"), messages[0].Payload)
+	assert.Equal(t, "u1", messages[0].ToUID)
+	assert.Equal(t, "d1", messages[0].toDeviceID)
+
+	// 收到ack后移除
+	err = r.finishMessage("u1", "d1", 1)
+	assert.NoError(t, err)
+	r.flushPersist()
+	inFlightMessages, err = s.store.GetInFlightMessages()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(inFlightMessages))
+
+	// 添加和移除在同一个持久化间隔内则不写入存储
+	msg.MessageID = 2
+	r.startInFlightTimeout(msg)
+	err = r.finishMessage("u1", "d1", 2)
+	assert.NoError(t, err)
+	r.flushPersist()
+	inFlightMessages, err = s.store.GetInFlightMessages()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(inFlightMessages))
+}
+
+// 保存投递中的消息失败的存储，写入时回调用于模拟写入期间有新的更新
+type retryTestFailStore struct {
+	okstore.Store
+	fail     bool
+	onUpdate func()
+}
+
+func (f *retryTestFailStore) AddOrUpdateInFlightMessages(messages []*okstore.InFlightMessage) error {
+	if f.onUpdate != nil {
+		f.onUpdate()
+	}
+	if f.fail {
+		return errors.New("store fail")
+	}
+	return f.Store.AddOrUpdateInFlightMessages(messages)
+}
+
+func TestRetryQueuePersistFail(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+	failStore := &retryTestFailStore{Store: s.store, fail: true}
+	s.store = failStore
+
+	r := s.retryQueue
+	newMsg := func(messageID int64) *Message {
+		return &Message{
+			RecvPacket: &okproto.RecvPacket{
+				MessageID:   messageID,
+				MessageSeq:  uint32(messageID),
+				ChannelID:   "group1",
+				ChannelType: okproto.ChannelTypeGroup,
+				FromUID:     "test",
+				Timestamp:   int32(time.Now().Unix()),
+				Payload:     []byte("hello"),
+			},
+			ToUID:      "u1",
+			toDeviceID: "d1",
+		}
+	}
+
+	// 写入失败的放回待持久化，下次持久化时重试
+	r.startInFlightTimeout(newMsg(1))
+	r.flushPersist()
+	inFlightMessages, err := s.store.GetInFlightMessages()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(inFlightMessages))
+	assert.Equal(t, 1, len(r.persistPending))
+
+	failStore.fail = false
+	r.flushPersist()
+	inFlightMessages, err = s.store.GetInFlightMessages()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(inFlightMessages))
+	assert.Equal(t, 0, len(r.persistPending))
+
+	// 写入期间已经收到ack的，失败的写入不覆盖新的移除
+	failStore.fail = true
+	r.startInFlightTimeout(newMsg(2))
+	failStore.onUpdate = func() {
+		failStore.onUpdate = nil
+		assert.NoError(t, r.finishMessage("u1", "d1", 2))
+	}
+	r.flushPersist()
+	failStore.fail = false
+	r.flushPersist()
+	inFlightMessages, err = s.store.GetInFlightMessages()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(inFlightMessages))
+	assert.Equal(t, int64(1), inFlightMessages[0].MessageID)
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/oklog"
+	"github.com/samlau0508/imserver/pkg/oksearch"
+	"github.com/samlau0508/imserver/pkg/okstore"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"go.uber.org/zap"
+)
+
+// SearchManager 消息搜索，消息存储时在频道所在节点建立全文索引
+type SearchManager struct {
+	s     *Server
+	index *oksearch.Index
+	oklog.Log
+}
+
+// NewSearchManager NewSearchManager
+func NewSearchManager(s *Server) *SearchManager {
+	return &SearchManager{
+		s:   s,
+		Log: oklog.NewOKLog("SearchManager"),
+	}
+}
+
+// Start Start
+func (sm *SearchManager) Start() error {
+	if !sm.s.opts.Search.On {
+		return nil
+	}
+	cfg := oksearch.NewConfig()
+	cfg.Dir = filepath.Join(sm.s.opts.DataDir, "search")
+	cfg.Tokenizer = sm.s.opts.Search.Tokenizer
+	index, err := oksearch.NewIndex(cfg)
+	if err != nil {
+		return err
+	}
+	if err = index.Open(); err != nil {
+		return err
+	}
+	sm.index = index
+	return nil
+}
+
+// Stop Stop
+func (sm *SearchManager) Stop() {
+	if sm.index != nil {
+		_ = sm.index.Close()
+	}
+}
+
+// On 是否开启了消息搜索
+func (sm *SearchManager) On() bool {
+	return sm.index != nil
+}
+
+// IndexMessages 给已存储的消息建立索引（索引失败不影响消息的存储和投递）
+func (sm *SearchManager) IndexMessages(fakeChannelID string, channelType uint8, messages []okstore.Message) {
+	if !sm.On() || len(messages) == 0 {
+		return
+	}
+	docs := make([]*oksearch.Document, 0, len(messages))
+	for _, m := range messages {
+		message := m.(*Message)
+		if message.MessageSeq == 0 || message.StreamIng() {
+			continue
+		}
+		text := oksearch.ExtractText(message.Payload)
+		if text == "" {
+			continue
+		}
+		docs = append(docs, &oksearch.Document{
+			ChannelID:   fakeChannelID,
+			ChannelType: channelType,
+			MessageSeq:  message.MessageSeq,
+			Text:        text,
+		})
+	}
+	if err := sm.index.Add(docs); err != nil {
+		sm.Error("建立消息索引失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channelType))
+	}
+}
+
+// IndexEdit 给编辑后的消息内容建立索引（编辑前的内容在搜索时会被过滤掉）
+func (sm *SearchManager) IndexEdit(fakeChannelID string, channelType uint8, messageSeq uint32, payload []byte) {
+	if !sm.On() {
+		return
+	}
+	text := oksearch.ExtractText(payload)
+	if text == "" {
+		return
+	}
+	err := sm.index.Add([]*oksearch.Document{{
+		ChannelID:   fakeChannelID,
+		ChannelType: channelType,
+		MessageSeq:  messageSeq,
+		Text:        text,
+	}})
+	if err != nil {
+		sm.Error("建立编辑消息的索引失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channelType), zap.Uint32("messageSeq", messageSeq))
+	}
+}
+
+// Search 搜索用户多个频道的消息，频道的索引在频道所在节点，按节点分组搜索
+func (sm *SearchManager) Search(uid string, channels []*MessageSearchChannel, keyword string, limit int) ([]*MessageSearchResult, error) {
+	if !sm.s.clusterManager.On() {
+		return sm.SearchLocal(uid, channels, keyword, 0, limit)
+	}
+	localChannels := make([]*MessageSearchChannel, 0, len(channels))
+	remoteChannelMap := map[int64][]*MessageSearchChannel{}
+	for _, channel := range channels {
+		nodeID, err := sm.s.clusterManager.NodeIDOfChannel(channel.fakeChannelID(uid), channel.ChannelType)
+		if err != nil {
+			return nil, err
+		}
+		if sm.s.clusterManager.IsLocal(nodeID) {
+			localChannels = append(localChannels, channel)
+			continue
+		}
+		remoteChannelMap[nodeID] = append(remoteChannelMap[nodeID], channel)
+	}
+	results, err := sm.SearchLocal(uid, localChannels, keyword, 0, limit)
+	if err != nil {
+		return nil, err
+	}
+	for nodeID, remoteChannels := range remoteChannelMap {
+		var remoteResults []*MessageSearchResult
+		err = sm.s.clusterManager.requestNode(nodeID, "/message/search", &MessageSearchReq{
+			UID:      uid,
+			Keyword:  keyword,
+			Limit:    limit,
+			Channels: remoteChannels,
+		}, &remoteResults)
+		if err != nil {
+			sm.Error("搜索其他节点的消息失败！", zap.Error(err), zap.Int64("nodeID", nodeID))
+			return nil, err
+		}
+		results = append(results, remoteResults...)
+	}
+	// 最近有匹配消息的频道排在前面
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Messages[0].Timestamp > results[j].Messages[0].Timestamp
+	})
+	return results, nil
+}
+
+// SearchLocal 搜索本节点频道的消息，没有匹配消息的频道不返回
+func (sm *SearchManager) SearchLocal(uid string, channels []*MessageSearchChannel, keyword string, startMessageSeq uint32, limit int) ([]*MessageSearchResult, error) {
+	results := make([]*MessageSearchResult, 0)
+	for _, channel := range channels {
+		result, err := sm.searchChannel(uid, channel, keyword, startMessageSeq, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Messages) > 0 {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+func (sm *SearchManager) searchChannel(uid string, channel *MessageSearchChannel, keyword string, startMessageSeq uint32, limit int) (*MessageSearchResult, error) {
+	fakeChannelID := channel.fakeChannelID(uid)
+	terms := sm.index.Tokenizer().Tokenize(keyword)
+	now := time.Now().Unix()
+	markers, err := sm.s.store.GetMessageDeleteMarkers(fakeChannelID, channel.ChannelType, uid)
+	if err != nil {
+		return nil, err
+	}
+	result := &MessageSearchResult{
+		ChannelID:   channel.ChannelID,
+		ChannelType: channel.ChannelType,
+		Messages:    make([]*MessageResp, 0),
+	}
+	err = sm.index.Search(fakeChannelID, channel.ChannelType, keyword, startMessageSeq, func(messageSeq uint32) bool {
+		msg, err := sm.s.store.LoadMsg(fakeChannelID, channel.ChannelType, messageSeq)
+		if err != nil || msg == nil { // 消息可能已按保留策略删除
+			sm.Debug("搜索到的消息不存在！", zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channel.ChannelType), zap.Uint32("messageSeq", messageSeq))
+			return true
+		}
+		message := msg.(*Message)
+		if okstore.MessageExpired(message, now) || markers.Deleted(message.MessageSeq, message.MessageID) {
+			return true
+		}
+		messageResp := &MessageResp{}
+		messageResp.from(message, sm.s.store)
+		sm.s.messageManager.fillMessageExtras(fakeChannelID, channel.ChannelType, []*MessageResp{messageResp})
+		// 撤回的消息不返回，编辑过的消息按最新的内容匹配
+		if messageResp.Revoke == 1 || !containsAllTerms(sm.index.Tokenizer().Tokenize(oksearch.ExtractText(messageResp.Payload)), terms) {
+			return true
+		}
+		if len(result.Messages) >= limit {
+			result.More = 1
+			return false
+		}
+		result.Messages = append(result.Messages, messageResp)
+		return true
+	})
+	if err != nil {
+		sm.Error("搜索消息失败！", zap.Error(err), zap.String("channelID", fakeChannelID), zap.Uint8("channelType", channel.ChannelType))
+		return nil, err
+	}
+	return result, nil
+}
+
+func containsAllTerms(tokens []string, terms []string) bool {
+	tokenMap := make(map[string]struct{}, len(tokens))
+	for _, token := range tokens {
+		tokenMap[token] = struct{}{}
+	}
+	for _, term := range terms {
+		if _, ok := tokenMap[term]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeChannelID 个人频道的消息存储在uid和对方组成的fake频道里
+func (m *MessageSearchChannel) fakeChannelID(uid string) string {
+	if m.ChannelType == okproto.ChannelTypePerson {
+		return GetFakeChannelIDWith(uid, m.ChannelID)
+	}
+	return m.ChannelID
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/okstore"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchManagerSearchLocal(t *testing.T) {
+	opts := NewTestOptions()
+	opts.DataDir = t.TempDir()
+	opts.Search.On = true
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+	err = s.searchManager.Start()
+	assert.NoError(t, err)
+	defer s.searchManager.Stop()
+
+	payloads := []string{
+		`{"type":1,"content":"今天天气不错"}`,
+		`{"type":1,"content":"hello world"}`,
+		`{"type":1,"content":"明天天气怎么样"}`,
+		`{"type":1,"content":"天气预报"}`,
+	}
+	messages := make([]okstore.Message, 0, len(payloads))
+	for i, payload := range payloads {
+		messages = append(messages, &Message{
+			RecvPacket: &okproto.RecvPacket{
+				MessageID:   int64(i + 1),
+				MessageSeq:  uint32(i + 1),
+				ChannelID:   "group1",
+				ChannelType: okproto.ChannelTypeGroup,
+				FromUID:     "test",
+				Timestamp:   int32(time.Now().Unix()),
+				Payload:     []byte(payload),
+			},
+		})
+	}
+	_, err = s.store.AppendMessages("group1", okproto.ChannelTypeGroup, messages)
+	assert.NoError(t, err)
+	s.searchManager.IndexMessages("group1", okproto.ChannelTypeGroup, messages)
+
+	channels := []*MessageSearchChannel{{ChannelID: "group1", ChannelType: okproto.ChannelTypeGroup}}
+	results, err := s.searchManager.SearchLocal("test", channels, "天气", 0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, 2, len(results[0].Messages))
+	assert.Equal(t, uint32(4), results[0].Messages[0].MessageSeq)
+	assert.Equal(t, uint32(3), results[0].Messages[1].MessageSeq)
+	assert.Equal(t, 1, results[0].More)
+
+	results, err = s.searchManager.SearchLocal("test", channels, "天气", 3, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results[0].Messages))
+	assert.Equal(t, uint32(1), results[0].Messages[0].MessageSeq)
+	assert.Equal(t, 0, results[0].More)
+
+	results, err = s.searchManager.SearchLocal("test", channels, "下雨", 0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(results))
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/judwhite/go-svc"
 	"github.com/panjf2000/ants/v2"
 	"github.com/samlau0508/imserver/internal/monitor"
+	"github.com/samlau0508/imserver/pkg/okauth"
 	"github.com/samlau0508/imserver/pkg/oklog"
 	"github.com/samlau0508/imserver/pkg/okstore"
 	"github.com/samlau0508/imserver/pkg/okutil"
@@ -40,15 +41,24 @@ type Server struct {
 	start               time.Time                // 服务开始时间
 	timingWheel         *timingwheel.TimingWheel // Time wheel delay task
 	deliveryManager     *DeliveryManager         // 消息投递管理
+	messageManager      *MessageManager          // 消息的管理（系统发送、撤回、编辑等）
+	clusterManager      *ClusterManager          // 集群管理（节点成员和用户/频道所在节点）
+	searchManager       *SearchManager           // 消息搜索
+	threadManager       *ThreadManager           // 子区
 	monitor             monitor.IMonitor         // Data monitoring
 	dispatch            *Dispatch                // 消息流入流出分发器
 	store               okstore.Store            // 存储相关接口
+	storeCfg            *okstore.StoreConfig     // 存储配置（消息保留策略等）
 	connManager         *ConnManager             // conn manager
 	systemUIDManager    *SystemUIDManager        // System uid management, system uid can send messages to everyone without any restrictions
 	datasource          IDatasource              // 数据源（提供数据源 订阅者，黑名单，白名单这些数据可以交由第三方提供）
 	channelManager      *ChannelManager          // channel manager
 	conversationManager *ConversationManager     // conversation manager
 	retryQueue          *RetryQueue              // retry queue
+	rateLimiter         *RateLimiter             // 限流
+	apiKeyManager       *APIKeyManager           // api密钥管理
+	presenceManager     *PresenceManager         // 在线状态订阅
+	authVerifier        okauth.Verifier          // 连接认证
 	webhook             *Webhook                 // webhook
 	monitorServer       *MonitorServer           // 监控服务
 	demoServer          *DemoServer              // demo server
@@ -75,6 +85,10 @@ func New(opts *Options) *Server {
 
 	storeCfg := okstore.NewStoreConfig()
 	storeCfg.DataDir = s.opts.DataDir
+	storeCfg.ExpireScanInterval = s.opts.Store.ExpireScanInterval
+	storeCfg.ArchiveDir = s.opts.Store.ArchiveDir
+	storeCfg.Retention = s.opts.Store.Retention
+	storeCfg.ChannelTypeRetentions = s.opts.Store.ChannelTypeRetentions
 	storeCfg.DecodeMessageFnc = func(msg []byte) (okstore.Message, error) {
 		m := &Message{}
 		err := m.Decode(msg)
@@ -82,10 +96,28 @@ func New(opts *Options) *Server {
 	}
 
 	monitor.SetMonitorOn(opts.Monitor.On) // 监控开关
-	s.store = okstore.NewFileStore(storeCfg)
+	store, err := okstore.NewStore(s.opts.Store.Driver, storeCfg)
+	if err != nil {
+		panic(err)
+	}
+	s.store = store
+	s.storeCfg = storeCfg
+
+	authCfg := okauth.NewConfig()
+	authCfg.JWT = s.opts.Auth.JWT
+	authCfg.Callback = s.opts.Auth.Callback
+	authCfg.GetUserToken = s.store.GetUserToken
+	s.authVerifier, err = okauth.NewVerifier(s.opts.Auth.Verifier, authCfg)
+	if err != nil {
+		panic(err)
+	}
 
 	s.apiServer = NewAPIServer(s)
 	s.deliveryManager = NewDeliveryManager(s)
+	s.messageManager = NewMessageManager(s)
+	s.clusterManager = NewClusterManager(s)
+	s.searchManager = NewSearchManager(s)
+	s.threadManager = NewThreadManager(s)
 	s.dispatch = NewDispatch(s)
 	s.connManager = NewConnManager(s)
 	s.systemUIDManager = NewSystemUIDManager(s)
@@ -93,11 +125,13 @@ func New(opts *Options) *Server {
 	s.channelManager = NewChannelManager(s)
 	s.conversationManager = NewConversationManager(s)
 	s.retryQueue = NewRetryQueue(s)
+	s.rateLimiter = NewRateLimiter(s)
+	s.apiKeyManager = NewAPIKeyManager(s)
+	s.presenceManager = NewPresenceManager(s)
 	s.webhook = NewWebhook(s)
 	s.monitor = monitor.GetMonitor() // 监控
 	s.monitorServer = NewMonitorServer(s)
 	s.demoServer = NewDemoServer(s)
-	var err error
 	s.handleGoroutinePool, err = ants.NewPool(s.opts.HandlePoolSize)
 	if err != nil {
 		panic(err)
@@ -130,6 +164,9 @@ func (s *Server) Start() error {
 	if s.opts.WSSAddr != "" {
 		s.Info(fmt.Sprintf("Listening  for WSS client on %s", s.opts.WSSAddr))
 	}
+	if s.opts.MQTTAddr != "" {
+		s.Info(fmt.Sprintf("Listening  for MQTT client on %s", s.opts.MQTTAddr))
+	}
 	s.Info(fmt.Sprintf("Listening  for Manager Http api on %s", fmt.Sprintf("http://%s", s.opts.HTTPAddr)))
 
 	if s.opts.Monitor.On {
@@ -142,13 +179,25 @@ func (s *Server) Start() error {
 	if err != nil {
 		panic(err)
 	}
+	err = s.searchManager.Start()
+	if err != nil {
+		return err
+	}
 	err = s.dispatch.Start()
 	if err != nil {
 		panic(err)
 	}
+	err = s.apiKeyManager.Load()
+	if err != nil {
+		return err
+	}
 	s.apiServer.Start()
+	s.clusterManager.Start()
 
 	s.conversationManager.Start()
+	s.messageManager.Start()
+	s.threadManager.Start()
+	s.presenceManager.Start()
 	s.webhook.Start()
 
 	s.retryQueue.Start()
@@ -157,6 +206,8 @@ func (s *Server) Start() error {
 
 	s.initIPBlacklist() // 初始化ip黑名单
 
+	s.rateLimiter.Start()
+
 	// 打印黑名单阻止情况
 	s.Schedule(5*time.Minute, func() {
 		s.printIpBlacklist()
@@ -183,10 +234,14 @@ func (s *Server) Stop() error {
 
 	s.timingWheel.Stop()
 
-	s.retryQueue.Stop()
+	s.clusterManager.Stop()
 	_ = s.dispatch.Stop()
 	s.apiServer.Stop()
 	s.conversationManager.Stop()
+	s.messageManager.Stop()
+	s.threadManager.Stop()
+	s.searchManager.Stop()
+	s.presenceManager.Stop()
 	s.webhook.Stop()
 
 	if s.opts.Monitor.On {
@@ -196,6 +251,7 @@ func (s *Server) Stop() error {
 	if s.opts.Demo.On {
 		s.demoServer.Stop()
 	}
+	s.retryQueue.Stop() // 投递中的消息需要在关闭存储前持久化
 	s.store.Close()
 	close(s.stopChan)
 
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/gin-gonic/gin"
 	"github.com/samlau0508/imserver/pkg/okhttp"
 	"github.com/samlau0508/imserver/pkg/oklog"
 	"go.uber.org/zap"
@@ -46,18 +47,7 @@ func NewAPIServer(s *Server) *APIServer {
 // Start 开始
 func (s *APIServer) Start() {
 
-	s.r.Use(func(c *okhttp.Context) { // 管理者权限判断
-		if strings.TrimSpace(s.s.opts.ManagerToken) == "" {
-			c.Next()
-			return
-		}
-		managerToken := c.GetHeader("token")
-		if managerToken != s.s.opts.ManagerToken {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
-		c.Next()
-	})
+	s.r.Use(s.s.apiKeyManager.Authorize) // 权限判断（managerToken或api密钥）
 
 	s.setRoutes()
 	go func() {
@@ -73,6 +63,11 @@ func (s *APIServer) Start() {
 func (s *APIServer) Stop() {
 }
 
+// APIRoutes api服务注册的路由（服务启动后才有）
+func (s *Server) APIRoutes() gin.RoutesInfo {
+	return s.apiServer.r.GetGinRoute().Routes()
+}
+
 func (s *APIServer) setRoutes() {
 	connz := NewConnzAPI(s.s)
 	connz.Route(s.r)
@@ -96,6 +91,18 @@ func (s *APIServer) setRoutes() {
 	message := NewMessageAPI(s.s)
 	message.Route(s.r)
 
+	// 消息回应API
+	reaction := NewReactionAPI(s.s)
+	reaction.Route(s.r)
+
+	// 置顶消息API
+	pin := NewPinAPI(s.s)
+	pin.Route(s.r)
+
+	// 子区API
+	thread := NewThreadAPI(s.s)
+	thread.Route(s.r)
+
 	// 路由api
 	routeapi := NewRouteAPI(s.s)
 	routeapi.Route(s.r)
@@ -103,4 +110,8 @@ func (s *APIServer) setRoutes() {
 	// 系统api
 	system := NewSystemAPI(s.s)
 	system.Route(s.r)
+
+	// 集群api
+	cluster := NewClusterAPI(s.s)
+	cluster.Route(s.r)
 }
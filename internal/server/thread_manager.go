@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/samlau0508/imserver/pkg/keylock"
+	"github.com/samlau0508/imserver/pkg/oklog"
+	"github.com/samlau0508/imserver/pkg/okstore"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"go.uber.org/zap"
+)
+
+// ThreadManager 子区管理
+// 回复社区频道的消息时，回复发送到子区频道（社区话题频道，ID为：社区频道ID@被回复的消息序号），子区频道的订阅者继承自社区频道
+// 子区频道和社区频道在同一节点，回复存储后在本节点更新被回复消息的回复数和最后回复
+type ThreadManager struct {
+	s    *Server
+	lock *keylock.KeyLock // 同一个社区频道的子区串行更新
+	oklog.Log
+}
+
+// NewThreadManager NewThreadManager
+func NewThreadManager(s *Server) *ThreadManager {
+	return &ThreadManager{
+		s:    s,
+		lock: keylock.NewKeyLock(),
+		Log:  oklog.NewOKLog("ThreadManager"),
+	}
+}
+
+// Start Start
+func (tm *ThreadManager) Start() {
+	tm.lock.StartCleanLoop()
+}
+
+// Stop Stop
+func (tm *ThreadManager) Stop() {
+	tm.lock.StopCleanLoop()
+}
+
+// AddReplies 子区频道的消息存储后更新子区（更新失败不影响消息的存储和投递）
+func (tm *ThreadManager) AddReplies(channelID string, channelType uint8, messages []okstore.Message) {
+	if channelType != okproto.ChannelTypeCommunityTopic || len(messages) == 0 {
+		return
+	}
+	parentChannelID, rootMessageSeq, ok := parseThreadChannelID(channelID)
+	if !ok {
+		return
+	}
+	var (
+		replyCount uint32
+		lastReply  *Message
+	)
+	for _, m := range messages {
+		message := m.(*Message)
+		if message.MessageSeq == 0 || message.StreamIng() {
+			continue
+		}
+		replyCount++
+		lastReply = message
+	}
+	if replyCount == 0 {
+		return
+	}
+	if _, err := tm.updateThread(parentChannelID, rootMessageSeq, replyCount, lastReply); err != nil {
+		tm.Error("更新子区失败！", zap.Error(err), zap.String("channelID", parentChannelID), zap.Uint32("rootMessageSeq", rootMessageSeq))
+	}
+}
+
+// 更新被回复消息的回复数和最后回复，被回复的消息不存在的话题频道不是子区，返回nil
+func (tm *ThreadManager) updateThread(parentChannelID string, rootMessageSeq uint32, replyCount uint32, lastReply *Message) (*okstore.Thread, error) {
+	lockKey := fmt.Sprintf("%s-%d", parentChannelID, okproto.ChannelTypeCommunity)
+	tm.lock.Lock(lockKey)
+	defer tm.lock.Unlock(lockKey)
+
+	rootMessage, reasonCode, err := tm.s.messageManager.loadMessage(parentChannelID, okproto.ChannelTypeCommunity, rootMessageSeq, 0)
+	if err != nil {
+		return nil, err
+	}
+	if reasonCode != okproto.ReasonSuccess {
+		tm.Debug("被回复的消息不存在，不是子区", zap.String("channelID", parentChannelID), zap.Uint32("rootMessageSeq", rootMessageSeq))
+		return nil, nil
+	}
+	var thread *okstore.Thread
+	_, err = tm.s.messageManager.updateMessageExtra(parentChannelID, okproto.ChannelTypeCommunity, rootMessage, func(extra *okstore.MessageExtra) okproto.ReasonCode {
+		extra.ReplyCount += replyCount
+		extra.LastReplyUID = lastReply.FromUID
+		extra.LastReplyMessageID = lastReply.MessageID
+		extra.LastReplyMessageSeq = lastReply.MessageSeq
+		extra.LastReplyAt = int64(lastReply.Timestamp)
+		thread = &okstore.Thread{
+			RootMessageID:       rootMessage.MessageID,
+			RootMessageSeq:      rootMessage.MessageSeq,
+			ReplyCount:          extra.ReplyCount,
+			LastReplyUID:        extra.LastReplyUID,
+			LastReplyMessageID:  extra.LastReplyMessageID,
+			LastReplyMessageSeq: extra.LastReplyMessageSeq,
+			LastReplyAt:         extra.LastReplyAt,
+		}
+		return okproto.ReasonSuccess
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = tm.s.store.AddOrUpdateThread(parentChannelID, okproto.ChannelTypeCommunity, thread); err != nil {
+		return nil, err
+	}
+	return thread, nil
+}
+
+// Threads 获取社区频道最近活跃的子区（按最后回复时间倒序）
+// since 不为0时只返回最后回复时间不早于since的子区
+func (tm *ThreadManager) Threads(channelID string, since int64, limit int) ([]*ThreadResp, error) {
+	threads, err := tm.s.store.GetThreads(channelID, okproto.ChannelTypeCommunity, limit)
+	if err != nil {
+		return nil, err
+	}
+	resps := make([]*ThreadResp, 0, len(threads))
+	for _, thread := range threads {
+		if since > 0 && thread.LastReplyAt < since {
+			break
+		}
+		resp := newThreadResp(channelID, thread)
+		msg, err := tm.s.store.LoadMsg(channelID, okproto.ChannelTypeCommunity, thread.RootMessageSeq)
+		if err != nil {
+			tm.Error("获取子区的被回复消息失败！", zap.Error(err), zap.String("channelID", channelID), zap.Uint32("rootMessageSeq", thread.RootMessageSeq))
+			return nil, err
+		}
+		if msg != nil { // 消息可能已按保留策略删除
+			resp.RootMessage = &MessageResp{}
+			resp.RootMessage.from(msg.(*Message), tm.s.store)
+		}
+		resps = append(resps, resp)
+	}
+	rootMessages := make([]*MessageResp, 0, len(resps))
+	for _, resp := range resps {
+		if resp.RootMessage != nil {
+			rootMessages = append(rootMessages, resp.RootMessage)
+		}
+	}
+	tm.s.messageManager.fillMessageExtras(channelID, okproto.ChannelTypeCommunity, rootMessages)
+	return resps, nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/samlau0508/imserver/pkg/okstore"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseThreadChannelID(t *testing.T) {
+	channelID, rootMessageSeq, ok := parseThreadChannelID(GetThreadChannelID("c1", 10))
+	assert.True(t, ok)
+	assert.Equal(t, "c1", channelID)
+	assert.Equal(t, uint32(10), rootMessageSeq)
+
+	for _, channelID := range []string{"c1", "c1@topic", "c1@0", "@1", "c1@1@2"} {
+		_, _, ok = parseThreadChannelID(channelID)
+		assert.False(t, ok, channelID)
+	}
+}
+
+func TestThreadManagerAddReplies(t *testing.T) {
+	opts := NewTestOptions()
+	opts.Store.Driver = okstore.DriverMemory
+	s := NewTestServer(opts)
+	err := s.store.Open()
+	assert.NoError(t, err)
+	defer s.store.Close()
+
+	newMessage := func(messageID int64, channelID string, channelType uint8, fromUID string, timestamp int32) *Message {
+		return &Message{
+			RecvPacket: &okproto.RecvPacket{
+				MessageID:   messageID,
+				ChannelID:   channelID,
+				ChannelType: channelType,
+				FromUID:     fromUID,
+				Timestamp:   timestamp,
+				Payload:     []byte("hello"),
+			},
+		}
+	}
+	_, err = s.store.AppendMessages("c1", okproto.ChannelTypeCommunity, []okstore.Message{
+		newMessage(1, "c1", okproto.ChannelTypeCommunity, "u1", 100),
+		newMessage(2, "c1", okproto.ChannelTypeCommunity, "u1", 100),
+	})
+	assert.NoError(t, err)
+
+	reply := func(rootMessageSeq uint32, messages ...okstore.Message) {
+		threadChannelID := GetThreadChannelID("c1", rootMessageSeq)
+		_, err := s.store.AppendMessages(threadChannelID, okproto.ChannelTypeCommunityTopic, messages)
+		assert.NoError(t, err)
+		s.threadManager.AddReplies(threadChannelID, okproto.ChannelTypeCommunityTopic, messages)
+	}
+	reply(1, newMessage(10, "c1@1", okproto.ChannelTypeCommunityTopic, "u2", 200), newMessage(11, "c1@1", okproto.ChannelTypeCommunityTopic, "u3", 201))
+	reply(2, newMessage(12, "c1@2", okproto.ChannelTypeCommunityTopic, "u2", 300))
+	reply(1, newMessage(13, "c1@1", okproto.ChannelTypeCommunityTopic, "u4", 400))
+	reply(3, newMessage(14, "c1@3", okproto.ChannelTypeCommunityTopic, "u2", 500)) // 被回复的消息不存在，不是子区
+
+	threads, err := s.threadManager.Threads("c1", 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(threads))
+	assert.Equal(t, "c1@1", threads[0].ChannelID)
+	assert.Equal(t, uint32(3), threads[0].ReplyCount)
+	assert.Equal(t, "u4", threads[0].LastReplyUID)
+	assert.Equal(t, int64(13), threads[0].LastReplyMessageID)
+	assert.Equal(t, uint32(3), threads[0].LastReplyMessageSeq)
+	assert.Equal(t, int64(400), threads[0].LastReplyAt)
+	assert.Equal(t, int64(1), threads[0].RootMessage.MessageID)
+	assert.Equal(t, uint32(3), threads[0].RootMessage.ReplyCount)
+	assert.Equal(t, "c1@2", threads[1].ChannelID)
+
+	threads, err = s.threadManager.Threads("c1", 301, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(threads))
+	assert.Equal(t, uint32(1), threads[0].RootMessageSeq)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samlau0508/imserver/pkg/oklog"
+	"github.com/samlau0508/imserver/pkg/oknet/crypto/tls"
+	"go.uber.org/zap"
+)
+
+// newTCPTLSConfig tcp长连接的TLS配置，配置了客户端CA证书则要求客户端提供证书（双向认证）
+func newTCPTLSConfig(certFile, keyFile, clientCAFile string, reloadInterval time.Duration) (*tls.Config, error) {
+	reloader, err := newCertReloader(certFile, keyFile, reloadInterval)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if clientCAFile != "" {
+		caPEM, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("客户端CA证书文件中没有有效的证书！")
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// certReloader 证书热加载，握手时按间隔检查证书文件的修改时间，文件变化后新的连接使用新证书（已建立的连接不受影响）
+type certReloader struct {
+	certFile       string
+	keyFile        string
+	reloadInterval time.Duration // 检查证书文件的间隔 0表示不检查
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time // 证书文件的修改时间
+	lastCheck time.Time // 最后一次检查证书文件的时间
+	oklog.Log
+}
+
+func newCertReloader(certFile, keyFile string, reloadInterval time.Duration) (*certReloader, error) {
+	r := &certReloader{
+		certFile:       certFile,
+		keyFile:        keyFile,
+		reloadInterval: reloadInterval,
+		Log:            oklog.NewOKLog("CertReloader"),
+	}
+	modTime, err := r.fileModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate 作为tls.Config的GetCertificate
+func (r *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if r.reloadInterval > 0 {
+		r.reloadIfNeed()
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func (r *certReloader) reloadIfNeed() {
+	now := time.Now()
+	r.mu.RLock()
+	needCheck := now.Sub(r.lastCheck) >= r.reloadInterval
+	r.mu.RUnlock()
+	if !needCheck {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.lastCheck) < r.reloadInterval { // 其他握手已经检查过了
+		return
+	}
+	r.lastCheck = now
+	modTime, err := r.fileModTime()
+	if err != nil {
+		r.Warn("检查证书文件失败，继续使用原证书！", zap.Error(err))
+		return
+	}
+	if !modTime.After(r.modTime) {
+		return
+	}
+	if err = r.loadLocked(modTime); err != nil { // 证书和私钥可能还没有全部写完，下次检查时再加载
+		r.Warn("加载新证书失败，继续使用原证书！", zap.Error(err))
+		return
+	}
+	r.Info("证书已重新加载", zap.String("certFile", r.certFile))
+}
+
+func (r *certReloader) load(modTime time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastCheck = time.Now()
+	return r.loadLocked(modTime)
+}
+
+func (r *certReloader) loadLocked(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// 证书和私钥文件中较新的修改时间
+func (r *certReloader) fileModTime() (time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/oknet/crypto/tls"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// 生成测试证书 parent为nil时生成自签名的CA证书
+func newTestCert(t *testing.T, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "imserver-test"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parentCert, parentKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0644)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	assert.NoError(t, err)
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	assert.NoError(t, err)
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func certSerial(t *testing.T, cert *tls.Certificate) int64 {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	return leaf.SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	newTestCert(t, 1, nil).write(t, certFile, keyFile)
+
+	reloader, err := newCertReloader(certFile, keyFile, time.Millisecond)
+	assert.NoError(t, err)
+	cert, err := reloader.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), certSerial(t, cert))
+
+	newTestCert(t, 2, nil).write(t, certFile, keyFile)
+	modTime := time.Now().Add(time.Second)
+	assert.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	time.Sleep(time.Millisecond * 2)
+	cert, err = reloader.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), certSerial(t, cert))
+
+	// 新证书无效时继续使用原证书
+	assert.NoError(t, os.WriteFile(keyFile, []byte("invalid"), 0600))
+	modTime = modTime.Add(time.Second)
+	assert.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	time.Sleep(time.Millisecond * 2)
+	cert, err = reloader.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), certSerial(t, cert))
+}
+
+func TestTCPTLSConfigClientCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := newTestCert(t, 1, nil)
+	ca.write(t, caFile, filepath.Join(dir, "ca.key"))
+	newTestCert(t, 2, ca).write(t, certFile, keyFile)
+
+	serverConfig, err := newTCPTLSConfig(certFile, keyFile, caFile, 0)
+	assert.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	handshake := func(clientCerts []tls.Certificate) error {
+		serverErr := make(chan error, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			defer conn.Close()
+			serverErr <- tls.Server(conn, serverConfig).Handshake()
+		}()
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		assert.NoError(t, err)
+		defer conn.Close()
+		client := tls.Client(conn, &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       clientCerts,
+		})
+		_ = client.Handshake()
+		return <-serverErr
+	}
+	// 没有客户端证书
+	assert.Error(t, handshake(nil))
+	// 客户端证书不是CA签发的
+	assert.Error(t, handshake([]tls.Certificate{newTestCert(t, 3, nil).tlsCertificate()}))
+	assert.NoError(t, handshake([]tls.Certificate{newTestCert(t, 4, ca).tlsCertificate()}))
+}
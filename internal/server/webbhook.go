@@ -393,6 +393,12 @@ const (
 	EventMsgOffline = "msg.offline"
 	// EventMsgNotify 消息通知（将所有消息通知到第三方程序）
 	EventMsgNotify = "msg.notify"
+	// EventMsgRevoke 消息撤回
+	EventMsgRevoke = "msg.revoke"
+	// EventMsgEdit 消息编辑
+	EventMsgEdit = "msg.edit"
+	// EventMsgDelete 消息删除
+	EventMsgDelete = "msg.delete"
 	// EventOnlineStatus 用户在线状态
 	EventOnlineStatus = "user.onlinestatus"
 )
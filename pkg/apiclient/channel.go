@@ -0,0 +1,143 @@
+package apiclient
+
+import "net/url"
+
+// ChannelCreateOrUpdate 创建或修改频道（同时设置订阅者）
+func (c *Client) ChannelCreateOrUpdate(req *ChannelCreateReq) error {
+	return c.post("/channel", req, nil)
+}
+
+// ChannelInfoUpdate 更新或添加频道基础信息
+func (c *Client) ChannelInfoUpdate(req *ChannelInfoReq) error {
+	return c.post("/channel/info", req, nil)
+}
+
+// ChannelDelete 删除频道
+func (c *Client) ChannelDelete(channelID string, channelType uint8) error {
+	return c.post("/channel/delete", &ChannelDeleteReq{ChannelID: channelID, ChannelType: channelType}, nil)
+}
+
+// SubscriberAdd 添加订阅者
+func (c *Client) SubscriberAdd(req *SubscriberAddReq) error {
+	return c.post("/channel/subscriber_add", req, nil)
+}
+
+// SubscriberRemove 移除订阅者
+func (c *Client) SubscriberRemove(req *SubscriberRemoveReq) error {
+	return c.post("/channel/subscriber_remove", req, nil)
+}
+
+// BlacklistAdd 添加黑名单
+func (c *Client) BlacklistAdd(req *BlacklistReq) error {
+	return c.post("/channel/blacklist_add", req, nil)
+}
+
+// BlacklistSet 设置黑名单（覆盖原来的黑名单数据）
+func (c *Client) BlacklistSet(req *BlacklistReq) error {
+	return c.post("/channel/blacklist_set", req, nil)
+}
+
+// BlacklistRemove 移除黑名单
+func (c *Client) BlacklistRemove(req *BlacklistReq) error {
+	return c.post("/channel/blacklist_remove", req, nil)
+}
+
+// WhitelistAdd 添加白名单
+func (c *Client) WhitelistAdd(req *WhitelistReq) error {
+	return c.post("/channel/whitelist_add", req, nil)
+}
+
+// WhitelistSet 设置白名单（覆盖原来的白名单数据）
+func (c *Client) WhitelistSet(req *WhitelistReq) error {
+	return c.post("/channel/whitelist_set", req, nil)
+}
+
+// WhitelistRemove 移除白名单
+func (c *Client) WhitelistRemove(req *WhitelistReq) error {
+	return c.post("/channel/whitelist_remove", req, nil)
+}
+
+// Whitelist 获取群频道的白名单
+func (c *Client) Whitelist(channelID string) ([]string, error) {
+	var uids []string
+	if err := c.get("/channel/whitelist", url.Values{"channel_id": {channelID}}, &uids); err != nil {
+		return nil, err
+	}
+	return uids, nil
+}
+
+// ChannelMessageSync 同步频道内的消息
+func (c *Client) ChannelMessageSync(req *ChannelMessageSyncReq) (*SyncMessageResp, error) {
+	resp := &SyncMessageResp{}
+	if err := c.post("/channel/messagesync", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ChannelRetention 获取频道生效的消息保留策略
+func (c *Client) ChannelRetention(channelID string, channelType uint8) (*ChannelRetentionResp, error) {
+	resp := &ChannelRetentionResp{}
+	if err := c.post("/channel/retention", &ChannelRetentionGetReq{ChannelID: channelID, ChannelType: channelType}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ChannelRetentionSet 设置频道的消息保留策略
+func (c *Client) ChannelRetentionSet(req *ChannelRetentionReq) error {
+	return c.post("/channel/retention_set", req, nil)
+}
+
+// ChannelRetentionRemove 移除频道的消息保留策略（使用频道类型或默认的保留策略）
+func (c *Client) ChannelRetentionRemove(channelID string, channelType uint8) error {
+	return c.post("/channel/retention_remove", &ChannelRetentionGetReq{ChannelID: channelID, ChannelType: channelType}, nil)
+}
+
+// ChannelAdminAdd 添加管理员（不受全员禁言和慢速模式限制）
+func (c *Client) ChannelAdminAdd(req *ChannelAdminReq) error {
+	return c.post("/channel/admin_add", req, nil)
+}
+
+// ChannelAdminRemove 移除管理员
+func (c *Client) ChannelAdminRemove(req *ChannelAdminReq) error {
+	return c.post("/channel/admin_remove", req, nil)
+}
+
+// ChannelMuteSet 设置全员禁言
+func (c *Client) ChannelMuteSet(req *ChannelMuteReq) error {
+	return c.post("/channel/mute_set", req, nil)
+}
+
+// ChannelSlowModeSet 设置慢速模式
+func (c *Client) ChannelSlowModeSet(req *ChannelSlowModeReq) error {
+	return c.post("/channel/slowmode_set", req, nil)
+}
+
+// ChannelMemberMuteAdd 禁言成员
+func (c *Client) ChannelMemberMuteAdd(req *ChannelMemberMuteReq) error {
+	return c.post("/channel/member_mute_add", req, nil)
+}
+
+// ChannelMemberMuteRemove 解除成员禁言
+func (c *Client) ChannelMemberMuteRemove(req *ChannelMemberMuteReq) error {
+	return c.post("/channel/member_mute_remove", req, nil)
+}
+
+// ChannelModeration 获取频道的禁言设置
+func (c *Client) ChannelModeration(channelID string, channelType uint8) (*ChannelModerationResp, error) {
+	resp := &ChannelModerationResp{}
+	if err := c.post("/channel/moderation", &ChannelModerationReq{ChannelID: channelID, ChannelType: channelType}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ThreadList 获取社区频道最近活跃的子区
+func (c *Client) ThreadList(req *ThreadListReq) ([]*ThreadResp, error) {
+	var resps []*ThreadResp
+	if err := c.post("/thread/list", req, &resps); err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
@@ -0,0 +1,153 @@
+// Package apiclient IM的http api客户端
+// 请求和返回的数据结构直接使用服务端的定义（见model.go），服务端api变动时调用方编译就会报错。
+package apiclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Options Options
+type Options struct {
+	Token      string        // 调用api的token（managerToken或api密钥），放在header的token里
+	Timeout    time.Duration // 请求超时时间
+	HTTPClient *http.Client  // 自定义的http客户端 为空时按Timeout创建
+}
+
+// NewOptions 创建默认配置
+func NewOptions() *Options {
+	return &Options{
+		Timeout: 10 * time.Second,
+	}
+}
+
+// Option 参数项
+type Option func(*Options) error
+
+// WithToken 调用api的token（managerToken或api密钥）
+func WithToken(token string) Option {
+	return func(opts *Options) error {
+		opts.Token = token
+		return nil
+	}
+}
+
+// WithTimeout 请求超时时间
+func WithTimeout(timeout time.Duration) Option {
+	return func(opts *Options) error {
+		opts.Timeout = timeout
+		return nil
+	}
+}
+
+// WithHTTPClient 自定义的http客户端（比如需要自定义Transport）
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(opts *Options) error {
+		opts.HTTPClient = httpClient
+		return nil
+	}
+}
+
+// Error api返回的错误
+type Error struct {
+	Path       string // 请求的路径
+	StatusCode int    // http状态码
+	Msg        string // 服务端返回的错误信息
+}
+
+func (e *Error) Error() string {
+	if e.Msg != "" {
+		return fmt.Sprintf("请求%s失败！[%d]%s", e.Path, e.StatusCode, e.Msg)
+	}
+	return fmt.Sprintf("请求%s失败！http状态码错误！[%d]", e.Path, e.StatusCode)
+}
+
+// Client http api客户端
+type Client struct {
+	apiURL     string
+	opts       *Options
+	httpClient *http.Client
+}
+
+// New 创建api客户端 apiURL为IM的http api地址 例如：http://127.0.0.1:5001
+func New(apiURL string, opt ...Option) *Client {
+	var opts = NewOptions()
+	for _, op := range opt {
+		if op != nil {
+			if err := op(opts); err != nil {
+				panic(err)
+			}
+		}
+	}
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: opts.Timeout}
+	}
+	return &Client{
+		apiURL:     strings.TrimSuffix(apiURL, "/"),
+		opts:       opts,
+		httpClient: httpClient,
+	}
+}
+
+func (c *Client) get(path string, query url.Values, resp interface{}) error {
+	if len(query) > 0 {
+		path = path + "?" + query.Encode()
+	}
+	return c.request(http.MethodGet, path, nil, resp)
+}
+
+func (c *Client) post(path string, req interface{}, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return c.request(http.MethodPost, path, body, resp)
+}
+
+// resp为空时不解析返回的数据
+func (c *Client) request(method string, path string, body []byte, resp interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequest(method, c.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.opts.Token != "" {
+		request.Header.Set("token", c.opts.Token)
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		apiErr := &Error{Path: path, StatusCode: response.StatusCode}
+		var errResp struct {
+			Msg string `json:"msg"`
+		}
+		if json.Unmarshal(data, &errResp) == nil {
+			apiErr.Msg = errResp.Msg
+		}
+		return apiErr
+	}
+	if resp == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, resp)
+}
@@ -0,0 +1,256 @@
+package apiclient
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samlau0508/imserver/internal/server"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const testManagerToken = "test-manager-token"
+
+// 记录请求过的路由（404的不算）
+type routeRecorder struct {
+	mu     sync.Mutex
+	routes map[string]bool
+}
+
+func (r *routeRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil && resp.StatusCode != http.StatusNotFound {
+		r.mu.Lock()
+		r.routes[req.Method+" "+req.URL.Path] = true
+		r.mu.Unlock()
+	}
+	return resp, err
+}
+
+func newTestServer(t *testing.T) (*server.Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	httpAddr := ln.Addr().String()
+	ln.Close()
+
+	vp := viper.New()
+	vp.Set("rootDir", t.TempDir())
+	vp.Set("addr", "tcp://127.0.0.1:0")
+	vp.Set("wsAddr", "ws://127.0.0.1:0")
+	vp.Set("mqttAddr", "tcp://127.0.0.1:0")
+	vp.Set("httpAddr", httpAddr)
+	vp.Set("managerToken", testManagerToken)
+	vp.Set("monitor.on", false)
+	vp.Set("demo.on", false)
+	opts := server.NewTestOptions()
+	opts.ConfigureWithViper(vp)
+	opts.DataDir = t.TempDir()
+	s := server.NewTestServer(opts)
+	assert.NoError(t, s.Start())
+
+	apiURL := fmt.Sprintf("http://%s", httpAddr)
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", httpAddr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, time.Second*5, time.Millisecond*20)
+	return s, apiURL
+}
+
+func TestClient(t *testing.T) {
+	s, apiURL := newTestServer(t)
+	defer s.Stop()
+
+	recorder := &routeRecorder{routes: map[string]bool{}}
+	c := New(apiURL, WithToken(testManagerToken), WithHTTPClient(&http.Client{Transport: recorder, Timeout: time.Second * 5}))
+
+	// 没有token
+	err := New(apiURL).SystemUIDsAdd([]string{"u1"})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, err.(*Error).StatusCode)
+
+	// ---------- 用户 ----------
+	assert.NoError(t, c.UpdateToken(&UpdateTokenReq{UID: "u1", Token: "token1", DeviceFlag: okproto.APP, DeviceLevel: okproto.DeviceLevelMaster}))
+	assert.NoError(t, c.DeviceQuit(&DeviceQuitReq{UID: "u1", DeviceFlag: -1}))
+	onlineStatus, err := c.OnlineStatus([]string{"u1"})
+	assert.NoError(t, err)
+	assert.Len(t, onlineStatus, 0)
+	presences, err := c.Presence([]string{"u1"})
+	assert.NoError(t, err)
+	assert.Len(t, presences, 1)
+	assert.NoError(t, c.SystemUIDsAdd([]string{"sys"}))
+	assert.NoError(t, c.SystemUIDsRemove([]string{"sys"}))
+	assert.NoError(t, c.UserRateLimitSet(&UserRateLimitReq{UID: "u1", RateLimit: RateLimit{Rate: 10, Burst: 5}}))
+	rateLimit, err := c.UserRateLimit("u1")
+	assert.NoError(t, err)
+	assert.True(t, rateLimit.Custom)
+	assert.Equal(t, 5, rateLimit.Burst)
+	assert.NoError(t, c.UserRateLimitRemove("u1"))
+
+	// ---------- 频道 ----------
+	group := okproto.ChannelTypeGroup
+	assert.NoError(t, c.ChannelCreateOrUpdate(&ChannelCreateReq{
+		ChannelInfoReq: ChannelInfoReq{ChannelID: "g1", ChannelType: group},
+		Subscribers:    []string{"u1", "u2"},
+	}))
+	assert.NoError(t, c.ChannelInfoUpdate(&ChannelInfoReq{ChannelID: "g1", ChannelType: group}))
+	assert.NoError(t, c.SubscriberAdd(&SubscriberAddReq{ChannelID: "g1", ChannelType: group, Subscribers: []string{"u3"}}))
+	assert.NoError(t, c.SubscriberRemove(&SubscriberRemoveReq{ChannelID: "g1", ChannelType: group, Subscribers: []string{"u3"}}))
+	assert.NoError(t, c.BlacklistAdd(&BlacklistReq{ChannelID: "g1", ChannelType: group, UIDs: []string{"u4"}}))
+	assert.NoError(t, c.BlacklistSet(&BlacklistReq{ChannelID: "g1", ChannelType: group, UIDs: []string{"u5"}}))
+	assert.NoError(t, c.BlacklistRemove(&BlacklistReq{ChannelID: "g1", ChannelType: group, UIDs: []string{"u5"}}))
+	assert.NoError(t, c.WhitelistAdd(&WhitelistReq{ChannelID: "g1", ChannelType: group, UIDs: []string{"u4"}}))
+	assert.NoError(t, c.WhitelistSet(&WhitelistReq{ChannelID: "g1", ChannelType: group, UIDs: []string{"u1", "u2"}}))
+	whitelist, err := c.Whitelist("g1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"u1", "u2"}, whitelist)
+	assert.NoError(t, c.WhitelistRemove(&WhitelistReq{ChannelID: "g1", ChannelType: group, UIDs: []string{"u1", "u2"}}))
+
+	assert.NoError(t, c.ChannelRetentionSet(&ChannelRetentionReq{ChannelID: "g1", ChannelType: group, RetentionPolicy: RetentionPolicy{MaxMessages: 100}}))
+	retention, err := c.ChannelRetention("g1", group)
+	assert.NoError(t, err)
+	assert.True(t, retention.Custom)
+	assert.Equal(t, uint32(100), retention.MaxMessages)
+	assert.NoError(t, c.ChannelRetentionRemove("g1", group))
+
+	moderationReq := ChannelModerationReq{ChannelID: "g1", ChannelType: group}
+	assert.NoError(t, c.ChannelAdminAdd(&ChannelAdminReq{ChannelModerationReq: moderationReq, UIDs: []string{"u1"}}))
+	assert.NoError(t, c.ChannelMuteSet(&ChannelMuteReq{ChannelModerationReq: moderationReq, Mute: 1}))
+	assert.NoError(t, c.ChannelSlowModeSet(&ChannelSlowModeReq{ChannelModerationReq: moderationReq, Interval: 10}))
+	assert.NoError(t, c.ChannelMemberMuteAdd(&ChannelMemberMuteReq{ChannelModerationReq: moderationReq, UIDs: []string{"u2"}}))
+	moderation, err := c.ChannelModeration("g1", group)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, moderation.Mute)
+	assert.Equal(t, uint32(10), moderation.SlowMode)
+	assert.NoError(t, c.ChannelMemberMuteRemove(&ChannelMemberMuteReq{ChannelModerationReq: moderationReq, UIDs: []string{"u2"}}))
+	assert.NoError(t, c.ChannelMuteSet(&ChannelMuteReq{ChannelModerationReq: moderationReq}))
+	assert.NoError(t, c.ChannelSlowModeSet(&ChannelSlowModeReq{ChannelModerationReq: moderationReq}))
+	assert.NoError(t, c.ChannelAdminRemove(&ChannelAdminReq{ChannelModerationReq: moderationReq, UIDs: []string{"u1"}}))
+
+	// 子区只支持社区频道
+	_, err = c.ThreadList(&ThreadListReq{ChannelID: "g1", ChannelType: group})
+	assert.Error(t, err)
+
+	// ---------- 消息 ----------
+	sendResp, err := c.MessageSend(&MessageSendReq{FromUID: "u1", ChannelID: "g1", ChannelType: group, Payload: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), sendResp.MessageSeq)
+	assert.NotEmpty(t, sendResp.ClientMsgNo)
+
+	syncResp, err := c.ChannelMessageSync(&ChannelMessageSyncReq{LoginUID: "u1", ChannelID: "g1", ChannelType: group, Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, syncResp.Messages, 1)
+	assert.Equal(t, []byte("hello"), syncResp.Messages[0].Payload)
+
+	batchResp, err := c.MessageSendBatch(&MessageSendBatchReq{FromUID: "u1", Subscribers: []string{"u2"}, Payload: []byte("batch")})
+	assert.NoError(t, err)
+	assert.Len(t, batchResp.FailUIDs, 0)
+
+	_, err = c.MessageSync(&SyncReq{UID: "u2", Limit: 10})
+	assert.NoError(t, err)
+	assert.NoError(t, c.MessageSyncack(&SyncackReq{UID: "u2", LastMessageSeq: 1}))
+
+	assert.NoError(t, c.MessageEdit(&MessageEditReq{UID: "u1", ChannelID: "g1", ChannelType: group, MessageSeq: 1, Payload: []byte("hello2")}))
+	edits, err := c.MessageEditHistory(&MessageEditHistoryReq{UID: "u1", ChannelID: "g1", ChannelType: group, MessageSeq: 1})
+	assert.NoError(t, err)
+	assert.Len(t, edits, 1)
+
+	assert.NoError(t, c.ReactionAdd(&ReactionReq{UID: "u2", ChannelID: "g1", ChannelType: group, MessageID: sendResp.MessageID, MessageSeq: 1, Emoji: "👍"}))
+	reactions, err := c.ReactionSync(&ReactionSyncReq{LoginUID: "u1", ChannelID: "g1", ChannelType: group, Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, reactions.Reactions, 1)
+	assert.NoError(t, c.ReactionRemove(&ReactionReq{UID: "u2", ChannelID: "g1", ChannelType: group, MessageID: sendResp.MessageID, MessageSeq: 1, Emoji: "👍"}))
+
+	assert.NoError(t, c.MessagePin(&MessagePinReq{UID: "u1", ChannelID: "g1", ChannelType: group, MessageSeq: 1}))
+	pins, err := c.MessagePins(&MessagePinSyncReq{LoginUID: "u1", ChannelID: "g1", ChannelType: group})
+	assert.NoError(t, err)
+	assert.Len(t, pins.Pins, 1)
+	assert.NoError(t, c.MessageUnpin(&MessagePinReq{UID: "u1", ChannelID: "g1", ChannelType: group, MessageSeq: 1}))
+
+	assert.NoError(t, c.MessageReceiptRead(&MessageReadReq{UID: "u2", ChannelID: "g1", ChannelType: group, MessageSeq: 1}))
+	_, err = c.MessageReceipt(&MessageReceiptReq{UID: "u1", ChannelID: "g1", ChannelType: group, MessageSeqs: []uint32{1}})
+	assert.NoError(t, err)
+	_, err = c.MessageReceiptReaders(&MessageReadersReq{UID: "u1", ChannelID: "g1", ChannelType: group, MessageSeq: 1})
+	assert.NoError(t, err)
+
+	// 搜索未开启
+	_, err = c.MessageSearch(&MessageSearchReq{UID: "u1", Keyword: "hello"})
+	assert.Error(t, err)
+	_, err = c.MessageSearchChannel(&MessageSearchReq{UID: "u1", ChannelID: "g1", ChannelType: group, Keyword: "hello"})
+	assert.Error(t, err)
+
+	streamNo, err := c.StreamMessageStart(&MessageStreamStartReq{FromUID: "u1", ChannelID: "g1", ChannelType: group, Payload: []byte("stream")})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, streamNo)
+	assert.NoError(t, c.StreamMessageEnd(&MessageStreamEndReq{StreamNo: streamNo, ChannelID: "g1", ChannelType: group}))
+
+	assert.NoError(t, c.MessageRevoke(&MessageRevokeReq{UID: "u1", ChannelID: "g1", ChannelType: group, MessageSeq: 1}))
+	assert.NoError(t, c.MessageDelete(&MessageDeleteReq{UID: "u1", ChannelID: "g1", ChannelType: group, StartMessageSeq: 1}))
+	markers, err := c.MessageDeleteMarkers(&MessageDeleteMarkersReq{UID: "u1", ChannelID: "g1", ChannelType: group})
+	assert.NoError(t, err)
+	assert.Len(t, markers, 1)
+
+	// ---------- 最近会话 ----------
+	_, err = c.Conversations("u2")
+	assert.NoError(t, err)
+	assert.NoError(t, c.ConversationSetUnread(&SetConversationUnreadReq{UID: "u2", ChannelID: "g1", ChannelType: group, Unread: 1}))
+	assert.NoError(t, c.ConversationClearUnread(&ClearConversationUnreadReq{UID: "u2", ChannelID: "g1", ChannelType: group}))
+	_, err = c.ConversationSync(&SyncUserConversationReq{UID: "u2", MsgCount: 1})
+	assert.NoError(t, err)
+	recents, err := c.ConversationSyncMessages(&SyncRecentMessagesReq{UID: "u2", Channels: []*ChannelRecentMessageReq{{ChannelID: "g1", ChannelType: group}}, MsgCount: 1})
+	assert.NoError(t, err)
+	assert.Len(t, recents, 1)
+	assert.NoError(t, c.ConversationDelete(&ConversationDeleteReq{UID: "u2", ChannelID: "g1", ChannelType: group}))
+
+	assert.NoError(t, c.ChannelDelete("g1", group))
+
+	// ---------- 系统、路由和监控 ----------
+	assert.NoError(t, c.IPBlacklistAdd([]string{"10.0.0.1"}))
+	ips, err := c.IPBlacklist()
+	assert.NoError(t, err)
+	assert.Contains(t, ips, "10.0.0.1")
+	assert.NoError(t, c.IPBlacklistRemove([]string{"10.0.0.1"}))
+	_, err = c.StoreCompact(&StoreCompactReq{})
+	assert.NoError(t, err)
+
+	apiKey, err := c.APIKeyCreate(&APIKeyCreateReq{Name: "monitor", Scopes: []string{"monitor"}})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, apiKey.Key)
+	apiKeys, err := c.APIKeys()
+	assert.NoError(t, err)
+	assert.Len(t, apiKeys, 1)
+	// api密钥没有权限
+	err = New(apiURL, WithToken(apiKey.Key)).SystemUIDsAdd([]string{"u1"})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, err.(*Error).StatusCode)
+	assert.NoError(t, c.APIKeyRemove(apiKey.ID))
+
+	_, err = c.Route("u1")
+	assert.NoError(t, err)
+	addrs, err := c.RouteBatch([]string{"u1", "u2"})
+	assert.NoError(t, err)
+	assert.Len(t, addrs, 1)
+	_, err = c.ClusterNodes()
+	assert.NoError(t, err)
+	_, err = c.Connz(&ConnzReq{Sort: ByID, Limit: 10})
+	assert.NoError(t, err)
+	_, err = c.Varz(true, 10)
+	assert.NoError(t, err)
+
+	// 除了节点之间通讯的/cluster/的api，服务端注册的路由都需要有对应的方法
+	for _, route := range s.APIRoutes() {
+		if strings.HasPrefix(route.Path, "/cluster/") && route.Path != "/cluster/nodes" {
+			continue
+		}
+		assert.True(t, recorder.routes[route.Method+" "+route.Path], "没有调用的路由：%s %s", route.Method, route.Path)
+	}
+}
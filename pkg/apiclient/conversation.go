@@ -0,0 +1,45 @@
+package apiclient
+
+import "net/url"
+
+// Conversations 获取用户的最近会话列表
+func (c *Client) Conversations(uid string) ([]*ConversationResp, error) {
+	var resps []*ConversationResp
+	if err := c.get("/conversations", url.Values{"uid": {uid}}, &resps); err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
+
+// ConversationClearUnread 清空会话未读数量
+func (c *Client) ConversationClearUnread(req *ClearConversationUnreadReq) error {
+	return c.post("/conversations/clearUnread", req, nil)
+}
+
+// ConversationSetUnread 设置会话未读数量
+func (c *Client) ConversationSetUnread(req *SetConversationUnreadReq) error {
+	return c.post("/conversations/setUnread", req, nil)
+}
+
+// ConversationDelete 删除会话
+func (c *Client) ConversationDelete(req *ConversationDeleteReq) error {
+	return c.post("/conversations/delete", req, nil)
+}
+
+// ConversationSync 同步会话（version之后有变化的会话和每个会话的最近消息）
+func (c *Client) ConversationSync(req *SyncUserConversationReq) ([]*SyncUserConversationResp, error) {
+	var resps []*SyncUserConversationResp
+	if err := c.post("/conversation/sync", req, &resps); err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
+
+// ConversationSyncMessages 同步会话的最近消息
+func (c *Client) ConversationSyncMessages(req *SyncRecentMessagesReq) ([]*ChannelRecentMessage, error) {
+	var resps []*ChannelRecentMessage
+	if err := c.post("/conversation/syncMessages", req, &resps); err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
@@ -0,0 +1,161 @@
+package apiclient
+
+// MessageSend 发送消息
+func (c *Client) MessageSend(req *MessageSendReq) (*MessageSendResp, error) {
+	var result struct {
+		Data *MessageSendResp `json:"data"`
+	}
+	if err := c.post("/message/send", req, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// MessageSendBatch 批量给用户发送消息（按用户逐个发送，返回发送失败的用户）
+func (c *Client) MessageSendBatch(req *MessageSendBatchReq) (*MessageSendBatchResp, error) {
+	resp := &MessageSendBatchResp{}
+	if err := c.post("/message/sendbatch", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// MessageSync 同步用户的消息队列(写模式)
+func (c *Client) MessageSync(req *SyncReq) ([]*MessageResp, error) {
+	var resps []*MessageResp
+	if err := c.post("/message/sync", req, &resps); err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
+
+// MessageSyncack 消息同步回执(写模式)
+func (c *Client) MessageSyncack(req *SyncackReq) error {
+	return c.post("/message/syncack", req, nil)
+}
+
+// MessageRevoke 撤回消息
+func (c *Client) MessageRevoke(req *MessageRevokeReq) error {
+	return c.post("/message/revoke", req, nil)
+}
+
+// MessageEdit 编辑消息
+func (c *Client) MessageEdit(req *MessageEditReq) error {
+	return c.post("/message/edit", req, nil)
+}
+
+// MessageEditHistory 消息编辑记录
+func (c *Client) MessageEditHistory(req *MessageEditHistoryReq) ([]*MessageEditResp, error) {
+	var resps []*MessageEditResp
+	if err := c.post("/message/edithistory", req, &resps); err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
+
+// MessageSearch 搜索用户所有最近会话频道的消息（req.ChannelID需为空，指定频道使用MessageSearchChannel）
+func (c *Client) MessageSearch(req *MessageSearchReq) ([]*MessageSearchResult, error) {
+	var results []*MessageSearchResult
+	if err := c.post("/message/search", req, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// MessageSearchChannel 搜索指定频道的消息
+func (c *Client) MessageSearchChannel(req *MessageSearchReq) (*MessageSearchResult, error) {
+	result := &MessageSearchResult{}
+	if err := c.post("/message/search", req, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// MessageDelete 删除消息（对所有人或只对自己）
+func (c *Client) MessageDelete(req *MessageDeleteReq) error {
+	return c.post("/message/delete", req, nil)
+}
+
+// MessageDeleteMarkers 消息删除标记
+func (c *Client) MessageDeleteMarkers(req *MessageDeleteMarkersReq) ([]*MessageDeleteMarker, error) {
+	var markers []*MessageDeleteMarker
+	if err := c.post("/message/delete/markers", req, &markers); err != nil {
+		return nil, err
+	}
+	return markers, nil
+}
+
+// MessageReceipt 消息回执（已读未读数量）
+func (c *Client) MessageReceipt(req *MessageReceiptReq) ([]*MessageReceiptResp, error) {
+	var resps []*MessageReceiptResp
+	if err := c.post("/message/receipt", req, &resps); err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
+
+// MessageReceiptReaders 消息已读用户列表
+func (c *Client) MessageReceiptReaders(req *MessageReadersReq) ([]*MessageReaderResp, error) {
+	var resps []*MessageReaderResp
+	if err := c.post("/message/receipt/readers", req, &resps); err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
+
+// MessageReceiptRead 上报消息已读
+func (c *Client) MessageReceiptRead(req *MessageReadReq) error {
+	return c.post("/message/receipt/read", req, nil)
+}
+
+// StreamMessageStart 流消息开始 返回消息流编号
+func (c *Client) StreamMessageStart(req *MessageStreamStartReq) (string, error) {
+	resp := &MessageStreamStartResp{}
+	if err := c.post("/streammessage/start", req, resp); err != nil {
+		return "", err
+	}
+	return resp.StreamNo, nil
+}
+
+// StreamMessageEnd 流消息结束
+func (c *Client) StreamMessageEnd(req *MessageStreamEndReq) error {
+	return c.post("/streammessage/end", req, nil)
+}
+
+// ReactionAdd 添加消息回应
+func (c *Client) ReactionAdd(req *ReactionReq) error {
+	return c.post("/reaction/add", req, nil)
+}
+
+// ReactionRemove 移除消息回应
+func (c *Client) ReactionRemove(req *ReactionReq) error {
+	return c.post("/reaction/remove", req, nil)
+}
+
+// ReactionSync 增量同步频道的消息回应
+func (c *Client) ReactionSync(req *ReactionSyncReq) (*ReactionSyncResp, error) {
+	resp := &ReactionSyncResp{}
+	if err := c.post("/reaction/sync", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// MessagePin 置顶消息
+func (c *Client) MessagePin(req *MessagePinReq) error {
+	return c.post("/message/pin", req, nil)
+}
+
+// MessageUnpin 取消置顶消息
+func (c *Client) MessageUnpin(req *MessagePinReq) error {
+	return c.post("/message/unpin", req, nil)
+}
+
+// MessagePins 同步频道的置顶消息
+func (c *Client) MessagePins(req *MessagePinSyncReq) (*MessagePinSyncResp, error) {
+	resp := &MessagePinSyncResp{}
+	if err := c.post("/message/pins", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,137 @@
+package apiclient
+
+import (
+	"github.com/samlau0508/imserver/internal/server"
+	"github.com/samlau0508/imserver/pkg/okstore"
+)
+
+// 请求和返回的数据结构和服务端共用（internal包外部无法直接引用，这里统一导出别名）
+
+// 用户
+type (
+	UpdateTokenReq    = server.UpdateTokenReq
+	DeviceQuitReq     = server.DeviceQuitReq
+	OnlinestatusResp  = server.OnlinestatusResp
+	PresenceResp      = server.PresenceResp
+	SystemUIDsReq     = server.SystemUIDsReq
+	UserReq           = server.UserReq
+	UserRateLimitReq  = server.UserRateLimitReq
+	UserRateLimitResp = server.UserRateLimitResp
+	RateLimit         = okstore.RateLimit
+)
+
+// 频道
+type (
+	ChannelCreateReq       = server.ChannelCreateReq
+	ChannelInfoReq         = server.ChannelInfoReq
+	ChannelDeleteReq       = server.ChannelDeleteReq
+	SubscriberAddReq       = server.SubscriberAddReq
+	SubscriberRemoveReq    = server.SubscriberRemoveReq
+	BlacklistReq           = server.BlacklistReq
+	WhitelistReq           = server.WhitelistReq
+	ChannelMessageSyncReq  = server.ChannelMessageSyncReq
+	SyncMessageResp        = server.SyncMessageResp
+	PullMode               = server.PullMode
+	ChannelRetentionGetReq = server.ChannelRetentionGetReq
+	ChannelRetentionReq    = server.ChannelRetentionReq
+	ChannelRetentionResp   = server.ChannelRetentionResp
+	RetentionPolicy        = okstore.RetentionPolicy
+	ChannelModerationReq   = server.ChannelModerationReq
+	ChannelModerationResp  = server.ChannelModerationResp
+	ChannelAdminReq        = server.ChannelAdminReq
+	ChannelMuteReq         = server.ChannelMuteReq
+	ChannelSlowModeReq     = server.ChannelSlowModeReq
+	ChannelMemberMuteReq   = server.ChannelMemberMuteReq
+	ThreadListReq          = server.ThreadListReq
+	ThreadResp             = server.ThreadResp
+)
+
+const (
+	PullModeDown = server.PullModeDown // 向下拉取
+	PullModeUp   = server.PullModeUp   // 向上拉取
+)
+
+// 最近会话
+type (
+	ConversationResp           = server.ConversationResp
+	ClearConversationUnreadReq = server.ClearConversationUnreadReq
+	SetConversationUnreadReq   = server.SetConversationUnreadReq
+	ConversationDeleteReq      = server.ConversationDeleteReq
+	SyncUserConversationReq    = server.SyncUserConversationReq
+	SyncUserConversationResp   = server.SyncUserConversationResp
+	SyncRecentMessagesReq      = server.SyncRecentMessagesReq
+	ChannelRecentMessageReq    = server.ChannelRecentMessageReq
+	ChannelRecentMessage       = server.ChannelRecentMessage
+)
+
+// 消息
+type (
+	MessageHeader           = server.MessageHeader
+	MessageResp             = server.MessageResp
+	MessageSendReq          = server.MessageSendReq
+	MessageSendResp         = server.MessageSendResp
+	MessageSendBatchReq     = server.MessageSendBatchReq
+	MessageSendBatchResp    = server.MessageSendBatchResp
+	SyncReq                 = server.SyncReq
+	SyncackReq              = server.SyncackReq
+	MessageRevokeReq        = server.MessageRevokeReq
+	MessageEditReq          = server.MessageEditReq
+	MessageEditHistoryReq   = server.MessageEditHistoryReq
+	MessageEditResp         = server.MessageEditResp
+	MessageSearchReq        = server.MessageSearchReq
+	MessageSearchResult     = server.MessageSearchResult
+	MessageDeleteReq        = server.MessageDeleteReq
+	MessageDeleteMarkersReq = server.MessageDeleteMarkersReq
+	MessageDeleteMarker     = okstore.MessageDeleteMarker
+	MessageReceiptReq       = server.MessageReceiptReq
+	MessageReceiptResp      = server.MessageReceiptResp
+	MessageReadersReq       = server.MessageReadersReq
+	MessageReaderResp       = server.MessageReaderResp
+	MessageReadReq          = server.MessageReadReq
+	MessageStreamStartReq   = server.MessageStreamStartReq
+	MessageStreamStartResp  = server.MessageStreamStartResp
+	MessageStreamEndReq     = server.MessageStreamEndReq
+	ReactionReq             = server.ReactionReq
+	ReactionSyncReq         = server.ReactionSyncReq
+	ReactionSyncResp        = server.ReactionSyncResp
+	ReactionResp            = server.ReactionResp
+	MessagePinReq           = server.MessagePinReq
+	MessagePinSyncReq       = server.MessagePinSyncReq
+	MessagePinSyncResp      = server.MessagePinSyncResp
+	MessagePinResp          = server.MessagePinResp
+)
+
+// 系统、路由和监控
+type (
+	IPBlacklistReq  = server.IPBlacklistReq
+	StoreCompactReq = server.StoreCompactReq
+	CompactResult   = okstore.CompactResult
+	APIKeyCreateReq = server.APIKeyCreateReq
+	APIKeyRemoveReq = server.APIKeyRemoveReq
+	APIKeyResp      = server.APIKeyResp
+	IMAddrResp      = server.IMAddrResp
+	UserAddrResp    = server.UserAddrResp
+	ClusterNodeResp = server.ClusterNodeResp
+	Connz           = server.Connz
+	ConnInfo        = server.ConnInfo
+	Varz            = server.Varz
+	SortOpt         = server.SortOpt
+)
+
+// 连接排序方式
+const (
+	ByID               = server.ByID               // 通过连接id排序
+	ByIDDesc           = server.ByIDDesc           // 通过连接id排序
+	ByInMsg            = server.ByInMsg            // 通过收到消息排序
+	ByInMsgDesc        = server.ByInMsgDesc        // 通过收到消息排序
+	ByOutMsg           = server.ByOutMsg           // 通过发送消息排序
+	ByOutMsgDesc       = server.ByOutMsgDesc       // 通过发送消息排序
+	ByInBytes          = server.ByInBytes          // 通过收到字节数排序
+	ByInBytesDesc      = server.ByInBytesDesc      // 通过收到字节数排序
+	ByOutBytes         = server.ByOutBytes         // 通过发送字节数排序
+	ByOutBytesDesc     = server.ByOutBytesDesc     // 通过发送字节数排序
+	ByPendingBytes     = server.ByPendingBytes     // 通过等待发送字节数排序
+	ByPendingBytesDesc = server.ByPendingBytesDesc // 通过等待发送字节数排序
+	ByUptime           = server.ByUptime           // 通过启动时间排序
+	ByUptimeDesc       = server.ByUptimeDesc       // 通过启动时间排序
+)
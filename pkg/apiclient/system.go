@@ -0,0 +1,131 @@
+package apiclient
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// IPBlacklistAdd 添加ip黑名单
+func (c *Client) IPBlacklistAdd(ips []string) error {
+	return c.post("/system/ip/blacklist_add", &IPBlacklistReq{IPs: ips}, nil)
+}
+
+// IPBlacklistRemove 移除ip黑名单
+func (c *Client) IPBlacklistRemove(ips []string) error {
+	return c.post("/system/ip/blacklist_remove", &IPBlacklistReq{IPs: ips}, nil)
+}
+
+// IPBlacklist 获取ip黑名单列表
+func (c *Client) IPBlacklist() ([]string, error) {
+	var ips []string
+	if err := c.get("/system/ip/blacklist", nil, &ips); err != nil {
+		return nil, err
+	}
+	return ips, nil
+}
+
+// StoreCompact 按保留策略压缩消息（不传channel_id则压缩当前节点的所有频道）
+func (c *Client) StoreCompact(req *StoreCompactReq) (*CompactResult, error) {
+	resp := &CompactResult{}
+	if err := c.post("/system/store/compact", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// APIKeyCreate 创建api密钥（密钥明文只在创建时返回）
+func (c *Client) APIKeyCreate(req *APIKeyCreateReq) (*APIKeyResp, error) {
+	resp := &APIKeyResp{}
+	if err := c.post("/system/apikey_create", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// APIKeyRemove 移除api密钥
+func (c *Client) APIKeyRemove(id string) error {
+	return c.post("/system/apikey_remove", &APIKeyRemoveReq{ID: id}, nil)
+}
+
+// APIKeys 获取api密钥列表
+func (c *Client) APIKeys() ([]*APIKeyResp, error) {
+	var resps []*APIKeyResp
+	if err := c.get("/system/apikeys", nil, &resps); err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
+
+// Route 获取用户所在节点的连接地址
+func (c *Client) Route(uid string) (*IMAddrResp, error) {
+	resp := &IMAddrResp{}
+	if err := c.get("/route", url.Values{"uid": {uid}}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// RouteBatch 批量获取用户所在节点的连接地址（按节点分组）
+func (c *Client) RouteBatch(uids []string) ([]*UserAddrResp, error) {
+	var resps []*UserAddrResp
+	if err := c.post("/route/batch", uids, &resps); err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
+
+// ClusterNodes 获取集群节点
+// 其他/cluster/开头的api为节点之间通讯使用，不提供给业务调用
+func (c *Client) ClusterNodes() ([]*ClusterNodeResp, error) {
+	var resps []*ClusterNodeResp
+	if err := c.get("/cluster/nodes", nil, &resps); err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
+
+// ConnzReq 获取连接信息的参数
+type ConnzReq struct {
+	Sort   SortOpt // 排序方式
+	Offset int     // 偏移量
+	Limit  int     // 数量限制
+	UID    string  // 不为空则只获取此用户的连接
+}
+
+// Connz 获取连接信息
+func (c *Client) Connz(req *ConnzReq) (*Connz, error) {
+	query := url.Values{}
+	if req.Sort != "" {
+		query.Set("sort", string(req.Sort))
+	}
+	if req.Offset > 0 {
+		query.Set("offset", strconv.Itoa(req.Offset))
+	}
+	if req.Limit > 0 {
+		query.Set("limit", strconv.Itoa(req.Limit))
+	}
+	if req.UID != "" {
+		query.Set("uid", req.UID)
+	}
+	resp := &Connz{}
+	if err := c.get("/connz", query, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Varz 获取系统变量 withConns为true时同时返回收消息最多的connLimit个连接
+func (c *Client) Varz(withConns bool, connLimit int) (*Varz, error) {
+	query := url.Values{}
+	if withConns {
+		query.Set("show", "conn")
+	}
+	if connLimit > 0 {
+		query.Set("conn_limit", strconv.Itoa(connLimit))
+	}
+	resp := &Varz{}
+	if err := c.get("/varz", query, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,58 @@
+package apiclient
+
+// UpdateToken 更新用户token
+func (c *Client) UpdateToken(req *UpdateTokenReq) error {
+	return c.post("/user/token", req, nil)
+}
+
+// DeviceQuit 强制设备退出 deviceFlag为-1时退出用户所有的设备
+func (c *Client) DeviceQuit(req *DeviceQuitReq) error {
+	return c.post("/user/device_quit", req, nil)
+}
+
+// OnlineStatus 获取用户在线状态（只返回在线的设备）
+func (c *Client) OnlineStatus(uids []string) ([]*OnlinestatusResp, error) {
+	var resps []*OnlinestatusResp
+	if err := c.post("/user/onlinestatus", uids, &resps); err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
+
+// Presence 获取用户在线的设备类型和最后在线时间
+func (c *Client) Presence(uids []string) ([]*PresenceResp, error) {
+	var resps []*PresenceResp
+	if err := c.post("/user/presence", uids, &resps); err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
+
+// SystemUIDsAdd 添加系统uid
+func (c *Client) SystemUIDsAdd(uids []string) error {
+	return c.post("/user/systemuids_add", &SystemUIDsReq{UIDs: uids}, nil)
+}
+
+// SystemUIDsRemove 移除系统uid
+func (c *Client) SystemUIDsRemove(uids []string) error {
+	return c.post("/user/systemuids_remove", &SystemUIDsReq{UIDs: uids}, nil)
+}
+
+// UserRateLimit 获取用户生效的发消息限流
+func (c *Client) UserRateLimit(uid string) (*UserRateLimitResp, error) {
+	resp := &UserRateLimitResp{}
+	if err := c.post("/user/ratelimit", &UserReq{UID: uid}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// UserRateLimitSet 设置用户单独的发消息限流
+func (c *Client) UserRateLimitSet(req *UserRateLimitReq) error {
+	return c.post("/user/ratelimit_set", req, nil)
+}
+
+// UserRateLimitRemove 移除用户单独的发消息限流（使用全局配置的限流）
+func (c *Client) UserRateLimitRemove(uid string) error {
+	return c.post("/user/ratelimit_remove", &UserReq{UID: uid}, nil)
+}
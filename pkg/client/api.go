@@ -0,0 +1,138 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/samlau0508/imserver/pkg/network"
+	"github.com/samlau0508/imserver/pkg/okutil"
+)
+
+// PullMode 拉取消息的模式
+type PullMode int
+
+const (
+	PullModeDown PullMode = iota // 向下拉取（拉取更早的消息）
+	PullModeUp                   // 向上拉取（拉取更新的消息）
+)
+
+// Message 通过http api同步的消息（消息内容未加密）
+type Message struct {
+	Header struct {
+		NoPersist int `json:"no_persist"` // 是否不持久化
+		RedDot    int `json:"red_dot"`    // 是否显示红点
+		SyncOnce  int `json:"sync_once"`  // 此消息只被同步或被消费一次
+	} `json:"header"`
+	Setting     uint8  `json:"setting"`       // 设置
+	MessageID   int64  `json:"message_id"`    // 服务端的消息ID(全局唯一)
+	ClientMsgNo string `json:"client_msg_no"` // 客户端消息唯一编号
+	MessageSeq  uint32 `json:"message_seq"`   // 消息序列号
+	FromUID     string `json:"from_uid"`      // 发送者UID
+	ChannelID   string `json:"channel_id"`    // 频道ID
+	ChannelType uint8  `json:"channel_type"`  // 频道类型
+	Expire      uint32 `json:"expire"`        // 消息过期时间
+	Timestamp   int32  `json:"timestamp"`     // 服务器消息时间戳(10位，到秒)
+	Payload     []byte `json:"payload"`       // 消息内容
+	Revoke      int    `json:"revoke"`        // 是否已撤回 1.是 0.否
+	EditVersion uint32 `json:"edit_version"`  // 编辑版本 0.未编辑
+}
+
+// Conversation 最近会话
+type Conversation struct {
+	ChannelID       string     `json:"channel_id"`         // 频道ID
+	ChannelType     uint8      `json:"channel_type"`       // 频道类型
+	Unread          int        `json:"unread"`             // 未读消息数量
+	Timestamp       int64      `json:"timestamp"`          // 最后一次会话时间
+	LastMsgSeq      uint32     `json:"last_msg_seq"`       // 最后一条消息seq
+	LastClientMsgNo string     `json:"last_client_msg_no"` // 最后一条消息的客户端编号
+	OffsetMsgSeq    int64      `json:"offset_msg_seq"`     // 偏移位的消息seq
+	Version         int64      `json:"version"`            // 数据版本
+	Recents         []*Message `json:"recents"`            // 最近的消息
+}
+
+// SyncConversationsReq 同步会话的参数
+type SyncConversationsReq struct {
+	Version     int64              // 客户端会话的最大版本号 0表示全量同步
+	LastMsgSeqs map[Channel]uint32 // 客户端会话的最后一条消息序列号，最近消息只返回之后的
+	MsgCount    int                // 每个会话返回的最近消息数量
+}
+
+// SyncChannelMessagesReq 同步频道消息的参数
+type SyncChannelMessagesReq struct {
+	StartMessageSeq uint32   // 开始消息序列号（结果包含）
+	EndMessageSeq   uint32   // 结束消息序列号（结果不包含） 0表示不限制
+	Limit           int      // 数量限制
+	PullMode        PullMode // 拉取模式
+}
+
+// ChannelMessages 同步到的频道消息
+type ChannelMessages struct {
+	StartMessageSeq uint32     `json:"start_message_seq"` // 开始序列号
+	EndMessageSeq   uint32     `json:"end_message_seq"`   // 结束序列号
+	More            int        `json:"more"`              // 是否还有更多 1.是 0.否
+	Messages        []*Message `json:"messages"`          // 消息数据
+}
+
+// SyncConversations 通过http api同步当前用户的会话（需要WithAPI）
+func (c *Client) SyncConversations(req *SyncConversationsReq) ([]*Conversation, error) {
+	lastMsgSeqs := make([]string, 0, len(req.LastMsgSeqs))
+	for channel, lastMsgSeq := range req.LastMsgSeqs {
+		lastMsgSeqs = append(lastMsgSeqs, fmt.Sprintf("%s:%d:%d", channel.ChannelID, channel.ChannelType, lastMsgSeq))
+	}
+	var conversations []*Conversation
+	err := c.requestAPI("/conversation/sync", map[string]interface{}{
+		"uid":           c.opts.UID,
+		"version":       req.Version,
+		"last_msg_seqs": strings.Join(lastMsgSeqs, "|"),
+		"msg_count":     req.MsgCount,
+	}, &conversations)
+	if err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+// SyncChannelMessages 通过http api同步频道的消息（需要WithAPI）
+func (c *Client) SyncChannelMessages(channel *Channel, req *SyncChannelMessagesReq) (*ChannelMessages, error) {
+	resp := &ChannelMessages{}
+	err := c.requestAPI("/channel/messagesync", map[string]interface{}{
+		"login_uid":         c.opts.UID,
+		"channel_id":        channel.ChannelID,
+		"channel_type":      channel.ChannelType,
+		"start_message_seq": req.StartMessageSeq,
+		"end_message_seq":   req.EndMessageSeq,
+		"limit":             req.Limit,
+		"pull_mode":         req.PullMode,
+	}, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) requestAPI(path string, req interface{}, resp interface{}) error {
+	if c.opts.APIURL == "" {
+		return ErrAPIURLNotSet
+	}
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+	if c.opts.APIToken != "" {
+		headers["token"] = c.opts.APIToken
+	}
+	result, err := network.Post(c.opts.APIURL+path, []byte(okutil.ToJSON(req)), headers)
+	if err != nil {
+		return err
+	}
+	if result.StatusCode != http.StatusOK {
+		var errResp struct {
+			Msg string `json:"msg"`
+		}
+		if err = okutil.ReadJSONByByte([]byte(result.Body), &errResp); err == nil && errResp.Msg != "" {
+			return fmt.Errorf("请求%s失败！[%d]%s", path, result.StatusCode, errResp.Msg)
+		}
+		return fmt.Errorf("请求%s失败！http状态码错误！[%d]", path, result.StatusCode)
+	}
+	return okutil.ReadJSONByByte([]byte(result.Body), resp)
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncChannelMessages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("token") != "test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"msg":"token错误！","status":401}`))
+			return
+		}
+		assert.Equal(t, "/channel/messagesync", r.URL.Path)
+		var req map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "u1", req["login_uid"])
+		assert.Equal(t, "g1", req["channel_id"])
+		_, _ = w.Write([]byte(`{"start_message_seq":1,"end_message_seq":2,"more":0,"messages":[{"message_id":10,"message_seq":1,"from_uid":"u2","payload":"aGVsbG8="}]}`))
+	}))
+	defer server.Close()
+
+	c := New("tcp://127.0.0.1:5100", WithUID("u1"))
+	_, err := c.SyncChannelMessages(NewChannel("g1", 2), &SyncChannelMessagesReq{Limit: 10})
+	assert.Equal(t, ErrAPIURLNotSet, err)
+
+	c = New("tcp://127.0.0.1:5100", WithUID("u1"), WithAPI(server.URL+"/", "wrong"))
+	_, err = c.SyncChannelMessages(NewChannel("g1", 2), &SyncChannelMessagesReq{Limit: 10})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "token错误！")
+
+	c = New("tcp://127.0.0.1:5100", WithUID("u1"), WithAPI(server.URL, "test"))
+	resp, err := c.SyncChannelMessages(NewChannel("g1", 2), &SyncChannelMessagesReq{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(resp.Messages))
+	assert.Equal(t, int64(10), resp.Messages[0].MessageID)
+	assert.Equal(t, "hello", string(resp.Messages[0].Payload))
+}
+
+func TestSyncConversations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/conversation/sync", r.URL.Path)
+		assert.Equal(t, "test", r.Header.Get("token"))
+		var req map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "u1", req["uid"])
+		assert.Equal(t, float64(3), req["version"])
+		assert.Equal(t, "g1:2:5", req["last_msg_seqs"])
+		assert.Equal(t, float64(1), req["msg_count"])
+		_, _ = w.Write([]byte(`[{"channel_id":"g1","channel_type":2,"unread":2,"last_msg_seq":7,"version":4,"recents":[{"message_id":10,"message_seq":7,"from_uid":"u2","payload":"aGVsbG8="}]}]`))
+	}))
+	defer server.Close()
+
+	c := New("tcp://127.0.0.1:5100", WithUID("u1"))
+	_, err := c.SyncConversations(&SyncConversationsReq{})
+	assert.Equal(t, ErrAPIURLNotSet, err)
+
+	c = New("tcp://127.0.0.1:5100", WithUID("u1"), WithAPI(server.URL, "test"))
+	conversations, err := c.SyncConversations(&SyncConversationsReq{
+		Version:     3,
+		LastMsgSeqs: map[Channel]uint32{{ChannelID: "g1", ChannelType: 2}: 5},
+		MsgCount:    1,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(conversations))
+	assert.Equal(t, "g1", conversations[0].ChannelID)
+	assert.Equal(t, 2, conversations[0].Unread)
+	assert.Equal(t, uint32(7), conversations[0].LastMsgSeq)
+	assert.Equal(t, int64(4), conversations[0].Version)
+	assert.Equal(t, 1, len(conversations[0].Recents))
+	assert.Equal(t, "hello", string(conversations[0].Recents[0].Payload))
+}
@@ -23,6 +23,9 @@ import (
 type OnRecv func(recv *okproto.RecvPacket) error
 type OnSendack func(sendackPacket *okproto.SendackPacket)
 
+// OnDecryptError 收到的消息解密失败事件
+type OnDecryptError func(recv *okproto.RecvPacket, err error)
+
 type Client struct {
 	Statistics
 	oklog.Log
@@ -31,6 +34,7 @@ type Client struct {
 	salt   string // 安全码
 
 	clientIDGen atomic.Uint64
+	subNoGen    atomic.Uint64
 
 	addr   string
 	writer *limWriter
@@ -56,8 +60,13 @@ type Client struct {
 	proto *okproto.Proto
 	pongs []chan struct{}
 
-	onRecv    OnRecv
-	onSendack OnSendack
+	onRecv         OnRecv
+	onSendack      OnSendack
+	onDecryptError OnDecryptError
+
+	subMu         sync.Mutex
+	subFutures    map[string]*SubFuture             // 等待SUBACK的订阅
+	subscriptions map[string]map[string]interface{} // 订阅成功的数据频道和订阅参数（重连后重新订阅）
 
 	err error
 }
@@ -84,6 +93,8 @@ func New(addr string, opt ...Option) *Client {
 			buf: make([]byte, opts.DefaultBufSize),
 			off: -1,
 		},
+		subFutures:    map[string]*SubFuture{},
+		subscriptions: map[string]map[string]interface{}{},
 	}
 
 	return c
@@ -126,6 +137,11 @@ func (c *Client) SetOnSendack(onSendack OnSendack) {
 	c.onSendack = onSendack
 }
 
+// SetOnDecryptError 设置消息解密失败事件（不设置则只记录日志） 解密失败的消息不会触发收消息事件
+func (c *Client) SetOnDecryptError(onDecryptError OnDecryptError) {
+	c.onDecryptError = onDecryptError
+}
+
 func (c *Client) close(status Status, err error) {
 	c.mu.Lock()
 
@@ -165,6 +181,8 @@ func (c *Client) close(status Status, err error) {
 	c.status = status
 
 	c.mu.Unlock()
+
+	c.failSubFutures(ErrConnectionClosed)
 }
 
 func (c *Client) isClosed() bool {
@@ -286,7 +304,10 @@ func (c *Client) doReconnect() {
 			c.status = RECONNECTING
 			continue
 		}
-		c.err = c.flushReconnectPendingItems()
+		// 先重新订阅数据频道，再发送重连期间缓存的包
+		if c.err = c.resubscribe(); c.err == nil {
+			c.err = c.flushReconnectPendingItems()
+		}
 		if c.err != nil {
 			c.status = RECONNECTING
 			// Stop the ping timer (if set)
@@ -415,6 +436,8 @@ func (c *Client) processOpErr(err error) {
 
 		// Create pending buffer before reconnecting.
 		c.writer.switchToPending()
+		// 已发出的订阅收不到SUBACK了（订阅成功的数据频道重连后会重新订阅）
+		c.failSubFutures(ErrConnectionReconnecting)
 		go c.doReconnect()
 		c.mu.Unlock()
 		return
@@ -522,6 +545,8 @@ func (c *Client) handlePacket(frame okproto.Frame) error {
 		c.handleSendackPacket(frame.(*okproto.SendackPacket))
 	case okproto.RECV: // 收到消息
 		c.handleRecvPacket(frame.(*okproto.RecvPacket))
+	case okproto.SUBACK: // 订阅回执
+		c.handleSubackPacket(frame.(*okproto.SubackPacket))
 	case okproto.PONG: // pong
 		c.handlePong()
 	}
@@ -542,21 +567,51 @@ func (c *Client) handleRecvPacket(packet *okproto.RecvPacket) {
 		if !packet.Setting.IsSet(okproto.SettingNoEncrypt) {
 			payload, err = okutil.AesDecryptPkcs7Base64(packet.Payload, []byte(c.aesKey), []byte(c.salt))
 			if err != nil {
-				panic(err)
+				c.handleDecryptError(packet, err)
+				return
 			}
 			packet.Payload = payload
 		}
 		err = c.onRecv(packet)
 	}
-	if err == nil {
-		c.sendPacket(&okproto.RecvackPacket{
-			Framer:     packet.Framer,
-			MessageID:  packet.MessageID,
-			MessageSeq: packet.MessageSeq,
-		})
+	if err == nil && !c.opts.ManualAck {
+		c.sendRecvack(packet)
+	}
+}
+
+func (c *Client) handleDecryptError(packet *okproto.RecvPacket, err error) {
+	if c.onDecryptError != nil {
+		c.onDecryptError(packet, err)
+	} else {
+		c.Error("解密消息失败！", zap.Error(err), zap.Int64("messageID", packet.MessageID), zap.String("channelID", packet.ChannelID), zap.Uint8("channelType", packet.ChannelType))
+	}
+	if !c.opts.ManualAck { // 重发的消息同样无法解密，直接回执
+		c.sendRecvack(packet)
 	}
 }
 
+func (c *Client) sendRecvack(packet *okproto.RecvPacket) {
+	err := c.sendPacket(&okproto.RecvackPacket{
+		Framer:     packet.Framer,
+		MessageID:  packet.MessageID,
+		MessageSeq: packet.MessageSeq,
+	})
+	if err != nil {
+		c.Warn("发送消息回执失败！", zap.Error(err), zap.Int64("messageID", packet.MessageID))
+	}
+}
+
+// Recvack 回执收到的消息 开启手动回执（WithManualAck）后处理完消息再调用，未回执的消息服务端会重发
+func (c *Client) Recvack(packet *okproto.RecvPacket) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.appendPacket(&okproto.RecvackPacket{
+		Framer:     packet.Framer,
+		MessageID:  packet.MessageID,
+		MessageSeq: packet.MessageSeq,
+	})
+}
+
 func (c *Client) handlePong() {
 	var ch chan struct{}
 	c.mu.Lock()
@@ -738,8 +793,12 @@ func (c *Client) appendPacket(packet okproto.Frame) error {
 }
 
 func (c *Client) createConn() (net.Conn, error) {
-	network, address, _ := parseAddr(c.addr)
-	conn, err := net.DialTimeout(network, address, c.opts.Timeout)
+	network, _, _ := parseAddr(c.addr)
+	transport := getTransport(network)
+	if transport == nil {
+		return nil, fmt.Errorf("不支持的连接地址：%s", c.addr)
+	}
+	conn, err := transport.Dial(c.addr, c.opts)
 	if err != nil {
 		return nil, err
 	}
@@ -798,10 +857,14 @@ func parseAddr(addr string) (network, address string, port int) {
 		pair := strings.Split(address, "://")
 		network = pair[0]
 		address = pair[1]
+		if idx := strings.Index(address, "/"); idx != -1 { // ws的地址可能带路径
+			address = address[:idx]
+		}
 		pair2 := strings.Split(address, ":")
-		portStr := pair2[1]
-		portInt64, _ := strconv.ParseInt(portStr, 10, 64)
-		port = int(portInt64)
+		if len(pair2) > 1 {
+			portInt64, _ := strconv.ParseInt(pair2[len(pair2)-1], 10, 64)
+			port = int(portInt64)
+		}
 	}
 	return
 }
@@ -38,11 +38,13 @@ const (
 )
 
 var (
-	ErrStaleConnection  = errors.New("im " + STALE_CONNECTION)
-	ErrNoServers        = errors.New("im no servers available for connection")
-	ErrBadTimeout       = errors.New("im timeout invalid")
-	ErrConnectionClosed = errors.New("im connection closed")
-	ErrTimeout          = errors.New("im timeout")
+	ErrStaleConnection        = errors.New("im " + STALE_CONNECTION)
+	ErrNoServers              = errors.New("im no servers available for connection")
+	ErrBadTimeout             = errors.New("im timeout invalid")
+	ErrConnectionClosed       = errors.New("im connection closed")
+	ErrConnectionReconnecting = errors.New("im connection reconnecting")
+	ErrTimeout                = errors.New("im timeout")
+	ErrAPIURLNotSet           = errors.New("im api url not set")
 )
 
 type Statistics struct {
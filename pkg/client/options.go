@@ -1,6 +1,8 @@
 package client
 
 import (
+	"crypto/tls"
+	"strings"
 	"time"
 
 	okproto "github.com/samlau0508/imserver/pkg/proto"
@@ -12,6 +14,9 @@ type Options struct {
 	UID            string // 用户uid
 	Token          string // 连接IM的token
 	AutoReconn     bool   //是否开启自动重连
+	ManualAck      bool   // 是否手动回执收到的消息（需要调用Client.Recvack） 默认自动回执
+	APIURL         string // IM的http api地址 例如：http://127.0.0.1:5001 （同步会话和消息使用）
+	APIToken       string // 调用http api的token（managerToken或api密钥）
 	DefaultBufSize int    // The size of the bufio reader/writer on top of the socket.
 
 	// ReconnectBufSize is the size of the backing bufio during reconnect.
@@ -26,6 +31,9 @@ type Options struct {
 	// Timeout sets the timeout for a Dial operation on a connection.
 	Timeout time.Duration
 
+	// TLSConfig 连接地址为tls://或wss://时使用的TLS配置（例如自签名证书的RootCAs、双向认证的客户端证书） 为空时使用默认配置
+	TLSConfig *tls.Config
+
 	PingInterval time.Duration
 
 	MaxPingCount int // 最大ping的次数
@@ -89,6 +97,31 @@ func WithAutoReconn(autoReconn bool) Option {
 	}
 }
 
+// WithTLSConfig 连接地址为tls://或wss://时使用的TLS配置
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(opts *Options) error {
+		opts.TLSConfig = tlsConfig
+		return nil
+	}
+}
+
+// WithManualAck 是否手动回执收到的消息
+func WithManualAck(manualAck bool) Option {
+	return func(opts *Options) error {
+		opts.ManualAck = manualAck
+		return nil
+	}
+}
+
+// WithAPI IM的http api地址和token
+func WithAPI(apiURL string, apiToken string) Option {
+	return func(opts *Options) error {
+		opts.APIURL = strings.TrimSuffix(apiURL, "/")
+		opts.APIToken = apiToken
+		return nil
+	}
+}
+
 // SendOptions SendOptions
 type SendOptions struct {
 	NoPersist   bool // 是否不存储 默认 false
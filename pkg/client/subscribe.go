@@ -0,0 +1,168 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/okutil"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"go.uber.org/zap"
+)
+
+// SubFuture 订阅或取消订阅的结果，收到SUBACK后完成
+type SubFuture struct {
+	subNo     string
+	channelID string
+	action    okproto.Action
+	param     map[string]interface{}
+	resub     bool // 是否是重连后的重新订阅
+	done      chan struct{}
+	once      sync.Once
+	suback    *okproto.SubackPacket
+	err       error
+}
+
+func newSubFuture(packet *okproto.SubPacket, param map[string]interface{}) *SubFuture {
+	return &SubFuture{
+		subNo:     packet.SubNo,
+		channelID: packet.ChannelID,
+		action:    packet.Action,
+		param:     param,
+		done:      make(chan struct{}),
+	}
+}
+
+// Done 收到SUBACK或连接断开后关闭
+func (f *SubFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait 等待SUBACK 原因码不是成功时返回错误
+func (f *SubFuture) Wait(timeout time.Duration) (*okproto.SubackPacket, error) {
+	if timeout <= 0 {
+		return nil, ErrBadTimeout
+	}
+	t := globalTimerPool.Get(timeout)
+	defer globalTimerPool.Put(t)
+	select {
+	case <-f.done:
+		return f.suback, f.err
+	case <-t.C:
+		return nil, ErrTimeout
+	}
+}
+
+func (f *SubFuture) complete(suback *okproto.SubackPacket, err error) {
+	f.once.Do(func() {
+		f.suback = suback
+		f.err = err
+		close(f.done)
+	})
+}
+
+// Subscribe 订阅数据频道 param为订阅参数（服务端会合并到连接的订阅信息里）
+func (c *Client) Subscribe(channelID string, param map[string]interface{}) (*SubFuture, error) {
+	return c.sendSub(channelID, okproto.Subscribe, param)
+}
+
+// Unsubscribe 取消订阅数据频道
+func (c *Client) Unsubscribe(channelID string) (*SubFuture, error) {
+	return c.sendSub(channelID, okproto.UnSubscribe, nil)
+}
+
+func (c *Client) sendSub(channelID string, action okproto.Action, param map[string]interface{}) (*SubFuture, error) {
+	packet := c.newSubPacket(channelID, action, param)
+	future := newSubFuture(packet, param)
+	c.subMu.Lock()
+	c.subFutures[packet.SubNo] = future
+	c.subMu.Unlock()
+
+	c.mu.Lock()
+	err := c.appendPacket(packet)
+	c.mu.Unlock()
+	if err != nil {
+		c.removeSubFuture(packet.SubNo)
+		return nil, err
+	}
+	return future, nil
+}
+
+func (c *Client) newSubPacket(channelID string, action okproto.Action, param map[string]interface{}) *okproto.SubPacket {
+	packet := &okproto.SubPacket{
+		SubNo:       strconv.FormatUint(c.subNoGen.Add(1), 10),
+		ChannelID:   channelID,
+		ChannelType: okproto.ChannelTypeData,
+		Action:      action,
+	}
+	if len(param) > 0 {
+		packet.Param = okutil.ToJSON(param)
+	}
+	return packet
+}
+
+// 重连成功后重新订阅之前订阅成功的数据频道（需要在重连期间缓存的包之前发送，缓存里可能有取消订阅）
+func (c *Client) resubscribe() error {
+	c.subMu.Lock()
+	packets := make([]*okproto.SubPacket, 0, len(c.subscriptions))
+	for channelID, param := range c.subscriptions {
+		packet := c.newSubPacket(channelID, okproto.Subscribe, param)
+		future := newSubFuture(packet, param)
+		future.resub = true
+		c.subFutures[packet.SubNo] = future
+		packets = append(packets, packet)
+	}
+	c.subMu.Unlock()
+	for _, packet := range packets {
+		if err := c.sendPacket(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) removeSubFuture(subNo string) *SubFuture {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	future := c.subFutures[subNo]
+	delete(c.subFutures, subNo)
+	return future
+}
+
+func (c *Client) handleSubackPacket(packet *okproto.SubackPacket) {
+	future := c.removeSubFuture(packet.SubNo)
+	if future == nil {
+		return
+	}
+	var err error
+	if packet.ReasonCode != okproto.ReasonSuccess {
+		err = fmt.Errorf("订阅频道[%s]失败！[%s]", packet.ChannelID, packet.ReasonCode.String())
+		if packet.Action == okproto.UnSubscribe {
+			err = fmt.Errorf("取消订阅频道[%s]失败！[%s]", packet.ChannelID, packet.ReasonCode.String())
+		}
+	}
+	// 记录订阅成功的数据频道，重连后重新订阅
+	c.subMu.Lock()
+	if err == nil && future.action == okproto.Subscribe {
+		c.subscriptions[future.channelID] = future.param
+	} else if (err == nil && future.action == okproto.UnSubscribe) || future.resub {
+		delete(c.subscriptions, future.channelID)
+	}
+	c.subMu.Unlock()
+	if err != nil && future.resub {
+		c.Warn("重新订阅数据频道失败！", zap.String("channelID", future.channelID), zap.Error(err))
+	}
+	future.complete(packet, err)
+}
+
+// 连接断开（关闭或开始重连）后未收到SUBACK的订阅都失败
+func (c *Client) failSubFutures(err error) {
+	c.subMu.Lock()
+	futures := c.subFutures
+	c.subFutures = map[string]*SubFuture{}
+	c.subMu.Unlock()
+	for _, future := range futures {
+		future.complete(nil, err)
+	}
+}
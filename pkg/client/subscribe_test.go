@@ -0,0 +1,164 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(opt ...Option) (*Client, *bytes.Buffer) {
+	c := New("tcp://127.0.0.1:5100", opt...)
+	out := &bytes.Buffer{}
+	c.writer.w = out
+	return c, out
+}
+
+// 解码客户端写出的包
+func decodeTestFrames(t *testing.T, c *Client, data []byte) []okproto.Frame {
+	frames := make([]okproto.Frame, 0)
+	for len(data) > 0 {
+		frame, size, err := c.proto.DecodeFrame(data, c.opts.ProtoVersion)
+		assert.NoError(t, err)
+		frames = append(frames, frame)
+		data = data[size:]
+	}
+	return frames
+}
+
+func TestSubscribe(t *testing.T) {
+	c, _ := newTestClient()
+
+	future, err := c.Subscribe("d1", map[string]interface{}{"a": 1})
+	assert.NoError(t, err)
+	frames := decodeTestFrames(t, c, c.writer.bufs)
+	assert.Equal(t, 1, len(frames))
+	sub := frames[0].(*okproto.SubPacket)
+	assert.Equal(t, "d1", sub.ChannelID)
+	assert.Equal(t, okproto.ChannelTypeData, sub.ChannelType)
+	assert.Equal(t, `{"a":1}`, sub.Param)
+
+	c.handleSubackPacket(&okproto.SubackPacket{SubNo: sub.SubNo, ChannelID: "d1", ChannelType: okproto.ChannelTypeData, ReasonCode: okproto.ReasonSuccess})
+	suback, err := future.Wait(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "d1", suback.ChannelID)
+
+	future, err = c.Unsubscribe("d2")
+	assert.NoError(t, err)
+	frames = decodeTestFrames(t, c, c.writer.bufs)
+	unsub := frames[len(frames)-1].(*okproto.SubPacket)
+	assert.Equal(t, okproto.UnSubscribe, unsub.Action)
+	_, err = future.Wait(time.Millisecond * 10)
+	assert.Equal(t, ErrTimeout, err)
+
+	c.handleSubackPacket(&okproto.SubackPacket{SubNo: unsub.SubNo, ChannelID: "d2", Action: okproto.UnSubscribe, ReasonCode: okproto.ReasonChannelNotExist})
+	_, err = future.Wait(time.Second)
+	assert.Error(t, err)
+	assert.Empty(t, c.subFutures)
+}
+
+func TestRecvDecryptErrorAndManualAck(t *testing.T) {
+	recvPacket := func() *okproto.RecvPacket {
+		return &okproto.RecvPacket{MessageID: 1, MessageSeq: 1, ChannelID: "g1", ChannelType: okproto.ChannelTypeGroup, Payload: []byte("not encrypted")}
+	}
+
+	c, out := newTestClient()
+	var recvCount, decryptErrCount int
+	c.SetOnRecv(func(recv *okproto.RecvPacket) error {
+		recvCount++
+		return nil
+	})
+	c.SetOnDecryptError(func(recv *okproto.RecvPacket, err error) {
+		assert.Error(t, err)
+		decryptErrCount++
+	})
+	c.handleRecvPacket(recvPacket())
+	assert.Equal(t, 0, recvCount)
+	assert.Equal(t, 1, decryptErrCount)
+	// 自动回执
+	frames := decodeTestFrames(t, c, out.Bytes())
+	assert.Equal(t, 1, len(frames))
+	assert.Equal(t, int64(1), frames[0].(*okproto.RecvackPacket).MessageID)
+
+	c, out = newTestClient(WithManualAck(true))
+	c.SetOnRecv(func(recv *okproto.RecvPacket) error {
+		recvCount++
+		return nil
+	})
+	packet := recvPacket()
+	packet.Setting.Set(okproto.SettingNoEncrypt)
+	c.handleRecvPacket(packet)
+	assert.Equal(t, 1, recvCount)
+	assert.Equal(t, 0, out.Len())
+
+	err := c.Recvack(packet)
+	assert.NoError(t, err)
+	frames = decodeTestFrames(t, c, c.writer.bufs)
+	assert.Equal(t, 1, len(frames))
+	assert.Equal(t, uint32(1), frames[0].(*okproto.RecvackPacket).MessageSeq)
+}
+
+func TestResubscribe(t *testing.T) {
+	c, out := newTestClient()
+	suback := func(sub *okproto.SubPacket, reasonCode okproto.ReasonCode) {
+		c.handleSubackPacket(&okproto.SubackPacket{SubNo: sub.SubNo, ChannelID: sub.ChannelID, ChannelType: okproto.ChannelTypeData, Action: sub.Action, ReasonCode: reasonCode})
+	}
+
+	// 订阅成功的频道才记录，取消订阅后删除
+	for _, channelID := range []string{"d1", "d2", "d3"} {
+		_, err := c.Subscribe(channelID, map[string]interface{}{"id": channelID})
+		assert.NoError(t, err)
+	}
+	frames := decodeTestFrames(t, c, c.writer.bufs)
+	c.writer.bufs = c.writer.bufs[:0]
+	assert.Equal(t, 3, len(frames))
+	suback(frames[0].(*okproto.SubPacket), okproto.ReasonSuccess)
+	suback(frames[1].(*okproto.SubPacket), okproto.ReasonSuccess)
+	suback(frames[2].(*okproto.SubPacket), okproto.ReasonChannelNotExist)
+	_, err := c.Unsubscribe("d2")
+	assert.NoError(t, err)
+	frames = decodeTestFrames(t, c, c.writer.bufs)
+	c.writer.bufs = c.writer.bufs[:0]
+	suback(frames[0].(*okproto.SubPacket), okproto.ReasonSuccess)
+	assert.Equal(t, map[string]map[string]interface{}{"d1": {"id": "d1"}}, c.subscriptions)
+
+	// 重连后直接发送订阅包
+	err = c.resubscribe()
+	assert.NoError(t, err)
+	frames = decodeTestFrames(t, c, out.Bytes())
+	assert.Equal(t, 1, len(frames))
+	sub := frames[0].(*okproto.SubPacket)
+	assert.Equal(t, okproto.Subscribe, sub.Action)
+	assert.Equal(t, "d1", sub.ChannelID)
+	assert.Equal(t, `{"id":"d1"}`, sub.Param)
+
+	// 重新订阅失败的频道不再记录
+	suback(sub, okproto.ReasonChannelNotExist)
+	assert.Empty(t, c.subscriptions)
+	assert.Empty(t, c.subFutures)
+}
+
+func TestSubFutureFailOnReconnect(t *testing.T) {
+	c, _ := newTestClient(WithAutoReconn(true))
+	c.opts.ReconnectWait = time.Hour
+	c.reconnQuitCh = make(chan struct{})
+	c.status = CONNECTED
+
+	future, err := c.Subscribe("d1", nil)
+	assert.NoError(t, err)
+
+	// 连接断开开始重连，未收到SUBACK的订阅立即失败
+	c.processOpErr(ErrStaleConnection)
+	_, err = future.Wait(time.Second)
+	assert.Equal(t, ErrConnectionReconnecting, err)
+	assert.Empty(t, c.subFutures)
+
+	// 重连期间的订阅在关闭连接后失败
+	future, err = c.Subscribe("d2", nil)
+	assert.NoError(t, err)
+	c.Close()
+	_, err = future.Wait(time.Second)
+	assert.Equal(t, ErrConnectionClosed, err)
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// Transport 建立到IM的连接，按连接地址的scheme选择
+type Transport interface {
+	// Dial addr为完整的连接地址（包含scheme），返回的连接上直接读写IM协议的数据
+	Dial(addr string, opts *Options) (net.Conn, error)
+}
+
+// TransportFunc 函数形式的Transport
+type TransportFunc func(addr string, opts *Options) (net.Conn, error)
+
+// Dial Dial
+func (f TransportFunc) Dial(addr string, opts *Options) (net.Conn, error) {
+	return f(addr, opts)
+}
+
+var (
+	transportsMu sync.RWMutex
+	transports   = map[string]Transport{
+		"tcp": TransportFunc(dialTCP),
+		"tls": TransportFunc(dialTLS),
+		"ws":  TransportFunc(dialWS),
+		"wss": TransportFunc(dialWS),
+	}
+)
+
+// RegisterTransport 注册scheme对应的Transport（会覆盖已有的）
+func RegisterTransport(scheme string, transport Transport) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[scheme] = transport
+}
+
+func getTransport(scheme string) Transport {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+	return transports[scheme]
+}
+
+// tcp://ip:port 或 ip:port
+func dialTCP(addr string, opts *Options) (net.Conn, error) {
+	_, address, _ := parseAddr(addr)
+	return net.DialTimeout("tcp", address, opts.Timeout)
+}
+
+// tls://ip:port 服务端配置了tlsConfig的tcp长连接
+func dialTLS(addr string, opts *Options) (net.Conn, error) {
+	_, address, _ := parseAddr(addr)
+	return tls.DialWithDialer(&net.Dialer{Timeout: opts.Timeout}, "tcp", address, opts.TLSConfig)
+}
+
+// ws://ip:port 或 wss://ip:port 每次写入作为一个二进制帧发送，读取时按帧拼接为字节流
+func dialWS(addr string, opts *Options) (net.Conn, error) {
+	dialer := ws.Dialer{
+		Timeout:   opts.Timeout,
+		TLSConfig: opts.TLSConfig,
+	}
+	conn, br, _, err := dialer.Dial(context.Background(), addr)
+	if err != nil {
+		return nil, err
+	}
+	var source io.Reader = conn
+	if br != nil { // 握手时多读的数据
+		source = io.MultiReader(br, conn)
+	}
+	return &wsConn{
+		Conn:   conn,
+		reader: wsutil.NewClientSideReader(source),
+	}, nil
+}
+
+type wsConn struct {
+	net.Conn
+	reader  *wsutil.Reader
+	reading bool // 当前数据帧是否还有数据未读
+	writeMu sync.Mutex
+}
+
+func (w *wsConn) Read(p []byte) (int, error) {
+	for {
+		if !w.reading {
+			hdr, err := w.reader.NextFrame()
+			if err != nil {
+				return 0, err
+			}
+			if hdr.OpCode.IsControl() {
+				if err = w.handleControl(hdr); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			w.reading = true
+		}
+		n, err := w.reader.Read(p)
+		if err == io.EOF { // 当前帧已读完
+			w.reading = false
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (w *wsConn) handleControl(hdr ws.Header) error {
+	payload := make([]byte, hdr.Length)
+	if _, err := io.ReadFull(w.reader, payload); err != nil && err != io.EOF {
+		return err
+	}
+	switch hdr.OpCode {
+	case ws.OpPing:
+		return w.writeMessage(ws.OpPong, payload)
+	case ws.OpClose:
+		_ = w.writeMessage(ws.OpClose, payload)
+		return io.EOF
+	}
+	return nil
+}
+
+func (w *wsConn) Write(p []byte) (int, error) {
+	if err := w.writeMessage(ws.OpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *wsConn) writeMessage(op ws.OpCode, p []byte) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	return wsutil.WriteClientMessage(w.Conn, op, p)
+}
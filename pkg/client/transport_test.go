@@ -0,0 +1,89 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAddr(t *testing.T) {
+	network, address, port := parseAddr("127.0.0.1:5100")
+	assert.Equal(t, "tcp", network)
+	assert.Equal(t, "127.0.0.1:5100", address)
+	assert.Equal(t, 0, port)
+
+	network, address, port = parseAddr("WSS://im.example.com:5210/ws")
+	assert.Equal(t, "wss", network)
+	assert.Equal(t, "im.example.com:5210", address)
+	assert.Equal(t, 5210, port)
+
+	network, address, _ = parseAddr("ws://im.example.com")
+	assert.Equal(t, "ws", network)
+	assert.Equal(t, "im.example.com", address)
+}
+
+// ws服务端先发ping和拆成两帧的数据，再把收到的二进制帧原样返回
+func newTestWSHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _, err := ws.UpgradeHTTP(r, w)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		assert.NoError(t, wsutil.WriteServerMessage(conn, ws.OpPing, []byte("ping")))
+		assert.NoError(t, wsutil.WriteServerBinary(conn, []byte("hel")))
+		assert.NoError(t, wsutil.WriteServerBinary(conn, []byte("lo")))
+		for {
+			data, op, err := wsutil.ReadClientData(conn)
+			if err != nil {
+				return
+			}
+			if op == ws.OpBinary {
+				_ = wsutil.WriteServerBinary(conn, data)
+			}
+		}
+	})
+}
+
+func testWSTransport(t *testing.T, addr string, opts *Options) {
+	conn, err := getTransport(strings.SplitN(addr, "://", 2)[0]).Dial(addr, opts)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf) // ping由连接自动回复，数据按字节流读取
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	_, err = conn.Write([]byte("world"))
+	assert.NoError(t, err)
+	_, err = io.ReadFull(conn, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(buf))
+}
+
+func TestWSTransport(t *testing.T) {
+	server := httptest.NewServer(newTestWSHandler(t))
+	defer server.Close()
+
+	testWSTransport(t, strings.Replace(server.URL, "http://", "ws://", 1), NewOptions())
+}
+
+func TestWSSTransport(t *testing.T) {
+	server := httptest.NewTLSServer(newTestWSHandler(t))
+	defer server.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+	opts := NewOptions()
+	opts.TLSConfig = &tls.Config{RootCAs: rootCAs}
+	testWSTransport(t, strings.Replace(server.URL, "https://", "wss://", 1), opts)
+}
@@ -0,0 +1,162 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"unicode/utf8"
+)
+
+var (
+	// ErrMalformed 报文格式错误
+	ErrMalformed = errors.New("mqtt: malformed packet")
+	// ErrPacketTooLarge 报文太大
+	ErrPacketTooLarge = errors.New("mqtt: packet too large")
+	// ErrUnknownPacketType 未知报文类型
+	ErrUnknownPacketType = errors.New("mqtt: unknown packet type")
+	// ErrProtocolViolation 违反协议
+	ErrProtocolViolation = errors.New("mqtt: protocol violation")
+	// ErrInvalidUTF8 字符串不是合法的UTF-8
+	ErrInvalidUTF8 = errors.New("mqtt: invalid utf-8 string")
+)
+
+// decoder 报文体解码
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *decoder) len() int {
+	return len(d.buf) - d.pos
+}
+
+func (d *decoder) readByte() (byte, error) {
+	if d.len() < 1 {
+		return 0, ErrMalformed
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *decoder) readUint16() (uint16, error) {
+	if d.len() < 2 {
+		return 0, ErrMalformed
+	}
+	v := binary.BigEndian.Uint16(d.buf[d.pos:])
+	d.pos += 2
+	return v, nil
+}
+
+func (d *decoder) readUint32() (uint32, error) {
+	if d.len() < 4 {
+		return 0, ErrMalformed
+	}
+	v := binary.BigEndian.Uint32(d.buf[d.pos:])
+	d.pos += 4
+	return v, nil
+}
+
+func (d *decoder) readVarint() (uint32, error) {
+	var (
+		value      uint32
+		multiplier uint32 = 1
+	)
+	for i := 0; i < 4; i++ {
+		b, err := d.readByte()
+		if err != nil {
+			return 0, err
+		}
+		value += uint32(b&0x7F) * multiplier
+		if b&0x80 == 0 {
+			return value, nil
+		}
+		multiplier *= 128
+	}
+	return 0, ErrMalformed
+}
+
+func (d *decoder) readBinary() ([]byte, error) {
+	length, err := d.readUint16()
+	if err != nil {
+		return nil, err
+	}
+	return d.readBytes(int(length))
+}
+
+func (d *decoder) readString() (string, error) {
+	b, err := d.readBinary()
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", ErrInvalidUTF8
+	}
+	return string(b), nil
+}
+
+func (d *decoder) readBytes(n int) ([]byte, error) {
+	if n < 0 || d.len() < n {
+		return nil, ErrMalformed
+	}
+	b := make([]byte, n)
+	copy(b, d.buf[d.pos:d.pos+n])
+	d.pos += n
+	return b, nil
+}
+
+// readRest 读取剩余的全部数据
+func (d *decoder) readRest() []byte {
+	b, _ := d.readBytes(d.len())
+	return b
+}
+
+func writeUint16(b *bytes.Buffer, v uint16) {
+	b.WriteByte(byte(v >> 8))
+	b.WriteByte(byte(v))
+}
+
+func writeUint32(b *bytes.Buffer, v uint32) {
+	b.WriteByte(byte(v >> 24))
+	b.WriteByte(byte(v >> 16))
+	b.WriteByte(byte(v >> 8))
+	b.WriteByte(byte(v))
+}
+
+func writeVarint(b *bytes.Buffer, v uint32) {
+	for {
+		digit := byte(v % 128)
+		v /= 128
+		if v > 0 {
+			digit |= 0x80
+		}
+		b.WriteByte(digit)
+		if v == 0 {
+			return
+		}
+	}
+}
+
+func writeBinary(b *bytes.Buffer, v []byte) {
+	writeUint16(b, uint16(len(v)))
+	b.Write(v)
+}
+
+func writeString(b *bytes.Buffer, v string) {
+	writeUint16(b, uint16(len(v)))
+	b.WriteString(v)
+}
+
+// varintSize 可变长度整数所占字节数
+func varintSize(v uint32) int {
+	switch {
+	case v < 128:
+		return 1
+	case v < 16384:
+		return 2
+	case v < 2097152:
+		return 3
+	default:
+		return 4
+	}
+}
@@ -1,4 +1,210 @@
 package mqtt
 
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ConnectPacket 连接报文
 type ConnectPacket struct {
+	FixedHeader
+	ProtocolName    string      // 协议名 MQTT（3.1为MQIsdp）
+	ProtocolVersion byte        // 协议版本 3/4/5
+	CleanStart      bool        // 清理会话
+	KeepAlive       uint16      // 保持连接（秒）
+	Properties      *Properties // v5属性
+	ClientID        string      // 客户端标识符
+	WillFlag        bool        // 遗嘱标志
+	WillQoS         byte        // 遗嘱QoS
+	WillRetain      bool        // 遗嘱保留
+	WillProperties  *Properties // v5遗嘱属性
+	WillTopic       string      // 遗嘱主题
+	WillPayload     []byte      // 遗嘱消息
+	UsernameFlag    bool        // 是否有用户名
+	Username        string      // 用户名
+	PasswordFlag    bool        // 是否有密码
+	Password        []byte      // 密码
+}
+
+// Encode 编码
+func (c *ConnectPacket) Encode(w io.Writer) error {
+	if c.ProtocolVersion == 0 {
+		c.ProtocolVersion = Version311
+	}
+	if c.ProtocolName == "" {
+		if c.ProtocolVersion == Version31 {
+			c.ProtocolName = "MQIsdp"
+		} else {
+			c.ProtocolName = "MQTT"
+		}
+	}
+	c.Version = c.ProtocolVersion
+	var b bytes.Buffer
+	writeString(&b, c.ProtocolName)
+	b.WriteByte(c.ProtocolVersion)
+	var flags byte
+	if c.UsernameFlag {
+		flags |= 0x80
+	}
+	if c.PasswordFlag {
+		flags |= 0x40
+	}
+	if c.WillRetain {
+		flags |= 0x20
+	}
+	flags |= (c.WillQoS & 0x03) << 3
+	if c.WillFlag {
+		flags |= 0x04
+	}
+	if c.CleanStart {
+		flags |= 0x02
+	}
+	b.WriteByte(flags)
+	writeUint16(&b, c.KeepAlive)
+	if c.IsV5() {
+		c.Properties.encode(&b)
+	}
+	writeString(&b, c.ClientID)
+	if c.WillFlag {
+		if c.IsV5() {
+			c.WillProperties.encode(&b)
+		}
+		writeString(&b, c.WillTopic)
+		writeBinary(&b, c.WillPayload)
+	}
+	if c.UsernameFlag {
+		writeString(&b, c.Username)
+	}
+	if c.PasswordFlag {
+		writeBinary(&b, c.Password)
+	}
+	return writePacket(w, &c.FixedHeader, b.Bytes())
+}
+
+// Decode 解码
+func (c *ConnectPacket) Decode(r io.Reader, remainingLen uint32) error {
+	d, err := readBody(r, remainingLen)
+	if err != nil {
+		return err
+	}
+	if c.ProtocolName, err = d.readString(); err != nil {
+		return err
+	}
+	if c.ProtocolVersion, err = d.readByte(); err != nil {
+		return err
+	}
+	if c.ProtocolName != "MQTT" && c.ProtocolName != "MQIsdp" {
+		return fmt.Errorf("%w: invalid protocol name %s", ErrProtocolViolation, c.ProtocolName)
+	}
+	c.Version = c.ProtocolVersion
+	flags, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	if flags&0x01 != 0 {
+		return fmt.Errorf("%w: reserved connect flag is set", ErrMalformed)
+	}
+	c.UsernameFlag = flags&0x80 != 0
+	c.PasswordFlag = flags&0x40 != 0
+	c.WillRetain = flags&0x20 != 0
+	c.WillQoS = (flags >> 3) & 0x03
+	c.WillFlag = flags&0x04 != 0
+	c.CleanStart = flags&0x02 != 0
+	if c.WillQoS > 2 || (!c.WillFlag && (c.WillQoS != 0 || c.WillRetain)) {
+		return fmt.Errorf("%w: invalid will flags", ErrMalformed)
+	}
+	if c.KeepAlive, err = d.readUint16(); err != nil {
+		return err
+	}
+	if c.IsV5() {
+		if c.Properties, err = decodeProperties(d); err != nil {
+			return err
+		}
+	}
+	if c.ClientID, err = d.readString(); err != nil {
+		return err
+	}
+	if c.WillFlag {
+		if c.IsV5() {
+			if c.WillProperties, err = decodeProperties(d); err != nil {
+				return err
+			}
+		}
+		if c.WillTopic, err = d.readString(); err != nil {
+			return err
+		}
+		if c.WillPayload, err = d.readBinary(); err != nil {
+			return err
+		}
+	}
+	if c.UsernameFlag {
+		if c.Username, err = d.readString(); err != nil {
+			return err
+		}
+	}
+	if c.PasswordFlag {
+		if c.Password, err = d.readBinary(); err != nil {
+			return err
+		}
+	}
+	if d.len() > 0 {
+		return ErrMalformed
+	}
+	return nil
+}
+
+func (c *ConnectPacket) String() string {
+	return fmt.Sprintf("%s ProtocolName:%s ProtocolVersion:%d CleanStart:%v KeepAlive:%d ClientID:%s Username:%s WillFlag:%v WillTopic:%s", c.FixedHeader.String(), c.ProtocolName, c.ProtocolVersion, c.CleanStart, c.KeepAlive, c.ClientID, c.Username, c.WillFlag, c.WillTopic)
+}
+
+// ConnackPacket 连接确认报文
+type ConnackPacket struct {
+	FixedHeader
+	SessionPresent bool        // 当前会话
+	ReasonCode     ReasonCode  // v5为原因码，v3.1.1为连接返回码
+	Properties     *Properties // v5属性
+}
+
+// Encode 编码
+func (c *ConnackPacket) Encode(w io.Writer) error {
+	var b bytes.Buffer
+	if c.SessionPresent {
+		b.WriteByte(0x01)
+	} else {
+		b.WriteByte(0x00)
+	}
+	b.WriteByte(byte(c.ReasonCode))
+	if c.IsV5() {
+		c.Properties.encode(&b)
+	}
+	return writePacket(w, &c.FixedHeader, b.Bytes())
+}
+
+// Decode 解码
+func (c *ConnackPacket) Decode(r io.Reader, remainingLen uint32) error {
+	d, err := readBody(r, remainingLen)
+	if err != nil {
+		return err
+	}
+	ack, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	c.SessionPresent = ack&0x01 != 0
+	code, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	c.ReasonCode = ReasonCode(code)
+	if c.IsV5() && d.len() > 0 {
+		if c.Properties, err = decodeProperties(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ConnackPacket) String() string {
+	return fmt.Sprintf("%s SessionPresent:%v ReasonCode:%d", c.FixedHeader.String(), c.SessionPresent, c.ReasonCode)
 }
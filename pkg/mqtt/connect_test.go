@@ -0,0 +1,110 @@
+package mqtt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeAndDecode(t *testing.T, packet ControlPacket, version byte) ControlPacket {
+	var buf bytes.Buffer
+	// 编码
+	err := packet.Encode(&buf)
+	assert.NoError(t, err)
+
+	length, err := DecodePacketLength(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, buf.Len(), length)
+
+	// 解码
+	resultPacket, err := ReadFromVersion(&buf, version)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, buf.Len())
+	return resultPacket
+}
+
+func TestConnectEncodeAndDecode(t *testing.T) {
+	packet := &ConnectPacket{
+		FixedHeader:     FixedHeader{Type: CONNECT},
+		ProtocolVersion: Version311,
+		CleanStart:      true,
+		KeepAlive:       60,
+		ClientID:        "device1",
+		WillFlag:        true,
+		WillQoS:         1,
+		WillTopic:       "will",
+		WillPayload:     []byte("bye"),
+		UsernameFlag:    true,
+		Username:        "uid1",
+		PasswordFlag:    true,
+		Password:        []byte("token"),
+	}
+	// CONNECT自带协议版本，用任意版本都能解码
+	resultPacket := encodeAndDecode(t, packet, Version5)
+	resultConnectPacket, ok := resultPacket.(*ConnectPacket)
+	assert.Equal(t, true, ok)
+
+	assert.Equal(t, "MQTT", resultConnectPacket.ProtocolName)
+	assert.Equal(t, Version311, resultConnectPacket.Version)
+	assert.Equal(t, packet.CleanStart, resultConnectPacket.CleanStart)
+	assert.Equal(t, packet.KeepAlive, resultConnectPacket.KeepAlive)
+	assert.Equal(t, packet.ClientID, resultConnectPacket.ClientID)
+	assert.Equal(t, packet.WillQoS, resultConnectPacket.WillQoS)
+	assert.Equal(t, packet.WillTopic, resultConnectPacket.WillTopic)
+	assert.Equal(t, packet.WillPayload, resultConnectPacket.WillPayload)
+	assert.Equal(t, packet.Username, resultConnectPacket.Username)
+	assert.Equal(t, packet.Password, resultConnectPacket.Password)
+}
+
+func TestConnectV5EncodeAndDecode(t *testing.T) {
+	packet := &ConnectPacket{
+		FixedHeader:     FixedHeader{Type: CONNECT},
+		ProtocolVersion: Version5,
+		KeepAlive:       30,
+		Properties: &Properties{
+			SessionExpiryInterval: Uint32(120),
+			ReceiveMaximum:        Uint16(10),
+			User:                  []UserProperty{{Key: "k", Value: "v"}},
+		},
+		ClientID:     "device1",
+		UsernameFlag: true,
+		Username:     "uid1",
+	}
+	resultPacket := encodeAndDecode(t, packet, Version311)
+	resultConnectPacket, ok := resultPacket.(*ConnectPacket)
+	assert.Equal(t, true, ok)
+
+	assert.Equal(t, true, resultConnectPacket.IsV5())
+	assert.Equal(t, uint32(120), *resultConnectPacket.Properties.SessionExpiryInterval)
+	assert.Equal(t, uint16(10), *resultConnectPacket.Properties.ReceiveMaximum)
+	value, ok := resultConnectPacket.Properties.GetUser("k")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "v", value)
+	assert.Equal(t, packet.Username, resultConnectPacket.Username)
+	assert.Equal(t, false, resultConnectPacket.PasswordFlag)
+}
+
+func TestConnackEncodeAndDecode(t *testing.T) {
+	for _, version := range []byte{Version311, Version5} {
+		packet := &ConnackPacket{
+			FixedHeader:    FixedHeader{Type: CONNACK, Version: version},
+			SessionPresent: true,
+			ReasonCode:     ConnectRefusedNotAuthorized,
+		}
+		if version == Version5 {
+			packet.ReasonCode = NotAuthorized
+			packet.Properties = &Properties{ReasonString: "token error", ServerKeepAlive: Uint16(90)}
+		}
+		resultPacket := encodeAndDecode(t, packet, version)
+		resultConnackPacket, ok := resultPacket.(*ConnackPacket)
+		assert.Equal(t, true, ok)
+
+		assert.Equal(t, packet.SessionPresent, resultConnackPacket.SessionPresent)
+		assert.Equal(t, packet.ReasonCode, resultConnackPacket.ReasonCode)
+		if version == Version5 {
+			assert.Equal(t, "token error", resultConnackPacket.Properties.ReasonString)
+			assert.Equal(t, uint16(90), *resultConnackPacket.Properties.ServerKeepAlive)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package mqtt
 
+import "fmt"
+
 type ReasonCode byte
 
 const (
@@ -28,3 +30,138 @@ const (
 	SubscriptionIdsNotSupported       ReasonCode = 0xA1 // SUBACK, DISCONNECT
 	WildcardSubscriptionsNotSupported ReasonCode = 0xA2 // SUBACK, DISCONNECT
 )
+
+// 其他v5原因码
+const (
+	NormalDisconnection        ReasonCode = 0x00 // DISCONNECT
+	GrantedQoS0                ReasonCode = 0x00 // SUBACK
+	GrantedQoS1                ReasonCode = 0x01 // SUBACK
+	GrantedQoS2                ReasonCode = 0x02 // SUBACK
+	DisconnectWithWillMessage  ReasonCode = 0x04 // DISCONNECT
+	NoSubscriptionExisted      ReasonCode = 0x11 // UNSUBACK
+	ContinueAuthentication     ReasonCode = 0x18 // AUTH
+	ReAuthenticate             ReasonCode = 0x19 // AUTH
+	UnsupportedProtocolVersion ReasonCode = 0x84 // CONNACK
+	ClientIdentifierNotValid   ReasonCode = 0x85 // CONNACK
+	BadUserNameOrPassword      ReasonCode = 0x86 // CONNACK
+	ServerUnavailable          ReasonCode = 0x88 // CONNACK
+	Banned                     ReasonCode = 0x8A // CONNACK
+	ServerShuttingDown         ReasonCode = 0x8B // DISCONNECT
+	KeepAliveTimeout           ReasonCode = 0x8D // DISCONNECT
+	SessionTakenOver           ReasonCode = 0x8E // DISCONNECT
+	ReceiveMaximumExceeded     ReasonCode = 0x93 // DISCONNECT
+	TopicAliasInvalid          ReasonCode = 0x94 // DISCONNECT
+	MessageRateTooHigh         ReasonCode = 0x96 // DISCONNECT
+	AdministrativeAction       ReasonCode = 0x98 // DISCONNECT
+	MaximumConnectTime         ReasonCode = 0xA0 // DISCONNECT
+	SubscribeFailure           ReasonCode = 0x80 // SUBACK (v3.1.1)
+)
+
+// v3.1.1 CONNACK的返回码
+const (
+	ConnectAccepted                    ReasonCode = 0x00 // 连接已接受
+	ConnectRefusedUnacceptableProtocol ReasonCode = 0x01 // 不支持的协议版本
+	ConnectRefusedIdentifierRejected   ReasonCode = 0x02 // 客户端标识符不合法
+	ConnectRefusedServerUnavailable    ReasonCode = 0x03 // 服务端不可用
+	ConnectRefusedBadUsernamePassword  ReasonCode = 0x04 // 用户名或密码错误
+	ConnectRefusedNotAuthorized        ReasonCode = 0x05 // 未授权
+)
+
+// 协议版本（CONNECT里的Protocol Level）
+const (
+	Version31  byte = 3 // MQTT 3.1
+	Version311 byte = 4 // MQTT 3.1.1
+	Version5   byte = 5 // MQTT 5.0
+)
+
+// PacketType 控制报文类型
+type PacketType byte
+
+const (
+	Reserved    PacketType = iota
+	CONNECT                // 客户端请求连接服务端
+	CONNACK                // 连接报文确认
+	PUBLISH                // 发布消息
+	PUBACK                 // QoS 1消息发布收到确认
+	PUBREC                 // 发布收到（保证交付第一步）
+	PUBREL                 // 发布释放（保证交付第二步）
+	PUBCOMP                // QoS 2消息发布完成（保证交互第三步）
+	SUBSCRIBE              // 客户端订阅请求
+	SUBACK                 // 订阅请求报文确认
+	UNSUBSCRIBE            // 客户端取消订阅请求
+	UNSUBACK               // 取消订阅报文确认
+	PINGREQ                // 心跳请求
+	PINGRESP               // 心跳响应
+	DISCONNECT             // 断开连接
+	AUTH                   // 认证交换（v5）
+)
+
+func (p PacketType) String() string {
+	switch p {
+	case CONNECT:
+		return "CONNECT"
+	case CONNACK:
+		return "CONNACK"
+	case PUBLISH:
+		return "PUBLISH"
+	case PUBACK:
+		return "PUBACK"
+	case PUBREC:
+		return "PUBREC"
+	case PUBREL:
+		return "PUBREL"
+	case PUBCOMP:
+		return "PUBCOMP"
+	case SUBSCRIBE:
+		return "SUBSCRIBE"
+	case SUBACK:
+		return "SUBACK"
+	case UNSUBSCRIBE:
+		return "UNSUBSCRIBE"
+	case UNSUBACK:
+		return "UNSUBACK"
+	case PINGREQ:
+		return "PINGREQ"
+	case PINGRESP:
+		return "PINGRESP"
+	case DISCONNECT:
+		return "DISCONNECT"
+	case AUTH:
+		return "AUTH"
+	}
+	return fmt.Sprintf("UNKNOWN[%d]", p)
+}
+
+// v5属性标识符
+const (
+	PropPayloadFormat          byte = 0x01
+	PropMessageExpiry          byte = 0x02
+	PropContentType            byte = 0x03
+	PropResponseTopic          byte = 0x08
+	PropCorrelationData        byte = 0x09
+	PropSubscriptionIdentifier byte = 0x0B
+	PropSessionExpiryInterval  byte = 0x11
+	PropAssignedClientID       byte = 0x12
+	PropServerKeepAlive        byte = 0x13
+	PropAuthMethod             byte = 0x15
+	PropAuthData               byte = 0x16
+	PropRequestProblemInfo     byte = 0x17
+	PropWillDelayInterval      byte = 0x18
+	PropRequestResponseInfo    byte = 0x19
+	PropResponseInfo           byte = 0x1A
+	PropServerReference        byte = 0x1C
+	PropReasonString           byte = 0x1F
+	PropReceiveMaximum         byte = 0x21
+	PropTopicAliasMaximum      byte = 0x22
+	PropTopicAlias             byte = 0x23
+	PropMaximumQoS             byte = 0x24
+	PropRetainAvailable        byte = 0x25
+	PropUserProperty           byte = 0x26
+	PropMaximumPacketSize      byte = 0x27
+	PropWildcardSubAvailable   byte = 0x28
+	PropSubIDAvailable         byte = 0x29
+	PropSharedSubAvailable     byte = 0x2A
+)
+
+// MaxRemainingLength 剩余长度的最大值
+const MaxRemainingLength = 268435455
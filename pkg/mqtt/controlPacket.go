@@ -1,9 +1,96 @@
 package mqtt
 
-import "io"
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
 
 // controlPacket MQTT control packet codec interface
 type ControlPacket interface {
 	Encode(w io.Writer) error
 	Decode(r io.Reader, remainingLen uint32) error
+	// GetFixedHeader 获取固定报头
+	GetFixedHeader() *FixedHeader
+}
+
+// FixedHeader 固定报头
+type FixedHeader struct {
+	Type    PacketType // 报文类型
+	Flags   byte       // 报文标记（低4位）
+	Version byte       // 协议版本（不参与编码解码，v5才有属性和原因码）
+}
+
+// GetFixedHeader 获取固定报头
+func (f *FixedHeader) GetFixedHeader() *FixedHeader {
+	return f
+}
+
+// IsV5 是否是MQTT 5.0
+func (f *FixedHeader) IsV5() bool {
+	return f.Version >= Version5
+}
+
+func (f FixedHeader) String() string {
+	return fmt.Sprintf("Type:%s Flags:%d Version:%d", f.Type.String(), f.Flags, f.Version)
+}
+
+// NewControlPacket 通过报文类型创建报文
+func NewControlPacket(packetType PacketType) ControlPacket {
+	switch packetType {
+	case CONNECT:
+		return &ConnectPacket{FixedHeader: FixedHeader{Type: CONNECT}}
+	case CONNACK:
+		return &ConnackPacket{FixedHeader: FixedHeader{Type: CONNACK}}
+	case PUBLISH:
+		return &PublishPacket{FixedHeader: FixedHeader{Type: PUBLISH}}
+	case PUBACK:
+		return &PubackPacket{ackPacket{FixedHeader: FixedHeader{Type: PUBACK}}}
+	case PUBREC:
+		return &PubrecPacket{ackPacket{FixedHeader: FixedHeader{Type: PUBREC}}}
+	case PUBREL:
+		return &PubrelPacket{ackPacket{FixedHeader: FixedHeader{Type: PUBREL, Flags: 0x02}}}
+	case PUBCOMP:
+		return &PubcompPacket{ackPacket{FixedHeader: FixedHeader{Type: PUBCOMP}}}
+	case SUBSCRIBE:
+		return &SubscribePacket{FixedHeader: FixedHeader{Type: SUBSCRIBE, Flags: 0x02}}
+	case SUBACK:
+		return &SubackPacket{FixedHeader: FixedHeader{Type: SUBACK}}
+	case UNSUBSCRIBE:
+		return &UnsubscribePacket{FixedHeader: FixedHeader{Type: UNSUBSCRIBE, Flags: 0x02}}
+	case UNSUBACK:
+		return &UnsubackPacket{FixedHeader: FixedHeader{Type: UNSUBACK}}
+	case PINGREQ:
+		return &PingreqPacket{FixedHeader: FixedHeader{Type: PINGREQ}}
+	case PINGRESP:
+		return &PingrespPacket{FixedHeader: FixedHeader{Type: PINGRESP}}
+	case DISCONNECT:
+		return &DisconnectPacket{FixedHeader: FixedHeader{Type: DISCONNECT}}
+	case AUTH:
+		return &AuthPacket{FixedHeader: FixedHeader{Type: AUTH}}
+	}
+	return nil
+}
+
+// writePacket 写入固定报头和可变报头+有效载荷
+func writePacket(w io.Writer, header *FixedHeader, body []byte) error {
+	if len(body) > MaxRemainingLength {
+		return ErrPacketTooLarge
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(body) + 5)
+	buf.WriteByte(byte(header.Type)<<4 | header.Flags&0x0F)
+	writeVarint(&buf, uint32(len(body)))
+	buf.Write(body)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// readBody 读取剩余长度的数据
+func readBody(r io.Reader, remainingLen uint32) (*decoder, error) {
+	body := make([]byte, remainingLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &decoder{buf: body}, nil
 }
@@ -0,0 +1,136 @@
+package mqtt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// PingreqPacket 心跳请求
+type PingreqPacket struct {
+	FixedHeader
+}
+
+// Encode 编码
+func (p *PingreqPacket) Encode(w io.Writer) error {
+	return writePacket(w, &p.FixedHeader, nil)
+}
+
+// Decode 解码
+func (p *PingreqPacket) Decode(r io.Reader, remainingLen uint32) error {
+	_, err := readBody(r, remainingLen)
+	return err
+}
+
+// PingrespPacket 心跳响应
+type PingrespPacket struct {
+	FixedHeader
+}
+
+// Encode 编码
+func (p *PingrespPacket) Encode(w io.Writer) error {
+	return writePacket(w, &p.FixedHeader, nil)
+}
+
+// Decode 解码
+func (p *PingrespPacket) Decode(r io.Reader, remainingLen uint32) error {
+	_, err := readBody(r, remainingLen)
+	return err
+}
+
+// DisconnectPacket 断开连接
+type DisconnectPacket struct {
+	FixedHeader
+	ReasonCode ReasonCode  // v5原因码
+	Properties *Properties // v5属性
+}
+
+// Encode 编码
+func (p *DisconnectPacket) Encode(w io.Writer) error {
+	var b bytes.Buffer
+	if p.IsV5() {
+		hasProps := p.Properties != nil && !isEmptyProperties(p.Properties)
+		if p.ReasonCode != NormalDisconnection || hasProps {
+			b.WriteByte(byte(p.ReasonCode))
+			if hasProps {
+				p.Properties.encode(&b)
+			}
+		}
+	}
+	return writePacket(w, &p.FixedHeader, b.Bytes())
+}
+
+// Decode 解码
+func (p *DisconnectPacket) Decode(r io.Reader, remainingLen uint32) error {
+	d, err := readBody(r, remainingLen)
+	if err != nil {
+		return err
+	}
+	if p.IsV5() {
+		if d.len() > 0 {
+			code, err := d.readByte()
+			if err != nil {
+				return err
+			}
+			p.ReasonCode = ReasonCode(code)
+		}
+		if d.len() > 0 {
+			if p.Properties, err = decodeProperties(d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *DisconnectPacket) String() string {
+	return fmt.Sprintf("%s ReasonCode:%d", p.FixedHeader.String(), p.ReasonCode)
+}
+
+// AuthPacket 认证交换（v5）
+type AuthPacket struct {
+	FixedHeader
+	ReasonCode ReasonCode  // 原因码
+	Properties *Properties // 属性
+}
+
+// Encode 编码
+func (p *AuthPacket) Encode(w io.Writer) error {
+	var b bytes.Buffer
+	hasProps := p.Properties != nil && !isEmptyProperties(p.Properties)
+	if p.ReasonCode != Success || hasProps {
+		b.WriteByte(byte(p.ReasonCode))
+		if hasProps {
+			p.Properties.encode(&b)
+		}
+	}
+	return writePacket(w, &p.FixedHeader, b.Bytes())
+}
+
+// Decode 解码
+func (p *AuthPacket) Decode(r io.Reader, remainingLen uint32) error {
+	if !p.IsV5() {
+		return fmt.Errorf("%w: auth packet requires mqtt 5.0", ErrProtocolViolation)
+	}
+	d, err := readBody(r, remainingLen)
+	if err != nil {
+		return err
+	}
+	if d.len() > 0 {
+		code, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		p.ReasonCode = ReasonCode(code)
+	}
+	if d.len() > 0 {
+		if p.Properties, err = decodeProperties(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *AuthPacket) String() string {
+	return fmt.Sprintf("%s ReasonCode:%d", p.FixedHeader.String(), p.ReasonCode)
+}
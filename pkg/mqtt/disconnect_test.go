@@ -0,0 +1,65 @@
+package mqtt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPingEncodeAndDecode(t *testing.T) {
+	resultPacket := encodeAndDecode(t, &PingreqPacket{FixedHeader: FixedHeader{Type: PINGREQ}}, Version311)
+	_, ok := resultPacket.(*PingreqPacket)
+	assert.Equal(t, true, ok)
+
+	resultPacket = encodeAndDecode(t, &PingrespPacket{FixedHeader: FixedHeader{Type: PINGRESP}}, Version311)
+	_, ok = resultPacket.(*PingrespPacket)
+	assert.Equal(t, true, ok)
+}
+
+func TestDisconnectEncodeAndDecode(t *testing.T) {
+	packet := &DisconnectPacket{
+		FixedHeader: FixedHeader{Type: DISCONNECT, Version: Version5},
+		ReasonCode:  SessionTakenOver,
+		Properties:  &Properties{ReasonString: "kicked"},
+	}
+	resultPacket := encodeAndDecode(t, packet, Version5)
+	resultDisconnectPacket, ok := resultPacket.(*DisconnectPacket)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, packet.ReasonCode, resultDisconnectPacket.ReasonCode)
+	assert.Equal(t, "kicked", resultDisconnectPacket.Properties.ReasonString)
+
+	// v3.1.1的DISCONNECT没有可变报头
+	var buf bytes.Buffer
+	err := (&DisconnectPacket{FixedHeader: FixedHeader{Type: DISCONNECT, Version: Version311}}).Encode(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xE0, 0x00}, buf.Bytes())
+}
+
+func TestAuthEncodeAndDecode(t *testing.T) {
+	packet := &AuthPacket{
+		FixedHeader: FixedHeader{Type: AUTH, Version: Version5},
+		ReasonCode:  ContinueAuthentication,
+		Properties:  &Properties{AuthMethod: "SCRAM-SHA-1", AuthData: []byte{1, 2, 3}},
+	}
+	resultPacket := encodeAndDecode(t, packet, Version5)
+	resultAuthPacket, ok := resultPacket.(*AuthPacket)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, packet.ReasonCode, resultAuthPacket.ReasonCode)
+	assert.Equal(t, packet.Properties.AuthMethod, resultAuthPacket.Properties.AuthMethod)
+	assert.Equal(t, packet.Properties.AuthData, resultAuthPacket.Properties.AuthData)
+}
+
+func TestReadFromMalformed(t *testing.T) {
+	// 剩余长度超过4个字节
+	_, err := ReadFrom(bytes.NewReader([]byte{0x30, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}))
+	assert.Error(t, err)
+
+	// 未知属性
+	_, err = ReadFromVersion(bytes.NewReader([]byte{0xE0, 0x03, 0x00, 0x01, 0x7F}), Version5)
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	// 保留的报文类型
+	_, err = ReadFrom(bytes.NewReader([]byte{0x00, 0x00}))
+	assert.ErrorIs(t, err, ErrUnknownPacketType)
+}
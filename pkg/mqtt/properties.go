@@ -0,0 +1,277 @@
+package mqtt
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// UserProperty 用户属性
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
+// Properties v5属性（可选的属性使用指针，nil表示不存在）
+type Properties struct {
+	PayloadFormat          *byte
+	MessageExpiry          *uint32
+	ContentType            string
+	ResponseTopic          string
+	CorrelationData        []byte
+	SubscriptionIdentifier []uint32 // PUBLISH里可以出现多个，SUBSCRIBE里最多一个
+	SessionExpiryInterval  *uint32
+	AssignedClientID       string
+	ServerKeepAlive        *uint16
+	AuthMethod             string
+	AuthData               []byte
+	RequestProblemInfo     *byte
+	WillDelayInterval      *uint32
+	RequestResponseInfo    *byte
+	ResponseInfo           string
+	ServerReference        string
+	ReasonString           string
+	ReceiveMaximum         *uint16
+	TopicAliasMaximum      *uint16
+	TopicAlias             *uint16
+	MaximumQoS             *byte
+	RetainAvailable        *byte
+	User                   []UserProperty
+	MaximumPacketSize      *uint32
+	WildcardSubAvailable   *byte
+	SubIDAvailable         *byte
+	SharedSubAvailable     *byte
+}
+
+// GetUser 获取第一个key匹配的用户属性
+func (p *Properties) GetUser(key string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	for _, u := range p.User {
+		if u.Key == key {
+			return u.Value, true
+		}
+	}
+	return "", false
+}
+
+// AddUser 添加用户属性
+func (p *Properties) AddUser(key, value string) {
+	p.User = append(p.User, UserProperty{Key: key, Value: value})
+}
+
+// encode 编码属性（包含属性长度）
+func (p *Properties) encode(b *bytes.Buffer) {
+	var props bytes.Buffer
+	if p != nil {
+		writeByteProp := func(id byte, v *byte) {
+			if v != nil {
+				props.WriteByte(id)
+				props.WriteByte(*v)
+			}
+		}
+		writeUint16Prop := func(id byte, v *uint16) {
+			if v != nil {
+				props.WriteByte(id)
+				writeUint16(&props, *v)
+			}
+		}
+		writeUint32Prop := func(id byte, v *uint32) {
+			if v != nil {
+				props.WriteByte(id)
+				writeUint32(&props, *v)
+			}
+		}
+		writeStringProp := func(id byte, v string) {
+			if v != "" {
+				props.WriteByte(id)
+				writeString(&props, v)
+			}
+		}
+		writeBinaryProp := func(id byte, v []byte) {
+			if len(v) > 0 {
+				props.WriteByte(id)
+				writeBinary(&props, v)
+			}
+		}
+		writeByteProp(PropPayloadFormat, p.PayloadFormat)
+		writeUint32Prop(PropMessageExpiry, p.MessageExpiry)
+		writeStringProp(PropContentType, p.ContentType)
+		writeStringProp(PropResponseTopic, p.ResponseTopic)
+		writeBinaryProp(PropCorrelationData, p.CorrelationData)
+		for _, id := range p.SubscriptionIdentifier {
+			props.WriteByte(PropSubscriptionIdentifier)
+			writeVarint(&props, id)
+		}
+		writeUint32Prop(PropSessionExpiryInterval, p.SessionExpiryInterval)
+		writeStringProp(PropAssignedClientID, p.AssignedClientID)
+		writeUint16Prop(PropServerKeepAlive, p.ServerKeepAlive)
+		writeStringProp(PropAuthMethod, p.AuthMethod)
+		writeBinaryProp(PropAuthData, p.AuthData)
+		writeByteProp(PropRequestProblemInfo, p.RequestProblemInfo)
+		writeUint32Prop(PropWillDelayInterval, p.WillDelayInterval)
+		writeByteProp(PropRequestResponseInfo, p.RequestResponseInfo)
+		writeStringProp(PropResponseInfo, p.ResponseInfo)
+		writeStringProp(PropServerReference, p.ServerReference)
+		writeStringProp(PropReasonString, p.ReasonString)
+		writeUint16Prop(PropReceiveMaximum, p.ReceiveMaximum)
+		writeUint16Prop(PropTopicAliasMaximum, p.TopicAliasMaximum)
+		writeUint16Prop(PropTopicAlias, p.TopicAlias)
+		writeByteProp(PropMaximumQoS, p.MaximumQoS)
+		writeByteProp(PropRetainAvailable, p.RetainAvailable)
+		for _, u := range p.User {
+			props.WriteByte(PropUserProperty)
+			writeString(&props, u.Key)
+			writeString(&props, u.Value)
+		}
+		writeUint32Prop(PropMaximumPacketSize, p.MaximumPacketSize)
+		writeByteProp(PropWildcardSubAvailable, p.WildcardSubAvailable)
+		writeByteProp(PropSubIDAvailable, p.SubIDAvailable)
+		writeByteProp(PropSharedSubAvailable, p.SharedSubAvailable)
+	}
+	writeVarint(b, uint32(props.Len()))
+	b.Write(props.Bytes())
+}
+
+// decodeProperties 解码属性（包含属性长度）
+func decodeProperties(d *decoder) (*Properties, error) {
+	length, err := d.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	if int(length) > d.len() {
+		return nil, ErrMalformed
+	}
+	pd := &decoder{buf: d.buf[d.pos : d.pos+int(length)]}
+	d.pos += int(length)
+
+	p := &Properties{}
+	for pd.len() > 0 {
+		id, err := pd.readByte()
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case PropPayloadFormat, PropRequestProblemInfo, PropRequestResponseInfo, PropMaximumQoS,
+			PropRetainAvailable, PropWildcardSubAvailable, PropSubIDAvailable, PropSharedSubAvailable:
+			v, err := pd.readByte()
+			if err != nil {
+				return nil, err
+			}
+			switch id {
+			case PropPayloadFormat:
+				p.PayloadFormat = &v
+			case PropRequestProblemInfo:
+				p.RequestProblemInfo = &v
+			case PropRequestResponseInfo:
+				p.RequestResponseInfo = &v
+			case PropMaximumQoS:
+				p.MaximumQoS = &v
+			case PropRetainAvailable:
+				p.RetainAvailable = &v
+			case PropWildcardSubAvailable:
+				p.WildcardSubAvailable = &v
+			case PropSubIDAvailable:
+				p.SubIDAvailable = &v
+			case PropSharedSubAvailable:
+				p.SharedSubAvailable = &v
+			}
+		case PropServerKeepAlive, PropReceiveMaximum, PropTopicAliasMaximum, PropTopicAlias:
+			v, err := pd.readUint16()
+			if err != nil {
+				return nil, err
+			}
+			switch id {
+			case PropServerKeepAlive:
+				p.ServerKeepAlive = &v
+			case PropReceiveMaximum:
+				p.ReceiveMaximum = &v
+			case PropTopicAliasMaximum:
+				p.TopicAliasMaximum = &v
+			case PropTopicAlias:
+				p.TopicAlias = &v
+			}
+		case PropMessageExpiry, PropSessionExpiryInterval, PropWillDelayInterval, PropMaximumPacketSize:
+			v, err := pd.readUint32()
+			if err != nil {
+				return nil, err
+			}
+			switch id {
+			case PropMessageExpiry:
+				p.MessageExpiry = &v
+			case PropSessionExpiryInterval:
+				p.SessionExpiryInterval = &v
+			case PropWillDelayInterval:
+				p.WillDelayInterval = &v
+			case PropMaximumPacketSize:
+				p.MaximumPacketSize = &v
+			}
+		case PropContentType, PropResponseTopic, PropAssignedClientID, PropAuthMethod,
+			PropResponseInfo, PropServerReference, PropReasonString:
+			v, err := pd.readString()
+			if err != nil {
+				return nil, err
+			}
+			switch id {
+			case PropContentType:
+				p.ContentType = v
+			case PropResponseTopic:
+				p.ResponseTopic = v
+			case PropAssignedClientID:
+				p.AssignedClientID = v
+			case PropAuthMethod:
+				p.AuthMethod = v
+			case PropResponseInfo:
+				p.ResponseInfo = v
+			case PropServerReference:
+				p.ServerReference = v
+			case PropReasonString:
+				p.ReasonString = v
+			}
+		case PropCorrelationData, PropAuthData:
+			v, err := pd.readBinary()
+			if err != nil {
+				return nil, err
+			}
+			if id == PropCorrelationData {
+				p.CorrelationData = v
+			} else {
+				p.AuthData = v
+			}
+		case PropSubscriptionIdentifier:
+			v, err := pd.readVarint()
+			if err != nil {
+				return nil, err
+			}
+			p.SubscriptionIdentifier = append(p.SubscriptionIdentifier, v)
+		case PropUserProperty:
+			key, err := pd.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := pd.readString()
+			if err != nil {
+				return nil, err
+			}
+			p.User = append(p.User, UserProperty{Key: key, Value: value})
+		default:
+			return nil, fmt.Errorf("%w: unknown property id 0x%02X", ErrMalformed, id)
+		}
+	}
+	return p, nil
+}
+
+// Uint16 返回uint16指针（方便设置属性）
+func Uint16(v uint16) *uint16 {
+	return &v
+}
+
+// Uint32 返回uint32指针（方便设置属性）
+func Uint32(v uint32) *uint32 {
+	return &v
+}
+
+// Byte 返回byte指针（方便设置属性）
+func Byte(v byte) *byte {
+	return &v
+}
@@ -1,8 +1,94 @@
 package mqtt
 
-import "io"
+import (
+	"fmt"
+	"io"
+)
 
+// ReadFrom 从r读取一个报文（默认按MQTT 3.1.1解码，CONNECT报文自带协议版本）
 func ReadFrom(r io.Reader) (ControlPacket, error) {
+	return ReadFromVersion(r, Version311)
+}
+
+// ReadFromVersion 按指定协议版本从r读取一个报文
+func ReadFromVersion(r io.Reader, version byte) (ControlPacket, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = byteReader{Reader: r}
+	}
+	b, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	remainingLen, err := readRemainingLength(br)
+	if err != nil {
+		return nil, err
+	}
+	packetType := PacketType(b >> 4)
+	cp := NewControlPacket(packetType)
+	if cp == nil {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownPacketType, packetType)
+	}
+	header := cp.GetFixedHeader()
+	header.Flags = b & 0x0F
+	header.Version = version
+	if err = cp.Decode(r, remainingLen); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// DecodePacketLength 解析buf开头的固定报头，返回整个报文的长度（固定报头+剩余长度），数据不完整时返回0
+func DecodePacketLength(buf []byte) (int, error) {
+	if len(buf) < 2 {
+		return 0, nil
+	}
+	var (
+		value      uint32
+		multiplier uint32 = 1
+	)
+	for i := 1; i < 5; i++ {
+		if i >= len(buf) {
+			return 0, nil
+		}
+		b := buf[i]
+		value += uint32(b&0x7F) * multiplier
+		if b&0x80 == 0 {
+			return i + 1 + int(value), nil
+		}
+		multiplier *= 128
+	}
+	return 0, ErrMalformed
+}
+
+func readRemainingLength(r io.ByteReader) (uint32, error) {
+	var (
+		value      uint32
+		multiplier uint32 = 1
+	)
+	for i := 0; i < 4; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value += uint32(b&0x7F) * multiplier
+		if b&0x80 == 0 {
+			return value, nil
+		}
+		multiplier *= 128
+	}
+	return 0, ErrMalformed
+}
+
+// byteReader 逐字节读取（避免预读多余的数据）
+type byteReader struct {
+	io.Reader
+}
 
-	return nil, nil
+func (b byteReader) ReadByte() (byte, error) {
+	var buf [1]byte
+	if _, err := io.ReadFull(b.Reader, buf[:]); err != nil {
+		return 0, err
+	}
+	return buf[0], nil
 }
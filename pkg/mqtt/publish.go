@@ -0,0 +1,192 @@
+package mqtt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// PublishPacket 发布消息报文
+type PublishPacket struct {
+	FixedHeader
+	TopicName  string      // 主题名
+	PacketID   uint16      // 报文标识符（QoS>0才有）
+	Properties *Properties // v5属性
+	Payload    []byte      // 消息内容
+}
+
+// DUP 重发标志
+func (p *PublishPacket) DUP() bool {
+	return p.Flags&0x08 != 0
+}
+
+// QoS 服务质量等级
+func (p *PublishPacket) QoS() byte {
+	return (p.Flags >> 1) & 0x03
+}
+
+// Retain 保留标志
+func (p *PublishPacket) Retain() bool {
+	return p.Flags&0x01 != 0
+}
+
+// SetFlags 设置标志
+func (p *PublishPacket) SetFlags(dup bool, qos byte, retain bool) {
+	var flags byte
+	if dup {
+		flags |= 0x08
+	}
+	flags |= (qos & 0x03) << 1
+	if retain {
+		flags |= 0x01
+	}
+	p.Flags = flags
+}
+
+// Encode 编码
+func (p *PublishPacket) Encode(w io.Writer) error {
+	var b bytes.Buffer
+	writeString(&b, p.TopicName)
+	if p.QoS() > 0 {
+		writeUint16(&b, p.PacketID)
+	}
+	if p.IsV5() {
+		p.Properties.encode(&b)
+	}
+	b.Write(p.Payload)
+	return writePacket(w, &p.FixedHeader, b.Bytes())
+}
+
+// Decode 解码
+func (p *PublishPacket) Decode(r io.Reader, remainingLen uint32) error {
+	if p.QoS() > 2 {
+		return fmt.Errorf("%w: invalid qos", ErrMalformed)
+	}
+	d, err := readBody(r, remainingLen)
+	if err != nil {
+		return err
+	}
+	if p.TopicName, err = d.readString(); err != nil {
+		return err
+	}
+	if p.QoS() > 0 {
+		if p.PacketID, err = d.readUint16(); err != nil {
+			return err
+		}
+		if p.PacketID == 0 {
+			return fmt.Errorf("%w: packet id must not be zero", ErrProtocolViolation)
+		}
+	}
+	if p.IsV5() {
+		if p.Properties, err = decodeProperties(d); err != nil {
+			return err
+		}
+	}
+	p.Payload = d.readRest()
+	return nil
+}
+
+func (p *PublishPacket) String() string {
+	return fmt.Sprintf("%s TopicName:%s PacketID:%d QoS:%d DUP:%v Retain:%v Payload:%d", p.FixedHeader.String(), p.TopicName, p.PacketID, p.QoS(), p.DUP(), p.Retain(), len(p.Payload))
+}
+
+// ackPacket PUBACK、PUBREC、PUBREL、PUBCOMP通用结构
+type ackPacket struct {
+	FixedHeader
+	PacketID   uint16      // 报文标识符
+	ReasonCode ReasonCode  // v5原因码
+	Properties *Properties // v5属性
+}
+
+// Encode 编码
+func (a *ackPacket) Encode(w io.Writer) error {
+	var b bytes.Buffer
+	writeUint16(&b, a.PacketID)
+	if a.IsV5() {
+		// 原因码为0且没有属性时可以省略
+		hasProps := a.Properties != nil && !isEmptyProperties(a.Properties)
+		if a.ReasonCode != Success || hasProps {
+			b.WriteByte(byte(a.ReasonCode))
+			if hasProps {
+				a.Properties.encode(&b)
+			}
+		}
+	}
+	return writePacket(w, &a.FixedHeader, b.Bytes())
+}
+
+// Decode 解码
+func (a *ackPacket) Decode(r io.Reader, remainingLen uint32) error {
+	d, err := readBody(r, remainingLen)
+	if err != nil {
+		return err
+	}
+	if a.PacketID, err = d.readUint16(); err != nil {
+		return err
+	}
+	if a.IsV5() {
+		if d.len() > 0 {
+			code, err := d.readByte()
+			if err != nil {
+				return err
+			}
+			a.ReasonCode = ReasonCode(code)
+		}
+		if d.len() > 0 {
+			if a.Properties, err = decodeProperties(d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (a *ackPacket) String() string {
+	return fmt.Sprintf("%s PacketID:%d ReasonCode:%d", a.FixedHeader.String(), a.PacketID, a.ReasonCode)
+}
+
+// PubackPacket QoS 1消息发布收到确认
+type PubackPacket struct {
+	ackPacket
+}
+
+// PubrecPacket 发布收到（QoS 2，第一步）
+type PubrecPacket struct {
+	ackPacket
+}
+
+// PubrelPacket 发布释放（QoS 2，第二步）
+type PubrelPacket struct {
+	ackPacket
+}
+
+// PubcompPacket 发布完成（QoS 2，第三步）
+type PubcompPacket struct {
+	ackPacket
+}
+
+// NewPuback 创建PUBACK
+func NewPuback(version byte, packetID uint16, reasonCode ReasonCode) *PubackPacket {
+	return &PubackPacket{ackPacket{FixedHeader: FixedHeader{Type: PUBACK, Version: version}, PacketID: packetID, ReasonCode: reasonCode}}
+}
+
+// NewPubrec 创建PUBREC
+func NewPubrec(version byte, packetID uint16, reasonCode ReasonCode) *PubrecPacket {
+	return &PubrecPacket{ackPacket{FixedHeader: FixedHeader{Type: PUBREC, Version: version}, PacketID: packetID, ReasonCode: reasonCode}}
+}
+
+// NewPubrel 创建PUBREL
+func NewPubrel(version byte, packetID uint16, reasonCode ReasonCode) *PubrelPacket {
+	return &PubrelPacket{ackPacket{FixedHeader: FixedHeader{Type: PUBREL, Flags: 0x02, Version: version}, PacketID: packetID, ReasonCode: reasonCode}}
+}
+
+// NewPubcomp 创建PUBCOMP
+func NewPubcomp(version byte, packetID uint16, reasonCode ReasonCode) *PubcompPacket {
+	return &PubcompPacket{ackPacket{FixedHeader: FixedHeader{Type: PUBCOMP, Version: version}, PacketID: packetID, ReasonCode: reasonCode}}
+}
+
+func isEmptyProperties(p *Properties) bool {
+	var b bytes.Buffer
+	p.encode(&b)
+	return b.Len() == 1
+}
@@ -0,0 +1,90 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishEncodeAndDecode(t *testing.T) {
+	for _, version := range []byte{Version311, Version5} {
+		packet := &PublishPacket{
+			FixedHeader: FixedHeader{Type: PUBLISH, Version: version},
+			TopicName:   "test/topic",
+			PacketID:    12,
+			Payload:     []byte("hello"),
+		}
+		packet.SetFlags(true, 1, true)
+		if version == Version5 {
+			packet.Properties = &Properties{
+				MessageExpiry:          Uint32(60),
+				SubscriptionIdentifier: []uint32{1, 300},
+			}
+			packet.Properties.AddUser("from_uid", "uid1")
+		}
+		resultPacket := encodeAndDecode(t, packet, version)
+		resultPublishPacket, ok := resultPacket.(*PublishPacket)
+		assert.Equal(t, true, ok)
+
+		assert.Equal(t, true, resultPublishPacket.DUP())
+		assert.Equal(t, byte(1), resultPublishPacket.QoS())
+		assert.Equal(t, true, resultPublishPacket.Retain())
+		assert.Equal(t, packet.TopicName, resultPublishPacket.TopicName)
+		assert.Equal(t, packet.PacketID, resultPublishPacket.PacketID)
+		assert.Equal(t, packet.Payload, resultPublishPacket.Payload)
+		if version == Version5 {
+			assert.Equal(t, uint32(60), *resultPublishPacket.Properties.MessageExpiry)
+			assert.Equal(t, []uint32{1, 300}, resultPublishPacket.Properties.SubscriptionIdentifier)
+			fromUID, _ := resultPublishPacket.Properties.GetUser("from_uid")
+			assert.Equal(t, "uid1", fromUID)
+		}
+	}
+}
+
+func TestPublishQoS0EncodeAndDecode(t *testing.T) {
+	packet := &PublishPacket{
+		FixedHeader: FixedHeader{Type: PUBLISH, Version: Version311},
+		TopicName:   "test",
+		Payload:     []byte("hello"),
+	}
+	resultPacket := encodeAndDecode(t, packet, Version311)
+	resultPublishPacket, ok := resultPacket.(*PublishPacket)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, byte(0), resultPublishPacket.QoS())
+	assert.Equal(t, uint16(0), resultPublishPacket.PacketID)
+	assert.Equal(t, packet.Payload, resultPublishPacket.Payload)
+}
+
+func TestPubackEncodeAndDecode(t *testing.T) {
+	for _, version := range []byte{Version311, Version5} {
+		reasonCodes := []ReasonCode{Success}
+		if version == Version5 {
+			reasonCodes = append(reasonCodes, NotAuthorized)
+		}
+		for _, reasonCode := range reasonCodes {
+			packets := []ControlPacket{
+				NewPuback(version, 1, reasonCode),
+				NewPubrec(version, 2, reasonCode),
+				NewPubrel(version, 3, reasonCode),
+				NewPubcomp(version, 4, reasonCode),
+			}
+			for i, packet := range packets {
+				resultPacket := encodeAndDecode(t, packet, version)
+				assert.Equal(t, packet.GetFixedHeader().Type, resultPacket.GetFixedHeader().Type)
+				var ack *ackPacket
+				switch p := resultPacket.(type) {
+				case *PubackPacket:
+					ack = &p.ackPacket
+				case *PubrecPacket:
+					ack = &p.ackPacket
+				case *PubrelPacket:
+					ack = &p.ackPacket
+				case *PubcompPacket:
+					ack = &p.ackPacket
+				}
+				assert.Equal(t, uint16(i+1), ack.PacketID)
+				assert.Equal(t, reasonCode, ack.ReasonCode)
+			}
+		}
+	}
+}
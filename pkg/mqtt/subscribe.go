@@ -0,0 +1,247 @@
+package mqtt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Subscription 订阅项
+type Subscription struct {
+	TopicFilter       string // 主题过滤器
+	QoS               byte   // 最大QoS
+	NoLocal           bool   // v5 不接收自己发布的消息
+	RetainAsPublished bool   // v5 保留发布时的retain标志
+	RetainHandling    byte   // v5 保留消息处理方式
+}
+
+// SubscribePacket 订阅报文
+type SubscribePacket struct {
+	FixedHeader
+	PacketID      uint16         // 报文标识符
+	Properties    *Properties    // v5属性
+	Subscriptions []Subscription // 订阅列表
+}
+
+// Encode 编码
+func (s *SubscribePacket) Encode(w io.Writer) error {
+	s.Flags = 0x02
+	var b bytes.Buffer
+	writeUint16(&b, s.PacketID)
+	if s.IsV5() {
+		s.Properties.encode(&b)
+	}
+	for _, sub := range s.Subscriptions {
+		writeString(&b, sub.TopicFilter)
+		options := sub.QoS & 0x03
+		if s.IsV5() {
+			if sub.NoLocal {
+				options |= 0x04
+			}
+			if sub.RetainAsPublished {
+				options |= 0x08
+			}
+			options |= (sub.RetainHandling & 0x03) << 4
+		}
+		b.WriteByte(options)
+	}
+	return writePacket(w, &s.FixedHeader, b.Bytes())
+}
+
+// Decode 解码
+func (s *SubscribePacket) Decode(r io.Reader, remainingLen uint32) error {
+	if s.Flags != 0x02 {
+		return fmt.Errorf("%w: invalid subscribe flags", ErrMalformed)
+	}
+	d, err := readBody(r, remainingLen)
+	if err != nil {
+		return err
+	}
+	if s.PacketID, err = d.readUint16(); err != nil {
+		return err
+	}
+	if s.IsV5() {
+		if s.Properties, err = decodeProperties(d); err != nil {
+			return err
+		}
+	}
+	for d.len() > 0 {
+		var sub Subscription
+		if sub.TopicFilter, err = d.readString(); err != nil {
+			return err
+		}
+		options, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		sub.QoS = options & 0x03
+		if s.IsV5() {
+			sub.NoLocal = options&0x04 != 0
+			sub.RetainAsPublished = options&0x08 != 0
+			sub.RetainHandling = (options >> 4) & 0x03
+			if options&0xC0 != 0 {
+				return fmt.Errorf("%w: reserved subscription options are set", ErrMalformed)
+			}
+		} else if options&0xFC != 0 {
+			return fmt.Errorf("%w: reserved subscription options are set", ErrMalformed)
+		}
+		if sub.QoS > 2 {
+			return fmt.Errorf("%w: invalid qos", ErrMalformed)
+		}
+		s.Subscriptions = append(s.Subscriptions, sub)
+	}
+	if len(s.Subscriptions) == 0 {
+		return fmt.Errorf("%w: subscribe without topic filters", ErrProtocolViolation)
+	}
+	return nil
+}
+
+func (s *SubscribePacket) String() string {
+	return fmt.Sprintf("%s PacketID:%d Subscriptions:%v", s.FixedHeader.String(), s.PacketID, s.Subscriptions)
+}
+
+// SubackPacket 订阅确认报文
+type SubackPacket struct {
+	FixedHeader
+	PacketID    uint16       // 报文标识符
+	Properties  *Properties  // v5属性
+	ReasonCodes []ReasonCode // 每个订阅项的结果（v3.1.1为返回码）
+}
+
+// Encode 编码
+func (s *SubackPacket) Encode(w io.Writer) error {
+	var b bytes.Buffer
+	writeUint16(&b, s.PacketID)
+	if s.IsV5() {
+		s.Properties.encode(&b)
+	}
+	for _, code := range s.ReasonCodes {
+		b.WriteByte(byte(code))
+	}
+	return writePacket(w, &s.FixedHeader, b.Bytes())
+}
+
+// Decode 解码
+func (s *SubackPacket) Decode(r io.Reader, remainingLen uint32) error {
+	d, err := readBody(r, remainingLen)
+	if err != nil {
+		return err
+	}
+	if s.PacketID, err = d.readUint16(); err != nil {
+		return err
+	}
+	if s.IsV5() {
+		if s.Properties, err = decodeProperties(d); err != nil {
+			return err
+		}
+	}
+	for _, code := range d.readRest() {
+		s.ReasonCodes = append(s.ReasonCodes, ReasonCode(code))
+	}
+	return nil
+}
+
+func (s *SubackPacket) String() string {
+	return fmt.Sprintf("%s PacketID:%d ReasonCodes:%v", s.FixedHeader.String(), s.PacketID, s.ReasonCodes)
+}
+
+// UnsubscribePacket 取消订阅报文
+type UnsubscribePacket struct {
+	FixedHeader
+	PacketID     uint16      // 报文标识符
+	Properties   *Properties // v5属性
+	TopicFilters []string    // 主题过滤器列表
+}
+
+// Encode 编码
+func (u *UnsubscribePacket) Encode(w io.Writer) error {
+	u.Flags = 0x02
+	var b bytes.Buffer
+	writeUint16(&b, u.PacketID)
+	if u.IsV5() {
+		u.Properties.encode(&b)
+	}
+	for _, topic := range u.TopicFilters {
+		writeString(&b, topic)
+	}
+	return writePacket(w, &u.FixedHeader, b.Bytes())
+}
+
+// Decode 解码
+func (u *UnsubscribePacket) Decode(r io.Reader, remainingLen uint32) error {
+	if u.Flags != 0x02 {
+		return fmt.Errorf("%w: invalid unsubscribe flags", ErrMalformed)
+	}
+	d, err := readBody(r, remainingLen)
+	if err != nil {
+		return err
+	}
+	if u.PacketID, err = d.readUint16(); err != nil {
+		return err
+	}
+	if u.IsV5() {
+		if u.Properties, err = decodeProperties(d); err != nil {
+			return err
+		}
+	}
+	for d.len() > 0 {
+		topic, err := d.readString()
+		if err != nil {
+			return err
+		}
+		u.TopicFilters = append(u.TopicFilters, topic)
+	}
+	if len(u.TopicFilters) == 0 {
+		return fmt.Errorf("%w: unsubscribe without topic filters", ErrProtocolViolation)
+	}
+	return nil
+}
+
+func (u *UnsubscribePacket) String() string {
+	return fmt.Sprintf("%s PacketID:%d TopicFilters:%v", u.FixedHeader.String(), u.PacketID, u.TopicFilters)
+}
+
+// UnsubackPacket 取消订阅确认报文
+type UnsubackPacket struct {
+	FixedHeader
+	PacketID    uint16       // 报文标识符
+	Properties  *Properties  // v5属性
+	ReasonCodes []ReasonCode // v5 每个主题过滤器的结果（v3.1.1没有）
+}
+
+// Encode 编码
+func (u *UnsubackPacket) Encode(w io.Writer) error {
+	var b bytes.Buffer
+	writeUint16(&b, u.PacketID)
+	if u.IsV5() {
+		u.Properties.encode(&b)
+		for _, code := range u.ReasonCodes {
+			b.WriteByte(byte(code))
+		}
+	}
+	return writePacket(w, &u.FixedHeader, b.Bytes())
+}
+
+// Decode 解码
+func (u *UnsubackPacket) Decode(r io.Reader, remainingLen uint32) error {
+	d, err := readBody(r, remainingLen)
+	if err != nil {
+		return err
+	}
+	if u.PacketID, err = d.readUint16(); err != nil {
+		return err
+	}
+	if u.IsV5() {
+		if u.Properties, err = decodeProperties(d); err != nil {
+			return err
+		}
+		for _, code := range d.readRest() {
+			u.ReasonCodes = append(u.ReasonCodes, ReasonCode(code))
+		}
+	}
+	return nil
+}
+
+func (u *UnsubackPacket) String() string {
+	return fmt.Sprintf("%s PacketID:%d ReasonCodes:%v", u.FixedHeader.String(), u.PacketID, u.ReasonCodes)
+}
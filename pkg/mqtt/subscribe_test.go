@@ -0,0 +1,84 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribeEncodeAndDecode(t *testing.T) {
+	for _, version := range []byte{Version311, Version5} {
+		packet := &SubscribePacket{
+			FixedHeader: FixedHeader{Type: SUBSCRIBE, Version: version},
+			PacketID:    10,
+			Subscriptions: []Subscription{
+				{TopicFilter: "a", QoS: 1},
+				{TopicFilter: "b", QoS: 2},
+			},
+		}
+		if version == Version5 {
+			packet.Properties = &Properties{SubscriptionIdentifier: []uint32{5}}
+			packet.Subscriptions[1].NoLocal = true
+			packet.Subscriptions[1].RetainHandling = 2
+		}
+		resultPacket := encodeAndDecode(t, packet, version)
+		resultSubscribePacket, ok := resultPacket.(*SubscribePacket)
+		assert.Equal(t, true, ok)
+
+		assert.Equal(t, packet.PacketID, resultSubscribePacket.PacketID)
+		assert.Equal(t, packet.Subscriptions, resultSubscribePacket.Subscriptions)
+		if version == Version5 {
+			assert.Equal(t, []uint32{5}, resultSubscribePacket.Properties.SubscriptionIdentifier)
+		}
+	}
+}
+
+func TestSubackEncodeAndDecode(t *testing.T) {
+	for _, version := range []byte{Version311, Version5} {
+		packet := &SubackPacket{
+			FixedHeader: FixedHeader{Type: SUBACK, Version: version},
+			PacketID:    10,
+			ReasonCodes: []ReasonCode{GrantedQoS1, SubscribeFailure},
+		}
+		resultPacket := encodeAndDecode(t, packet, version)
+		resultSubackPacket, ok := resultPacket.(*SubackPacket)
+		assert.Equal(t, true, ok)
+
+		assert.Equal(t, packet.PacketID, resultSubackPacket.PacketID)
+		assert.Equal(t, packet.ReasonCodes, resultSubackPacket.ReasonCodes)
+	}
+}
+
+func TestUnsubscribeEncodeAndDecode(t *testing.T) {
+	for _, version := range []byte{Version311, Version5} {
+		packet := &UnsubscribePacket{
+			FixedHeader:  FixedHeader{Type: UNSUBSCRIBE, Version: version},
+			PacketID:     11,
+			TopicFilters: []string{"a", "b"},
+		}
+		resultPacket := encodeAndDecode(t, packet, version)
+		resultUnsubscribePacket, ok := resultPacket.(*UnsubscribePacket)
+		assert.Equal(t, true, ok)
+
+		assert.Equal(t, packet.PacketID, resultUnsubscribePacket.PacketID)
+		assert.Equal(t, packet.TopicFilters, resultUnsubscribePacket.TopicFilters)
+	}
+}
+
+func TestUnsubackEncodeAndDecode(t *testing.T) {
+	for _, version := range []byte{Version311, Version5} {
+		packet := &UnsubackPacket{
+			FixedHeader: FixedHeader{Type: UNSUBACK, Version: version},
+			PacketID:    11,
+		}
+		if version == Version5 {
+			packet.ReasonCodes = []ReasonCode{Success, NoSubscriptionExisted}
+		}
+		resultPacket := encodeAndDecode(t, packet, version)
+		resultUnsubackPacket, ok := resultPacket.(*UnsubackPacket)
+		assert.Equal(t, true, ok)
+
+		assert.Equal(t, packet.PacketID, resultUnsubackPacket.PacketID)
+		assert.Equal(t, packet.ReasonCodes, resultUnsubackPacket.ReasonCodes)
+	}
+}
@@ -0,0 +1,150 @@
+package okauth
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// VerifierStore 校验通过api（/user/token）更新并存储的token（默认）
+	VerifierStore = "store"
+	// VerifierJWT 校验无状态的jwt（HS256/RS256）
+	VerifierJWT = "jwt"
+	// VerifierCallback 回调第三方的http或grpc接口校验
+	VerifierCallback = "callback"
+)
+
+// ErrAuthFail 认证失败（token错误、过期等）
+var ErrAuthFail = errors.New("okauth: auth fail")
+
+// Request 连接认证请求
+type Request struct {
+	UID        string `json:"uid"`         // 连接的uid
+	Token      string `json:"token"`       // 连接的token
+	DeviceFlag uint8  `json:"device_flag"` // 设备标识 0.app 1.web 2.pc
+	DeviceID   string `json:"device_id"`   // 设备ID
+	ClientIP   string `json:"client_ip"`   // 客户端ip
+}
+
+// Result 认证结果
+type Result struct {
+	DeviceLevel uint8 `json:"device_level"` // 设备等级 0.从设备 1.主设备
+	ExpireAt    int64 `json:"expire_at"`    // token的过期时间（10位时间戳），0表示不过期
+}
+
+// Verifier 连接认证
+type Verifier interface {
+	// Verify 认证连接 认证失败返回ErrAuthFail（或包装了ErrAuthFail的错误）
+	Verify(req *Request) (*Result, error)
+}
+
+// VerifierFunc 函数形式的认证
+type VerifierFunc func(req *Request) (*Result, error)
+
+func (f VerifierFunc) Verify(req *Request) (*Result, error) {
+	return f(req)
+}
+
+// Config 认证配置
+type Config struct {
+	JWT      JWTConfig
+	Callback CallbackConfig
+	// GetUserToken 获取存储的用户token和设备等级（store认证使用）
+	GetUserToken func(uid string, deviceFlag uint8) (string, uint8, error)
+}
+
+// JWTConfig jwt认证配置
+type JWTConfig struct {
+	Alg              string        // 签名算法 HS256或RS256
+	Secret           string        // HS256的密钥
+	SecretFile       string        // HS256的密钥文件（优先于Secret）
+	PublicKeyFile    string        // RS256的公钥文件（PEM格式，支持PKIX、PKCS1公钥和证书）
+	UIDClaim         string        // uid的claim 默认为sub，必须和连接的uid一致
+	DeviceLevelClaim string        // 设备等级的claim 默认为device_level，没有则为主设备
+	Issuer           string        // 不为空则校验iss
+	Audience         string        // 不为空则校验aud
+	Leeway           time.Duration // 校验exp和nbf时允许的时钟误差
+}
+
+// CallbackConfig 回调认证配置 两者配其一即可
+type CallbackConfig struct {
+	HTTPAddr string        // http回调地址 请求体为Request的json，返回200和Result的json表示认证成功
+	GRPCAddr string        // grpc回调地址（使用webhook的grpc协议，事件为user.auth） 如果此地址有值则不会再调用HTTPAddr
+	Timeout  time.Duration // 回调超时时间（认证在连接的事件循环里同步执行，回调接口需要尽快返回） 默认2秒
+}
+
+// NewConfig NewConfig
+func NewConfig() *Config {
+	return &Config{
+		JWT: JWTConfig{
+			Alg:              AlgHS256,
+			UIDClaim:         "sub",
+			DeviceLevelClaim: "device_level",
+		},
+		Callback: CallbackConfig{
+			Timeout: time.Second * 2,
+		},
+	}
+}
+
+// NewVerifierFunc 根据配置创建认证
+type NewVerifierFunc func(cfg *Config) (Verifier, error)
+
+var (
+	verifiersLock sync.RWMutex
+	verifiers     = map[string]NewVerifierFunc{}
+)
+
+func init() {
+	RegisterVerifier(VerifierStore, func(cfg *Config) (Verifier, error) {
+		return NewStoreVerifier(cfg.GetUserToken)
+	})
+	RegisterVerifier(VerifierJWT, func(cfg *Config) (Verifier, error) {
+		return NewJWTVerifier(cfg.JWT)
+	})
+	RegisterVerifier(VerifierCallback, func(cfg *Config) (Verifier, error) {
+		return NewCallbackVerifier(cfg.Callback)
+	})
+}
+
+// RegisterVerifier 注册认证方式，同名认证重复注册会panic
+func RegisterVerifier(name string, fnc NewVerifierFunc) {
+	verifiersLock.Lock()
+	defer verifiersLock.Unlock()
+	if fnc == nil {
+		panic("okauth: register verifier is nil")
+	}
+	if _, ok := verifiers[name]; ok {
+		panic(fmt.Sprintf("okauth: register called twice for verifier %s", name))
+	}
+	verifiers[name] = fnc
+}
+
+// Verifiers 已注册的认证方式名称（按名称排序）
+func Verifiers() []string {
+	verifiersLock.RLock()
+	defer verifiersLock.RUnlock()
+	names := make([]string, 0, len(verifiers))
+	for name := range verifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewVerifier 使用指定的认证方式创建认证，名称为空则使用store认证
+func NewVerifier(name string, cfg *Config) (Verifier, error) {
+	if name == "" {
+		name = VerifierStore
+	}
+	verifiersLock.RLock()
+	fnc, ok := verifiers[name]
+	verifiersLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("okauth: unknown verifier %s", name)
+	}
+	return fnc(cfg)
+}
@@ -0,0 +1,156 @@
+package okauth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func signJWT(t *testing.T, alg string, claims map[string]interface{}, sign func(signingString string) []byte) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	assert.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+	signingString := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingString + "." + base64.RawURLEncoding.EncodeToString(sign(signingString))
+}
+
+func TestJWTVerifierHS256(t *testing.T) {
+	secret := []byte("secret")
+	hs256 := func(signingString string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingString))
+		return mac.Sum(nil)
+	}
+	cfg := NewConfig().JWT
+	cfg.Secret = string(secret)
+	cfg.Issuer = "im"
+	verifier, err := NewVerifier(VerifierJWT, &Config{JWT: cfg})
+	assert.NoError(t, err)
+
+	exp := time.Now().Add(time.Hour).Unix()
+	token := signJWT(t, AlgHS256, map[string]interface{}{"sub": "u1", "exp": exp, "iss": "im", "device_level": 0}, hs256)
+	result, err := verifier.Verify(&Request{UID: "u1", Token: token})
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(0), result.DeviceLevel)
+	assert.Equal(t, exp, result.ExpireAt)
+
+	// 没有设备等级则为主设备
+	token = signJWT(t, AlgHS256, map[string]interface{}{"sub": "u1", "iss": "im"}, hs256)
+	result, err = verifier.Verify(&Request{UID: "u1", Token: token})
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(1), result.DeviceLevel)
+
+	fails := []string{
+		signJWT(t, AlgHS256, map[string]interface{}{"sub": "u2", "iss": "im"}, hs256),                                             // uid不一致
+		signJWT(t, AlgHS256, map[string]interface{}{"sub": "u1", "iss": "im", "exp": time.Now().Add(-time.Minute).Unix()}, hs256), // 已过期
+		signJWT(t, AlgHS256, map[string]interface{}{"sub": "u1", "iss": "other"}, hs256),                                          // 签发者不一致
+		signJWT(t, "none", map[string]interface{}{"sub": "u1", "iss": "im"}, func(string) []byte { return nil }),                  // 不接受其他算法
+		signJWT(t, AlgHS256, map[string]interface{}{"sub": "u1", "iss": "im"}, func(string) []byte { return []byte("bad") }),      // 签名错误
+		"not a jwt",
+	}
+	for _, token := range fails {
+		_, err = verifier.Verify(&Request{UID: "u1", Token: token})
+		assert.True(t, errors.Is(err, ErrAuthFail), token)
+	}
+}
+
+func TestJWTVerifierRS256(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	assert.NoError(t, err)
+	publicKeyFile := filepath.Join(t.TempDir(), "public.pem")
+	err = os.WriteFile(publicKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes}), 0644)
+	assert.NoError(t, err)
+
+	cfg := NewConfig().JWT
+	cfg.Alg = AlgRS256
+	cfg.PublicKeyFile = publicKeyFile
+	cfg.UIDClaim = "uid"
+	verifier, err := NewJWTVerifier(cfg)
+	assert.NoError(t, err)
+
+	rs256 := func(signingString string) []byte {
+		hashed := sha256.Sum256([]byte(signingString))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashed[:])
+		assert.NoError(t, err)
+		return signature
+	}
+	token := signJWT(t, AlgRS256, map[string]interface{}{"uid": "u1"}, rs256)
+	_, err = verifier.Verify(&Request{UID: "u1", Token: token})
+	assert.NoError(t, err)
+
+	// 用公钥当HS256的密钥伪造的token不能通过
+	forged := signJWT(t, AlgHS256, map[string]interface{}{"uid": "u1"}, func(signingString string) []byte {
+		mac := hmac.New(sha256.New, publicKeyBytes)
+		mac.Write([]byte(signingString))
+		return mac.Sum(nil)
+	})
+	_, err = verifier.Verify(&Request{UID: "u1", Token: forged})
+	assert.True(t, errors.Is(err, ErrAuthFail))
+}
+
+func TestStoreVerifier(t *testing.T) {
+	verifier, err := NewVerifier("", &Config{GetUserToken: func(uid string, deviceFlag uint8) (string, uint8, error) {
+		if uid == "u1" {
+			return "token1", 1, nil
+		}
+		return "", 0, nil
+	}})
+	assert.NoError(t, err)
+	result, err := verifier.Verify(&Request{UID: "u1", Token: "token1"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(1), result.DeviceLevel)
+	_, err = verifier.Verify(&Request{UID: "u1", Token: "token2"})
+	assert.True(t, errors.Is(err, ErrAuthFail))
+	_, err = verifier.Verify(&Request{UID: "u2", Token: ""})
+	assert.True(t, errors.Is(err, ErrAuthFail))
+}
+
+func TestCallbackVerifierHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		switch req.Token {
+		case "slave":
+			_, _ = w.Write([]byte(`{"device_level":0}`))
+		case "master":
+			w.WriteHeader(http.StatusOK)
+		case "error":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	verifier, err := NewVerifier(VerifierCallback, &Config{Callback: CallbackConfig{HTTPAddr: server.URL}})
+	assert.NoError(t, err)
+	result, err := verifier.Verify(&Request{UID: "u1", Token: "slave"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(0), result.DeviceLevel)
+	result, err = verifier.Verify(&Request{UID: "u1", Token: "master"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(1), result.DeviceLevel)
+	_, err = verifier.Verify(&Request{UID: "u1", Token: "wrong"})
+	assert.True(t, errors.Is(err, ErrAuthFail))
+	_, err = verifier.Verify(&Request{UID: "u1", Token: "error"})
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrAuthFail))
+}
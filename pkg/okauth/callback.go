@@ -0,0 +1,119 @@
+package okauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/exhook"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// EventUserAuth grpc回调认证的事件名
+const EventUserAuth = "user.auth"
+
+// CallbackVerifier 回调第三方的http或grpc接口认证，方便业务方自己实现认证逻辑（本地开发可以用一个简单的接口代替）
+// http：POST请求体为Request的json，返回200和Result的json表示认证成功（返回体为空则为主设备），返回401或403表示认证失败
+// grpc：使用webhook的grpc协议，事件为user.auth，data为Request的json，返回Success状态和Result的json表示认证成功，返回Error状态表示认证失败
+type CallbackVerifier struct {
+	cfg        CallbackConfig
+	httpClient *http.Client
+	grpcConn   *grpc.ClientConn
+}
+
+// NewCallbackVerifier NewCallbackVerifier
+func NewCallbackVerifier(cfg CallbackConfig) (*CallbackVerifier, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Second * 2
+	}
+	c := &CallbackVerifier{
+		cfg: cfg,
+	}
+	if strings.TrimSpace(cfg.GRPCAddr) != "" {
+		conn, err := grpc.Dial(cfg.GRPCAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, err
+		}
+		c.grpcConn = conn
+		return c, nil
+	}
+	if strings.TrimSpace(cfg.HTTPAddr) == "" {
+		return nil, errors.New("okauth: callback httpAddr and grpcAddr are both empty")
+	}
+	c.httpClient = &http.Client{
+		Timeout: cfg.Timeout,
+	}
+	return c, nil
+}
+
+func (c *CallbackVerifier) Verify(req *Request) (*Result, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if c.grpcConn != nil {
+		return c.verifyForGRPC(data)
+	}
+	return c.verifyForHTTP(data)
+}
+
+func (c *CallbackVerifier) verifyForHTTP(data []byte) (*Result, error) {
+	resp, err := c.httpClient.Post(c.cfg.HTTPAddr, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return decodeResult(body)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, fmt.Errorf("%w: callback status %d", ErrAuthFail, resp.StatusCode)
+	}
+	return nil, fmt.Errorf("okauth: callback status error [%d]", resp.StatusCode)
+}
+
+func (c *CallbackVerifier) verifyForGRPC(data []byte) (*Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
+	defer cancel()
+	resp, err := exhook.NewWebhookServiceClient(c.grpcConn).SendWebhook(ctx, &exhook.EventReq{
+		Event: EventUserAuth,
+		Data:  data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != exhook.EventStatus_Success {
+		return nil, fmt.Errorf("%w: callback status error", ErrAuthFail)
+	}
+	return decodeResult(resp.Data)
+}
+
+// Close 关闭grpc连接
+func (c *CallbackVerifier) Close() error {
+	if c.grpcConn != nil {
+		return c.grpcConn.Close()
+	}
+	return nil
+}
+
+func decodeResult(data []byte) (*Result, error) {
+	result := &Result{DeviceLevel: 1}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return result, nil
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
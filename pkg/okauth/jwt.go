@@ -0,0 +1,220 @@
+package okauth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// AlgHS256 HMAC SHA256
+	AlgHS256 = "HS256"
+	// AlgRS256 RSA PKCS1v15 SHA256
+	AlgRS256 = "RS256"
+)
+
+// JWTVerifier 校验无状态的jwt，不需要业务后台提前给每个设备注册token
+// 签名算法必须和配置一致（不接受token头里指定的其他算法），有exp、nbf则校验有效期
+type JWTVerifier struct {
+	cfg       JWTConfig
+	secret    []byte
+	publicKey *rsa.PublicKey
+	nowFnc    func() time.Time
+}
+
+// NewJWTVerifier NewJWTVerifier
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+	if cfg.UIDClaim == "" {
+		cfg.UIDClaim = "sub"
+	}
+	if cfg.DeviceLevelClaim == "" {
+		cfg.DeviceLevelClaim = "device_level"
+	}
+	j := &JWTVerifier{
+		cfg:    cfg,
+		nowFnc: time.Now,
+	}
+	switch cfg.Alg {
+	case AlgHS256:
+		j.secret = []byte(cfg.Secret)
+		if cfg.SecretFile != "" {
+			secret, err := os.ReadFile(cfg.SecretFile)
+			if err != nil {
+				return nil, err
+			}
+			j.secret = bytes.TrimSpace(secret)
+		}
+		if len(j.secret) == 0 {
+			return nil, errors.New("okauth: jwt secret is empty")
+		}
+	case AlgRS256:
+		if cfg.PublicKeyFile == "" {
+			return nil, errors.New("okauth: jwt public key file is empty")
+		}
+		data, err := os.ReadFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		j.publicKey, err = ParseRSAPublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("okauth: unsupported jwt alg %s", cfg.Alg)
+	}
+	return j, nil
+}
+
+func (j *JWTVerifier) Verify(req *Request) (*Result, error) {
+	parts := strings.Split(req.Token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: jwt format error", ErrAuthFail)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != j.cfg.Alg {
+		return nil, fmt.Errorf("%w: jwt alg %s not allowed", ErrAuthFail, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: jwt signature decode error", ErrAuthFail)
+	}
+	if err = j.verifySignature(parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+	claims := map[string]interface{}{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return j.verifyClaims(req, claims)
+}
+
+func (j *JWTVerifier) verifySignature(signingString string, signature []byte) error {
+	switch j.cfg.Alg {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, j.secret)
+		mac.Write([]byte(signingString))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return fmt.Errorf("%w: jwt signature verify fail", ErrAuthFail)
+		}
+	case AlgRS256:
+		hashed := sha256.Sum256([]byte(signingString))
+		if err := rsa.VerifyPKCS1v15(j.publicKey, crypto.SHA256, hashed[:], signature); err != nil {
+			return fmt.Errorf("%w: jwt signature verify fail", ErrAuthFail)
+		}
+	}
+	return nil
+}
+
+func (j *JWTVerifier) verifyClaims(req *Request, claims map[string]interface{}) (*Result, error) {
+	now := j.nowFnc()
+	leeway := j.cfg.Leeway
+	result := &Result{DeviceLevel: 1}
+
+	if uid, _ := claims[j.cfg.UIDClaim].(string); uid == "" || uid != req.UID {
+		return nil, fmt.Errorf("%w: jwt uid not match", ErrAuthFail)
+	}
+	if exp, ok := numberClaim(claims, "exp"); ok {
+		if now.After(time.Unix(exp, 0).Add(leeway)) {
+			return nil, fmt.Errorf("%w: jwt is expired", ErrAuthFail)
+		}
+		result.ExpireAt = exp
+	}
+	if nbf, ok := numberClaim(claims, "nbf"); ok {
+		if now.Add(leeway).Before(time.Unix(nbf, 0)) {
+			return nil, fmt.Errorf("%w: jwt is not valid yet", ErrAuthFail)
+		}
+	}
+	if j.cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != j.cfg.Issuer {
+			return nil, fmt.Errorf("%w: jwt issuer not match", ErrAuthFail)
+		}
+	}
+	if j.cfg.Audience != "" && !audienceContains(claims["aud"], j.cfg.Audience) {
+		return nil, fmt.Errorf("%w: jwt audience not match", ErrAuthFail)
+	}
+	if deviceLevel, ok := numberClaim(claims, j.cfg.DeviceLevelClaim); ok {
+		result.DeviceLevel = uint8(deviceLevel)
+	}
+	return result, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: jwt decode error", ErrAuthFail)
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: jwt decode error", ErrAuthFail)
+	}
+	return nil
+}
+
+func numberClaim(claims map[string]interface{}, name string) (int64, bool) {
+	v, ok := claims[name].(float64)
+	if !ok {
+		return 0, false
+	}
+	return int64(v), true
+}
+
+// aud可以是字符串或字符串数组
+func audienceContains(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, item := range v {
+			if s, _ := item.(string); s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseRSAPublicKey 解析PEM格式的RSA公钥（支持PKIX、PKCS1公钥和证书）
+func ParseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("okauth: public key is not pem format")
+	}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("okauth: certificate public key is not rsa")
+		}
+		return publicKey, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("okauth: public key is not rsa")
+	}
+	return publicKey, nil
+}
@@ -0,0 +1,36 @@
+package okauth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// StoreVerifier 校验通过api（/user/token）更新并存储的token
+type StoreVerifier struct {
+	getUserToken func(uid string, deviceFlag uint8) (string, uint8, error)
+}
+
+// NewStoreVerifier NewStoreVerifier
+func NewStoreVerifier(getUserToken func(uid string, deviceFlag uint8) (string, uint8, error)) (*StoreVerifier, error) {
+	if getUserToken == nil {
+		return nil, errors.New("okauth: store verifier need GetUserToken")
+	}
+	return &StoreVerifier{
+		getUserToken: getUserToken,
+	}, nil
+}
+
+func (s *StoreVerifier) Verify(req *Request) (*Result, error) {
+	if req.Token == "" {
+		return nil, fmt.Errorf("%w: token is empty", ErrAuthFail)
+	}
+	token, deviceLevel, err := s.getUserToken(req.UID, req.DeviceFlag)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(req.Token)) != 1 {
+		return nil, fmt.Errorf("%w: token verify fail", ErrAuthFail)
+	}
+	return &Result{DeviceLevel: deviceLevel}, nil
+}
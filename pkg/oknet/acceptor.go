@@ -24,9 +24,11 @@ type Acceptor struct {
 	listenPoller      *netpoll.Poller
 	listenWSPoller    *netpoll.Poller
 	listenWSSPoller   *netpoll.Poller
+	listenMQTTPoller  *netpoll.Poller
 	listen            *listener
 	listenWS          *listener // websocket
 	listenWSS         *listener // websocket
+	listenMQTT        *listener // mqtt
 	tcpRealListenAddr net.Addr  // tcp real listen addr
 	wsRealListenAddr  net.Addr  // websocket real listen addr
 
@@ -39,12 +41,13 @@ func NewAcceptor(eg *Engine) *Acceptor {
 		reactorSubs[i] = NewReactorSub(eg, i)
 	}
 	a := &Acceptor{
-		eg:              eg,
-		reactorSubs:     reactorSubs,
-		listenPoller:    netpoll.NewPoller("listenerPoller"),
-		listenWSPoller:  netpoll.NewPoller("listenWSPoller"),
-		listenWSSPoller: netpoll.NewPoller("listenWSSPoller"),
-		Log:             oklog.NewOKLog("Acceptor"),
+		eg:               eg,
+		reactorSubs:      reactorSubs,
+		listenPoller:     netpoll.NewPoller("listenerPoller"),
+		listenWSPoller:   netpoll.NewPoller("listenWSPoller"),
+		listenWSSPoller:  netpoll.NewPoller("listenWSSPoller"),
+		listenMQTTPoller: netpoll.NewPoller("listenMQTTPoller"),
+		Log:              oklog.NewOKLog("Acceptor"),
 	}
 
 	return a
@@ -89,6 +92,15 @@ func (a *Acceptor) start() error {
 			}
 		}()
 	}
+	if strings.TrimSpace(a.eg.options.MqttAddr) != "" {
+		wg.Add(1)
+		go func() {
+			err := a.initMQTTListener(wg)
+			if err != nil {
+				panic(err)
+			}
+		}()
+	}
 
 	wg.Wait()
 	return nil
@@ -133,6 +145,18 @@ func (a *Acceptor) Stop() error {
 		}
 	}
 
+	// -----------------mqtt-----------------
+	err = a.listenMQTTPoller.Close()
+	if err != nil {
+		a.Warn("listenMQTTPoller.Close() failed", zap.Error(err))
+	}
+	if a.listenMQTT != nil {
+		err = a.listenMQTT.Close()
+		if err != nil {
+			a.Warn("listenMQTT.Close() failed", zap.Error(err))
+		}
+	}
+
 	// -----------------reactor sub-----------------
 	for _, reactorSub := range a.reactorSubs {
 		reactorSub.Stop()
@@ -155,7 +179,7 @@ func (a *Acceptor) initTCPListener(wg *sync.WaitGroup) error {
 	wg.Done()
 
 	a.listenPoller.Polling(func(fd int, ev netpoll.PollEvent) error {
-		return a.acceptConn(fd, false, false)
+		return a.acceptConn(fd, listenerTCP)
 	})
 	return nil
 
@@ -174,7 +198,7 @@ func (a *Acceptor) initWSListener(wg *sync.WaitGroup) error {
 	}
 	wg.Done()
 	a.listenWSPoller.Polling(func(fd int, ev netpoll.PollEvent) error {
-		return a.acceptConn(fd, true, false)
+		return a.acceptConn(fd, listenerWS)
 	})
 	return nil
 }
@@ -192,12 +216,29 @@ func (a *Acceptor) initWSSListener(wg *sync.WaitGroup) error {
 	}
 	wg.Done()
 	a.listenWSSPoller.Polling(func(fd int, ev netpoll.PollEvent) error {
-		return a.acceptConn(fd, false, true)
+		return a.acceptConn(fd, listenerWSS)
 	})
 	return nil
 }
 
-func (a *Acceptor) acceptConn(listenFd int, ws bool, wss bool) error {
+func (a *Acceptor) initMQTTListener(wg *sync.WaitGroup) error {
+	// mqtt
+	a.listenMQTT = newListener(a.eg.options.MqttAddr, a.eg.options)
+	err := a.listenMQTT.init()
+	if err != nil {
+		return err
+	}
+	if err := a.listenMQTTPoller.AddRead(a.listenMQTT.fd); err != nil {
+		return fmt.Errorf("add mqtt listener fd to poller failed %s", err)
+	}
+	wg.Done()
+	a.listenMQTTPoller.Polling(func(fd int, ev netpoll.PollEvent) error {
+		return a.acceptConn(fd, listenerMQTT)
+	})
+	return nil
+}
+
+func (a *Acceptor) acceptConn(listenFd int, kind listenerKind) error {
 	var (
 		conn Conn
 		err  error
@@ -219,16 +260,20 @@ func (a *Acceptor) acceptConn(listenFd int, ws bool, wss bool) error {
 		a.Error("SetKeepAlivePeriod() failed", zap.Error(err))
 	}
 	subReactor := a.reactorSubByConnFd(connFd)
-	if wss {
+	switch kind {
+	case listenerWSS:
 		if conn, err = a.eg.eventHandler.OnNewWSSConn(a.eg.GenClientID(), newNetFd(connFd), a.wssRealAddr(), remoteAddr, a.eg, subReactor); err != nil {
 			return err
 		}
-	} else if ws {
+	case listenerWS:
 		if conn, err = a.eg.eventHandler.OnNewWSConn(a.eg.GenClientID(), newNetFd(connFd), a.wsRealAddr(), remoteAddr, a.eg, subReactor); err != nil {
 			return err
 		}
-	} else {
-
+	case listenerMQTT:
+		if conn, err = a.eg.eventHandler.OnNewMQTTConn(a.eg.GenClientID(), newNetFd(connFd), a.mqttRealAddr(), remoteAddr, a.eg, subReactor); err != nil {
+			return err
+		}
+	default:
 		if conn, err = a.eg.eventHandler.OnNewConn(a.eg.GenClientID(), newNetFd(connFd), a.tcpRealAddr(), remoteAddr, a.eg, subReactor); err != nil {
 			return err
 		}
@@ -257,3 +302,7 @@ func (a *Acceptor) wsRealAddr() net.Addr {
 func (a *Acceptor) wssRealAddr() net.Addr {
 	return a.listenWSS.realAddr
 }
+
+func (a *Acceptor) mqttRealAddr() net.Addr {
+	return a.listenMQTT.realAddr
+}
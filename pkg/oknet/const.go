@@ -6,3 +6,13 @@ var (
 	// ErrUnsupportedOp occurs when calling some methods that has not been implemented yet.
 	ErrUnsupportedOp = errors.New("unsupported operation")
 )
+
+// listenerKind 监听类型，决定接受的连接使用哪种协议
+type listenerKind uint8
+
+const (
+	listenerTCP  listenerKind = iota // tcp
+	listenerWS                       // websocket
+	listenerWSS                      // websocket + tls
+	listenerMQTT                     // mqtt
+)
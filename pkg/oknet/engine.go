@@ -119,6 +119,10 @@ func (e *Engine) WSSRealListenAddr() net.Addr {
 	return e.reactorMain.acceptor.wssRealAddr()
 }
 
+func (e *Engine) MQTTRealListenAddr() net.Addr {
+	return e.reactorMain.acceptor.mqttRealAddr()
+}
+
 func (e *Engine) OnConnect(onConnect OnConnect) {
 	e.eventHandler.OnConnect = onConnect
 }
@@ -0,0 +1,19 @@
+package oknet
+
+import "net"
+
+func CreateMQTTConn(id int64, connFd NetFd, localAddr, remoteAddr net.Addr, eg *Engine, reactorSub *ReactorSub) (Conn, error) {
+	defaultConn := GetDefaultConn(id, connFd, localAddr, remoteAddr, eg, reactorSub)
+	return NewMQTTConn(defaultConn), nil
+}
+
+// MQTTConn MQTT连接（读写和普通tcp连接一样，数据包由上层按MQTT协议编解码）
+type MQTTConn struct {
+	*DefaultConn
+}
+
+func NewMQTTConn(d *DefaultConn) *MQTTConn {
+	return &MQTTConn{
+		DefaultConn: d,
+	}
+}
@@ -16,6 +16,8 @@ type Options struct {
 	// WsAddr is the listen addr  example: ws://127.0.0.1:5200或 wss://127.0.0.1:5200
 	WsAddr  string
 	WssAddr string // wss addr
+	// MqttAddr is the mqtt listen addr  example: tcp://127.0.0.1:1883
+	MqttAddr string
 	// WSTlsConfig ws tls config
 	// MaxOpenFiles is the maximum number of open files that the server can
 	MaxOpenFiles int
@@ -68,6 +70,13 @@ func WithWSSAddr(v string) Option {
 	}
 }
 
+// WithMQTTAddr set mqtt listen addr
+func WithMQTTAddr(v string) Option {
+	return func(opts *Options) {
+		opts.MqttAddr = v
+	}
+}
+
 func WithTCPTLSConfig(v *tls.Config) Option {
 	return func(opts *Options) {
 		opts.TCPTLSConfig = v
@@ -0,0 +1,173 @@
+package oksearch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/oklog"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	postingsBucket = []byte("postings")
+	postingValue   = []byte{1}
+)
+
+// Config 索引配置
+type Config struct {
+	Dir       string // 索引目录
+	Tokenizer string // 分词器名称
+}
+
+// NewConfig NewConfig
+func NewConfig() *Config {
+	return &Config{
+		Dir:       "./search",
+		Tokenizer: TokenizerStandard,
+	}
+}
+
+// Document 需要索引的消息
+type Document struct {
+	ChannelID   string
+	ChannelType uint8
+	MessageSeq  uint32
+	Text        string
+}
+
+// Index 消息的倒排索引，按频道记录每个词出现的消息序号
+// key: 词 + 0x00 + 频道类型 + 频道ID + 0x00 + 消息序号（大端） 同一个词同一个频道的消息序号有序，可以按消息序号分页
+// 索引只增不删，搜索结果需要再校验消息是否存在以及内容是否匹配
+type Index struct {
+	cfg       *Config
+	db        *bolt.DB
+	tokenizer Tokenizer
+	oklog.Log
+}
+
+// NewIndex NewIndex
+func NewIndex(cfg *Config) (*Index, error) {
+	tokenizer, err := GetTokenizer(cfg.Tokenizer)
+	if err != nil {
+		return nil, err
+	}
+	return &Index{
+		cfg:       cfg,
+		tokenizer: tokenizer,
+		Log:       oklog.NewOKLog("SearchIndex"),
+	}, nil
+}
+
+// Open Open
+func (i *Index) Open() error {
+	err := os.MkdirAll(i.cfg.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	i.db, err = bolt.Open(filepath.Join(i.cfg.Dir, "index.db"), 0755, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return err
+	}
+	return i.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(postingsBucket)
+		return err
+	})
+}
+
+// Close Close
+func (i *Index) Close() error {
+	if i.db == nil {
+		return nil
+	}
+	return i.db.Close()
+}
+
+// Tokenizer 索引使用的分词器
+func (i *Index) Tokenizer() Tokenizer {
+	return i.tokenizer
+}
+
+// Add 给消息建立索引
+func (i *Index) Add(docs []*Document) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	return i.db.Batch(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(postingsBucket)
+		for _, doc := range docs {
+			for _, term := range i.tokenizer.Tokenize(doc.Text) {
+				if err := bucket.Put(postingKey(term, doc.ChannelID, doc.ChannelType, doc.MessageSeq), postingValue); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Search 在频道内搜索包含keyword所有分词的消息，按消息序号从大到小回调
+// startMessageSeq 从此消息序号往前搜索（不包含），0表示从最新的消息开始 fnc返回false则停止搜索
+func (i *Index) Search(channelID string, channelType uint8, keyword string, startMessageSeq uint32, fnc func(messageSeq uint32) bool) error {
+	terms := i.tokenizer.Tokenize(keyword)
+	if len(terms) == 0 {
+		return nil
+	}
+	return i.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(postingsBucket)
+		prefix := postingPrefix(terms[0], channelID, channelType)
+		cursor := bucket.Cursor()
+
+		var k []byte
+		if startMessageSeq == 0 {
+			seekKey := postingKey(terms[0], channelID, channelType, math.MaxUint32)
+			k, _ = cursor.Seek(seekKey)
+			if k == nil {
+				k, _ = cursor.Last()
+			} else if !bytes.Equal(k, seekKey) {
+				k, _ = cursor.Prev()
+			}
+		} else {
+			k, _ = cursor.Seek(postingKey(terms[0], channelID, channelType, startMessageSeq))
+			if k == nil {
+				k, _ = cursor.Last()
+			} else {
+				k, _ = cursor.Prev()
+			}
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Prev() {
+			if len(k) != len(prefix)+4 {
+				continue
+			}
+			messageSeq := binary.BigEndian.Uint32(k[len(prefix):])
+			match := true
+			for _, term := range terms[1:] {
+				if bucket.Get(postingKey(term, channelID, channelType, messageSeq)) == nil {
+					match = false
+					break
+				}
+			}
+			if match && !fnc(messageSeq) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func postingPrefix(term string, channelID string, channelType uint8) []byte {
+	key := make([]byte, 0, len(term)+len(channelID)+3)
+	key = append(key, term...)
+	key = append(key, 0)
+	key = append(key, channelType)
+	key = append(key, channelID...)
+	key = append(key, 0)
+	return key
+}
+
+func postingKey(term string, channelID string, channelType uint8, messageSeq uint32) []byte {
+	return binary.BigEndian.AppendUint32(postingPrefix(term, channelID, channelType), messageSeq)
+}
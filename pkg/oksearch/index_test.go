@@ -0,0 +1,59 @@
+package oksearch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStandardTokenize(t *testing.T) {
+	assert.Equal(t, []string{"hello", "world", "42"}, standardTokenize("Hello, WORLD! hello 42"))
+	assert.Equal(t, []string{"今天", "天天", "天气", "气不", "不错"}, standardTokenize("今天天气不错"))
+	assert.Equal(t, []string{"go", "语言", "很", "好"}, standardTokenize("go语言 很 好"))
+	assert.Empty(t, standardTokenize(" ,.!"))
+}
+
+func TestExtractText(t *testing.T) {
+	assert.Equal(t, "hello", ExtractText([]byte(`{"type":1,"content":"hello"}`)))
+	assert.Equal(t, "a b", ExtractText([]byte(`["a",1,{"x":"b"}]`)))
+	assert.Equal(t, "plain text", ExtractText([]byte("plain text")))
+	assert.Equal(t, "", ExtractText([]byte{0xff, 0xfe}))
+}
+
+func TestIndexSearch(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Dir = t.TempDir()
+	index, err := NewIndex(cfg)
+	assert.NoError(t, err)
+	err = index.Open()
+	assert.NoError(t, err)
+	defer index.Close()
+
+	err = index.Add([]*Document{
+		{ChannelID: "g1", ChannelType: 2, MessageSeq: 1, Text: "今天天气不错"},
+		{ChannelID: "g1", ChannelType: 2, MessageSeq: 2, Text: "明天天气怎么样"},
+		{ChannelID: "g1", ChannelType: 2, MessageSeq: 3, Text: "hello world"},
+		{ChannelID: "g1", ChannelType: 2, MessageSeq: 4, Text: "天气预报"},
+		{ChannelID: "g11", ChannelType: 2, MessageSeq: 5, Text: "天气"},
+		{ChannelID: "g1", ChannelType: 1, MessageSeq: 6, Text: "天气"},
+	})
+	assert.NoError(t, err)
+
+	search := func(keyword string, startMessageSeq uint32, limit int) []uint32 {
+		seqs := make([]uint32, 0)
+		err := index.Search("g1", 2, keyword, startMessageSeq, func(messageSeq uint32) bool {
+			seqs = append(seqs, messageSeq)
+			return len(seqs) < limit
+		})
+		assert.NoError(t, err)
+		return seqs
+	}
+	assert.Equal(t, []uint32{4, 2, 1}, search("天气", 0, 10))
+	assert.Equal(t, []uint32{4, 2}, search("天气", 0, 2))
+	assert.Equal(t, []uint32{2, 1}, search("天气", 4, 10))
+	assert.Equal(t, []uint32{1}, search("天气不错", 0, 10))
+	assert.Equal(t, []uint32{3}, search("HELLO", 0, 10))
+	assert.Empty(t, search("天气", 1, 10))
+	assert.Empty(t, search("下雨", 0, 10))
+	assert.Empty(t, search("", 0, 10))
+}
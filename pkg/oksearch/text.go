@@ -0,0 +1,46 @@
+package oksearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// ExtractText 提取消息内容里可搜索的文本
+// 内容是json则提取所有字符串类型的值（不包含key），否则内容是utf8文本则直接返回，其他情况（比如二进制内容）返回空
+func ExtractText(payload []byte) string {
+	if len(payload) == 0 {
+		return ""
+	}
+	trimPayload := bytes.TrimSpace(payload)
+	if len(trimPayload) > 0 && (trimPayload[0] == '{' || trimPayload[0] == '[') {
+		var value interface{}
+		if err := json.Unmarshal(trimPayload, &value); err == nil {
+			texts := make([]string, 0)
+			collectText(value, &texts)
+			return strings.Join(texts, " ")
+		}
+	}
+	if !utf8.Valid(payload) {
+		return ""
+	}
+	return string(payload)
+}
+
+func collectText(value interface{}, texts *[]string) {
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			*texts = append(*texts, v)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectText(item, texts)
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			collectText(item, texts)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package oksearch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	// TokenizerStandard 字母数字按单词分词（转小写），中日韩文字按二元组（bigram）分词
+	TokenizerStandard = "standard"
+	// TokenizerWhitespace 按空白分词（转小写）
+	TokenizerWhitespace = "whitespace"
+)
+
+// Tokenizer 分词器
+type Tokenizer interface {
+	// Tokenize 对文本分词，返回去重后的词
+	Tokenize(text string) []string
+}
+
+// TokenizerFunc 函数形式的分词器
+type TokenizerFunc func(text string) []string
+
+func (f TokenizerFunc) Tokenize(text string) []string {
+	return f(text)
+}
+
+var (
+	tokenizersLock sync.RWMutex
+	tokenizers     = map[string]Tokenizer{}
+)
+
+func init() {
+	RegisterTokenizer(TokenizerStandard, TokenizerFunc(standardTokenize))
+	RegisterTokenizer(TokenizerWhitespace, TokenizerFunc(whitespaceTokenize))
+}
+
+// RegisterTokenizer 注册分词器，同名分词器重复注册会panic
+func RegisterTokenizer(name string, tokenizer Tokenizer) {
+	tokenizersLock.Lock()
+	defer tokenizersLock.Unlock()
+	if tokenizer == nil {
+		panic("oksearch: register tokenizer is nil")
+	}
+	if _, ok := tokenizers[name]; ok {
+		panic(fmt.Sprintf("oksearch: register called twice for tokenizer %s", name))
+	}
+	tokenizers[name] = tokenizer
+}
+
+// GetTokenizer 获取分词器，名称为空则使用standard分词器
+func GetTokenizer(name string) (Tokenizer, error) {
+	if name == "" {
+		name = TokenizerStandard
+	}
+	tokenizersLock.RLock()
+	defer tokenizersLock.RUnlock()
+	tokenizer, ok := tokenizers[name]
+	if !ok {
+		return nil, fmt.Errorf("oksearch: unknown tokenizer %s", name)
+	}
+	return tokenizer, nil
+}
+
+// Tokenizers 已注册的分词器名称（按名称排序）
+func Tokenizers() []string {
+	tokenizersLock.RLock()
+	defer tokenizersLock.RUnlock()
+	names := make([]string, 0, len(tokenizers))
+	for name := range tokenizers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// standardTokenize 连续的字母数字为一个词，连续的中日韩文字两两一组（只有一个字则为单字）
+func standardTokenize(text string) []string {
+	tokens := newTokenSet()
+	var (
+		word []rune
+		cjk  []rune
+	)
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens.add(strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		if len(cjk) == 1 {
+			tokens.add(string(cjk))
+		}
+		for i := 0; i+1 < len(cjk); i++ {
+			tokens.add(string(cjk[i : i+2]))
+		}
+		cjk = cjk[:0]
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return tokens.list
+}
+
+func whitespaceTokenize(text string) []string {
+	tokens := newTokenSet()
+	for _, field := range strings.Fields(text) {
+		tokens.add(strings.ToLower(field))
+	}
+	return tokens.list
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// tokenSet 保持顺序的去重词集合
+type tokenSet struct {
+	list []string
+	seen map[string]struct{}
+}
+
+func newTokenSet() *tokenSet {
+	return &tokenSet{
+		list: make([]string, 0),
+		seen: map[string]struct{}{},
+	}
+}
+
+func (t *tokenSet) add(token string) {
+	if _, ok := t.seen[token]; ok {
+		return
+	}
+	t.seen[token] = struct{}{}
+	t.list = append(t.list, token)
+}
@@ -1,5 +1,7 @@
 package okstore
 
+import "time"
+
 type StoreConfig struct {
 	SlotNum                    int //
 	DataDir                    string
@@ -7,7 +9,12 @@ type StoreConfig struct {
 	EachMessagegMaxSizeOfBytes int
 	SegmentMaxBytes            int64 // each segment max size of bytes default 2G
 	DecodeMessageFnc           func(msg []byte) (Message, error)
-	StreamCacheSize            int // stream cache size
+	StreamCacheSize            int           // stream cache size
+	ExpireScanInterval         time.Duration // 扫描过期消息的间隔（删除消息全部过期的segment，并按保留策略压缩已加载的频道），0表示不扫描
+
+	Retention             RetentionPolicy           // 默认的消息保留策略
+	ChannelTypeRetentions map[uint8]RetentionPolicy // 频道类型的消息保留策略（优先于默认的保留策略）
+	ArchiveDir            string                    // 归档目录，不为空则超出保留策略的segment移动到归档目录，否则直接删除
 }
 
 func NewStoreConfig() *StoreConfig {
@@ -18,5 +25,15 @@ func NewStoreConfig() *StoreConfig {
 		EachMessagegMaxSizeOfBytes: 1024 * 1024 * 2, // 2M
 		SegmentMaxBytes:            1024 * 1024 * 1024 * 2,
 		StreamCacheSize:            40,
+		ExpireScanInterval:         time.Minute * 10,
+		ChannelTypeRetentions:      map[uint8]RetentionPolicy{},
+	}
+}
+
+// GetRetention 获取频道类型的消息保留策略
+func (s *StoreConfig) GetRetention(channelType uint8) RetentionPolicy {
+	if policy, ok := s.ChannelTypeRetentions[channelType]; ok {
+		return policy
 	}
+	return s.Retention
 }
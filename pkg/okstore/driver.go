@@ -0,0 +1,70 @@
+package okstore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// DriverFile 文件存储（默认）
+	DriverFile = "file"
+	// DriverMemory 内存存储，数据不持久化，适用于测试和临时部署
+	DriverMemory = "memory"
+)
+
+// NewStoreFunc 根据配置创建存储
+type NewStoreFunc func(cfg *StoreConfig) Store
+
+var (
+	driversLock sync.RWMutex
+	drivers     = map[string]NewStoreFunc{}
+)
+
+func init() {
+	RegisterDriver(DriverFile, func(cfg *StoreConfig) Store {
+		return NewFileStore(cfg)
+	})
+	RegisterDriver(DriverMemory, func(cfg *StoreConfig) Store {
+		return NewMemoryStore(cfg)
+	})
+}
+
+// RegisterDriver 注册存储驱动，同名驱动重复注册会panic
+func RegisterDriver(name string, fnc NewStoreFunc) {
+	driversLock.Lock()
+	defer driversLock.Unlock()
+	if fnc == nil {
+		panic("okstore: register driver is nil")
+	}
+	if _, ok := drivers[name]; ok {
+		panic(fmt.Sprintf("okstore: register called twice for driver %s", name))
+	}
+	drivers[name] = fnc
+}
+
+// Drivers 已注册的存储驱动名称（按名称排序）
+func Drivers() []string {
+	driversLock.RLock()
+	defer driversLock.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewStore 使用指定驱动创建存储，驱动为空则使用文件存储
+func NewStore(driver string, cfg *StoreConfig) (Store, error) {
+	if driver == "" {
+		driver = DriverFile
+	}
+	driversLock.RLock()
+	fnc, ok := drivers[driver]
+	driversLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("okstore: unknown driver %s", driver)
+	}
+	return fnc(cfg), nil
+}
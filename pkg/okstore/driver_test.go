@@ -0,0 +1,39 @@
+package okstore_test
+
+import (
+	"testing"
+
+	"github.com/samlau0508/imserver/pkg/okstore"
+	"github.com/samlau0508/imserver/pkg/okstore/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrivers(t *testing.T) {
+	drivers := okstore.Drivers()
+	assert.Contains(t, drivers, okstore.DriverFile)
+	assert.Contains(t, drivers, okstore.DriverMemory)
+
+	for _, driver := range drivers {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			storetest.RunStoreTests(t, func(cfg *okstore.StoreConfig) okstore.Store {
+				store, err := okstore.NewStore(driver, cfg)
+				assert.NoError(t, err)
+				return store
+			})
+		})
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	store, err := okstore.NewStore("", okstore.NewStoreConfig())
+	assert.NoError(t, err)
+	assert.IsType(t, &okstore.FileStore{}, store)
+
+	store, err = okstore.NewStore(okstore.DriverMemory, okstore.NewStoreConfig())
+	assert.NoError(t, err)
+	assert.IsType(t, &okstore.MemoryStore{}, store)
+
+	_, err = okstore.NewStore("unknown", okstore.NewStoreConfig())
+	assert.Error(t, err)
+}
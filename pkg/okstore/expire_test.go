@@ -0,0 +1,107 @@
+package okstore_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/okstore"
+	"github.com/samlau0508/imserver/pkg/okstore/storetest"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStoreRemoveExpiredSegments(t *testing.T) {
+	cfg := storetest.NewStoreConfig(t)
+	cfg.SegmentMaxBytes = 200
+	store := okstore.NewFileStore(cfg)
+	err := store.Open()
+	assert.NoError(t, err)
+
+	expiredAt := time.Now().Add(-time.Minute).Unix()
+	for i := 1; i <= 25; i++ {
+		msg := &storetest.Message{
+			MessageID: int64(i),
+			Payload:   []byte(fmt.Sprintf("msg%d", i)),
+		}
+		if i <= 20 {
+			msg.ExpireAt = expiredAt
+		}
+		_, err = store.AppendMessages("g1", okproto.ChannelTypeGroup, []okstore.Message{msg})
+		assert.NoError(t, err)
+	}
+	segmentCount := countSegments(t, cfg.DataDir)
+	assert.True(t, segmentCount > 2)
+
+	removed, err := store.RemoveExpiredSegments()
+	assert.NoError(t, err)
+	assert.True(t, removed > 0)
+	assert.Equal(t, segmentCount-removed, countSegments(t, cfg.DataDir))
+
+	assertLiveMessages := func(store okstore.Store) {
+		lastSeq, err := store.GetLastMsgSeq("g1", okproto.ChannelTypeGroup)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(25), lastSeq)
+
+		msgs, err := store.LoadNextRangeMsgs("g1", okproto.ChannelTypeGroup, 1, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, []uint32{21, 22, 23, 24, 25}, messageSeqs(msgs))
+
+		msgs, err = store.LoadPrevRangeMsgs("g1", okproto.ChannelTypeGroup, 25, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, []uint32{21, 22, 23, 24, 25}, messageSeqs(msgs))
+
+		msgs, err = store.LoadPrevRangeMsgs("g1", okproto.ChannelTypeGroup, 10, 0, 100)
+		assert.NoError(t, err)
+		assert.Empty(t, msgs)
+	}
+	assertLiveMessages(store)
+
+	// 重启后依然正确
+	err = store.Close()
+	assert.NoError(t, err)
+	store = okstore.NewFileStore(cfg)
+	err = store.Open()
+	assert.NoError(t, err)
+	defer store.Close()
+	assertLiveMessages(store)
+}
+
+func TestMemoryStoreRemoveExpiredMessages(t *testing.T) {
+	store := okstore.NewMemoryStore(storetest.NewStoreConfig(t))
+	expiredAt := time.Now().Add(-time.Minute).Unix()
+	_, err := store.AppendMessages("g1", okproto.ChannelTypeGroup, []okstore.Message{
+		&storetest.Message{MessageID: 1, ExpireAt: expiredAt},
+		&storetest.Message{MessageID: 2, ExpireAt: expiredAt},
+		&storetest.Message{MessageID: 3},
+		&storetest.Message{MessageID: 4, ExpireAt: expiredAt},
+	})
+	assert.NoError(t, err)
+
+	// 只删除最前面连续过期的消息
+	assert.Equal(t, 2, store.RemoveExpiredMessages())
+	_, err = store.LoadMsg("g1", okproto.ChannelTypeGroup, 1)
+	assert.Error(t, err)
+	msg, err := store.LoadMsg("g1", okproto.ChannelTypeGroup, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), msg.GetMessageID())
+
+	msgs, err := store.LoadNextRangeMsgs("g1", okproto.ChannelTypeGroup, 1, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{3}, messageSeqs(msgs))
+}
+
+func countSegments(t *testing.T, dataDir string) int {
+	files, err := filepath.Glob(filepath.Join(dataDir, "*", "topics", "*", "logs", "*.log"))
+	assert.NoError(t, err)
+	return len(files)
+}
+
+func messageSeqs(msgs []okstore.Message) []uint32 {
+	seqs := make([]uint32, 0, len(msgs))
+	for _, msg := range msgs {
+		seqs = append(seqs, msg.GetSeq())
+	}
+	return seqs
+}
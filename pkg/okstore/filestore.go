@@ -1,6 +1,7 @@
 package okstore
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"path/filepath"
@@ -33,6 +34,21 @@ type FileStore struct {
 	notifyQueuePrefix      string
 	userSeqPrefix          string
 	nodeInFlightDataPrefix string
+	messageExtraPrefix     string
+	messageEditPrefix      string
+	messageDeletePrefix    string
+	messageReaderPrefix    string
+	channelReadedSeqPrefix string
+	reactionPrefix         string
+	reactionSeqPrefix      string
+	reactionMaxSeqPrefix   string
+	threadPrefix           string
+	pinPrefix              string
+	retentionPrefix        string
+	userRateLimitPrefix    string
+	userLastSeenPrefix     string
+	apiKeyPrefix           string
+	apiKeyEnabledKey       string
 	systemUIDsKey          string
 	ipBlacklistKey         string
 
@@ -54,16 +70,33 @@ func NewFileStore(cfg *StoreConfig) *FileStore {
 		notifyQueuePrefix:         "notifyQueue",
 		userSeqPrefix:             "userSeq:",
 		nodeInFlightDataPrefix:    "nodeInFlightData",
+		messageExtraPrefix:        "messageExtra:",
+		messageEditPrefix:         "messageEdit:",
+		messageDeletePrefix:       "messageDelete:",
+		messageReaderPrefix:       "messageReader:",
+		channelReadedSeqPrefix:    "channelReadedSeq:",
+		reactionPrefix:            "reaction:",
+		reactionSeqPrefix:         "reactionSeq:",
+		reactionMaxSeqPrefix:      "reactionMaxSeq:",
+		threadPrefix:              "thread:",
+		pinPrefix:                 "pin:",
+		retentionPrefix:           "retention:",
+		userRateLimitPrefix:       "userRateLimit:",
+		userLastSeenPrefix:        "userLastSeen:",
+		apiKeyPrefix:              "apiKey:",
+		apiKeyEnabledKey:          "apiKeyEnabled",
 		systemUIDsKey:             "systemUIDs",
 		ipBlacklistKey:            "ipBlacklist",
 		FileStoreForMsg:           NewFileStoreForMsg(cfg),
 	}
+	f.FileStoreForMsg.retentionFnc = f.getRetention
 
 	return f
 }
 
 func (f *FileStore) Open() error {
 	f.lock.StartCleanLoop()
+	f.startExpireLoop()
 	var err error
 	f.db, err = bolt.Open(filepath.Join(f.cfg.DataDir, "im.db"), 0755, &bolt.Options{Timeout: 10 * time.Second})
 	if err != nil {
@@ -140,6 +173,48 @@ func (f *FileStore) UpdateUserToken(uid string, deviceFlag uint8, deviceLevel ui
 	})))
 }
 
+func (f *FileStore) SetUserRateLimit(uid string, limit *RateLimit) error {
+	slotNum := f.slotNum(uid)
+	key := []byte(f.getUserRateLimitKey(uid))
+	if limit == nil {
+		return f.delete(slotNum, key)
+	}
+	return f.set(slotNum, key, limit.Encode())
+}
+
+func (f *FileStore) GetUserRateLimit(uid string) (*RateLimit, error) {
+	slotNum := f.slotNum(uid)
+	value, err := f.get(slotNum, []byte(f.getUserRateLimitKey(uid)))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+	limit := &RateLimit{}
+	if err = limit.Decode(value); err != nil {
+		return nil, err
+	}
+	return limit, nil
+}
+
+func (f *FileStore) UpdateUserLastSeen(uid string, lastSeen int64) error {
+	slotNum := f.slotNum(uid)
+	return f.set(slotNum, []byte(f.getUserLastSeenKey(uid)), []byte(strconv.FormatInt(lastSeen, 10)))
+}
+
+func (f *FileStore) GetUserLastSeen(uid string) (int64, error) {
+	slotNum := f.slotNum(uid)
+	value, err := f.get(slotNum, []byte(f.getUserLastSeenKey(uid)))
+	if err != nil {
+		return 0, err
+	}
+	if len(value) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
 func (f *FileStore) SyncMessageOfUser(uid string, startMessageSeq uint32, limit int) ([]Message, error) {
 
 	fmt.Println("SyncMessageOfUser-startMessageSeq--->", uid, startMessageSeq, limit)
@@ -318,6 +393,8 @@ func (f *FileStore) AddSystemUIDs(uids []string) error {
 				}
 				list = append(list, values...)
 			}
+		} else {
+			list = append(list, uids...)
 		}
 		return bucket.Put([]byte(f.systemUIDsKey), []byte(strings.Join(list, ",")))
 	})
@@ -434,6 +511,97 @@ func (f *FileStore) GetIPBlacklist() ([]string, error) {
 	return ips, err
 }
 
+func (f *FileStore) AddOrUpdateAPIKey(apiKey *APIKey) error {
+	return f.db.Update(func(tx *bolt.Tx) error {
+		return f.getRootBucket(tx).Put([]byte(f.getAPIKeyKey(apiKey.ID)), apiKey.Encode())
+	})
+}
+
+func (f *FileStore) RemoveAPIKey(id string) error {
+	return f.db.Update(func(tx *bolt.Tx) error {
+		return f.getRootBucket(tx).Delete([]byte(f.getAPIKeyKey(id)))
+	})
+}
+
+func (f *FileStore) GetAPIKeys() ([]*APIKey, error) {
+	apiKeys := make([]*APIKey, 0)
+	err := f.db.View(func(tx *bolt.Tx) error {
+		prefix := []byte(f.apiKeyPrefix)
+		c := f.getRootBucket(tx).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			apiKey := &APIKey{}
+			if err := apiKey.Decode(v); err != nil {
+				return err
+			}
+			apiKeys = append(apiKeys, apiKey)
+		}
+		return nil
+	})
+	return apiKeys, err
+}
+
+func (f *FileStore) SetAPIKeyEnabled() error {
+	return f.db.Update(func(tx *bolt.Tx) error {
+		return f.getRootBucket(tx).Put([]byte(f.apiKeyEnabledKey), []byte("1"))
+	})
+}
+
+func (f *FileStore) GetAPIKeyEnabled() (bool, error) {
+	var enabled bool
+	err := f.db.View(func(tx *bolt.Tx) error {
+		enabled = len(f.getRootBucket(tx).Get([]byte(f.apiKeyEnabledKey))) > 0
+		return nil
+	})
+	return enabled, err
+}
+
+func (f *FileStore) AddOrUpdateInFlightMessages(messages []*InFlightMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	return f.db.Update(func(tx *bolt.Tx) error {
+		bucket := f.getRootBucket(tx)
+		for _, message := range messages {
+			if err := bucket.Put([]byte(f.getInFlightKey(message)), message.Encode()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (f *FileStore) RemoveInFlightMessages(messages []*InFlightMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	return f.db.Update(func(tx *bolt.Tx) error {
+		bucket := f.getRootBucket(tx)
+		for _, message := range messages {
+			if err := bucket.Delete([]byte(f.getInFlightKey(message))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (f *FileStore) GetInFlightMessages() ([]*InFlightMessage, error) {
+	messages := make([]*InFlightMessage, 0)
+	err := f.db.View(func(tx *bolt.Tx) error {
+		prefix := []byte(fmt.Sprintf("%s:", f.nodeInFlightDataPrefix))
+		c := f.getRootBucket(tx).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			message := &InFlightMessage{}
+			if err := message.Decode(v); err != nil {
+				return err
+			}
+			messages = append(messages, message)
+		}
+		return nil
+	})
+	return messages, err
+}
+
 func (f *FileStore) AddOrUpdateConversations(uid string, conversations []*Conversation) error {
 	newConversations, err := f.getNewConversations(uid, conversations)
 	if err != nil {
@@ -506,6 +674,399 @@ func (f *FileStore) DeleteConversation(uid string, channelID string, channelType
 	})
 }
 
+func (f *FileStore) SetChannelRetention(channelID string, channelType uint8, policy *RetentionPolicy) error {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	key := []byte(f.getRetentionKey(channelID, channelType))
+	if policy == nil {
+		return f.delete(slotNum, key)
+	}
+	return f.set(slotNum, key, policy.Encode())
+}
+
+func (f *FileStore) GetChannelRetention(channelID string, channelType uint8) (*RetentionPolicy, error) {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	value, err := f.get(slotNum, []byte(f.getRetentionKey(channelID, channelType)))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+	policy := &RetentionPolicy{}
+	if err = policy.Decode(value); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// getRetention 获取频道生效的消息保留策略 频道 > 频道类型 > 默认
+func (f *FileStore) getRetention(channelID string, channelType uint8) (RetentionPolicy, error) {
+	policy, err := f.GetChannelRetention(channelID, channelType)
+	if err != nil {
+		return RetentionPolicy{}, err
+	}
+	if policy != nil {
+		return *policy, nil
+	}
+	return f.cfg.GetRetention(channelType), nil
+}
+
+func (f *FileStore) AddOrUpdateMessageExtras(channelID string, channelType uint8, extras []*MessageExtra) error {
+	if len(extras) == 0 {
+		return nil
+	}
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	return f.db.Update(func(t *bolt.Tx) error {
+		bucket, err := f.getSlotBucket(slotNum, t)
+		if err != nil {
+			return err
+		}
+		for _, extra := range extras {
+			err = bucket.Put([]byte(f.getMessageExtraKey(channelID, channelType, extra.MessageSeq)), extra.Encode())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (f *FileStore) GetMessageExtras(channelID string, channelType uint8, messageSeqs []uint32) ([]*MessageExtra, error) {
+	if len(messageSeqs) == 0 {
+		return nil, nil
+	}
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	extras := make([]*MessageExtra, 0)
+	err := f.db.View(func(t *bolt.Tx) error {
+		bucket, err := f.getSlotBucket(slotNum, t)
+		if err != nil {
+			return err
+		}
+		for _, messageSeq := range messageSeqs {
+			value := bucket.Get([]byte(f.getMessageExtraKey(channelID, channelType, messageSeq)))
+			if len(value) == 0 {
+				continue
+			}
+			extra := &MessageExtra{}
+			if err = extra.Decode(value); err != nil {
+				return err
+			}
+			extras = append(extras, extra)
+		}
+		return nil
+	})
+	return extras, err
+}
+
+func (f *FileStore) AppendMessageEdit(channelID string, channelType uint8, edit *MessageEdit) error {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	return f.db.Update(func(t *bolt.Tx) error {
+		bucket, err := f.getSlotBucket(slotNum, t)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(f.getMessageEditKey(channelID, channelType, edit.MessageSeq, edit.EditVersion)), edit.Encode())
+	})
+}
+
+func (f *FileStore) GetMessageEdits(channelID string, channelType uint8, messageSeq uint32) ([]*MessageEdit, error) {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	edits := make([]*MessageEdit, 0)
+	err := f.db.View(func(t *bolt.Tx) error {
+		bucket, err := f.getSlotBucket(slotNum, t)
+		if err != nil {
+			return err
+		}
+		prefix := []byte(f.getMessageEditPrefix(channelID, channelType, messageSeq))
+		c := bucket.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			edit := &MessageEdit{}
+			if err = edit.Decode(v); err != nil {
+				return err
+			}
+			edits = append(edits, edit)
+		}
+		return nil
+	})
+	return edits, err
+}
+
+func (f *FileStore) AddMessageDeleteMarker(channelID string, channelType uint8, marker *MessageDeleteMarker) error {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	return f.db.Update(func(t *bolt.Tx) error {
+		bucket, err := f.getSlotBucket(slotNum, t)
+		if err != nil {
+			return err
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(f.getMessageDeleteKey(channelID, channelType, marker.UID, id)), marker.Encode())
+	})
+}
+
+func (f *FileStore) GetMessageDeleteMarkers(channelID string, channelType uint8, uid string) (MessageDeleteMarkers, error) {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	markers := make(MessageDeleteMarkers, 0)
+	uids := []string{""}
+	if uid != "" {
+		uids = append(uids, uid)
+	}
+	err := f.db.View(func(t *bolt.Tx) error {
+		bucket, err := f.getSlotBucket(slotNum, t)
+		if err != nil {
+			return err
+		}
+		c := bucket.Cursor()
+		for _, u := range uids {
+			prefix := []byte(f.getMessageDeletePrefix(channelID, channelType, u))
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				marker := &MessageDeleteMarker{}
+				if err = marker.Decode(v); err != nil {
+					return err
+				}
+				markers = append(markers, marker)
+			}
+		}
+		return nil
+	})
+	return markers, err
+}
+
+func (f *FileStore) AddMessageReaders(channelID string, channelType uint8, uid string, messageSeqs []uint32, readedAt int64) ([]uint32, error) {
+	if len(messageSeqs) == 0 {
+		return nil, nil
+	}
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	newMessageSeqs := make([]uint32, 0, len(messageSeqs))
+	err := f.db.Update(func(t *bolt.Tx) error {
+		bucket, err := f.getSlotBucket(slotNum, t)
+		if err != nil {
+			return err
+		}
+		for _, messageSeq := range messageSeqs {
+			key := []byte(f.getMessageReaderKey(channelID, channelType, messageSeq, uid))
+			if len(bucket.Get(key)) > 0 { // 已经读过了
+				continue
+			}
+			reader := &MessageReader{
+				UID:        uid,
+				MessageSeq: messageSeq,
+				ReadedAt:   readedAt,
+			}
+			if err = bucket.Put(key, reader.Encode()); err != nil {
+				return err
+			}
+			newMessageSeqs = append(newMessageSeqs, messageSeq)
+		}
+		return nil
+	})
+	return newMessageSeqs, err
+}
+
+func (f *FileStore) GetMessageReaders(channelID string, channelType uint8, messageSeq uint32) ([]*MessageReader, error) {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	readers := make([]*MessageReader, 0)
+	err := f.db.View(func(t *bolt.Tx) error {
+		bucket, err := f.getSlotBucket(slotNum, t)
+		if err != nil {
+			return err
+		}
+		prefix := []byte(f.getMessageReaderPrefix(channelID, channelType, messageSeq))
+		c := bucket.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			reader := &MessageReader{}
+			if err = reader.Decode(v); err != nil {
+				return err
+			}
+			readers = append(readers, reader)
+		}
+		return nil
+	})
+	return readers, err
+}
+
+// SetReaction 回应按回应序号存储（方便增量同步），另外记录用户对消息的表情的回应序号，回应变化时删除旧序号的记录
+func (f *FileStore) SetReaction(channelID string, channelType uint8, reaction *Reaction) (*Reaction, error) {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	var result *Reaction
+	err := f.db.Update(func(t *bolt.Tx) error {
+		bucket, err := f.getSlotBucket(slotNum, t)
+		if err != nil {
+			return err
+		}
+		key := []byte(f.getReactionKey(channelID, channelType, reaction.MessageID, reaction.UID, reaction.Emoji))
+		oldSeqValue := bucket.Get(key)
+		if len(oldSeqValue) > 0 {
+			oldSeqKey := []byte(f.getReactionSeqKey(channelID, channelType, binary.BigEndian.Uint64(oldSeqValue)))
+			oldReaction := &Reaction{}
+			if err = oldReaction.Decode(bucket.Get(oldSeqKey)); err != nil {
+				return err
+			}
+			if oldReaction.Deleted == reaction.Deleted {
+				return nil
+			}
+			if err = bucket.Delete(oldSeqKey); err != nil {
+				return err
+			}
+		} else if reaction.Deleted {
+			return nil
+		}
+		maxSeqKey := []byte(f.getReactionMaxSeqKey(channelID, channelType))
+		var seq uint64 = 1
+		if maxSeqValue := bucket.Get(maxSeqKey); len(maxSeqValue) > 0 {
+			seq = binary.BigEndian.Uint64(maxSeqValue) + 1
+		}
+		seqValue := binary.BigEndian.AppendUint64(nil, seq)
+		if err = bucket.Put(maxSeqKey, seqValue); err != nil {
+			return err
+		}
+		if err = bucket.Put(key, seqValue); err != nil {
+			return err
+		}
+		newReaction := *reaction
+		newReaction.Seq = seq
+		if err = bucket.Put([]byte(f.getReactionSeqKey(channelID, channelType, seq)), newReaction.Encode()); err != nil {
+			return err
+		}
+		result = &newReaction
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (f *FileStore) SyncReactions(channelID string, channelType uint8, seq uint64, limit int) ([]*Reaction, error) {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	reactions := make([]*Reaction, 0)
+	err := f.db.View(func(t *bolt.Tx) error {
+		bucket, err := f.getSlotBucket(slotNum, t)
+		if err != nil {
+			return err
+		}
+		prefix := []byte(f.getReactionSeqPrefix(channelID, channelType))
+		c := bucket.Cursor()
+		for k, v := c.Seek([]byte(f.getReactionSeqKey(channelID, channelType, seq+1))); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if limit > 0 && len(reactions) >= limit {
+				break
+			}
+			reaction := &Reaction{}
+			if err = reaction.Decode(v); err != nil {
+				return err
+			}
+			reactions = append(reactions, reaction)
+		}
+		return nil
+	})
+	return reactions, err
+}
+
+func (f *FileStore) AddOrUpdateThread(channelID string, channelType uint8, thread *Thread) error {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	return f.db.Update(func(t *bolt.Tx) error {
+		bucket, err := f.getSlotBucket(slotNum, t)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(f.getThreadKey(channelID, channelType, thread.RootMessageSeq)), thread.Encode())
+	})
+}
+
+func (f *FileStore) GetThreads(channelID string, channelType uint8, limit int) ([]*Thread, error) {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	threads := make([]*Thread, 0)
+	err := f.db.View(func(t *bolt.Tx) error {
+		bucket, err := f.getSlotBucket(slotNum, t)
+		if err != nil {
+			return err
+		}
+		prefix := []byte(f.getThreadPrefix(channelID, channelType))
+		c := bucket.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			thread := &Thread{}
+			if err = thread.Decode(v); err != nil {
+				return err
+			}
+			threads = append(threads, thread)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortThreads(threads)
+	if limit > 0 && len(threads) > limit {
+		threads = threads[:limit]
+	}
+	return threads, nil
+}
+
+func (f *FileStore) SetChannelPins(channelID string, channelType uint8, pins *ChannelPins) error {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	return f.set(slotNum, []byte(f.getPinKey(channelID, channelType)), pins.Encode())
+}
+
+func (f *FileStore) GetChannelPins(channelID string, channelType uint8) (*ChannelPins, error) {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	value, err := f.get(slotNum, []byte(f.getPinKey(channelID, channelType)))
+	if err != nil {
+		return nil, err
+	}
+	pins := &ChannelPins{}
+	if len(value) > 0 {
+		if err = pins.Decode(value); err != nil {
+			return nil, err
+		}
+	}
+	if pins.Pins == nil {
+		pins.Pins = make([]*Pin, 0)
+	}
+	return pins, nil
+}
+
+func (f *FileStore) GetChannelReadedSeq(uid string, channelID string, channelType uint8) (uint32, error) {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	var readedSeq uint32
+	err := f.db.View(func(t *bolt.Tx) error {
+		bucket, err := f.getSlotBucket(slotNum, t)
+		if err != nil {
+			return err
+		}
+		value := bucket.Get([]byte(f.getChannelReadedSeqKey(uid, channelID, channelType)))
+		if len(value) > 0 {
+			readedSeq64, _ := strconv.ParseUint(string(value), 10, 64)
+			readedSeq = uint32(readedSeq64)
+		}
+		return nil
+	})
+	return readedSeq, err
+}
+
+func (f *FileStore) UpdateChannelReadedSeqIfNeed(uid string, channelID string, channelType uint8, messageSeq uint32) (uint32, error) {
+	slotNum := f.slotNumForChannel(channelID, channelType)
+	var oldReadedSeq uint32
+	err := f.db.Update(func(t *bolt.Tx) error {
+		bucket, err := f.getSlotBucket(slotNum, t)
+		if err != nil {
+			return err
+		}
+		key := []byte(f.getChannelReadedSeqKey(uid, channelID, channelType))
+		value := bucket.Get(key)
+		if len(value) > 0 {
+			oldReadedSeq64, _ := strconv.ParseUint(string(value), 10, 64)
+			oldReadedSeq = uint32(oldReadedSeq64)
+		}
+		if messageSeq <= oldReadedSeq {
+			return nil
+		}
+		return bucket.Put(key, []byte(fmt.Sprintf("%d", messageSeq)))
+	})
+	return oldReadedSeq, err
+}
+
 func (f *FileStore) AppendMessageOfNotifyQueue(messages []Message) error {
 	return f.db.Update(func(t *bolt.Tx) error {
 		bucket := t.Bucket([]byte(f.notifyQueuePrefix))
@@ -651,6 +1212,86 @@ func (f *FileStore) getAllowlistKey(channelID string, channelType uint8) string
 	return fmt.Sprintf("%s%s-%d", f.allowlistPrefix, channelID, channelType)
 }
 
+func (f *FileStore) getMessageEditPrefix(channelID string, channelType uint8, messageSeq uint32) string {
+	return fmt.Sprintf("%s%s-%d:%010d:", f.messageEditPrefix, channelID, channelType, messageSeq)
+}
+
+func (f *FileStore) getMessageEditKey(channelID string, channelType uint8, messageSeq uint32, editVersion uint32) string {
+	return fmt.Sprintf("%s%010d", f.getMessageEditPrefix(channelID, channelType, messageSeq), editVersion)
+}
+
+func (f *FileStore) getInFlightKey(message *InFlightMessage) string {
+	return fmt.Sprintf("%s:%s:%s:%d", f.nodeInFlightDataPrefix, message.UID, message.DeviceID, message.MessageID)
+}
+
+func (f *FileStore) getMessageDeletePrefix(channelID string, channelType uint8, uid string) string {
+	return fmt.Sprintf("%s%s-%d:%s:", f.messageDeletePrefix, channelID, channelType, uid)
+}
+
+func (f *FileStore) getMessageDeleteKey(channelID string, channelType uint8, uid string, id uint64) string {
+	return fmt.Sprintf("%s%020d", f.getMessageDeletePrefix(channelID, channelType, uid), id)
+}
+
+func (f *FileStore) getMessageReaderPrefix(channelID string, channelType uint8, messageSeq uint32) string {
+	return fmt.Sprintf("%s%s-%d:%010d:", f.messageReaderPrefix, channelID, channelType, messageSeq)
+}
+
+func (f *FileStore) getMessageReaderKey(channelID string, channelType uint8, messageSeq uint32, uid string) string {
+	return fmt.Sprintf("%s%s", f.getMessageReaderPrefix(channelID, channelType, messageSeq), uid)
+}
+
+func (f *FileStore) getReactionKey(channelID string, channelType uint8, messageID int64, uid string, emoji string) string {
+	return fmt.Sprintf("%s%s-%d:%d:%s:%s", f.reactionPrefix, channelID, channelType, messageID, uid, emoji)
+}
+
+func (f *FileStore) getReactionSeqPrefix(channelID string, channelType uint8) string {
+	return fmt.Sprintf("%s%s-%d:", f.reactionSeqPrefix, channelID, channelType)
+}
+
+func (f *FileStore) getReactionSeqKey(channelID string, channelType uint8, seq uint64) string {
+	return fmt.Sprintf("%s%020d", f.getReactionSeqPrefix(channelID, channelType), seq)
+}
+
+func (f *FileStore) getReactionMaxSeqKey(channelID string, channelType uint8) string {
+	return fmt.Sprintf("%s%s-%d", f.reactionMaxSeqPrefix, channelID, channelType)
+}
+
+func (f *FileStore) getThreadPrefix(channelID string, channelType uint8) string {
+	return fmt.Sprintf("%s%s-%d:", f.threadPrefix, channelID, channelType)
+}
+
+func (f *FileStore) getThreadKey(channelID string, channelType uint8, rootMessageSeq uint32) string {
+	return fmt.Sprintf("%s%010d", f.getThreadPrefix(channelID, channelType), rootMessageSeq)
+}
+
+func (f *FileStore) getPinKey(channelID string, channelType uint8) string {
+	return fmt.Sprintf("%s%s-%d", f.pinPrefix, channelID, channelType)
+}
+
+func (f *FileStore) getChannelReadedSeqKey(uid string, channelID string, channelType uint8) string {
+	return fmt.Sprintf("%s%s-%d:%s", f.channelReadedSeqPrefix, channelID, channelType, uid)
+}
+
+func (f *FileStore) getMessageExtraKey(channelID string, channelType uint8, messageSeq uint32) string {
+	return fmt.Sprintf("%s%s-%d:%010d", f.messageExtraPrefix, channelID, channelType, messageSeq)
+}
+
+func (f *FileStore) getRetentionKey(channelID string, channelType uint8) string {
+	return fmt.Sprintf("%s%s-%d", f.retentionPrefix, channelID, channelType)
+}
+
+func (f *FileStore) getAPIKeyKey(id string) string {
+	return fmt.Sprintf("%s%s", f.apiKeyPrefix, id)
+}
+
+func (f *FileStore) getUserRateLimitKey(uid string) string {
+	return fmt.Sprintf("%s%s", f.userRateLimitPrefix, uid)
+}
+
+func (f *FileStore) getUserLastSeenKey(uid string) string {
+	return fmt.Sprintf("%s%s", f.userLastSeenPrefix, uid)
+}
+
 func (f *FileStore) getMessageOfUserCursorKey(uid string) string {
 	return fmt.Sprintf("%s%s", f.messageOfUserCursorPrefix, uid)
 }
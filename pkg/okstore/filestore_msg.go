@@ -2,12 +2,18 @@ package okstore
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/samlau0508/imserver/pkg/oklog"
 	"github.com/samlau0508/imserver/pkg/okutil"
 	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"go.uber.org/zap"
 )
 
 var segmentCache *lru.Cache[string, *segment]
@@ -26,7 +32,10 @@ type FileStoreForMsg struct {
 	cfg     *StoreConfig
 	slotMap map[uint32]*slot
 	oklog.Log
+	stopChan    chan struct{}
 	slotMapLock sync.RWMutex
+	// retentionFnc 获取频道的消息保留策略
+	retentionFnc func(channelID string, channelType uint8) (RetentionPolicy, error)
 }
 
 func NewFileStoreForMsg(cfg *StoreConfig) *FileStoreForMsg {
@@ -36,6 +45,9 @@ func NewFileStoreForMsg(cfg *StoreConfig) *FileStoreForMsg {
 		slotMap: make(map[uint32]*slot),
 		Log:     oklog.NewOKLog("FileStoreForMsg"),
 	}
+	f.retentionFnc = func(channelID string, channelType uint8) (RetentionPolicy, error) {
+		return cfg.GetRetention(channelType), nil
+	}
 	return f
 }
 
@@ -58,32 +70,15 @@ func (f *FileStoreForMsg) LoadMsg(channelID string, channelType uint8, messageSe
 }
 
 func (f *FileStoreForMsg) LoadLastMsgs(channelID string, channelType uint8, limit int) ([]Message, error) {
-	var messages = make([]Message, 0, limit)
-	tp := f.getTopic(channelID, channelType)
-	err := tp.readLastMessages(uint64(limit), func(message Message) error {
-		messages = append(messages, message)
-		return nil
-	})
-	if err != nil {
-		return nil, err
-	}
-	return messages, nil
+	return f.LoadLastMsgsWithEnd(channelID, channelType, 0, limit)
 }
 
 func (f *FileStoreForMsg) LoadLastMsgsWithEnd(channelID string, channelType uint8, endMessageSeq uint32, limit int) ([]Message, error) {
-	var messages = make([]Message, 0, limit)
-	tp := f.getTopic(channelID, channelType)
-	err := tp.readLastMessages(uint64(limit), func(message Message) error {
-		if endMessageSeq != 0 && message.GetSeq() <= endMessageSeq {
-			return nil
-		}
-		messages = append(messages, message)
-		return nil
-	})
-	if err != nil {
-		return nil, err
+	lastMsgSeq := f.getTopic(channelID, channelType).getLastMsgSeq()
+	if lastMsgSeq == 0 || lastMsgSeq <= endMessageSeq {
+		return make([]Message, 0), nil
 	}
-	return messages, nil
+	return f.LoadPrevRangeMsgs(channelID, channelType, lastMsgSeq, endMessageSeq, limit)
 }
 
 func (f *FileStoreForMsg) GetLastMsgSeq(channelID string, channelType uint8) (uint32, error) {
@@ -91,10 +86,48 @@ func (f *FileStoreForMsg) GetLastMsgSeq(channelID string, channelType uint8) (ui
 	return f.getTopic(channelID, channelType).getLastMsgSeq(), nil
 }
 
+// LoadPrevRangeMsgs 过期的消息不返回，过滤掉过期消息后不够limit会继续向上加载
 func (f *FileStoreForMsg) LoadPrevRangeMsgs(channelID string, channelType uint8, startMessageSeq, endMessageSeq uint32, limit int) ([]Message, error) {
 	if startMessageSeq == 0 {
 		return nil, fmt.Errorf("start messageSeq must be greater than 0")
 	}
+	tp := f.getTopic(channelID, channelType)
+	messages := make([]Message, 0, limit)
+	for startMessageSeq > endMessageSeq && len(messages) < limit {
+		rangeMessages, err := f.readPrevRangeMsgs(tp, startMessageSeq, endMessageSeq, limit-len(messages))
+		if err != nil {
+			return nil, err
+		}
+		if len(rangeMessages) == 0 {
+			break
+		}
+		messages = append(filterExpiredMessages(rangeMessages), messages...)
+		startMessageSeq = rangeMessages[0].GetSeq() - 1
+	}
+	return messages, nil
+}
+
+// LoadNextRangeMsgs 过期的消息不返回，过滤掉过期消息后不够limit会继续向下加载
+func (f *FileStoreForMsg) LoadNextRangeMsgs(channelID string, channelType uint8, startMessageSeq, endMessageSeq uint32, limit int) ([]Message, error) {
+	tp := f.getTopic(channelID, channelType)
+	messages := make([]Message, 0, limit)
+	for len(messages) < limit {
+		readLimit := limit - len(messages)
+		rangeMessages, err := f.readNextRangeMsgs(tp, startMessageSeq, endMessageSeq, readLimit)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, filterExpiredMessages(rangeMessages)...)
+		if len(rangeMessages) < readLimit { // 没有更多消息了
+			break
+		}
+		startMessageSeq = rangeMessages[len(rangeMessages)-1].GetSeq() + 1
+	}
+	return messages, nil
+}
+
+// readPrevRangeMsgs 向上读取消息（包含过期的消息）
+func (f *FileStoreForMsg) readPrevRangeMsgs(tp *topic, startMessageSeq, endMessageSeq uint32, limit int) ([]Message, error) {
 	actLimit := limit
 	var actStartMessageSeq uint32
 	if startMessageSeq < uint32(limit) {
@@ -104,7 +137,6 @@ func (f *FileStoreForMsg) LoadPrevRangeMsgs(channelID string, channelType uint8,
 		actStartMessageSeq = startMessageSeq - uint32(limit) + 1
 	}
 
-	tp := f.getTopic(channelID, channelType)
 	var messages = make([]Message, 0, limit)
 	err := tp.readMessages(actStartMessageSeq, uint64(actLimit), func(message Message) error {
 		if endMessageSeq != 0 && message.GetSeq() <= endMessageSeq {
@@ -116,9 +148,12 @@ func (f *FileStoreForMsg) LoadPrevRangeMsgs(channelID string, channelType uint8,
 	return messages, err
 }
 
-func (f *FileStoreForMsg) LoadNextRangeMsgs(channelID string, channelType uint8, startMessageSeq, endMessageSeq uint32, limit int) ([]Message, error) {
+// readNextRangeMsgs 向下读取消息（包含过期的消息）
+func (f *FileStoreForMsg) readNextRangeMsgs(tp *topic, startMessageSeq, endMessageSeq uint32, limit int) ([]Message, error) {
+	if firstBaseMessageSeq := tp.getFirstBaseMessageSeq(); startMessageSeq <= firstBaseMessageSeq { // 前面的segment已被删除，从最早的消息开始加载
+		startMessageSeq = firstBaseMessageSeq + 1
+	}
 	var messages = make([]Message, 0, limit)
-	tp := f.getTopic(channelID, channelType)
 	err := tp.readMessages(startMessageSeq, uint64(limit), func(message Message) error {
 		if endMessageSeq != 0 && message.GetSeq() >= endMessageSeq {
 			return nil
@@ -129,6 +164,152 @@ func (f *FileStoreForMsg) LoadNextRangeMsgs(channelID string, channelType uint8,
 	return messages, err
 }
 
+// RemoveExpiredSegments 删除消息全部过期的segment，返回删除的segment数量
+// 只扫描已加载的topic
+func (f *FileStoreForMsg) RemoveExpiredSegments() (int, error) {
+	now := time.Now().Unix()
+	removed := 0
+	for _, tp := range f.loadedTopics() {
+		n, err := tp.removeExpiredSegments(now)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// CompactChannel 按保留策略压缩频道的消息
+func (f *FileStoreForMsg) CompactChannel(channelID string, channelType uint8) (*CompactResult, error) {
+	return f.compactTopic(f.getTopic(channelID, channelType))
+}
+
+// Compact 按保留策略压缩所有频道的消息（包括未加载的频道）
+func (f *FileStoreForMsg) Compact() (*CompactResult, error) {
+	topicNames, err := f.allTopicNames()
+	if err != nil {
+		return nil, err
+	}
+	result := &CompactResult{}
+	for _, topicName := range topicNames {
+		channelID, channelType, ok := parseTopicName(topicName)
+		if !ok {
+			continue
+		}
+		topicResult, err := f.CompactChannel(channelID, channelType)
+		if err != nil {
+			return result, err
+		}
+		result.add(topicResult)
+	}
+	return result, nil
+}
+
+// compactLoadedTopics 按保留策略压缩已加载的频道
+func (f *FileStoreForMsg) compactLoadedTopics() (*CompactResult, error) {
+	result := &CompactResult{}
+	for _, tp := range f.loadedTopics() {
+		topicResult, err := f.compactTopic(tp)
+		if err != nil {
+			return result, err
+		}
+		result.add(topicResult)
+	}
+	return result, nil
+}
+
+func (f *FileStoreForMsg) compactTopic(tp *topic) (*CompactResult, error) {
+	var policy RetentionPolicy
+	if channelID, channelType, ok := parseTopicName(tp.name); ok {
+		var err error
+		policy, err = f.retentionFnc(channelID, channelType)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tp.compact(policy, time.Now().Unix())
+}
+
+func (f *FileStoreForMsg) loadedTopics() []*topic {
+	f.slotMapLock.RLock()
+	slots := make([]*slot, 0, len(f.slotMap))
+	for _, s := range f.slotMap {
+		slots = append(slots, s)
+	}
+	f.slotMapLock.RUnlock()
+
+	topics := make([]*topic, 0)
+	for _, s := range slots {
+		for _, key := range s.topicCache.Keys() {
+			tp, ok := s.topicCache.Peek(key)
+			if !ok {
+				continue
+			}
+			topics = append(topics, tp)
+		}
+	}
+	return topics
+}
+
+// allTopicNames 数据目录下所有的topic名称
+func (f *FileStoreForMsg) allTopicNames() ([]string, error) {
+	slotDirs, err := os.ReadDir(f.cfg.DataDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	topicNames := make([]string, 0)
+	for _, slotDir := range slotDirs {
+		if !slotDir.IsDir() {
+			continue
+		}
+		if _, err := strconv.ParseUint(slotDir.Name(), 10, 32); err != nil {
+			continue
+		}
+		topicDirs, err := os.ReadDir(filepath.Join(f.cfg.DataDir, slotDir.Name(), "topics"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, topicDir := range topicDirs {
+			if topicDir.IsDir() {
+				topicNames = append(topicNames, topicDir.Name())
+			}
+		}
+	}
+	return topicNames, nil
+}
+
+func (f *FileStoreForMsg) startExpireLoop() {
+	if f.cfg.ExpireScanInterval <= 0 {
+		return
+	}
+	stopChan := make(chan struct{})
+	f.stopChan = stopChan
+	go func() {
+		tick := time.NewTicker(f.cfg.ExpireScanInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				result, err := f.compactLoadedTopics()
+				if err != nil {
+					f.Error("压缩消息失败！", zap.Error(err))
+				}
+				if result.Segments > 0 {
+					f.Info("删除过期或超出保留策略的segment", zap.Int("segments", result.Segments), zap.Int("messages", result.Messages), zap.Int64("bytes", result.Bytes))
+				}
+			case <-stopChan: // Close会将f.stopChan置为nil，这里使用局部变量
+				return
+			}
+		}
+	}()
+}
+
 func (f *FileStoreForMsg) DeleteChannelAndClearMessages(channelID string, channelType uint8) error {
 	f.Warn("暂未实现DeleteChannelAndClearMessages")
 
@@ -158,6 +339,10 @@ func (f *FileStoreForMsg) StreamEnd(channelID string, channelType uint8, streamN
 }
 
 func (f *FileStoreForMsg) Close() error {
+	if f.stopChan != nil {
+		close(f.stopChan)
+		f.stopChan = nil
+	}
 	if len(f.slotMap) == 0 {
 		return nil
 	}
@@ -171,6 +356,19 @@ func (f *FileStoreForMsg) topicName(channelID string, channelType uint8) string
 	return fmt.Sprintf("%d-%s", channelType, channelID)
 }
 
+// parseTopicName 从topic名称（channelType-channelID）解析出频道
+func parseTopicName(name string) (string, uint8, bool) {
+	channelTypeStr, channelID, ok := strings.Cut(name, "-")
+	if !ok {
+		return "", 0, false
+	}
+	channelType, err := strconv.ParseUint(channelTypeStr, 10, 8)
+	if err != nil {
+		return "", 0, false
+	}
+	return channelID, uint8(channelType), true
+}
+
 func (f *FileStoreForMsg) getTopic(channelID string, channelType uint8) *topic {
 	topic := f.topicName(channelID, channelType)
 	slotNum := okutil.GetSlotNum(f.cfg.SlotNum, topic)
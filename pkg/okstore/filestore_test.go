@@ -42,3 +42,111 @@ func TestFileWriteAndRead(t *testing.T) {
 	fmt.Println("zzz--->", string(testBytes[:n]))
 
 }
+
+func TestFileStoreMessageExtras(t *testing.T) {
+	store := NewFileStore(newTestStoreConfig())
+	err := store.Open()
+	assert.NoError(t, err)
+	defer store.Close()
+
+	err = store.AddOrUpdateMessageExtras("testchannel", 2, []*MessageExtra{
+		{
+			MessageID:  1001,
+			MessageSeq: 1,
+			Revoke:     true,
+			Revoker:    "u1",
+			Version:    1,
+		},
+	})
+	assert.NoError(t, err)
+
+	extras, err := store.GetMessageExtras("testchannel", 2, []uint32{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(extras))
+	assert.Equal(t, int64(1001), extras[0].MessageID)
+	assert.Equal(t, true, extras[0].Revoke)
+	assert.Equal(t, "u1", extras[0].Revoker)
+
+	// 其他频道不受影响
+	extras, err = store.GetMessageExtras("testchannel", 1, []uint32{1})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(extras))
+}
+
+func TestFileStoreMessageEdits(t *testing.T) {
+	store := NewFileStore(newTestStoreConfig())
+	err := store.Open()
+	assert.NoError(t, err)
+	defer store.Close()
+
+	for i := 1; i <= 11; i++ {
+		err = store.AppendMessageEdit("testchannel", 2, &MessageEdit{
+			MessageID:   1001,
+			MessageSeq:  1,
+			EditVersion: uint32(i),
+			Content:     []byte(fmt.Sprintf("content%d", i)),
+			Editor:      "u1",
+		})
+		assert.NoError(t, err)
+	}
+	// 相邻序号的编辑记录不能混在一起
+	err = store.AppendMessageEdit("testchannel", 2, &MessageEdit{MessageID: 1010, MessageSeq: 10, EditVersion: 1})
+	assert.NoError(t, err)
+
+	edits, err := store.GetMessageEdits("testchannel", 2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 11, len(edits))
+	assert.Equal(t, uint32(1), edits[0].EditVersion)
+	assert.Equal(t, uint32(11), edits[10].EditVersion)
+	assert.Equal(t, []byte("content11"), edits[10].Content)
+
+	edits, err = store.GetMessageEdits("testchannel", 2, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(edits))
+}
+
+func TestFileStoreMessageReaders(t *testing.T) {
+	store := NewFileStore(newTestStoreConfig())
+	err := store.Open()
+	assert.NoError(t, err)
+	defer store.Close()
+
+	newMessageSeqs, err := store.AddMessageReaders("testchannel", 2, "u1", []uint32{1, 2}, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2}, newMessageSeqs)
+
+	// 重复已读不会重复计算
+	newMessageSeqs, err = store.AddMessageReaders("testchannel", 2, "u1", []uint32{2, 3}, 101)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{3}, newMessageSeqs)
+
+	_, err = store.AddMessageReaders("testchannel", 2, "u2", []uint32{2}, 102)
+	assert.NoError(t, err)
+
+	readers, err := store.GetMessageReaders("testchannel", 2, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(readers))
+	assert.Equal(t, "u1", readers[0].UID)
+	assert.Equal(t, int64(100), readers[0].ReadedAt)
+	assert.Equal(t, "u2", readers[1].UID)
+}
+
+func TestFileStoreChannelReadedSeq(t *testing.T) {
+	store := NewFileStore(newTestStoreConfig())
+	err := store.Open()
+	assert.NoError(t, err)
+	defer store.Close()
+
+	oldReadedSeq, err := store.UpdateChannelReadedSeqIfNeed("u1", "testchannel", 2, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), oldReadedSeq)
+
+	// 已读序号只会变大
+	oldReadedSeq, err = store.UpdateChannelReadedSeqIfNeed("u1", "testchannel", 2, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(10), oldReadedSeq)
+
+	readedSeq, err := store.GetChannelReadedSeq("u1", "testchannel", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(10), readedSeq)
+}
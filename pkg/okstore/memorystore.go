@@ -0,0 +1,1291 @@
+package okstore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/oklog"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"go.uber.org/zap"
+)
+
+// MemoryStore 内存存储，所有数据保存在内存里，进程退出后数据丢失
+// 适用于测试和不需要持久化的临时部署
+type MemoryStore struct {
+	cfg *StoreConfig
+	oklog.Log
+	sync.RWMutex
+	stopChan chan struct{}
+
+	userTokens        map[string]memoryUserToken
+	userCursors       map[string]uint32
+	userRateLimits    map[string]*RateLimit
+	userLastSeens     map[string]int64
+	channels          map[string]*ChannelInfo
+	subscribers       map[string][]string
+	denylists         map[string][]string
+	allowlists        map[string][]string
+	topics            map[string]*memoryTopic
+	notifyQueue       []Message
+	messageExtras     map[string]map[uint32]*MessageExtra
+	messageEdits      map[string]map[uint32][]*MessageEdit
+	messageDeletes    map[string][]*MessageDeleteMarker
+	messageReaders    map[string]map[uint32]map[string]*MessageReader
+	reactions         map[string]*memoryReactions
+	threads           map[string]map[uint32]*Thread
+	pins              map[string]*ChannelPins
+	channelReadedSeqs map[string]uint32
+	retentions        map[string]*RetentionPolicy
+	conversations     map[string][]*Conversation
+	systemUIDs        []string
+	ipBlacklist       []string
+	apiKeys           map[string]*APIKey
+	apiKeyEnabled     bool
+	inFlightMessages  map[string]*InFlightMessage
+}
+
+type memoryReactions struct {
+	maxSeq    uint64
+	reactions map[string]*Reaction // key为消息ID、用户和表情
+}
+
+type memoryUserToken struct {
+	token       string
+	deviceLevel uint8
+}
+
+type memoryTopic struct {
+	messages    []Message // 按messageSeq升序
+	appendedAts []int64   // 消息的追加时间（10位时间戳），和messages一一对应
+	lastMsgSeq  uint32
+	streams     map[string]*memoryStream
+}
+
+type memoryStream struct {
+	meta  *StreamMeta
+	items []*StreamItem
+}
+
+// NewMemoryStore NewMemoryStore
+func NewMemoryStore(cfg *StoreConfig) *MemoryStore {
+	m := &MemoryStore{
+		cfg: cfg,
+		Log: oklog.NewOKLog("MemoryStore"),
+	}
+	m.reset()
+	return m
+}
+
+func (m *MemoryStore) reset() {
+	m.userTokens = map[string]memoryUserToken{}
+	m.userCursors = map[string]uint32{}
+	m.userRateLimits = map[string]*RateLimit{}
+	m.userLastSeens = map[string]int64{}
+	m.channels = map[string]*ChannelInfo{}
+	m.subscribers = map[string][]string{}
+	m.denylists = map[string][]string{}
+	m.allowlists = map[string][]string{}
+	m.topics = map[string]*memoryTopic{}
+	m.notifyQueue = make([]Message, 0)
+	m.messageExtras = map[string]map[uint32]*MessageExtra{}
+	m.messageEdits = map[string]map[uint32][]*MessageEdit{}
+	m.messageDeletes = map[string][]*MessageDeleteMarker{}
+	m.messageReaders = map[string]map[uint32]map[string]*MessageReader{}
+	m.reactions = map[string]*memoryReactions{}
+	m.threads = map[string]map[uint32]*Thread{}
+	m.pins = map[string]*ChannelPins{}
+	m.channelReadedSeqs = map[string]uint32{}
+	m.retentions = map[string]*RetentionPolicy{}
+	m.conversations = map[string][]*Conversation{}
+	m.systemUIDs = make([]string, 0)
+	m.ipBlacklist = make([]string, 0)
+	m.apiKeys = map[string]*APIKey{}
+	m.inFlightMessages = map[string]*InFlightMessage{}
+}
+
+func (m *MemoryStore) Open() error {
+	m.startExpireLoop()
+	return nil
+}
+
+// Close 关闭后数据将被清空
+func (m *MemoryStore) Close() error {
+	m.Lock()
+	defer m.Unlock()
+	if m.stopChan != nil {
+		close(m.stopChan)
+		m.stopChan = nil
+	}
+	m.reset()
+	return nil
+}
+
+// #################### user ####################
+
+func (m *MemoryStore) GetUserToken(uid string, deviceFlag uint8) (string, uint8, error) {
+	m.RLock()
+	defer m.RUnlock()
+	userToken := m.userTokens[m.userTokenKey(uid, deviceFlag)]
+	return userToken.token, userToken.deviceLevel, nil
+}
+
+func (m *MemoryStore) UpdateUserToken(uid string, deviceFlag uint8, deviceLevel uint8, token string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.userTokens[m.userTokenKey(uid, deviceFlag)] = memoryUserToken{
+		token:       token,
+		deviceLevel: deviceLevel,
+	}
+	return nil
+}
+
+func (m *MemoryStore) UpdateMessageOfUserCursorIfNeed(uid string, messageSeq uint32) error {
+	m.Lock()
+	defer m.Unlock()
+	var lastSeq uint32
+	if tp := m.topics[m.topicKey(m.userQueueChannelID(uid), okproto.ChannelTypePerson)]; tp != nil {
+		lastSeq = tp.lastMsgSeq
+	}
+	actOffset := messageSeq
+	if messageSeq > lastSeq { // 如果传过来的大于系统里最新的 则用最新的
+		actOffset = lastSeq
+	}
+	if oldOffset, ok := m.userCursors[uid]; ok {
+		if actOffset <= oldOffset && oldOffset < lastSeq {
+			return nil
+		}
+	}
+	m.userCursors[uid] = actOffset
+	return nil
+}
+
+func (m *MemoryStore) GetMessageOfUserCursor(uid string) (uint32, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.userCursors[uid], nil
+}
+
+func (m *MemoryStore) SetUserRateLimit(uid string, limit *RateLimit) error {
+	m.Lock()
+	defer m.Unlock()
+	if limit == nil {
+		delete(m.userRateLimits, uid)
+		return nil
+	}
+	cp := *limit
+	m.userRateLimits[uid] = &cp
+	return nil
+}
+
+func (m *MemoryStore) GetUserRateLimit(uid string) (*RateLimit, error) {
+	m.RLock()
+	defer m.RUnlock()
+	limit := m.userRateLimits[uid]
+	if limit == nil {
+		return nil, nil
+	}
+	cp := *limit
+	return &cp, nil
+}
+
+func (m *MemoryStore) UpdateUserLastSeen(uid string, lastSeen int64) error {
+	m.Lock()
+	defer m.Unlock()
+	m.userLastSeens[uid] = lastSeen
+	return nil
+}
+
+func (m *MemoryStore) GetUserLastSeen(uid string) (int64, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.userLastSeens[uid], nil
+}
+
+// #################### channel ####################
+
+func (m *MemoryStore) GetChannel(channelID string, channelType uint8) (*ChannelInfo, error) {
+	m.RLock()
+	defer m.RUnlock()
+	channelInfo := m.channels[m.channelKey(channelID, channelType)]
+	if channelInfo == nil {
+		return nil, nil
+	}
+	cloneChannelInfo := *channelInfo
+	cloneChannelInfo.ChannelModeration = channelInfo.ChannelModeration.Clone()
+	return &cloneChannelInfo, nil
+}
+
+func (m *MemoryStore) AddOrUpdateChannel(channelInfo *ChannelInfo) error {
+	m.Lock()
+	defer m.Unlock()
+	cloneChannelInfo := *channelInfo
+	cloneChannelInfo.ChannelModeration = channelInfo.ChannelModeration.Clone()
+	m.channels[m.channelKey(channelInfo.ChannelID, channelInfo.ChannelType)] = &cloneChannelInfo
+	return nil
+}
+
+func (m *MemoryStore) ExistChannel(channelID string, channelType uint8) (bool, error) {
+	m.RLock()
+	defer m.RUnlock()
+	_, ok := m.channels[m.channelKey(channelID, channelType)]
+	return ok, nil
+}
+
+func (m *MemoryStore) DeleteChannel(channelID string, channelType uint8) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.channels, m.channelKey(channelID, channelType))
+	return nil
+}
+
+func (m *MemoryStore) AddSubscribers(channelID string, channelType uint8, uids []string) error {
+	return m.addList(m.subscribers, m.channelKey(channelID, channelType), uids)
+}
+
+func (m *MemoryStore) RemoveSubscribers(channelID string, channelType uint8, uids []string) error {
+	return m.removeList(m.subscribers, m.channelKey(channelID, channelType), uids)
+}
+
+func (m *MemoryStore) GetSubscribers(channelID string, channelType uint8) ([]string, error) {
+	return m.getList(m.subscribers, m.channelKey(channelID, channelType))
+}
+
+func (m *MemoryStore) RemoveAllSubscriber(channelID string, channelType uint8) error {
+	return m.removeAllList(m.subscribers, m.channelKey(channelID, channelType))
+}
+
+func (m *MemoryStore) GetAllowlist(channelID string, channelType uint8) ([]string, error) {
+	return m.getList(m.allowlists, m.channelKey(channelID, channelType))
+}
+
+func (m *MemoryStore) AddAllowlist(channelID string, channelType uint8, uids []string) error {
+	return m.addList(m.allowlists, m.channelKey(channelID, channelType), uids)
+}
+
+func (m *MemoryStore) RemoveAllowlist(channelID string, channelType uint8, uids []string) error {
+	return m.removeList(m.allowlists, m.channelKey(channelID, channelType), uids)
+}
+
+func (m *MemoryStore) RemoveAllAllowlist(channelID string, channelType uint8) error {
+	return m.removeAllList(m.allowlists, m.channelKey(channelID, channelType))
+}
+
+func (m *MemoryStore) GetDenylist(channelID string, channelType uint8) ([]string, error) {
+	return m.getList(m.denylists, m.channelKey(channelID, channelType))
+}
+
+func (m *MemoryStore) AddDenylist(channelID string, channelType uint8, uids []string) error {
+	return m.addList(m.denylists, m.channelKey(channelID, channelType), uids)
+}
+
+func (m *MemoryStore) RemoveDenylist(channelID string, channelType uint8, uids []string) error {
+	return m.removeList(m.denylists, m.channelKey(channelID, channelType), uids)
+}
+
+func (m *MemoryStore) RemoveAllDenylist(channelID string, channelType uint8) error {
+	return m.removeAllList(m.denylists, m.channelKey(channelID, channelType))
+}
+
+// #################### messages ####################
+
+func (m *MemoryStore) AppendMessages(channelID string, channelType uint8, msgs []Message) ([]uint32, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.appendMessages(m.getTopic(channelID, channelType), msgs), nil
+}
+
+func (m *MemoryStore) AppendMessagesOfUser(uid string, msgs []Message) ([]uint32, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.appendMessages(m.getTopic(m.userQueueChannelID(uid), okproto.ChannelTypePerson), msgs), nil
+}
+
+func (m *MemoryStore) LoadMsg(channelID string, channelType uint8, seq uint32) (Message, error) {
+	m.RLock()
+	defer m.RUnlock()
+	tp := m.topics[m.topicKey(channelID, channelType)]
+	if tp == nil {
+		return nil, ErrorNotData
+	}
+	idx := tp.searchMessage(seq)
+	if idx >= len(tp.messages) || tp.messages[idx].GetSeq() != seq {
+		return nil, ErrorNotData
+	}
+	return m.cloneMessage(tp.messages[idx])
+}
+
+func (m *MemoryStore) LoadLastMsgs(channelID string, channelType uint8, limit int) ([]Message, error) {
+	return m.LoadLastMsgsWithEnd(channelID, channelType, 0, limit)
+}
+
+func (m *MemoryStore) LoadLastMsgsWithEnd(channelID string, channelType uint8, end uint32, limit int) ([]Message, error) {
+	m.RLock()
+	defer m.RUnlock()
+	tp := m.topics[m.topicKey(channelID, channelType)]
+	if tp == nil || tp.lastMsgSeq == 0 {
+		return make([]Message, 0), nil
+	}
+	return m.loadPrevRangeMessages(tp, tp.lastMsgSeq, end, limit)
+}
+
+func (m *MemoryStore) LoadPrevRangeMsgs(channelID string, channelType uint8, start, end uint32, limit int) ([]Message, error) {
+	if start == 0 {
+		return nil, fmt.Errorf("start messageSeq must be greater than 0")
+	}
+	m.RLock()
+	defer m.RUnlock()
+	tp := m.topics[m.topicKey(channelID, channelType)]
+	if tp == nil {
+		return make([]Message, 0), nil
+	}
+	return m.loadPrevRangeMessages(tp, start, end, limit)
+}
+
+func (m *MemoryStore) LoadNextRangeMsgs(channelID string, channelType uint8, start, end uint32, limit int) ([]Message, error) {
+	m.RLock()
+	defer m.RUnlock()
+	tp := m.topics[m.topicKey(channelID, channelType)]
+	if tp == nil || limit <= 0 {
+		return make([]Message, 0), nil
+	}
+	return m.loadRangeMessages(tp, start, end, limit)
+}
+
+func (m *MemoryStore) GetLastMsgSeq(channelID string, channelType uint8) (uint32, error) {
+	m.RLock()
+	defer m.RUnlock()
+	tp := m.topics[m.topicKey(channelID, channelType)]
+	if tp == nil {
+		return 0, nil
+	}
+	return tp.lastMsgSeq, nil
+}
+
+func (m *MemoryStore) SyncMessageOfUser(uid string, startMessageSeq uint32, limit int) ([]Message, error) {
+	return m.LoadNextRangeMsgs(m.userQueueChannelID(uid), okproto.ChannelTypePerson, startMessageSeq, 0, limit)
+}
+
+func (m *MemoryStore) AppendMessageOfNotifyQueue(msgs []Message) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, msg := range msgs {
+		cloneMsg, err := m.cloneMessage(msg)
+		if err != nil {
+			m.Error("AppendMessageOfNotifyQueue", zap.Error(err))
+			continue
+		}
+		m.notifyQueue = append(m.notifyQueue, cloneMsg)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetMessagesOfNotifyQueue(count int) ([]Message, error) {
+	m.RLock()
+	defer m.RUnlock()
+	messages := make([]Message, 0)
+	for _, msg := range m.notifyQueue {
+		if len(messages) >= count {
+			break
+		}
+		cloneMsg, err := m.cloneMessage(msg)
+		if err != nil {
+			m.Error("decode message fail", zap.Error(err))
+			continue
+		}
+		messages = append(messages, cloneMsg)
+	}
+	return messages, nil
+}
+
+func (m *MemoryStore) RemoveMessagesOfNotifyQueue(messageIDs []int64) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	m.Lock()
+	defer m.Unlock()
+	newNotifyQueue := make([]Message, 0, len(m.notifyQueue))
+	for _, msg := range m.notifyQueue {
+		remove := false
+		for _, messageID := range messageIDs {
+			if msg.GetMessageID() == messageID {
+				remove = true
+				break
+			}
+		}
+		if !remove {
+			newNotifyQueue = append(newNotifyQueue, msg)
+		}
+	}
+	m.notifyQueue = newNotifyQueue
+	return nil
+}
+
+func (m *MemoryStore) DeleteChannelAndClearMessages(channelID string, channelType uint8) error {
+	m.Lock()
+	defer m.Unlock()
+	key := m.channelKey(channelID, channelType)
+	delete(m.channels, key)
+	delete(m.subscribers, key)
+	delete(m.denylists, key)
+	delete(m.allowlists, key)
+	delete(m.messageExtras, key)
+	delete(m.messageEdits, key)
+	delete(m.messageDeletes, key)
+	delete(m.messageReaders, key)
+	delete(m.reactions, key)
+	delete(m.threads, key)
+	delete(m.pins, key)
+	delete(m.retentions, key)
+	delete(m.topics, m.topicKey(channelID, channelType))
+	return nil
+}
+
+// #################### retention ####################
+
+func (m *MemoryStore) SetChannelRetention(channelID string, channelType uint8, policy *RetentionPolicy) error {
+	m.Lock()
+	defer m.Unlock()
+	key := m.channelKey(channelID, channelType)
+	if policy == nil {
+		delete(m.retentions, key)
+		return nil
+	}
+	cp := *policy
+	m.retentions[key] = &cp
+	return nil
+}
+
+func (m *MemoryStore) GetChannelRetention(channelID string, channelType uint8) (*RetentionPolicy, error) {
+	m.RLock()
+	defer m.RUnlock()
+	policy := m.retentions[m.channelKey(channelID, channelType)]
+	if policy == nil {
+		return nil, nil
+	}
+	cp := *policy
+	return &cp, nil
+}
+
+func (m *MemoryStore) CompactChannel(channelID string, channelType uint8) (*CompactResult, error) {
+	m.Lock()
+	defer m.Unlock()
+	tp := m.topics[m.topicKey(channelID, channelType)]
+	if tp == nil {
+		return &CompactResult{}, nil
+	}
+	return m.compactTopic(tp, m.getRetention(channelID, channelType), time.Now().Unix()), nil
+}
+
+func (m *MemoryStore) Compact() (*CompactResult, error) {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now().Unix()
+	result := &CompactResult{}
+	for key, tp := range m.topics {
+		var policy RetentionPolicy
+		if channelID, channelType, ok := parseTopicName(key); ok {
+			policy = m.getRetention(channelID, channelType)
+		}
+		result.add(m.compactTopic(tp, policy, now))
+	}
+	return result, nil
+}
+
+// compactTopic 删除最前面超出保留策略（或已过期）的消息
+func (m *MemoryStore) compactTopic(tp *memoryTopic, policy RetentionPolicy, now int64) *CompactResult {
+	result := &CompactResult{Topics: 1}
+	var totalBytes int64
+	if policy.MaxBytes > 0 {
+		for _, msg := range tp.messages {
+			totalBytes += int64(len(msg.Encode()))
+		}
+	}
+	idx := 0
+	for ; idx < len(tp.messages); idx++ {
+		msg := tp.messages[idx]
+		remove := MessageExpired(msg, now)
+		if !remove && policy.MaxMessages > 0 && len(tp.messages)-idx > int(policy.MaxMessages) {
+			remove = true
+		}
+		if !remove && policy.MaxAge > 0 && tp.appendedAts[idx] < now-policy.MaxAge {
+			remove = true
+		}
+		if !remove && policy.MaxBytes > 0 && totalBytes > policy.MaxBytes {
+			remove = true
+		}
+		if !remove {
+			break
+		}
+		size := int64(len(msg.Encode()))
+		totalBytes -= size
+		result.Messages++
+		result.Bytes += size
+	}
+	tp.removeFront(idx)
+	return result
+}
+
+// getRetention 获取频道生效的消息保留策略 频道 > 频道类型 > 默认
+func (m *MemoryStore) getRetention(channelID string, channelType uint8) RetentionPolicy {
+	if policy := m.retentions[m.channelKey(channelID, channelType)]; policy != nil {
+		return *policy
+	}
+	if m.cfg == nil {
+		return RetentionPolicy{}
+	}
+	return m.cfg.GetRetention(channelType)
+}
+
+// #################### message extra ####################
+
+func (m *MemoryStore) AddOrUpdateMessageExtras(channelID string, channelType uint8, extras []*MessageExtra) error {
+	if len(extras) == 0 {
+		return nil
+	}
+	m.Lock()
+	defer m.Unlock()
+	key := m.channelKey(channelID, channelType)
+	extraMap := m.messageExtras[key]
+	if extraMap == nil {
+		extraMap = map[uint32]*MessageExtra{}
+		m.messageExtras[key] = extraMap
+	}
+	for _, extra := range extras {
+		cloneExtra := *extra
+		extraMap[extra.MessageSeq] = &cloneExtra
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetMessageExtras(channelID string, channelType uint8, messageSeqs []uint32) ([]*MessageExtra, error) {
+	if len(messageSeqs) == 0 {
+		return nil, nil
+	}
+	m.RLock()
+	defer m.RUnlock()
+	extraMap := m.messageExtras[m.channelKey(channelID, channelType)]
+	extras := make([]*MessageExtra, 0)
+	for _, messageSeq := range messageSeqs {
+		extra := extraMap[messageSeq]
+		if extra == nil {
+			continue
+		}
+		cloneExtra := *extra
+		extras = append(extras, &cloneExtra)
+	}
+	return extras, nil
+}
+
+func (m *MemoryStore) AppendMessageEdit(channelID string, channelType uint8, edit *MessageEdit) error {
+	m.Lock()
+	defer m.Unlock()
+	key := m.channelKey(channelID, channelType)
+	editMap := m.messageEdits[key]
+	if editMap == nil {
+		editMap = map[uint32][]*MessageEdit{}
+		m.messageEdits[key] = editMap
+	}
+	cloneEdit := *edit
+	edits := editMap[edit.MessageSeq]
+	for i, oldEdit := range edits { // 同一版本覆盖
+		if oldEdit.EditVersion == edit.EditVersion {
+			edits[i] = &cloneEdit
+			return nil
+		}
+	}
+	edits = append(edits, &cloneEdit)
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].EditVersion < edits[j].EditVersion
+	})
+	editMap[edit.MessageSeq] = edits
+	return nil
+}
+
+func (m *MemoryStore) GetMessageEdits(channelID string, channelType uint8, messageSeq uint32) ([]*MessageEdit, error) {
+	m.RLock()
+	defer m.RUnlock()
+	edits := make([]*MessageEdit, 0)
+	for _, edit := range m.messageEdits[m.channelKey(channelID, channelType)][messageSeq] {
+		cloneEdit := *edit
+		edits = append(edits, &cloneEdit)
+	}
+	return edits, nil
+}
+
+// #################### message delete ####################
+
+func (m *MemoryStore) AddMessageDeleteMarker(channelID string, channelType uint8, marker *MessageDeleteMarker) error {
+	m.Lock()
+	defer m.Unlock()
+	key := m.channelKey(channelID, channelType)
+	cloneMarker := *marker
+	cloneMarker.MessageIDs = append([]int64(nil), marker.MessageIDs...)
+	m.messageDeletes[key] = append(m.messageDeletes[key], &cloneMarker)
+	return nil
+}
+
+func (m *MemoryStore) GetMessageDeleteMarkers(channelID string, channelType uint8, uid string) (MessageDeleteMarkers, error) {
+	m.RLock()
+	defer m.RUnlock()
+	markers := make(MessageDeleteMarkers, 0)
+	for _, marker := range m.messageDeletes[m.channelKey(channelID, channelType)] {
+		if marker.UID != "" && marker.UID != uid {
+			continue
+		}
+		cloneMarker := *marker
+		cloneMarker.MessageIDs = append([]int64(nil), marker.MessageIDs...)
+		markers = append(markers, &cloneMarker)
+	}
+	return markers, nil
+}
+
+// #################### message receipt ####################
+
+func (m *MemoryStore) AddMessageReaders(channelID string, channelType uint8, uid string, messageSeqs []uint32, readedAt int64) ([]uint32, error) {
+	if len(messageSeqs) == 0 {
+		return nil, nil
+	}
+	m.Lock()
+	defer m.Unlock()
+	key := m.channelKey(channelID, channelType)
+	readerMap := m.messageReaders[key]
+	if readerMap == nil {
+		readerMap = map[uint32]map[string]*MessageReader{}
+		m.messageReaders[key] = readerMap
+	}
+	newMessageSeqs := make([]uint32, 0, len(messageSeqs))
+	for _, messageSeq := range messageSeqs {
+		readers := readerMap[messageSeq]
+		if readers == nil {
+			readers = map[string]*MessageReader{}
+			readerMap[messageSeq] = readers
+		}
+		if _, ok := readers[uid]; ok { // 已经读过了
+			continue
+		}
+		readers[uid] = &MessageReader{
+			UID:        uid,
+			MessageSeq: messageSeq,
+			ReadedAt:   readedAt,
+		}
+		newMessageSeqs = append(newMessageSeqs, messageSeq)
+	}
+	return newMessageSeqs, nil
+}
+
+func (m *MemoryStore) GetMessageReaders(channelID string, channelType uint8, messageSeq uint32) ([]*MessageReader, error) {
+	m.RLock()
+	defer m.RUnlock()
+	readers := make([]*MessageReader, 0)
+	for _, reader := range m.messageReaders[m.channelKey(channelID, channelType)][messageSeq] {
+		cloneReader := *reader
+		readers = append(readers, &cloneReader)
+	}
+	sort.Slice(readers, func(i, j int) bool {
+		return readers[i].UID < readers[j].UID
+	})
+	return readers, nil
+}
+
+func (m *MemoryStore) GetChannelReadedSeq(uid string, channelID string, channelType uint8) (uint32, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.channelReadedSeqs[m.channelReadedSeqKey(uid, channelID, channelType)], nil
+}
+
+func (m *MemoryStore) UpdateChannelReadedSeqIfNeed(uid string, channelID string, channelType uint8, messageSeq uint32) (uint32, error) {
+	m.Lock()
+	defer m.Unlock()
+	key := m.channelReadedSeqKey(uid, channelID, channelType)
+	oldReadedSeq := m.channelReadedSeqs[key]
+	if messageSeq > oldReadedSeq {
+		m.channelReadedSeqs[key] = messageSeq
+	}
+	return oldReadedSeq, nil
+}
+
+// #################### message reaction ####################
+
+func (m *MemoryStore) SetReaction(channelID string, channelType uint8, reaction *Reaction) (*Reaction, error) {
+	m.Lock()
+	defer m.Unlock()
+	key := m.channelKey(channelID, channelType)
+	channelReactions := m.reactions[key]
+	if channelReactions == nil {
+		channelReactions = &memoryReactions{
+			reactions: map[string]*Reaction{},
+		}
+		m.reactions[key] = channelReactions
+	}
+	reactionKey := fmt.Sprintf("%d:%s:%s", reaction.MessageID, reaction.UID, reaction.Emoji)
+	oldReaction := channelReactions.reactions[reactionKey]
+	if oldReaction == nil && reaction.Deleted || oldReaction != nil && oldReaction.Deleted == reaction.Deleted {
+		return nil, nil
+	}
+	channelReactions.maxSeq++
+	newReaction := *reaction
+	newReaction.Seq = channelReactions.maxSeq
+	channelReactions.reactions[reactionKey] = &newReaction
+	result := newReaction
+	return &result, nil
+}
+
+func (m *MemoryStore) SyncReactions(channelID string, channelType uint8, seq uint64, limit int) ([]*Reaction, error) {
+	m.RLock()
+	defer m.RUnlock()
+	reactions := make([]*Reaction, 0)
+	channelReactions := m.reactions[m.channelKey(channelID, channelType)]
+	if channelReactions == nil {
+		return reactions, nil
+	}
+	for _, reaction := range channelReactions.reactions {
+		if reaction.Seq > seq {
+			cloneReaction := *reaction
+			reactions = append(reactions, &cloneReaction)
+		}
+	}
+	sort.Slice(reactions, func(i, j int) bool {
+		return reactions[i].Seq < reactions[j].Seq
+	})
+	if limit > 0 && len(reactions) > limit {
+		reactions = reactions[:limit]
+	}
+	return reactions, nil
+}
+
+// #################### thread ####################
+
+func (m *MemoryStore) AddOrUpdateThread(channelID string, channelType uint8, thread *Thread) error {
+	m.Lock()
+	defer m.Unlock()
+	key := m.channelKey(channelID, channelType)
+	threadMap := m.threads[key]
+	if threadMap == nil {
+		threadMap = map[uint32]*Thread{}
+		m.threads[key] = threadMap
+	}
+	cloneThread := *thread
+	threadMap[thread.RootMessageSeq] = &cloneThread
+	return nil
+}
+
+func (m *MemoryStore) GetThreads(channelID string, channelType uint8, limit int) ([]*Thread, error) {
+	m.RLock()
+	defer m.RUnlock()
+	threads := make([]*Thread, 0)
+	for _, thread := range m.threads[m.channelKey(channelID, channelType)] {
+		cloneThread := *thread
+		threads = append(threads, &cloneThread)
+	}
+	sortThreads(threads)
+	if limit > 0 && len(threads) > limit {
+		threads = threads[:limit]
+	}
+	return threads, nil
+}
+
+// #################### message pin ####################
+
+func (m *MemoryStore) SetChannelPins(channelID string, channelType uint8, pins *ChannelPins) error {
+	m.Lock()
+	defer m.Unlock()
+	m.pins[m.channelKey(channelID, channelType)] = pins.clone()
+	return nil
+}
+
+func (m *MemoryStore) GetChannelPins(channelID string, channelType uint8) (*ChannelPins, error) {
+	m.RLock()
+	defer m.RUnlock()
+	pins := m.pins[m.channelKey(channelID, channelType)]
+	if pins == nil {
+		return &ChannelPins{Pins: make([]*Pin, 0)}, nil
+	}
+	return pins.clone(), nil
+}
+
+// #################### conversations ####################
+
+func (m *MemoryStore) AddOrUpdateConversations(uid string, conversations []*Conversation) error {
+	m.Lock()
+	defer m.Unlock()
+	oldConversations := m.conversations[uid]
+	newConversations := make([]*Conversation, 0, len(oldConversations)+len(conversations))
+	newConversations = append(newConversations, oldConversations...)
+	for _, updateConversation := range conversations {
+		cloneConversation := *updateConversation
+		exist := false
+		for idx, oldConversation := range newConversations {
+			if oldConversation.ChannelID == updateConversation.ChannelID && oldConversation.ChannelType == updateConversation.ChannelType {
+				newConversations[idx] = &cloneConversation
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			newConversations = append(newConversations, &cloneConversation)
+		}
+	}
+	m.conversations[uid] = newConversations
+	return nil
+}
+
+func (m *MemoryStore) GetConversations(uid string) ([]*Conversation, error) {
+	m.RLock()
+	defer m.RUnlock()
+	oldConversations := m.conversations[uid]
+	if len(oldConversations) == 0 {
+		return nil, nil
+	}
+	conversations := make([]*Conversation, 0, len(oldConversations))
+	for _, conversation := range oldConversations {
+		cloneConversation := *conversation
+		conversations = append(conversations, &cloneConversation)
+	}
+	return conversations, nil
+}
+
+func (m *MemoryStore) GetConversation(uid string, channelID string, channelType uint8) (*Conversation, error) {
+	m.RLock()
+	defer m.RUnlock()
+	for _, conversation := range m.conversations[uid] {
+		if conversation.ChannelID == channelID && conversation.ChannelType == channelType {
+			cloneConversation := *conversation
+			return &cloneConversation, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) DeleteConversation(uid string, channelID string, channelType uint8) error {
+	m.Lock()
+	defer m.Unlock()
+	conversations := m.conversations[uid]
+	newConversations := make([]*Conversation, 0, len(conversations))
+	for _, conversation := range conversations {
+		if !(conversation.ChannelID == channelID && conversation.ChannelType == channelType) {
+			newConversations = append(newConversations, conversation)
+		}
+	}
+	m.conversations[uid] = newConversations
+	return nil
+}
+
+// #################### system uids ####################
+
+func (m *MemoryStore) AddSystemUIDs(uids []string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.systemUIDs = appendUnique(m.systemUIDs, uids)
+	return nil
+}
+
+func (m *MemoryStore) RemoveSystemUIDs(uids []string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.systemUIDs = removeValues(m.systemUIDs, uids)
+	return nil
+}
+
+func (m *MemoryStore) GetSystemUIDs() ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return append(make([]string, 0, len(m.systemUIDs)), m.systemUIDs...), nil
+}
+
+// #################### message stream ####################
+
+func (m *MemoryStore) SaveStreamMeta(meta *StreamMeta) error {
+	m.Lock()
+	defer m.Unlock()
+	cloneMeta := *meta
+	m.getStream(meta.ChannelID, meta.ChannelType, meta.StreamNo).meta = &cloneMeta
+	return nil
+}
+
+func (m *MemoryStore) StreamEnd(channelID string, channelType uint8, streamNo string) error {
+	m.Lock()
+	defer m.Unlock()
+	stream := m.getStream(channelID, channelType, streamNo)
+	if stream.meta == nil {
+		return nil
+	}
+	stream.meta.StreamFlag = okproto.StreamFlagEnd
+	return nil
+}
+
+func (m *MemoryStore) GetStreamMeta(channelID string, channelType uint8, streamNo string) (*StreamMeta, error) {
+	m.Lock()
+	defer m.Unlock()
+	stream := m.getStream(channelID, channelType, streamNo)
+	if stream.meta == nil {
+		return nil, nil
+	}
+	cloneMeta := *stream.meta
+	return &cloneMeta, nil
+}
+
+func (m *MemoryStore) AppendStreamItem(channelID string, channelType uint8, streamNo string, item *StreamItem) (uint32, error) {
+	m.Lock()
+	defer m.Unlock()
+	stream := m.getStream(channelID, channelType, streamNo)
+	var streamSeq uint32 = 1
+	if len(stream.items) > 0 {
+		streamSeq = stream.items[len(stream.items)-1].StreamSeq + 1
+	}
+	item.StreamSeq = streamSeq
+	cloneItem := *item
+	cloneItem.Blob = append([]byte(nil), item.Blob...)
+	stream.items = append(stream.items, &cloneItem)
+	return streamSeq, nil
+}
+
+func (m *MemoryStore) GetStreamItems(channelID string, channelType uint8, streamNo string) ([]*StreamItem, error) {
+	m.Lock()
+	defer m.Unlock()
+	stream := m.getStream(channelID, channelType, streamNo)
+	if len(stream.items) == 0 {
+		return nil, nil
+	}
+	items := make([]*StreamItem, 0, len(stream.items))
+	for _, item := range stream.items {
+		cloneItem := *item
+		cloneItem.Blob = append([]byte(nil), item.Blob...)
+		items = append(items, &cloneItem)
+	}
+	return items, nil
+}
+
+// #################### in flight ####################
+
+func (m *MemoryStore) AddOrUpdateAPIKey(apiKey *APIKey) error {
+	m.Lock()
+	defer m.Unlock()
+	cp := *apiKey
+	cp.Scopes = append([]string{}, apiKey.Scopes...)
+	m.apiKeys[apiKey.ID] = &cp
+	return nil
+}
+
+func (m *MemoryStore) RemoveAPIKey(id string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.apiKeys, id)
+	return nil
+}
+
+func (m *MemoryStore) GetAPIKeys() ([]*APIKey, error) {
+	m.RLock()
+	defer m.RUnlock()
+	apiKeys := make([]*APIKey, 0, len(m.apiKeys))
+	for _, apiKey := range m.apiKeys {
+		cp := *apiKey
+		cp.Scopes = append([]string{}, apiKey.Scopes...)
+		apiKeys = append(apiKeys, &cp)
+	}
+	sort.Slice(apiKeys, func(i, j int) bool {
+		return apiKeys[i].ID < apiKeys[j].ID
+	})
+	return apiKeys, nil
+}
+
+func (m *MemoryStore) SetAPIKeyEnabled() error {
+	m.Lock()
+	defer m.Unlock()
+	m.apiKeyEnabled = true
+	return nil
+}
+
+func (m *MemoryStore) GetAPIKeyEnabled() (bool, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.apiKeyEnabled, nil
+}
+
+func (m *MemoryStore) AddOrUpdateInFlightMessages(messages []*InFlightMessage) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, message := range messages {
+		cloneMessage := *message
+		m.inFlightMessages[m.inFlightKey(message)] = &cloneMessage
+	}
+	return nil
+}
+
+func (m *MemoryStore) RemoveInFlightMessages(messages []*InFlightMessage) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, message := range messages {
+		delete(m.inFlightMessages, m.inFlightKey(message))
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetInFlightMessages() ([]*InFlightMessage, error) {
+	m.RLock()
+	defer m.RUnlock()
+	messages := make([]*InFlightMessage, 0, len(m.inFlightMessages))
+	for _, message := range m.inFlightMessages {
+		cloneMessage := *message
+		messages = append(messages, &cloneMessage)
+	}
+	return messages, nil
+}
+
+func (m *MemoryStore) inFlightKey(message *InFlightMessage) string {
+	return fmt.Sprintf("%s:%s:%d", message.UID, message.DeviceID, message.MessageID)
+}
+
+// #################### ip blacklist ####################
+
+func (m *MemoryStore) AddIPBlacklist(ips []string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.ipBlacklist = appendUnique(m.ipBlacklist, ips)
+	return nil
+}
+
+func (m *MemoryStore) RemoveIPBlacklist(ips []string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.ipBlacklist = removeValues(m.ipBlacklist, ips)
+	return nil
+}
+
+func (m *MemoryStore) GetIPBlacklist() ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return append(make([]string, 0, len(m.ipBlacklist)), m.ipBlacklist...), nil
+}
+
+func (m *MemoryStore) appendMessages(tp *memoryTopic, msgs []Message) []uint32 {
+	seqs := make([]uint32, 0, len(msgs))
+	for _, msg := range msgs {
+		tp.lastMsgSeq++
+		msg.SetSeq(tp.lastMsgSeq)
+		seqs = append(seqs, tp.lastMsgSeq)
+		cloneMsg, err := m.cloneMessage(msg)
+		if err != nil {
+			m.Error("clone message fail", zap.Error(err))
+			cloneMsg = msg
+		}
+		tp.messages = append(tp.messages, cloneMsg)
+		tp.appendedAts = append(tp.appendedAts, time.Now().Unix())
+	}
+	return seqs
+}
+
+// loadRangeMessages 向下加载[start,end)范围内未过期的消息 end=0表示不做限制
+func (m *MemoryStore) loadRangeMessages(tp *memoryTopic, start, end uint32, limit int) ([]Message, error) {
+	now := time.Now().Unix()
+	messages := make([]Message, 0, limit)
+	for idx := tp.searchMessage(start); idx < len(tp.messages) && len(messages) < limit; idx++ {
+		msg := tp.messages[idx]
+		if end != 0 && msg.GetSeq() >= end {
+			break
+		}
+		if MessageExpired(msg, now) {
+			continue
+		}
+		cloneMsg, err := m.cloneMessage(msg)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, cloneMsg)
+	}
+	return messages, nil
+}
+
+// loadPrevRangeMessages 向上加载(end,start]范围内未过期的消息 end=0表示不做限制
+func (m *MemoryStore) loadPrevRangeMessages(tp *memoryTopic, start, end uint32, limit int) ([]Message, error) {
+	now := time.Now().Unix()
+	messages := make([]Message, 0, limit)
+	for idx := tp.searchMessage(start+1) - 1; idx >= 0 && len(messages) < limit; idx-- {
+		msg := tp.messages[idx]
+		if msg.GetSeq() <= end {
+			break
+		}
+		if MessageExpired(msg, now) {
+			continue
+		}
+		cloneMsg, err := m.cloneMessage(msg)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, cloneMsg)
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// RemoveExpiredMessages 删除每个频道最前面连续过期的消息，返回删除的消息数量
+func (m *MemoryStore) RemoveExpiredMessages() int {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now().Unix()
+	removed := 0
+	for _, tp := range m.topics {
+		idx := 0
+		for idx < len(tp.messages) && MessageExpired(tp.messages[idx], now) {
+			idx++
+		}
+		tp.removeFront(idx)
+		removed += idx
+	}
+	return removed
+}
+
+func (m *MemoryStore) startExpireLoop() {
+	if m.cfg == nil || m.cfg.ExpireScanInterval <= 0 {
+		return
+	}
+	stopChan := make(chan struct{})
+	m.stopChan = stopChan
+	go func() {
+		tick := time.NewTicker(m.cfg.ExpireScanInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				m.Compact()
+			case <-stopChan: // Close会将m.stopChan置为nil，这里使用局部变量
+				return
+			}
+		}
+	}()
+}
+
+// cloneMessage 通过编解码复制消息，避免外部修改影响到存储的数据
+func (m *MemoryStore) cloneMessage(msg Message) (Message, error) {
+	if m.cfg == nil || m.cfg.DecodeMessageFnc == nil {
+		return msg, nil
+	}
+	return m.cfg.DecodeMessageFnc(msg.Encode())
+}
+
+func (m *MemoryStore) getTopic(channelID string, channelType uint8) *memoryTopic {
+	key := m.topicKey(channelID, channelType)
+	tp := m.topics[key]
+	if tp == nil {
+		tp = &memoryTopic{
+			messages:    make([]Message, 0),
+			appendedAts: make([]int64, 0),
+			streams:     map[string]*memoryStream{},
+		}
+		m.topics[key] = tp
+	}
+	return tp
+}
+
+func (m *MemoryStore) getStream(channelID string, channelType uint8, streamNo string) *memoryStream {
+	tp := m.getTopic(channelID, channelType)
+	stream := tp.streams[streamNo]
+	if stream == nil {
+		stream = &memoryStream{}
+		tp.streams[streamNo] = stream
+	}
+	return stream
+}
+
+func (m *MemoryStore) getList(lists map[string][]string, key string) ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+	values := lists[key]
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return append(make([]string, 0, len(values)), values...), nil
+}
+
+func (m *MemoryStore) addList(lists map[string][]string, key string, values []string) error {
+	m.Lock()
+	defer m.Unlock()
+	lists[key] = append(lists[key], values...)
+	return nil
+}
+
+func (m *MemoryStore) removeList(lists map[string][]string, key string, values []string) error {
+	m.Lock()
+	defer m.Unlock()
+	lists[key] = removeValues(lists[key], values)
+	return nil
+}
+
+func (m *MemoryStore) removeAllList(lists map[string][]string, key string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(lists, key)
+	return nil
+}
+
+func (m *MemoryStore) userQueueChannelID(uid string) string {
+	return fmt.Sprintf("%s%s", UserQueuePrefix, uid)
+}
+
+func (m *MemoryStore) topicKey(channelID string, channelType uint8) string {
+	return fmt.Sprintf("%d-%s", channelType, channelID)
+}
+
+func (m *MemoryStore) channelKey(channelID string, channelType uint8) string {
+	return fmt.Sprintf("%s-%d", channelID, channelType)
+}
+
+func (m *MemoryStore) userTokenKey(uid string, deviceFlag uint8) string {
+	return fmt.Sprintf("%s-%d", uid, deviceFlag)
+}
+
+func (m *MemoryStore) channelReadedSeqKey(uid string, channelID string, channelType uint8) string {
+	return fmt.Sprintf("%s-%d:%s", channelID, channelType, uid)
+}
+
+// searchMessage 返回第一个messageSeq大于等于seq的消息下标
+func (t *memoryTopic) searchMessage(seq uint32) int {
+	return sort.Search(len(t.messages), func(i int) bool {
+		return t.messages[i].GetSeq() >= seq
+	})
+}
+
+// removeFront 删除最前面的n条消息
+func (t *memoryTopic) removeFront(n int) {
+	if n <= 0 {
+		return
+	}
+	t.messages = append(make([]Message, 0, len(t.messages)-n), t.messages[n:]...)
+	t.appendedAts = append(make([]int64, 0, len(t.appendedAts)-n), t.appendedAts[n:]...)
+}
+
+func appendUnique(list []string, values []string) []string {
+	for _, value := range values {
+		exist := false
+		for _, oldValue := range list {
+			if oldValue == value {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+func removeValues(list []string, values []string) []string {
+	newList := make([]string, 0, len(list))
+	for _, v := range list {
+		has := false
+		for _, value := range values {
+			if v == value {
+				has = true
+				break
+			}
+		}
+		if !has {
+			newList = append(newList, v)
+		}
+	}
+	return newList
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"time"
 )
 
 type Message interface {
@@ -14,6 +15,35 @@ type Message interface {
 	Decode(msg []byte) error
 }
 
+// ExpireMessage 有过期时间的消息（阅后即焚等），过期的消息不会再被加载，segment里的消息全部过期后segment会被删除
+type ExpireMessage interface {
+	// GetExpireAt 消息的过期时间（10位时间戳，到秒），0表示永不过期
+	GetExpireAt() int64
+}
+
+// MessageExpired 消息在now（10位时间戳）时是否已过期
+func MessageExpired(m Message, now int64) bool {
+	em, ok := m.(ExpireMessage)
+	if !ok {
+		return false
+	}
+	expireAt := em.GetExpireAt()
+	return expireAt > 0 && expireAt <= now
+}
+
+// filterExpiredMessages 过滤掉已过期的消息
+func filterExpiredMessages(messages []Message) []Message {
+	now := time.Now().Unix()
+	validMessages := make([]Message, 0, len(messages))
+	for _, m := range messages {
+		if MessageExpired(m, now) {
+			continue
+		}
+		validMessages = append(validMessages, m)
+	}
+	return validMessages
+}
+
 func EncodeMessage(messageSeq uint32, data []byte) []byte {
 	p := new(bytes.Buffer)
 	binary.Write(p, Encoding, MagicNumber)
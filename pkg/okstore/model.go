@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sort"
 
 	"github.com/samlau0508/imserver/pkg/okutil"
 	okproto "github.com/samlau0508/imserver/pkg/proto"
@@ -295,3 +296,299 @@ func (l StreamItemSlice) Less(i, j int) bool {
 }
 
 func (l StreamItemSlice) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+
+// MessageExtra 消息扩展数据（撤回等），消息本身是追加写入的不可修改，对消息的变更都记录在扩展里
+type MessageExtra struct {
+	MessageID  int64  `json:"message_id"`
+	MessageSeq uint32 `json:"message_seq"`
+	Revoke     bool   `json:"revoke,omitempty"`  // 是否已撤回
+	Revoker    string `json:"revoker,omitempty"` // 撤回者的uid
+	// 编辑
+	ContentEdit []byte `json:"content_edit,omitempty"` // 编辑后的消息内容（最新的一次编辑）
+	EditVersion uint32 `json:"edit_version,omitempty"` // 编辑版本，每编辑一次加1
+	EditedAt    int64  `json:"edited_at,omitempty"`    // 最后一次编辑时间（10位，到秒）
+	// 回执
+	ReadedCount int `json:"readed_count,omitempty"` // 已读人数
+	// 子区（社区频道消息的回复）
+	ReplyCount          uint32 `json:"reply_count,omitempty"`            // 回复数
+	LastReplyUID        string `json:"last_reply_uid,omitempty"`         // 最后一条回复的发送者
+	LastReplyMessageID  int64  `json:"last_reply_message_id,omitempty"`  // 最后一条回复的消息ID
+	LastReplyMessageSeq uint32 `json:"last_reply_message_seq,omitempty"` // 最后一条回复在子区频道的消息序号
+	LastReplyAt         int64  `json:"last_reply_at,omitempty"`          // 最后一条回复的时间（10位，到秒）
+
+	Version int64 `json:"version"` // 数据版本（毫秒时间戳）
+}
+
+func (m *MessageExtra) Encode() []byte {
+	return []byte(okutil.ToJSON(m))
+}
+
+func (m *MessageExtra) Decode(data []byte) error {
+
+	return okutil.ReadJSONByByte(data, m)
+}
+
+// MessageDeleteMarker 消息删除标记，消息在topic里是追加写入的，删除消息只记录标记，同步消息的时候过滤掉被删除的消息
+type MessageDeleteMarker struct {
+	UID             string  `json:"uid,omitempty"`               // 为空表示对所有人删除，否则只对此用户删除
+	StartMessageSeq uint32  `json:"start_message_seq,omitempty"` // 删除的消息序号范围（包含），为0表示不按消息序号删除
+	EndMessageSeq   uint32  `json:"end_message_seq,omitempty"`
+	MessageIDs      []int64 `json:"message_ids,omitempty"` // 按消息ID删除（写扩散的消息在用户队列里没有频道的消息序号，只能按消息ID删除）
+	Deleter         string  `json:"deleter,omitempty"`     // 删除者的uid
+	DeletedAt       int64   `json:"deleted_at"`            // 删除时间（10位，到秒）
+}
+
+func (m *MessageDeleteMarker) Encode() []byte {
+	return []byte(okutil.ToJSON(m))
+}
+
+func (m *MessageDeleteMarker) Decode(data []byte) error {
+
+	return okutil.ReadJSONByByte(data, m)
+}
+
+// Deleted 消息是否被此标记删除 messageSeq为0则只按消息ID判断
+func (m *MessageDeleteMarker) Deleted(messageSeq uint32, messageID int64) bool {
+	if messageSeq != 0 && m.StartMessageSeq != 0 && messageSeq >= m.StartMessageSeq && messageSeq <= m.EndMessageSeq {
+		return true
+	}
+	if messageID != 0 {
+		for _, id := range m.MessageIDs {
+			if id == messageID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type MessageDeleteMarkers []*MessageDeleteMarker
+
+// Deleted 消息是否被任意一个标记删除 messageSeq为0则只按消息ID判断
+func (m MessageDeleteMarkers) Deleted(messageSeq uint32, messageID int64) bool {
+	for _, marker := range m {
+		if marker.Deleted(messageSeq, messageID) {
+			return true
+		}
+	}
+	return false
+}
+
+// InFlightMessage 投递中（已投递给客户端但还没有收到回执）的消息，持久化后服务重启了也能在设备重新连接时继续重试
+type InFlightMessage struct {
+	UID        string `json:"uid"`         // 接收者uid
+	DeviceID   string `json:"device_id"`   // 接收者设备ID
+	MessageID  int64  `json:"message_id"`  // 消息ID
+	RetryCount int    `json:"retry_count"` // 已重试次数
+	Data       []byte `json:"data"`        // 消息数据（由调用方编码）
+	CreatedAt  int64  `json:"created_at"`  // 第一次投递的时间（10位，到秒）
+}
+
+func (m *InFlightMessage) Encode() []byte {
+	return []byte(okutil.ToJSON(m))
+}
+
+func (m *InFlightMessage) Decode(data []byte) error {
+
+	return okutil.ReadJSONByByte(data, m)
+}
+
+// MessageEdit 消息的一次编辑记录
+type MessageEdit struct {
+	MessageID   int64  `json:"message_id"`
+	MessageSeq  uint32 `json:"message_seq"`
+	EditVersion uint32 `json:"edit_version"` // 编辑版本
+	Content     []byte `json:"content"`      // 编辑后的内容
+	Editor      string `json:"editor"`       // 编辑者的uid
+	EditedAt    int64  `json:"edited_at"`    // 编辑时间（10位，到秒）
+}
+
+func (m *MessageEdit) Encode() []byte {
+	return []byte(okutil.ToJSON(m))
+}
+
+func (m *MessageEdit) Decode(data []byte) error {
+
+	return okutil.ReadJSONByByte(data, m)
+}
+
+// MessageReader 消息的已读用户
+type MessageReader struct {
+	UID        string `json:"uid"`
+	MessageSeq uint32 `json:"message_seq"`
+	ReadedAt   int64  `json:"readed_at"` // 已读时间（10位，到秒）
+}
+
+func (m *MessageReader) Encode() []byte {
+	return []byte(okutil.ToJSON(m))
+}
+
+func (m *MessageReader) Decode(data []byte) error {
+
+	return okutil.ReadJSONByByte(data, m)
+}
+
+// Reaction 消息的回应（表情）
+type Reaction struct {
+	MessageID  int64  `json:"message_id"`
+	MessageSeq uint32 `json:"message_seq"`
+	UID        string `json:"uid"`        // 回应者
+	Emoji      string `json:"emoji"`      // 表情
+	Deleted    bool   `json:"deleted"`    // 是否已移除
+	Seq        uint64 `json:"seq"`        // 频道内的回应序号（每次添加或移除都会递增）
+	CreatedAt  int64  `json:"created_at"` // 添加或移除的时间（10位，到秒）
+}
+
+func (r *Reaction) Encode() []byte {
+	return []byte(okutil.ToJSON(r))
+}
+
+func (r *Reaction) Decode(data []byte) error {
+	return okutil.ReadJSONByByte(data, r)
+}
+
+// Thread 子区，社区频道的消息被回复后，回复存储在对应的社区话题频道里
+type Thread struct {
+	RootMessageID       int64  `json:"root_message_id"`        // 被回复的消息ID
+	RootMessageSeq      uint32 `json:"root_message_seq"`       // 被回复的消息序号
+	ReplyCount          uint32 `json:"reply_count"`            // 回复数
+	LastReplyUID        string `json:"last_reply_uid"`         // 最后一条回复的发送者
+	LastReplyMessageID  int64  `json:"last_reply_message_id"`  // 最后一条回复的消息ID
+	LastReplyMessageSeq uint32 `json:"last_reply_message_seq"` // 最后一条回复在子区频道的消息序号
+	LastReplyAt         int64  `json:"last_reply_at"`          // 最后一条回复的时间（10位，到秒）
+}
+
+func (t *Thread) Encode() []byte {
+	return []byte(okutil.ToJSON(t))
+}
+
+func (t *Thread) Decode(data []byte) error {
+	return okutil.ReadJSONByByte(data, t)
+}
+
+// Pin 置顶的消息
+type Pin struct {
+	MessageID  int64  `json:"message_id"`
+	MessageSeq uint32 `json:"message_seq"`
+	UID        string `json:"uid"`       // 置顶者
+	PinnedAt   int64  `json:"pinned_at"` // 置顶时间（10位，到秒）
+}
+
+// ChannelPins 频道的置顶消息，每次置顶或取消置顶版本号加1，客户端版本号一致则不需要同步
+type ChannelPins struct {
+	Version uint64 `json:"version"`
+	Pins    []*Pin `json:"pins"` // 按置顶时间升序
+}
+
+func (c *ChannelPins) Encode() []byte {
+	return []byte(okutil.ToJSON(c))
+}
+
+func (c *ChannelPins) Decode(data []byte) error {
+	return okutil.ReadJSONByByte(data, c)
+}
+
+func (c *ChannelPins) clone() *ChannelPins {
+	pins := make([]*Pin, 0, len(c.Pins))
+	for _, pin := range c.Pins {
+		clonePin := *pin
+		pins = append(pins, &clonePin)
+	}
+	return &ChannelPins{
+		Version: c.Version,
+		Pins:    pins,
+	}
+}
+
+// 子区按最后回复时间倒序，时间相同的按被回复的消息序号倒序
+func sortThreads(threads []*Thread) {
+	sort.Slice(threads, func(i, j int) bool {
+		if threads[i].LastReplyAt != threads[j].LastReplyAt {
+			return threads[i].LastReplyAt > threads[j].LastReplyAt
+		}
+		return threads[i].RootMessageSeq > threads[j].RootMessageSeq
+	})
+}
+
+// RetentionPolicy 消息保留策略，0表示不限制
+// 文件存储按segment删除（或归档），正在写入的segment不会被删除，所以实际保留的消息可能比策略多
+type RetentionPolicy struct {
+	MaxAge      int64  `json:"max_age"`      // 消息最长保留时间（单位秒）
+	MaxMessages uint32 `json:"max_messages"` // 最多保留最新的多少条消息
+	MaxBytes    int64  `json:"max_bytes"`    // 最多保留最新的多少字节的消息
+}
+
+// IsZero 是否没有任何限制
+func (r RetentionPolicy) IsZero() bool {
+	return r.MaxAge <= 0 && r.MaxMessages == 0 && r.MaxBytes <= 0
+}
+
+func (r *RetentionPolicy) Encode() []byte {
+	return []byte(okutil.ToJSON(r))
+}
+
+func (r *RetentionPolicy) Decode(data []byte) error {
+
+	return okutil.ReadJSONByByte(data, r)
+}
+
+// CompactResult 压缩结果
+type CompactResult struct {
+	Topics   int   `json:"topics"`   // 扫描的频道数量
+	Segments int   `json:"segments"` // 删除（或归档）的segment数量
+	Messages int   `json:"messages"` // 删除（或归档）的消息数量
+	Bytes    int64 `json:"bytes"`    // 释放的空间（单位字节）
+}
+
+func (c *CompactResult) add(r *CompactResult) {
+	c.Topics += r.Topics
+	c.Segments += r.Segments
+	c.Messages += r.Messages
+	c.Bytes += r.Bytes
+}
+
+// RateLimit 令牌桶限流配置
+type RateLimit struct {
+	Rate  float64 `json:"rate"`  // 每秒生成的令牌数（即每秒允许的次数），0表示不限制
+	Burst int     `json:"burst"` // 令牌桶容量（即允许突发的次数），小于1则按1计算
+}
+
+// IsZero 是否不限制
+func (r RateLimit) IsZero() bool {
+	return r.Rate <= 0
+}
+
+func (r *RateLimit) Encode() []byte {
+	return []byte(okutil.ToJSON(r))
+}
+
+func (r *RateLimit) Decode(data []byte) error {
+	return okutil.ReadJSONByByte(data, r)
+}
+
+// APIKey 调用http api的密钥 只保存密钥的sha256，密钥明文只在创建时返回一次
+type APIKey struct {
+	ID        string   `json:"id"`         // 密钥ID
+	Name      string   `json:"name"`       // 名称（比如运维监控、业务后台）
+	Scopes    []string `json:"scopes"`     // 权限范围
+	KeyHash   string   `json:"key_hash"`   // 密钥的sha256（十六进制）
+	CreatedAt int64    `json:"created_at"` // 创建时间（10位，到秒）
+}
+
+// HasScope 是否拥有指定权限
+func (a *APIKey) HasScope(scope string) bool {
+	for _, s := range a.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *APIKey) Encode() []byte {
+	return []byte(okutil.ToJSON(a))
+}
+
+func (a *APIKey) Decode(data []byte) error {
+	return okutil.ReadJSONByByte(data, a)
+}
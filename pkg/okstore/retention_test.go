@@ -0,0 +1,128 @@
+package okstore_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/okstore"
+	"github.com/samlau0508/imserver/pkg/okstore/storetest"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStoreCompactByMaxMessages(t *testing.T) {
+	cfg := storetest.NewStoreConfig(t)
+	cfg.SegmentMaxBytes = 200
+	store := okstore.NewFileStore(cfg)
+	err := store.Open()
+	assert.NoError(t, err)
+	defer store.Close()
+
+	appendTestMessages(t, store, "g1", okproto.ChannelTypeGroup, 50)
+	segmentCount := countSegments(t, cfg.DataDir)
+
+	err = store.SetChannelRetention("g1", okproto.ChannelTypeGroup, &okstore.RetentionPolicy{MaxMessages: 10})
+	assert.NoError(t, err)
+	result, err := store.CompactChannel("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.True(t, result.Segments > 0)
+	assert.True(t, result.Bytes > 0)
+	assert.Equal(t, segmentCount-result.Segments, countSegments(t, cfg.DataDir))
+
+	firstSeq := uint32(result.Messages + 1)
+	assert.True(t, 50-result.Messages >= 10)
+
+	// 已删除的消息
+	_, err = store.LoadMsg("g1", okproto.ChannelTypeGroup, 1)
+	assert.ErrorIs(t, err, okstore.ErrorNotData)
+
+	// 向上加载跨过已删除的消息
+	msgs, err := store.LoadPrevRangeMsgs("g1", okproto.ChannelTypeGroup, firstSeq+2, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{firstSeq, firstSeq + 1, firstSeq + 2}, messageSeqs(msgs))
+
+	msgs, err = store.LoadPrevRangeMsgs("g1", okproto.ChannelTypeGroup, 50, 0, 100)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 50-result.Messages)
+	assert.Equal(t, firstSeq, msgs[0].GetSeq())
+
+	// 向下加载从最早的消息开始
+	msgs, err = store.LoadNextRangeMsgs("g1", okproto.ChannelTypeGroup, 1, 0, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{firstSeq, firstSeq + 1, firstSeq + 2}, messageSeqs(msgs))
+}
+
+func TestFileStoreCompactByChannelType(t *testing.T) {
+	cfg := storetest.NewStoreConfig(t)
+	cfg.SegmentMaxBytes = 200
+	cfg.ChannelTypeRetentions[okproto.ChannelTypePerson] = okstore.RetentionPolicy{MaxBytes: 300}
+	cfg.ArchiveDir = t.TempDir()
+	store := okstore.NewFileStore(cfg)
+	err := store.Open()
+	assert.NoError(t, err)
+	defer store.Close()
+
+	appendTestMessages(t, store, "u1", okproto.ChannelTypePerson, 50)
+	appendTestMessages(t, store, "g1", okproto.ChannelTypeGroup, 50)
+	segmentCount := countSegments(t, cfg.DataDir)
+
+	result, err := store.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Topics)
+	assert.True(t, result.Segments > 0)
+	assert.Equal(t, segmentCount-result.Segments, countSegments(t, cfg.DataDir))
+
+	// 超出保留策略的segment移到归档目录
+	assert.Equal(t, result.Segments, countSegments(t, cfg.ArchiveDir))
+
+	// 没有保留策略的频道不压缩
+	msgs, err := store.LoadNextRangeMsgs("g1", okproto.ChannelTypeGroup, 0, 0, 100)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 50)
+}
+
+func TestFileStoreCompactByMaxAge(t *testing.T) {
+	cfg := storetest.NewStoreConfig(t)
+	cfg.SegmentMaxBytes = 200
+	cfg.Retention = okstore.RetentionPolicy{MaxAge: 3600}
+	store := okstore.NewFileStore(cfg)
+	err := store.Open()
+	assert.NoError(t, err)
+	defer store.Close()
+
+	appendTestMessages(t, store, "g1", okproto.ChannelTypeGroup, 50)
+
+	result, err := store.CompactChannel("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Segments)
+
+	// segment最后写入时间超过保留时间
+	files, err := filepath.Glob(filepath.Join(cfg.DataDir, "*", "topics", "*", "logs", "*.log"))
+	assert.NoError(t, err)
+	oldTime := time.Now().Add(-time.Hour * 2)
+	for _, file := range files {
+		err = os.Chtimes(file, oldTime, oldTime)
+		assert.NoError(t, err)
+	}
+	result, err = store.CompactChannel("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, len(files)-1, result.Segments) // 正在写入的segment不删除
+	assert.Equal(t, 1, countSegments(t, cfg.DataDir))
+
+	lastSeq, err := store.GetLastMsgSeq("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(50), lastSeq)
+}
+
+func appendTestMessages(t *testing.T, store okstore.Store, channelID string, channelType uint8, count int) {
+	for i := 1; i <= count; i++ {
+		_, err := store.AppendMessages(channelID, channelType, []okstore.Message{&storetest.Message{
+			MessageID: int64(i),
+			Payload:   []byte(fmt.Sprintf("msg%d", i)),
+		}})
+		assert.NoError(t, err)
+	}
+}
@@ -80,6 +80,7 @@ func (s *segment) appendMessages(msgs []Message) (int, error) {
 		s.bytesSinceLastIndexEntry = 0
 	}
 	s.bytesSinceLastIndexEntry += int64(n)
+	s.lastMsgSeq.Store(msgs[len(msgs)-1].GetSeq())
 	return n, nil
 }
 
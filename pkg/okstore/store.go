@@ -1,5 +1,7 @@
 package okstore
 
+import "encoding/json"
+
 type Store interface {
 	Open() error
 	Close() error
@@ -9,6 +11,14 @@ type Store interface {
 	UpdateUserToken(uid string, deviceFlag uint8, deviceLevel uint8, token string) error
 	// UpdateMessageOfUserCursorIfNeed 更新用户消息队列的游标，用户读到的位置
 	UpdateMessageOfUserCursorIfNeed(uid string, messageSeq uint32) error
+	// SetUserRateLimit 设置用户单独的发消息限流（优先于全局配置） limit为nil表示删除用户的限流设置
+	SetUserRateLimit(uid string, limit *RateLimit) error
+	// GetUserRateLimit 获取用户单独设置的发消息限流，没有设置返回nil
+	GetUserRateLimit(uid string) (*RateLimit, error)
+	// UpdateUserLastSeen 更新用户最后在线时间（10位时间戳）
+	UpdateUserLastSeen(uid string, lastSeen int64) error
+	// GetUserLastSeen 获取用户最后在线时间，没有记录返回0
+	GetUserLastSeen(uid string) (int64, error)
 
 	// #################### channel ####################
 	GetChannel(channelID string, channelType uint8) (*ChannelInfo, error)
@@ -71,6 +81,61 @@ type Store interface {
 
 	DeleteChannelAndClearMessages(channelID string, channelType uint8) error
 
+	// #################### retention ####################
+	// SetChannelRetention 设置频道的消息保留策略（优先于频道类型和默认的保留策略） policy为nil表示删除频道的保留策略
+	SetChannelRetention(channelID string, channelType uint8, policy *RetentionPolicy) error
+	// GetChannelRetention 获取频道单独设置的消息保留策略，没有设置返回nil
+	GetChannelRetention(channelID string, channelType uint8) (*RetentionPolicy, error)
+	// CompactChannel 按保留策略压缩频道的消息（删除或归档超出保留策略和已过期的消息）
+	CompactChannel(channelID string, channelType uint8) (*CompactResult, error)
+	// Compact 按保留策略压缩所有频道的消息
+	Compact() (*CompactResult, error)
+
+	// #################### message extra ####################
+	// AddOrUpdateMessageExtras 添加或更新消息扩展数据（撤回等）
+	AddOrUpdateMessageExtras(channelID string, channelType uint8, extras []*MessageExtra) error
+	// GetMessageExtras 获取指定消息序号的扩展数据，没有扩展数据的消息不返回
+	GetMessageExtras(channelID string, channelType uint8, messageSeqs []uint32) ([]*MessageExtra, error)
+	// AppendMessageEdit 追加消息的编辑记录
+	AppendMessageEdit(channelID string, channelType uint8, edit *MessageEdit) error
+	// GetMessageEdits 获取消息的编辑记录（按编辑版本升序）
+	GetMessageEdits(channelID string, channelType uint8, messageSeq uint32) ([]*MessageEdit, error)
+
+	// #################### message delete ####################
+	// AddMessageDeleteMarker 添加消息删除标记
+	AddMessageDeleteMarker(channelID string, channelType uint8, marker *MessageDeleteMarker) error
+	// GetMessageDeleteMarkers 获取对所有人的删除标记以及对uid的删除标记（uid为空则只返回对所有人的删除标记）
+	GetMessageDeleteMarkers(channelID string, channelType uint8, uid string) (MessageDeleteMarkers, error)
+
+	// #################### message receipt ####################
+	// AddMessageReaders 添加消息的已读用户，返回本次新增已读的消息序号（已经读过的不返回）
+	AddMessageReaders(channelID string, channelType uint8, uid string, messageSeqs []uint32, readedAt int64) ([]uint32, error)
+	// GetMessageReaders 获取消息的已读用户
+	GetMessageReaders(channelID string, channelType uint8, messageSeq uint32) ([]*MessageReader, error)
+	// GetChannelReadedSeq 获取用户在频道内已读到的消息序号
+	GetChannelReadedSeq(uid string, channelID string, channelType uint8) (uint32, error)
+	// UpdateChannelReadedSeqIfNeed 更新用户在频道内已读到的消息序号（只会变大），返回更新前的已读序号
+	UpdateChannelReadedSeqIfNeed(uid string, channelID string, channelType uint8, messageSeq uint32) (uint32, error)
+
+	// #################### message reaction ####################
+	// SetReaction 添加或移除消息的回应（同一用户对同一消息的同一表情只有一条记录，reaction.Deleted为true表示移除）
+	// 每次变化都会生成频道内递增的回应序号，返回变化后的回应，没有变化（重复添加或移除不存在的回应）返回nil
+	SetReaction(channelID string, channelType uint8, reaction *Reaction) (*Reaction, error)
+	// SyncReactions 同步频道内回应序号大于seq的回应变化（按回应序号升序，包含已移除的回应）
+	SyncReactions(channelID string, channelType uint8, seq uint64, limit int) ([]*Reaction, error)
+
+	// #################### thread ####################
+	// AddOrUpdateThread 添加或更新频道的子区（以被回复的消息序号为唯一标识）
+	AddOrUpdateThread(channelID string, channelType uint8, thread *Thread) error
+	// GetThreads 获取频道的子区，按最后回复时间倒序 limit为0表示不限制
+	GetThreads(channelID string, channelType uint8, limit int) ([]*Thread, error)
+
+	// #################### message pin ####################
+	// SetChannelPins 保存频道的置顶消息
+	SetChannelPins(channelID string, channelType uint8, pins *ChannelPins) error
+	// GetChannelPins 获取频道的置顶消息，没有置顶过消息的频道返回版本号为0的空列表
+	GetChannelPins(channelID string, channelType uint8) (*ChannelPins, error)
+
 	// #################### conversations ####################
 	AddOrUpdateConversations(uid string, conversations []*Conversation) error
 	GetConversations(uid string) ([]*Conversation, error)
@@ -94,6 +159,26 @@ type Store interface {
 	// GetStreamItems 获取消息流
 	GetStreamItems(channelID string, channelType uint8, streamNo string) ([]*StreamItem, error)
 
+	// #################### in flight ####################
+	// AddOrUpdateInFlightMessages 保存投递中的消息（uid、设备ID和消息ID相同的覆盖）
+	AddOrUpdateInFlightMessages(messages []*InFlightMessage) error
+	// RemoveInFlightMessages 移除投递中的消息（按uid、设备ID和消息ID）
+	RemoveInFlightMessages(messages []*InFlightMessage) error
+	// GetInFlightMessages 获取本节点所有投递中的消息
+	GetInFlightMessages() ([]*InFlightMessage, error)
+
+	// #################### api key ####################
+	// AddOrUpdateAPIKey 添加或更新api密钥（按密钥ID）
+	AddOrUpdateAPIKey(apiKey *APIKey) error
+	// RemoveAPIKey 移除api密钥
+	RemoveAPIKey(id string) error
+	// GetAPIKeys 获取所有api密钥（按密钥ID排序）
+	GetAPIKeys() ([]*APIKey, error)
+	// SetAPIKeyEnabled 标记已开启api密钥校验（创建过api密钥后即使密钥都被移除也继续校验）
+	SetAPIKeyEnabled() error
+	// GetAPIKeyEnabled 是否已开启api密钥校验
+	GetAPIKeyEnabled() (bool, error)
+
 	// AddIPBlacklist 添加ip黑名单
 	AddIPBlacklist(ips []string) error
 	// RemoveIPBlacklist 移除ip黑名单
@@ -107,14 +192,91 @@ type ChannelInfo struct {
 	ChannelType uint8  `json:"-"`
 	Ban         bool   `json:"ban"`   // 是否被封
 	Large       bool   `json:"large"` // 是否是超大群
+	ChannelModeration
+}
+
+// ChannelModeration 频道的禁言设置，到期时间都是10位时间戳（到秒），0表示不过期
+type ChannelModeration struct {
+	Admins       []string         `json:"admins,omitempty"`         // 管理员（不受全员禁言和慢速模式限制）
+	Mute         bool             `json:"mute,omitempty"`           // 是否全员禁言（管理员除外）
+	MuteExpireAt int64            `json:"mute_expire_at,omitempty"` // 全员禁言的到期时间
+	SlowMode     uint32           `json:"slow_mode,omitempty"`      // 慢速模式，每个成员每N秒只能发一次消息（管理员除外） 0表示关闭
+	MemberMutes  map[string]int64 `json:"member_mutes,omitempty"`   // 被禁言的成员，value为禁言的到期时间
+}
+
+// Clone Clone
+func (c ChannelModeration) Clone() ChannelModeration {
+	clone := c
+	if c.Admins != nil {
+		clone.Admins = append([]string{}, c.Admins...)
+	}
+	if c.MemberMutes != nil {
+		clone.MemberMutes = make(map[string]int64, len(c.MemberMutes))
+		for uid, expireAt := range c.MemberMutes {
+			clone.MemberMutes[uid] = expireAt
+		}
+	}
+	return clone
+}
+
+// Muted 是否正在全员禁言
+func (c ChannelModeration) Muted(now int64) bool {
+	return c.Mute && (c.MuteExpireAt == 0 || c.MuteExpireAt > now)
+}
+
+// MemberMuted 成员是否正在被禁言
+func (c ChannelModeration) MemberMuted(uid string, now int64) bool {
+	expireAt, ok := c.MemberMutes[uid]
+	return ok && (expireAt == 0 || expireAt > now)
+}
+
+// IsAdmin 是否是管理员
+func (c ChannelModeration) IsAdmin(uid string) bool {
+	for _, admin := range c.Admins {
+		if admin == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// ClearExpired 清除已到期的禁言，返回是否有变化
+func (c *ChannelModeration) ClearExpired(now int64) bool {
+	changed := false
+	if c.Mute && !c.Muted(now) {
+		c.Mute = false
+		c.MuteExpireAt = 0
+		changed = true
+	}
+	for uid := range c.MemberMutes {
+		if !c.MemberMuted(uid, now) {
+			delete(c.MemberMutes, uid)
+			changed = true
+		}
+	}
+	return changed
 }
 
 // ToMap ToMap
 func (c *ChannelInfo) ToMap() map[string]interface{} {
-	return map[string]interface{}{
+	mp := map[string]interface{}{
 		"ban":   c.Ban,
 		"large": c.Large,
 	}
+	if len(c.Admins) > 0 {
+		mp["admins"] = c.Admins
+	}
+	if c.Mute {
+		mp["mute"] = c.Mute
+		mp["mute_expire_at"] = c.MuteExpireAt
+	}
+	if c.SlowMode > 0 {
+		mp["slow_mode"] = c.SlowMode
+	}
+	if len(c.MemberMutes) > 0 {
+		mp["member_mutes"] = c.MemberMutes
+	}
+	return mp
 }
 
 // NewChannelInfo NewChannelInfo
@@ -131,7 +293,39 @@ func (c *ChannelInfo) from(mp map[string]interface{}) {
 	if mp["large"] != nil {
 		c.Large = mp["large"].(bool)
 	}
+	if admins, ok := mp["admins"].([]interface{}); ok {
+		c.Admins = make([]string, 0, len(admins))
+		for _, admin := range admins {
+			c.Admins = append(c.Admins, admin.(string))
+		}
+	}
+	if mp["mute"] != nil {
+		c.Mute = mp["mute"].(bool)
+	}
+	if mp["mute_expire_at"] != nil {
+		c.MuteExpireAt = jsonInt64(mp["mute_expire_at"])
+	}
+	if mp["slow_mode"] != nil {
+		c.SlowMode = uint32(jsonInt64(mp["slow_mode"]))
+	}
+	if memberMutes, ok := mp["member_mutes"].(map[string]interface{}); ok {
+		c.MemberMutes = make(map[string]int64, len(memberMutes))
+		for uid, expireAt := range memberMutes {
+			c.MemberMutes[uid] = jsonInt64(expireAt)
+		}
+	}
+}
 
+// 解析json里的数字（okutil.ReadJSONByByte解析的数字为json.Number）
+func jsonInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case json.Number:
+		i, _ := n.Int64()
+		return i
+	case float64:
+		return int64(n)
+	}
+	return 0
 }
 
 // 订阅信息
@@ -59,3 +59,31 @@ func (t *testMessage) Decode(msg []byte) error {
 func (t *testMessage) SetSeq(seq uint32) {
 	t.seq = seq
 }
+
+func TestChannelModeration(t *testing.T) {
+	moderation := ChannelModeration{
+		Admins:       []string{"admin"},
+		Mute:         true,
+		MuteExpireAt: 100,
+		MemberMutes:  map[string]int64{"u1": 0, "u2": 100},
+	}
+	assert.True(t, moderation.IsAdmin("admin"))
+	assert.False(t, moderation.IsAdmin("u1"))
+	assert.True(t, moderation.Muted(99))
+	assert.False(t, moderation.Muted(100))
+	assert.True(t, moderation.MemberMuted("u1", 1000))
+	assert.True(t, moderation.MemberMuted("u2", 99))
+	assert.False(t, moderation.MemberMuted("u2", 100))
+	assert.False(t, moderation.MemberMuted("u3", 0))
+
+	clone := moderation.Clone()
+	clone.MemberMutes["u3"] = 0
+	clone.Admins[0] = "other"
+	assert.Equal(t, 2, len(moderation.MemberMutes))
+	assert.Equal(t, "admin", moderation.Admins[0])
+
+	assert.False(t, moderation.ClearExpired(99))
+	assert.True(t, moderation.ClearExpired(100))
+	assert.False(t, moderation.Mute)
+	assert.Equal(t, map[string]int64{"u1": 0}, moderation.MemberMutes)
+}
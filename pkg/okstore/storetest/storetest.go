@@ -0,0 +1,1045 @@
+// Package storetest okstore.Store的一致性测试，所有存储实现都必须通过
+//
+//	func TestMyStore(t *testing.T) {
+//		storetest.RunStoreTests(t, func(cfg *okstore.StoreConfig) okstore.Store {
+//			return NewMyStore(cfg)
+//		})
+//	}
+package storetest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/samlau0508/imserver/pkg/okstore"
+	okproto "github.com/samlau0508/imserver/pkg/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// Message 测试用的消息
+type Message struct {
+	MessageID  int64
+	MessageSeq uint32
+	ExpireAt   int64 // 过期时间（10位时间戳） 0表示永不过期
+	Payload    []byte
+}
+
+func (m *Message) GetMessageID() int64 {
+	return m.MessageID
+}
+
+func (m *Message) SetSeq(seq uint32) {
+	m.MessageSeq = seq
+}
+
+func (m *Message) GetSeq() uint32 {
+	return m.MessageSeq
+}
+
+func (m *Message) GetExpireAt() int64 {
+	return m.ExpireAt
+}
+
+func (m *Message) Encode() []byte {
+	data := make([]byte, 16+len(m.Payload))
+	binary.BigEndian.PutUint64(data, uint64(m.MessageID))
+	binary.BigEndian.PutUint64(data[8:], uint64(m.ExpireAt))
+	copy(data[16:], m.Payload)
+	return okstore.EncodeMessage(m.MessageSeq, data)
+}
+
+func (m *Message) Decode(msg []byte) error {
+	seq, data, err := okstore.DecodeMessage(msg)
+	if err != nil {
+		return err
+	}
+	if len(data) < 16 {
+		return fmt.Errorf("message data too short")
+	}
+	m.MessageSeq = seq
+	m.MessageID = int64(binary.BigEndian.Uint64(data))
+	m.ExpireAt = int64(binary.BigEndian.Uint64(data[8:]))
+	m.Payload = append([]byte(nil), data[16:]...)
+	return nil
+}
+
+// DecodeMessage 测试消息的解码方法，用于StoreConfig.DecodeMessageFnc
+func DecodeMessage(msg []byte) (okstore.Message, error) {
+	m := &Message{}
+	err := m.Decode(msg)
+	return m, err
+}
+
+// NewStoreConfig 测试用的存储配置，数据目录在测试结束后会被删除
+func NewStoreConfig(t *testing.T) *okstore.StoreConfig {
+	cfg := okstore.NewStoreConfig()
+	cfg.SlotNum = 16
+	cfg.DataDir = t.TempDir()
+	cfg.SegmentMaxBytes = 1024 * 1024 * 10
+	cfg.DecodeMessageFnc = DecodeMessage
+	return cfg
+}
+
+// RunStoreTests 对newStore创建的存储执行一致性测试，每个子测试使用一个新的存储
+func RunStoreTests(t *testing.T, newStore okstore.NewStoreFunc) {
+	tests := []struct {
+		name string
+		fnc  func(t *testing.T, store okstore.Store)
+	}{
+		{"UserToken", testUserToken},
+		{"Channel", testChannel},
+		{"Subscribers", testSubscribers},
+		{"Denylist", testDenylist},
+		{"Allowlist", testAllowlist},
+		{"Messages", testMessages},
+		{"MessageRange", testMessageRange},
+		{"MessageExpire", testMessageExpire},
+		{"Retention", testRetention},
+		{"UserRateLimit", testUserRateLimit},
+		{"UserLastSeen", testUserLastSeen},
+		{"MessagesOfUser", testMessagesOfUser},
+		{"NotifyQueue", testNotifyQueue},
+		{"MessageExtras", testMessageExtras},
+		{"MessageDeleteMarkers", testMessageDeleteMarkers},
+		{"MessageReceipt", testMessageReceipt},
+		{"Reactions", testReactions},
+		{"Threads", testThreads},
+		{"ChannelPins", testChannelPins},
+		{"Conversations", testConversations},
+		{"SystemUIDs", testSystemUIDs},
+		{"Streams", testStreams},
+		{"IPBlacklist", testIPBlacklist},
+		{"APIKeys", testAPIKeys},
+		{"InFlightMessages", testInFlightMessages},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			store := newStore(NewStoreConfig(t))
+			err := store.Open()
+			assert.NoError(t, err)
+			defer store.Close()
+			tt.fnc(t, store)
+		})
+	}
+}
+
+func testUserToken(t *testing.T, store okstore.Store) {
+	token, level, err := store.GetUserToken("u1", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "", token)
+	assert.Equal(t, uint8(0), level)
+
+	err = store.UpdateUserToken("u1", 0, 1, "token1")
+	assert.NoError(t, err)
+	err = store.UpdateUserToken("u1", 1, 0, "token2")
+	assert.NoError(t, err)
+
+	token, level, err = store.GetUserToken("u1", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "token1", token)
+	assert.Equal(t, uint8(1), level)
+
+	token, level, err = store.GetUserToken("u1", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "token2", token)
+	assert.Equal(t, uint8(0), level)
+}
+
+func testChannel(t *testing.T, store okstore.Store) {
+	channelInfo, err := store.GetChannel("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Nil(t, channelInfo)
+
+	exist, err := store.ExistChannel("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.False(t, exist)
+
+	err = store.AddOrUpdateChannel(&okstore.ChannelInfo{
+		ChannelID:   "g1",
+		ChannelType: okproto.ChannelTypeGroup,
+		Ban:         true,
+	})
+	assert.NoError(t, err)
+
+	channelInfo, err = store.GetChannel("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.NotNil(t, channelInfo)
+	assert.Equal(t, "g1", channelInfo.ChannelID)
+	assert.Equal(t, okproto.ChannelTypeGroup, channelInfo.ChannelType)
+	assert.True(t, channelInfo.Ban)
+	assert.False(t, channelInfo.Large)
+
+	err = store.AddOrUpdateChannel(&okstore.ChannelInfo{
+		ChannelID:   "g1",
+		ChannelType: okproto.ChannelTypeGroup,
+		Large:       true,
+	})
+	assert.NoError(t, err)
+	channelInfo, err = store.GetChannel("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.False(t, channelInfo.Ban)
+	assert.True(t, channelInfo.Large)
+
+	// 禁言设置
+	err = store.AddOrUpdateChannel(&okstore.ChannelInfo{
+		ChannelID:   "g1",
+		ChannelType: okproto.ChannelTypeGroup,
+		ChannelModeration: okstore.ChannelModeration{
+			Admins:       []string{"u1"},
+			Mute:         true,
+			MuteExpireAt: 1700000000,
+			SlowMode:     10,
+			MemberMutes:  map[string]int64{"u2": 0, "u3": 1700000100},
+		},
+	})
+	assert.NoError(t, err)
+	channelInfo, err = store.GetChannel("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"u1"}, channelInfo.Admins)
+	assert.True(t, channelInfo.Mute)
+	assert.Equal(t, int64(1700000000), channelInfo.MuteExpireAt)
+	assert.Equal(t, uint32(10), channelInfo.SlowMode)
+	assert.Equal(t, map[string]int64{"u2": 0, "u3": 1700000100}, channelInfo.MemberMutes)
+	channelInfo.MemberMutes["u4"] = 0 // 修改获取到的数据不影响已保存的数据
+	channelInfo, err = store.GetChannel("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(channelInfo.MemberMutes))
+
+	// 频道类型不同是不同的频道
+	exist, err = store.ExistChannel("g1", okproto.ChannelTypePerson)
+	assert.NoError(t, err)
+	assert.False(t, exist)
+
+	err = store.DeleteChannel("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	exist, err = store.ExistChannel("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.False(t, exist)
+}
+
+func testSubscribers(t *testing.T, store okstore.Store) {
+	testList(t, listFncs{
+		add:       store.AddSubscribers,
+		remove:    store.RemoveSubscribers,
+		removeAll: store.RemoveAllSubscriber,
+		get:       store.GetSubscribers,
+	})
+}
+
+func testDenylist(t *testing.T, store okstore.Store) {
+	testList(t, listFncs{
+		add:       store.AddDenylist,
+		remove:    store.RemoveDenylist,
+		removeAll: store.RemoveAllDenylist,
+		get:       store.GetDenylist,
+	})
+}
+
+func testAllowlist(t *testing.T, store okstore.Store) {
+	testList(t, listFncs{
+		add:       store.AddAllowlist,
+		remove:    store.RemoveAllowlist,
+		removeAll: store.RemoveAllAllowlist,
+		get:       store.GetAllowlist,
+	})
+}
+
+type listFncs struct {
+	add       func(channelID string, channelType uint8, uids []string) error
+	remove    func(channelID string, channelType uint8, uids []string) error
+	removeAll func(channelID string, channelType uint8) error
+	get       func(channelID string, channelType uint8) ([]string, error)
+}
+
+func testList(t *testing.T, fncs listFncs) {
+	uids, err := fncs.get("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Empty(t, uids)
+
+	err = fncs.add("g1", okproto.ChannelTypeGroup, []string{"u1", "u2"})
+	assert.NoError(t, err)
+	err = fncs.add("g1", okproto.ChannelTypeGroup, []string{"u3"})
+	assert.NoError(t, err)
+	err = fncs.add("g2", okproto.ChannelTypeGroup, []string{"u4"})
+	assert.NoError(t, err)
+
+	uids, err = fncs.get("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"u1", "u2", "u3"}, uids)
+
+	// 频道类型不同是不同的列表
+	uids, err = fncs.get("g1", okproto.ChannelTypeCommunity)
+	assert.NoError(t, err)
+	assert.Empty(t, uids)
+
+	err = fncs.remove("g1", okproto.ChannelTypeGroup, []string{"u2", "u5"})
+	assert.NoError(t, err)
+	uids, err = fncs.get("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"u1", "u3"}, uids)
+
+	err = fncs.removeAll("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	uids, err = fncs.get("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Empty(t, uids)
+
+	uids, err = fncs.get("g2", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"u4"}, uids)
+}
+
+func testMessages(t *testing.T, store okstore.Store) {
+	lastSeq, err := store.GetLastMsgSeq("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), lastSeq)
+
+	msgs := newMessages(1, 3)
+	seqs, err := store.AppendMessages("g1", okproto.ChannelTypeGroup, msgs)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2, 3}, seqs)
+	for i, msg := range msgs {
+		assert.Equal(t, seqs[i], msg.GetSeq())
+	}
+
+	seqs, err = store.AppendMessages("g1", okproto.ChannelTypeGroup, newMessages(4, 2))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{4, 5}, seqs)
+
+	// 每个频道的消息序号是独立的
+	seqs, err = store.AppendMessages("g1", okproto.ChannelTypePerson, newMessages(100, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1}, seqs)
+
+	lastSeq, err = store.GetLastMsgSeq("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(5), lastSeq)
+
+	msg, err := store.LoadMsg("g1", okproto.ChannelTypeGroup, 2)
+	assert.NoError(t, err)
+	assertMessage(t, msg, 2, 2)
+
+	msg, err = store.LoadMsg("g1", okproto.ChannelTypePerson, 1)
+	assert.NoError(t, err)
+	assertMessage(t, msg, 100, 1)
+
+	// 读取到的消息修改后不影响存储的数据
+	msg.(*Message).Payload[0] = 'x'
+	msg, err = store.LoadMsg("g1", okproto.ChannelTypePerson, 1)
+	assert.NoError(t, err)
+	assertMessage(t, msg, 100, 1)
+
+	msgs, err = store.LoadLastMsgs("g1", okproto.ChannelTypeGroup, 2)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{4, 5}, msgs)
+
+	msgs, err = store.LoadLastMsgs("g1", okproto.ChannelTypeGroup, 10)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{1, 2, 3, 4, 5}, msgs)
+	for _, msg := range msgs {
+		assertMessage(t, msg, int64(msg.GetSeq()), msg.GetSeq())
+	}
+
+	msgs, err = store.LoadLastMsgsWithEnd("g1", okproto.ChannelTypeGroup, 3, 10)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{4, 5}, msgs)
+
+	msgs, err = store.LoadLastMsgsWithEnd("g1", okproto.ChannelTypeGroup, 0, 3)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{3, 4, 5}, msgs)
+}
+
+func testMessageRange(t *testing.T, store okstore.Store) {
+	_, err := store.AppendMessages("g1", okproto.ChannelTypeGroup, newMessages(1, 10))
+	assert.NoError(t, err)
+
+	// 向上加载 结果包含start 不包含end
+	msgs, err := store.LoadPrevRangeMsgs("g1", okproto.ChannelTypeGroup, 5, 0, 3)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{3, 4, 5}, msgs)
+
+	msgs, err = store.LoadPrevRangeMsgs("g1", okproto.ChannelTypeGroup, 5, 3, 10)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{4, 5}, msgs)
+
+	msgs, err = store.LoadPrevRangeMsgs("g1", okproto.ChannelTypeGroup, 2, 0, 10)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{1, 2}, msgs)
+
+	_, err = store.LoadPrevRangeMsgs("g1", okproto.ChannelTypeGroup, 0, 0, 10)
+	assert.Error(t, err)
+
+	// 向下加载 结果包含start 不包含end
+	msgs, err = store.LoadNextRangeMsgs("g1", okproto.ChannelTypeGroup, 3, 0, 3)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{3, 4, 5}, msgs)
+
+	msgs, err = store.LoadNextRangeMsgs("g1", okproto.ChannelTypeGroup, 3, 5, 10)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{3, 4}, msgs)
+
+	msgs, err = store.LoadNextRangeMsgs("g1", okproto.ChannelTypeGroup, 8, 0, 10)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{8, 9, 10}, msgs)
+}
+
+func testMessageExpire(t *testing.T, store okstore.Store) {
+	msgs := newMessages(1, 6)
+	expiredAt := time.Now().Add(-time.Minute).Unix()
+	msgs[1].(*Message).ExpireAt = expiredAt
+	msgs[2].(*Message).ExpireAt = expiredAt
+	msgs[4].(*Message).ExpireAt = expiredAt
+	msgs[5].(*Message).ExpireAt = time.Now().Add(time.Hour).Unix()
+	_, err := store.AppendMessages("g1", okproto.ChannelTypeGroup, msgs)
+	assert.NoError(t, err)
+
+	// 过期的消息不返回，不够limit继续加载
+	loadMsgs, err := store.LoadNextRangeMsgs("g1", okproto.ChannelTypeGroup, 1, 0, 3)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{1, 4, 6}, loadMsgs)
+
+	loadMsgs, err = store.LoadNextRangeMsgs("g1", okproto.ChannelTypeGroup, 2, 5, 10)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{4}, loadMsgs)
+
+	loadMsgs, err = store.LoadPrevRangeMsgs("g1", okproto.ChannelTypeGroup, 6, 0, 2)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{4, 6}, loadMsgs)
+
+	loadMsgs, err = store.LoadPrevRangeMsgs("g1", okproto.ChannelTypeGroup, 5, 0, 10)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{1, 4}, loadMsgs)
+
+	loadMsgs, err = store.LoadLastMsgs("g1", okproto.ChannelTypeGroup, 3)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{1, 4, 6}, loadMsgs)
+
+	loadMsgs, err = store.LoadLastMsgsWithEnd("g1", okproto.ChannelTypeGroup, 2, 10)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{4, 6}, loadMsgs)
+
+	// 用户队列同步同样不返回过期的消息
+	msgs = newMessages(1, 3)
+	msgs[0].(*Message).ExpireAt = expiredAt
+	_, err = store.AppendMessagesOfUser("u1", msgs)
+	assert.NoError(t, err)
+	loadMsgs, err = store.SyncMessageOfUser("u1", 1, 10)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{2, 3}, loadMsgs)
+}
+
+func testRetention(t *testing.T, store okstore.Store) {
+	policy, err := store.GetChannelRetention("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+
+	err = store.SetChannelRetention("g1", okproto.ChannelTypeGroup, &okstore.RetentionPolicy{MaxMessages: 5})
+	assert.NoError(t, err)
+	policy, err = store.GetChannelRetention("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, &okstore.RetentionPolicy{MaxMessages: 5}, policy)
+
+	_, err = store.AppendMessages("g1", okproto.ChannelTypeGroup, newMessages(1, 20))
+	assert.NoError(t, err)
+	_, err = store.AppendMessages("g2", okproto.ChannelTypeGroup, newMessages(1, 20))
+	assert.NoError(t, err)
+
+	result, err := store.CompactChannel("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.True(t, result.Messages <= 15)
+
+	// 至少保留最新的5条消息，已删除的消息不返回且剩下的消息序号连续
+	loadMsgs, err := store.LoadLastMsgs("g1", okproto.ChannelTypeGroup, 5)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{16, 17, 18, 19, 20}, loadMsgs)
+
+	loadMsgs, err = store.LoadPrevRangeMsgs("g1", okproto.ChannelTypeGroup, 20, 0, 100)
+	assert.NoError(t, err)
+	assert.Len(t, loadMsgs, 20-result.Messages)
+	assertMessage(t, loadMsgs[0], int64(result.Messages+1), uint32(result.Messages+1))
+
+	loadMsgs, err = store.LoadNextRangeMsgs("g1", okproto.ChannelTypeGroup, 0, 0, 100)
+	assert.NoError(t, err)
+	assert.Len(t, loadMsgs, 20-result.Messages)
+
+	// 没有保留策略的频道不压缩
+	_, err = store.Compact()
+	assert.NoError(t, err)
+	loadMsgs, err = store.LoadNextRangeMsgs("g2", okproto.ChannelTypeGroup, 0, 0, 100)
+	assert.NoError(t, err)
+	assert.Len(t, loadMsgs, 20)
+
+	err = store.SetChannelRetention("g1", okproto.ChannelTypeGroup, nil)
+	assert.NoError(t, err)
+	policy, err = store.GetChannelRetention("g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+}
+
+func testUserRateLimit(t *testing.T, store okstore.Store) {
+	limit, err := store.GetUserRateLimit("u1")
+	assert.NoError(t, err)
+	assert.Nil(t, limit)
+
+	err = store.SetUserRateLimit("u1", &okstore.RateLimit{Rate: 0.5, Burst: 3})
+	assert.NoError(t, err)
+	limit, err = store.GetUserRateLimit("u1")
+	assert.NoError(t, err)
+	assert.Equal(t, &okstore.RateLimit{Rate: 0.5, Burst: 3}, limit)
+
+	limit, err = store.GetUserRateLimit("u2")
+	assert.NoError(t, err)
+	assert.Nil(t, limit)
+
+	err = store.SetUserRateLimit("u1", nil)
+	assert.NoError(t, err)
+	limit, err = store.GetUserRateLimit("u1")
+	assert.NoError(t, err)
+	assert.Nil(t, limit)
+}
+
+func testUserLastSeen(t *testing.T, store okstore.Store) {
+	lastSeen, err := store.GetUserLastSeen("u1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), lastSeen)
+
+	err = store.UpdateUserLastSeen("u1", 1700000000)
+	assert.NoError(t, err)
+	err = store.UpdateUserLastSeen("u1", 1700000100)
+	assert.NoError(t, err)
+	lastSeen, err = store.GetUserLastSeen("u1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1700000100), lastSeen)
+
+	lastSeen, err = store.GetUserLastSeen("u2")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), lastSeen)
+}
+
+func testReactions(t *testing.T, store okstore.Store) {
+	channelID := "reactionChannel"
+	channelType := uint8(2)
+
+	reaction, err := store.SetReaction(channelID, channelType, &okstore.Reaction{MessageID: 1, MessageSeq: 1, UID: "u1", Emoji: "👍", Deleted: true})
+	assert.NoError(t, err)
+	assert.Nil(t, reaction) // 移除不存在的回应
+
+	reaction, err = store.SetReaction(channelID, channelType, &okstore.Reaction{MessageID: 1, MessageSeq: 1, UID: "u1", Emoji: "👍", CreatedAt: 100})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), reaction.Seq)
+	reaction, err = store.SetReaction(channelID, channelType, &okstore.Reaction{MessageID: 1, MessageSeq: 1, UID: "u1", Emoji: "👍"})
+	assert.NoError(t, err)
+	assert.Nil(t, reaction) // 重复添加
+	reaction, err = store.SetReaction(channelID, channelType, &okstore.Reaction{MessageID: 1, MessageSeq: 1, UID: "u2", Emoji: "👍"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), reaction.Seq)
+	reaction, err = store.SetReaction(channelID, channelType, &okstore.Reaction{MessageID: 2, MessageSeq: 2, UID: "u1", Emoji: "❤️"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), reaction.Seq)
+	reaction, err = store.SetReaction(channelID, channelType, &okstore.Reaction{MessageID: 1, MessageSeq: 1, UID: "u1", Emoji: "👍", Deleted: true, CreatedAt: 200})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), reaction.Seq)
+
+	// 同一回应只保留最新的变化
+	reactions, err := store.SyncReactions(channelID, channelType, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(reactions))
+	assert.Equal(t, []uint64{2, 3, 4}, []uint64{reactions[0].Seq, reactions[1].Seq, reactions[2].Seq})
+	assert.Equal(t, "u1", reactions[2].UID)
+	assert.True(t, reactions[2].Deleted)
+	assert.Equal(t, int64(200), reactions[2].CreatedAt)
+
+	reactions, err = store.SyncReactions(channelID, channelType, 2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(reactions))
+	assert.Equal(t, uint64(3), reactions[0].Seq)
+
+	reactions, err = store.SyncReactions(channelID, channelType, 4, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, reactions)
+	reactions, err = store.SyncReactions("other", channelType, 0, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, reactions)
+}
+
+func testThreads(t *testing.T, store okstore.Store) {
+	channelID := "community1"
+	channelType := uint8(4)
+
+	threads, err := store.GetThreads(channelID, channelType, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, threads)
+
+	err = store.AddOrUpdateThread(channelID, channelType, &okstore.Thread{RootMessageID: 1, RootMessageSeq: 1, ReplyCount: 1, LastReplyUID: "u1", LastReplyAt: 100})
+	assert.NoError(t, err)
+	err = store.AddOrUpdateThread(channelID, channelType, &okstore.Thread{RootMessageID: 2, RootMessageSeq: 2, ReplyCount: 1, LastReplyUID: "u2", LastReplyAt: 200})
+	assert.NoError(t, err)
+	err = store.AddOrUpdateThread(channelID, channelType, &okstore.Thread{RootMessageID: 1, RootMessageSeq: 1, ReplyCount: 2, LastReplyUID: "u3", LastReplyMessageSeq: 2, LastReplyAt: 300})
+	assert.NoError(t, err)
+	err = store.AddOrUpdateThread("community10", channelType, &okstore.Thread{RootMessageID: 3, RootMessageSeq: 1, ReplyCount: 1, LastReplyAt: 400})
+	assert.NoError(t, err)
+
+	// 按最后回复时间倒序
+	threads, err = store.GetThreads(channelID, channelType, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(threads))
+	assert.Equal(t, uint32(1), threads[0].RootMessageSeq)
+	assert.Equal(t, uint32(2), threads[0].ReplyCount)
+	assert.Equal(t, "u3", threads[0].LastReplyUID)
+	assert.Equal(t, uint32(2), threads[0].LastReplyMessageSeq)
+	assert.Equal(t, uint32(2), threads[1].RootMessageSeq)
+
+	threads, err = store.GetThreads(channelID, channelType, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(threads))
+	assert.Equal(t, int64(300), threads[0].LastReplyAt)
+}
+
+func testChannelPins(t *testing.T, store okstore.Store) {
+	channelID := "pinChannel"
+	channelType := uint8(2)
+
+	pins, err := store.GetChannelPins(channelID, channelType)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), pins.Version)
+	assert.Empty(t, pins.Pins)
+
+	pins.Version++
+	pins.Pins = append(pins.Pins, &okstore.Pin{MessageID: 1, MessageSeq: 1, UID: "u1", PinnedAt: 100})
+	err = store.SetChannelPins(channelID, channelType, pins)
+	assert.NoError(t, err)
+	pins.Version++
+	pins.Pins = append(pins.Pins, &okstore.Pin{MessageID: 2, MessageSeq: 2, UID: "u2", PinnedAt: 200})
+	err = store.SetChannelPins(channelID, channelType, pins)
+	assert.NoError(t, err)
+	pins.Pins[0].UID = "changed" // 保存后修改不影响已保存的数据
+
+	pins, err = store.GetChannelPins(channelID, channelType)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), pins.Version)
+	assert.Equal(t, 2, len(pins.Pins))
+	assert.Equal(t, "u1", pins.Pins[0].UID)
+	assert.Equal(t, uint32(2), pins.Pins[1].MessageSeq)
+	assert.Equal(t, int64(200), pins.Pins[1].PinnedAt)
+
+	pins, err = store.GetChannelPins("other", channelType)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), pins.Version)
+}
+
+func testMessagesOfUser(t *testing.T, store okstore.Store) {
+	cursor, err := store.GetMessageOfUserCursor("u1")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), cursor)
+
+	seqs, err := store.AppendMessagesOfUser("u1", newMessages(1, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2, 3}, seqs)
+
+	seqs, err = store.AppendMessagesOfUser("u2", newMessages(10, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1}, seqs)
+
+	msgs, err := store.SyncMessageOfUser("u1", 1, 10)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{1, 2, 3}, msgs)
+
+	msgs, err = store.SyncMessageOfUser("u1", 2, 1)
+	assert.NoError(t, err)
+	assertMessageSeqs(t, []uint32{2}, msgs)
+	assertMessage(t, msgs[0], 2, 2)
+
+	err = store.UpdateMessageOfUserCursorIfNeed("u1", 2)
+	assert.NoError(t, err)
+	cursor, err = store.GetMessageOfUserCursor("u1")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), cursor)
+
+	// 游标不会超过用户队列的最新消息序号
+	err = store.UpdateMessageOfUserCursorIfNeed("u1", 100)
+	assert.NoError(t, err)
+	cursor, err = store.GetMessageOfUserCursor("u1")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), cursor)
+}
+
+func testNotifyQueue(t *testing.T, store okstore.Store) {
+	err := store.AppendMessageOfNotifyQueue(newMessages(1, 3))
+	assert.NoError(t, err)
+
+	msgs, err := store.GetMessagesOfNotifyQueue(2)
+	assert.NoError(t, err)
+	assertMessageIDs(t, []int64{1, 2}, msgs)
+
+	err = store.RemoveMessagesOfNotifyQueue([]int64{1, 3})
+	assert.NoError(t, err)
+
+	msgs, err = store.GetMessagesOfNotifyQueue(10)
+	assert.NoError(t, err)
+	assertMessageIDs(t, []int64{2}, msgs)
+	assertMessage(t, msgs[0], 2, 0)
+}
+
+func testMessageExtras(t *testing.T, store okstore.Store) {
+	extras, err := store.GetMessageExtras("g1", okproto.ChannelTypeGroup, []uint32{1})
+	assert.NoError(t, err)
+	assert.Empty(t, extras)
+
+	err = store.AddOrUpdateMessageExtras("g1", okproto.ChannelTypeGroup, []*okstore.MessageExtra{
+		{MessageID: 1, MessageSeq: 1, Revoke: true, Revoker: "u1", Version: 1},
+		{MessageID: 2, MessageSeq: 2, EditVersion: 1, ContentEdit: []byte("edit"), Version: 1},
+	})
+	assert.NoError(t, err)
+	err = store.AddOrUpdateMessageExtras("g1", okproto.ChannelTypeGroup, []*okstore.MessageExtra{
+		{MessageID: 2, MessageSeq: 2, EditVersion: 2, ContentEdit: []byte("edit2"), Version: 2},
+	})
+	assert.NoError(t, err)
+
+	extras, err = store.GetMessageExtras("g1", okproto.ChannelTypeGroup, []uint32{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Len(t, extras, 2)
+	assert.True(t, extras[0].Revoke)
+	assert.Equal(t, "u1", extras[0].Revoker)
+	assert.Equal(t, uint32(2), extras[1].EditVersion)
+	assert.Equal(t, []byte("edit2"), extras[1].ContentEdit)
+	assert.Equal(t, int64(2), extras[1].Version)
+
+	for _, version := range []uint32{2, 1} {
+		err = store.AppendMessageEdit("g1", okproto.ChannelTypeGroup, &okstore.MessageEdit{
+			MessageID:   2,
+			MessageSeq:  2,
+			EditVersion: version,
+			Content:     []byte(fmt.Sprintf("edit%d", version)),
+			Editor:      "u1",
+		})
+		assert.NoError(t, err)
+	}
+	edits, err := store.GetMessageEdits("g1", okproto.ChannelTypeGroup, 2)
+	assert.NoError(t, err)
+	assert.Len(t, edits, 2)
+	assert.Equal(t, uint32(1), edits[0].EditVersion)
+	assert.Equal(t, []byte("edit1"), edits[0].Content)
+	assert.Equal(t, uint32(2), edits[1].EditVersion)
+
+	edits, err = store.GetMessageEdits("g1", okproto.ChannelTypeGroup, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, edits)
+}
+
+func testMessageDeleteMarkers(t *testing.T, store okstore.Store) {
+	markers, err := store.GetMessageDeleteMarkers("g1", okproto.ChannelTypeGroup, "u1")
+	assert.NoError(t, err)
+	assert.Empty(t, markers)
+
+	err = store.AddMessageDeleteMarker("g1", okproto.ChannelTypeGroup, &okstore.MessageDeleteMarker{StartMessageSeq: 2, EndMessageSeq: 4, Deleter: "admin"})
+	assert.NoError(t, err)
+	err = store.AddMessageDeleteMarker("g1", okproto.ChannelTypeGroup, &okstore.MessageDeleteMarker{UID: "u1", MessageIDs: []int64{10, 11}, Deleter: "u1"})
+	assert.NoError(t, err)
+	err = store.AddMessageDeleteMarker("g1", okproto.ChannelTypeGroup, &okstore.MessageDeleteMarker{UID: "u2", StartMessageSeq: 6, EndMessageSeq: 6, Deleter: "u2"})
+	assert.NoError(t, err)
+
+	markers, err = store.GetMessageDeleteMarkers("g1", okproto.ChannelTypeGroup, "")
+	assert.NoError(t, err)
+	assert.Len(t, markers, 1)
+	assert.Equal(t, "admin", markers[0].Deleter)
+
+	markers, err = store.GetMessageDeleteMarkers("g1", okproto.ChannelTypeGroup, "u1")
+	assert.NoError(t, err)
+	assert.Len(t, markers, 2)
+	assert.False(t, markers.Deleted(1, 1))
+	assert.True(t, markers.Deleted(2, 2))
+	assert.True(t, markers.Deleted(4, 4))
+	assert.True(t, markers.Deleted(0, 11))
+	assert.False(t, markers.Deleted(0, 4)) // 只按消息ID判断
+	assert.False(t, markers.Deleted(6, 6))
+
+	markers, err = store.GetMessageDeleteMarkers("g2", okproto.ChannelTypeGroup, "u1")
+	assert.NoError(t, err)
+	assert.Empty(t, markers)
+}
+
+func testMessageReceipt(t *testing.T, store okstore.Store) {
+	newSeqs, err := store.AddMessageReaders("g1", okproto.ChannelTypeGroup, "u2", []uint32{1, 2}, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2}, newSeqs)
+
+	newSeqs, err = store.AddMessageReaders("g1", okproto.ChannelTypeGroup, "u2", []uint32{2, 3}, 101)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{3}, newSeqs)
+
+	_, err = store.AddMessageReaders("g1", okproto.ChannelTypeGroup, "u1", []uint32{2}, 102)
+	assert.NoError(t, err)
+
+	readers, err := store.GetMessageReaders("g1", okproto.ChannelTypeGroup, 2)
+	assert.NoError(t, err)
+	assert.Len(t, readers, 2)
+	readedAtMap := map[string]int64{}
+	for _, reader := range readers {
+		assert.Equal(t, uint32(2), reader.MessageSeq)
+		readedAtMap[reader.UID] = reader.ReadedAt
+	}
+	assert.Equal(t, map[string]int64{"u1": 102, "u2": 100}, readedAtMap)
+
+	readedSeq, err := store.GetChannelReadedSeq("u1", "g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), readedSeq)
+
+	oldReadedSeq, err := store.UpdateChannelReadedSeqIfNeed("u1", "g1", okproto.ChannelTypeGroup, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), oldReadedSeq)
+
+	// 已读序号只会变大
+	oldReadedSeq, err = store.UpdateChannelReadedSeqIfNeed("u1", "g1", okproto.ChannelTypeGroup, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(5), oldReadedSeq)
+
+	readedSeq, err = store.GetChannelReadedSeq("u1", "g1", okproto.ChannelTypeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(5), readedSeq)
+}
+
+func testConversations(t *testing.T, store okstore.Store) {
+	conversations, err := store.GetConversations("u1")
+	assert.NoError(t, err)
+	assert.Empty(t, conversations)
+
+	err = store.AddOrUpdateConversations("u1", []*okstore.Conversation{
+		{UID: "u1", ChannelID: "u2", ChannelType: okproto.ChannelTypePerson, UnreadCount: 1, LastMsgSeq: 1, Timestamp: 100},
+		{UID: "u1", ChannelID: "g1", ChannelType: okproto.ChannelTypeGroup, UnreadCount: 2, LastMsgSeq: 5, Timestamp: 101},
+	})
+	assert.NoError(t, err)
+
+	err = store.AddOrUpdateConversations("u1", []*okstore.Conversation{
+		{UID: "u1", ChannelID: "u2", ChannelType: okproto.ChannelTypePerson, UnreadCount: 3, LastMsgSeq: 3, Timestamp: 102},
+	})
+	assert.NoError(t, err)
+
+	conversations, err = store.GetConversations("u1")
+	assert.NoError(t, err)
+	assert.Len(t, conversations, 2)
+
+	conversation, err := store.GetConversation("u1", "u2", okproto.ChannelTypePerson)
+	assert.NoError(t, err)
+	assert.NotNil(t, conversation)
+	assert.Equal(t, 3, conversation.UnreadCount)
+	assert.Equal(t, uint32(3), conversation.LastMsgSeq)
+	assert.Equal(t, int64(102), conversation.Timestamp)
+
+	conversation, err = store.GetConversation("u1", "g1", okproto.ChannelTypePerson)
+	assert.NoError(t, err)
+	assert.Nil(t, conversation)
+
+	conversations, err = store.GetConversations("u2")
+	assert.NoError(t, err)
+	assert.Empty(t, conversations)
+
+	err = store.DeleteConversation("u1", "u2", okproto.ChannelTypePerson)
+	assert.NoError(t, err)
+	conversation, err = store.GetConversation("u1", "u2", okproto.ChannelTypePerson)
+	assert.NoError(t, err)
+	assert.Nil(t, conversation)
+
+	conversations, err = store.GetConversations("u1")
+	assert.NoError(t, err)
+	assert.Len(t, conversations, 1)
+	assert.Equal(t, "g1", conversations[0].ChannelID)
+}
+
+func testSystemUIDs(t *testing.T, store okstore.Store) {
+	testGlobalList(t, store.AddSystemUIDs, store.RemoveSystemUIDs, store.GetSystemUIDs)
+}
+
+func testIPBlacklist(t *testing.T, store okstore.Store) {
+	testGlobalList(t, store.AddIPBlacklist, store.RemoveIPBlacklist, store.GetIPBlacklist)
+}
+
+func testAPIKeys(t *testing.T, store okstore.Store) {
+	apiKeys, err := store.GetAPIKeys()
+	assert.NoError(t, err)
+	assert.Empty(t, apiKeys)
+
+	err = store.AddOrUpdateAPIKey(&okstore.APIKey{ID: "k2", Name: "backend", Scopes: []string{"message"}, KeyHash: "h2"})
+	assert.NoError(t, err)
+	err = store.AddOrUpdateAPIKey(&okstore.APIKey{ID: "k1", Name: "monitor", Scopes: []string{"monitor"}, KeyHash: "h1"})
+	assert.NoError(t, err)
+	err = store.AddOrUpdateAPIKey(&okstore.APIKey{ID: "k2", Name: "backend", Scopes: []string{"message", "channel"}, KeyHash: "h2"})
+	assert.NoError(t, err)
+
+	apiKeys, err = store.GetAPIKeys()
+	assert.NoError(t, err)
+	assert.Len(t, apiKeys, 2)
+	assert.Equal(t, "k1", apiKeys[0].ID)
+	assert.Equal(t, "k2", apiKeys[1].ID)
+	assert.Equal(t, []string{"message", "channel"}, apiKeys[1].Scopes)
+	assert.True(t, apiKeys[1].HasScope("channel"))
+	assert.False(t, apiKeys[0].HasScope("channel"))
+
+	err = store.RemoveAPIKey("k1")
+	assert.NoError(t, err)
+	apiKeys, err = store.GetAPIKeys()
+	assert.NoError(t, err)
+	assert.Len(t, apiKeys, 1)
+	assert.Equal(t, "k2", apiKeys[0].ID)
+}
+
+func testInFlightMessages(t *testing.T, store okstore.Store) {
+	messages, err := store.GetInFlightMessages()
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+
+	err = store.AddOrUpdateInFlightMessages([]*okstore.InFlightMessage{
+		{UID: "u1", DeviceID: "d1", MessageID: 1, Data: []byte("m1"), CreatedAt: 100},
+		{UID: "u1", DeviceID: "d2", MessageID: 1, Data: []byte("m1"), CreatedAt: 100},
+		{UID: "u2", DeviceID: "d1", MessageID: 2, Data: []byte("m2"), CreatedAt: 100},
+	})
+	assert.NoError(t, err)
+	err = store.AddOrUpdateInFlightMessages([]*okstore.InFlightMessage{
+		{UID: "u1", DeviceID: "d1", MessageID: 1, RetryCount: 2, Data: []byte("m1"), CreatedAt: 100},
+	})
+	assert.NoError(t, err)
+	err = store.RemoveInFlightMessages([]*okstore.InFlightMessage{{UID: "u1", DeviceID: "d2", MessageID: 1}})
+	assert.NoError(t, err)
+
+	messages, err = store.GetInFlightMessages()
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2)
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].UID < messages[j].UID
+	})
+	assert.Equal(t, "d1", messages[0].DeviceID)
+	assert.Equal(t, 2, messages[0].RetryCount)
+	assert.Equal(t, []byte("m1"), messages[0].Data)
+	assert.Equal(t, int64(2), messages[1].MessageID)
+}
+
+func testGlobalList(t *testing.T, add func([]string) error, remove func([]string) error, get func() ([]string, error)) {
+	values, err := get()
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+
+	err = add([]string{"a", "b"})
+	assert.NoError(t, err)
+	// 重复添加会去重
+	err = add([]string{"b", "c"})
+	assert.NoError(t, err)
+
+	values, err = get()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, values)
+
+	err = remove([]string{"a", "d"})
+	assert.NoError(t, err)
+	values, err = get()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"b", "c"}, values)
+
+	err = remove([]string{"b", "c"})
+	assert.NoError(t, err)
+	values, err = get()
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+}
+
+func testStreams(t *testing.T, store okstore.Store) {
+	meta, err := store.GetStreamMeta("g1", okproto.ChannelTypeGroup, "stream1")
+	assert.NoError(t, err)
+	assert.Nil(t, meta)
+
+	err = store.SaveStreamMeta(&okstore.StreamMeta{
+		StreamNo:    "stream1",
+		MessageID:   1,
+		ChannelID:   "g1",
+		ChannelType: okproto.ChannelTypeGroup,
+		MessageSeq:  1,
+		StreamFlag:  okproto.StreamFlagStart,
+	})
+	assert.NoError(t, err)
+
+	meta, err = store.GetStreamMeta("g1", okproto.ChannelTypeGroup, "stream1")
+	assert.NoError(t, err)
+	assert.NotNil(t, meta)
+	assert.Equal(t, int64(1), meta.MessageID)
+	assert.Equal(t, uint32(1), meta.MessageSeq)
+	assert.Equal(t, okproto.StreamFlagStart, meta.StreamFlag)
+
+	items, err := store.GetStreamItems("g1", okproto.ChannelTypeGroup, "stream1")
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+
+	for i := 1; i <= 3; i++ {
+		item := &okstore.StreamItem{
+			ClientMsgNo: fmt.Sprintf("no%d", i),
+			Blob:        []byte(fmt.Sprintf("blob%d", i)),
+		}
+		streamSeq, err := store.AppendStreamItem("g1", okproto.ChannelTypeGroup, "stream1", item)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(i), streamSeq)
+		assert.Equal(t, uint32(i), item.StreamSeq)
+	}
+
+	items, err = store.GetStreamItems("g1", okproto.ChannelTypeGroup, "stream1")
+	assert.NoError(t, err)
+	assert.Len(t, items, 3)
+	for i, item := range items {
+		assert.Equal(t, uint32(i+1), item.StreamSeq)
+		assert.Equal(t, fmt.Sprintf("no%d", i+1), item.ClientMsgNo)
+		assert.Equal(t, []byte(fmt.Sprintf("blob%d", i+1)), item.Blob)
+	}
+
+	err = store.StreamEnd("g1", okproto.ChannelTypeGroup, "stream1")
+	assert.NoError(t, err)
+	meta, err = store.GetStreamMeta("g1", okproto.ChannelTypeGroup, "stream1")
+	assert.NoError(t, err)
+	assert.Equal(t, okproto.StreamFlagEnd, meta.StreamFlag)
+
+	// 其他流不受影响
+	items, err = store.GetStreamItems("g1", okproto.ChannelTypeGroup, "stream2")
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+// newMessages 生成count条消息，消息ID从startMessageID开始，内容为 msg+消息ID
+func newMessages(startMessageID int64, count int) []okstore.Message {
+	msgs := make([]okstore.Message, 0, count)
+	for i := 0; i < count; i++ {
+		messageID := startMessageID + int64(i)
+		msgs = append(msgs, &Message{
+			MessageID: messageID,
+			Payload:   []byte(fmt.Sprintf("msg%d", messageID)),
+		})
+	}
+	return msgs
+}
+
+// assertMessage messageSeq为0表示不校验序号
+func assertMessage(t *testing.T, msg okstore.Message, messageID int64, messageSeq uint32) {
+	assert.NotNil(t, msg)
+	if msg == nil {
+		return
+	}
+	assert.Equal(t, messageID, msg.GetMessageID())
+	if messageSeq != 0 {
+		assert.Equal(t, messageSeq, msg.GetSeq())
+	}
+	assert.Equal(t, []byte(fmt.Sprintf("msg%d", messageID)), msg.(*Message).Payload)
+}
+
+func assertMessageSeqs(t *testing.T, expect []uint32, msgs []okstore.Message) {
+	seqs := make([]uint32, 0, len(msgs))
+	for _, msg := range msgs {
+		seqs = append(seqs, msg.GetSeq())
+	}
+	assert.Equal(t, expect, seqs)
+}
+
+func assertMessageIDs(t *testing.T, expect []int64, msgs []okstore.Message) {
+	messageIDs := make([]int64, 0, len(msgs))
+	for _, msg := range msgs {
+		messageIDs = append(messageIDs, msg.GetMessageID())
+	}
+	assert.Equal(t, expect, messageIDs)
+}
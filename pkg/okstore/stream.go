@@ -128,8 +128,11 @@ func (m *Stream) saveMeta(meta *StreamMeta) error {
 	data := meta.Encode()
 
 	metaIO := m.getMetaIO()
-	metaIO.Seek(0, io.SeekStart)
-	_, err := metaIO.Write(data)
+	err := metaIO.Truncate(0) // 覆盖写，避免新数据比旧数据短时残留旧数据
+	if err != nil {
+		return err
+	}
+	_, err = metaIO.WriteAt(data, 0)
 	return err
 }
 
@@ -166,7 +169,7 @@ func (m *Stream) streamEnd() error {
 func (m *Stream) getMetaIO() *os.File {
 	if m.streamMetaIO == nil {
 		var err error
-		m.streamMetaIO, err = os.OpenFile(m.streamMetaPath(), os.O_RDWR|os.O_CREATE|os.O_TRUNC, FileDefaultMode)
+		m.streamMetaIO, err = os.OpenFile(m.streamMetaPath(), os.O_RDWR|os.O_CREATE, FileDefaultMode)
 		if err != nil {
 			panic(err)
 		}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -88,7 +89,7 @@ func (t *topic) appendMessages(msgs []Message) ([]uint32, int, error) {
 
 	//	if  roll new segment
 	if lastSegment.index.IsFull() || int64(lastSegment.position) > t.cfg.SegmentMaxBytes {
-		t.roll(msgs[len(msgs)-1]) // roll new segment
+		lastSegment = t.roll(preLastMsgSeq) // roll new segment 新segment的baseMessageSeq为上一个segment的最后一条消息序号
 	}
 
 	// append message to segment
@@ -183,6 +184,18 @@ func (t *topic) readLastMessages(limit uint64, callback func(msg Message) error)
 
 // ReadLogs ReadLogs
 func (t *topic) readMessages(messageSeq uint32, limit uint64, callback func(msg Message) error) error {
+	firstBaseMessageSeq := t.getFirstBaseMessageSeq()
+	if firstBaseMessageSeq > 0 && messageSeq <= firstBaseMessageSeq { // 前面的segment已被删除，跳过已删除的消息
+		if messageSeq == 0 { // 消息序号从1开始，从0读取等同于从1读取
+			messageSeq = 1
+		}
+		skip := uint64(firstBaseMessageSeq - messageSeq + 1)
+		if limit <= skip {
+			return nil
+		}
+		limit -= skip
+		messageSeq = firstBaseMessageSeq + 1
+	}
 	baseMessageSeq, err := t.calcBaseMessageSeq(messageSeq)
 	if err != nil {
 		return err
@@ -231,6 +244,9 @@ func (t *topic) readMessages(messageSeq uint32, limit uint64, callback func(msg
 
 // readMessageAt readMessageAt
 func (t *topic) readMessageAt(messageSeq uint32) (Message, error) {
+	if messageSeq <= t.getFirstBaseMessageSeq() { // 消息所在的segment已被删除
+		return nil, ErrorNotData
+	}
 	baseMessageSeq, err := t.calcBaseMessageSeq(messageSeq)
 	if err != nil {
 		return nil, err
@@ -239,15 +255,17 @@ func (t *topic) readMessageAt(messageSeq uint32) (Message, error) {
 	return segment.readAt(messageSeq)
 }
 
-func (t *topic) roll(m Message) {
+func (t *topic) roll(baseMessageSeq uint32) *segment {
 	lastSegment := t.getActiveSegment()
 	if lastSegment != nil {
 		segmentCache.Remove(t.getSegmentCacheKey(t.lastBaseMessageSeq))
 	}
-	t.lastBaseMessageSeq = m.GetSeq()
-	t.segments = append(t.segments, m.GetSeq())
+	t.lastBaseMessageSeq = baseMessageSeq
+	t.getSegmentLock.Lock()
+	t.segments = append(t.segments, baseMessageSeq)
+	t.getSegmentLock.Unlock()
 
-	t.resetActiveSegment(t.lastBaseMessageSeq)
+	return t.getSegment(t.lastBaseMessageSeq, SegmentModeAll)
 }
 
 func (t *topic) nextBaseMessageSeq(baseMessageSeq uint32) int64 {
@@ -321,13 +339,16 @@ func (t *topic) resetActiveSegment(baseMessageSeq uint32) *segment {
 }
 
 func (t *topic) getSegmentCacheKey(baseMessageSeq uint32) string {
-	return fmt.Sprintf("%s-%d", t.name, baseMessageSeq)
+	return fmt.Sprintf("%s-%d", t.topicDir, baseMessageSeq) // segmentCache是全局的，用数据目录区分不同的存储
 }
 
 // get all segment base messageSeq
 func (t *topic) getAllSegmentBaseMessageSeq() []uint32 {
-	files, err := ioutil.ReadDir(t.topicDir)
+	files, err := ioutil.ReadDir(t.segmentDir())
 	if err != nil {
+		if os.IsNotExist(err) {
+			return make([]uint32, 0)
+		}
 		t.Error("read dir fail!", zap.String("topicDir", t.topicDir))
 		panic(err)
 	}
@@ -356,6 +377,181 @@ func (t *topic) sortSegmentBaseMessageSeqs(baseMessageSeqs []uint32) []uint32 {
 	return baseMessageSeqs
 }
 
+// removeExpiredSegments 删除消息全部过期的segment
+// 只删除最前面连续过期的segment（保证消息序号连续），正在写入的segment不删除
+func (t *topic) removeExpiredSegments(now int64) (int, error) {
+	result, err := t.compact(RetentionPolicy{}, now)
+	return result.Segments, err
+}
+
+// compact 删除最前面超出保留策略（或消息全部过期）的segment，正在写入的segment不删除
+// 超出保留策略的segment如果配置了归档目录则移动到归档目录，过期的消息直接删除不归档
+func (t *topic) compact(policy RetentionPolicy, now int64) (*CompactResult, error) {
+	result := &CompactResult{Topics: 1}
+	for {
+		t.getSegmentLock.RLock()
+		if len(t.segments) <= 1 {
+			t.getSegmentLock.RUnlock()
+			break
+		}
+		baseMessageSeq := t.segments[0]
+		nextBaseMessageSeq := t.segments[1]
+		t.getSegmentLock.RUnlock()
+
+		outOfRetention, err := t.firstSegmentOutOfRetention(policy, now)
+		if err != nil {
+			return result, err
+		}
+		if !outOfRetention {
+			expired, err := t.segmentExpired(baseMessageSeq, now)
+			if err != nil {
+				return result, err
+			}
+			if !expired {
+				break
+			}
+		}
+		size, err := t.segmentSize(baseMessageSeq)
+		if err != nil {
+			return result, err
+		}
+		if err = t.removeSegment(baseMessageSeq, outOfRetention && t.cfg.ArchiveDir != ""); err != nil {
+			return result, err
+		}
+		result.Segments++
+		result.Messages += int(nextBaseMessageSeq - baseMessageSeq)
+		result.Bytes += size
+	}
+	return result, nil
+}
+
+// firstSegmentOutOfRetention 最前面的segment是否超出保留策略
+// 按消息数量和大小判断时，删除后剩下的消息仍然满足保留策略才删除
+func (t *topic) firstSegmentOutOfRetention(policy RetentionPolicy, now int64) (bool, error) {
+	if policy.IsZero() {
+		return false, nil
+	}
+	t.getSegmentLock.RLock()
+	segments := append(make([]uint32, 0, len(t.segments)), t.segments...)
+	t.getSegmentLock.RUnlock()
+	if len(segments) <= 1 {
+		return false, nil
+	}
+	if policy.MaxMessages > 0 && t.lastMsgSeq.Load()-segments[1] >= policy.MaxMessages {
+		return true, nil
+	}
+	if policy.MaxAge > 0 {
+		info, err := os.Stat(t.segmentPath(segments[0]))
+		if err != nil {
+			return false, err
+		}
+		if info.ModTime().Unix() < now-policy.MaxAge { // segment最后一次写入的时间超过了保留时间
+			return true, nil
+		}
+	}
+	if policy.MaxBytes > 0 {
+		var totalSize, firstSize int64
+		for i, baseMessageSeq := range segments {
+			size, err := t.segmentSize(baseMessageSeq)
+			if err != nil {
+				return false, err
+			}
+			if i == 0 {
+				firstSize = size
+			}
+			totalSize += size
+		}
+		if totalSize-firstSize >= policy.MaxBytes {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// segmentExpired segment里的消息是否全部过期
+func (t *topic) segmentExpired(baseMessageSeq uint32, now int64) (bool, error) {
+	errNotExpired := errors.New("not expired")
+	seg := t.getSegment(baseMessageSeq, SegmentModeAll)
+	err := seg.readMessagesAtPosition(0, math.MaxUint64, func(m Message) error {
+		if !MessageExpired(m, now) {
+			return errNotExpired
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, errNotExpired) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// removeSegment 删除最前面的segment archive为true则移动到归档目录（归档目录需要和数据目录在同一个文件系统）
+func (t *topic) removeSegment(baseMessageSeq uint32, archive bool) error {
+	t.appendLock.Lock()
+	defer t.appendLock.Unlock()
+	t.getSegmentLock.Lock()
+	defer t.getSegmentLock.Unlock()
+
+	if len(t.segments) <= 1 || t.segments[0] != baseMessageSeq {
+		return nil
+	}
+	var archiveDir string
+	if archive {
+		archiveDir = filepath.Join(t.cfg.ArchiveDir, fmt.Sprintf("%d", t.slot), "topics", t.name, "logs")
+		if err := os.MkdirAll(archiveDir, FileDefaultMode); err != nil {
+			return err
+		}
+	}
+	t.segments = t.segments[1:]
+	segmentCache.Remove(t.getSegmentCacheKey(baseMessageSeq)) // 移除会触发segment close
+
+	for _, suffix := range []string{segmentSuffix, indexSuffix} {
+		fileName := fmt.Sprintf(fileFormat, baseMessageSeq, suffix)
+		var err error
+		if archive {
+			err = os.Rename(filepath.Join(t.segmentDir(), fileName), filepath.Join(archiveDir, fileName))
+		} else {
+			err = os.Remove(filepath.Join(t.segmentDir(), fileName))
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	t.Info("删除segment", zap.Uint32("baseMessageSeq", baseMessageSeq), zap.Bool("archive", archive))
+	return nil
+}
+
+func (t *topic) segmentSize(baseMessageSeq uint32) (int64, error) {
+	info, err := os.Stat(t.segmentPath(baseMessageSeq))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (t *topic) segmentPath(baseMessageSeq uint32) string {
+	return filepath.Join(t.segmentDir(), fmt.Sprintf(fileFormat, baseMessageSeq, segmentSuffix))
+}
+
+// getFirstBaseMessageSeq 最早的segment的baseMessageSeq，大于0说明之前的segment已被删除
+func (t *topic) getFirstBaseMessageSeq() uint32 {
+	t.getSegmentLock.RLock()
+	defer t.getSegmentLock.RUnlock()
+	if len(t.segments) == 0 {
+		return 0
+	}
+	return t.segments[0]
+}
+
+func (t *topic) segmentDir() string {
+	return filepath.Join(t.topicDir, "logs")
+}
+
 func (t *topic) nextMsgSeq() uint32 {
 	return t.lastMsgSeq.Inc()
 }
@@ -1,9 +1,19 @@
 package okutil
 
-import "hash/crc32"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+)
 
 // HashCrc32 通过字符串获取32位数字
 func HashCrc32(str string) uint32 {
 
 	return crc32.ChecksumIEEE([]byte(str))
 }
+
+// SHA256 获取字符串的sha256（十六进制）
+func SHA256(str string) string {
+	sum := sha256.Sum256([]byte(str))
+	return hex.EncodeToString(sum[:])
+}
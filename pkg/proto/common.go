@@ -113,6 +113,8 @@ const (
 	DISCONNECT                  // 请求断开连接
 	SUB                         // 订阅
 	SUBACK                      // 订阅确认
+	EVENT                       // 事件(c2s/s2c)
+	EVENTACK                    // 事件确认(s2c)
 )
 
 func (p FrameType) String() string {
@@ -139,6 +141,10 @@ func (p FrameType) String() string {
 		return "SUB"
 	case SUBACK:
 		return "SUBACK"
+	case EVENT:
+		return "EVENT"
+	case EVENTACK:
+		return "EVENTACK"
 	}
 	return fmt.Sprintf("UNKNOWN[%d]", p)
 }
@@ -190,6 +196,17 @@ const (
 	ReasonClientKeyIsEmpty      // clientKey 是空的
 	ReasonRateLimit             // 速率限制
 	ReasonNotSupportChannelType // 不支持的频道类型
+	ReasonMessageNotExist       // 消息不存在
+	ReasonRevokeTimeout         // 超过了可撤回的时间
+	ReasonNoPermission          // 没有操作权限
+	ReasonNotSupportEvent       // 不支持的事件类型
+	ReasonEventDataError        // 事件数据错误
+	ReasonMessageRevoked        // 消息已撤回
+	ReasonSubscriptionLimit     // 订阅数量超过限制
+	ReasonPinLimit              // 置顶数量超过限制
+	ReasonChannelMuted          // 频道全员禁言
+	ReasonMemberMuted           // 成员被禁言
+	ReasonSlowMode              // 慢速模式下发送太频繁
 )
 
 func (r ReasonCode) String() string {
@@ -232,6 +249,28 @@ func (r ReasonCode) String() string {
 		return "ReasonClientKeyIsEmpty"
 	case ReasonRateLimit:
 		return "ReasonRateLimit"
+	case ReasonMessageNotExist:
+		return "ReasonMessageNotExist"
+	case ReasonRevokeTimeout:
+		return "ReasonRevokeTimeout"
+	case ReasonNoPermission:
+		return "ReasonNoPermission"
+	case ReasonNotSupportEvent:
+		return "ReasonNotSupportEvent"
+	case ReasonEventDataError:
+		return "ReasonEventDataError"
+	case ReasonMessageRevoked:
+		return "ReasonMessageRevoked"
+	case ReasonSubscriptionLimit:
+		return "ReasonSubscriptionLimit"
+	case ReasonPinLimit:
+		return "ReasonPinLimit"
+	case ReasonChannelMuted:
+		return "ReasonChannelMuted"
+	case ReasonMemberMuted:
+		return "ReasonMemberMuted"
+	case ReasonSlowMode:
+		return "ReasonSlowMode"
 	}
 	return fmt.Sprintf("UNKNOWN[%d]", r)
 }
@@ -330,6 +369,7 @@ const (
 	StreamSeqByteSize       = 4
 	StreamFlagByteSize      = 1
 	ExpireByteSize          = 4
+	EventTimestampByteSize  = 8
 )
 
 const (
@@ -0,0 +1,74 @@
+package proto
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// EventMinVersion 支持EVENT包的最低协议版本，低于此版本的客户端不会收到事件
+const EventMinVersion = 4
+
+// EventPacket 事件包（撤回通知等不需要存储的事件，c2s和s2c都可以使用）
+type EventPacket struct {
+	Framer
+	ID        string // 事件ID（c2s的时候由客户端生成，eventack会原样返回）
+	Type      string // 事件类型
+	Timestamp int64  // 事件时间（毫秒）
+	Data      []byte // 事件数据（一般为json）
+}
+
+// GetFrameType 包类型
+func (e *EventPacket) GetFrameType() FrameType {
+	return EVENT
+}
+
+func (e *EventPacket) String() string {
+	return fmt.Sprintf("ID:%s Type:%s Timestamp:%d Data:%s", e.ID, e.Type, e.Timestamp, string(e.Data))
+}
+
+func decodeEvent(frame Frame, data []byte, version uint8) (Frame, error) {
+	dec := NewDecoder(data)
+	eventPacket := &EventPacket{}
+	eventPacket.Framer = frame.(Framer)
+
+	var err error
+	// 事件ID
+	if eventPacket.ID, err = dec.String(); err != nil {
+		return nil, errors.Wrap(err, "解码ID失败！")
+	}
+	// 事件类型
+	if eventPacket.Type, err = dec.String(); err != nil {
+		return nil, errors.Wrap(err, "解码Type失败！")
+	}
+	// 事件时间
+	if eventPacket.Timestamp, err = dec.Int64(); err != nil {
+		return nil, errors.Wrap(err, "解码Timestamp失败！")
+	}
+	// 事件数据
+	if eventPacket.Data, err = dec.BinaryAll(); err != nil {
+		return nil, errors.Wrap(err, "解码Data失败！")
+	}
+	return eventPacket, nil
+}
+
+func encodeEvent(eventPacket *EventPacket, enc *Encoder, version uint8) error {
+	// 事件ID
+	enc.WriteString(eventPacket.ID)
+	// 事件类型
+	enc.WriteString(eventPacket.Type)
+	// 事件时间
+	enc.WriteInt64(eventPacket.Timestamp)
+	// 事件数据
+	enc.WriteBytes(eventPacket.Data)
+	return nil
+}
+
+func encodeEventSize(packet *EventPacket, version uint8) int {
+	var size = 0
+	size += (len(packet.ID) + StringFixLenByteSize)
+	size += (len(packet.Type) + StringFixLenByteSize)
+	size += EventTimestampByteSize
+	size += len(packet.Data)
+	return size
+}
@@ -0,0 +1,33 @@
+package proto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventEncodeAndDecode(t *testing.T) {
+	packet := &EventPacket{
+		ID:        "event1",
+		Type:      "message.revoke",
+		Timestamp: 1234567890123,
+		Data:      []byte(`{"message_seq":1}`),
+	}
+
+	codec := New()
+	// 编码
+	packetBytes, err := codec.EncodeFrame(packet, LatestVersion)
+	assert.NoError(t, err)
+
+	// 解码
+	resultPacket, _, err := codec.DecodeFrame(packetBytes, LatestVersion)
+	assert.NoError(t, err)
+	resultEventPacket, ok := resultPacket.(*EventPacket)
+	assert.Equal(t, true, ok)
+
+	// 比较
+	assert.Equal(t, packet.ID, resultEventPacket.ID)
+	assert.Equal(t, packet.Type, resultEventPacket.Type)
+	assert.Equal(t, packet.Timestamp, resultEventPacket.Timestamp)
+	assert.Equal(t, packet.Data, resultEventPacket.Data)
+}
@@ -0,0 +1,65 @@
+package proto
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// EventackPacket 事件回执包（服务端对客户端发起的事件进行回执）
+type EventackPacket struct {
+	Framer
+	ID         string     // 事件ID
+	Type       string     // 事件类型
+	ReasonCode ReasonCode // 原因码
+}
+
+// GetFrameType 包类型
+func (e *EventackPacket) GetFrameType() FrameType {
+	return EVENTACK
+}
+
+func (e *EventackPacket) String() string {
+	return fmt.Sprintf("ID:%s Type:%s ReasonCode:%s", e.ID, e.Type, e.ReasonCode.String())
+}
+
+func decodeEventack(frame Frame, data []byte, version uint8) (Frame, error) {
+	dec := NewDecoder(data)
+	eventackPacket := &EventackPacket{}
+	eventackPacket.Framer = frame.(Framer)
+
+	var err error
+	// 事件ID
+	if eventackPacket.ID, err = dec.String(); err != nil {
+		return nil, errors.Wrap(err, "解码ID失败！")
+	}
+	// 事件类型
+	if eventackPacket.Type, err = dec.String(); err != nil {
+		return nil, errors.Wrap(err, "解码Type失败！")
+	}
+	// 原因码
+	var reasonCode uint8
+	if reasonCode, err = dec.Uint8(); err != nil {
+		return nil, errors.Wrap(err, "解码ReasonCode失败！")
+	}
+	eventackPacket.ReasonCode = ReasonCode(reasonCode)
+	return eventackPacket, nil
+}
+
+func encodeEventack(eventackPacket *EventackPacket, enc *Encoder, version uint8) error {
+	// 事件ID
+	enc.WriteString(eventackPacket.ID)
+	// 事件类型
+	enc.WriteString(eventackPacket.Type)
+	// 原因码
+	enc.WriteUint8(eventackPacket.ReasonCode.Byte())
+	return nil
+}
+
+func encodeEventackSize(packet *EventackPacket, version uint8) int {
+	var size = 0
+	size += (len(packet.ID) + StringFixLenByteSize)
+	size += (len(packet.Type) + StringFixLenByteSize)
+	size += ReasonCodeByteSize
+	return size
+}
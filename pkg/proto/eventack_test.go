@@ -0,0 +1,31 @@
+package proto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventackEncodeAndDecode(t *testing.T) {
+	packet := &EventackPacket{
+		ID:         "event1",
+		Type:       "message.revoke",
+		ReasonCode: ReasonSuccess,
+	}
+
+	codec := New()
+	// 编码
+	packetBytes, err := codec.EncodeFrame(packet, LatestVersion)
+	assert.NoError(t, err)
+
+	// 解码
+	resultPacket, _, err := codec.DecodeFrame(packetBytes, LatestVersion)
+	assert.NoError(t, err)
+	resultEventackPacket, ok := resultPacket.(*EventackPacket)
+	assert.Equal(t, true, ok)
+
+	// 比较
+	assert.Equal(t, packet.ID, resultEventackPacket.ID)
+	assert.Equal(t, packet.Type, resultEventackPacket.Type)
+	assert.Equal(t, packet.ReasonCode, resultEventackPacket.ReasonCode)
+}
@@ -27,7 +27,7 @@ type Proto struct {
 }
 
 // LatestVersion 最新版本
-const LatestVersion = 3
+const LatestVersion = 4
 
 // MaxRemaingLength 最大剩余长度 // 1<<28 - 1
 const MaxRemaingLength uint32 = 1024 * 1024
@@ -53,6 +53,8 @@ var packetDecodeMap = map[FrameType]PacketDecodeFunc{
 	DISCONNECT: decodeDisConnect,
 	SUB:        decodeSub,
 	SUBACK:     decodeSuback,
+	EVENT:      decodeEvent,
+	EVENTACK:   decodeEventack,
 }
 
 // var packetEncodeMap = map[PacketType]PacketEncodeFunc{
@@ -190,6 +192,14 @@ func (l *Proto) EncodeFrame(frame Frame, version uint8) ([]byte, error) {
 		packet := frame.(*SubackPacket)
 		l.encodeFrame(packet, enc, uint32(encodeSubackSize(packet, version)))
 		err = encodeSuback(packet, enc, version)
+	case EVENT:
+		packet := frame.(*EventPacket)
+		l.encodeFrame(packet, enc, uint32(encodeEventSize(packet, version)))
+		err = encodeEvent(packet, enc, version)
+	case EVENTACK:
+		packet := frame.(*EventackPacket)
+		l.encodeFrame(packet, enc, uint32(encodeEventackSize(packet, version)))
+		err = encodeEventack(packet, enc, version)
 	}
 	if err != nil {
 		return nil, err
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket 令牌桶 每秒生成rate个令牌，最多存burst个令牌，每次请求消耗一个令牌
+type Bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket NewBucket 初始令牌是满的 burst小于1则按1计算
+func NewBucket(rate float64, burst int, now time.Time) *Bucket {
+	b := &Bucket{last: now}
+	b.SetLimit(rate, burst)
+	b.tokens = b.burst
+	return b
+}
+
+// SetLimit 修改令牌生成速率和容量（已有的令牌不超过新的容量）
+func (b *Bucket) SetLimit(rate float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+	b.rate = rate
+	b.burst = float64(burst)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// Allow 消耗一个令牌，没有令牌返回false
+func (b *Bucket) Allow(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Full 令牌是否已满（满了的令牌桶和新建的令牌桶没有区别，可以回收）
+func (b *Bucket) Full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// Limiter 按key限流，每个key一个令牌桶
+type Limiter struct {
+	buckets map[string]*Bucket
+	mu      sync.Mutex
+	nowFnc  func() time.Time
+}
+
+// NewLimiter NewLimiter
+func NewLimiter() *Limiter {
+	return &Limiter{
+		buckets: map[string]*Bucket{},
+		nowFnc:  time.Now,
+	}
+}
+
+// Allow key是否允许通过 rate小于等于0表示不限制
+// 同一个key的rate或burst变了（比如修改了配置）则按新的限制计算
+func (l *Limiter) Allow(key string, rate float64, burst int) bool {
+	if rate <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.nowFnc()
+	bucket := l.buckets[key]
+	if bucket == nil {
+		bucket = NewBucket(rate, burst, now)
+		l.buckets[key] = bucket
+	} else {
+		bucket.SetLimit(rate, burst)
+	}
+	return bucket.Allow(now)
+}
+
+// Remove 移除key的令牌桶（下次请求按满令牌重新计算）
+func (l *Limiter) Remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, key)
+}
+
+// Clean 清理令牌已满的令牌桶，返回清理的数量
+func (l *Limiter) Clean() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.nowFnc()
+	count := 0
+	for key, bucket := range l.buckets {
+		if bucket.Full(now) {
+			delete(l.buckets, key)
+			count++
+		}
+	}
+	return count
+}
+
+// Len 令牌桶数量
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(2, 3, now)
+	assert.True(t, b.Allow(now))
+	assert.True(t, b.Allow(now))
+	assert.True(t, b.Allow(now))
+	assert.False(t, b.Allow(now))
+
+	// 每秒2个令牌，500毫秒生成1个
+	now = now.Add(time.Millisecond * 500)
+	assert.True(t, b.Allow(now))
+	assert.False(t, b.Allow(now))
+
+	// 不会超过容量
+	now = now.Add(time.Hour)
+	assert.True(t, b.Full(now))
+	for i := 0; i < 3; i++ {
+		assert.True(t, b.Allow(now))
+	}
+	assert.False(t, b.Allow(now))
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter()
+	l.nowFnc = func() time.Time { return now }
+
+	assert.True(t, l.Allow("u1", 1, 2))
+	assert.True(t, l.Allow("u1", 1, 2))
+	assert.False(t, l.Allow("u1", 1, 2))
+	assert.True(t, l.Allow("u2", 1, 2))
+
+	// 不限制的不创建令牌桶
+	assert.True(t, l.Allow("u3", 0, 0))
+	assert.Equal(t, 2, l.Len())
+
+	// 每秒生成1个令牌
+	now = now.Add(time.Second)
+	assert.True(t, l.Allow("u1", 1, 2))
+	assert.False(t, l.Allow("u1", 1, 2))
+
+	l.Remove("u1")
+	assert.True(t, l.Allow("u1", 1, 2))
+
+	// 令牌满了的令牌桶会被清理
+	now = now.Add(time.Second * 2)
+	assert.Equal(t, 2, l.Clean())
+	assert.Equal(t, 0, l.Len())
+}